@@ -15,10 +15,17 @@ func addCmd() *cobra.Command {
 useѕ to uniquely identify a user, because <user-name> need not be unique.`,
 	}
 
+	var templatePath string
 	var addOrgCmd = cobra.Command{
 		Aliases: []string{"o"},
 		Use:     "org <organization>",
 		Short:   "Creates a new organization",
+		Long: `Creates a new organization applying the template of the configuration, the
+entries prefixed with "org.template.", and the template file given with
+--template over it.  Templates have the configuration file format, their
+settings are stored in the organization configuration, like "quota.size" which
+overrides the server quota, and the ones prefixed with "user." are copied
+without the prefix to the configuration of every new user.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 1 {
 				if err := cmd.Usage(); err != nil {
@@ -35,11 +42,22 @@ useѕ to uniquely identify a user, because <user-name> need not be unique.`,
 				return err
 			}
 
-			org, err := repository.NewOrg(orgName)
+			var template repo.OrgTemplate
+			if templatePath != "" {
+				if template, err = repo.LoadOrgTemplate(templatePath); err != nil {
+					return err
+				}
+			}
+
+			org, err := repository.NewOrgFromTemplate(orgName, template)
 			if err != nil {
 				return err
 			}
 
+			if jsonMode(cmd) {
+				return printResult(orgResult{Org: org.Name})
+			}
+
 			log.Infof("created organization %q", org.Name)
 
 			return nil
@@ -71,6 +89,10 @@ useѕ to uniquely identify a user, because <user-name> need not be unique.`,
 				return err
 			}
 
+			if jsonMode(cmd) {
+				return printResult(userResult{Org: user.Org.Name, Name: user.Name, Key: user.Key})
+			}
+
 			log.Infof("New user key: %v", user.Key)
 			log.Infof("Created user %q for organization %q", user.Name, user.Org.Name)
 
@@ -78,6 +100,8 @@ useѕ to uniquely identify a user, because <user-name> need not be unique.`,
 		},
 	}
 
+	addOrgCmd.Flags().StringVar(&templatePath, "template", "", "Template file with the organization settings")
+
 	addCmd.AddCommand(&addOrgCmd)
 	addCmd.AddCommand(&addUserCmd)
 
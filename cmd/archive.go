@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+func archiveCmd() *cobra.Command {
+	var archiveCmd = cobra.Command{
+		Use:   "archive",
+		Short: "Inspects the archives of the expired tasks.",
+		Long: `With "retention.archive = true", the retention moves the expired tasks to
+per user archives instead of dropping them.  The syncs only read them when the
+client syncs from a sync key older than the tasks archived.`,
+	}
+
+	var statusCmd = cobra.Command{
+		Use:   "status [organization]",
+		Short: "Shows the transactions and archives sizes of the users and groups",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("at most one organization name expected")
+			}
+
+			var orgName string
+			if len(args) == 1 {
+				orgName = args[0]
+			}
+
+			usages, err := repo.ArchiveStatus(cmd.Flag(dataFlag).Value.String(), orgName)
+			if err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(usages)
+			}
+			return printArchiveStatus(os.Stdout, usages)
+		},
+	}
+
+	archiveCmd.AddCommand(&statusCmd)
+
+	return &archiveCmd
+}
+
+func printArchiveStatus(w io.Writer, usages []repo.ArchiveUsage) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ORG\tUSER\tGROUP\tTX\tARCHIVE")
+
+	var tx, archived int64
+	for _, u := range usages {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\n", u.Org, u.User, u.Group, u.Tx, u.Archive)
+		tx, archived = tx+u.Tx, archived+u.Archive
+	}
+	fmt.Fprintf(tw, "TOTAL\t\t\t%d\t%d\n", tx, archived)
+
+	return tw.Flush()
+}
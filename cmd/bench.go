@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task"
+	"github.com/szaffarano/gotas/task/transport"
+)
+
+func benchCmd() *cobra.Command {
+	var credentials, pattern string
+	var caCert, clientCert, clientKey string
+	cfg := task.BenchConfig{}
+
+	var benchCmd = cobra.Command{
+		Use:   "bench <host:port>",
+		Short: "Load tests a server with synthetic clients.",
+		Long: `Runs concurrent synthetic clients syncing new tasks as the given user, and
+reports the throughput and the latency percentiles.  Every sync opens a new
+connection, as taskwarrior does.  The client certificate configured in the
+data directory is used unless overridden by the flags, and an https:// address
+tunnels the syncs over HTTPS.  The tasks are stored, so use a dedicated user.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("server address expected")
+			}
+
+			var err error
+			if cfg.Credentials, err = task.ParseCredentials(credentials); err != nil {
+				return err
+			}
+			if cfg.Pattern, err = task.ParseBenchPattern(pattern); err != nil {
+				return err
+			}
+			if cfg.Clients < 1 || cfg.Syncs < 1 || cfg.Tasks < 0 || cfg.TaskSize < 0 {
+				return fmt.Errorf("positive number of clients and syncs expected")
+			}
+
+			clientCfg := transport.ClientConfig{CaCert: caCert, Cert: clientCert, Key: clientKey, Address: args[0]}
+			loadClientConfig(cmd, &clientCfg)
+
+			log.Infof("Running %d clients, %d syncs each, against %s...", cfg.Clients, cfg.Syncs, clientCfg.Address)
+			result := task.Bench(func() (io.ReadWriteCloser, error) {
+				return transport.Dial(clientCfg)
+			}, cfg)
+
+			if jsonMode(cmd) {
+				return printResult(result)
+			}
+
+			codes := make([]string, 0, len(result.Codes))
+			for code, count := range result.Codes {
+				codes = append(codes, fmt.Sprintf("%s=%d", code, count))
+			}
+			sort.Strings(codes)
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "Syncs\t%d\n", result.Syncs)
+			fmt.Fprintf(tw, "Errors\t%d\n", result.Errors)
+			fmt.Fprintf(tw, "Codes\t%s\n", strings.Join(codes, " "))
+			fmt.Fprintf(tw, "Duration\t%s\n", result.Duration)
+			fmt.Fprintf(tw, "Throughput\t%.2f syncs/s\n", result.Throughput)
+			fmt.Fprintf(tw, "Average latency\t%s\n", result.AvgLatency)
+			fmt.Fprintf(tw, "p50 latency\t%s\n", result.P50Latency)
+			fmt.Fprintf(tw, "p95 latency\t%s\n", result.P95Latency)
+			fmt.Fprintf(tw, "p99 latency\t%s\n", result.P99Latency)
+			return tw.Flush()
+		},
+	}
+	benchCmd.Flags().StringVar(&credentials, "credentials", "", "User credentials, org/user/key")
+	benchCmd.Flags().IntVar(&cfg.Clients, "clients", 10, "Number of concurrent clients")
+	benchCmd.Flags().IntVar(&cfg.Syncs, "syncs", 10, "Number of syncs of every client")
+	benchCmd.Flags().IntVar(&cfg.Tasks, "tasks", 10, "Number of new tasks sent in every sync")
+	benchCmd.Flags().IntVar(&cfg.TaskSize, "task-size", 100, "Size of the tasks description")
+	benchCmd.Flags().StringVar(&pattern, "pattern", string(task.BenchIncremental), "Sync pattern, either incremental or full")
+	benchCmd.Flags().StringVar(&caCert, "ca", "", "CA certificate, instead of the configured one")
+	benchCmd.Flags().StringVar(&clientCert, "cert", "", "Client certificate, instead of the configured one")
+	benchCmd.Flags().StringVar(&clientKey, "key", "", "Client key, instead of the configured one")
+	if err := benchCmd.MarkFlagRequired("credentials"); err != nil {
+		panic(err)
+	}
+
+	return &benchCmd
+}
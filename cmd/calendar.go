@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+func calendarCmd() *cobra.Command {
+	var calendarCmd = cobra.Command{
+		Use:   "calendar",
+		Short: "Manages the access to the users calendars.",
+		Long: `When "calendar.listen" is configured, the server publishes an iCalendar with
+the tasks having a due date of every user with a calendar token, at
+https://<calendar.listen>/<token>.ics`,
+	}
+
+	var tokenCmd = cobra.Command{
+		Use:   "token <organization> <user-key>",
+		Short: "Generates a new calendar token, invalidating the previous one",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and user key expected")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			token, err := repository.NewCalendarToken(args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(struct {
+					Token string `json:"token"`
+				}{token})
+			}
+
+			log.Infof("New calendar token: %v", token)
+
+			return nil
+		},
+	}
+
+	var revokeCmd = cobra.Command{
+		Use:   "revoke <organization> <user-key>",
+		Short: "Disables the access to the user calendar",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and user key expected")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			if err := repository.RevokeCalendarToken(args[0], args[1]); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(userResult{Org: args[0], Key: args[1]})
+			}
+
+			log.Infof("Calendar token revoked")
+
+			return nil
+		},
+	}
+
+	calendarCmd.AddCommand(&tokenCmd)
+	calendarCmd.AddCommand(&revokeCmd)
+
+	return &calendarCmd
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task"
+	"github.com/szaffarano/gotas/task/champion"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// championResult is the JSON output of the TaskChampion export and import.
+type championResult struct {
+	Org     string `json:"org"`
+	User    string `json:"user"`
+	Replica string `json:"replica"`
+	Tasks   int    `json:"tasks"`
+}
+
+func championCmd() *cobra.Command {
+	var championCmd = cobra.Command{
+		Aliases: []string{"tc"},
+		Use:     "taskchampion",
+		Short:   "Migrates the users tasks from and to Taskwarrior 3.",
+		Long: `Exports the tasks of a user to a TaskChampion replica, the Taskwarrior 3 local
+storage, or imports them from one.  The replicas are SQLite databases, the
+sqlite3 command line shell must be installed.`,
+	}
+
+	var exportCmd = cobra.Command{
+		Use:   "export <organization> <user> <replica>",
+		Short: "Creates a replica holding the latest state of the user tasks",
+		Long: `Creates a replica holding the latest state of the user tasks, to be used as
+the Taskwarrior 3 "taskchampion.sqlite3" file.  The replica has no pending
+operations, so it can be synced with a fresh TaskChampion server.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 3 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization, user name or key and replica path expected")
+			}
+
+			dataDir := cmd.Flag(dataFlag).Value.String()
+			repository, err := repo.OpenRepository(dataDir)
+			if err != nil {
+				return err
+			}
+			user, err := findUser(repository, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			data, err := repo.NewDefaultReadAppender(dataDir).Read(user)
+			if err != nil {
+				return err
+			}
+			tasks, err := task.LatestTasks(data)
+			if err != nil {
+				return err
+			}
+
+			if err := champion.Export(args[2], tasks); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(championResult{Org: user.Org.Name, User: user.Name, Replica: args[2], Tasks: len(tasks)})
+			}
+			log.Infof("Exported %d task(s) of %q to %v", len(tasks), user.Name, args[2])
+
+			return nil
+		},
+	}
+
+	var importCmd = cobra.Command{
+		Use:   "import <organization> <user> <replica>",
+		Short: "Stores the replica tasks as the user tasks",
+		Long: `Stores the replica tasks missing or different in the server as a new sync of
+the user, so the clients get them on their next sync.  The server should not
+be running while importing.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 3 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization, user name or key and replica path expected")
+			}
+
+			dataDir := cmd.Flag(dataFlag).Value.String()
+			repository, err := repo.OpenRepository(dataDir)
+			if err != nil {
+				return err
+			}
+			user, err := findUser(repository, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			imported, err := champion.Import(args[2])
+			if err != nil {
+				return err
+			}
+
+			ra := repo.NewDefaultReadAppender(dataDir)
+			data, err := ra.Read(user)
+			if err != nil {
+				return err
+			}
+			tasks, err := task.LatestTasks(data)
+			if err != nil {
+				return err
+			}
+			stored := make(map[string]string, len(tasks))
+			for _, t := range tasks {
+				if stored[t.Get("uuid")], err = t.ComposeJSON(); err != nil {
+					return err
+				}
+			}
+
+			var changed []task.Task
+			for _, t := range imported {
+				raw, err := t.ComposeJSON()
+				if err != nil {
+					return err
+				}
+				if stored[t.Get("uuid")] != raw {
+					changed = append(changed, t)
+				}
+			}
+
+			if len(changed) > 0 {
+				if err := task.StoreTasks(ra, user, changed, nil); err != nil {
+					return err
+				}
+			}
+
+			if jsonMode(cmd) {
+				return printResult(championResult{Org: user.Org.Name, User: user.Name, Replica: args[2], Tasks: len(changed)})
+			}
+			log.Infof("Imported %d new or modified task(s) of %d for %q", len(changed), len(imported), user.Name)
+
+			return nil
+		},
+	}
+
+	championCmd.AddCommand(&exportCmd)
+	championCmd.AddCommand(&importCmd)
+
+	return &championCmd
+}
@@ -0,0 +1,393 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task"
+	"github.com/szaffarano/gotas/task/repo"
+	"github.com/szaffarano/gotas/task/transport"
+)
+
+// dryRunResult is the JSON output of a dry-run sync.
+type dryRunResult struct {
+	Code   string   `json:"code"`
+	Status string   `json:"status"`
+	Stored int      `json:"stored"`
+	Merged int      `json:"merged"`
+	Data   []string `json:"data"`
+}
+
+func debugCmd() *cobra.Command {
+	var debugCmd = cobra.Command{
+		Use:   "debug",
+		Short: "Helps debugging the clients sync and connection issues.",
+	}
+
+	var payloadPath string
+	var syncCmd = cobra.Command{
+		Use:   "sync <organization> <user>",
+		Short: "Computes the sync of a payload without storing anything",
+		Long: `Merges the tasks in the payload file, as sent by the client, with the user
+data and shows the transactions that would be stored.  The payload has one
+task per line, followed by the sync key of the last client sync, if any.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and user name or key expected")
+			}
+
+			payload, err := os.ReadFile(payloadPath)
+			if err != nil {
+				return fmt.Errorf("reading payload: %v", err)
+			}
+
+			dataDir := cmd.Flag(dataFlag).Value.String()
+			repository, err := repo.OpenRepository(dataDir)
+			if err != nil {
+				return err
+			}
+
+			user, err := findUser(repository, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			// use the server merge settings if they're valid
+			opts := task.DefaultOptions()
+			if cfg, err := task.LoadConfig(dataDir, nil, false); err != nil {
+				log.Warnf("Using the default settings: %v", err)
+			} else if settings, err := task.NewSettings(cfg); err != nil {
+				log.Warnf("Using the default settings: %v", err)
+			} else {
+				opts.Merge = settings.Merge
+				opts.OrgMerge = settings.OrgMerge
+				opts.LenientDates = settings.SyncLenientDates
+			}
+
+			ra := repo.NewDefaultReadAppender(dataDir)
+			opts.Archives = ra
+			resp := task.DryRunSync(user, string(payload), ra, opts)
+
+			result := dryRunResult{
+				Code:   resp.Header["code"],
+				Status: resp.Header["status"],
+				Data:   make([]string, 0),
+			}
+			result.Stored, _ = strconv.Atoi(resp.Header["stored"])
+			result.Merged, _ = strconv.Atoi(resp.Header["merged"])
+			for _, line := range strings.Split(resp.Payload, "\n") {
+				if line != "" {
+					result.Data = append(result.Data, line)
+				}
+			}
+
+			if jsonMode(cmd) {
+				return printResult(result)
+			}
+
+			if result.Code != "200" {
+				return fmt.Errorf("sync failed with code %s: %s", result.Code, result.Status)
+			}
+
+			log.Infof("Would store %d tasks and merge %d tasks", result.Stored, result.Merged)
+			for _, line := range result.Data {
+				fmt.Println(line)
+			}
+
+			return nil
+		},
+	}
+	syncCmd.Flags().StringVar(&payloadPath, "payload", "", "File with the payload sent by the client")
+	if err := syncCmd.MarkFlagRequired("payload"); err != nil {
+		panic(err)
+	}
+
+	var caCert, clientCert, clientKey string
+	var tlsCmd = cobra.Command{
+		Use:   "tls <host:port>",
+		Short: "Diagnoses the TLS connection to a server",
+		Long: `Connects to the server with the client certificate configured in the data
+directory ("client.cert" and "client.key", verified with "ca.cert"), unless
+overridden by the flags, and shows the negotiated TLS version and cipher and
+the server certificate chain.  Common failures, like expired certificates,
+names not matching the host or missing client certificates, are explained.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("server address expected")
+			}
+
+			cfg := transport.ClientConfig{CaCert: caCert, Cert: clientCert, Key: clientKey, Address: args[0]}
+			if loaded, err := task.LoadConfig(cmd.Flag(dataFlag).Value.String(), nil, false); err == nil {
+				if cfg.CaCert == "" {
+					cfg.CaCert = loaded.Get(task.CaCert)
+				}
+				if cfg.Cert == "" {
+					cfg.Cert = loaded.Get(task.ClientCert)
+				}
+				if cfg.Key == "" {
+					cfg.Key = loaded.Get(task.ClientKey)
+				}
+			} else {
+				log.Warnf("Using only the flags, configuration not loaded: %v", err)
+			}
+
+			diagnosis, err := transport.Diagnose(cfg)
+			if err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				if err := printResult(diagnosis); err != nil {
+					return err
+				}
+			} else {
+				fmt.Printf("Protocol: %s\nCipher:   %s\n", diagnosis.Version, diagnosis.CipherSuite)
+				for i, cert := range diagnosis.Chain {
+					fmt.Printf("Certificate %d:\n  Subject: %s\n  Issuer:  %s\n  Names:   %s\n  Valid:   %s - %s\n",
+						i, cert.Subject, cert.Issuer, strings.Join(cert.Names, ", "),
+						cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339))
+				}
+				for _, problem := range diagnosis.Problems {
+					fmt.Println(problem)
+				}
+			}
+
+			if len(diagnosis.Problems) > 0 {
+				return fmt.Errorf("connection has %d problem(s)", len(diagnosis.Problems))
+			}
+
+			log.Infof("Connection to %s verified", cfg.Address)
+
+			return nil
+		},
+	}
+	tlsCmd.Flags().StringVar(&caCert, "ca", "", "CA certificate, instead of the configured one")
+	tlsCmd.Flags().StringVar(&clientCert, "cert", "", "Client certificate, instead of the configured one")
+	tlsCmd.Flags().StringVar(&clientKey, "key", "", "Client key, instead of the configured one")
+
+	var verifyCmd = cobra.Command{
+		Aliases: []string{"verify"},
+		Use:     "verify-payload <file>",
+		Short:   "Inspects a raw message captured from a client",
+		Long: `Parses a raw client message, as captured from the wire including its 4-byte
+size prefix, and shows its headers and the format detected for every payload
+line.  The lines the server would reject are explained along with the column
+where the parsing failed, which helps triaging the "Malformed data" reports.
+Nothing is read from or stored in the data directory.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("payload file expected")
+			}
+
+			raw, err := os.ReadFile(args[0])
+			if err != nil {
+				return fmt.Errorf("reading payload: %v", err)
+			}
+
+			report, err := task.VerifyPayload(raw)
+			if err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				if err := printResult(report); err != nil {
+					return err
+				}
+			} else {
+				printPayloadReport(report)
+			}
+
+			if invalid := report.Invalid(); invalid > 0 {
+				return fmt.Errorf("%d of %d record(s) rejected", invalid, len(report.Records))
+			}
+			log.Infof("%d record(s) verified", len(report.Records))
+
+			return nil
+		},
+	}
+
+	var profile, profileFile string
+	var seconds int
+	var profileCmd = cobra.Command{
+		Use:   "profile",
+		Short: "Saves a runtime profile of the running server",
+		Long: `Gets a runtime profile of the running server through the admin socket, to be
+analyzed with "go tool pprof".  The cpu profile samples the server for the
+given --seconds.  The server has to be started with "admin.debug = true".`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("no arguments expected")
+			}
+
+			cfg, err := task.LoadConfig(cmd.Flag(dataFlag).Value.String(), nil, false)
+			if err != nil {
+				return err
+			}
+			settings, err := task.NewSettings(cfg)
+			if err != nil {
+				return err
+			}
+
+			if profileFile == "" {
+				profileFile = fmt.Sprintf("gotas-%s-%s.pprof", profile, time.Now().Format("20060102T150405"))
+			}
+			file, err := os.Create(profileFile)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			if profile == "cpu" {
+				log.Infof("Sampling the server for %d seconds...", seconds)
+			}
+			if err := task.QueryProfile(settings.AdminSocket, profile, seconds, file); err != nil {
+				file.Close()
+				os.Remove(profileFile)
+				return err
+			}
+			if err := file.Close(); err != nil {
+				return err
+			}
+
+			log.Infof("Profile saved to %s, analyze it with \"go tool pprof %s\"", profileFile, profileFile)
+
+			return nil
+		},
+	}
+	profileCmd.Flags().StringVar(&profile, "profile", "cpu", fmt.Sprintf("Profile, one of %v", task.Profiles))
+	profileCmd.Flags().IntVar(&seconds, "seconds", 30, "Duration of the cpu profile")
+	profileCmd.Flags().StringVar(&profileFile, "file", "", "File where the profile is saved, gotas-<profile>-<time>.pprof by default")
+
+	var mergesCmd = cobra.Command{
+		Use:   "merges <organization> <user> [uuid]",
+		Short: "Shows the last merges of a user tasks",
+		Long: `Shows the merges recorded for the user, or only the ones of the task with the
+given uuid, to find out why a change was lost: the modifications applied in
+order, and the side, client or server, whose value was kept for every changed
+attribute.  The server records them with "merge.audit" set to the number of
+merges to keep per user.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 && len(args) != 3 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization, user name or key and optional uuid expected")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+			user, err := findUser(repository, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			var uuid string
+			if len(args) == 3 {
+				uuid = args[2]
+			}
+			records, err := repository.Merges(args[0], user.Key, uuid)
+			if err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(records)
+			}
+
+			if len(records) == 0 {
+				log.Infof("No merges recorded for %q", user.Name)
+				return nil
+			}
+			printMerges(records)
+
+			return nil
+		},
+	}
+
+	debugCmd.AddCommand(&mergesCmd)
+	debugCmd.AddCommand(&profileCmd)
+	debugCmd.AddCommand(&syncCmd)
+	debugCmd.AddCommand(&tlsCmd)
+	debugCmd.AddCommand(&verifyCmd)
+
+	return &debugCmd
+}
+
+func printMerges(records []repo.MergeRecord) {
+	for _, record := range records {
+		names := make([]string, 0, len(record.Attributes))
+		for name := range record.Attributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		kept := make([]string, 0, len(names))
+		for _, name := range names {
+			kept = append(kept, name+"="+record.Attributes[name])
+		}
+
+		fmt.Printf("%s %s (%s)\n  Kept: %s\n", record.Time.Local().Format(time.RFC3339), record.UUID,
+			record.Strategy, strings.Join(kept, ", "))
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  SOURCE\tMODIFIED\tCHANGED\tREMOVED")
+		for _, step := range record.Steps {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", step.Source, step.Modified.Local().Format(time.RFC3339),
+				strings.Join(step.Changed, ","), strings.Join(step.Removed, ","))
+		}
+		w.Flush()
+	}
+}
+
+func printPayloadReport(report task.PayloadReport) {
+	fmt.Printf("Size: %d bytes\n", report.Size)
+	if report.Trailing > 0 {
+		fmt.Printf("Trailing: %d bytes after the message\n", report.Trailing)
+	}
+
+	names := make([]string, 0, len(report.Header))
+	for name := range report.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println("Headers:")
+	for _, name := range names {
+		fmt.Printf("  %s: %s\n", name, report.Header[name])
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tFORMAT\tUUID\tRESULT")
+	for _, record := range report.Records {
+		format := record.Format
+		if record.Format == task.FormatLegacy {
+			format = fmt.Sprintf("v%d", record.Version)
+		}
+
+		result := "ok"
+		if record.Column > 0 {
+			result = fmt.Sprintf("column %d: %s", record.Column, record.Error)
+		} else if record.Error != "" {
+			result = record.Error
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", record.Line, format, record.UUID, result)
+	}
+	w.Flush()
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/szaffarano/gotas/config"
+	"github.com/szaffarano/gotas/pki"
+	"github.com/szaffarano/gotas/task"
+)
+
+// devCertificates is the ephemeral PKI used by the server in insecure
+// development mode.
+type devCertificates struct {
+	dir        string
+	host       string
+	caCert     string
+	serverCert string
+	serverKey  string
+	clientCert string
+	clientKey  string
+}
+
+// newDevCertificates creates a CA, a server certificate for the host of the
+// bind address and a client certificate in dir.
+func newDevCertificates(dir, bindAddress string) (*devCertificates, error) {
+	host, _, err := net.SplitHostPort(bindAddress)
+	if ip := net.ParseIP(host); err != nil || host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+
+	dev := &devCertificates{
+		dir:        dir,
+		host:       host,
+		caCert:     filepath.Join(dir, "ca.pem"),
+		serverCert: filepath.Join(dir, "server.pem"),
+		serverKey:  filepath.Join(dir, "server.key"),
+		clientCert: filepath.Join(dir, "client.pem"),
+		clientKey:  filepath.Join(dir, "client.key"),
+	}
+
+	caCert, caKey, err := pki.CreateCA("Gotas insecure dev", "Gotas insecure dev CA")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeDevPair(dev.caCert, filepath.Join(dir, "ca.key"), caCert, caKey); err != nil {
+		return nil, err
+	}
+	ca, err := tls.X509KeyPair(caCert, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, key, err := pki.CreateServerCert("Gotas insecure dev", host, ca)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeDevPair(dev.serverCert, dev.serverKey, cert, key); err != nil {
+		return nil, err
+	}
+
+	cert, key, err = pki.CreateClientCert("Gotas insecure dev", "dev", ca)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeDevPair(dev.clientCert, dev.clientKey, cert, key); err != nil {
+		return nil, err
+	}
+
+	return dev, nil
+}
+
+// apply replaces the certificates of the configuration with the ephemeral
+// ones, accepting any client certificate if allowAnyClient is set.
+func (d *devCertificates) apply(cfg *config.Config, allowAnyClient bool) {
+	cfg.Set(task.CaCert, d.caCert)
+	cfg.Set(task.ServerCert, d.serverCert)
+	cfg.Set(task.ServerKey, d.serverKey)
+	cfg.Set(task.ClientCert, d.clientCert)
+	cfg.Set(task.ClientKey, d.clientKey)
+	if allowAnyClient {
+		cfg.Set(task.Trust, string(task.TrustAllowAll))
+	}
+}
+
+// warn loudly logs the insecure mode and the client settings to connect.
+func (d *devCertificates) warn(bindAddress string, allowAnyClient bool) {
+	_, port, err := net.SplitHostPort(bindAddress)
+	if err != nil {
+		port = "<port>"
+	}
+
+	log.Warnf("INSECURE DEVELOPMENT MODE, NEVER USE IT IN PRODUCTION: the configured certificates are ignored, "+
+		"ephemeral ones are created in %s, CA key included, and removed on exit", d.dir)
+	if allowAnyClient {
+		log.Warn("INSECURE DEVELOPMENT MODE: client certificates are NOT verified, any client can connect")
+	}
+	log.Info("Client settings (.taskrc), with the credentials of a user created with `gotas add user`:")
+	log.Infof("  taskd.server=%s:%s", d.host, port)
+	log.Infof("  taskd.ca=%s", d.caCert)
+	log.Infof("  taskd.certificate=%s", d.clientCert)
+	log.Infof("  taskd.key=%s", d.clientKey)
+	log.Info("  taskd.credentials=<organization>/<user>/<key>")
+}
+
+func writeDevPair(certPath, keyPath string, cert, key []byte) error {
+	if err := os.WriteFile(certPath, cert, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyPath, key, 0600)
+}
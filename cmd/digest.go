@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+func digestCmd() *cobra.Command {
+	var digestCmd = cobra.Command{
+		Use:   "digest <organization> <user> <email|off>",
+		Short: "Subscribes a user to the digest of its due tasks",
+		Long: `Subscribes a user, identified by name or key, to the email with its tasks due
+today or overdue, sent by the "digest" job, see "schedule.digest" and the
+"smtp.*" settings.  "off" unsubscribes the user.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 3 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization, user name or key and email expected")
+			}
+
+			email := args[2]
+			if email == "off" {
+				email = ""
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			user, err := findUser(repository, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			if err := repository.SetDigestEmail(args[0], user.Key, email); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(struct {
+					userResult
+					Email string `json:"email"`
+				}{userResult{Org: args[0], Name: user.Name, Key: user.Key}, email})
+			}
+
+			if email == "" {
+				log.Infof("user %q from organization %q won't get the digest", user.Name, args[0])
+			} else {
+				log.Infof("user %q from organization %q gets the digest at %s", user.Name, args[0], email)
+			}
+
+			return nil
+		},
+	}
+
+	return &digestCmd
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task"
+	"github.com/szaffarano/gotas/task/transport"
+)
+
+func doctorCmd() *cobra.Command {
+	var doctorCmd = cobra.Command{
+		Use:   "doctor",
+		Short: "Checks the server setup and explains how to fix the problems found",
+	}
+
+	var clientCert, clientKey string
+	var certsCmd = cobra.Command{
+		Use:   "certs",
+		Short: "Verifies the CA, server and client certificates",
+		Long: `Loads the certificates configured in the data directory ("ca.cert",
+"server.cert" and "server.key") and checks, without connecting to the server,
+that the keys match the certificates, they are not expired and are signed by
+the CA, and that the server certificate is valid for the host in "server".
+The client certificate is checked too if configured ("client.cert" and
+"client.key") or given with the flags.  Every problem comes with the steps to
+fix it.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := task.LoadConfig(cmd.Flag(dataFlag).Value.String(), nil, false)
+			if err != nil {
+				return err
+			}
+
+			if clientCert == "" && clientKey == "" {
+				clientCert, clientKey = cfg.Get(task.ClientCert), cfg.Get(task.ClientKey)
+			}
+
+			report := transport.CheckCertificates(transport.TLSConfig{
+				CaCert:      cfg.Get(task.CaCert),
+				ServerCert:  cfg.Get(task.ServerCert),
+				ServerKey:   cfg.Get(task.ServerKey),
+				BindAddress: cfg.Get(task.BindAddress),
+			}, clientCert, clientKey)
+
+			if jsonMode(cmd) {
+				if err := printResult(report); err != nil {
+					return err
+				}
+			} else {
+				for _, cert := range report.CA {
+					printCertificate("CA", cert)
+				}
+				if report.Server != nil {
+					printCertificate("Server", *report.Server)
+				}
+				if report.Client != nil {
+					printCertificate("Client", *report.Client)
+				}
+				for _, finding := range report.Findings {
+					fmt.Printf("Problem: %s\n  Fix:   %s\n", finding.Problem, finding.Remediation)
+				}
+			}
+
+			if len(report.Findings) > 0 {
+				return fmt.Errorf("certificates have %d problem(s)", len(report.Findings))
+			}
+
+			log.Info("Certificates verified")
+
+			return nil
+		},
+	}
+	certsCmd.Flags().StringVar(&clientCert, "cert", "", "Client certificate, instead of the configured one")
+	certsCmd.Flags().StringVar(&clientKey, "key", "", "Client key, instead of the configured one")
+
+	doctorCmd.AddCommand(&certsCmd)
+
+	return &doctorCmd
+}
+
+func printCertificate(owner string, cert transport.Certificate) {
+	fmt.Printf("%s certificate:\n  Subject: %s\n  Issuer:  %s\n  Names:   %s\n  Valid:   %s - %s\n",
+		owner, cert.Subject, cert.Issuer, strings.Join(cert.Names, ", "),
+		cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339))
+}
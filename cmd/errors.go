@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// Exit codes of the commands, so the scripts can tell the failures apart.
+const (
+	exitError      = 1
+	exitNotFound   = 2
+	exitExists     = 3
+	exitConflict   = 4
+	exitPermission = 5
+)
+
+// errorCode classifies a repository error, code is the identifier printed in
+// the JSON output.
+type errorCode struct {
+	err  error
+	code string
+	exit int
+}
+
+var errorCodes = []errorCode{
+	{repo.ErrOrgExists, "org-exists", exitExists},
+	{repo.ErrOrgNotFound, "org-not-found", exitNotFound},
+	{repo.ErrOrgDeleted, "org-deleted", exitConflict},
+	{repo.ErrOrgNotDeleted, "org-not-deleted", exitConflict},
+	{repo.ErrUserExists, "user-exists", exitExists},
+	{repo.ErrUserNotFound, "user-not-found", exitNotFound},
+	{repo.ErrUserDeleted, "user-deleted", exitConflict},
+	{repo.ErrUserNotDeleted, "user-not-deleted", exitConflict},
+	{repo.ErrUserSuspended, "user-suspended", exitConflict},
+	{repo.ErrUserNotSuspended, "user-not-suspended", exitConflict},
+	{repo.ErrGroupExists, "group-exists", exitExists},
+	{repo.ErrGroupNotFound, "group-not-found", exitNotFound},
+	{repo.ErrPermission, "permission", exitPermission},
+}
+
+// classify returns the JSON code and the exit code of a command error, "error"
+// and exitError unless it's a known repository error.
+func classify(err error) (string, int) {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code, c.exit
+		}
+	}
+
+	var permission auth.PermissionError
+	if errors.As(err, &permission) {
+		return "permission", exitPermission
+	}
+	return "error", exitError
+}
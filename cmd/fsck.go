@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/fsck"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+func fsckCmd() *cobra.Command {
+	var repair bool
+
+	var fsckCmd = cobra.Command{
+		Use:   "fsck",
+		Short: "Checks the repository integrity.",
+		Long: `Walks the repository verifying the organizations and users structure, the
+configuration files and the transactions files.  With --repair, truncated
+transactions are removed (keeping a backup of the original file), leftover
+temporary files are deleted or recovered as "gotas gc" does, and missing
+folders are created.  The repository must be in maintenance mode to repair
+it, so the appends of a running server aren't touched.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dataDir := cmd.Flag(dataFlag).Value.String()
+			if repair && !repo.InMaintenance(dataDir) {
+				return fmt.Errorf("the repository must be in maintenance mode to repair it")
+			}
+
+			report, err := fsck.Check(dataDir, repair)
+			if err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				if err := printResult(fsckResult(report)); err != nil {
+					return err
+				}
+			} else {
+				for _, issue := range report.Issues {
+					fmt.Println(issue)
+				}
+			}
+
+			if !report.Clean() {
+				return fmt.Errorf("repository has %d issue(s)", unrepaired(report))
+			}
+
+			log.Infof("Repository checked, %d issue(s) repaired", len(report.Issues))
+
+			return nil
+		},
+	}
+	fsckCmd.Flags().BoolVar(&repair, "repair", false, "Repairs the issues that can be safely fixed")
+
+	return &fsckCmd
+}
+
+func fsckResult(report fsck.Report) interface{} {
+	return struct {
+		Issues []fsck.Issue `json:"issues"`
+		Clean  bool         `json:"clean"`
+	}{append([]fsck.Issue{}, report.Issues...), report.Clean()}
+}
+
+func unrepaired(report fsck.Report) int {
+	count := 0
+	for _, issue := range report.Issues {
+		if !issue.Repaired {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// gcResult is the JSON output of the gc command.
+type gcResult struct {
+	Artifacts []repo.Artifact `json:"artifacts"`
+	Expired   []repo.Expired  `json:"expired"`
+}
+
+func gcCmd() *cobra.Command {
+	var checkOnly bool
+
+	var gcCmd = cobra.Command{
+		Use:   "gc",
+		Short: "Removes the temporary files left by a crash and the expired tasks.",
+		Long: `Removes the temporary transactions and configuration files and the stale
+locks left in the repository by a crash.  A temporary transactions file holding
+the only, complete copy of the user data is recovered instead.
+
+Then it drops the tasks completed or deleted longer than "retention.completed.days"
+and "retention.deleted.days" ago from the transactions, keeping the sync keys,
+or moves them to the users archives with "retention.archive = true".
+
+The server does it on startup, with --check-only the files and tasks are only
+reported.  Otherwise the repository must be in maintenance mode, see "server
+maintenance", so the appends in flight aren't removed nor overwritten.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dataDir := cmd.Flag(dataFlag).Value.String()
+			if !checkOnly && !repo.InMaintenance(dataDir) {
+				return fmt.Errorf("the repository must be in maintenance mode, or use --check-only")
+			}
+
+			artifacts, err := repo.CollectGarbage(dataDir, checkOnly)
+			if err != nil {
+				return err
+			}
+
+			var expired []repo.Expired
+			if cfg, err := task.LoadConfig(dataDir, nil, false); err != nil {
+				log.Warnf("Retention not enforced, configuration not loaded: %v", err)
+			} else if settings, err := task.NewSettings(cfg); err != nil {
+				log.Warnf("Retention not enforced, configuration not loaded: %v", err)
+			} else if expired, err = repo.EnforceRetention(dataDir, settings.Retention, checkOnly); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				result := gcResult{Artifacts: artifacts, Expired: expired}
+				if result.Artifacts == nil {
+					result.Artifacts = make([]repo.Artifact, 0)
+				}
+				if result.Expired == nil {
+					result.Expired = make([]repo.Expired, 0)
+				}
+				return printResult(result)
+			}
+
+			for _, a := range artifacts {
+				fmt.Println(a)
+			}
+			for _, e := range expired {
+				fmt.Println(e)
+			}
+			log.Infof("Found %d leftover file(s) and %d transactions file(s) with expired tasks", len(artifacts), len(expired))
+
+			return nil
+		},
+	}
+
+	gcCmd.Flags().BoolVar(&checkOnly, "check-only", false, "Only reports the leftover files and the expired tasks")
+
+	return &gcCmd
+}
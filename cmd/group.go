@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// groupResult is the JSON output of the commands managing groups.
+type groupResult struct {
+	Org   string `json:"org"`
+	Group string `json:"group,omitempty"`
+	Key   string `json:"key,omitempty"`
+}
+
+func groupCmd() *cobra.Command {
+	var groupCmd = cobra.Command{
+		Use:   "group",
+		Short: "Manages shared groups.",
+		Long: `Users joining a shared group sync the same task list, so small teams can
+share their tasks using standard Taskwarrior clients.`,
+	}
+
+	var addGroupCmd = cobra.Command{
+		Use:   "add <organization> <group>",
+		Short: "Creates a new shared group",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and group name expected")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			if err := repository.NewGroup(args[0], args[1]); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(groupResult{Org: args[0], Group: args[1]})
+			}
+
+			log.Infof("Created group %q for organization %q", args[1], args[0])
+
+			return nil
+		},
+	}
+
+	var removeGroupCmd = cobra.Command{
+		Use:   "remove <organization> <group>",
+		Short: "Deletes a shared group and its data",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and group name expected")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			if err := repository.DelGroup(args[0], args[1]); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(groupResult{Org: args[0], Group: args[1]})
+			}
+
+			log.Infof("Removed group %q from organization %q", args[1], args[0])
+
+			return nil
+		},
+	}
+
+	var listGroupCmd = cobra.Command{
+		Use:   "list <organization>",
+		Short: "Lists the shared groups of an organization",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization name expected")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			groups, err := repository.GetGroups(args[0])
+			if err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(append([]string{}, groups...))
+			}
+
+			for _, g := range groups {
+				fmt.Println(g)
+			}
+
+			return nil
+		},
+	}
+
+	var joinGroupCmd = cobra.Command{
+		Use:   "join <organization> <group> <user-key>",
+		Short: "Makes a user share the group task list",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 3 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization, group name and user key expected")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			if err := repository.JoinGroup(args[0], args[1], args[2]); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(groupResult{Org: args[0], Group: args[1], Key: args[2]})
+			}
+
+			log.Infof("User %q joined group %q", args[2], args[1])
+
+			return nil
+		},
+	}
+
+	var leaveGroupCmd = cobra.Command{
+		Use:   "leave <organization> <user-key>",
+		Short: "Makes a user go back to its own task list",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and user key expected")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			if err := repository.LeaveGroup(args[0], args[1]); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(groupResult{Org: args[0], Key: args[1]})
+			}
+
+			log.Infof("User %q left its group", args[1])
+
+			return nil
+		},
+	}
+
+	groupCmd.AddCommand(&addGroupCmd)
+	groupCmd.AddCommand(&removeGroupCmd)
+	groupCmd.AddCommand(&listGroupCmd)
+	groupCmd.AddCommand(&joinGroupCmd)
+	groupCmd.AddCommand(&leaveGroupCmd)
+
+	return &groupCmd
+}
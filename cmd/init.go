@@ -34,6 +34,12 @@ func initCmd() *cobra.Command {
 				return err
 			}
 
+			if jsonMode(cmd) {
+				return printResult(struct {
+					Path string `json:"path"`
+				}{repository.String()})
+			}
+
 			log.Infof("Empty repository initialized: %q", repository)
 
 			return nil
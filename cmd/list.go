@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// userListResult is the JSON output of the users listing.  LastSync is only
+// set in detailed mode.
+type userListResult struct {
+	Org       string         `json:"org"`
+	Name      string         `json:"name"`
+	Key       string         `json:"key"`
+	Role      auth.Role      `json:"role"`
+	Deleted   *time.Time     `json:"deleted,omitempty"`
+	Suspended *time.Time     `json:"suspended,omitempty"`
+	LastSync  *repo.LastSync `json:"last_sync,omitempty"`
+}
+
+func listCmd() *cobra.Command {
+	var listCmd = cobra.Command{
+		Use:   "list",
+		Short: "Lists organizations or users.",
+	}
+
+	var detail bool
+	var usersCmd = cobra.Command{
+		Use:   "users [organization]",
+		Short: "Lists the users, optionally only the ones of an organization",
+		Long: `Lists the users.  With --detail, the last sync time, client, address and
+payload size are included, so stale or abusive accounts can be found.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("at most one organization name expected")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			var orgNames []string
+			if len(args) == 1 {
+				orgNames = []string{args[0]}
+			} else {
+				for _, org := range repository.Orgs() {
+					orgNames = append(orgNames, org.Name)
+				}
+			}
+
+			users := make([]userListResult, 0)
+			for _, orgName := range orgNames {
+				org, err := repository.GetOrg(orgName)
+				if err != nil {
+					return err
+				}
+
+				for _, u := range org.Users {
+					result := userListResult{Org: org.Name, Name: u.Name, Key: u.Key, Role: u.Role}
+					if !u.Deleted.IsZero() {
+						deleted := u.Deleted
+						result.Deleted = &deleted
+					}
+					if !u.Suspended.IsZero() {
+						suspended := u.Suspended
+						result.Suspended = &suspended
+					}
+
+					if detail {
+						lastSync, ok, err := repository.LastSync(org.Name, u.Key)
+						if err != nil {
+							return err
+						} else if ok {
+							result.LastSync = &lastSync
+						}
+					}
+
+					users = append(users, result)
+				}
+			}
+
+			if jsonMode(cmd) {
+				return printResult(users)
+			}
+			return printUsersTable(os.Stdout, users, detail)
+		},
+	}
+	usersCmd.Flags().BoolVar(&detail, "detail", false, "Includes the last sync metadata")
+
+	listCmd.AddCommand(&usersCmd)
+
+	return &listCmd
+}
+
+func printUsersTable(w io.Writer, users []userListResult, detail bool) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	header := "ORG\tNAME\tKEY\tROLE\tSTATUS"
+	if detail {
+		header += "\tLAST SYNC\tCLIENT\tADDRESS\tSIZE"
+	}
+	fmt.Fprintln(tw, header)
+
+	for _, u := range users {
+		status := "active"
+		if u.Deleted != nil {
+			status = "deleted"
+		} else if u.Suspended != nil {
+			status = "suspended"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s", u.Org, u.Name, u.Key, u.Role, status)
+
+		if detail {
+			if s := u.LastSync; s != nil {
+				fmt.Fprintf(tw, "\t%s\t%s\t%s\t%d", formatDate(s.Time), s.Client, s.Address, s.Size)
+			} else {
+				fmt.Fprint(tw, "\tnever\t\t\t")
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// migrateResult is the JSON output of the migrate command.
+type migrateResult struct {
+	From       int              `json:"from"`
+	To         int              `json:"to"`
+	Migrations []repo.Migration `json:"migrations"`
+}
+
+func migrateCmd() *cobra.Command {
+	var checkOnly bool
+
+	var migrateCmd = cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrades the repository to the schema version of this gotas.",
+		Long: `Runs the migrations the repository needs to be used by this version of gotas,
+in order, stamping the schema version after each one.  The repository is
+backed up to the "backups" folder of the data directory before the first
+migration rewriting or removing data.  Stop the server first.
+
+The server runs the migrations that don't touch the data when it starts, and
+refuses to start with the other ones pending, or with a repository written by
+a newer gotas.  With --check-only the pending migrations are only reported.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dataDir := cmd.Flag(dataFlag).Value.String()
+
+			from, err := repo.ReadSchemaVersion(dataDir)
+			if err != nil {
+				return err
+			}
+
+			var migrations []repo.Migration
+			if checkOnly {
+				migrations, err = repo.PendingMigrations(dataDir)
+			} else {
+				migrations, err = repo.Migrate(dataDir)
+			}
+			if err != nil {
+				return err
+			}
+
+			result := migrateResult{From: from, To: from, Migrations: make([]repo.Migration, 0)}
+			for _, m := range migrations {
+				result.Migrations = append(result.Migrations, m)
+				if !checkOnly {
+					result.To = m.Version
+				}
+			}
+
+			if jsonMode(cmd) {
+				return printResult(result)
+			}
+
+			status := "applied"
+			if checkOnly {
+				status = "pending"
+			}
+			for _, m := range migrations {
+				if m.Destructive {
+					fmt.Printf("%d: %s (%s, destructive)\n", m.Version, m.Description, status)
+				} else {
+					fmt.Printf("%d: %s (%s)\n", m.Version, m.Description, status)
+				}
+			}
+			log.Infof("Repository schema version %d, %d migration(s) %s", result.To, len(migrations), status)
+
+			return nil
+		},
+	}
+
+	migrateCmd.Flags().BoolVar(&checkOnly, "check-only", false, "Only reports the pending migrations")
+
+	return &migrateCmd
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	textOutput = "text"
+	jsonOutput = "json"
+)
+
+// errorResult is printed instead of the command result when it fails and the
+// JSON output is enabled.  Code identifies the failure, e.g. "org-exists".
+type errorResult struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// orgResult is the JSON output of the commands managing organizations.
+type orgResult struct {
+	Org string `json:"org"`
+}
+
+// userResult is the JSON output of the commands managing users.
+type userResult struct {
+	Org  string `json:"org"`
+	Name string `json:"name,omitempty"`
+	Key  string `json:"key"`
+}
+
+func validateOutput(output string) error {
+	if output != textOutput && output != jsonOutput {
+		return fmt.Errorf("invalid output %q, either %q or %q expected", output, textOutput, jsonOutput)
+	}
+	return nil
+}
+
+// jsonMode returns true if the command has to print its result as JSON on
+// stdout instead of logging it.
+func jsonMode(cmd *cobra.Command) bool {
+	flag := cmd.Flag(outputFlag)
+	return flag != nil && flag.Value.String() == jsonOutput
+}
+
+// printResult prints a command result as JSON on stdout.
+func printResult(result interface{}) error {
+	return printJSON(os.Stdout, result)
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
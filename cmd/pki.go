@@ -23,7 +23,7 @@ func pkiCmd() *cobra.Command {
 	pkiInitCmd := cobra.Command{
 		Use:   "init",
 		Short: "Initializes the PKI by crating a new CA",
-		RunE: func(_ *cobra.Command, _ []string) error {
+		RunE: func(cmd *cobra.Command, _ []string) error {
 			if err := createIfNotExists(pkiPath); err != nil {
 				return err
 			}
@@ -38,7 +38,7 @@ func pkiCmd() *cobra.Command {
 				return err
 			}
 
-			return writePair(certPath, keyPath, caCert, caKey)
+			return writePair(cmd, certPath, keyPath, caCert, caKey)
 		},
 	}
 
@@ -50,7 +50,7 @@ func pkiCmd() *cobra.Command {
 	pkiAddClientCmd := cobra.Command{
 		Use:   "client",
 		Short: "Creates a new client certificate",
-		RunE: func(_ *cobra.Command, _ []string) error {
+		RunE: func(cmd *cobra.Command, _ []string) error {
 			caCert, err := loadCakeyPair(pkiPath)
 			if err != nil {
 				return nil
@@ -66,14 +66,14 @@ func pkiCmd() *cobra.Command {
 				return err
 			}
 
-			return writePair(certFile, keyFile, cert, key)
+			return writePair(cmd, certFile, keyFile, cert, key)
 		},
 	}
 
 	pkiAddServerCmd := cobra.Command{
 		Use:   "server",
 		Short: "Creates a new server certificate",
-		RunE: func(_ *cobra.Command, _ []string) error {
+		RunE: func(cmd *cobra.Command, _ []string) error {
 			caCert, err := loadCakeyPair(pkiPath)
 			if err != nil {
 				return err
@@ -89,7 +89,7 @@ func pkiCmd() *cobra.Command {
 				return err
 			}
 
-			return writePair(certFile, keyFile, cert, key)
+			return writePair(cmd, certFile, keyFile, cert, key)
 		},
 	}
 
@@ -144,13 +144,19 @@ func pairPath(prefix, pkiPath string) (string, string, error) {
 	return caCertPath, caKeyPath, nil
 }
 
-func writePair(certPath, keyPath string, cert, key []byte) error {
+func writePair(cmd *cobra.Command, certPath, keyPath string, cert, key []byte) error {
 	if err := os.WriteFile(certPath, cert, 0644); err != nil {
 		return err
 	}
 	if err := os.WriteFile(keyPath, key, 0600); err != nil {
 		return err
 	}
+	if jsonMode(cmd) {
+		return printResult(struct {
+			Cert string `json:"cert"`
+			Key  string `json:"key"`
+		}{certPath, keyPath})
+	}
 	log.Infof("%v: created successfully", certPath)
 	log.Infof("%v: created successfully", keyPath)
 	return nil
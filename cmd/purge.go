@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+func purgeCmd() *cobra.Command {
+	var olderThan time.Duration
+
+	purgeCmd := cobra.Command{
+		Use:   "purge",
+		Short: "Permanently removes the deleted organizations and users.",
+		Long: `Permanently removes the organizations and users deleted more than
+--older-than ago, along with all their data.  This can't be undone.  The
+ones whose deletion time can't be parsed are skipped and reported.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			purged, skipped, err := repository.Purge(time.Now().Add(-olderThan))
+			for _, p := range purged {
+				log.Infof("purged %s", p)
+			}
+			for _, s := range skipped {
+				log.Warnf("skipped %s", s)
+			}
+			if err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(struct {
+					Purged  []string `json:"purged"`
+					Skipped []string `json:"skipped"`
+				}{append([]string{}, purged...), append([]string{}, skipped...)})
+			}
+
+			log.Infof("%d deleted organization(s) or user(s) purged", len(purged))
+
+			return nil
+		},
+	}
+	purgeCmd.Flags().DurationVar(&olderThan, "older-than", 30*24*time.Hour, "Only purges the entities deleted before this duration")
+
+	return &purgeCmd
+}
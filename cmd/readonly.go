@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// readOnlyResult is the JSON output of the read-only command.
+type readOnlyResult struct {
+	Org      string `json:"org"`
+	ReadOnly bool   `json:"readonly"`
+}
+
+func readOnlyCmd() *cobra.Command {
+	var readOnlyCmd = cobra.Command{
+		Use:   "readonly",
+		Short: "Makes an organization read-only.",
+		Long: `Makes an organization read-only: its users can sync but not upload changes
+until --off is given.  "server.readonly = true" does the same for the whole
+server.`,
+	}
+
+	var off bool
+	readOnlyOrgCmd := cobra.Command{
+		Aliases: []string{"o"},
+		Use:     "org <organization>",
+		Short:   "Makes an organization read-only, or writable again with --off",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization name expected")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			if err := repository.SetOrgReadOnly(args[0], !off); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(readOnlyResult{Org: args[0], ReadOnly: !off})
+			}
+
+			if off {
+				log.Infof("organization %q is writable", args[0])
+			} else {
+				log.Infof("organization %q is read-only", args[0])
+			}
+
+			return nil
+		},
+	}
+	readOnlyOrgCmd.Flags().BoolVar(&off, "off", false, "Makes the organization writable again")
+
+	readOnlyCmd.AddCommand(&readOnlyOrgCmd)
+
+	return &readOnlyCmd
+}
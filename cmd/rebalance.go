@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+func rebalanceCmd() *cobra.Command {
+	var rebalanceCmd = cobra.Command{
+		Use:   "rebalance <organization> <root>",
+		Short: "Moves an organization to another data root",
+		Long: `Moves an organization to another data root, the data directory or one of
+the roots listed in "data.roots".  The organization is copied and verified
+before being mapped to the new root with a "data.root.<organization>" entry,
+and only then removed from the old one.  The server must be in maintenance
+mode ("gotas server maintenance on") while the organization is moved.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and root expected")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			if err := repository.MoveOrg(args[0], args[1]); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(struct {
+					Org  string `json:"org"`
+					Root string `json:"root"`
+				}{args[0], args[1]})
+			}
+
+			log.Infof("organization %q moved to %s", args[0], args[1])
+
+			return nil
+		},
+	}
+
+	return &rebalanceCmd
+}
@@ -10,7 +10,10 @@ import (
 func removeCmd() *cobra.Command {
 	removeCmd := cobra.Command{
 		Use:   "remove",
-		Short: "Deletes an organization or user.  Permanently.",
+		Short: "Deletes an organization or user.",
+		Long: `Marks an organization or user as deleted.  The data is kept on disk, and can
+be recovered using "restore", until it is permanently removed using "purge".
+Deleted users can't sync anymore.`,
 		Run: func(_ *cobra.Command, _ []string) {
 			log.Info("not implemented")
 		},
@@ -19,7 +22,7 @@ func removeCmd() *cobra.Command {
 	removeOrgCmd := cobra.Command{
 		Aliases: []string{"o"},
 		Use:     "org <organization>",
-		Short:   "Deletes a new organization",
+		Short:   "Deletes an organization",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 1 {
 				if err := cmd.Usage(); err != nil {
@@ -41,6 +44,10 @@ func removeCmd() *cobra.Command {
 				return err
 			}
 
+			if jsonMode(cmd) {
+				return printResult(orgResult{Org: orgName})
+			}
+
 			log.Infof("removed organization %q", orgName)
 
 			return nil
@@ -50,7 +57,7 @@ func removeCmd() *cobra.Command {
 	removeUserCmd := cobra.Command{
 		Aliases: []string{"u"},
 		Use:     "user <organization> <user>",
-		Short:   "Deletes a new user.  Users are identified by uuid, not name",
+		Short:   "Deletes a user.  Users are identified by uuid, not name",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 2 {
 				if err := cmd.Usage(); err != nil {
@@ -72,7 +79,10 @@ func removeCmd() *cobra.Command {
 				return err
 			}
 
-			log.Infof("New user key: %v", userName)
+			if jsonMode(cmd) {
+				return printResult(userResult{Org: orgName, Key: userName})
+			}
+
 			log.Infof("removed user %q from organization %q", userName, orgName)
 
 			return nil
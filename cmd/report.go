@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// burndownWidth is the width of the pending tasks bars.
+const burndownWidth = 40
+
+// burndownResult is the JSON output of the burndown report.
+type burndownResult struct {
+	Org  string             `json:"org"`
+	User string             `json:"user"`
+	Days []task.BurndownDay `json:"days"`
+}
+
+func reportCmd() *cobra.Command {
+	var reportCmd = cobra.Command{
+		Use:   "report",
+		Short: "Reports the users activity.",
+	}
+
+	var since string
+	var burndownCmd = cobra.Command{
+		Use:   "burndown <organization> <user>",
+		Short: "Shows the tasks created, completed and pending every day",
+		Long: `Shows the tasks created and completed every day, by their entry and end dates,
+and the ones still pending at the end of the day, for the --since period.  The
+user can be given by either its name or key.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and user name or key expected")
+			}
+
+			period, err := task.ParseReportPeriod(since)
+			if err != nil {
+				return err
+			}
+
+			dataDir := cmd.Flag(dataFlag).Value.String()
+			repository, err := repo.OpenRepository(dataDir)
+			if err != nil {
+				return err
+			}
+
+			user, err := findUser(repository, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			data, err := repo.NewDefaultReadAppender(dataDir).Read(user)
+			if err != nil {
+				return err
+			}
+
+			now := time.Now()
+			days, err := task.Burndown(data, now.Add(-period), now)
+			if err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(burndownResult{Org: user.Org.Name, User: user.Name, Days: days})
+			}
+			return printBurndown(os.Stdout, days)
+		},
+	}
+	burndownCmd.Flags().StringVar(&since, "since", "30d", "Period reported, e.g. 30d, 4w or 36h")
+
+	reportCmd.AddCommand(&burndownCmd)
+
+	return &reportCmd
+}
+
+// printBurndown writes the report as a table, with a bar showing the pending
+// tasks.
+func printBurndown(w io.Writer, days []task.BurndownDay) error {
+	most := 0
+	for _, d := range days {
+		if d.Pending > most {
+			most = d.Pending
+		}
+	}
+
+	out := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "DAY\tCREATED\tCOMPLETED\tPENDING\t")
+	for _, d := range days {
+		bar := 0
+		if most > 0 {
+			bar = (d.Pending*burndownWidth + most - 1) / most
+		}
+		fmt.Fprintf(out, "%s\t%d\t%d\t%d\t%s\n", d.Day, d.Created, d.Completed, d.Pending, strings.Repeat("#", bar))
+	}
+	return out.Flush()
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// resetResult is the JSON output of the reset command.
+type resetResult struct {
+	userResult
+	KeepLatest bool   `json:"keep_latest"`
+	Backup     string `json:"backup"`
+}
+
+func resetCmd() *cobra.Command {
+	var resetCmd = cobra.Command{
+		Use:   "reset",
+		Short: "Resets the data of a user.",
+		Long: `Truncates the transactions of a user, or of its group, after backing up its
+data to the "backups" folder of the repository.  With --keep-latest the
+latest version of every task is kept as a fresh baseline, otherwise the user
+starts from an empty task list.  Either way the clients have to sync from
+scratch, e.g. "task sync init".  The repository must be in maintenance mode.`,
+	}
+
+	var keepLatest, yes bool
+	resetUserCmd := cobra.Command{
+		Aliases: []string{"u"},
+		Use:     "user <organization> <user>",
+		Short:   "Resets the data of a user, identified by name or key",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and user name or key expected")
+			}
+			if jsonMode(cmd) && !yes {
+				return fmt.Errorf("--yes expected with the JSON output")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			user, err := findUser(repository, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			if !yes {
+				shared := ""
+				if user.Group != "" {
+					shared = fmt.Sprintf(", shared with the members of group %q,", user.Group)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "The data of user %q%s will be reset. Continue? [y/N] ", user.Name, shared)
+				answer, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+				if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+					log.Info("reset cancelled")
+					return nil
+				}
+			}
+
+			backup, err := repository.ResetUser(args[0], user.Key, keepLatest)
+			if err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(resetResult{
+					userResult: userResult{Org: args[0], Name: user.Name, Key: user.Key},
+					KeepLatest: keepLatest,
+					Backup:     backup,
+				})
+			}
+
+			if backup == "" {
+				log.Infof("user %q from organization %q has no data to reset", user.Name, args[0])
+			} else {
+				log.Infof("reset user %q from organization %q, backup at %v", user.Name, args[0], backup)
+			}
+
+			return nil
+		},
+	}
+	resetUserCmd.Flags().BoolVar(&keepLatest, "keep-latest", false, "Keeps the latest version of every task as a fresh baseline")
+	resetUserCmd.Flags().BoolVarP(&yes, "yes", "y", false, "Resets without asking for confirmation")
+
+	resetCmd.AddCommand(&resetUserCmd)
+
+	return &resetCmd
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+func restoreCmd() *cobra.Command {
+	restoreCmd := cobra.Command{
+		Use:   "restore",
+		Short: "Restores a deleted organization or user.",
+		Run: func(_ *cobra.Command, _ []string) {
+			log.Info("not implemented")
+		},
+	}
+
+	restoreOrgCmd := cobra.Command{
+		Aliases: []string{"o"},
+		Use:     "org <organization>",
+		Short:   "Restores a deleted organization",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization name expected")
+			}
+			orgName := args[0]
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			if err := repository.RestoreOrg(orgName); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(orgResult{Org: orgName})
+			}
+
+			log.Infof("restored organization %q", orgName)
+
+			return nil
+		},
+	}
+
+	restoreUserCmd := cobra.Command{
+		Aliases: []string{"u"},
+		Use:     "user <organization> <user>",
+		Short:   "Restores a deleted user.  Users are identified by uuid, not name",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and user key expected")
+			}
+			orgName := args[0]
+			userKey := args[1]
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			if err := repository.RestoreUser(orgName, userKey); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(userResult{Org: orgName, Key: userKey})
+			}
+
+			log.Infof("restored user %q from organization %q", userKey, orgName)
+
+			return nil
+		},
+	}
+
+	restoreCmd.AddCommand(&restoreOrgCmd)
+	restoreCmd.AddCommand(&restoreUserCmd)
+
+	return &restoreCmd
+}
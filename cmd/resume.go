@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/repo"
 )
 
 // resumeCmd represents the resume command
@@ -14,5 +17,43 @@ func resumeCmd() *cobra.Command {
 		},
 	}
 
+	resumeUserCmd := cobra.Command{
+		Aliases: []string{"u"},
+		Use:     "user <organization> <user>",
+		Short:   "Resumes a suspended user, identified by name or key",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and user name or key expected")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			user, err := findUser(repository, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			if err := repository.ResumeUser(args[0], user.Key); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(userResult{Org: args[0], Name: user.Name, Key: user.Key})
+			}
+
+			log.Infof("resumed user %q from organization %q", user.Name, args[0])
+
+			return nil
+		},
+	}
+
+	resumeCmd.AddCommand(&resumeUserCmd)
+
 	return &resumeCmd
 }
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+func roleCmd() *cobra.Command {
+	var roleCmd = cobra.Command{
+		Use:   "role <organization> <user> <role>",
+		Short: "Changes the role of a user",
+		Long: `Changes the role of a user, identified by name or key, to one of:
+
+  user          only syncs its own tasks, the default
+  org-admin     manages the users of its organization through the REST API
+  server-admin  manages the users of every organization through the REST API`,
+		ValidArgs: []string{string(auth.RoleUser), string(auth.RoleOrgAdmin), string(auth.RoleServerAdmin)},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 3 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization, user name or key and role expected")
+			}
+
+			role, err := auth.ParseRole(args[2])
+			if err != nil {
+				return err
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			user, err := findUser(repository, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			if err := repository.SetRole(args[0], user.Key, role); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(struct {
+					userResult
+					Role auth.Role `json:"role"`
+				}{userResult{Org: args[0], Name: user.Name, Key: user.Key}, role})
+			}
+
+			log.Infof("user %q from organization %q is now %s", user.Name, args[0], role)
+
+			return nil
+		},
+	}
+
+	return &roleCmd
+}
@@ -8,22 +8,27 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/szaffarano/gotas/logger"
+	"github.com/szaffarano/gotas/task"
 )
 
 const (
 	taskdDataVariableName = "TASKDDATA"
 
-	dataFlag    = "data"
-	quietFlag   = "quit"
-	verboseFlag = "verbose"
+	dataFlag      = "data"
+	outputFlag    = "output"
+	quietFlag     = "quiet"
+	verboseFlag   = "verbose"
+	logFormatFlag = "log-format"
 )
 
 var log *logger.Logger
 
 type flags struct {
-	quiet    bool
-	verbose  bool
-	taskData string
+	quiet     bool
+	verbose   bool
+	taskData  string
+	output    string
+	logFormat string
 }
 
 // Version is the app version
@@ -46,6 +51,7 @@ func Execute(version Version) {
 	if err := json.NewEncoder(&buffer).Encode(version); err != nil {
 		panic("Error building version")
 	}
+	task.Build = task.BuildInfo{Version: version.Version, Commit: version.Commit, Date: version.Date}
 
 	// rootCmd represents the base command when called without any subcommands
 	rootCmd := &cobra.Command{
@@ -57,16 +63,33 @@ func Execute(version Version) {
 		Long: `Gotas aims to implement a taskwarrior server (aka taskd) using Go 
 programming language`,
 		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			if err := validateOutput(flags.output); err != nil {
+				return err
+			}
+
+			if err := logger.SetFormat(flags.logFormat); err != nil {
+				return err
+			}
+
+			if flags.quiet && flags.verbose {
+				return fmt.Errorf("either --%s or --%s expected, not both", quietFlag, verboseFlag)
+			} else if flags.quiet {
+				logger.SetLevel(logger.WarnLevel)
+			} else if flags.verbose {
+				logger.SetLevel(logger.DebugLevel)
+			}
+
 			if skipTaskDataValidation(cmd) {
 				return nil
 			}
 
-			if flags.taskData == "" {
-				value, ok := os.LookupEnv(taskdDataVariableName)
-				if !ok {
-					return fmt.Errorf("you have to define either $%s variable or data flag", taskdDataVariableName)
-				}
-				flags.taskData = value
+			dataDir, err := resolveDataDir(flags.taskData)
+			if err != nil {
+				return err
+			}
+			// commands read the data directory from the flag
+			if err := cmd.Flag(dataFlag).Value.Set(dataDir); err != nil {
+				return err
 			}
 			log.Infof("==== gotas %s - %s - %s ====", version.Version, version.Commit, version.Date)
 			return nil
@@ -75,7 +98,7 @@ programming language`,
 
 	rootCmd.
 		PersistentFlags().
-		BoolVarP(&flags.quiet, quietFlag, "q", false, "Turns off verbose output")
+		BoolVarP(&flags.quiet, quietFlag, "q", false, "Only logs warnings and errors")
 
 	rootCmd.
 		PersistentFlags().
@@ -83,23 +106,94 @@ programming language`,
 
 	rootCmd.
 		PersistentFlags().
-		StringVar(&flags.taskData, dataFlag, "", "Data directory (default is $HOME/.gotas")
+		StringVar(&flags.logFormat, logFormatFlag, logger.ConsoleFormat, "Log format, either console or json")
+
+	rootCmd.
+		PersistentFlags().
+		StringVar(&flags.taskData, dataFlag, "", "Data directory, overrides $TASKDDATA")
+
+	rootCmd.
+		PersistentFlags().
+		StringVar(&flags.output, outputFlag, textOutput, "Output format, either text or json.  Logs are always written to stderr")
 
 	rootCmd.AddCommand(addCmd())
+	rootCmd.AddCommand(archiveCmd())
+	rootCmd.AddCommand(benchCmd())
+	rootCmd.AddCommand(calendarCmd())
 	rootCmd.AddCommand(configCmd())
+	rootCmd.AddCommand(debugCmd())
+	rootCmd.AddCommand(digestCmd())
+	rootCmd.AddCommand(doctorCmd())
+	rootCmd.AddCommand(fsckCmd())
+	rootCmd.AddCommand(gcCmd())
+	rootCmd.AddCommand(groupCmd())
 	rootCmd.AddCommand(initCmd())
+	rootCmd.AddCommand(listCmd())
+	rootCmd.AddCommand(migrateCmd())
+	rootCmd.AddCommand(purgeCmd())
+	rootCmd.AddCommand(readOnlyCmd())
+	rootCmd.AddCommand(rebalanceCmd())
 	rootCmd.AddCommand(removeCmd())
+	rootCmd.AddCommand(reportCmd())
+	rootCmd.AddCommand(resetCmd())
+	rootCmd.AddCommand(restoreCmd())
+	rootCmd.AddCommand(roleCmd())
 	rootCmd.AddCommand(resumeCmd())
-	rootCmd.AddCommand(serverCmd())
+	rootCmd.AddCommand(serverCmd(version))
+	rootCmd.AddCommand(storageStatsCmd())
 	rootCmd.AddCommand(suspendCmd())
+	rootCmd.AddCommand(championCmd())
+	rootCmd.AddCommand(tasksCmd())
+	rootCmd.AddCommand(versionCmd(version))
 	rootCmd.AddCommand(pkiCmd())
 
-	cobra.CheckErr(rootCmd.Execute())
+	if err := rootCmd.Execute(); err != nil {
+		code, exit := classify(err)
+		if flags.output == jsonOutput {
+			if err := printResult(errorResult{Error: err.Error(), Code: code}); err != nil {
+				log.Errorf("Error printing result: %v", err)
+			}
+		} else {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(exit)
+	}
+}
+
+// resolveDataDir returns the data directory following the taskd precedence:
+// the data flag and then the $TASKDDATA variable.  A warning is logged when
+// both are set to different directories.
+func resolveDataDir(flagValue string) (string, error) {
+	dataDir, source := flagValue, "--"+dataFlag
+	envValue, _ := os.LookupEnv(taskdDataVariableName)
+	if dataDir == "" {
+		dataDir, source = envValue, "$"+taskdDataVariableName
+	}
+
+	if dataDir == "" {
+		return "", fmt.Errorf("you have to define either $%s variable or data flag", taskdDataVariableName)
+	}
+
+	info, err := os.Stat(dataDir)
+	if err != nil {
+		return "", fmt.Errorf("data directory %q (from %s): %v", dataDir, source, err)
+	} else if !info.IsDir() {
+		return "", fmt.Errorf("data directory %q (from %s): directory expected", dataDir, source)
+	}
+
+	if envValue != "" && envValue != dataDir {
+		if envInfo, err := os.Stat(envValue); err != nil || !os.SameFile(info, envInfo) {
+			log.Warnf("Using --%s %q instead of $%s %q", dataFlag, dataDir, taskdDataVariableName, envValue)
+		}
+	}
+
+	return dataDir, nil
 }
 
 func skipTaskDataValidation(cmd *cobra.Command) bool {
 	for {
-		if cmd.Name() == "pki" {
+		// the version command only loads the configuration to query a server
+		if cmd.Name() == "pki" || cmd.Name() == "version" {
 			return true
 		} else if cmd.HasParent() {
 			cmd = cmd.Parent()
@@ -1,33 +1,303 @@
 package cmd
 
 import (
-	"path/filepath"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/szaffarano/gotas/config"
+	"github.com/szaffarano/gotas/logger"
 	"github.com/szaffarano/gotas/task"
+	"github.com/szaffarano/gotas/task/repo"
 )
 
-func serverCmd() *cobra.Command {
+func serverCmd(version Version) *cobra.Command {
 	daemon := false
+	var settings []string
+	var strict bool
+	var insecureDev, allowAnyClient bool
+	var ephemeral bool
 	var serverCmd = cobra.Command{
 		Use:   "server",
 		Short: "Runs the server",
+		Long: `Runs the server using the configuration file stored in the data directory.
+The file is authoritative, --set only fills the options missing in it.
+
+Started as root, the server drops its privileges to "run.user" and "run.group"
+once the ports are bound, so the data directory must be writable by that user.
+With "sandbox" enabled the process can only access the data directory, the
+certificate directories and the files needed to resolve names afterwards
+(Linux landlock).  The system CAs are loaded before.
+
+The executables found in "hooks.dir" (<data>/hooks by default) named after the
+events on-org-added, on-user-added, on-user-removed and on-sync-complete are
+run with the event as JSON in their standard input, and killed after
+"hooks.timeout" (10s by default).  The sandbox prevents them from running.
+
+For local development only, --insecure-dev ignores the configured certificates
+and generates an ephemeral CA, server and client certificates in a temporary
+directory, logging the client settings to connect.  Adding
+--insecure-dev-allow-any-client also disables the client certificates
+verification.
+
+With --ephemeral the organizations, users and tasks are kept in memory and lost
+on exit, the data directory only provides the configuration.  A "Demo"
+organization with a "demo" user is created, and its credentials logged.`,
 		RunE: func(cmd *cobra.Command, _ []string) error {
-			dataDir := cmd.Flag(dataFlag).Value.String()
+			overrides := make(map[string]string)
+			for _, o := range settings {
+				kv := strings.SplitN(o, "=", 2)
+				if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+					return fmt.Errorf("invalid setting %q, key=value expected", o)
+				}
+				overrides[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
 
-			configFilePath := filepath.Join(dataDir, "config")
-			cfg, err := config.Load(configFilePath)
-			if err != nil {
-				return err
+			if allowAnyClient && !insecureDev {
+				return fmt.Errorf("--insecure-dev-allow-any-client requires --insecure-dev")
+			}
+			var dev *devCertificates
+			var devDir string
+			if insecureDev {
+				var err error
+				if devDir, err = os.MkdirTemp("", "gotas-insecure-dev"); err != nil {
+					return err
+				}
+				defer os.RemoveAll(devDir)
+			}
+
+			// the configuration is reloaded while the server runs
+			load := func() (config.Config, error) {
+				cfg, err := task.LoadConfig(cmd.Flag(dataFlag).Value.String(), overrides, strict)
+				if err != nil {
+					return config.Config{}, err
+				}
+
+				if insecureDev {
+					// the certificates are created once, with the first load
+					if dev == nil {
+						if dev, err = newDevCertificates(devDir, cfg.Get(task.BindAddress)); err != nil {
+							return config.Config{}, fmt.Errorf("creating the development certificates: %v", err)
+						}
+						dev.warn(cfg.Get(task.BindAddress), allowAnyClient)
+					}
+					dev.apply(&cfg, allowAnyClient)
+				}
+
+				// the command line flags take precedence over the configuration
+				if !cmd.Flag(verboseFlag).Changed && !cmd.Flag(quietFlag).Changed {
+					verbose, _, err := cfg.LookupBool(task.Verbose)
+					if err != nil {
+						return config.Config{}, err
+					}
+					if verbose {
+						logger.SetLevel(logger.DebugLevel)
+					} else {
+						logger.SetLevel(logger.InfoLevel)
+					}
+				}
+
+				if cfg.Get(task.ServerIdentity) == "" {
+					cfg.Set(task.ServerIdentity, fmt.Sprintf("%s %s", task.DefaultIdentity, version.Version))
+				}
+
+				return cfg, nil
+			}
+
+			if ephemeral {
+				memory, err := demoRepository()
+				if err != nil {
+					return err
+				}
+				return task.ServeEphemeral(load, memory)
 			}
 
-			return task.Serve(cfg)
+			return task.Serve(load)
 		},
 	}
 
 	// TODO implement -d flag
 	serverCmd.Flags().BoolVarP(&daemon, "daemon", "d", false, "Runs server as a daemon")
 
+	serverCmd.Flags().StringArrayVar(&settings, "set", nil, "Sets a key=value option missing in the configuration file, can be repeated")
+
+	serverCmd.Flags().BoolVar(&strict, "strict", false, "Fails if the configuration file has unknown options")
+
+	serverCmd.Flags().BoolVar(&insecureDev, "insecure-dev", false, "Uses ephemeral self-signed certificates, only for local development")
+	serverCmd.Flags().BoolVar(&allowAnyClient, "insecure-dev-allow-any-client", false, "Accepts any client certificate, requires --insecure-dev")
+
+	serverCmd.Flags().BoolVar(&ephemeral, "ephemeral", false, "Keeps the organizations, users and tasks in memory, lost on exit")
+
+	serverCmd.AddCommand(maintenanceCmd())
+	serverCmd.AddCommand(statsCmd())
+
 	return &serverCmd
 }
+
+// demoRepository returns an in-memory repository with a demo user, logging
+// its credentials.
+func demoRepository() (*repo.MemoryRepository, error) {
+	memory := repo.NewMemoryRepository()
+	if _, err := memory.NewOrg("Demo"); err != nil {
+		return nil, err
+	}
+	user, err := memory.AddUser("Demo", "demo")
+	if err != nil {
+		return nil, err
+	}
+
+	log.Warn("EPHEMERAL MODE: the organizations, users and tasks are kept in memory and lost on exit")
+	log.Infof("  taskd.credentials=%s/%s/%s", user.Org.Name, user.Name, user.Key)
+
+	return memory, nil
+}
+
+func maintenanceCmd() *cobra.Command {
+	var maintenanceCmd = cobra.Command{
+		Use:   "maintenance <on|off>",
+		Short: "Turns the maintenance mode on or off",
+		Long: `While in maintenance mode the server keeps running but rejects sync requests
+with a 420 code, so operators can safely run backups or clean up the data.`,
+		ValidArgs: []string{"on", "off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("either on or off expected")
+			}
+
+			dataDir := cmd.Flag(dataFlag).Value.String()
+			repository, err := repo.OpenRepository(dataDir)
+			if err != nil {
+				return err
+			}
+
+			if err := repository.SetMaintenance(args[0] == "on"); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(struct {
+					Maintenance bool `json:"maintenance"`
+				}{args[0] == "on"})
+			}
+
+			log.Infof("Maintenance mode turned %s", args[0])
+
+			return nil
+		},
+	}
+
+	return &maintenanceCmd
+}
+
+func statsCmd() *cobra.Command {
+	var statsCmd = cobra.Command{
+		Use:   "stats",
+		Short: "Shows the statistics of the running server",
+		Long: `Queries the running server through the admin socket, configured with
+"admin.socket", for its uptime, number of requests, latencies and the
+scheduled jobs runs, along with the syncs totals kept across restarts.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := task.LoadConfig(cmd.Flag(dataFlag).Value.String(), nil, false)
+			if err != nil {
+				return err
+			}
+			settings, err := task.NewSettings(cfg)
+			if err != nil {
+				return err
+			}
+
+			stats, err := task.QueryStats(settings.AdminSocket)
+			if err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(stats)
+			}
+
+			codes := make([]string, 0, len(stats.Codes))
+			for code, count := range stats.Codes {
+				codes = append(codes, fmt.Sprintf("%s=%d", code, count))
+			}
+			sort.Strings(codes)
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "Started\t%s\n", stats.Start.Local().Format(time.RFC3339))
+			fmt.Fprintf(tw, "Uptime\t%s\n", stats.Uptime.Truncate(time.Second))
+			fmt.Fprintf(tw, "Requests\t%d\n", stats.Requests)
+			fmt.Fprintf(tw, "Codes\t%s\n", strings.Join(codes, " "))
+			fmt.Fprintf(tw, "Average latency\t%s\n", stats.AvgLatency)
+			fmt.Fprintf(tw, "p95 latency\t%s\n", stats.P95Latency)
+			fmt.Fprintf(tw, "p99 latency\t%s\n", stats.P99Latency)
+			fmt.Fprintf(tw, "Queue\t%d waiting, %d active, %d rejected\n", stats.Queue.Waiting, stats.Queue.Active, stats.Queue.Rejected)
+			if memory := stats.Memory; memory != nil {
+				fmt.Fprintf(tw, "Memory\t%d of %d bytes in use, peak %d, %d rejected\n", memory.InUse, memory.Budget, memory.Peak, memory.Rejected)
+			}
+			totals := stats.Totals
+			fmt.Fprintf(tw, "Syncs since %s\t%d, %d bytes received, %d sent\n", totals.Since.Local().Format(time.RFC3339), totals.Syncs, totals.BytesIn, totals.BytesOut)
+			orgs := make([]string, 0, len(totals.Orgs))
+			for org := range totals.Orgs {
+				orgs = append(orgs, org)
+			}
+			sort.Strings(orgs)
+			for _, org := range orgs {
+				activity := totals.Orgs[org]
+				fmt.Fprintf(tw, "Org %s\t%d syncs, %d bytes received, %d sent, last %s\n",
+					org, activity.Syncs, activity.BytesIn, activity.BytesOut, activity.LastSync.Local().Format(time.RFC3339))
+			}
+			fmt.Fprintf(tw, "Full handshakes\t%d (average %s)\n", stats.Handshakes, stats.AvgHandshake)
+			fmt.Fprintf(tw, "Resumed handshakes\t%d (average %s)\n", stats.Resumed, stats.AvgResumedHandshake)
+			for _, job := range stats.Jobs {
+				status := "never run"
+				if !job.LastRun.IsZero() {
+					status = fmt.Sprintf("last %s in %s", job.LastRun.Local().Format(time.RFC3339), job.LastDuration)
+				}
+				if job.LastError != "" {
+					status += ", failed: " + job.LastError
+				}
+				fmt.Fprintf(tw, "Job %s\t%q, %d runs, %d failures, %d skipped, %s, next %s\n",
+					job.Name, job.Schedule, job.Runs, job.Failures, job.Skipped, status, job.NextRun.Local().Format(time.RFC3339))
+			}
+			return tw.Flush()
+		},
+	}
+
+	statsCmd.AddCommand(statsResetCmd())
+
+	return &statsCmd
+}
+
+func statsResetCmd() *cobra.Command {
+	var statsResetCmd = cobra.Command{
+		Use:   "reset",
+		Short: "Resets the statistics totals",
+		Long: `Starts counting the syncs totals kept across restarts again.  The running
+server is reset through the admin socket, otherwise the totals saved in the
+data directory are removed.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := task.LoadConfig(cmd.Flag(dataFlag).Value.String(), nil, false)
+			if err != nil {
+				return err
+			}
+			settings, err := task.NewSettings(cfg)
+			if err != nil {
+				return err
+			}
+
+			if err := task.ResetStats(settings.Root, settings.AdminSocket); err != nil {
+				return err
+			}
+			log.Info("Statistics totals reset")
+			return nil
+		},
+	}
+
+	return &statsResetCmd
+}
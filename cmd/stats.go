@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task"
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// userStats is the usage of a user in the stats JSON output.  The members
+// of a group share their usage.
+type userStats struct {
+	Name     string         `json:"name"`
+	Key      string         `json:"key"`
+	Group    string         `json:"group,omitempty"`
+	Deleted  bool           `json:"deleted"`
+	LastSync *repo.LastSync `json:"last_sync,omitempty"`
+	task.Usage
+}
+
+// statsResult is the JSON output of the stats command.  The organization
+// usage counts the data shared by a group once.
+type statsResult struct {
+	Org          string      `json:"org"`
+	Users        int         `json:"users"`
+	DeletedUsers int         `json:"deleted_users"`
+	Largest      []userStats `json:"largest"`
+	task.Usage
+}
+
+func storageStatsCmd() *cobra.Command {
+	var top int
+
+	var storageStatsCmd = cobra.Command{
+		Use:   "stats <organization> [user]",
+		Short: "Shows the storage used by an organization or user.",
+		Long: `Shows the number of users, the transactions size, the syncs that stored data,
+the tasks by status and the tasks created every month, by their entry date.
+For an organization, the --top users storing more data are listed along with
+their last sync.  The user can be given by either its name or key.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 || len(args) > 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and optionally user name or key expected")
+			}
+
+			if top < 0 {
+				return fmt.Errorf("positive number of users expected, got %d", top)
+			}
+
+			dataDir := cmd.Flag(dataFlag).Value.String()
+			repository, err := repo.OpenRepository(dataDir)
+			if err != nil {
+				return err
+			}
+
+			org, err := repository.GetOrg(args[0])
+			if err != nil {
+				return err
+			}
+			users := org.Users
+			if len(args) == 2 {
+				user, err := findUser(repository, args[0], args[1])
+				if err != nil {
+					return err
+				}
+				users = []auth.User{user}
+			}
+
+			ra := repo.NewDefaultReadAppender(dataDir)
+			result := statsResult{Org: org.Name, Largest: make([]userStats, 0), Usage: task.Usage{Tasks: make(map[string]int), Growth: make([]task.Growth, 0)}}
+			counted := make(map[string]bool)
+			for _, u := range users {
+				data, err := ra.Read(u)
+				if err != nil {
+					return err
+				}
+				usage, err := task.NewUsage(data)
+				if err != nil {
+					return fmt.Errorf("user %q: %v", u.Name, err)
+				}
+
+				stats := userStats{Name: u.Name, Key: u.Key, Group: u.Group, Deleted: !u.Deleted.IsZero(), Usage: usage}
+				if lastSync, ok, err := repository.LastSync(org.Name, u.Key); err != nil {
+					return err
+				} else if ok {
+					stats.LastSync = &lastSync
+				}
+
+				result.Users++
+				if stats.Deleted {
+					result.DeletedUsers++
+				}
+				if u.Group == "" || !counted[u.Group] {
+					counted[u.Group] = true
+					result.Usage.Add(usage)
+				}
+				result.Largest = append(result.Largest, stats)
+			}
+
+			sort.SliceStable(result.Largest, func(i, j int) bool {
+				return result.Largest[i].Size > result.Largest[j].Size
+			})
+			if len(result.Largest) > top {
+				result.Largest = result.Largest[:top]
+			}
+
+			if jsonMode(cmd) {
+				return printResult(result)
+			}
+			return printStats(os.Stdout, result)
+		},
+	}
+
+	storageStatsCmd.Flags().IntVar(&top, "top", 5, "Number of users storing more data listed")
+
+	return &storageStatsCmd
+}
+
+func printStats(w io.Writer, stats statsResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Organization\t%s\n", stats.Org)
+	fmt.Fprintf(tw, "Users\t%d (%d deleted)\n", stats.Users, stats.DeletedUsers)
+	fmt.Fprintf(tw, "Size\t%d bytes\n", stats.Size)
+	fmt.Fprintf(tw, "Transactions\t%d\n", stats.Transactions)
+	fmt.Fprintf(tw, "Tasks\t%s\n", formatStatuses(stats.Tasks))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tKEY\tGROUP\tSIZE\tTRANSACTIONS\tTASKS\tLAST SYNC")
+	for _, u := range stats.Largest {
+		lastSync := "never"
+		if u.LastSync != nil {
+			lastSync = formatDate(u.LastSync.Time)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n", u.Name, u.Key, u.Group, u.Size, u.Transactions, formatStatuses(u.Tasks), lastSync)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MONTH\tADDED")
+	for _, g := range stats.Growth {
+		fmt.Fprintf(tw, "%s\t%d\n", g.Month, g.Added)
+	}
+	return tw.Flush()
+}
+
+// formatStatuses formats the number of tasks by status, sorted by status.
+func formatStatuses(tasks map[string]int) string {
+	statuses := make([]string, 0, len(tasks))
+	for status := range tasks {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	for i, status := range statuses {
+		statuses[i] = fmt.Sprintf("%s %d", status, tasks[status])
+	}
+	if len(statuses) == 0 {
+		return "none"
+	}
+	return strings.Join(statuses, ", ")
+}
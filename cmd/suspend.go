@@ -1,17 +1,60 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task/repo"
 )
 
 func suspendCmd() *cobra.Command {
 	var suspendCmd = cobra.Command{
 		Use:   "suspend",
 		Short: "Suspends an organization or user.",
+		Long: `Suspends a user, which keeps its data but can't sync until resumed with
+"resume".`,
 		Run: func(_ *cobra.Command, _ []string) {
 			log.Info("not implemented")
 		},
 	}
 
+	suspendUserCmd := cobra.Command{
+		Aliases: []string{"u"},
+		Use:     "user <organization> <user>",
+		Short:   "Suspends a user, identified by name or key",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and user name or key expected")
+			}
+
+			repository, err := repo.OpenRepository(cmd.Flag(dataFlag).Value.String())
+			if err != nil {
+				return err
+			}
+
+			user, err := findUser(repository, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			if err := repository.SuspendUser(args[0], user.Key); err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(userResult{Org: args[0], Name: user.Name, Key: user.Key})
+			}
+
+			log.Infof("suspended user %q from organization %q", user.Name, args[0])
+
+			return nil
+		},
+	}
+
+	suspendCmd.AddCommand(&suspendUserCmd)
+
 	return &suspendCmd
 }
@@ -0,0 +1,365 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task"
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+const displayDateLayout = "2006-01-02 15:04"
+
+// userTask is a task along with its owner, used in the JSON output.
+type userTask struct {
+	Org  string          `json:"org"`
+	User string          `json:"user"`
+	Task json.RawMessage `json:"task"`
+}
+
+func tasksCmd() *cobra.Command {
+	var tasksCmd = cobra.Command{
+		Use:   "tasks",
+		Short: "Inspects the users tasks.",
+		Long: `Parses the users transactions to show the tasks stored in the server.  Meant
+to be used for debugging purposes.`,
+	}
+
+	var listCmd = cobra.Command{
+		Use:   "list <organization> <user> [filter...]",
+		Short: "Lists the latest state of the user tasks",
+		Long: `Lists the latest state of the user tasks, only the ones matching the filter
+if given, e.g. "status:pending project:Work +urgent due.before:2025-01-01".`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and user name or key expected")
+			}
+
+			filter, err := task.ParseFilter(strings.Join(args[2:], " "))
+			if err != nil {
+				return err
+			}
+
+			dataDir := cmd.Flag(dataFlag).Value.String()
+			repository, err := repo.OpenRepository(dataDir)
+			if err != nil {
+				return err
+			}
+
+			user, err := findUser(repository, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			data, err := repo.NewDefaultReadAppender(dataDir).Read(user)
+			if err != nil {
+				return err
+			}
+
+			all, err := task.LatestTasks(data)
+			if err != nil {
+				return err
+			}
+			var tasks []task.Task
+			for _, t := range all {
+				if filter.Match(t) {
+					tasks = append(tasks, t)
+				}
+			}
+
+			if jsonMode(cmd) {
+				return printTasksJSON(os.Stdout, user, tasks)
+			}
+			return printTasksTable(os.Stdout, tasks)
+		},
+	}
+
+	var showCmd = cobra.Command{
+		Use:   "show <uuid>",
+		Short: "Shows the latest state of a task",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("task uuid expected")
+			}
+
+			versions, user, err := findTask(cmd.Flag(dataFlag).Value.String(), args[0])
+			if err != nil {
+				return err
+			}
+			latest := versions[len(versions)-1]
+
+			if jsonMode(cmd) {
+				return printTasksJSON(os.Stdout, user, []task.Task{latest})
+			}
+
+			fmt.Printf("Organization: %s\nUser: %s (%s)\n\n", user.Org.Name, user.Name, user.Key)
+			return printTask(os.Stdout, latest)
+		},
+	}
+
+	var historyCmd = cobra.Command{
+		Use:   "history <uuid>",
+		Short: "Shows every stored version of a task",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("task uuid expected")
+			}
+
+			versions, user, err := findTask(cmd.Flag(dataFlag).Value.String(), args[0])
+			if err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printTasksJSON(os.Stdout, user, versions)
+			}
+
+			fmt.Printf("Organization: %s\nUser: %s (%s)\n", user.Org.Name, user.Name, user.Key)
+			var previous task.Task
+			for i, version := range versions {
+				fmt.Printf("\nVersion %d (%s)\n", i+1, formatDate(lastModified(version)))
+				if err := printChanges(os.Stdout, previous, version); err != nil {
+					return err
+				}
+				previous = version
+			}
+			return nil
+		},
+	}
+
+	var exportFormat string
+	var exportCmd = cobra.Command{
+		Use:   "export <organization> <user>",
+		Short: "Writes the latest state of the user tasks, one per line",
+		Long: `Writes the latest state of the user tasks to the standard output, one per
+line, either as JSON, the format used by the clients to sync, or as the format
+4 records ("ff4") read by the legacy taskserver tooling.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("organization and user name or key expected")
+			}
+			if exportFormat != "json" && exportFormat != "ff4" {
+				return fmt.Errorf("unknown format %q, either json or ff4 expected", exportFormat)
+			}
+
+			dataDir := cmd.Flag(dataFlag).Value.String()
+			repository, err := repo.OpenRepository(dataDir)
+			if err != nil {
+				return err
+			}
+
+			user, err := findUser(repository, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			data, err := repo.NewDefaultReadAppender(dataDir).Read(user)
+			if err != nil {
+				return err
+			}
+
+			tasks, err := task.LatestTasks(data)
+			if err != nil {
+				return err
+			}
+
+			return exportTasks(os.Stdout, tasks, exportFormat)
+		},
+	}
+	exportCmd.Flags().StringVar(&exportFormat, "format", "json", "Tasks format, either json or ff4")
+
+	tasksCmd.AddCommand(&listCmd)
+	tasksCmd.AddCommand(&showCmd)
+	tasksCmd.AddCommand(&historyCmd)
+	tasksCmd.AddCommand(&exportCmd)
+
+	return &tasksCmd
+}
+
+// exportTasks writes the tasks one per line in the given format.
+func exportTasks(w io.Writer, tasks []task.Task, format string) error {
+	for _, t := range tasks {
+		var line string
+		if format == "ff4" {
+			line = t.ComposeF4()
+		} else {
+			var err error
+			if line, err = t.ComposeJSON(); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findUser finds an organization user by either its name or key.
+func findUser(repository *repo.Repository, orgName, user string) (auth.User, error) {
+	org, err := repository.GetOrg(orgName)
+	if err != nil {
+		return auth.User{}, err
+	}
+
+	var found []auth.User
+	for _, u := range org.Users {
+		if u.Key == user {
+			return u, nil
+		} else if u.Name == user {
+			found = append(found, u)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return auth.User{}, fmt.Errorf("user %q not found in organization %q", user, orgName)
+	case 1:
+		return found[0], nil
+	default:
+		return auth.User{}, fmt.Errorf("more than one user named %q, use the user key instead", user)
+	}
+}
+
+// findTask looks for a task in every user data, returning all its versions
+// and the user owning it.
+func findTask(dataDir, uuid string) ([]task.Task, auth.User, error) {
+	repository, err := repo.OpenRepository(dataDir)
+	if err != nil {
+		return nil, auth.User{}, err
+	}
+	ra := repo.NewDefaultReadAppender(dataDir)
+
+	for _, org := range repository.Orgs() {
+		for _, user := range org.Users {
+			data, err := ra.Read(user)
+			if err != nil {
+				return nil, auth.User{}, err
+			}
+
+			var versions []task.Task
+			for _, line := range data {
+				if !strings.Contains(line, uuid) {
+					continue
+				}
+				t, err := task.NewTask(line)
+				if err != nil {
+					return nil, auth.User{}, err
+				}
+				if t.Get("uuid") == uuid {
+					versions = append(versions, t)
+				}
+			}
+
+			if len(versions) > 0 {
+				return versions, user, nil
+			}
+		}
+	}
+
+	return nil, auth.User{}, fmt.Errorf("task %q not found", uuid)
+}
+
+func printTasksTable(w io.Writer, tasks []task.Task) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "UUID\tSTATUS\tDUE\tPROJECT\tDESCRIPTION")
+	for _, t := range tasks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			t.Get("uuid"),
+			t.Get("status"),
+			formatDate(t.GetDate("due")),
+			t.Get("project"),
+			t.Get("description"))
+	}
+	return tw.Flush()
+}
+
+func printTasksJSON(w io.Writer, user auth.User, tasks []task.Task) error {
+	out := make([]userTask, 0, len(tasks))
+	for _, t := range tasks {
+		raw, err := t.ComposeJSON()
+		if err != nil {
+			return err
+		}
+		out = append(out, userTask{Org: user.Org.Name, User: user.Name, Task: json.RawMessage(raw)})
+	}
+
+	return printJSON(w, out)
+}
+
+func printTask(w io.Writer, t task.Task) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, name := range sortedAttrs(t) {
+		fmt.Fprintf(tw, "%s\t%s\n", name, formatAttr(t, name))
+	}
+	return tw.Flush()
+}
+
+// printChanges prints the attributes added, modified or removed between two
+// versions of a task.
+func printChanges(w io.Writer, before, after task.Task) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	changes := task.Diff(before, after)
+	for _, name := range changes.Added {
+		fmt.Fprintf(tw, "  + %s\t%s\n", name, formatAttr(after, name))
+	}
+	for _, name := range changes.Modified {
+		fmt.Fprintf(tw, "  ~ %s\t%s -> %s\n", name, formatAttr(before, name), formatAttr(after, name))
+	}
+	for _, name := range changes.Removed {
+		fmt.Fprintf(tw, "  - %s\t%s\n", name, formatAttr(before, name))
+	}
+
+	return tw.Flush()
+}
+
+func sortedAttrs(t task.Task) []string {
+	names := t.GetAttrNames()
+	sort.Strings(names)
+	return names
+}
+
+// formatAttr returns a human readable attribute value, dates are stored as
+// epochs.
+func formatAttr(t task.Task, name string) string {
+	switch name {
+	case "due", "end", "entry", "modified", "scheduled", "start", "until", "wait":
+		return formatDate(t.GetDate(name))
+	}
+	return t.Get(name)
+}
+
+func formatDate(d time.Time) string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Local().Format(displayDateLayout)
+}
+
+func lastModified(t task.Task) time.Time {
+	if t.Has("modified") {
+		return t.GetDate("modified")
+	}
+	return t.GetDate("entry")
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/szaffarano/gotas/task"
+	"github.com/szaffarano/gotas/task/transport"
+)
+
+func versionCmd(version Version) *cobra.Command {
+	var remote, credentials string
+	var caCert, clientCert, clientKey string
+
+	var versionCmd = cobra.Command{
+		Use:   "version",
+		Short: "Shows the version of gotas or of a running server",
+		Long: `Shows the version, commit and build date of gotas.  With --remote, asks a
+running server for its version, commit, build date, storage backend and
+supported protocol versions, sending a statistics request with the
+--credentials of a server admin.  The client certificate configured in the
+data directory is used unless overridden by the flags.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				if err := cmd.Usage(); err != nil {
+					return nil
+				}
+				return fmt.Errorf("no arguments expected")
+			}
+
+			if remote == "" {
+				if jsonMode(cmd) {
+					return printResult(version)
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintf(tw, "Version\t%s\n", version.Version)
+				fmt.Fprintf(tw, "Commit\t%s\n", version.Commit)
+				fmt.Fprintf(tw, "Built\t%s\n", version.Date)
+				return tw.Flush()
+			}
+
+			creds, err := task.ParseCredentials(credentials)
+			if err != nil {
+				return err
+			}
+
+			clientCfg := transport.ClientConfig{CaCert: caCert, Cert: clientCert, Key: clientKey, Address: remote}
+			loadClientConfig(cmd, &clientCfg)
+
+			info, err := task.NewClient(clientCfg, creds, "gotas "+version.Version).Statistics()
+			if err != nil {
+				return err
+			}
+
+			if jsonMode(cmd) {
+				return printResult(info)
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "Version\t%s\n", info.Version)
+			fmt.Fprintf(tw, "Commit\t%s\n", info.Commit)
+			fmt.Fprintf(tw, "Built\t%s\n", info.Date)
+			fmt.Fprintf(tw, "Storage\t%s\n", info.Storage)
+			fmt.Fprintf(tw, "Protocols\t%s\n", strings.Join(info.Protocols, " "))
+			return tw.Flush()
+		},
+	}
+	versionCmd.Flags().StringVar(&remote, "remote", "", "Address of the server queried, host:port")
+	versionCmd.Flags().StringVar(&credentials, "credentials", "", "Server admin credentials, org/user/key, required by --remote")
+	versionCmd.Flags().StringVar(&caCert, "ca", "", "CA certificate, instead of the configured one")
+	versionCmd.Flags().StringVar(&clientCert, "cert", "", "Client certificate, instead of the configured one")
+	versionCmd.Flags().StringVar(&clientKey, "key", "", "Client key, instead of the configured one")
+
+	return &versionCmd
+}
+
+// loadClientConfig fills the certificates not given by the flags with the
+// ones configured in the data directory, if any.
+func loadClientConfig(cmd *cobra.Command, cfg *transport.ClientConfig) {
+	dataDir, err := resolveDataDir(cmd.Flag(dataFlag).Value.String())
+	if err != nil {
+		log.Warnf("Using only the flags, configuration not loaded: %v", err)
+		return
+	}
+
+	loaded, err := task.LoadConfig(dataDir, nil, false)
+	if err != nil {
+		log.Warnf("Using only the flags, configuration not loaded: %v", err)
+		return
+	}
+	if cfg.CaCert == "" {
+		cfg.CaCert = loaded.Get(task.CaCert)
+	}
+	if cfg.Cert == "" {
+		cfg.Cert = loaded.Get(task.ClientCert)
+	}
+	if cfg.Key == "" {
+		cfg.Key = loaded.Get(task.ClientKey)
+	}
+}
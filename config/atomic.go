@@ -0,0 +1,77 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	backupSuffix = ".bak"
+	tempSuffix   = ".tmp"
+)
+
+// rename replaces the configuration file with the new version, it's a
+// variable so tests can simulate a crash before the file is replaced.
+var rename = os.Rename
+
+// writeAtomic replaces the file content so a crash leaves either the old or
+// the new version, but never a partially written file.  The previous version
+// is kept in a backup file.
+func writeAtomic(path string, content []byte) error {
+	mode := os.FileMode(0666)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+
+		previous, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := writeSynced(path+backupSuffix, previous, mode); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	tempPath := path + tempSuffix
+	if err := writeSynced(tempPath, content, mode); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	if err := rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// writeSynced writes a file and flushes it to disk.
+func writeSynced(path string, content []byte, mode os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir flushes a directory so a rename survives a crash.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
@@ -1,10 +1,8 @@
 package config
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
-	"os"
 	"sort"
 	"strconv"
 	"strings"
@@ -14,6 +12,11 @@ import (
 type Config struct {
 	path   string
 	values map[string]string
+
+	// the loaded file, used to keep its comments and layout when saved
+	lines    []line
+	loaded   map[string]string
+	included map[string]string
 }
 
 // Set sets a new value in the configuration.  Overrides an existent value.
@@ -40,6 +43,48 @@ func (c *Config) Get(key string) string {
 	return c.values[key]
 }
 
+// Lookup returns the value associated to the given key and whether it
+// exists.
+func (c *Config) Lookup(key string) (string, bool) {
+	value, ok := c.values[key]
+	return value, ok
+}
+
+// LookupInt returns the value as integer associated to the given key and
+// whether it exists.  It fails if the value can't be parsed as a number.
+func (c *Config) LookupInt(key string) (int, bool, error) {
+	str, ok := c.values[key]
+	if !ok {
+		return 0, false, nil
+	}
+
+	value, err := strconv.Atoi(strings.TrimSpace(str))
+	if err != nil {
+		return 0, true, fmt.Errorf("%s: invalid number %q", key, str)
+	}
+	return value, true, nil
+}
+
+// LookupBool returns the value as a boolean associated to the given key and
+// whether it exists.  It fails if the value can't be parsed as a bool.
+func (c *Config) LookupBool(key string) (bool, bool, error) {
+	str, ok := c.values[key]
+	if !ok {
+		return false, false, nil
+	}
+
+	value, err := strconv.ParseBool(strings.TrimSpace(str))
+	if err != nil {
+		return false, true, fmt.Errorf("%s: invalid boolean %q", key, str)
+	}
+	return value, true, nil
+}
+
+// Keys returns the configuration keys sorted alphabetically.
+func (c *Config) Keys() []string {
+	return sortKeys(c.values)
+}
+
 // GetInt returns the value as integer associated to the given key or the zero
 // value (0) if it doesn't exist or the value can't be parsed as number.
 func (c *Config) GetInt(key string) (value int) {
@@ -73,58 +118,96 @@ func New(path string) (Config, error) {
 }
 
 // Load loads a configuration from a given file.  The file has to have pairs
-// of key=value lines.  Empty lines or starting with "#" will be ignored.
+// of key=value lines, split on the first "=".  Values can be double quoted to
+// keep leading or trailing spaces, span several lines or use the \", \\, \n,
+// \t and \r escape sequences.  An "include <file>" line loads another file,
+// relative to the current one.  Empty lines or starting with "#" will be
+// ignored.
 func Load(path string) (Config, error) {
-	cfg := Config{}
-	file, err := os.Open(path)
+	p, err := parseFile(path, 0)
 	if err != nil {
-		return cfg, fmt.Errorf("open file %v: %v", path, err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	values := make(map[string]string)
-	for scanner.Scan() {
-		line := scanner.Text()
-		// skip comments and blank lines
-		if strings.Trim(line, " ") != "" && !strings.HasPrefix(line, "#") {
-			splitted := strings.Split(line, "=")
-			if len(splitted) != 2 {
-				return cfg, fmt.Errorf("parse line: %v", line)
-			}
-
-			values[strings.TrimRight(splitted[0], " ")] = strings.TrimLeft(splitted[1], " ")
-		}
+		return Config{}, err
 	}
 
-	cfg.path = path
-	cfg.values = values
+	loaded := make(map[string]string, len(p.values))
+	for k, v := range p.values {
+		loaded[k] = v
+	}
+
+	return Config{
+		path:     path,
+		values:   p.values,
+		lines:    p.lines,
+		loaded:   loaded,
+		included: p.included,
+	}, nil
+}
+
+// LoadStrict loads a configuration like Load, but it fails if the file has a
+// key not included in known.  A known key ending in "*" matches every key
+// starting with its prefix.
+func LoadStrict(path string, known []string) (Config, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	for _, key := range cfg.Keys() {
+		if !isKnown(key, known) {
+			return Config{}, fmt.Errorf("%v: unknown key %q", path, key)
+		}
+	}
 
 	return cfg, nil
 }
 
+func isKnown(key string, known []string) bool {
+	for _, k := range known {
+		if k == key || (strings.HasSuffix(k, "*") && strings.HasPrefix(key, strings.TrimSuffix(k, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
 // Save stores the configuration in the file set when initialized.  In case it
 // fails because the configuration wasn't not properly initialized or there is
-// an error saving the file, it will return an error.
+// an error saving the file, it will return an error.  The file is replaced
+// atomically, keeping the previous version with a ".bak" suffix.
 func Save(config Config) error {
 	if config.path == "" {
 		return errors.New("uninitialized config")
 	}
 
-	file, err := os.Create(config.path)
-	if err != nil {
-		return fmt.Errorf("open file %v: %v", config.path, err)
+	var builder strings.Builder
+	written := make(map[string]bool)
+	for _, l := range config.lines {
+		value, ok := config.values[l.key]
+		switch {
+		case l.key == "" || (ok && value == config.loaded[l.key]):
+			// keep comments and unmodified entries as they were
+			builder.WriteString(l.text)
+		case ok:
+			fmt.Fprintf(&builder, "%s = %s", l.key, quote(value))
+		default:
+			continue
+		}
+		builder.WriteByte('\n')
+		written[l.key] = true
 	}
-	defer file.Close()
 
-	// sort the keys to serialize the values deterministically
-	var builder strings.Builder
+	// sort the new keys to serialize the values deterministically
 	for _, k := range sortKeys(config.values) {
-		fmt.Fprintf(&builder, "%s = %v\n", k, config.values[k])
+		if written[k] {
+			continue
+		}
+		if v, ok := config.included[k]; ok && v == config.values[k] {
+			continue
+		}
+		fmt.Fprintf(&builder, "%s = %s\n", k, quote(config.values[k]))
 	}
 
-	buffer := []byte(builder.String())
-	if _, err := file.Write(buffer); err != nil {
+	if err := writeAtomic(config.path, []byte(builder.String())); err != nil {
 		return fmt.Errorf("save file %v: %v", config.path, err)
 	}
 
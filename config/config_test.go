@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -97,6 +98,230 @@ func TestConfig(t *testing.T) {
 
 	})
 
+	t.Run("lookups", func(t *testing.T) {
+		cfg, err := Load(validConfigPath)
+		assert.Nil(t, err)
+
+		cases := []struct {
+			key    string
+			exists bool
+			fails  bool
+		}{
+			{"queue.size", true, false},
+			{"requst.limit", false, false},
+			{"trust", true, true},
+		}
+
+		for _, c := range cases {
+			t.Run(c.key, func(t *testing.T) {
+				_, ok, err := cfg.LookupInt(c.key)
+				assert.Equal(t, c.exists, ok)
+				assert.Equal(t, c.fails, err != nil)
+			})
+		}
+
+		value, ok, err := cfg.LookupInt("request.limit")
+		assert.Equal(t, 1048576, value)
+		assert.True(t, ok)
+		assert.Nil(t, err)
+
+		verbose, ok, err := cfg.LookupBool("verbose")
+		assert.True(t, verbose)
+		assert.True(t, ok)
+		assert.Nil(t, err)
+
+		_, ok, err = cfg.LookupBool("log")
+		assert.True(t, ok)
+		assert.NotNil(t, err)
+
+		_, ok = cfg.Lookup("ip.log")
+		assert.True(t, ok)
+		_, ok = cfg.Lookup("missing")
+		assert.False(t, ok)
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		cfg, err := Load(validConfigPath)
+		assert.Nil(t, err)
+
+		keys := cfg.Keys()
+		assert.Len(t, keys, 13)
+		assert.Equal(t, "ca.cert", keys[0])
+		assert.Equal(t, "verbose", keys[len(keys)-1])
+	})
+
+	t.Run("strict load", func(t *testing.T) {
+		known := []string{
+			"ca.cert", "confirmation", "ip.log", "log", "pid.file", "queue.size",
+			"request.limit", "root", "server", "trust", "verbose",
+		}
+
+		_, err := LoadStrict(validConfigPath, known)
+		assert.NotNil(t, err)
+
+		_, err = LoadStrict(validConfigPath, append(known, "server.*"))
+		assert.Nil(t, err)
+	})
+
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name     string
+		content  string
+		expected map[string]string
+		fails    bool
+	}{
+		{"split on the first equal", "token = YWJj==\n", map[string]string{"token": "YWJj=="}, false},
+		{"quoted values keep spaces", `motd = "  hello  "` + "\n", map[string]string{"motd": "  hello  "}, false},
+		{"escape sequences", `motd = "say \"hi\"\n\tbye\\"`, map[string]string{"motd": "say \"hi\"\n\tbye\\"}, false},
+		{"multiline values", "motd = \"first\nsecond\"\nother = x\n", map[string]string{"motd": "first\nsecond", "other": "x"}, false},
+		{"indented comments", "  # comment\nkey=value", map[string]string{"key": "value"}, false},
+		{"missing closing quote", "motd = \"hello\nother = x\n", nil, true},
+		{"invalid escape sequence", `motd = "\q"`, nil, true},
+		{"text after closing quote", `motd = "hello" world`, nil, true},
+		{"missing key", "= value", nil, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path, dir := mockConfig(t, c.content)
+			defer os.RemoveAll(dir)
+
+			cfg, err := Load(path)
+			if c.fails {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, c.expected, cfg.values)
+		})
+	}
+}
+
+func TestInclude(t *testing.T) {
+	path, dir := mockConfig(t, "root = /data\ninclude tls.conf\nserver = localhost:53589\n")
+	defer os.RemoveAll(dir)
+
+	tlsPath := filepath.Join(dir, "tls.conf")
+	assert.Nil(t, os.WriteFile(tlsPath, []byte("server.cert = cert.pem\nserver = 0.0.0.0:53589\n"), 0644))
+
+	cfg, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "cert.pem", cfg.Get("server.cert"))
+	assert.Equal(t, "localhost:53589", cfg.Get("server"))
+
+	t.Run("included entries are not saved", func(t *testing.T) {
+		assert.Nil(t, Save(cfg))
+
+		content, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, "root = /data\ninclude tls.conf\nserver = localhost:53589\n", string(content))
+	})
+
+	t.Run("include cycles fail", func(t *testing.T) {
+		assert.Nil(t, os.WriteFile(tlsPath, []byte("include config\n"), 0644))
+
+		_, err := Load(path)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("missing include fails", func(t *testing.T) {
+		assert.Nil(t, os.Remove(tlsPath))
+
+		_, err := Load(path)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestSaveKeepsLayout(t *testing.T) {
+	content := "# server settings\nserver   = localhost:53589\n\n# tls\nca.cert=ca.pem\ntoken = YWJj==\n"
+	path, dir := mockConfig(t, content)
+	defer os.RemoveAll(dir)
+
+	cfg, err := Load(path)
+	assert.Nil(t, err)
+
+	assert.Nil(t, Save(cfg))
+	saved, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, content, string(saved))
+
+	cfg.Set("ca.cert", "other.pem")
+	cfg.Set("motd", " hello\n")
+	assert.Nil(t, Save(cfg))
+
+	saved, err = os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "# server settings\nserver   = localhost:53589\n\n# tls\nca.cert = other.pem\ntoken = YWJj==\nmotd = \" hello\\n\"\n", string(saved))
+
+	reloaded, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.values, reloaded.values)
+}
+
+func TestAtomicSave(t *testing.T) {
+	path, dir := mockConfig(t, "key = first\n")
+	defer os.RemoveAll(dir)
+
+	cfg, err := Load(path)
+	assert.Nil(t, err)
+
+	t.Run("keeps a backup of the previous version", func(t *testing.T) {
+		cfg.Set("key", "second")
+		assert.Nil(t, Save(cfg))
+
+		assertContent(t, "key = second\n", path)
+		assertContent(t, "key = first\n", path+backupSuffix)
+		assert.NoFileExists(t, path+tempSuffix)
+	})
+
+	t.Run("keeps a single backup", func(t *testing.T) {
+		cfg.Set("key", "third")
+		assert.Nil(t, Save(cfg))
+
+		assertContent(t, "key = third\n", path)
+		assertContent(t, "key = second\n", path+backupSuffix)
+
+		backups, err := filepath.Glob(filepath.Join(dir, "*"+backupSuffix+"*"))
+		assert.Nil(t, err)
+		assert.Len(t, backups, 1)
+	})
+
+	t.Run("crash before rename keeps the previous version", func(t *testing.T) {
+		defer func(original func(string, string) error) { rename = original }(rename)
+		rename = func(string, string) error {
+			return errors.New("simulated crash")
+		}
+
+		cfg.Set("key", "fourth")
+		assert.NotNil(t, Save(cfg))
+
+		assertContent(t, "key = third\n", path)
+		assert.NoFileExists(t, path+tempSuffix)
+
+		reloaded, err := Load(path)
+		assert.Nil(t, err)
+		assert.Equal(t, "third", reloaded.Get("key"))
+	})
+
+	t.Run("keeps file permissions", func(t *testing.T) {
+		assert.Nil(t, os.Chmod(path, 0600))
+		assert.Nil(t, Save(cfg))
+
+		info, err := os.Stat(path)
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	})
+}
+
+func assertContent(t *testing.T, expected, path string) {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(content))
 }
 
 func assertConfig(t *testing.T, conf Config) {
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// includeDirective loads another configuration file, relative to the
+// including one, as if its entries were defined in its place.
+const includeDirective = "include"
+
+// maxIncludeDepth limits nested includes, it's a safety net against include
+// cycles.
+const maxIncludeDepth = 10
+
+// line is a line of a configuration file, kept to preserve the comments and
+// layout when the file is saved.  Quoted values can span several physical
+// lines, so text may contain new lines.
+type line struct {
+	text string
+	key  string
+}
+
+// parsed is the content of a configuration file.
+type parsed struct {
+	lines []line
+	// values are the resulting entries, including the included ones.
+	values map[string]string
+	// included are the entries defined in included files.
+	included map[string]string
+}
+
+func parseFile(path string, depth int) (parsed, error) {
+	if depth > maxIncludeDepth {
+		return parsed{}, fmt.Errorf("%v: too many nested includes", path)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return parsed{}, fmt.Errorf("open file %v: %v", path, err)
+	}
+
+	p := parsed{
+		values:   make(map[string]string),
+		included: make(map[string]string),
+	}
+
+	physical := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(content) == 0 {
+		physical = nil
+	}
+
+	for i := 0; i < len(physical); i++ {
+		raw := physical[i]
+		trimmed := strings.TrimSpace(raw)
+
+		// comments and blank lines
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			p.lines = append(p.lines, line{text: raw})
+			continue
+		}
+
+		if include, ok := includePath(trimmed); ok {
+			if !filepath.IsAbs(include) {
+				include = filepath.Join(filepath.Dir(path), include)
+			}
+			child, err := parseFile(include, depth+1)
+			if err != nil {
+				return parsed{}, err
+			}
+			for k, v := range child.values {
+				p.values[k] = v
+				p.included[k] = v
+			}
+			p.lines = append(p.lines, line{text: raw})
+			continue
+		}
+
+		idx := strings.IndexByte(raw, '=')
+		if idx == -1 {
+			return parsed{}, fmt.Errorf("parse line: %v", raw)
+		}
+		key := strings.TrimSpace(raw[:idx])
+		if key == "" {
+			return parsed{}, fmt.Errorf("parse line: %v", raw)
+		}
+
+		rest := strings.TrimSpace(raw[idx+1:])
+		value := rest
+		if strings.HasPrefix(rest, `"`) {
+			// the closing quote may be in a following line
+			for {
+				var done bool
+				if value, done, err = unquote(rest); err != nil {
+					return parsed{}, fmt.Errorf("parse line: %v: %v", raw, err)
+				} else if done {
+					break
+				} else if i+1 == len(physical) {
+					return parsed{}, fmt.Errorf("parse line: %v: missing closing quote", raw)
+				}
+				i++
+				raw += "\n" + physical[i]
+				rest += "\n" + physical[i]
+			}
+		}
+
+		p.values[key] = value
+		delete(p.included, key)
+		p.lines = append(p.lines, line{text: raw, key: key})
+	}
+
+	return p, nil
+}
+
+// includePath returns the file name if the line is an include directive.
+func includePath(trimmed string) (string, bool) {
+	fields := strings.SplitN(trimmed, " ", 2)
+	if len(fields) != 2 || fields[0] != includeDirective || strings.Contains(trimmed, "=") {
+		return "", false
+	}
+
+	name := strings.TrimSpace(fields[1])
+	if strings.HasPrefix(name, `"`) {
+		if unquoted, done, err := unquote(name); err == nil && done {
+			name = unquoted
+		}
+	}
+	return name, name != ""
+}
+
+// unquote parses a double quoted value.  It returns false if the closing
+// quote wasn't found.  Only spaces are allowed after the closing quote.
+func unquote(s string) (string, bool, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			if rest := strings.TrimSpace(s[i+1:]); rest != "" {
+				return "", false, fmt.Errorf("unexpected %q after closing quote", rest)
+			}
+			return b.String(), true, nil
+		case '\\':
+			if i+1 == len(s) {
+				return "", false, fmt.Errorf("unterminated escape sequence")
+			}
+			i++
+			switch s[i] {
+			case '\\', '"':
+				b.WriteByte(s[i])
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				return "", false, fmt.Errorf("invalid escape sequence \\%c", s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false, nil
+}
+
+// quote returns the value as written in a configuration file, quoting it only
+// if needed.
+func quote(value string) string {
+	needsQuotes := value != strings.TrimSpace(value) ||
+		strings.HasPrefix(value, `"`) ||
+		strings.ContainsAny(value, "\n\r\t")
+	if !needsQuotes {
+		return value
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+	return `"` + replacer.Replace(value) + `"`
+}
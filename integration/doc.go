@@ -0,0 +1,7 @@
+// Package integration holds the end to end tests running the official
+// Taskwarrior clients, in containers, against a gotas server.  They need
+// docker (or podman, see $GOTAS_CONTAINER_RUNTIME) and run only with the
+// integration build tag:
+//
+//	go test -tags integration ./integration/...
+package integration
@@ -0,0 +1,352 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/szaffarano/gotas/pki"
+)
+
+const org = "Public"
+
+// clientImages are the base images providing each Taskwarrior version.
+var clientImages = map[string]string{
+	"2.5": "debian:bullseye-slim",
+	"2.6": "debian:bookworm-slim",
+}
+
+// syncedAttributes are the attributes compared between the clients, the
+// rest (id, urgency...) are computed by each client.
+var syncedAttributes = []string{
+	"annotations", "depends", "description", "due", "end", "entry",
+	"modified", "priority", "project", "status", "tags", "uuid",
+}
+
+var (
+	runtime string
+	gotas   string
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	runtime = os.Getenv("GOTAS_CONTAINER_RUNTIME")
+	if runtime == "" {
+		runtime = "docker"
+	}
+	if _, err := exec.LookPath(runtime); err != nil {
+		fmt.Printf("skipping the integration tests, %v not found\n", runtime)
+		return 0
+	}
+
+	dir, err := os.MkdirTemp("", "gotas-integration")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	gotas = filepath.Join(dir, "gotas")
+	if out, err := exec.Command("go", "build", "-o", gotas, "..").CombinedOutput(); err != nil {
+		fmt.Printf("building gotas: %v\n%s", err, out)
+		return 1
+	}
+
+	for version, base := range clientImages {
+		build := exec.Command(runtime, "build", "--build-arg", "BASE="+base, "-t", image(version), "testdata")
+		if out, err := build.CombinedOutput(); err != nil {
+			fmt.Printf("building the task %v image: %v\n%s", version, err, out)
+			return 1
+		}
+	}
+
+	return m.Run()
+}
+
+func TestSyncAcrossVersions(t *testing.T) {
+	for _, first := range versions() {
+		for _, second := range versions() {
+			first, second := first, second
+			t.Run(first+" to "+second, func(t *testing.T) {
+				srv := startServer(t)
+				key := srv.addUser(t, "alice")
+				a := srv.newClient(t, first, "alice", key)
+				b := srv.newClient(t, second, "alice", key)
+
+				a.run(t, "add", "buy milk", "project:home", "+errand", "priority:H")
+				a.run(t, "add", "write report", "due:2030-01-15")
+				a.run(t, "1", "annotate", "semi-skimmed")
+				a.run(t, "sync")
+
+				b.run(t, "sync")
+				assert.Equal(t, a.export(t), b.export(t))
+
+				b.run(t, "1", "done")
+				b.run(t, "2", "modify", "+work", "project:office")
+				b.run(t, "add", "call mom")
+				b.run(t, "sync")
+
+				a.run(t, "sync")
+				tasks := a.export(t)
+				assert.Equal(t, tasks, b.export(t))
+				assert.Len(t, tasks, 3)
+			})
+		}
+	}
+}
+
+func TestMergeConcurrentChanges(t *testing.T) {
+	for _, first := range versions() {
+		for _, second := range versions() {
+			first, second := first, second
+			t.Run(first+" and "+second, func(t *testing.T) {
+				srv := startServer(t)
+				key := srv.addUser(t, "bob")
+				a := srv.newClient(t, first, "bob", key)
+				b := srv.newClient(t, second, "bob", key)
+
+				a.run(t, "add", "plan trip")
+				a.run(t, "sync")
+				b.run(t, "sync")
+
+				// both clients change the same task before syncing
+				a.run(t, "1", "modify", "project:travel")
+				b.run(t, "1", "modify", "priority:L")
+				a.run(t, "sync")
+				b.run(t, "sync")
+				a.run(t, "sync")
+
+				tasks := a.export(t)
+				assert.Equal(t, tasks, b.export(t))
+				require.Len(t, tasks, 1)
+				for _, task := range tasks {
+					assert.Equal(t, "travel", task["project"])
+					assert.Equal(t, "L", task["priority"])
+				}
+			})
+		}
+	}
+}
+
+func TestSyncIsolatesUsers(t *testing.T) {
+	srv := startServer(t)
+	versions := versions()
+	carol := srv.newClient(t, versions[0], "carol", srv.addUser(t, "carol"))
+	dave := srv.newClient(t, versions[len(versions)-1], "dave", srv.addUser(t, "dave"))
+
+	carol.run(t, "add", "private task")
+	carol.run(t, "sync")
+	dave.run(t, "sync")
+
+	assert.Len(t, carol.export(t), 1)
+	assert.Empty(t, dave.export(t))
+}
+
+// server is a running gotas instance with its PKI.
+type server struct {
+	data    string
+	pki     string
+	address string
+	ca      tls.Certificate
+}
+
+func startServer(t *testing.T) *server {
+	t.Helper()
+
+	srv := &server{data: t.TempDir(), pki: t.TempDir()}
+
+	caCert, caKey, err := pki.CreateCA(org, "gotas integration")
+	require.NoError(t, err)
+	srv.writePair(t, "ca", caCert, caKey)
+	srv.ca, err = tls.X509KeyPair(caCert, caKey)
+	require.NoError(t, err)
+
+	cert, key, err := pki.CreateServerCert(org, "localhost", srv.ca)
+	require.NoError(t, err)
+	srv.writePair(t, "server", cert, key)
+
+	gotasRun(t, "init", "--data", srv.data)
+	gotasRun(t, "add", "--data", srv.data, "org", org)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv.address = listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	cmd := exec.Command(gotas, "server", "--data", srv.data,
+		"--set", "server="+srv.address,
+		"--set", "ca.cert="+filepath.Join(srv.pki, "ca.pem"),
+		"--set", "server.cert="+filepath.Join(srv.pki, "server.pem"),
+		"--set", "server.key="+filepath.Join(srv.pki, "server.key"))
+	var logs bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &logs, &logs
+	require.NoError(t, cmd.Start())
+
+	t.Cleanup(func() {
+		_ = cmd.Process.Signal(os.Interrupt)
+		_ = cmd.Wait()
+		if t.Failed() {
+			t.Logf("gotas logs:\n%s", logs.String())
+		}
+	})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", srv.address, time.Second)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("gotas not listening on %v: %v\n%s", srv.address, err, logs.String())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return srv
+}
+
+// addUser creates a user in the server and returns its key.
+func (s *server) addUser(t *testing.T, name string) string {
+	t.Helper()
+
+	out := gotasRun(t, "add", "--data", s.data, "--output", "json", "user", org, name)
+	var user struct {
+		Key string `json:"key"`
+	}
+	require.NoError(t, json.Unmarshal(out, &user))
+	require.NotEmpty(t, user.Key)
+
+	return user.Key
+}
+
+func (s *server) writePair(t *testing.T, name string, cert, key []byte) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(filepath.Join(s.pki, name+".pem"), cert, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(s.pki, name+".key"), key, 0600))
+}
+
+// client is a Taskwarrior client with its own data, run in a container.
+type client struct {
+	version string
+	home    string
+}
+
+func (s *server) newClient(t *testing.T, version, user, key string) *client {
+	t.Helper()
+
+	c := &client{version: version, home: t.TempDir()}
+
+	cert, certKey, err := pki.CreateClientCert(org, user, s.ca)
+	require.NoError(t, err)
+	ca, err := os.ReadFile(filepath.Join(s.pki, "ca.pem"))
+	require.NoError(t, err)
+
+	files := map[string][]byte{
+		"ca.pem":     ca,
+		"client.pem": cert,
+		"client.key": certKey,
+		".taskrc": []byte(strings.Join([]string{
+			"data.location=/home/task/.task",
+			"confirmation=off",
+			"verbose=nothing",
+			"taskd.server=" + s.address,
+			"taskd.credentials=" + org + "/" + user + "/" + key,
+			"taskd.ca=/home/task/ca.pem",
+			"taskd.certificate=/home/task/client.pem",
+			"taskd.key=/home/task/client.key",
+			"taskd.trust=ignore hostname",
+		}, "\n") + "\n"),
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(c.home, name), content, 0600))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(c.home, ".task"), 0700))
+
+	return c
+}
+
+// run executes task with the given arguments, sharing the host network to
+// reach the server.
+func (c *client) run(t *testing.T, args ...string) []byte {
+	t.Helper()
+
+	cmdArgs := []string{"run", "--rm", "--network", "host",
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"-v", c.home + ":/home/task",
+		"-e", "HOME=/home/task",
+		"-e", "TASKRC=/home/task/.taskrc",
+		image(c.version)}
+	cmd := exec.Command(runtime, append(cmdArgs, args...)...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	require.NoError(t, err, "task %v %v: %s%s", c.version, strings.Join(args, " "), out, stderr.String())
+
+	return out
+}
+
+// export returns the synced attributes of the client tasks by uuid.
+func (c *client) export(t *testing.T) map[string]map[string]interface{} {
+	t.Helper()
+
+	var tasks []map[string]interface{}
+	require.NoError(t, json.Unmarshal(c.run(t, "export"), &tasks))
+
+	byUUID := make(map[string]map[string]interface{}, len(tasks))
+	for _, task := range tasks {
+		synced := make(map[string]interface{})
+		for _, name := range syncedAttributes {
+			if value, ok := task[name]; ok {
+				synced[name] = value
+			}
+		}
+		byUUID[fmt.Sprint(task["uuid"])] = synced
+	}
+
+	return byUUID
+}
+
+func gotasRun(t *testing.T, args ...string) []byte {
+	t.Helper()
+
+	cmd := exec.Command(gotas, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	require.NoError(t, err, "gotas %v: %s", strings.Join(args, " "), stderr.String())
+
+	return out
+}
+
+func image(version string) string {
+	return "gotas-integration-task:" + version
+}
+
+func versions() []string {
+	versions := make([]string, 0, len(clientImages))
+	for version := range clientImages {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
@@ -1,10 +1,29 @@
 package logger
 
 import (
+	"fmt"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// Level is a logging priority, higher levels are more important.
+type Level int8
+
+// Supported logging levels.
+const (
+	DebugLevel Level = iota - 1
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+// Supported output formats.
+const (
+	ConsoleFormat = "console"
+	JSONFormat    = "json"
+)
+
 func init() {
 	bootstrapLogging()
 }
@@ -16,6 +35,9 @@ type Logger struct {
 
 var log *Logger
 
+// level is shared by every logger built, so it can be changed at any time.
+var level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
 // Debug logs a message in debug level
 func (l *Logger) Debug(args ...interface{}) {
 	l.log.Debug(args...)
@@ -56,14 +78,27 @@ func (l *Logger) Errorf(template string, args ...interface{}) {
 	l.log.Errorf(template, args...)
 }
 
+// SetLevel sets the minimum level logged.  It affects every logger, even the
+// ones already in use.
+func SetLevel(l Level) {
+	level.SetLevel(zapcore.Level(l))
+}
+
+// SetFormat changes the output format, either ConsoleFormat or JSONFormat.
+// It is meant to be called on start up, before logging concurrently.
+func SetFormat(format string) error {
+	zapLog, err := build(format)
+	if err != nil {
+		return err
+	}
+	zap.ReplaceGlobals(zapLog)
+	log.log = zap.S()
+	return nil
+}
+
 // bootstrapLogging bootstraps a basic logger
 func bootstrapLogging() {
-	config := zap.NewDevelopmentConfig()
-	config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	config.EncoderConfig.TimeKey = "timestamp"
-	config.EncoderConfig.CallerKey = ""
-	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	zapLog, err := config.Build()
+	zapLog, err := build(ConsoleFormat)
 	if err != nil {
 		panic(err)
 	}
@@ -71,6 +106,28 @@ func bootstrapLogging() {
 	log = &Logger{zap.S()}
 }
 
+func build(format string) (*zap.Logger, error) {
+	config := zap.NewDevelopmentConfig()
+	config.Level = level
+	config.EncoderConfig.TimeKey = "timestamp"
+	config.EncoderConfig.CallerKey = ""
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	switch format {
+	case ConsoleFormat:
+		config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	case JSONFormat:
+		config.Encoding = "json"
+		config.EncoderConfig.LevelKey = "level"
+		config.EncoderConfig.MessageKey = "message"
+		config.EncoderConfig.EncodeLevel = zapcore.LowercaseLevelEncoder
+	default:
+		return nil, fmt.Errorf("invalid log format %q, either %q or %q expected", format, ConsoleFormat, JSONFormat)
+	}
+
+	return config.Build()
+}
+
 // Log returns a global logger instance
 func Log() *Logger {
 	return log
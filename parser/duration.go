@@ -0,0 +1,206 @@
+// The logic for this parser was taken from the original taskserver code
+// https://github.com/GothenburgBitFactory/libshared/blob/1fa5dcbf53a280857e35436aef6beb6a37266e33/src/Duration.cpp
+
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Duration is a Taskwarrior duration, like the `recur` attribute values.
+// Years, months and days are kept apart from the time, so they can be added
+// to a date following the calendar.
+type Duration struct {
+	Years  int
+	Months int
+	Days   int
+	Time   time.Duration
+}
+
+// unit is the duration represented by a unit name.
+type unit struct {
+	years, months, days int
+	time                time.Duration
+}
+
+// durationUnits are the unit names accepted by Taskwarrior, both as
+// standalone words ("weekly") and after a number ("2w").
+var durationUnits = map[string]unit{
+	"annual":     {years: 1},
+	"biannual":   {years: 2},
+	"bimonthly":  {months: 2},
+	"biweekly":   {days: 14},
+	"biyearly":   {years: 2},
+	"daily":      {days: 1},
+	"days":       {days: 1},
+	"day":        {days: 1},
+	"d":          {days: 1},
+	"fortnight":  {days: 14},
+	"hours":      {time: time.Hour},
+	"hour":       {time: time.Hour},
+	"hrs":        {time: time.Hour},
+	"hr":         {time: time.Hour},
+	"h":          {time: time.Hour},
+	"minutes":    {time: time.Minute},
+	"minute":     {time: time.Minute},
+	"mins":       {time: time.Minute},
+	"min":        {time: time.Minute},
+	"monthly":    {months: 1},
+	"months":     {months: 1},
+	"month":      {months: 1},
+	"mnths":      {months: 1},
+	"mths":       {months: 1},
+	"mth":        {months: 1},
+	"mos":        {months: 1},
+	"mo":         {months: 1},
+	"m":          {months: 1},
+	"quarterly":  {months: 3},
+	"quarters":   {months: 3},
+	"quarter":    {months: 3},
+	"qrtrs":      {months: 3},
+	"qtrs":       {months: 3},
+	"qtr":        {months: 3},
+	"q":          {months: 3},
+	"seconds":    {time: time.Second},
+	"second":     {time: time.Second},
+	"secs":       {time: time.Second},
+	"sec":        {time: time.Second},
+	"s":          {time: time.Second},
+	"semiannual": {months: 6},
+	"sennight":   {days: 7},
+	"weekdays":   {days: 1}, // the recurrence skips the weekends
+	"weekly":     {days: 7},
+	"weeks":      {days: 7},
+	"week":       {days: 7},
+	"wks":        {days: 7},
+	"wk":         {days: 7},
+	"w":          {days: 7},
+	"yearly":     {years: 1},
+	"years":      {years: 1},
+	"year":       {years: 1},
+	"yrs":        {years: 1},
+	"yr":         {years: 1},
+	"y":          {years: 1},
+}
+
+// ParseDuration parses a Taskwarrior duration, either a unit name with an
+// optional number ("weekly", "3d", "2 weeks") or an ISO-8601 duration
+// ("P1M", "PT12H", "P1Y2M3DT4H5M6S").
+func ParseDuration(value string) (Duration, error) {
+	p := NewPig(value)
+	p.SkipWS()
+
+	var d Duration
+	var ok bool
+	if p.PeekN(1) == "P" {
+		d, ok = parseISODuration(p)
+	} else {
+		d, ok = parseUnitDuration(p)
+	}
+
+	p.SkipWS()
+	if !ok || !p.Eos() {
+		return Duration{}, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// AddTo returns the date plus the duration, adding the years, months and
+// days in the date's calendar.
+func (d Duration) AddTo(date time.Time) time.Time {
+	return date.AddDate(d.Years, d.Months, d.Days).Add(d.Time)
+}
+
+// Approx returns the duration as a fixed amount of time, with 365 days years
+// and 30 days months as Taskwarrior does.
+func (d Duration) Approx() time.Duration {
+	days := time.Duration(d.Years*365+d.Months*30+d.Days) * 24 * time.Hour
+	return days + d.Time
+}
+
+// IsZero returns true if the duration is empty.
+func (d Duration) IsZero() bool {
+	return d == Duration{}
+}
+
+func parseUnitDuration(p *Pig) (Duration, bool) {
+	number := 1.0
+	if n, err := p.GetDecimal(); err == nil {
+		number = n
+		p.SkipWS()
+	}
+
+	name := new(strings.Builder)
+	if !p.GetUntilWS(name) {
+		return Duration{}, false
+	}
+	u, ok := durationUnits[strings.ToLower(name.String())]
+	if !ok {
+		return Duration{}, false
+	}
+
+	// the fractions of a unit are approximated as Taskwarrior does, e.g.
+	// "1.5d" is 36 hours, the whole numbers keep the calendar units
+	if number != math.Trunc(number) {
+		unit := Duration{Years: u.years, Months: u.months, Days: u.days, Time: u.time}
+		return Duration{Time: time.Duration(number * float64(unit.Approx()))}, true
+	}
+	n := int(number)
+	return Duration{
+		Years:  n * u.years,
+		Months: n * u.months,
+		Days:   n * u.days,
+		Time:   time.Duration(n) * u.time,
+	}, true
+}
+
+func parseISODuration(p *Pig) (Duration, bool) {
+	if !p.Skip('P') {
+		return Duration{}, false
+	}
+
+	var d Duration
+	found, timeFound := false, false
+	inTime := false
+	for !p.Eos() && !unicode.IsSpace(p.Peek()) {
+		if !inTime && p.Skip('T') {
+			inTime = true
+			continue
+		}
+
+		n, err := p.GetDigits()
+		if err != nil {
+			return Duration{}, false
+		}
+
+		designator := p.Peek()
+		switch {
+		case !inTime && designator == 'Y':
+			d.Years += n
+		case !inTime && designator == 'M':
+			d.Months += n
+		case !inTime && designator == 'W':
+			d.Days += 7 * n
+		case !inTime && designator == 'D':
+			d.Days += n
+		case inTime && designator == 'H':
+			d.Time += time.Duration(n) * time.Hour
+		case inTime && designator == 'M':
+			d.Time += time.Duration(n) * time.Minute
+		case inTime && designator == 'S':
+			d.Time += time.Duration(n) * time.Second
+		default:
+			return Duration{}, false
+		}
+		p.Skip(designator)
+		found = true
+		timeFound = inTime
+	}
+
+	// a "T" must be followed by at least one time component
+	return d, found && inTime == timeFound
+}
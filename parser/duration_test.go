@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDuration(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected Duration
+		success  bool
+	}{
+		{"weekly", Duration{Days: 7}, true},
+		{"monthly", Duration{Months: 1}, true},
+		{"Yearly", Duration{Years: 1}, true},
+		{"3d", Duration{Days: 3}, true},
+		{"2w", Duration{Days: 14}, true},
+		{"2 weeks", Duration{Days: 14}, true},
+		{"18mo", Duration{Months: 18}, true},
+		{"2q", Duration{Months: 6}, true},
+		{"90min", Duration{Time: 90 * time.Minute}, true},
+		{"1.5d", Duration{Time: 36 * time.Hour}, true},
+		{"0.5 weeks", Duration{Time: 84 * time.Hour}, true},
+		{"2.0w", Duration{Days: 14}, true},
+		{" 1y ", Duration{Years: 1}, true},
+		{"P1M", Duration{Months: 1}, true},
+		{"P2W", Duration{Days: 14}, true},
+		{"PT12H", Duration{Time: 12 * time.Hour}, true},
+		{"P1Y2M3DT4H5M6S", Duration{Years: 1, Months: 2, Days: 3, Time: 4*time.Hour + 5*time.Minute + 6*time.Second}, true},
+		{"", Duration{}, false},
+		{"3", Duration{}, false},
+		{"3 parsecs", Duration{}, false},
+		{"3d 2h", Duration{}, false},
+		{"P", Duration{}, false},
+		{"PT", Duration{}, false},
+		{"P1DT", Duration{}, false},
+		{"P1H", Duration{}, false},
+		{"PT1D", Duration{}, false},
+		{"P1.5D", Duration{}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.value, func(t *testing.T) {
+			d, err := ParseDuration(c.value)
+			if c.success {
+				assert.NoError(t, err)
+				assert.Equal(t, c.expected, d)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestDurationDates(t *testing.T) {
+	date := time.Date(2021, time.January, 31, 10, 0, 0, 0, time.UTC)
+
+	d, err := ParseDuration("P1MT2H")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, time.March, 3, 12, 0, 0, 0, time.UTC), d.AddTo(date))
+	assert.Equal(t, 30*24*time.Hour+2*time.Hour, d.Approx())
+	assert.False(t, d.IsZero())
+
+	d, err = ParseDuration("yearly")
+	assert.NoError(t, err)
+	assert.Equal(t, 365*24*time.Hour, d.Approx())
+	assert.True(t, Duration{}.IsZero())
+}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
 )
 
@@ -16,6 +17,7 @@ import (
 type Pig struct {
 	value string
 	idx   int
+	saved []int
 }
 
 // NewPig creates a pig based on a string.
@@ -93,9 +95,10 @@ func (p *Pig) GetQuoted(quote rune, w io.Writer) bool {
 			return true
 		}
 
-		ch, length = utf8.DecodeRuneInString(p.value[i-1:])
-		if ch == utf8.RuneError {
-			break
+		// a U+FFFD in the value is valid, only invalid UTF-8 is rejected
+		ch, size := utf8.DecodeLastRuneInString(p.value[start:i])
+		if ch == utf8.RuneError && size == 1 {
+			return false
 		}
 
 		if ch == '\\' {
@@ -115,7 +118,7 @@ func (p *Pig) GetQuoted(quote rune, w io.Writer) bool {
 			}
 
 			if isEscapedQuote {
-				i += length
+				i += len(string(quote))
 				continue
 			}
 		}
@@ -127,9 +130,6 @@ func (p *Pig) GetQuoted(quote rune, w io.Writer) bool {
 		p.idx = i + len(string(quote)) // Skip closing quote char
 		return true
 	}
-
-	// This should never be reached.  We could throw here instead.
-	return false
 }
 
 // Eos returns true only when the end of stream was reached.
@@ -168,6 +168,99 @@ func (p *Pig) SkipN(n int) bool {
 	return true
 }
 
+// SkipWS moves the pig cursor past the whitespace runes and returns true,
+// otherwise, if there is no whitespace, returns false.
+func (p *Pig) SkipWS() bool {
+	save := p.idx
+	for {
+		r, size := utf8.DecodeRuneInString(p.value[p.idx:])
+		if size == 0 || !unicode.IsSpace(r) {
+			break
+		}
+		p.idx += size
+	}
+	return p.idx > save
+}
+
+// GetUntilWS writes the pig content to the writer until it finds a
+// whitespace rune (exclusive) or the end of stream, and returns true if
+// something was written.
+func (p *Pig) GetUntilWS(w io.Writer) bool {
+	return p.getWhile(func(r rune) bool { return !unicode.IsSpace(r) }, w)
+}
+
+// GetUntilOneOf writes the pig content to the writer until it finds any of
+// the runes in the set (exclusive) or the end of stream, and returns true if
+// something was written.
+func (p *Pig) GetUntilOneOf(set string, w io.Writer) bool {
+	return p.getWhile(func(r rune) bool { return !strings.ContainsRune(set, r) }, w)
+}
+
+// getWhile writes the pig content to the writer while the runes match,
+// stopping at an invalid rune too.  In case nothing matches or the writer
+// fails, it returns false and the cursor is not changed.
+func (p *Pig) getWhile(match func(rune) bool, w io.Writer) bool {
+	save := p.idx
+	end := p.idx
+	for {
+		r, size := utf8.DecodeRuneInString(p.value[end:])
+		if r == utf8.RuneError || !match(r) {
+			break
+		}
+		end += size
+	}
+
+	if end == save {
+		return false
+	}
+	if _, err := w.Write([]byte(p.value[save:end])); err != nil {
+		return false
+	}
+	p.idx = end
+	return true
+}
+
+// PeekN returns the next `n` runes but not modifies the index position.  In
+// case there are less than `n` valid runes left, returns an empty string.
+func (p *Pig) PeekN(n int) string {
+	end := p.idx
+	for count := 0; count < n; count++ {
+		r, size := utf8.DecodeRuneInString(p.value[end:])
+		if r == utf8.RuneError {
+			return ""
+		}
+		end += size
+	}
+	return p.value[p.idx:end]
+}
+
+// Save pushes the current pig position to the checkpoints stack, to be later
+// restored with Rollback or discarded with Commit.
+func (p *Pig) Save() {
+	p.saved = append(p.saved, p.idx)
+}
+
+// Rollback moves the pig cursor back to the last saved checkpoint and
+// removes it from the stack.  In case there is no checkpoint returns false.
+func (p *Pig) Rollback() bool {
+	if len(p.saved) == 0 {
+		return false
+	}
+	p.idx = p.saved[len(p.saved)-1]
+	p.saved = p.saved[:len(p.saved)-1]
+	return true
+}
+
+// Commit removes the last saved checkpoint from the stack, keeping the
+// current pig position.  In case there is no checkpoint returns false.
+func (p *Pig) Commit() bool {
+	if len(p.saved) == 0 {
+		return false
+	}
+	p.saved = p.saved[:len(p.saved)-1]
+	return true
+}
+
 // Cursor returns the current pig position.
 func (p *Pig) Cursor() int {
 	return p.idx
@@ -208,44 +301,142 @@ func (p *Pig) GetValue() string {
 	return p.value[p.idx:]
 }
 
-// GetNDigits returns the next N runes as an numeric value
+// GetNDigits returns the next N runes as an numeric value.  In case any of
+// them is not a digit, returns an error and the cursor is not changed.
 func (p *Pig) GetNDigits(n int) (int, error) {
-	total := 0
+	end := p.idx
 	for i := 0; i < n; i++ {
-		r, size := utf8.DecodeRuneInString(p.value[p.idx:])
-		if r == utf8.RuneError || !unicode.IsDigit(r) {
+		if end >= len(p.value) || !isDigit(p.value[end]) {
 			return 0, fmt.Errorf("no valid digit")
 		}
-		total += size
+		end++
 	}
-	result, err := strconv.Atoi(p.value[p.idx : p.idx+total])
+	result, err := strconv.Atoi(p.value[p.idx:end])
 	if err != nil {
 		return 0, err
 	}
-	p.idx += total
+	p.idx = end
 	return result, nil
 }
 
-// GetDigits returns the remaining runes as an numeric value
+// GetDigits returns the following digits as an numeric value, stopping at the
+// first rune that is not a digit.
 func (p *Pig) GetDigits() (int, error) {
-	save := p.idx
+	end := p.skipDigits(p.idx)
+	if end == p.idx {
+		return 0, fmt.Errorf("no valid number found")
+	}
 
-	prev := p.idx
-	for {
-		r, size := utf8.DecodeRuneInString(p.value[p.idx:])
-		if r == utf8.RuneError || !unicode.IsDigit(r) {
-			p.idx = prev
-			break
+	result, err := strconv.Atoi(p.value[p.idx:end])
+	if err != nil {
+		return 0, err
+	}
+	p.idx = end
+	return result, nil
+}
+
+// GetInt returns the following integer, with an optional sign, as an numeric
+// value.  In case there is no integer, returns an error and the cursor is not
+// changed.
+func (p *Pig) GetInt() (int, error) {
+	end := p.skipSign(p.idx)
+	if digits := p.skipDigits(end); digits > end {
+		end = digits
+	} else {
+		return 0, fmt.Errorf("no valid integer found")
+	}
+
+	result, err := strconv.Atoi(p.value[p.idx:end])
+	if err != nil {
+		return 0, err
+	}
+	p.idx = end
+	return result, nil
+}
+
+// GetDecimal returns the following decimal number, with an optional sign,
+// fraction and exponent ("-1.5", "2.", ".5", "1e3"), as an numeric value.  In
+// case there is no number, returns an error and the cursor is not changed.
+func (p *Pig) GetDecimal() (float64, error) {
+	end := p.skipSign(p.idx)
+	digits := p.skipDigits(end)
+	whole := digits > end
+	end = digits
+
+	if end < len(p.value) && p.value[end] == '.' {
+		if fraction := p.skipDigits(end + 1); whole || fraction > end+1 {
+			end = fraction
+			whole = true
 		}
-		p.idx += size
-		prev = p.idx
+	}
+	if !whole {
+		return 0, fmt.Errorf("no valid decimal found")
+	}
+
+	// the exponent is only taken if it has digits
+	if end < len(p.value) && (p.value[end] == 'e' || p.value[end] == 'E') {
+		sign := p.skipSign(end + 1)
+		if exponent := p.skipDigits(sign); exponent > sign {
+			end = exponent
+		}
+	}
+
+	result, err := strconv.ParseFloat(p.value[p.idx:end], 64)
+	if err != nil {
+		return 0, err
+	}
+	p.idx = end
+	return result, nil
+}
+
+// GetHex returns the following hexadecimal number, with an optional "0x"
+// prefix, as an numeric value.  In case there is no number, returns an error
+// and the cursor is not changed.
+func (p *Pig) GetHex() (int, error) {
+	start := p.idx
+	if strings.HasPrefix(p.value[start:], "0x") || strings.HasPrefix(p.value[start:], "0X") {
+		start += 2
 	}
 
-	if p.idx > save {
-		return strconv.Atoi(p.value[save : p.idx-save])
+	end := start
+	for end < len(p.value) && isHexDigit(p.value[end]) {
+		end++
+	}
+	if end == start {
+		return 0, fmt.Errorf("no valid hexadecimal number found")
 	}
 
-	return 0, fmt.Errorf("no valid number found")
+	result, err := strconv.ParseInt(p.value[start:end], 16, 0)
+	if err != nil {
+		return 0, err
+	}
+	p.idx = end
+	return int(result), nil
+}
+
+// skipSign returns the position after an optional sign starting at idx.
+func (p *Pig) skipSign(idx int) int {
+	if idx < len(p.value) && (p.value[idx] == '+' || p.value[idx] == '-') {
+		return idx + 1
+	}
+	return idx
+}
+
+// skipDigits returns the position of the first rune that is not a digit
+// starting at idx.
+func (p *Pig) skipDigits(idx int) int {
+	for idx < len(p.value) && isDigit(p.value[idx]) {
+		idx++
+	}
+	return idx
+}
+
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
+func isHexDigit(b byte) bool {
+	return isDigit(b) || ('a' <= b && b <= 'f') || ('A' <= b && b <= 'F')
 }
 
 // Peek returns the current stream value as a rune but not modifies the index
@@ -264,3 +455,131 @@ func Decode(value string) string {
 	value = strings.ReplaceAll(value, "&open;", "[")
 	return strings.ReplaceAll(value, "&close;", "]")
 }
+
+// Encode converts "[" and "]" to their encoded values (&open; and &close;),
+// it's the inverse of Decode.
+func Encode(value string) string {
+	if !strings.ContainsAny(value, "[]") {
+		return value
+	}
+
+	value = strings.ReplaceAll(value, "[", "&open;")
+	return strings.ReplaceAll(value, "]", "&close;")
+}
+
+// EncodeValue escapes a value to be written quoted in a format 4 record, the
+// JSON escapes are applied first so the quotes and backslashes are kept, then
+// the brackets are encoded.  Any value is decoded back by DecodeValue.
+func EncodeValue(value string) string {
+	return Encode(jsonEncode(value))
+}
+
+// DecodeValue is the inverse of EncodeValue, it returns the original value
+// of a quoted format 4 attribute.
+func DecodeValue(value string) string {
+	return jsonDecode(Decode(value))
+}
+
+// jsonEncode escapes the quotes, backslashes and the control characters
+// having a short JSON escape sequence.  The ampersands are escaped too, so
+// the values having an "&open;" or "&close;" text are decoded back as they
+// were.
+func jsonEncode(value string) string {
+	if !strings.ContainsAny(value, "\"\\&\b\f\n\r\t") {
+		return value
+	}
+
+	var b strings.Builder
+	b.Grow(len(value) + 8)
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '&':
+			b.WriteString(`\u0026`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// jsonDecode replaces the JSON escape sequences, including the \uXXXX ones,
+// with the runes they represent.  Unknown sequences are kept as they are.
+func jsonDecode(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+
+	var b strings.Builder
+	b.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+
+		i++
+		switch escaped := value[i]; escaped {
+		case '"', '\\', '/':
+			b.WriteByte(escaped)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			r, size := unicodeEscape(value[i-1:])
+			if size == 0 {
+				b.WriteString(`\u`)
+				continue
+			}
+			b.WriteRune(r)
+			i += size - 2
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(escaped)
+		}
+	}
+	return b.String()
+}
+
+// unicodeEscape decodes the \uXXXX sequence at the beginning of value,
+// joining the UTF-16 surrogate pairs, and returns the rune and the length of
+// the sequence, or zero if there is no valid sequence.
+func unicodeEscape(value string) (rune, int) {
+	if len(value) < 6 {
+		return 0, 0
+	}
+	code, err := strconv.ParseUint(value[2:6], 16, 32)
+	if err != nil {
+		return 0, 0
+	}
+
+	r := rune(code)
+	if utf16.IsSurrogate(r) && len(value) >= 12 && value[6:8] == `\u` {
+		if low, err := strconv.ParseUint(value[8:12], 16, 32); err == nil {
+			if pair := utf16.DecodeRune(r, rune(low)); pair != utf8.RuneError {
+				return pair, 12
+			}
+		}
+	}
+	return r, 6
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"testing/quick"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -28,6 +29,8 @@ func TestGetQuoted(t *testing.T) {
 		{"GetQuoted for for unbalanced quoted string", `"foo`, '"', "", false},
 		{"GetQuoted for double escaped string", `"foo\\"bar`, '"', `foo\\`, true},
 		{"GetQuoted for multiple escaped", "\"one\\\\\"", '"', "one\\\\", true},
+		{"GetQuoted for multi-byte rune before the quote", `"año€"`, '"', "año€", true},
+		{"GetQuoted for replacement rune before the quote", "\"foo\uFFFD\"", '"', "foo\uFFFD", true},
 		{"GetQuoted for escaped string", `"foo\"bar"`, '"', `foo\"bar`, true},
 		{"GetQuoted for double escaped string", `"foo\a\b\"bar"`, '"', `foo\a\b\"bar`, true},
 		{"GetQuoted with alternative UTF-8 rune", `日foobar日`, '日', `foobar`, true},
@@ -270,6 +273,9 @@ func TestGetDigits(t *testing.T) {
 		{"123", 123, false},
 		{"1a23", 1, false},
 		{"a123", 0, true},
+		{"", 0, true},
+		{"-1", 0, true},
+		{"١٢", 0, true},
 	}
 
 	for _, c := range cases {
@@ -278,10 +284,158 @@ func TestGetDigits(t *testing.T) {
 		actual, error := p.GetDigits()
 		if c.fails {
 			assert.NotNil(t, error)
+			assert.Equal(t, 0, p.Cursor())
 		} else {
 			assert.Equal(t, actual, c.expected)
 		}
 	}
+
+	t.Run("digits after the start of the string", func(t *testing.T) {
+		p := NewPig("ab12345cd")
+		assert.True(t, p.SkipN(2))
+
+		actual, err := p.GetDigits()
+		assert.Nil(t, err)
+		assert.Equal(t, 12345, actual)
+		assert.Equal(t, 7, p.Cursor())
+	})
+}
+
+func TestGetNDigits(t *testing.T) {
+	cases := []struct {
+		value    string
+		skip     int
+		n        int
+		expected int
+		fails    bool
+	}{
+		{"12345", 0, 2, 12, false},
+		{"12345", 2, 3, 345, false},
+		{"12345", 3, 3, 0, true},
+		{"1a345", 0, 2, 0, true},
+		{"ab1", 2, 1, 1, false},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%d digits after %d in %q", c.n, c.skip, c.value), func(t *testing.T) {
+			p := NewPig(c.value)
+			assert.True(t, p.SkipN(c.skip))
+
+			actual, err := p.GetNDigits(c.n)
+			if c.fails {
+				assert.NotNil(t, err)
+				assert.Equal(t, c.skip, p.Cursor())
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, c.expected, actual)
+				assert.Equal(t, c.skip+c.n, p.Cursor())
+			}
+		})
+	}
+}
+
+func TestGetInt(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected int
+		cursor   int
+		fails    bool
+	}{
+		{"123", 123, 3, false},
+		{"-42abc", -42, 3, false},
+		{"+7", 7, 2, false},
+		{"007", 7, 3, false},
+		{"-", 0, 0, true},
+		{"+a", 0, 0, true},
+		{"abc", 0, 0, true},
+		{"", 0, 0, true},
+		{"99999999999999999999", 0, 0, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.value, func(t *testing.T) {
+			p := NewPig(c.value)
+
+			actual, err := p.GetInt()
+			if c.fails {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, c.expected, actual)
+			}
+			assert.Equal(t, c.cursor, p.Cursor())
+		})
+	}
+}
+
+func TestGetDecimal(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected float64
+		cursor   int
+		fails    bool
+	}{
+		{"123", 123, 3, false},
+		{"-1.5d", -1.5, 4, false},
+		{"+0.25", 0.25, 5, false},
+		{"2.", 2, 2, false},
+		{".5", 0.5, 2, false},
+		{"1e3", 1000, 3, false},
+		{"1.5E-2", 0.015, 6, false},
+		{"3e", 3, 1, false},
+		{"4e+x", 4, 1, false},
+		{".", 0, 0, true},
+		{"-.", 0, 0, true},
+		{"e3", 0, 0, true},
+		{"", 0, 0, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.value, func(t *testing.T) {
+			p := NewPig(c.value)
+
+			actual, err := p.GetDecimal()
+			if c.fails {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+				assert.InDelta(t, c.expected, actual, 1e-9)
+			}
+			assert.Equal(t, c.cursor, p.Cursor())
+		})
+	}
+}
+
+func TestGetHex(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected int
+		cursor   int
+		fails    bool
+	}{
+		{"ff", 255, 2, false},
+		{"0x1A2b", 0x1a2b, 6, false},
+		{"0XFFz", 255, 4, false},
+		{"12g", 0x12, 2, false},
+		{"0x", 0, 0, true},
+		{"xyz", 0, 0, true},
+		{"", 0, 0, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.value, func(t *testing.T) {
+			p := NewPig(c.value)
+
+			actual, err := p.GetHex()
+			if c.fails {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, c.expected, actual)
+			}
+			assert.Equal(t, c.cursor, p.Cursor())
+		})
+	}
 }
 
 func TestRestoreTo(t *testing.T) {
@@ -437,3 +591,236 @@ func TestJsonDecode(t *testing.T) {
 	}
 
 }
+
+func TestEncode(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected string
+	}{
+		{"hello", "hello"},
+		{"[hello]", "&open;hello&close;"},
+		{"a]b[c", "a&close;b&open;c"},
+		{`1\"2`, `1\"2`},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("encoding %v", c.value), func(t *testing.T) {
+			assert.Equal(t, c.expected, Encode(c.value))
+			assert.Equal(t, c.value, Decode(Encode(c.value)))
+		})
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected string
+	}{
+		{"hello", "hello"},
+		{`say "hi"`, `say \"hi\"`},
+		{`C:\temp`, `C:\\temp`},
+		{"one\ntwo\tthree", `one\ntwo\tthree`},
+		{"[x]", "&open;x&close;"},
+		{"&open;", `\u0026open;`},
+		{"1€2", "1€2"},
+		{"1\x02", "1\x02"},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("encoding %q", c.value), func(t *testing.T) {
+			assert.Equal(t, c.expected, EncodeValue(c.value))
+			assert.Equal(t, c.value, DecodeValue(c.expected))
+		})
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected string
+	}{
+		{`\u20ac`, "€"},
+		{`\u00e9t\u00e9`, "été"},
+		{`\ud83d\ude00`, "😀"},
+		{`\/path`, "/path"},
+		{`\u12`, `\u12`},
+		{`\uzzzz`, `\uzzzz`},
+		{`\x`, `\x`},
+		{`trailing\`, `trailing\`},
+		{"&open;\\&close;", `[\]`},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("decoding %q", c.value), func(t *testing.T) {
+			assert.Equal(t, c.expected, DecodeValue(c.value))
+		})
+	}
+}
+
+func TestEncodeValueRoundTrip(t *testing.T) {
+	roundTrip := func(value string) bool {
+		encoded := EncodeValue(value)
+
+		// the encoded value must be read back as a single quoted value
+		quoted := new(strings.Builder)
+		p := NewPig(`"` + encoded + `"`)
+		if encoded != "" && (!p.GetQuoted('"', quoted) || !p.Eos() || quoted.String() != encoded) {
+			return false
+		}
+
+		return !strings.ContainsAny(encoded, "[]") && DecodeValue(encoded) == value
+	}
+
+	t.Run("random strings", func(t *testing.T) {
+		assert.NoError(t, quick.Check(roundTrip, nil))
+	})
+
+	t.Run("special characters", func(t *testing.T) {
+		alphabet := []string{"[", "]", `"`, `\`, "&", "open;", "close;", "u0026", "\n", "€", "\x00", "\uFFFD"}
+		assert.NoError(t, quick.Check(func(picks []uint8) bool {
+			var value strings.Builder
+			for _, pick := range picks {
+				value.WriteString(alphabet[int(pick)%len(alphabet)])
+			}
+			return roundTrip(value.String())
+		}, nil))
+	})
+}
+
+func TestSkipWS(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected bool
+		cursor   int
+	}{
+		{"  \t\nabc", true, 4},
+		{"abc", false, 0},
+		{"   ", true, 3},
+		{"", false, 0},
+		{" abc", true, 2},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("skip whitespace in %q", c.value), func(t *testing.T) {
+			p := NewPig(c.value)
+
+			assert.Equal(t, c.expected, p.SkipWS())
+			assert.Equal(t, c.cursor, p.Cursor())
+		})
+	}
+}
+
+func TestGetUntilWS(t *testing.T) {
+	cases := []struct {
+		title    string
+		value    string
+		expected string
+		success  bool
+	}{
+		{"get the first word", "hello world", "hello", true},
+		{"get until a tab", "hello\tworld", "hello", true},
+		{"get until the end of the string", "hello", "hello", true},
+		{"fails with leading whitespace", " hello", "", false},
+		{"fails with empty string", "", "", false},
+		{"fails with invalid rune at the beginning", "\xbd\xb2hello world", "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			p := NewPig(c.value)
+			w := new(strings.Builder)
+
+			result := p.GetUntilWS(w)
+
+			assert.Equal(t, c.success, result)
+			assert.Equal(t, c.expected, w.String())
+			assert.Equal(t, len(c.expected), p.Cursor())
+		})
+	}
+
+	t.Run("fails with invalid writer", func(t *testing.T) {
+		p := NewPig("hello world")
+
+		assert.False(t, p.GetUntilWS(new(piggyWriter)))
+		assert.Equal(t, 0, p.Cursor())
+	})
+}
+
+func TestGetUntilOneOf(t *testing.T) {
+	cases := []struct {
+		title    string
+		value    string
+		set      string
+		expected string
+		success  bool
+	}{
+		{"get until the first rune of the set", "3d2h", "dwh", "3", true},
+		{"get until a later rune of the set", "12h", "dwh", "12", true},
+		{"get until the end of the string", "123", "dwh", "123", true},
+		{"get until a multi-byte rune", "año:2", "ñ", "a", true},
+		{"fails when the set matches the first rune", "d3", "dwh", "", false},
+		{"fails with empty string", "", "dwh", "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			p := NewPig(c.value)
+			w := new(strings.Builder)
+
+			result := p.GetUntilOneOf(c.set, w)
+
+			assert.Equal(t, c.success, result)
+			assert.Equal(t, c.expected, w.String())
+			assert.Equal(t, len(c.expected), p.Cursor())
+		})
+	}
+}
+
+func TestPeekN(t *testing.T) {
+	cases := []struct {
+		value    string
+		n        int
+		expected string
+	}{
+		{"12345", 0, ""},
+		{"12345", 1, "1"},
+		{"12345", 3, "123"},
+		{"12345", 5, "12345"},
+		{"12345", 6, ""},
+		{"añob", 3, "año"},
+		{"a\xbd\xb2", 2, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("peek %d in %q", c.n, c.value), func(t *testing.T) {
+			p := NewPig(c.value)
+
+			assert.Equal(t, c.expected, p.PeekN(c.n))
+			assert.Equal(t, 0, p.Cursor())
+		})
+	}
+}
+
+func TestSaveRestore(t *testing.T) {
+	p := NewPig("abcdef")
+
+	assert.False(t, p.Rollback())
+	assert.False(t, p.Commit())
+
+	p.Save()
+	assert.True(t, p.SkipN(2))
+	p.Save()
+	assert.True(t, p.SkipN(2))
+
+	assert.True(t, p.Rollback())
+	assert.Equal(t, 2, p.Cursor())
+
+	p.Save()
+	assert.True(t, p.SkipN(1))
+	assert.True(t, p.Commit())
+	assert.Equal(t, 3, p.Cursor())
+
+	assert.True(t, p.Rollback())
+	assert.Equal(t, 0, p.Cursor())
+	assert.False(t, p.Rollback())
+}
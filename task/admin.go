@@ -0,0 +1,202 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+const (
+	// DefaultAdminSocket is the admin socket file name, in the data
+	// directory, used when "admin.socket" is not configured.
+	DefaultAdminSocket = "admin.sock"
+
+	adminTimeout = 10 * time.Second
+
+	// blockProfileRate samples a blocking event every 10µs spent blocked
+	// and mutexProfileFraction one of every 100 contention events.
+	blockProfileRate     = 10000
+	mutexProfileFraction = 100
+)
+
+// AdminHandler serves the admin API, only reachable through the admin socket.
+// A POST to /stats/reset resets the statistics totals.  With debug, the
+// block and mutex profiling is enabled and the runtime profiles and
+// variables are served too, under /debug/pprof/ and /debug/vars.
+func AdminHandler(stats *Stats, debug bool) http.Handler {
+	mux := http.NewServeMux()
+
+	if debug {
+		runtime.SetBlockProfileRate(blockProfileRate)
+		runtime.SetMutexProfileFraction(mutexProfileFraction)
+
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		mux.Handle("/debug/vars", expvar.Handler())
+	}
+
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stats.Snapshot()); err != nil {
+			log.Errorf("Error encoding the statistics: %v", err)
+		}
+	})
+
+	mux.HandleFunc("/stats/reset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := stats.resetTotals(); err != nil {
+			log.Errorf("Error resetting the statistics: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Infof("Statistics totals reset")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
+
+// ListenAdmin listens on the admin socket, replacing the one left by a
+// previous run.  The socket is only accessible by the server owner.
+func ListenAdmin(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("removing admin socket: %v", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listening on admin socket: %v", err)
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("setting admin socket permissions: %v", err)
+	}
+
+	return listener, nil
+}
+
+// adminClient returns an HTTP client connected to the admin socket.
+func adminClient(path string) *http.Client {
+	return &http.Client{
+		Timeout: adminTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+// QueryStats gets the statistics of the server listening on the admin socket.
+func QueryStats(path string) (StatsSnapshot, error) {
+	resp, err := adminClient(path).Get("http://gotas/stats")
+	if err != nil {
+		return StatsSnapshot{}, fmt.Errorf("querying the server, is it running? %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return StatsSnapshot{}, fmt.Errorf("querying the server: %s", resp.Status)
+	}
+
+	var stats StatsSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return StatsSnapshot{}, fmt.Errorf("decoding statistics: %v", err)
+	}
+	return stats, nil
+}
+
+// ResetStats resets the statistics totals kept across restarts.  If the
+// server isn't listening on the admin socket, the totals saved in the data
+// directory root are removed instead.
+func ResetStats(root, socket string) error {
+	resp, err := adminClient(socket).Post("http://gotas/stats/reset", "", nil)
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		if err := os.Remove(filepath.Join(root, StatsFile)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("resetting statistics: %v", err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("resetting statistics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("resetting statistics: %s", resp.Status)
+	}
+	return nil
+}
+
+// Profiles are the runtime profiles QueryProfile gets, the cpu one sampled
+// for the given seconds.
+var Profiles = []string{"cpu", "heap", "allocs", "goroutine", "block", "mutex"}
+
+// QueryProfile saves in w a runtime profile of the server listening on the
+// admin socket, which has to be started with "admin.debug".
+func QueryProfile(path, profile string, seconds int, w io.Writer) error {
+	client := adminClient(path)
+	url := "http://gotas/debug/pprof/" + profile
+	switch {
+	case profile == "cpu":
+		if seconds < 1 {
+			return fmt.Errorf("positive number of seconds expected, got %d", seconds)
+		}
+		url = fmt.Sprintf("http://gotas/debug/pprof/profile?seconds=%d", seconds)
+		client.Timeout += time.Duration(seconds) * time.Second
+	case !sliceContains(Profiles, profile):
+		return fmt.Errorf("unknown profile %q, expected one of %v", profile, Profiles)
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("querying the server, is it running? %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("profiling disabled, start the server with %q", AdminDebug)
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("querying the server: %s", resp.Status)
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("reading profile: %v", err)
+	}
+	return nil
+}
+
+// adminSocketPath returns the configured admin socket, relative to root.
+func adminSocketPath(root, socket string) string {
+	if socket == "" {
+		socket = DefaultAdminSocket
+	}
+	if !filepath.IsAbs(socket) {
+		socket = filepath.Join(root, socket)
+	}
+	return socket
+}
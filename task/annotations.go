@@ -0,0 +1,72 @@
+package task
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// annotationPrefix is the prefix of the attributes holding the annotations,
+// followed by the annotation entry epoch.
+const annotationPrefix = "annotation_"
+
+// Annotation is a note added to a task.
+type Annotation struct {
+	Entry       time.Time
+	Description string
+}
+
+// Annotations returns the task annotations sorted by their entry date.
+func (t *Task) Annotations() []Annotation {
+	annotations := make([]Annotation, 0, t.annotationCount)
+	for name, value := range t.data {
+		if !strings.HasPrefix(name, annotationPrefix) {
+			continue
+		}
+
+		epoch, err := strconv.ParseInt(name[len(annotationPrefix):], 10, 64)
+		if err != nil {
+			log.Warnf("Malformed annotation %q: %v", name, err)
+			continue
+		}
+		annotations = append(annotations, Annotation{Entry: time.Unix(epoch, 0).UTC(), Description: value})
+	}
+
+	sort.Slice(annotations, func(i, j int) bool { return annotations[i].Entry.Before(annotations[j].Entry) })
+	return annotations
+}
+
+// AddAnnotation adds an annotation entered at the given date.  As Taskwarrior
+// does, if there is already an annotation entered in the same second, the
+// entry is moved forward until it's unique.
+func (t *Task) AddAnnotation(entry time.Time, description string) Annotation {
+	epoch := entry.Unix()
+	for t.Has(annotationName(epoch)) {
+		epoch++
+	}
+
+	t.setAnnotation(epoch, description)
+	return Annotation{Entry: time.Unix(epoch, 0).UTC(), Description: description}
+}
+
+// RemoveAnnotation removes the annotation entered at the given date and
+// returns true, or false if there is no such annotation.
+func (t *Task) RemoveAnnotation(entry time.Time) bool {
+	name := annotationName(entry.Unix())
+	if !t.Has(name) {
+		return false
+	}
+	t.Remove(name)
+	return true
+}
+
+// setAnnotation sets the annotation entered at epoch, replacing any other
+// entered at the same second.
+func (t *Task) setAnnotation(epoch int64, description string) {
+	t.Set(annotationName(epoch), description)
+}
+
+func annotationName(epoch int64) string {
+	return annotationPrefix + strconv.FormatInt(epoch, 10)
+}
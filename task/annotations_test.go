@@ -0,0 +1,71 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnotations(t *testing.T) {
+	first := time.Date(2021, time.October, 9, 12, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+
+	t.Run("parsed annotations", func(t *testing.T) {
+		task, err := NewTask(readFile(t, "task.json"))
+		assert.NoError(t, err)
+
+		assert.Equal(t, []Annotation{
+			{Entry: time.Unix(1633003241, 0).UTC(), Description: "A small annotation"},
+			{Entry: time.Unix(1633003244, 0).UTC(), Description: "A small annotation 2"},
+		}, task.Annotations())
+		assert.Equal(t, 2, task.annotationCount)
+
+		parsed, err := NewTask(task.ComposeF4())
+		assert.NoError(t, err)
+		assert.Equal(t, task.Annotations(), parsed.Annotations())
+		assert.Equal(t, 2, parsed.annotationCount)
+	})
+
+	t.Run("add and remove annotations", func(t *testing.T) {
+		task := Task{data: map[string]string{"description": "Some task"}}
+		assert.Empty(t, task.Annotations())
+
+		assert.Equal(t, Annotation{Entry: second, Description: "later"}, task.AddAnnotation(second, "later"))
+		assert.Equal(t, Annotation{Entry: first, Description: "sooner"}, task.AddAnnotation(first, "sooner"))
+		// same second, moved forward
+		assert.Equal(t, Annotation{Entry: first.Add(time.Second), Description: "again"}, task.AddAnnotation(first, "again"))
+
+		assert.Equal(t, []Annotation{
+			{Entry: first, Description: "sooner"},
+			{Entry: first.Add(time.Second), Description: "again"},
+			{Entry: second, Description: "later"},
+		}, task.Annotations())
+		assert.Equal(t, 3, task.annotationCount)
+
+		raw, err := task.ComposeJSON()
+		assert.NoError(t, err)
+		assert.Equal(t, `{"annotations":[{"description":"sooner","entry":"20211009T120000Z"},`+
+			`{"description":"again","entry":"20211009T120001Z"},`+
+			`{"description":"later","entry":"20211009T130000Z"}],"description":"Some task"}`, raw)
+
+		assert.True(t, task.RemoveAnnotation(first))
+		assert.False(t, task.RemoveAnnotation(first))
+		assert.Equal(t, []Annotation{
+			{Entry: first.Add(time.Second), Description: "again"},
+			{Entry: second, Description: "later"},
+		}, task.Annotations())
+		assert.Equal(t, 2, task.annotationCount)
+	})
+
+	t.Run("copies keep the count", func(t *testing.T) {
+		task := Task{data: map[string]string{"description": "Some task"}}
+		task.AddAnnotation(first, "note")
+
+		copied := task.Copy()
+		copied.Set(annotationName(second.Unix()), "other")
+		copied.Set(annotationName(second.Unix()), "changed")
+		assert.Equal(t, 1, task.annotationCount)
+		assert.Equal(t, 2, copied.annotationCount)
+	})
+}
@@ -0,0 +1,168 @@
+package task
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	gosync "sync"
+	"time"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+const (
+	// DefaultDriftHistorySize is the number of tasks above which a sync
+	// without a sync key is reported as a full history resend.
+	DefaultDriftHistorySize = 1000
+
+	// DefaultDriftFuture is how far in the future a modification date can be
+	// before it's reported as clock-skewed.
+	DefaultDriftFuture = 24 * time.Hour
+)
+
+// Anomaly is a suspicious sync pattern.
+type Anomaly string
+
+// Anomalies detected during a sync.
+const (
+	// AnomalyBranchPoint is a sync whose key isn't in the user data.
+	AnomalyBranchPoint Anomaly = "branch point not found"
+	// AnomalyFullHistory is a sync without key sending a huge history.
+	AnomalyFullHistory Anomaly = "full history resent"
+	// AnomalyRepeatedPayload is a sync identical to the previous one.
+	AnomalyRepeatedPayload Anomaly = "repeated payload"
+	// AnomalyFutureModification is a task modified far in the future,
+	// usually caused by a client with a skewed clock.
+	AnomalyFutureModification Anomaly = "future modification"
+)
+
+// DriftPolicy are the thresholds used to detect anomalies.
+type DriftPolicy struct {
+	// MaxHistory is the number of tasks above which a sync without a sync
+	// key is reported.  Zero disables the check.
+	MaxHistory int
+
+	// MaxFuture is how far in the future a modification date can be.  Zero
+	// disables the check.
+	MaxFuture time.Duration
+
+	// RejectFuture rejects the syncs with modifications beyond MaxFuture
+	// instead of just reporting them.
+	RejectFuture bool
+}
+
+// AnomalyDetector counts the anomalies found in the syncs, logging a warning
+// for each one.  It remembers the last payload of every user to detect the
+// repeated ones.
+type AnomalyDetector struct {
+	mu       gosync.Mutex
+	counts   map[Anomaly]uint64
+	payloads map[string][sha256.Size]byte
+}
+
+// NewAnomalyDetector creates an AnomalyDetector without anomalies.
+func NewAnomalyDetector() *AnomalyDetector {
+	return &AnomalyDetector{
+		counts:   make(map[Anomaly]uint64),
+		payloads: make(map[string][sha256.Size]byte),
+	}
+}
+
+// Counts returns the number of times each anomaly was found.
+func (d *AnomalyDetector) Counts() map[Anomaly]uint64 {
+	counts := make(map[Anomaly]uint64)
+	if d == nil {
+		return counts
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for k, v := range d.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+// String summarizes the counters, sorted by anomaly.
+func (d *AnomalyDetector) String() string {
+	counts := d.Counts()
+	anomalies := make([]string, 0, len(counts))
+	for k := range counts {
+		anomalies = append(anomalies, string(k))
+	}
+	sort.Strings(anomalies)
+
+	summary := ""
+	for i, a := range anomalies {
+		if i > 0 {
+			summary += ", "
+		}
+		summary += fmt.Sprintf("%s: %d", a, counts[Anomaly(a)])
+	}
+	return summary
+}
+
+// report logs and counts an anomaly.  A nil detector only logs it.
+func (d *AnomalyDetector) report(user auth.User, anomaly Anomaly, detail string) {
+	var count uint64 = 1
+	if d != nil {
+		d.mu.Lock()
+		d.counts[anomaly]++
+		count = d.counts[anomaly]
+		d.mu.Unlock()
+	}
+
+	org := ""
+	if user.Org != nil {
+		org = user.Org.Name
+	}
+	log.Warnf("Sync anomaly from %q (%s): %s, %s (%d so far)", user.Name, org, anomaly, detail, count)
+}
+
+// repeated returns true if the payload is the same the user sent in the
+// previous sync, remembering it for the next one.
+func (d *AnomalyDetector) repeated(user auth.User, payload string) bool {
+	if d == nil {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(payload))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	previous, ok := d.payloads[user.Key]
+	d.payloads[user.Key] = sum
+	return ok && previous == sum
+}
+
+// checkDrift looks for anomalies in the client data, returning an error if
+// the sync has to be rejected according to the policy.
+func checkDrift(user auth.User, payload, tx string, clientData []Task, opts Options) error {
+	d := opts.Anomalies
+
+	if tx == "" && opts.DriftPolicy.MaxHistory > 0 && len(clientData) > opts.DriftPolicy.MaxHistory {
+		d.report(user, AnomalyFullHistory, fmt.Sprintf("%d tasks without sync key", len(clientData)))
+	}
+
+	if len(clientData) > 0 && d.repeated(user, payload) {
+		d.report(user, AnomalyRepeatedPayload, fmt.Sprintf("%d tasks", len(clientData)))
+	}
+
+	if opts.DriftPolicy.MaxFuture <= 0 {
+		return nil
+	}
+
+	limit := time.Now().Add(opts.DriftPolicy.MaxFuture)
+	for _, t := range clientData {
+		if modified := lastModification(t); modified.After(limit) {
+			d.report(user, AnomalyFutureModification, fmt.Sprintf("task %q modified on %s", t.Get("uuid"), modified.Format(time.RFC3339)))
+			if opts.DriftPolicy.RejectFuture {
+				return clientError{catalogStatus{id: MsgFutureModification, args: []interface{}{t.Get("uuid"), modified.Format(time.RFC3339)}}}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package task
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestCheckDrift(t *testing.T) {
+	user := auth.User{Name: "noeh", Key: "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7", Org: &auth.Organization{Name: "Public"}}
+
+	newTask := func(t *testing.T, uuid string, modified time.Time) Task {
+		t.Helper()
+		task, err := NewTask(fmt.Sprintf(`{"uuid":%q,"description":"test","status":"pending","entry":"20210101T000000Z","modified":%q}`,
+			uuid, modified.UTC().Format(DateLayout)))
+		assert.Nil(t, err)
+		return task
+	}
+
+	past := newTask(t, "b2a2b6a4-9a63-4b43-a0c9-1a9b7b1c0a01", time.Now().Add(-time.Hour))
+	future := newTask(t, "b2a2b6a4-9a63-4b43-a0c9-1a9b7b1c0a02", time.Now().Add(48*time.Hour))
+
+	t.Run("full history", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Anomalies = NewAnomalyDetector()
+		opts.DriftPolicy.MaxHistory = 1
+
+		assert.Nil(t, checkDrift(user, "a", "", []Task{past, past}, opts))
+		assert.Nil(t, checkDrift(user, "b", "1a4e0b9f-0f6e-4d2b-9d8b-5a0c0f6f1a1c", []Task{past, past}, opts))
+		assert.Nil(t, checkDrift(user, "c", "", []Task{past}, opts))
+
+		assert.Equal(t, map[Anomaly]uint64{AnomalyFullHistory: 1}, opts.Anomalies.Counts())
+	})
+
+	t.Run("repeated payload", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Anomalies = NewAnomalyDetector()
+
+		assert.Nil(t, checkDrift(user, "payload", "", []Task{past}, opts))
+		assert.Nil(t, checkDrift(user, "payload", "", []Task{past}, opts))
+		assert.Nil(t, checkDrift(user, "other payload", "", []Task{past}, opts))
+		assert.Nil(t, checkDrift(auth.User{Key: "other"}, "other payload", "", []Task{past}, opts))
+
+		assert.Equal(t, map[Anomaly]uint64{AnomalyRepeatedPayload: 1}, opts.Anomalies.Counts())
+	})
+
+	t.Run("future modifications", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Anomalies = NewAnomalyDetector()
+
+		assert.Nil(t, checkDrift(user, "a", "", []Task{past, future}, opts))
+		assert.Equal(t, uint64(1), opts.Anomalies.Counts()[AnomalyFutureModification])
+
+		opts.DriftPolicy.RejectFuture = true
+		err := checkDrift(user, "b", "", []Task{past, future}, opts)
+		assert.IsType(t, clientError{}, err)
+		assert.Contains(t, err.Error(), "check the client clock")
+		assert.Nil(t, checkDrift(user, "c", "", []Task{past}, opts))
+
+		opts.DriftPolicy.MaxFuture = 0
+		assert.Nil(t, checkDrift(user, "d", "", []Task{past, future}, opts))
+		assert.Equal(t, uint64(2), opts.Anomalies.Counts()[AnomalyFutureModification])
+	})
+
+	t.Run("nil detector only logs", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.DriftPolicy.RejectFuture = true
+
+		assert.NotNil(t, checkDrift(user, "a", "", []Task{future}, opts))
+		assert.Empty(t, opts.Anomalies.Counts())
+		assert.Equal(t, "", opts.Anomalies.String())
+	})
+
+	t.Run("summary", func(t *testing.T) {
+		d := NewAnomalyDetector()
+		d.report(user, AnomalyRepeatedPayload, "")
+		d.report(user, AnomalyBranchPoint, "")
+		d.report(user, AnomalyBranchPoint, "")
+
+		assert.Equal(t, "branch point not found: 2, repeated payload: 1", d.String())
+	})
+}
@@ -0,0 +1,354 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+const (
+	// APIPrefix is the path prefix of the REST API.
+	APIPrefix = "/v1/"
+
+	// apiBodyLimit is the maximum size of the tasks sent to the REST API.
+	apiBodyLimit = 1024 * 1024
+)
+
+// APIHandler returns an HTTP handler serving the users tasks, so dashboards
+// and integrations can use them without speaking the sync protocol:
+//
+//	GET /v1/orgs/<org>/users/<key>/tasks
+//	POST /v1/orgs/<org>/users/<key>/tasks
+//	PATCH /v1/orgs/<org>/users/<key>/tasks/<uuid>
+//
+// GET returns the latest state of every task, optionally filtered by
+// "status", "project", including its subprojects, "tag", which can be
+// repeated to require several tags, and "filter", a filter expression (see
+// Filter).  POST creates the task in the request
+// body and PATCH sets the attributes in the request body, removing the null
+// ones.  The changes are appended as a sync does, with a new sync key
+// generated by keys, so the clients get them on their next sync.
+//
+// The requests authenticate as the feed ones, with "<org>/<user>" and the
+// user key as the basic auth credentials, and only give access to the
+// authenticated user tasks.
+func APIHandler(a auth.Authenticator, ra ReadAppender, keys KeyGenerator) http.Handler {
+	if keys == nil {
+		keys = randomKeys{}
+	}
+	api := &taskAPI{ra: ra, keys: keys}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(req.URL.Path, APIPrefix), "/")
+		if !strings.HasPrefix(req.URL.Path, APIPrefix) || len(parts) < 5 || len(parts) > 6 ||
+			parts[0] != "orgs" || parts[2] != "users" || parts[4] != "tasks" {
+			http.NotFound(w, req)
+			return
+		}
+		orgName, userKey := parts[1], parts[3]
+
+		user, ok := authenticateBasic(w, req, a)
+		if !ok {
+			return
+		} else if user.Org.Name != orgName || user.Key != userKey {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		switch {
+		case len(parts) == 5 && req.Method == http.MethodGet:
+			api.list(w, req, user)
+		case len(parts) == 5 && req.Method == http.MethodPost:
+			api.create(w, req, user)
+		case len(parts) == 6 && req.Method == http.MethodPatch:
+			api.modify(w, req, user, parts[5])
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// taskAPI handles the REST API requests.  The modifications lock the user
+// transactions, like the syncs do, so two of them don't read the same tasks.
+type taskAPI struct {
+	ra   ReadAppender
+	keys KeyGenerator
+}
+
+// authenticateBasic authenticates the request basic auth credentials,
+// "<org>/<user>" and the user key, replying with an error if they're not
+// valid.
+func authenticateBasic(w http.ResponseWriter, req *http.Request, a auth.Authenticator) (auth.User, bool) {
+	login, key, ok := req.BasicAuth()
+	names := strings.SplitN(login, "/", 2)
+	if !ok || len(names) != 2 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="gotas"`)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return auth.User{}, false
+	}
+
+	user, err := a.Authenticate(names[0], names[1], key)
+	if err != nil || user.Org == nil {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return auth.User{}, false
+	}
+	return user, true
+}
+
+func (api *taskAPI) list(w http.ResponseWriter, req *http.Request, user auth.User) {
+	query := req.URL.Query()
+	filter, err := ParseFilter(query.Get("filter"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tasks, err := api.tasks(user)
+	if err != nil {
+		log.Errorf("Error reading %q tasks: %v", user.Name, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]json.RawMessage, 0, len(tasks))
+	for _, t := range tasks {
+		if !matchesQuery(t, query.Get("status"), query.Get("project"), query["tag"]) || !filter.Match(t) {
+			continue
+		}
+		raw, err := t.ComposeJSON()
+		if err != nil {
+			log.Errorf("Error composing %q task %v: %v", user.Name, t.Get("uuid"), err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		out = append(out, json.RawMessage(raw))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		log.Errorf("Error sending %q tasks: %v", user.Name, err)
+	}
+}
+
+func (api *taskAPI) create(w http.ResponseWriter, req *http.Request, user auth.User) {
+	fields, ok := readTaskFields(w, req)
+	if !ok {
+		return
+	}
+	if _, ok := fields["uuid"]; !ok {
+		fields["uuid"] = json.RawMessage(`"` + uuid.New().String() + `"`)
+	}
+
+	t, err := fieldsTask(fields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if _, err := uuid.Parse(t.Get("uuid")); err != nil {
+		http.Error(w, "invalid uuid", http.StatusBadRequest)
+		return
+	} else if t.Get("description") == "" {
+		http.Error(w, "description required", http.StatusBadRequest)
+		return
+	}
+
+	defer lockStream(user)()
+
+	tasks, err := api.tasks(user)
+	if err != nil {
+		log.Errorf("Error reading %q tasks: %v", user.Name, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if taskContains(tasks, "uuid", t.Get("uuid")) {
+		http.Error(w, "task already exists", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	if !t.Has("status") {
+		t.Set("status", "pending")
+	}
+	if !t.Has("entry") {
+		t.SetDate("entry", now)
+	}
+	api.store(w, user, t, now, http.StatusCreated)
+}
+
+func (api *taskAPI) modify(w http.ResponseWriter, req *http.Request, user auth.User, taskUUID string) {
+	fields, ok := readTaskFields(w, req)
+	if !ok {
+		return
+	}
+
+	var removed []string
+	for name, value := range fields {
+		if string(value) != "null" {
+			continue
+		}
+		switch name {
+		case "uuid", "description", "entry", "status":
+			http.Error(w, fmt.Sprintf("%s can't be removed", name), http.StatusBadRequest)
+			return
+		}
+		removed = append(removed, name)
+		delete(fields, name)
+	}
+	if value, ok := fields["uuid"]; ok && rawString(value) != taskUUID {
+		http.Error(w, "the task uuid can't be modified", http.StatusBadRequest)
+		return
+	}
+	fields["uuid"] = json.RawMessage(`"` + taskUUID + `"`)
+
+	patch, err := fieldsTask(fields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	defer lockStream(user)()
+
+	tasks, err := api.tasks(user)
+	if err != nil {
+		log.Errorf("Error reading %q tasks: %v", user.Name, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var t Task
+	found := false
+	for _, candidate := range tasks {
+		if candidate.Get("uuid") == taskUUID {
+			t, found = candidate, true
+		}
+	}
+	if !found {
+		http.NotFound(w, req)
+		return
+	}
+
+	for _, name := range patch.GetAttrNames() {
+		t.Set(name, patch.Get(name))
+	}
+	for _, name := range removed {
+		t.Remove(name)
+	}
+
+	now := time.Now()
+	if status := t.Get("status"); (status == "completed" || status == "deleted") && !t.Has("end") {
+		t.SetDate("end", now)
+	}
+	api.store(w, user, t, now, http.StatusOK)
+}
+
+// store appends a new version of the task, modified now, followed by a new
+// sync key, and replies with the task.
+func (api *taskAPI) store(w http.ResponseWriter, user auth.User, t Task, now time.Time, code int) {
+	t.SetDate("modified", now)
+	raw, err := t.ComposeJSON()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := StoreTasks(api.ra, user, []Task{t}, api.keys); err != nil {
+		if redirect, ok := err.(RedirectError); ok {
+			http.Error(w, fmt.Sprintf("read-only replica, the primary is %s", redirect.Address), http.StatusServiceUnavailable)
+			return
+		} else if readOnly, ok := err.(ReadOnlyError); ok {
+			code := http.StatusForbidden
+			if readOnly.Org == "" {
+				w.Header().Set("Retry-After", strconv.Itoa(MaintenanceRetryAfter))
+				code = http.StatusServiceUnavailable
+			}
+			http.Error(w, readOnly.Error(), code)
+			return
+		}
+		log.Errorf("Error storing %q task %v: %v", user.Name, t.Get("uuid"), err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	log.Infof("Stored task %v of %q through the REST API", t.Get("uuid"), user.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := io.WriteString(w, raw+"\n"); err != nil {
+		log.Errorf("Error sending %q task: %v", user.Name, err)
+	}
+}
+
+// StoreTasks appends the tasks followed by a new sync key generated by keys,
+// random if nil, as a sync does, so the clients get them on their next sync.
+func StoreTasks(a Appender, user auth.User, tasks []Task, keys KeyGenerator) error {
+	if keys == nil {
+		keys = randomKeys{}
+	}
+
+	lines := make([]string, 0, len(tasks)+1)
+	for _, t := range tasks {
+		raw, err := t.ComposeJSON()
+		if err != nil {
+			return err
+		}
+		lines = append(lines, raw+"\n")
+	}
+	return a.Append(user, append(lines, keys.NewKey()+"\n"))
+}
+
+func (api *taskAPI) tasks(user auth.User) ([]Task, error) {
+	data, err := api.ra.Read(user)
+	if err != nil {
+		return nil, err
+	}
+	return LatestTasks(data)
+}
+
+// readTaskFields reads the JSON object in the request body, replying with an
+// error if it's not valid.
+func readTaskFields(w http.ResponseWriter, req *http.Request) (map[string]json.RawMessage, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(io.LimitReader(req.Body, apiBodyLimit)).Decode(&fields); err != nil || fields == nil {
+		http.Error(w, "JSON object expected", http.StatusBadRequest)
+		return nil, false
+	}
+	return fields, true
+}
+
+// fieldsTask parses the task fields as a task received in a sync.
+func fieldsTask(fields map[string]json.RawMessage) (Task, error) {
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return Task{}, err
+	}
+	return NewTask(string(raw))
+}
+
+// matchesQuery returns true if the task has the status, belongs to the
+// project or one of its subprojects, and has every tag.  Empty values match
+// any task.
+func matchesQuery(t Task, status, project string, tags []string) bool {
+	if status != "" && t.Get("status") != status {
+		return false
+	}
+
+	if project != "" {
+		if p := t.Get("project"); p != project && !strings.HasPrefix(p, project+".") {
+			return false
+		}
+	}
+
+	taskTags := t.Tags()
+	for _, tag := range tags {
+		if tag != "" && !sliceContains(taskTags, tag) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,179 @@
+package task
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestAPIHandler(t *testing.T) {
+	ra := newMemReadAppender()
+	user := auth.User{Name: "noeh", Key: "secret", Org: &auth.Organization{Name: "Public"}}
+	assert.NoError(t, ra.Append(user, []string{
+		`{"description":"Pay bills","entry":"20211009T112536Z","project":"home","status":"pending","tags":["bills","urgent"],"uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}` + "\n",
+		`{"description":"Fix the roof","entry":"20211009T112536Z","project":"home.repairs","status":"pending","tags":["urgent"],"uuid":"2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b"}` + "\n",
+		`{"description":"Write report","entry":"20211009T112536Z","project":"homework","status":"pending","uuid":"0c1a7a3e-1b0b-4a4e-9d4d-2f0a6a5a8c01"}` + "\n",
+		"94978aad-fbaf-4876-92e0-33321f1cbab9\n",
+		`{"description":"Pay bills","end":"20211010T100000Z","entry":"20211009T112536Z","project":"home","status":"completed","tags":["bills","urgent"],"uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}` + "\n",
+		"6c1b7f2e-0f5e-4b8e-a1d9-3f6a2b1c0d9e\n",
+	}))
+
+	server := httptest.NewServer(APIHandler(feedAuth{}, ra, NewSequentialKeys()))
+	defer server.Close()
+
+	get := func(t *testing.T, login, key, path string) (int, []string) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		assert.NoError(t, err)
+		if login != "" {
+			req.SetBasicAuth(login, key)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		var descriptions []string
+		if resp.StatusCode == http.StatusOK {
+			var tasks []map[string]interface{}
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&tasks))
+			for _, task := range tasks {
+				descriptions = append(descriptions, task["description"].(string))
+			}
+		}
+		return resp.StatusCode, descriptions
+	}
+
+	tasksPath := "/v1/orgs/Public/users/secret/tasks"
+	cases := []struct {
+		name     string
+		login    string
+		key      string
+		path     string
+		code     int
+		expected []string
+	}{
+		{"all", "Public/noeh", "secret", tasksPath, 200, []string{"Pay bills", "Fix the roof", "Write report"}},
+		{"by status", "Public/noeh", "secret", tasksPath + "?status=pending", 200, []string{"Fix the roof", "Write report"}},
+		{"by project", "Public/noeh", "secret", tasksPath + "?project=home", 200, []string{"Pay bills", "Fix the roof"}},
+		{"by tags", "Public/noeh", "secret", tasksPath + "?tag=urgent&tag=bills", 200, []string{"Pay bills"}},
+		{"by filter", "Public/noeh", "secret", tasksPath + "?filter=status:pending+%2Burgent", 200, []string{"Fix the roof"}},
+		{"invalid filter", "Public/noeh", "secret", tasksPath + "?filter=due.before:tomorrow", 400, nil},
+		{"no match", "Public/noeh", "secret", tasksPath + "?status=deleted", 200, nil},
+		{"no credentials", "", "", tasksPath, 401, nil},
+		{"invalid credentials", "Public/noeh", "invalid", tasksPath, 401, nil},
+		{"another user", "Public/noeh", "secret", "/v1/orgs/Public/users/other/tasks", 403, nil},
+		{"another org", "Public/noeh", "secret", "/v1/orgs/Private/users/secret/tasks", 403, nil},
+		{"unknown path", "Public/noeh", "secret", "/v1/orgs/Public/users/secret", 404, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, descriptions := get(t, c.login, c.key, c.path)
+			assert.Equal(t, c.code, code)
+			assert.Equal(t, c.expected, descriptions)
+		})
+	}
+
+	send := func(t *testing.T, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		assert.NoError(t, err)
+		req.SetBasicAuth("Public/noeh", "secret")
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		var task map[string]interface{}
+		if resp.StatusCode < 300 {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&task))
+		}
+		return resp.StatusCode, task
+	}
+
+	t.Run("create", func(t *testing.T) {
+		code, task := send(t, http.MethodPost, tasksPath, `{"description":"Sent by email","tags":["inbox"]}`)
+		assert.Equal(t, http.StatusCreated, code)
+		assert.Equal(t, "Sent by email", task["description"])
+		assert.Equal(t, "pending", task["status"])
+		assert.NotEmpty(t, task["uuid"])
+		assert.NotEmpty(t, task["entry"])
+		assert.NotEmpty(t, task["modified"])
+
+		data, err := ra.Read(user)
+		assert.NoError(t, err)
+		assert.Equal(t, "00000000-0000-0000-0000-000000000001", data[len(data)-1])
+		assert.Contains(t, data[len(data)-2], "Sent by email")
+
+		_, descriptions := get(t, "Public/noeh", "secret", tasksPath+"?tag=inbox")
+		assert.Equal(t, []string{"Sent by email"}, descriptions)
+	})
+
+	t.Run("create invalid", func(t *testing.T) {
+		for _, body := range []string{
+			`not json`,
+			`["description"]`,
+			`{"status":"pending"}`,
+			`{"description":"Invalid uuid","uuid":"1234"}`,
+			`{"description":"Invalid date","due":"tomorrow"}`,
+		} {
+			code, _ := send(t, http.MethodPost, tasksPath, body)
+			assert.Equal(t, http.StatusBadRequest, code, body)
+		}
+
+		code, _ := send(t, http.MethodPost, tasksPath, `{"description":"Again","uuid":"2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b"}`)
+		assert.Equal(t, http.StatusConflict, code)
+	})
+
+	t.Run("modify", func(t *testing.T) {
+		path := tasksPath + "/2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b"
+		code, task := send(t, http.MethodPatch, path, `{"status":"completed","project":null,"priority":"H"}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "Fix the roof", task["description"])
+		assert.Equal(t, "completed", task["status"])
+		assert.Equal(t, "H", task["priority"])
+		assert.NotContains(t, task, "project")
+		assert.NotEmpty(t, task["end"])
+
+		data, err := ra.Read(user)
+		assert.NoError(t, err)
+		assert.Equal(t, "00000000-0000-0000-0000-000000000002", data[len(data)-1])
+
+		_, descriptions := get(t, "Public/noeh", "secret", tasksPath+"?status=completed")
+		assert.Equal(t, []string{"Pay bills", "Fix the roof"}, descriptions)
+	})
+
+	t.Run("modify invalid", func(t *testing.T) {
+		path := tasksPath + "/2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b"
+		for _, body := range []string{
+			`{"description":null}`,
+			`{"uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`,
+			`{"due":"tomorrow"}`,
+		} {
+			code, _ := send(t, http.MethodPatch, path, body)
+			assert.Equal(t, http.StatusBadRequest, code, body)
+		}
+
+		code, _ := send(t, http.MethodPatch, tasksPath+"/6c1b7f2e-0f5e-4b8e-a1d9-3f6a2b1c0d9e", `{"priority":"L"}`)
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, server.URL+tasksPath, nil)
+		assert.NoError(t, err)
+		req.SetBasicAuth("Public/noeh", "secret")
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	})
+}
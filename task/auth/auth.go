@@ -1,21 +1,124 @@
 package auth
 
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
 // Authenticator exposes the logic needed to deal with security functionality
 type Authenticator interface {
 	Authenticate(org, user, key string) (User, error)
 }
 
-// Organization represents an Organization grouping users.
+// PeerAuthenticator is an Authenticator that also takes into account the
+// client identity seen by the transport.
+type PeerAuthenticator interface {
+	AuthenticatePeer(org, user, key string, peer Peer) (User, error)
+}
+
+// Peer is the client identity seen by the transport: its address, the
+// server name requested (SNI) and the certificates presented, leaf first.
+// The certificates can only be trusted if Verified, the transport accepts
+// any certificate with "trust = allow all".
+type Peer struct {
+	Address      string
+	ServerName   string
+	Certificates []*x509.Certificate
+	// Verified is true if the certificates chain to a trusted CA.
+	Verified bool
+	// Anonymous is true if the transport accepted the connection without a
+	// client certificate, only the health probes are answered then.
+	Anonymous bool
+}
+
+// CommonName returns the client certificate common name, or an empty string
+// if the client didn't present any.  It's only an identity if Verified.
+func (p Peer) CommonName() string {
+	if len(p.Certificates) == 0 {
+		return ""
+	}
+	return p.Certificates[0].Subject.CommonName
+}
+
+// String describes the peer for the logs.
+func (p Peer) String() string {
+	if cn := p.CommonName(); cn != "" {
+		return p.Address + " (" + cn + ")"
+	}
+	return p.Address
+}
+
+// Organization represents an Organization grouping users.  Deleted is the
+// time the organization was removed, or the zero time if it is active.
+// Settings are the organization configuration entries, like the ones applied
+// by a template when it was created.
 type Organization struct {
-	Name  string
-	Users []User
+	Name    string
+	Users   []User
+	Deleted time.Time
+	// ReadOnly organizations can sync but not upload changes.
+	ReadOnly bool
+	Settings map[string]string
 }
 
-// User is a system user, it belongs to one organization.
+// User is a system user, it belongs to one organization.  Users belonging to
+// the same Group share their task list.  Deleted and Suspended are the times
+// the user was removed or suspended, or the zero time if it is active.
 type User struct {
-	Name string
-	Key  string
-	Org  *Organization
+	Name      string
+	Key       string
+	Org       *Organization
+	Group     string
+	Role      Role
+	Deleted   time.Time
+	Suspended time.Time
+}
+
+// Role is what a user can manage besides its own tasks.
+type Role string
+
+const (
+	// RoleUser only gives access to the user tasks.
+	RoleUser Role = "user"
+	// RoleOrgAdmin manages the users of its organization.
+	RoleOrgAdmin Role = "org-admin"
+	// RoleServerAdmin manages the users of every organization.
+	RoleServerAdmin Role = "server-admin"
+)
+
+// ParseRole parses a role name, an empty one being RoleUser.
+func ParseRole(name string) (Role, error) {
+	switch Role(name) {
+	case "", RoleUser:
+		return RoleUser, nil
+	case RoleOrgAdmin, RoleServerAdmin:
+		return Role(name), nil
+	}
+	return "", fmt.Errorf("invalid role %q, either %v, %v or %v expected", name, RoleUser, RoleOrgAdmin, RoleServerAdmin)
+}
+
+// CanManage returns true if the user can manage the users of the
+// organization.
+func (u User) CanManage(orgName string) bool {
+	switch u.Role {
+	case RoleServerAdmin:
+		return true
+	case RoleOrgAdmin:
+		return u.Org != nil && u.Org.Name == orgName
+	}
+	return false
+}
+
+// PermissionError is returned when a user is not allowed to do an operation.
+type PermissionError struct {
+	User string
+	Msg  string
+}
+
+// Error makes PermissionError an error.
+func (e PermissionError) Error() string {
+	return fmt.Sprintf("user %q is not allowed to %s", e.User, e.Msg)
 }
 
 // AuthenticationError represents any authentication-related error.  It
@@ -0,0 +1,156 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BenchPattern is how the synthetic clients of a benchmark sync.
+type BenchPattern string
+
+// Supported benchmark sync patterns.
+const (
+	// BenchIncremental clients keep the sync key, as taskwarrior does, so
+	// they only get the tasks changed since their previous sync.
+	BenchIncremental BenchPattern = "incremental"
+	// BenchFull clients never send the sync key, so every response carries
+	// all the user tasks.
+	BenchFull BenchPattern = "full"
+)
+
+// benchClient is the client name sent by the synthetic clients.
+const benchClient = "gotas-bench"
+
+// BenchConfig configures a benchmark: Clients concurrent clients run Syncs
+// syncs each, every one sending Tasks new tasks whose descriptions are
+// TaskSize bytes long.
+type BenchConfig struct {
+	Credentials Credentials
+	Clients     int
+	Syncs       int
+	Tasks       int
+	TaskSize    int
+	Pattern     BenchPattern
+}
+
+// BenchResult is the outcome of a benchmark.  The errors are the syncs that
+// couldn't be completed, the ones rejected by the server are counted by
+// code.
+type BenchResult struct {
+	Syncs      int            `json:"syncs"`
+	Errors     int            `json:"errors"`
+	Codes      map[string]int `json:"codes"`
+	Duration   time.Duration  `json:"duration"`
+	Throughput float64        `json:"throughput"`
+	AvgLatency time.Duration  `json:"avg_latency"`
+	P50Latency time.Duration  `json:"p50_latency"`
+	P95Latency time.Duration  `json:"p95_latency"`
+	P99Latency time.Duration  `json:"p99_latency"`
+}
+
+// ParseBenchPattern parses a benchmark sync pattern.
+func ParseBenchPattern(value string) (BenchPattern, error) {
+	switch pattern := BenchPattern(value); pattern {
+	case BenchIncremental, BenchFull:
+		return pattern, nil
+	}
+	return "", fmt.Errorf("either %q or %q expected, got %q", BenchIncremental, BenchFull, value)
+}
+
+// Bench runs the benchmark, dial connects a new client for every sync as
+// taskwarrior does.
+func Bench(dial func() (io.ReadWriteCloser, error), cfg BenchConfig) BenchResult {
+	var mu gosync.Mutex
+	var wg gosync.WaitGroup
+	var latencies []time.Duration
+	result := BenchResult{Codes: make(map[string]int)}
+
+	start := time.Now()
+	for i := 0; i < cfg.Clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			syncKey := ""
+			for j := 0; j < cfg.Syncs; j++ {
+				syncStart := time.Now()
+				resp, err := benchSync(dial, cfg, syncKey)
+				latency := time.Since(syncStart)
+
+				mu.Lock()
+				if err != nil {
+					log.Debugf("Benchmark sync failed: %v", err)
+					result.Errors++
+				} else {
+					result.Syncs++
+					result.Codes[resp.Header["code"]]++
+					latencies = append(latencies, latency)
+				}
+				mu.Unlock()
+
+				if err == nil && cfg.Pattern == BenchIncremental {
+					if key := SyncKey(resp); key != "" {
+						syncKey = key
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	result.Duration = time.Since(start)
+
+	if result.Duration > 0 {
+		result.Throughput = float64(result.Syncs) / result.Duration.Seconds()
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	if len(latencies) > 0 {
+		result.AvgLatency = total / time.Duration(len(latencies))
+	}
+	result.P50Latency = percentile(latencies, 50)
+	result.P95Latency = percentile(latencies, 95)
+	result.P99Latency = percentile(latencies, 99)
+
+	return result
+}
+
+func benchSync(dial func() (io.ReadWriteCloser, error), cfg BenchConfig, syncKey string) (Message, error) {
+	conn, err := dial()
+	if err != nil {
+		return Message{}, err
+	}
+	defer conn.Close()
+
+	tasks := make([]string, cfg.Tasks)
+	for i := range tasks {
+		if tasks[i], err = benchTask(cfg.TaskSize); err != nil {
+			return Message{}, err
+		}
+	}
+
+	return Sync(conn, SyncRequest(cfg.Credentials, benchClient, tasks, syncKey))
+}
+
+// benchTask generates a new pending task.
+func benchTask(size int) (string, error) {
+	now := time.Now().UTC().Format(DateLayout)
+	data, err := json.Marshal(map[string]string{
+		"description": strings.Repeat("x", size),
+		"entry":       now,
+		"modified":    now,
+		"status":      "pending",
+		"uuid":        uuid.New().String(),
+	})
+	return string(data), err
+}
@@ -0,0 +1,71 @@
+package task
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+type orgAuth struct{}
+
+func (a orgAuth) Authenticate(org, user, key string) (auth.User, error) {
+	return auth.User{Name: user, Key: key, Org: &auth.Organization{Name: org}}, nil
+}
+
+func TestBench(t *testing.T) {
+	creds, err := ParseCredentials("Public/sebas/8749ee17-7949-4ce2-91dd-fcc3e0131305")
+	assert.Nil(t, err)
+
+	for _, pattern := range []BenchPattern{BenchIncremental, BenchFull} {
+		t.Run(string(pattern), func(t *testing.T) {
+			ra := newMemReadAppender()
+			dial := func() (io.ReadWriteCloser, error) {
+				client, server := net.Pipe()
+				go Process(server, orgAuth{}, ra, DefaultOptions())
+				return client, nil
+			}
+
+			result := Bench(dial, BenchConfig{
+				Credentials: creds,
+				Clients:     3,
+				Syncs:       4,
+				Tasks:       2,
+				TaskSize:    64,
+				Pattern:     pattern,
+			})
+
+			assert.Equal(t, 12, result.Syncs)
+			assert.Equal(t, 0, result.Errors)
+			assert.Equal(t, map[string]int{"200": 12}, result.Codes)
+			assert.True(t, result.Throughput > 0)
+			assert.True(t, result.P50Latency <= result.P99Latency)
+
+			// every sync stores its tasks and a new sync key
+			data, _ := ra.Read(auth.User{Key: creds.Key, Org: &auth.Organization{Name: creds.Org}})
+			assert.Equal(t, 12*3, len(data))
+		})
+	}
+}
+
+func TestClient(t *testing.T) {
+	t.Run("credentials", func(t *testing.T) {
+		creds, err := ParseCredentials("Public/sebas/key")
+		assert.Nil(t, err)
+		assert.Equal(t, Credentials{"Public", "sebas", "key"}, creds)
+
+		for _, invalid := range []string{"", "Public/sebas", "Public//key", "a/b/c/d"} {
+			_, err := ParseCredentials(invalid)
+			assert.NotNil(t, err, invalid)
+		}
+	})
+
+	t.Run("sync key", func(t *testing.T) {
+		key := "47b6cbe5-975a-406a-a02d-8a8b03fa0cd9"
+		assert.Equal(t, key, SyncKey(Message{Payload: "{\"uuid\":\"1\"}\n" + key + "\n"}))
+		assert.Equal(t, "", SyncKey(Message{Payload: "{\"uuid\":\"1\"}\n"}))
+		assert.Equal(t, "", SyncKey(Message{}))
+	})
+}
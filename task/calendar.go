@@ -0,0 +1,254 @@
+package task
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+const (
+	icsDateLayout = "20060102T150405Z"
+	icsLineLimit  = 75
+)
+
+// The invalid calendar tokens a client address can try every window, the
+// next requests are rejected until it ends, to keep the tokens from being
+// guessed.
+const (
+	calendarFailureLimit  = 10
+	calendarFailureWindow = time.Minute
+)
+
+// CalendarComponent is the iCalendar component used to represent tasks.
+type CalendarComponent string
+
+const (
+	// CalendarTodo represents tasks as to-dos, which preserves the task
+	// status but is not supported by every calendar app.
+	CalendarTodo CalendarComponent = "VTODO"
+
+	// CalendarEvent represents tasks as events happening at their due date.
+	CalendarEvent CalendarComponent = "VEVENT"
+)
+
+var icsPriorities = map[string]string{
+	"H": "1",
+	"M": "5",
+	"L": "9",
+}
+
+// CalendarUsers finds the user owning a calendar token.
+type CalendarUsers interface {
+	UserByCalendarToken(token string) (auth.User, error)
+}
+
+// ComposeICS writes the tasks having a due date as an iCalendar.  Deleted
+// tasks and recurring task templates are skipped.
+func ComposeICS(w io.Writer, name string, component CalendarComponent, tasks []Task) error {
+	out := bufio.NewWriter(w)
+
+	writeICSLine(out, "BEGIN:VCALENDAR")
+	writeICSLine(out, "VERSION:2.0")
+	writeICSLine(out, "PRODID:-//gotas//gotas//EN")
+	writeICSLine(out, "X-WR-CALNAME:"+escapeICS(name))
+
+	for _, t := range tasks {
+		status := t.Get("status")
+		if !t.Has("due") || status == "deleted" || status == "recurring" {
+			continue
+		}
+
+		due := t.GetDate("due").Format(icsDateLayout)
+		stamp := t.GetDate("modified")
+		if stamp.IsZero() {
+			stamp = t.GetDate("entry")
+		}
+
+		writeICSLine(out, "BEGIN:"+string(component))
+		writeICSLine(out, "UID:"+t.Get("uuid")+"@gotas")
+		writeICSLine(out, "DTSTAMP:"+stamp.Format(icsDateLayout))
+		writeICSLine(out, "SUMMARY:"+escapeICS(t.Get("description")))
+		if component == CalendarEvent {
+			writeICSLine(out, "DTSTART:"+due)
+			writeICSLine(out, "DTEND:"+due)
+		} else {
+			writeICSLine(out, "DUE:"+due)
+			if status == "completed" {
+				writeICSLine(out, "STATUS:COMPLETED")
+				if t.Has("end") {
+					writeICSLine(out, "COMPLETED:"+t.GetDate("end").Format(icsDateLayout))
+				}
+			} else {
+				writeICSLine(out, "STATUS:NEEDS-ACTION")
+			}
+		}
+		if priority, ok := icsPriorities[t.Get("priority")]; ok {
+			writeICSLine(out, "PRIORITY:"+priority)
+		}
+		if categories := t.Tags(); len(categories) > 0 {
+			for i := range categories {
+				categories[i] = escapeICS(categories[i])
+			}
+			writeICSLine(out, "CATEGORIES:"+strings.Join(categories, ","))
+		}
+		if project := t.Get("project"); project != "" {
+			writeICSLine(out, "X-GOTAS-PROJECT:"+escapeICS(project))
+		}
+		writeICSLine(out, "END:"+string(component))
+	}
+
+	writeICSLine(out, "END:VCALENDAR")
+
+	return out.Flush()
+}
+
+// CalendarHandler returns an HTTP handler serving the user calendars at
+// "/<token>.ics".  The "component" parameter selects how the tasks are
+// represented, either "todo" (the default) or "event".  The clients trying
+// too many invalid tokens are rejected with a 429 code for a while.
+func CalendarHandler(users CalendarUsers, r Reader) http.Handler {
+	failures := &failureLimiter{limit: calendarFailureLimit, window: calendarFailureWindow}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/"), ".ics")
+		if token == "" || strings.Contains(token, "/") {
+			http.NotFound(w, req)
+			return
+		}
+
+		component := CalendarTodo
+		switch req.URL.Query().Get("component") {
+		case "", "todo":
+		case "event":
+			component = CalendarEvent
+		default:
+			http.Error(w, "invalid component", http.StatusBadRequest)
+			return
+		}
+
+		address, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			address = req.RemoteAddr
+		}
+		if wait := failures.wait(address); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			http.Error(w, "too many invalid tokens", http.StatusTooManyRequests)
+			return
+		}
+
+		user, err := users.UserByCalendarToken(token)
+		if err != nil {
+			failures.failed(address)
+			http.NotFound(w, req)
+			return
+		}
+
+		data, err := r.Read(user)
+		if err != nil {
+			log.Errorf("Error reading %q calendar data: %v", user.Name, err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		tasks, err := LatestTasks(data)
+		if err != nil {
+			log.Errorf("Error parsing %q calendar data: %v", user.Name, err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		if req.Method == http.MethodHead {
+			return
+		}
+		if err := ComposeICS(w, fmt.Sprintf("%s tasks", user.Name), component, tasks); err != nil {
+			log.Errorf("Error sending %q calendar: %v", user.Name, err)
+		}
+	})
+}
+
+// failureLimiter counts the failures of every client address in fixed
+// windows, forgetting them all when a window ends.
+type failureLimiter struct {
+	mu       gosync.Mutex
+	limit    int
+	window   time.Duration
+	started  time.Time
+	failures map[string]int
+}
+
+// wait returns how long the address has to wait until its requests are
+// accepted again, zero if it didn't reach the limit.
+func (l *failureLimiter) wait(address string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rotate()
+	if l.failures[address] < l.limit {
+		return 0
+	}
+	return time.Until(l.started.Add(l.window))
+}
+
+// failed records a failure of the address.
+func (l *failureLimiter) failed(address string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rotate()
+	l.failures[address]++
+}
+
+// rotate starts a new window once the current one ends.  It must be called
+// holding mu.
+func (l *failureLimiter) rotate() {
+	if now := time.Now(); l.failures == nil || now.Sub(l.started) >= l.window {
+		l.started = now
+		l.failures = make(map[string]int)
+	}
+}
+
+var icsEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+// escapeICS escapes an iCalendar text value.
+func escapeICS(value string) string {
+	return icsEscaper.Replace(value)
+}
+
+// writeICSLine writes a content line folding it at 75 octets, without
+// splitting multi-byte characters.
+func writeICSLine(w *bufio.Writer, line string) {
+	limit := icsLineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		// continuation lines start with a space
+		limit = icsLineLimit - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
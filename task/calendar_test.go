@@ -0,0 +1,163 @@
+package task
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+type mockCalendarUsers struct{}
+
+func (mockCalendarUsers) UserByCalendarToken(token string) (auth.User, error) {
+	if token != "secret" {
+		return auth.User{}, errors.New("invalid token")
+	}
+	return auth.User{Name: "noeh", Org: &auth.Organization{Name: "Public"}}, nil
+}
+
+var calendarData = []string{
+	`{"description":"Pay bills, taxes; etc","due":"20211009T220000Z","entry":"20211009T112536Z","priority":"H","project":"home","status":"pending","tags":["T2","tagTwo"],"uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`,
+	`94978aad-fbaf-4876-92e0-33321f1cbab9`,
+	`{"description":"No due date","entry":"20211009T112536Z","status":"pending","uuid":"2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b"}`,
+	`{"description":"Deleted","due":"20211009T220000Z","entry":"20211009T112536Z","status":"deleted","uuid":"0c1a7a3e-1b0b-4a4e-9d4d-2f0a6a5a8c01"}`,
+	`{"description":"Done","due":"20211010T220000Z","end":"20211010T100000Z","entry":"20211009T112536Z","modified":"20211010T100000Z","status":"completed","uuid":"6c1b7f2e-0f5e-4b8e-a1d9-3f6a2b1c0d9e"}`,
+}
+
+func TestComposeICS(t *testing.T) {
+	tasks, err := LatestTasks(calendarData)
+	assert.NoError(t, err)
+
+	t.Run("todos", func(t *testing.T) {
+		var out strings.Builder
+		assert.NoError(t, ComposeICS(&out, "noeh tasks", CalendarTodo, tasks))
+
+		expected := strings.Join([]string{
+			"BEGIN:VCALENDAR",
+			"VERSION:2.0",
+			"PRODID:-//gotas//gotas//EN",
+			"X-WR-CALNAME:noeh tasks",
+			"BEGIN:VTODO",
+			"UID:e346004f-6ebb-4507-8f21-0ba2b8f263d8@gotas",
+			"DTSTAMP:20211009T112536Z",
+			`SUMMARY:Pay bills\, taxes\; etc`,
+			"DUE:20211009T220000Z",
+			"STATUS:NEEDS-ACTION",
+			"PRIORITY:1",
+			"CATEGORIES:T2,tagTwo",
+			"X-GOTAS-PROJECT:home",
+			"END:VTODO",
+			"BEGIN:VTODO",
+			"UID:6c1b7f2e-0f5e-4b8e-a1d9-3f6a2b1c0d9e@gotas",
+			"DTSTAMP:20211010T100000Z",
+			"SUMMARY:Done",
+			"DUE:20211010T220000Z",
+			"STATUS:COMPLETED",
+			"COMPLETED:20211010T100000Z",
+			"END:VTODO",
+			"END:VCALENDAR",
+			"",
+		}, "\r\n")
+		assert.Equal(t, expected, out.String())
+	})
+
+	t.Run("events", func(t *testing.T) {
+		var out strings.Builder
+		assert.NoError(t, ComposeICS(&out, "noeh tasks", CalendarEvent, tasks))
+
+		assert.Equal(t, 2, strings.Count(out.String(), "BEGIN:VEVENT"))
+		assert.Contains(t, out.String(), "DTSTART:20211009T220000Z\r\nDTEND:20211009T220000Z\r\n")
+		assert.NotContains(t, out.String(), "STATUS:")
+	})
+}
+
+func TestWriteICSLine(t *testing.T) {
+	var out strings.Builder
+	w := bufio.NewWriter(&out)
+
+	line := "SUMMARY:" + strings.Repeat("ñ", 100)
+	writeICSLine(w, line)
+	assert.NoError(t, w.Flush())
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\r\n"), "\r\n")
+	assert.Greater(t, len(lines), 1)
+	unfolded := lines[0]
+	for _, l := range lines[1:] {
+		assert.True(t, strings.HasPrefix(l, " "))
+		unfolded += l[1:]
+	}
+	for _, l := range lines {
+		assert.LessOrEqual(t, len(l), icsLineLimit)
+	}
+	assert.Equal(t, line, unfolded)
+}
+
+func TestCalendarHandler(t *testing.T) {
+	ra := newMemReadAppender()
+	user, _ := mockCalendarUsers{}.UserByCalendarToken("secret")
+	assert.NoError(t, ra.Append(user, calendarData))
+
+	server := httptest.NewServer(CalendarHandler(mockCalendarUsers{}, ra))
+	defer server.Close()
+
+	cases := []struct {
+		path string
+		code int
+	}{
+		{"/secret.ics", http.StatusOK},
+		{"/secret.ics?component=event", http.StatusOK},
+		{"/secret.ics?component=journal", http.StatusBadRequest},
+		{"/invalid.ics", http.StatusNotFound},
+		{"/", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			resp, err := http.Get(server.URL + c.path)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, c.code, resp.StatusCode)
+			if c.code == http.StatusOK {
+				assert.Equal(t, "text/calendar; charset=utf-8", resp.Header.Get("Content-Type"))
+			}
+		})
+	}
+
+	t.Run("too many invalid tokens", func(t *testing.T) {
+		limited := httptest.NewServer(CalendarHandler(mockCalendarUsers{}, ra))
+		defer limited.Close()
+
+		for i := 0; i < calendarFailureLimit; i++ {
+			resp, err := http.Get(limited.URL + "/invalid.ics")
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		}
+
+		resp, err := http.Get(limited.URL + "/secret.ics")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+	})
+}
+
+func TestFailureLimiter(t *testing.T) {
+	limiter := &failureLimiter{limit: 2, window: 50 * time.Millisecond}
+
+	limiter.failed("192.0.2.1")
+	assert.Zero(t, limiter.wait("192.0.2.1"))
+	limiter.failed("192.0.2.1")
+	assert.NotZero(t, limiter.wait("192.0.2.1"))
+	assert.Zero(t, limiter.wait("192.0.2.2"))
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Zero(t, limiter.wait("192.0.2.1"))
+}
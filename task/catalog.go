@@ -0,0 +1,168 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// LocaleHeader is the optional request header choosing the language of the
+// response statuses, e.g. "es" or "es-AR".  The statuses are in English
+// unless the locale is in the catalog.
+const LocaleHeader = "locale"
+
+// DefaultLocale is the locale of the statuses when the client doesn't ask for
+// a supported one.
+const DefaultLocale = "en"
+
+// MessageID identifies a client-visible status in the catalog.  The ids are
+// stable, so the texts can be reworded and translated without changing the
+// code.
+type MessageID string
+
+// Client-visible statuses.
+const (
+	MsgInternalError        MessageID = "internal-error"
+	MsgInternalErrorRequest MessageID = "internal-error-request"
+	MsgReadFailed           MessageID = "read-failed"
+	MsgUnknownSyncKey       MessageID = "unknown-sync-key"
+	MsgNoCommonAncestor     MessageID = "no-common-ancestor"
+	MsgUnknownType          MessageID = "unknown-type"
+	MsgDryRunDenied         MessageID = "dry-run-denied"
+	MsgStatisticsDenied     MessageID = "statistics-denied"
+	MsgQuotaExceeded        MessageID = "quota-exceeded"
+	MsgServerBusy           MessageID = "server-busy"
+	MsgMemoryBudget         MessageID = "memory-budget"
+	MsgExtensionFailed      MessageID = "extension-failed"
+	MsgCertificateRequired  MessageID = "certificate-required"
+	MsgReadOnly             MessageID = "read-only"
+	MsgOrgReadOnly          MessageID = "org-read-only"
+	MsgSyntaxError          MessageID = "syntax-error"
+	MsgMessageTooBig        MessageID = "message-too-big"
+	MsgNoSeparator          MessageID = "no-separator"
+	MsgMalformedData        MessageID = "malformed-data"
+	MsgUnsupportedEncoding  MessageID = "unsupported-encoding"
+	MsgRequestTooBig        MessageID = "request-too-big"
+	MsgFutureModification   MessageID = "future-modification"
+)
+
+// catalog are the statuses texts by locale, formatted with fmt.Sprintf.  The
+// English ones are the taskd texts when taskd has them, the clients and the
+// conformance suite may match them.
+var catalog = map[string]map[MessageID]string{
+	"en": {
+		MsgInternalError:        "Internal server error",
+		MsgInternalErrorRequest: "internal server error (request %s)",
+		MsgReadFailed:           "Error reading user data",
+		MsgUnknownSyncKey:       "Could not find the last sync transaction. Did you skip the 'task sync init' requirement?",
+		MsgNoCommonAncestor:     "Could not find common ancestor for %q. Did you skip the 'task sync init' requirement?",
+		MsgUnknownType:          "unknown message type: %q",
+		MsgDryRunDenied:         "Access denied, dry runs are restricted to the users in \"dryrun.users\"",
+		MsgStatisticsDenied:     "Access denied, statistics are restricted to the server admins",
+		MsgQuotaExceeded:        "Access denied, the sync would use %d bytes exceeding the %d bytes quota",
+		MsgServerBusy:           "Server busy",
+		MsgMemoryBudget:         "Server busy, try again later",
+		MsgExtensionFailed:      "Extension failed",
+		MsgCertificateRequired:  "Access denied, a client certificate is required",
+		MsgReadOnly:             "Writes are temporarily disabled, try again later",
+		MsgOrgReadOnly:          "Access denied, organization %q is read-only",
+		MsgSyntaxError:          "Syntax error in request",
+		MsgMessageTooBig:        "message size limit exceeded",
+		MsgNoSeparator:          "Message separator not found",
+		MsgMalformedData:        "Malformed data",
+		MsgUnsupportedEncoding:  "Unsupported encoding",
+		MsgRequestTooBig:        "Request too big",
+		MsgFutureModification:   "Task %q modified in the future, on %s, check the client clock",
+	},
+	"es": {
+		MsgInternalError:        "Error interno del servidor",
+		MsgInternalErrorRequest: "error interno del servidor (pedido %s)",
+		MsgReadFailed:           "Error leyendo los datos del usuario",
+		MsgUnknownSyncKey:       "No se encontró la última transacción sincronizada. ¿Se omitió 'task sync init'?",
+		MsgNoCommonAncestor:     "No se encontró el ancestro común de %q. ¿Se omitió 'task sync init'?",
+		MsgUnknownType:          "tipo de mensaje desconocido: %q",
+		MsgDryRunDenied:         "Acceso denegado, las simulaciones están restringidas a los usuarios en \"dryrun.users\"",
+		MsgStatisticsDenied:     "Acceso denegado, las estadísticas están restringidas a los administradores",
+		MsgQuotaExceeded:        "Acceso denegado, la sincronización usaría %d bytes excediendo la cuota de %d bytes",
+		MsgServerBusy:           "Servidor ocupado",
+		MsgMemoryBudget:         "Servidor ocupado, intente más tarde",
+		MsgExtensionFailed:      "Falló una extensión",
+		MsgCertificateRequired:  "Acceso denegado, se requiere un certificado de cliente",
+		MsgReadOnly:             "Las escrituras están deshabilitadas temporalmente, intente más tarde",
+		MsgOrgReadOnly:          "Acceso denegado, la organización %q es de solo lectura",
+		MsgSyntaxError:          "Error de sintaxis en el pedido",
+		MsgMessageTooBig:        "se excedió el tamaño máximo del mensaje",
+		MsgNoSeparator:          "No se encontró el separador del mensaje",
+		MsgMalformedData:        "Datos mal formados",
+		MsgUnsupportedEncoding:  "Codificación no soportada",
+		MsgRequestTooBig:        "Pedido demasiado grande",
+		MsgFutureModification:   "La tarea %q fue modificada en el futuro, el %s, verifique el reloj del cliente",
+	},
+}
+
+// catalogStatus is a status from the catalog, kept in the response so it's
+// translated once the locale is known.
+type catalogStatus struct {
+	id   MessageID
+	args []interface{}
+}
+
+// clientError is an error whose message is shown to the clients, unlike the
+// rest of the errors, which are only logged.
+type clientError struct {
+	catalogStatus
+}
+
+func (e clientError) Error() string {
+	return e.text(DefaultLocale)
+}
+
+// text returns the status in the locale, in English if the locale isn't in
+// the catalog.  A region not in the catalog falls back to its language, "es"
+// for "es-AR".
+func (s catalogStatus) text(locale string) string {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	texts, ok := catalog[locale]
+	if !ok {
+		language := strings.SplitN(locale, "-", 2)[0]
+		if texts, ok = catalog[language]; !ok {
+			texts = catalog[DefaultLocale]
+		}
+	}
+
+	format, ok := texts[s.id]
+	if !ok {
+		format = catalog[DefaultLocale][s.id]
+	}
+	return fmt.Sprintf(format, s.args...)
+}
+
+// catalogResponse returns a response with a status from the catalog, in
+// English until it's localized.
+func catalogResponse(code string, id MessageID, args ...interface{}) Message {
+	status := catalogStatus{id: id, args: args}
+	resp := NewResponseMessage(code, status.text(DefaultLocale))
+	resp.status = &status
+	return resp
+}
+
+// internalErrorResponse logs the error and returns a 500 response hiding it,
+// its detail is meaningless to the users and may leak the server internals.
+// The client errors are sent as they are.
+func internalErrorResponse(err error) Message {
+	var clientErr clientError
+	if errors.As(err, &clientErr) {
+		return catalogResponse("500", clientErr.id, clientErr.args...)
+	}
+
+	log.Errorf("Internal error: %v", err)
+	return catalogResponse("500", MsgInternalError)
+}
+
+// localize translates the response status, if it's from the catalog, to the
+// locale the request asked for.
+func localize(resp *Message, locale string) {
+	if resp.status != nil && locale != "" && resp.Header != nil {
+		resp.Header["status"] = resp.status.text(locale)
+	}
+}
@@ -0,0 +1,66 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalog(t *testing.T) {
+	t.Run("every locale has every message", func(t *testing.T) {
+		for locale, texts := range catalog {
+			assert.Len(t, texts, len(catalog[DefaultLocale]), locale)
+			for id := range catalog[DefaultLocale] {
+				assert.Contains(t, texts, id, locale)
+			}
+		}
+	})
+
+	t.Run("locales", func(t *testing.T) {
+		status := catalogStatus{id: MsgUnknownType, args: []interface{}{"backup"}}
+
+		assert.Equal(t, `unknown message type: "backup"`, status.text(""))
+		assert.Equal(t, `unknown message type: "backup"`, status.text("fr"))
+		assert.Equal(t, `tipo de mensaje desconocido: "backup"`, status.text("es"))
+		assert.Equal(t, `tipo de mensaje desconocido: "backup"`, status.text("ES_ar"))
+	})
+
+	t.Run("internal errors are hidden", func(t *testing.T) {
+		resp := internalErrorResponse(errors.New("open /var/lib/gotas/orgs/Public/tx.data: permission denied"))
+		assert.Equal(t, "500", resp.Header["code"])
+		assert.Equal(t, "Internal server error", resp.Header["status"])
+
+		err := fmt.Errorf("merging: %w", clientError{catalogStatus{id: MsgNoCommonAncestor, args: []interface{}{"1"}}})
+		resp = internalErrorResponse(err)
+		assert.Equal(t, `Could not find common ancestor for "1". Did you skip the 'task sync init' requirement?`, resp.Header["status"])
+	})
+
+	t.Run("localized response", func(t *testing.T) {
+		msg := parseMsg(t, loadPayload(t, "msg-sent-init"))
+		msg.Header["type"] = "backup"
+		msg.Header[LocaleHeader] = "es-AR"
+
+		client := &mockClient{
+			reader: strings.NewReader(string(frame(msg.String()))),
+			writer: new(strings.Builder),
+		}
+		Process(client, &mockAuth{}, &mockReadAppender{writer: new(strings.Builder)}, DefaultOptions())
+
+		resp := parseMsg(t, client.writer.String())
+		assert.Equal(t, "500", resp.Header["code"])
+		assert.Equal(t, `tipo de mensaje desconocido: "backup"`, resp.Header["status"])
+	})
+
+	t.Run("other statuses are kept", func(t *testing.T) {
+		resp := NewResponseMessage("400", "Invalid org")
+		localize(&resp, "es")
+		assert.Equal(t, "Invalid org", resp.Header["status"])
+
+		resp = memoryBudgetResponse()
+		localize(&resp, "es")
+		assert.Equal(t, "Servidor ocupado, intente más tarde", resp.Header["status"])
+	})
+}
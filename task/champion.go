@@ -0,0 +1,66 @@
+package task
+
+import (
+	"sort"
+	"strings"
+)
+
+// TaskChampion, the Taskwarrior 3 storage, keeps every task as a flat map of
+// strings very close to the gotas one: dates are epochs and annotations are
+// "annotation_<epoch>" entries.  Tags and dependencies are one entry each,
+// and the uuid is the map key instead of an entry.
+const (
+	championTagPrefix = "tag_"
+	championDepPrefix = "dep_"
+)
+
+// TaskMap returns the task as a TaskChampion task map.
+func (t *Task) TaskMap() map[string]string {
+	m := make(map[string]string, len(t.data))
+	for name, value := range t.data {
+		switch name {
+		case "uuid":
+		case "tags":
+			for _, tag := range t.Tags() {
+				m[championTagPrefix+tag] = ""
+			}
+		case "depends":
+			for _, dep := range t.Dependencies() {
+				m[championDepPrefix+dep] = ""
+			}
+		default:
+			m[name] = value
+		}
+	}
+	return m
+}
+
+// NewTaskFromMap builds a task from a TaskChampion task map.
+func NewTaskFromMap(uuid string, m map[string]string) Task {
+	t := Task{data: make(map[string]string, len(m)+1)}
+	t.data["uuid"] = uuid
+
+	var tags, deps []string
+	for name, value := range m {
+		switch {
+		case strings.HasPrefix(name, championTagPrefix):
+			tags = append(tags, strings.TrimPrefix(name, championTagPrefix))
+		case strings.HasPrefix(name, championDepPrefix):
+			deps = append(deps, strings.TrimPrefix(name, championDepPrefix))
+		default:
+			t.Set(name, value)
+		}
+	}
+
+	// the map order is random
+	sort.Strings(tags)
+	sort.Strings(deps)
+	if len(tags) > 0 {
+		t.data["tags"] = strings.Join(tags, ",")
+	}
+	if len(deps) > 0 {
+		t.data["depends"] = strings.Join(deps, ",")
+	}
+
+	return t
+}
@@ -0,0 +1,120 @@
+// Package champion reads and writes TaskChampion replicas, the Taskwarrior 3
+// local storage, easing the migration between gotas and Taskwarrior 3.  The
+// replicas are SQLite databases accessed through the sqlite3 command line
+// shell, which must be installed.
+package champion
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/szaffarano/gotas/task"
+)
+
+// Sqlite is the sqlite3 command line shell used to access the replicas.
+var Sqlite = "sqlite3"
+
+// schema is the TaskChampion replica schema, it upgrades it when opened.
+const schema = `CREATE TABLE operations (id INTEGER PRIMARY KEY AUTOINCREMENT, data STRING);
+CREATE TABLE sync_meta (key STRING PRIMARY KEY, value STRING);
+CREATE TABLE tasks (uuid STRING PRIMARY KEY, data STRING);
+CREATE TABLE working_set (id INTEGER PRIMARY KEY, uuid STRING);
+`
+
+// Export creates a replica at path holding the tasks, in their latest
+// state.  The replica has no pending operations, so it's ready to be synced
+// with a fresh TaskChampion server, and the pending tasks are numbered in the
+// working set as Taskwarrior does.
+func Export(path string, tasks []task.Task) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%v already exists", path)
+	}
+
+	var script strings.Builder
+	script.WriteString("BEGIN;\n")
+	script.WriteString(schema)
+
+	pending := 0
+	for _, t := range tasks {
+		data, err := json.Marshal(t.TaskMap())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&script, "INSERT INTO tasks (uuid, data) VALUES (%s, %s);\n", quote(t.Get("uuid")), quote(string(data)))
+
+		if status := t.Get("status"); status == "pending" || status == "waiting" {
+			pending++
+			fmt.Fprintf(&script, "INSERT INTO working_set (id, uuid) VALUES (%d, %s);\n", pending, quote(t.Get("uuid")))
+		}
+	}
+	script.WriteString("COMMIT;\n")
+
+	if _, err := sqlite(strings.NewReader(script.String()), path); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("exporting to %v: %v", path, err)
+	}
+	return nil
+}
+
+// Import reads the tasks stored in the replica at path, sorted by uuid.
+func Import(path string) ([]task.Task, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	out, err := sqlite(nil, "-readonly", "-json", path, "SELECT uuid, data FROM tasks")
+	if err != nil {
+		return nil, fmt.Errorf("importing from %v: %v", path, err)
+	}
+
+	var rows []struct {
+		UUID string `json:"uuid"`
+		Data string `json:"data"`
+	}
+	// no rows means no output at all
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, &rows); err != nil {
+			return nil, fmt.Errorf("importing from %v: %v", path, err)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].UUID < rows[j].UUID })
+
+	tasks := make([]task.Task, 0, len(rows))
+	for _, row := range rows {
+		var m map[string]string
+		if err := json.Unmarshal([]byte(row.Data), &m); err != nil {
+			return nil, fmt.Errorf("task %v: %v", row.UUID, err)
+		}
+		tasks = append(tasks, task.NewTaskFromMap(row.UUID, m))
+	}
+
+	return tasks, nil
+}
+
+func sqlite(stdin *strings.Reader, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(Sqlite, args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// quote returns value as an SQL string literal.
+func quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
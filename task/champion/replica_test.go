@@ -0,0 +1,76 @@
+package champion
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task"
+)
+
+func TestReplica(t *testing.T) {
+	if _, err := exec.LookPath(Sqlite); err != nil {
+		t.Skipf("%s not installed", Sqlite)
+	}
+
+	dir, err := os.MkdirTemp(os.TempDir(), "gotas")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var tasks []task.Task
+	for _, raw := range []string{
+		`{"description":"It's pending","entry":"20211009T112536Z","status":"pending","tags":["home"],"uuid":"0c1a7a3e-1b0b-4a4e-9d4d-2f0a6a5a8c01"}`,
+		`{"description":"Done","end":"20211010T100000Z","entry":"20211009T112536Z","status":"completed","uuid":"2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b"}`,
+		`{"depends":"0c1a7a3e-1b0b-4a4e-9d4d-2f0a6a5a8c01","description":"Later","entry":"20211009T112536Z","status":"pending","uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`,
+	} {
+		parsed, err := task.NewTask(raw)
+		assert.NoError(t, err)
+		tasks = append(tasks, parsed)
+	}
+
+	path := filepath.Join(dir, "taskchampion.sqlite3")
+
+	t.Run("export", func(t *testing.T) {
+		assert.NoError(t, Export(path, tasks))
+
+		out, err := exec.Command(Sqlite, path, "SELECT id, uuid FROM working_set ORDER BY id").Output()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			"1|0c1a7a3e-1b0b-4a4e-9d4d-2f0a6a5a8c01",
+			"2|e346004f-6ebb-4507-8f21-0ba2b8f263d8",
+		}, strings.Fields(string(out)))
+
+		assert.Error(t, Export(path, tasks), "existing replica overwritten")
+	})
+
+	t.Run("import", func(t *testing.T) {
+		imported, err := Import(path)
+		assert.NoError(t, err)
+		assert.Len(t, imported, len(tasks))
+
+		for i := range tasks {
+			expected, err := tasks[i].ComposeJSON()
+			assert.NoError(t, err)
+			actual, err := imported[i].ComposeJSON()
+			assert.NoError(t, err)
+			assert.Equal(t, expected, actual)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		empty := filepath.Join(dir, "empty.sqlite3")
+		assert.NoError(t, Export(empty, nil))
+
+		imported, err := Import(empty)
+		assert.NoError(t, err)
+		assert.Empty(t, imported)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := Import(filepath.Join(dir, "missing.sqlite3"))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,32 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskMap(t *testing.T) {
+	task, err := NewTask(`{"annotations":[{"description":"call first","entry":"20211009T120000Z"}],"depends":"2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b","description":"Pay bills","due":"20211009T220000Z","entry":"20211009T112536Z","project":"home","status":"pending","tags":["bills","urgent"],"uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`)
+	assert.NoError(t, err)
+
+	m := task.TaskMap()
+	assert.Equal(t, map[string]string{
+		"annotation_1633780800":                    "call first",
+		"dep_2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b": "",
+		"description":                              "Pay bills",
+		"due":                                      "1633816800",
+		"entry":                                    "1633778736",
+		"project":                                  "home",
+		"status":                                   "pending",
+		"tag_bills":                                "",
+		"tag_urgent":                               "",
+	}, m)
+
+	back := NewTaskFromMap("e346004f-6ebb-4507-8f21-0ba2b8f263d8", m)
+	expected, err := task.ComposeJSON()
+	assert.NoError(t, err)
+	actual, err := back.ComposeJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
@@ -0,0 +1,294 @@
+package task
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/szaffarano/gotas/task/transport"
+)
+
+const (
+	// DefaultResponseLimit is the maximum size of the server responses read
+	// by the client.
+	DefaultResponseLimit = 100 * 1024 * 1024
+
+	// DefaultClientRetries is the number of times a Client retries a request
+	// when Retries is not set.
+	DefaultClientRetries = 5
+
+	// DefaultClientBackoff and DefaultClientMaxBackoff are the delays used by
+	// a Client when Backoff and MaxBackoff are not set.
+	DefaultClientBackoff    = time.Second
+	DefaultClientMaxBackoff = time.Minute
+
+	// clientMaxRedirects is the number of redirections a Client follows,
+	// avoiding loops between misconfigured servers.
+	clientMaxRedirects = 5
+)
+
+// Credentials identify a user in the server, as in the taskwarrior
+// "taskd.credentials" setting.
+type Credentials struct {
+	Org  string
+	User string
+	Key  string
+}
+
+// ParseCredentials parses the "org/user/key" credentials.
+func ParseCredentials(value string) (Credentials, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return Credentials{}, fmt.Errorf("invalid credentials %q, org/user/key expected", value)
+	}
+	return Credentials{Org: parts[0], User: parts[1], Key: parts[2]}, nil
+}
+
+// SyncRequest builds the sync request sending the tasks, as JSON lines, and
+// the key of the previous sync, if any.
+func SyncRequest(creds Credentials, client string, tasks []string, syncKey string) Message {
+	var payload strings.Builder
+	for _, t := range tasks {
+		payload.WriteString(t)
+		payload.WriteString("\n")
+	}
+	if syncKey != "" {
+		payload.WriteString(syncKey)
+		payload.WriteString("\n")
+	}
+
+	return Message{
+		Header: map[string]string{
+			"client":   client,
+			"protocol": ProtocolVersion,
+			"type":     "sync",
+			"org":      creds.Org,
+			"user":     creds.User,
+			"key":      creds.Key,
+		},
+		Payload: payload.String(),
+	}
+}
+
+// Sync sends a sync request through conn and waits for the server response.
+func Sync(conn io.ReadWriter, req Message) (Message, error) {
+	if _, err := req.WriteTo(conn); err != nil {
+		return Message{}, err
+	}
+
+	resp, err := ReadMessage(conn, DefaultResponseLimit)
+	if err != nil {
+		return Message{}, fmt.Errorf("reading the server response: %v", err)
+	}
+	return resp, nil
+}
+
+// SyncKey returns the sync key sent by the server in a sync response, or an
+// empty string if there is none.
+func SyncKey(resp Message) string {
+	lines := strings.Split(strings.TrimSpace(resp.Payload), "\n")
+	if key := lines[len(lines)-1]; !strings.HasPrefix(key, "{") {
+		if parsed, err := uuid.Parse(key); err == nil {
+			return parsed.String()
+		}
+	}
+	return ""
+}
+
+// Client syncs a user with a server like a well behaved taskwarrior client
+// does.  The requests the server asks to retry (302) or rejects because it's
+// busy or in maintenance (420) are retried with an exponential backoff,
+// honoring the retry-after header, and the redirections (301) reconnect to
+// the address sent by the server.
+type Client struct {
+	// Credentials identify the user.
+	Credentials Credentials
+
+	// Name is sent in the "client" header.
+	Name string
+
+	// Address is the server address, updated when redirected.
+	Address string
+
+	// Dial connects to a server address.
+	Dial func(address string) (io.ReadWriteCloser, error)
+
+	// Retries is the maximum number of retries of a request,
+	// DefaultClientRetries if zero.  Negative disables the retries.
+	Retries int
+
+	// Backoff is the delay before the first retry, doubled on every retry up
+	// to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// KeyFile persists the key of the last sync if set, so the syncs resume
+	// from it, even from another process, and only get the changes.
+	KeyFile string
+
+	// sleep waits between the retries, replaced by the tests.
+	sleep func(time.Duration)
+}
+
+// NewClient returns a client connecting through transport.Dial with the
+// configured certificates.
+func NewClient(cfg transport.ClientConfig, creds Credentials, name string) *Client {
+	return &Client{
+		Credentials: creds,
+		Name:        name,
+		Address:     cfg.Address,
+		Dial: func(address string) (io.ReadWriteCloser, error) {
+			cfg.Address = address
+			return transport.Dial(cfg)
+		},
+	}
+}
+
+// Sync sends the tasks, as JSON lines, along with the key of the last sync,
+// and keeps the new key.  The final response is returned even if it's not
+// successful, an error means that it couldn't be obtained.
+func (c *Client) Sync(tasks []string) (Message, error) {
+	syncKey, err := c.LastKey()
+	if err != nil {
+		return Message{}, err
+	}
+
+	resp, err := c.send(SyncRequest(c.Credentials, c.Name, tasks, syncKey))
+	if err != nil {
+		return Message{}, err
+	}
+
+	if code := resp.Header["code"]; code == "200" || code == "201" {
+		if key := SyncKey(resp); key != "" && c.KeyFile != "" {
+			if err := writeKeyFile(c.KeyFile, key); err != nil {
+				return resp, err
+			}
+		}
+	}
+	return resp, nil
+}
+
+// Statistics asks the server for its build, storage backend and protocol
+// versions, only answered to the server admins.
+func (c *Client) Statistics() (ServerInfo, error) {
+	resp, err := c.send(StatisticsRequest(c.Credentials, c.Name))
+	if err != nil {
+		return ServerInfo{}, err
+	}
+	return ParseServerInfo(resp)
+}
+
+// LastKey returns the persisted key of the last sync, an empty string if
+// there is none.
+func (c *Client) LastKey() (string, error) {
+	if c.KeyFile == "" {
+		return "", nil
+	}
+
+	content, err := os.ReadFile(c.KeyFile)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("reading the sync key: %v", err)
+	}
+
+	key, err := uuid.Parse(strings.TrimSpace(string(content)))
+	if err != nil {
+		return "", fmt.Errorf("invalid sync key in %s: %v", c.KeyFile, err)
+	}
+	return key.String(), nil
+}
+
+// send sends the request, retrying and following the redirections.
+func (c *Client) send(req Message) (Message, error) {
+	retries := c.Retries
+	if retries == 0 {
+		retries = DefaultClientRetries
+	}
+	backoff, maxBackoff := c.Backoff, c.MaxBackoff
+	if backoff <= 0 {
+		backoff = DefaultClientBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultClientMaxBackoff
+	}
+	sleep := c.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	var attempts, redirects int
+	for {
+		resp, err := c.roundTrip(req)
+		if err != nil {
+			return Message{}, err
+		}
+
+		switch resp.Header["code"] {
+		case "301":
+			address := resp.Header["info"]
+			if address == "" {
+				return resp, fmt.Errorf("redirected without an address")
+			} else if redirects++; redirects > clientMaxRedirects {
+				return resp, fmt.Errorf("too many redirections, last to %s", address)
+			}
+			log.Debugf("Redirected from %s to %s", c.Address, address)
+			c.Address = address
+		case "302", "420":
+			if attempts >= retries {
+				return resp, nil
+			}
+			attempts++
+
+			delay := backoff
+			if seconds, err := strconv.Atoi(resp.Header[RetryAfterHeader]); err == nil && seconds > 0 {
+				delay = time.Duration(seconds) * time.Second
+			}
+			log.Debugf("Retrying in %v (%s %s)", delay, resp.Header["code"], resp.Header["status"])
+			sleep(delay)
+
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		default:
+			return resp, nil
+		}
+	}
+}
+
+// roundTrip sends the request through a new connection.
+func (c *Client) roundTrip(req Message) (Message, error) {
+	conn, err := c.Dial(c.Address)
+	if err != nil {
+		return Message{}, fmt.Errorf("connecting to %s: %v", c.Address, err)
+	}
+	defer conn.Close()
+
+	return Sync(conn, req)
+}
+
+// writeKeyFile replaces the sync key persisted in path.
+func writeKeyFile(path, key string) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("saving the sync key: %v", err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.WriteString(key + "\n"); err != nil {
+		temp.Close()
+		return fmt.Errorf("saving the sync key: %v", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("saving the sync key: %v", err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("saving the sync key: %v", err)
+	}
+	return nil
+}
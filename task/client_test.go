@@ -0,0 +1,158 @@
+package task
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientRetries(t *testing.T) {
+	const key = "9d4fb7a2-3b1c-4a8e-8f2d-0e1f2a3b4c5d"
+
+	response := func(code int, headers ...string) Message {
+		b := NewResponse(code)
+		for i := 0; i+1 < len(headers); i += 2 {
+			b.WithHeader(headers[i], headers[i+1])
+		}
+		msg, err := b.Build()
+		assert.NoError(t, err)
+		return msg
+	}
+
+	// newClient replies the responses in order, recording the addresses
+	// dialed, the requests and the delays
+	newClient := func(responses ...Message) (*Client, *[]string, *[]Message, *[]time.Duration) {
+		var addresses []string
+		var requests []Message
+		var delays []time.Duration
+
+		c := &Client{
+			Credentials: Credentials{Org: "Public", User: "noeh", Key: "secret"},
+			Name:        "test 1.0",
+			Address:     "primary:53589",
+			Backoff:     time.Second,
+			MaxBackoff:  3 * time.Second,
+			sleep: func(d time.Duration) {
+				delays = append(delays, d)
+			},
+		}
+		c.Dial = func(address string) (io.ReadWriteCloser, error) {
+			addresses = append(addresses, address)
+			if len(responses) == 0 {
+				return nil, errors.New("connection refused")
+			}
+			conn := &mockClient{
+				reader: strings.NewReader(string(frame(responses[0].String()))),
+				writer: new(strings.Builder),
+			}
+			responses = responses[1:]
+			requests = append(requests, Message{})
+			idx := len(requests) - 1
+			return &recordingConn{mockClient: conn, done: func(raw string) {
+				requests[idx] = parseMsg(t, raw)
+			}}, nil
+		}
+		return c, &addresses, &requests, &delays
+	}
+
+	t.Run("retries with backoff", func(t *testing.T) {
+		c, addresses, _, delays := newClient(
+			response(420), response(302), response(420, RetryAfterHeader, "10"), response(420), response(201),
+		)
+
+		resp, err := c.Sync(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "201", resp.Header["code"])
+		assert.Len(t, *addresses, 5)
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 10 * time.Second, 3 * time.Second}, *delays)
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		c, _, _, delays := newClient(response(420), response(420), response(420))
+		c.Retries = 2
+
+		resp, err := c.Sync(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "420", resp.Header["code"])
+		assert.Len(t, *delays, 2)
+	})
+
+	t.Run("follows redirections", func(t *testing.T) {
+		c, addresses, _, _ := newClient(response(301, "info", "replica:53589"), response(201))
+
+		resp, err := c.Sync(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "201", resp.Header["code"])
+		assert.Equal(t, []string{"primary:53589", "replica:53589"}, *addresses)
+		assert.Equal(t, "replica:53589", c.Address)
+	})
+
+	t.Run("redirection loop", func(t *testing.T) {
+		var responses []Message
+		for i := 0; i <= clientMaxRedirects; i++ {
+			responses = append(responses, response(301, "info", "replica:53589"))
+		}
+		c, _, _, _ := newClient(responses...)
+
+		_, err := c.Sync(nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("connection error", func(t *testing.T) {
+		c, _, _, _ := newClient()
+
+		_, err := c.Sync(nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("resumes from the last key", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "gotas-client")
+		assert.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		synced, err := NewResponse(200).WithPayload(key + "\n").Build()
+		assert.NoError(t, err)
+		c, _, requests, _ := newClient(synced, response(201))
+		c.KeyFile = filepath.Join(dir, "sync.key")
+
+		_, err = c.Sync([]string{`{"description":"first"}`})
+		assert.NoError(t, err)
+		last, err := c.LastKey()
+		assert.NoError(t, err)
+		assert.Equal(t, key, last)
+
+		_, err = c.Sync(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, key+"\n", (*requests)[1].Payload)
+	})
+
+	t.Run("invalid key file", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "gotas-client")
+		assert.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		c, _, _, _ := newClient(response(201))
+		c.KeyFile = filepath.Join(dir, "sync.key")
+		assert.NoError(t, os.WriteFile(c.KeyFile, []byte("garbage"), 0600))
+
+		_, err = c.Sync(nil)
+		assert.Error(t, err)
+	})
+}
+
+// recordingConn passes the request written to done when closed.
+type recordingConn struct {
+	*mockClient
+	done func(string)
+}
+
+func (c *recordingConn) Close() error {
+	c.done(c.writer.String())
+	return c.mockClient.Close()
+}
@@ -0,0 +1,65 @@
+package task
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/szaffarano/gotas/config"
+)
+
+// ConfigFile is the name of the configuration file stored in the data
+// directory.
+const ConfigFile = "config"
+
+// LoadConfig loads the server configuration stored in dataDir.  The file is
+// authoritative: the overrides only fill the options missing in it, and an
+// override conflicting with the file is an error.  The "root" option defaults
+// to dataDir, and it's an error if it points somewhere else, so the server
+// and the repository always use the same data.  In strict mode, keys not
+// included in ConfigKeys are rejected, so typos don't go unnoticed.
+func LoadConfig(dataDir string, overrides map[string]string, strict bool) (config.Config, error) {
+	path := filepath.Join(dataDir, ConfigFile)
+
+	var cfg config.Config
+	var err error
+	if strict {
+		cfg, err = config.LoadStrict(path, ConfigKeys)
+	} else {
+		cfg, err = config.Load(path)
+	}
+	if err != nil {
+		return config.Config{}, fmt.Errorf("loading configuration: %v", err)
+	}
+
+	keys := make([]string, 0, len(overrides))
+	for k := range overrides {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if current := cfg.Get(k); current != "" && current != overrides[k] {
+			return config.Config{}, fmt.Errorf("%q is %q in the configuration file, it can't be overridden with %q", k, current, overrides[k])
+		}
+		cfg.Set(k, overrides[k])
+	}
+
+	absDataDir, err := filepath.Abs(dataDir)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("calculate dir absolute path %v: %v", dataDir, err)
+	}
+
+	if root := cfg.Get(Root); root != "" {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return config.Config{}, fmt.Errorf("calculate dir absolute path %v: %v", root, err)
+		}
+		if absRoot != absDataDir {
+			return config.Config{}, fmt.Errorf("%q is %q in the configuration file, but the data directory is %q", Root, root, dataDir)
+		}
+	}
+	cfg.Set(Root, absDataDir)
+
+	return cfg, nil
+}
@@ -0,0 +1,69 @@
+package task
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	writeConfig := func(t *testing.T, content string) string {
+		t.Helper()
+		dataDir := t.TempDir()
+		assert.Nil(t, os.WriteFile(filepath.Join(dataDir, ConfigFile), []byte(content), 0644))
+		return dataDir
+	}
+
+	t.Run("root defaults to the data directory", func(t *testing.T) {
+		dataDir := writeConfig(t, "server = localhost:53589\n")
+
+		cfg, err := LoadConfig(dataDir, nil, false)
+		assert.Nil(t, err)
+		assert.Equal(t, dataDir, cfg.Get(Root))
+		assert.Equal(t, "localhost:53589", cfg.Get(BindAddress))
+	})
+
+	t.Run("root has to point to the data directory", func(t *testing.T) {
+		dataDir := writeConfig(t, "root = /somewhere/else\n")
+
+		_, err := LoadConfig(dataDir, nil, false)
+		assert.NotNil(t, err)
+
+		dataDir = t.TempDir()
+		assert.Nil(t, os.WriteFile(filepath.Join(dataDir, ConfigFile), []byte("root = "+dataDir+"\n"), 0644))
+		_, err = LoadConfig(dataDir, nil, false)
+		assert.Nil(t, err)
+	})
+
+	t.Run("overrides only fill missing options", func(t *testing.T) {
+		dataDir := writeConfig(t, "server = localhost:53589\n")
+
+		cfg, err := LoadConfig(dataDir, map[string]string{BindAddress: "localhost:53589", SyncWorkers: "4"}, false)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, cfg.GetInt(SyncWorkers))
+
+		_, err = LoadConfig(dataDir, map[string]string{BindAddress: "0.0.0.0:53589"}, false)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("strict mode rejects unknown options", func(t *testing.T) {
+		dataDir := writeConfig(t, "requst.limit = 1024\npublish.topic.Public = tasks\n")
+
+		_, err := LoadConfig(dataDir, nil, false)
+		assert.Nil(t, err)
+
+		_, err = LoadConfig(dataDir, nil, true)
+		assert.NotNil(t, err)
+
+		dataDir = writeConfig(t, "request.limit = 1024\npublish.topic.Public = tasks\n")
+		_, err = LoadConfig(dataDir, nil, true)
+		assert.Nil(t, err)
+	})
+
+	t.Run("fails without configuration file", func(t *testing.T) {
+		_, err := LoadConfig(t.TempDir(), nil, false)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,304 @@
+// Package conformance checks the taskd wire protocol implementation against
+// recorded exchanges.  Every fixture is a directory holding the request sent
+// by a client, the response expected, and optionally the user data before and
+// after the request:
+//
+//	testdata/<name>/request
+//	testdata/<name>/response
+//	testdata/<name>/before.data
+//	testdata/<name>/after.data
+//
+// The fixtures replay the requests of the Taskwarrior 2.5 and 2.6 clients,
+// with the credentials in Org, User and Key, expecting the responses of taskd
+// 1.2.0 plus the protocol headers gotas adds.
+// Run frames the request, serves it and diffs the framing, the headers, the
+// payload and the data stored, line by line.  The sync keys created by the
+// server are random, so they're compared by the order they appear in.
+package conformance
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/szaffarano/gotas/task"
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// The credentials used by the fixtures requests.
+const (
+	Org  = "Public"
+	User = "sebas"
+	Key  = "8749ee17-7949-4ce2-91dd-fcc3e0131305"
+)
+
+// Extensions are the response headers gotas adds that taskd doesn't send,
+// they're ignored unless the fixture expects them.
+var Extensions = []string{"server"}
+
+// Fixture is a recorded request and the response expected.
+type Fixture struct {
+	Name     string
+	Request  string
+	Response string
+	Before   []string
+	After    []string
+}
+
+// Server serves a taskd connection, e.g. task.Process with the options under
+// test.
+type Server func(client io.ReadWriteCloser, a auth.Authenticator, ra task.ReadAppender)
+
+// Load reads the fixtures in dir, sorted by name.
+func Load(dir string) ([]Fixture, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("loading fixtures: %v", err)
+	}
+
+	var fixtures []Fixture
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		fixture, err := loadFixture(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, fixture)
+	}
+	sort.Slice(fixtures, func(i, j int) bool { return fixtures[i].Name < fixtures[j].Name })
+
+	return fixtures, nil
+}
+
+func loadFixture(dir string) (Fixture, error) {
+	fixture := Fixture{Name: filepath.Base(dir)}
+
+	request, err := os.ReadFile(filepath.Join(dir, "request"))
+	if err != nil {
+		return fixture, fmt.Errorf("loading fixture %q: %v", fixture.Name, err)
+	}
+	response, err := os.ReadFile(filepath.Join(dir, "response"))
+	if err != nil {
+		return fixture, fmt.Errorf("loading fixture %q: %v", fixture.Name, err)
+	}
+	fixture.Request, fixture.Response = string(request), string(response)
+
+	for path, lines := range map[string]*[]string{"before.data": &fixture.Before, "after.data": &fixture.After} {
+		data, err := os.ReadFile(filepath.Join(dir, path))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fixture, fmt.Errorf("loading fixture %q: %v", fixture.Name, err)
+		}
+		*lines = splitLines(string(data))
+	}
+
+	return fixture, nil
+}
+
+// Run serves the fixture request, returning the differences between the
+// response and data expected and the actual ones.  An error means the
+// exchange couldn't be run at all.
+func Run(fixture Fixture, server Server) ([]string, error) {
+	memory := repo.NewMemoryRepository()
+	if _, err := memory.NewOrg(Org); err != nil {
+		return nil, err
+	}
+	user, err := memory.AddUser(Org, User)
+	if err != nil {
+		return nil, err
+	}
+	if len(fixture.Before) > 0 {
+		if err := memory.Append(*user, []string{strings.Join(fixture.Before, "\n") + "\n"}); err != nil {
+			return nil, err
+		}
+	}
+
+	client := &conn{request: bytes.NewReader(frame(fixture.Request))}
+	server(client, fixtureAuth{memory, user.Key}, memory)
+
+	after, err := memory.Read(*user)
+	if err != nil {
+		return nil, err
+	}
+
+	known := knownKeys(fixture)
+	var diffs []string
+	response, err := unframe(client.response.Bytes())
+	if err != nil {
+		return append(diffs, err.Error()), nil
+	}
+	expected, err := task.NewMessage(fixture.Response)
+	if err != nil {
+		return nil, fmt.Errorf("fixture %q response: %v", fixture.Name, err)
+	}
+	actual, err := task.NewMessage(response)
+	if err != nil {
+		return append(diffs, fmt.Sprintf("response: %v", err)), nil
+	}
+
+	diffs = append(diffs, diffHeaders(expected.Header, actual.Header)...)
+
+	// the keys are numbered across the payload and the data, so the new key
+	// sent must be the one stored
+	expectedKeys, actualKeys := newKeys{known: known}, newKeys{known: known}
+	diffs = append(diffs, diffLines("payload",
+		expectedKeys.normalize(splitLines(expected.Payload)),
+		actualKeys.normalize(splitLines(actual.Payload)))...)
+	diffs = append(diffs, diffLines("data",
+		expectedKeys.normalize(fixture.After),
+		actualKeys.normalize(after))...)
+
+	return diffs, nil
+}
+
+// diffHeaders compares the headers, ignoring the Extensions not expected.
+func diffHeaders(expected, actual map[string]string) []string {
+	var diffs []string
+	for name, value := range expected {
+		if got, ok := actual[name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("header %q: missing, expected %q", name, value))
+		} else if got != value {
+			diffs = append(diffs, fmt.Sprintf("header %q: expected %q, got %q", name, value, got))
+		}
+	}
+	for name, value := range actual {
+		if _, ok := expected[name]; !ok && !isExtension(name) {
+			diffs = append(diffs, fmt.Sprintf("header %q: unexpected %q", name, value))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+func isExtension(name string) bool {
+	for _, extension := range Extensions {
+		if extension == name {
+			return true
+		}
+	}
+	return false
+}
+
+// diffLines compares the lines one by one.
+func diffLines(what string, expected, actual []string) []string {
+	var diffs []string
+	for i := 0; i < len(expected) || i < len(actual); i++ {
+		switch {
+		case i >= len(actual):
+			diffs = append(diffs, fmt.Sprintf("%s line %d: missing, expected %s", what, i+1, expected[i]))
+		case i >= len(expected):
+			diffs = append(diffs, fmt.Sprintf("%s line %d: unexpected %s", what, i+1, actual[i]))
+		case expected[i] != actual[i]:
+			diffs = append(diffs, fmt.Sprintf("%s line %d: expected %s, got %s", what, i+1, expected[i], actual[i]))
+		}
+	}
+	return diffs
+}
+
+// knownKeys returns the sync keys of the request and the data before it,
+// compared as they are.
+func knownKeys(fixture Fixture) map[string]bool {
+	known := make(map[string]bool)
+	lines := append([]string(nil), fixture.Before...)
+	if msg, err := task.NewMessage(fixture.Request); err == nil {
+		lines = append(lines, splitLines(msg.Payload)...)
+	}
+	for _, line := range lines {
+		if isKey(line) {
+			known[line] = true
+		}
+	}
+	return known
+}
+
+// newKeys numbers the sync keys created by the server.
+type newKeys struct {
+	known    map[string]bool
+	numbered map[string]string
+}
+
+func (k *newKeys) normalize(lines []string) []string {
+	if k.numbered == nil {
+		k.numbered = make(map[string]string)
+	}
+	normalized := make([]string, len(lines))
+	for i, line := range lines {
+		normalized[i] = line
+		if !isKey(line) || k.known[line] {
+			continue
+		}
+		if _, ok := k.numbered[line]; !ok {
+			k.numbered[line] = fmt.Sprintf("<new sync key #%d>", len(k.numbered)+1)
+		}
+		normalized[i] = k.numbered[line]
+	}
+	return normalized
+}
+
+func isKey(line string) bool {
+	_, err := uuid.Parse(line)
+	return err == nil && !strings.HasPrefix(line, "{")
+}
+
+func splitLines(data string) []string {
+	var lines []string
+	for _, line := range strings.Split(data, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// frame prepends the message size, as the clients send it.
+func frame(msg string) []byte {
+	raw := make([]byte, 4, 4+len(msg))
+	binary.BigEndian.PutUint32(raw, uint32(4+len(msg)))
+	return append(raw, msg...)
+}
+
+// unframe verifies the size sent with the response and strips it.
+func unframe(raw []byte) (string, error) {
+	if len(raw) < 4 {
+		return "", fmt.Errorf("framing: %d bytes received, the size expected", len(raw))
+	}
+	if size := binary.BigEndian.Uint32(raw); int(size) != len(raw) {
+		return "", fmt.Errorf("framing: size %d sent, %d bytes received", size, len(raw))
+	}
+	return string(raw[4:]), nil
+}
+
+// conn is the client connection, sending the request and keeping the
+// response.
+type conn struct {
+	request  io.Reader
+	response bytes.Buffer
+}
+
+func (c *conn) Read(p []byte) (int, error)  { return c.request.Read(p) }
+func (c *conn) Write(p []byte) (int, error) { return c.response.Write(p) }
+func (c *conn) Close() error                { return nil }
+
+// fixtureAuth authenticates the fixtures credentials as the user created for
+// the run, whose key is random.
+type fixtureAuth struct {
+	memory *repo.MemoryRepository
+	key    string
+}
+
+func (a fixtureAuth) Authenticate(orgName, userName, key string) (auth.User, error) {
+	if key == Key {
+		key = a.key
+	}
+	return a.memory.Authenticate(orgName, userName, key)
+}
@@ -0,0 +1,93 @@
+package conformance
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func process(client io.ReadWriteCloser, a auth.Authenticator, ra task.ReadAppender) {
+	task.Process(client, a, ra, task.DefaultOptions())
+}
+
+func TestConformance(t *testing.T) {
+	fixtures, err := Load("testdata")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, fixtures)
+
+	for _, fixture := range fixtures {
+		fixture := fixture
+		t.Run(fixture.Name, func(t *testing.T) {
+			diffs, err := Run(fixture, process)
+			assert.Nil(t, err)
+			assert.Empty(t, diffs)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	fixtures, err := Load("testdata")
+	assert.Nil(t, err)
+	fixtures = filter(fixtures, "sync-first-time")
+	if !assert.Len(t, fixtures, 1) {
+		return
+	}
+	fixture := fixtures[0]
+
+	t.Run("reports the headers differences", func(t *testing.T) {
+		changed := fixture
+		changed.Response = "type: response\ncode: 201\nstatus: No change\n\n" + lastLine(fixture.After) + "\n"
+
+		diffs, err := Run(changed, process)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			`header "code": expected "201", got "200"`,
+			`header "protocol": unexpected "v1"`,
+			`header "status": expected "No change", got "Ok"`,
+		}, diffs)
+	})
+
+	t.Run("reports the data differences", func(t *testing.T) {
+		changed := fixture
+		changed.After = fixture.After[1:]
+
+		diffs, err := Run(changed, process)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, diffs)
+		assert.Contains(t, diffs[0], "data line 1: expected")
+	})
+
+	t.Run("numbers the new sync keys", func(t *testing.T) {
+		changed := fixture
+		changed.After = append(append([]string(nil), fixture.After[:len(fixture.After)-1]...), "6e8d1c2b-7f3a-4e5b-9c0d-1a2b3c4d5e6f")
+
+		diffs, err := Run(changed, process)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"data line 4: expected <new sync key #2>, got <new sync key #1>"}, diffs)
+	})
+
+	t.Run("reports the framing errors", func(t *testing.T) {
+		diffs, err := Run(fixture, func(client io.ReadWriteCloser, a auth.Authenticator, ra task.ReadAppender) {
+			client.Write([]byte{0, 0, 0, 10, 'x'})
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"framing: size 10 sent, 5 bytes received"}, diffs)
+	})
+}
+
+func filter(fixtures []Fixture, name string) []Fixture {
+	var filtered []Fixture
+	for _, f := range fixtures {
+		if f.Name == name {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered
+}
+
+func lastLine(lines []string) string {
+	return lines[len(lines)-1]
+}
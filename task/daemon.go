@@ -1,50 +1,533 @@
 package task
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	gosync "sync"
 	"syscall"
+	"time"
 
 	"github.com/szaffarano/gotas/config"
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/pubsub"
 	"github.com/szaffarano/gotas/task/repo"
 	"github.com/szaffarano/gotas/task/transport"
 )
 
-// Serve starts task server based on an initial configuration.
-func Serve(cfg config.Config) (err error) {
+// Serve starts task server based on the configuration returned by load.  The
+// configuration is reloaded periodically, applying the changes that don't
+// require a restart.
+func Serve(load func() (config.Config, error)) error {
+	return serve(load, nil)
+}
+
+// ServeEphemeral starts task server like Serve does, but keeping the
+// organizations, users and transactions in memory, so nothing survives the
+// process.  Meant for demos, the calendars and the background jobs aren't
+// available.
+func ServeEphemeral(load func() (config.Config, error), memory *repo.MemoryRepository) error {
+	return serve(load, memory)
+}
+
+func serve(load func() (config.Config, error), memory *repo.MemoryRepository) (err error) {
 	shutdownChan := make(chan os.Signal, 1)
 	signal.Notify(shutdownChan, syscall.SIGINT, syscall.SIGTERM)
 
+	watcher, err := NewSettingsWatcher(load)
+	if err != nil {
+		return err
+	}
+	settings := watcher.Current()
+
+	if memory == nil {
+		if _, err := repo.UpgradeSchema(settings.Root); err != nil {
+			return err
+		}
+		if _, err := repo.CollectGarbage(settings.Root, false); err != nil {
+			return err
+		}
+		if _, err := repo.EnforceRetention(settings.Root, settings.Retention, false); err != nil {
+			return err
+		}
+	} else if settings.CalendarListen != "" {
+		return fmt.Errorf("%q is not supported by the ephemeral mode", CalendarListen)
+	}
+
 	tlsConfig := transport.TLSConfig{
-		CaCert:      cfg.Get(CaCert),
-		ServerCert:  cfg.Get(ServerCert),
-		ServerKey:   cfg.Get(ServerKey),
-		BindAddress: cfg.Get(BindAddress),
+		CaCert:             settings.CaCert,
+		ServerCert:         settings.ServerCert,
+		ServerKey:          settings.ServerKey,
+		BindAddress:        settings.BindAddress,
+		AllowAnyClient:     settings.Trust == TrustAllowAll,
+		OptionalClientCert: !settings.PingClientCert,
+		MinVersion:         settings.TLSMinVersion,
+		MaxVersion:         settings.TLSMaxVersion,
+		HostCerts:          settings.HostCerts,
+		CipherSuites:       settings.TLSCiphers,
+
+		DisableSessionTickets: !settings.TLSSessionTickets,
+		TicketRotation:        settings.TLSTicketRotation,
+		ReadTimeout:           settings.RequestTimeout,
+	}
+
+	var authenticator auth.Authenticator
+	var users UserAdmin
+	var caches []interface{ Reload() }
+	var base ReadAppender
+	storage := "memory"
+	if memory != nil {
+		authenticator, users, base = memory, memory, memory
+	} else {
+		fsAuthenticator, err := repo.NewDefaultAuthenticator(settings.Root)
+		if err != nil {
+			return err
+		}
+		authenticator = fsAuthenticator
+		caches = append(caches, fsAuthenticator)
+		var fs *repo.DefaultReadAppender
+		if settings.CommitWindow > 0 {
+			fs = repo.NewGroupCommitReadAppender(settings.Root, settings.CommitWindow)
+			log.Infof("Committing the concurrent appends together every %v", settings.CommitWindow)
+		} else {
+			fs = repo.NewDefaultReadAppender(settings.Root)
+		}
+		base, storage = fs, fs.Storage()
+	}
+
+	gate := &appendGate{ReadAppender: base}
+	var ra ReadAppender = gate
+
+	scheduler := NewScheduler(settings.Root, settings.JobJitter)
+	jobs := map[string]func() error{
+		JobGC: gate.exclusive(func() error {
+			_, err := repo.CollectGarbage(settings.Root, false)
+			return err
+		}),
+		JobRetention: gate.exclusive(func() error {
+			_, err := repo.EnforceRetention(settings.Root, settings.Retention, false)
+			return err
+		}),
+		JobDigest: func() error {
+			return SendDigests(settings.Root, base, settings.SMTP, time.Now())
+		},
+	}
+	for _, name := range Jobs {
+		spec := settings.Schedules[name]
+		if spec == "" {
+			continue
+		} else if memory != nil {
+			log.Warnf("Ignoring the %s schedule, the jobs don't run in the ephemeral mode", name)
+			continue
+		}
+		if err := scheduler.Register(name, spec, jobs[name]); err != nil {
+			return err
+		}
+	}
+
+	var optsMu gosync.RWMutex
+	opts := settings.apply(DefaultOptions())
+	opts.Storage = storage
+	if memory != nil {
+		opts.Maintenance = memory.InMaintenance
+	} else {
+		opts.Maintenance = func() bool {
+			return repo.InMaintenance(settings.Root)
+		}
+		opts.RecordSync = recordSync(settings.Root, settings.Hooks)
+		opts.Archives, _ = base.(Unarchiver)
+		if settings.MergeAudit > 0 {
+			opts.RecordMerges = recordMerges(settings.Root, settings.MergeAudit)
+		}
+	}
+	if opts.Keys, err = ParseKeyGenerator(settings.SyncKeys); err != nil {
+		return err
+	}
+	if settings.SyncKeys != "" && settings.SyncKeys != RandomKeys {
+		log.Warnf("Generating predictable sync keys (%s), only meant for tests", settings.SyncKeys)
+	}
+	anomalies := NewAnomalyDetector()
+	opts.Anomalies = anomalies
+	stats := NewStats()
+	opts.Stats = stats
+	if memory == nil {
+		if err := stats.persist(filepath.Join(settings.Root, StatsFile)); err != nil {
+			log.Warnf("Counting the statistics totals from scratch: %v", err)
+		}
 	}
+	if settings.MemoryBudget > 0 {
+		opts.Memory = NewMemoryBudget(settings.MemoryBudget)
+		stats.watchMemory(opts.Memory.Stats)
+	}
+	tlsConfig.OnHandshake = stats.recordHandshake
+	stats.watchJobs(scheduler.Stats)
+	watcher.Subscribe(func(old, new Settings) {
+		optsMu.Lock()
+		opts = new.apply(opts)
+		optsMu.Unlock()
+
+		log.Infof("Configuration reloaded")
+		if changed := restartRequired(old, new); len(changed) > 0 {
+			log.Warnf("Restart the server to apply the changes in %v", changed)
+		}
+	})
 
-	auth, err := repo.NewDefaultAuthenticator(cfg.Get(Root))
+	adminListener, err := ListenAdmin(settings.AdminSocket)
 	if err != nil {
 		return err
 	}
+	adminServer := &http.Server{Handler: AdminHandler(stats, settings.AdminDebug)}
+	go func() {
+		if err := adminServer.Serve(adminListener); err != http.ErrServerClosed {
+			log.Errorf("Admin server stopped: %v", err)
+		}
+	}()
+	log.Infof("Serving the admin API on %s...", settings.AdminSocket)
+	if settings.AdminDebug {
+		log.Warnf("Serving the runtime profiles on the admin API, only meant for debugging")
+	}
+
+	var feedServer *http.Server
+	if address := settings.FeedListen; address != "" {
+		feed := NewFeed(ra, settings.FeedSize)
+		ra = feed
+
+		if feedServer, err = serveHTTPS("Change feed", address, feed.Handler(authenticator), tlsConfig); err != nil {
+			return err
+		}
+		log.Infof("Serving the change feed on %s...", address)
+	}
+
+	var calendarServer *http.Server
+	if address := settings.CalendarListen; address != "" {
+		repository, err := repo.OpenRepository(settings.Root)
+		if err != nil {
+			return err
+		}
+		caches = append(caches, repository)
+
+		if calendarServer, err = serveHTTPS("Calendar", address, CalendarHandler(repository, ra), tlsConfig); err != nil {
+			return err
+		}
+		log.Infof("Serving the calendars on %s...", address)
+	}
+
+	var primary *Primary
+	var replicationServer transport.Server
+	if address := settings.ReplicationListen; address != "" {
+		replicas := settings.ReplicationReplicas
+		primary = NewPrimary(ra, replicas)
+		ra = primary
+
+		replicationConfig := ReplicationTLSConfig(tlsConfig, address)
+		if replicationServer, err = transport.NewServer(replicationConfig, len(replicas), primary.Serve); err != nil {
+			return fmt.Errorf("initializing replication server: %v", err)
+		}
+		log.Infof("Replicating to %v on %s...", replicas, address)
+	}
 
-	ra := repo.NewDefaultReadAppender(cfg.Get(Root))
+	quitReplica := make(chan struct{})
+	if address := settings.ReplicationPrimary; address != "" {
+		dial := func() (io.ReadWriteCloser, error) {
+			return transport.Dial(transport.ClientConfig{
+				CaCert:  settings.CaCert,
+				Cert:    settings.ClientCert,
+				Key:     settings.ClientKey,
+				Address: address,
+			})
+		}
+		go Follow(dial, ra, quitReplica)
+
+		if settings.ReplicationRedirect == "" {
+			log.Warnf("%q not configured, clients won't know where to send their changes", ReplicationRedirect)
+		}
+		ra = NewReadOnly(ra, settings.ReplicationRedirect)
+		log.Infof("Read-only replica of %s", address)
+	}
+
+	// the read-only modes reject the appends of the REST API too, and so
+	// does the maintenance mode, e.g. while "gotas gc" runs
+	ra = readOnlyGate{ReadAppender: ra, server: func() bool {
+		optsMu.RLock()
+		defer optsMu.RUnlock()
+		return opts.ReadOnly || (opts.Maintenance != nil && opts.Maintenance())
+	}}
+
+	// after the replication, so the tasks stored are replicated
+	var apiServer *http.Server
+	if address := settings.APIListen; address != "" {
+		if users == nil {
+			repository, err := repo.OpenRepository(settings.Root)
+			if err != nil {
+				return err
+			}
+			users = repository
+			caches = append(caches, repository)
+		}
+		mux := http.NewServeMux()
+		mux.Handle(APIPrefix, APIHandler(authenticator, ra, opts.Keys))
+		mux.Handle(UsersAPIPrefix, UsersHandler(authenticator, users))
+		if apiServer, err = serveHTTPS("REST API", address, mux, tlsConfig); err != nil {
+			return err
+		}
+		log.Infof("Serving the REST API on %s...", address)
+	}
+
+	var notifier *Notifier
+	if brokerURL := settings.PublishURL; brokerURL != "" {
+		publisher, err := pubsub.New(brokerURL)
+		if err != nil {
+			return err
+		}
+
+		notifier = NewNotifier(publisher, func(org string) string {
+			return watcher.Current().Topic(org)
+		})
+		opts.OnSync = notifier.Notify
+		log.Infof("Publishing sync events to %s", brokerURL)
+	}
 
 	handler := func(client io.ReadWriteCloser) {
-		Process(client, auth, ra)
+		optsMu.RLock()
+		current := opts
+		optsMu.RUnlock()
+
+		Process(client, authenticator, ra, current)
+	}
+
+	tlsConfig.Busy = func(client io.ReadWriteCloser) {
+		optsMu.RLock()
+		current := opts
+		optsMu.RUnlock()
+
+		Busy(client, current)
 	}
 
-	server, err := transport.NewServer(tlsConfig, cfg.GetInt(QueueSize), handler)
+	server, err := transport.NewServer(tlsConfig, settings.QueueSize, handler)
 	if err != nil {
 		return fmt.Errorf("initializing server: %v", err)
 	}
+	stats.watchQueue(server.Queue)
+
+	var tunnelServer transport.Server
+	if address := settings.TunnelListen; address != "" {
+		tunnelConfig := tlsConfig
+		tunnelConfig.BindAddress = address
+		if tunnelServer, err = transport.NewTunnelServer(tunnelConfig, settings.QueueSize, handler); err != nil {
+			server.Close()
+			return fmt.Errorf("initializing tunnel server: %v", err)
+		}
+		log.Infof("Tunneling the taskd protocol over HTTPS on %s%s...", address, transport.TunnelPath)
+	}
 
 	log.Infof("Listening on %s...", tlsConfig.BindAddress)
 
+	// every port is bound and the certificates loaded
+	if err := dropPrivileges(settings.RunUser, settings.RunGroup); err != nil {
+		return err
+	}
+	if settings.Sandbox {
+		roots, err := repo.LoadRoots(settings.Root)
+		if err != nil {
+			return err
+		}
+		writable := append(roots.All(), filepath.Dir(settings.AdminSocket))
+		var readable []string
+		for _, path := range []string{settings.CaCert, settings.ServerCert, settings.ServerKey, settings.ClientCert, settings.ClientKey} {
+			if path != "" {
+				readable = append(readable, filepath.Dir(path))
+			}
+		}
+		var executable []string
+		if _, err := os.Stat(settings.Extensions.Dir); err == nil {
+			executable = append(executable, settings.Extensions.Dir)
+		}
+		if err := sandbox(writable, readable, executable); err != nil {
+			return err
+		}
+		if _, err := os.Stat(settings.Hooks.Dir); err == nil {
+			log.Warnf("The hooks in %v can't be executed in the sandbox", settings.Hooks.Dir)
+		}
+		if len(executable) > 0 {
+			log.Warnf("The extensions in %v can only access their own directory in the sandbox", settings.Extensions.Dir)
+		}
+	}
+	if err := settings.Extensions.check(); err != nil {
+		log.Warnf("The extensions can't be listed, every request will be denied: %v", err)
+	}
+
+	quitWatcher := make(chan struct{})
+	go watcher.Watch(DefaultSettingsInterval, quitWatcher)
+	quitStats := make(chan struct{})
+	go saveStats(stats, StatsSaveInterval, quitStats)
+	scheduler.Start()
+
+	// the changes made with the CLI are seen right away, SIGHUP is only
+	// needed after editing the repository by hand
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	go func() {
+		for range reloadChan {
+			for _, cache := range caches {
+				cache.Reload()
+			}
+			log.Infof("Organizations and users reloaded")
+		}
+	}()
+
 	<-shutdownChan
+	signal.Stop(reloadChan)
 
 	log.Info("Shutting down taskserver...")
 
-	return server.Close()
+	close(quitWatcher)
+	close(quitStats)
+	if err := stats.save(); err != nil {
+		log.Errorf("%v", err)
+	}
+	close(quitReplica)
+	scheduler.Close()
+	if err := adminServer.Close(); err != nil {
+		log.Errorf("Error closing admin server: %v", err)
+	}
+	if calendarServer != nil {
+		if err := calendarServer.Close(); err != nil {
+			log.Errorf("Error closing calendar server: %v", err)
+		}
+	}
+	if apiServer != nil {
+		if err := apiServer.Close(); err != nil {
+			log.Errorf("Error closing REST API server: %v", err)
+		}
+	}
+	if feedServer != nil {
+		if err := feedServer.Close(); err != nil {
+			log.Errorf("Error closing change feed server: %v", err)
+		}
+	}
+	if tunnelServer != nil {
+		if err := tunnelServer.Close(); err != nil {
+			log.Errorf("Error closing tunnel server: %v", err)
+		}
+	}
+	if primary != nil {
+		primary.Close()
+		if err := replicationServer.Close(); err != nil {
+			log.Errorf("Error closing replication server: %v", err)
+		}
+	}
+
+	err = server.Close()
+
+	if summary := anomalies.String(); summary != "" {
+		log.Infof("Sync anomalies found: %s", summary)
+	}
+
+	if notifier != nil {
+		if err := notifier.Close(); err != nil {
+			log.Errorf("Error closing the sync events publisher: %v", err)
+		}
+	}
+
+	return err
+}
+
+// serveHTTPS serves the handler in background.  The certificates are loaded
+// and the port bound before returning, so the privileges can be dropped
+// afterwards.
+func serveHTTPS(name, address string, handler http.Handler, tlsConfig transport.TLSConfig) (*http.Server, error) {
+	serverConfig, err := tlsConfig.ServerConfig()
+	if err != nil {
+		return nil, fmt.Errorf("%s server: %v", name, err)
+	}
+	serverConfig.NextProtos = []string{"h2", "http/1.1"}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("%s server: %v", name, err)
+	}
+
+	server := tlsConfig.HTTPServer(handler)
+	server.Addr = address
+	tlsListener := tls.NewListener(listener, serverConfig)
+	go func() {
+		if err := server.Serve(tlsListener); err != http.ErrServerClosed {
+			log.Errorf("%s server stopped: %v", name, err)
+		}
+	}()
+
+	return server, nil
+}
+
+// recordSync returns a function storing the users last sync in the repository
+// located in dataDir and running the sync hook in background.  Errors are only
+// logged, they must not fail the sync.
+func recordSync(dataDir string, hooks repo.Hooks) func(auth.User, repo.LastSync) {
+	return func(user auth.User, sync repo.LastSync) {
+		if user.Org == nil {
+			return
+		}
+		if err := repo.RecordSync(dataDir, user.Org.Name, user.Key, sync); err != nil {
+			log.Warnf("Error recording the last sync of %q: %v", user.Name, err)
+		}
+
+		go func() {
+			err := hooks.Run(repo.HookEvent{
+				Event: repo.HookSyncComplete,
+				Time:  sync.Time,
+				Org:   user.Org.Name,
+				User:  user.Name,
+				Key:   user.Key,
+				Sync:  &sync,
+			})
+			if err != nil {
+				log.Warnf("%v", err)
+			}
+		}()
+	}
+}
+
+// recordMerges returns a function storing the last size merges of the users
+// in the repository located in dataDir.  Errors are only logged.
+func recordMerges(dataDir string, size int) func(auth.User, []repo.MergeRecord) {
+	return func(user auth.User, records []repo.MergeRecord) {
+		if user.Org == nil {
+			return
+		}
+		if err := repo.RecordMerges(dataDir, user.Org.Name, user.Key, records, size); err != nil {
+			log.Warnf("Error recording the merges of %q: %v", user.Name, err)
+		}
+	}
+}
+
+// splitList splits a comma-separated configuration value ignoring the empty
+// entries.
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// intOption returns the value of a numeric option, or def if it's missing.  It
+// fails if the value is not a positive number.
+func intOption(cfg config.Config, key string, def int) (int, error) {
+	value, ok, err := cfg.LookupInt(key)
+	if err != nil {
+		return 0, err
+	} else if !ok {
+		return def, nil
+	} else if value < 1 {
+		return 0, fmt.Errorf("%s: positive number expected, got %d", key, value)
+	}
+	return value, nil
 }
@@ -0,0 +1,44 @@
+package task
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lenientLayouts are the date formats accepted from the clients besides
+// DateLayout when the lenient dates are enabled, all of them UTC unless they
+// have an offset.
+var lenientLayouts = []string{
+	"20060102T150405",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05Z0700",
+}
+
+// ParseDate parses a date formatted according to DateLayout.
+func ParseDate(value string) (time.Time, error) {
+	return time.Parse(DateLayout, value)
+}
+
+// ParseLenientDate parses the dates sent by the clients not following
+// DateLayout: lowercase "t" and "z", ISO 8601 with or without the zone and
+// epoch seconds.
+func ParseLenientDate(value string) (time.Time, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if t, err := ParseDate(value); err == nil {
+		return t, nil
+	}
+
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC(), nil
+	}
+	for _, layout := range lenientLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognized date %q", value)
+}
@@ -0,0 +1,61 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLenientDate(t *testing.T) {
+	expected := time.Date(2021, 10, 9, 11, 25, 36, 0, time.UTC)
+
+	for _, value := range []string{
+		"20211009T112536Z",
+		"20211009T112536z",
+		"20211009T112536",
+		"2021-10-09T11:25:36Z",
+		"2021-10-09T11:25:36z",
+		"2021-10-09T11:25:36",
+		"2021-10-09T13:25:36+02:00",
+		"2021-10-09T13:25:36+0200",
+		"1633778736",
+	} {
+		t.Run(value, func(t *testing.T) {
+			parsed, err := ParseLenientDate(value)
+			assert.Nil(t, err)
+			assert.True(t, expected.Equal(parsed), "got %v", parsed)
+			assert.Equal(t, time.UTC, parsed.Location())
+		})
+	}
+
+	for _, value := range []string{"", "yesterday", "2021-10-09", "20211009T112536Q"} {
+		t.Run("invalid "+value, func(t *testing.T) {
+			_, err := ParseLenientDate(value)
+			assert.NotNil(t, err)
+		})
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		_, err := ParseDate("2021-10-09T11:25:36Z")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestNewLenientTask(t *testing.T) {
+	raw := `{"uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8","description":"Task 1","status":"pending",` +
+		`"entry":1633778736,"modified":"2021-10-09T11:26:36Z","due":"20211009t220000z",` +
+		`"annotations":[{"entry":"1633778800","description":"note"}]}`
+
+	_, err := NewTask(raw)
+	assert.NotNil(t, err)
+
+	task, err := NewLenientTask(raw)
+	assert.Nil(t, err)
+
+	composed, err := task.ComposeJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"annotations":[{"description":"note","entry":"20211009T112640Z"}],"description":"Task 1",`+
+		`"due":"20211009T220000Z","entry":"20211009T112536Z","modified":"20211009T112636Z","status":"pending",`+
+		`"uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`, composed)
+}
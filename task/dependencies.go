@@ -0,0 +1,51 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dependencies returns the uuids of the tasks this task depends on, in the
+// order they were added.
+func (t *Task) Dependencies() []string {
+	return splitList(t.data["depends"])
+}
+
+// HasDependency returns true if the task depends on the given task.
+func (t *Task) HasDependency(uuid string) bool {
+	return sliceContains(t.Dependencies(), uuid)
+}
+
+// AddDependency makes the task depend on the given task, unless it already
+// does.  A task can't depend on itself.
+func (t *Task) AddDependency(uuid string) error {
+	if uuid == "" {
+		return fmt.Errorf("empty dependency")
+	} else if uuid == t.data["uuid"] {
+		return fmt.Errorf("a task cannot be dependent on itself")
+	}
+
+	dependencies := t.Dependencies()
+	if !sliceContains(dependencies, uuid) {
+		t.data["depends"] = strings.Join(append(dependencies, uuid), ",")
+	}
+	return nil
+}
+
+// RemoveDependency makes the task no longer depend on the given task and
+// returns true, or false if it didn't depend on it.
+func (t *Task) RemoveDependency(uuid string) bool {
+	dependencies := t.Dependencies()
+	for i, dependency := range dependencies {
+		if dependency == uuid {
+			dependencies = append(dependencies[:i], dependencies[i+1:]...)
+			if len(dependencies) == 0 {
+				delete(t.data, "depends")
+			} else {
+				t.data["depends"] = strings.Join(dependencies, ",")
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependencies(t *testing.T) {
+	const (
+		self  = "e346004f-6ebb-4507-8f21-0ba2b8f263d8"
+		long  = "abcdef"
+		short = "abc"
+		other = "2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b"
+	)
+
+	newTask := func() Task {
+		return Task{data: map[string]string{"description": "Some task", "uuid": self}}
+	}
+
+	t.Run("overlapping uuids are different dependencies", func(t *testing.T) {
+		task := newTask()
+		assert.NoError(t, task.AddDependency(long))
+		assert.NoError(t, task.AddDependency(short))
+		assert.NoError(t, task.AddDependency(long))
+
+		assert.Equal(t, []string{long, short}, task.Dependencies())
+		assert.True(t, task.HasDependency(short))
+		assert.False(t, task.HasDependency("ab"))
+		assert.False(t, task.HasDependency("abcd"))
+	})
+
+	t.Run("invalid dependencies fail", func(t *testing.T) {
+		task := newTask()
+		assert.Error(t, task.AddDependency(self))
+		assert.Error(t, task.AddDependency(""))
+		assert.Empty(t, task.Dependencies())
+		assert.False(t, task.Has("depends"))
+	})
+
+	t.Run("remove dependencies", func(t *testing.T) {
+		task := newTask()
+		for _, dep := range []string{long, short, other} {
+			assert.NoError(t, task.AddDependency(dep))
+		}
+
+		assert.False(t, task.RemoveDependency("ab"))
+		assert.True(t, task.RemoveDependency(short))
+		assert.Equal(t, []string{long, other}, task.Dependencies())
+		assert.False(t, task.RemoveDependency(short))
+
+		assert.True(t, task.RemoveDependency(long))
+		assert.True(t, task.RemoveDependency(other))
+		assert.Empty(t, task.Dependencies())
+		assert.False(t, task.Has("depends"))
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		task := newTask()
+		for _, dep := range []string{long, short, other} {
+			assert.NoError(t, task.AddDependency(dep))
+		}
+
+		raw, err := task.ComposeJSON()
+		assert.NoError(t, err)
+		parsed, err := NewTask(raw)
+		assert.NoError(t, err)
+		assert.Equal(t, task.Dependencies(), parsed.Dependencies())
+
+		parsed, err = NewTask(task.ComposeF4())
+		assert.NoError(t, err)
+		assert.Equal(t, task.Dependencies(), parsed.Dependencies())
+	})
+
+	t.Run("parsed dependencies with overlapping uuids", func(t *testing.T) {
+		for _, raw := range []string{
+			`{"depends":"abcdef,abc","description":"Some task","uuid":"` + self + `"}`,
+			`{"depends":["abcdef","abc","abc"],"description":"Some task","uuid":"` + self + `"}`,
+		} {
+			task, err := NewTask(raw)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{long, short}, task.Dependencies())
+		}
+	})
+}
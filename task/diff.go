@@ -0,0 +1,44 @@
+package task
+
+import "sort"
+
+// Changes are the attribute differences between two versions of a task, the
+// attribute names are sorted.
+type Changes struct {
+	// Added are the attributes only in the newer version.
+	Added []string `json:"added,omitempty"`
+	// Removed are the attributes only in the older version.
+	Removed []string `json:"removed,omitempty"`
+	// Modified are the attributes in both versions with different values.
+	Modified []string `json:"modified,omitempty"`
+}
+
+// Empty returns true if both versions have the same attributes and values.
+func (c Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Modified) == 0
+}
+
+// Diff returns the changes needed to turn the task from into the task to.
+func Diff(from, to Task) Changes {
+	var c Changes
+
+	for name, value := range from.data {
+		if toValue, ok := to.data[name]; !ok {
+			c.Removed = append(c.Removed, name)
+		} else if toValue != value {
+			c.Modified = append(c.Modified, name)
+		}
+	}
+	for name := range to.data {
+		if _, ok := from.data[name]; !ok {
+			c.Added = append(c.Added, name)
+		}
+	}
+
+	// the map order is random
+	sort.Strings(c.Added)
+	sort.Strings(c.Removed)
+	sort.Strings(c.Modified)
+
+	return c
+}
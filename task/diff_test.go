@@ -0,0 +1,63 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	from := Task{data: map[string]string{
+		"description": "Pay bills",
+		"project":     "home",
+		"status":      "pending",
+		"tags":        "bills",
+		"uuid":        "e346004f-6ebb-4507-8f21-0ba2b8f263d8",
+	}}
+	to := Task{data: map[string]string{
+		"description": "Pay the bills",
+		"due":         "1633816800",
+		"end":         "1633816800",
+		"status":      "completed",
+		"tags":        "bills",
+		"uuid":        "e346004f-6ebb-4507-8f21-0ba2b8f263d8",
+	}}
+
+	cases := []struct {
+		title    string
+		from, to Task
+		expected Changes
+	}{
+		{"changed task", from, to, Changes{
+			Added:    []string{"due", "end"},
+			Removed:  []string{"project"},
+			Modified: []string{"description", "status"},
+		}},
+		{"reverted task", to, from, Changes{
+			Added:    []string{"project"},
+			Removed:  []string{"due", "end"},
+			Modified: []string{"description", "status"},
+		}},
+		{"same task", from, from.Copy(), Changes{}},
+		{"new task", Task{}, from, Changes{Added: []string{"description", "project", "status", "tags", "uuid"}}},
+		{"empty tasks", Task{}, Task{}, Changes{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			changes := Diff(c.from, c.to)
+			assert.Equal(t, c.expected, changes)
+			assert.Equal(t, c.expected.Empty(), changes.Empty())
+		})
+	}
+
+	t.Run("patch applies the changes", func(t *testing.T) {
+		base := from.Copy()
+		base.Set("priority", "H")
+		patch(base, from, to)
+
+		expected := to.Copy()
+		expected.Set("priority", "H")
+		assert.Equal(t, expected.data, base.data)
+	})
+}
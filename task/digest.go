@@ -0,0 +1,163 @@
+package task
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// Mailer sends plain text emails.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer sends the emails through an SMTP server, authenticating if User
+// is set.
+type SMTPMailer struct {
+	Address  string
+	From     string
+	User     string
+	Password string
+}
+
+// Send sends the email.
+func (m SMTPMailer) Send(to, subject, body string) error {
+	var a smtp.Auth
+	if m.User != "" {
+		host, _, err := net.SplitHostPort(m.Address)
+		if err != nil {
+			return err
+		}
+		a = smtp.PlainAuth("", m.User, m.Password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return smtp.SendMail(m.Address, a, m.From, []string{to}, []byte(msg.String()))
+}
+
+// DueTasks returns the pending tasks due before the day of now, and the ones
+// due that day, sorted by their due date.
+func DueTasks(tasks []Task, now time.Time) (overdue, today []Task) {
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	end := start.AddDate(0, 0, 1)
+
+	for _, t := range tasks {
+		due := t.GetDate("due")
+		if t.Get("status") != "pending" || due.IsZero() {
+			continue
+		}
+		if due.Before(start) {
+			overdue = append(overdue, t)
+		} else if due.Before(end) {
+			today = append(today, t)
+		}
+	}
+
+	for _, list := range [][]Task{overdue, today} {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].GetDate("due").Before(list[j].GetDate("due"))
+		})
+	}
+	return overdue, today
+}
+
+// SendDigests emails every user that opted in, with repo.SetDigestEmail, the
+// list of its tasks due today or overdue.  The users without due tasks don't
+// get an email.  The failures don't stop the others from being sent.
+func SendDigests(dataDir string, r Reader, mailer Mailer, now time.Time) error {
+	repository, err := repo.OpenRepository(dataDir)
+	if err != nil {
+		return err
+	}
+
+	var sent, failed int
+	for _, org := range repository.Orgs() {
+		if !org.Deleted.IsZero() {
+			continue
+		}
+		for _, user := range org.Users {
+			if !user.Deleted.IsZero() || !user.Suspended.IsZero() {
+				continue
+			}
+
+			ok, err := sendDigest(repository, r, mailer, user, now)
+			if err != nil {
+				log.Errorf("Error sending the digest of %q of %q: %v", user.Name, org.Name, err)
+				failed++
+			} else if ok {
+				sent++
+			}
+		}
+	}
+
+	log.Infof("Sent %d digests", sent)
+	if failed > 0 {
+		return fmt.Errorf("%d digests not sent", failed)
+	}
+	return nil
+}
+
+// sendDigest sends the digest of the user, if it opted in and has due
+// tasks.
+func sendDigest(repository *repo.Repository, r Reader, mailer Mailer, user auth.User, now time.Time) (bool, error) {
+	email, err := repository.DigestEmail(user.Org.Name, user.Key)
+	if err != nil || email == "" {
+		return false, err
+	}
+
+	data, err := r.Read(user)
+	if err != nil {
+		return false, err
+	}
+	tasks, err := LatestTasks(data)
+	if err != nil {
+		return false, err
+	}
+
+	overdue, today := DueTasks(tasks, now)
+	if len(overdue) == 0 && len(today) == 0 {
+		return false, nil
+	}
+
+	subject := fmt.Sprintf("%d tasks due today, %d overdue", len(today), len(overdue))
+	return true, mailer.Send(email, subject, digestBody(overdue, today, now.Location()))
+}
+
+// digestBody lists the due tasks, one per line.
+func digestBody(overdue, today []Task, loc *time.Location) string {
+	var body strings.Builder
+	for _, section := range []struct {
+		title string
+		tasks []Task
+	}{
+		{"Overdue", overdue},
+		{"Due today", today},
+	} {
+		if len(section.tasks) == 0 {
+			continue
+		}
+		fmt.Fprintf(&body, "%s:\n\n", section.title)
+		for _, t := range section.tasks {
+			line := fmt.Sprintf("  %s  %s", t.GetDate("due").In(loc).Format("2006-01-02 15:04"), t.Get("description"))
+			if project := t.Get("project"); project != "" {
+				line += " (" + project + ")"
+			}
+			body.WriteString(line + "\n")
+		}
+		body.WriteString("\n")
+	}
+	return body.String()
+}
@@ -0,0 +1,98 @@
+package task
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+func TestDueTasks(t *testing.T) {
+	data := []string{
+		`{"description":"late","due":"20250109T100000Z","entry":"20250101T100000Z","status":"pending","uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`,
+		`{"description":"later","due":"20250110T180000Z","entry":"20250101T100000Z","status":"pending","uuid":"2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b"}`,
+		`{"description":"sooner","due":"20250110T080000Z","entry":"20250101T100000Z","status":"pending","uuid":"0c1a7a3e-1b0b-4a4e-9d4d-2f0a6a5a8c01"}`,
+		`{"description":"tomorrow","due":"20250111T100000Z","entry":"20250101T100000Z","status":"pending","uuid":"5c7a9e1b-2d4f-4a6b-8c0e-1f2a3b4c5d6e"}`,
+		`{"description":"done","due":"20250109T100000Z","end":"20250109T120000Z","entry":"20250101T100000Z","status":"completed","uuid":"6c1b7f2e-0f5e-4b8e-a1d9-3f6a2b1c0d9e"}`,
+		`{"description":"someday","entry":"20250101T100000Z","status":"pending","uuid":"94978aad-fbaf-4876-92e0-33321f1cbab9"}`,
+	}
+	tasks, err := LatestTasks(data)
+	assert.NoError(t, err)
+
+	descriptions := func(tasks []Task) (result []string) {
+		for _, t := range tasks {
+			result = append(result, t.Get("description"))
+		}
+		return result
+	}
+
+	overdue, today := DueTasks(tasks, time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, []string{"late"}, descriptions(overdue))
+	assert.Equal(t, []string{"sooner", "later"}, descriptions(today))
+
+	// the day starts in the local time
+	overdue, today = DueTasks(tasks, time.Date(2025, 1, 11, 1, 0, 0, 0, time.FixedZone("UTC+12", 12*3600)))
+	assert.Equal(t, []string{"late", "sooner"}, descriptions(overdue))
+	assert.Equal(t, []string{"later", "tomorrow"}, descriptions(today))
+}
+
+func TestSendDigests(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gotas-digest")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repository, err := repo.NewRepository(dir, nil)
+	assert.NoError(t, err)
+	_, err = repository.NewOrg("Public")
+	assert.NoError(t, err)
+
+	newUser := func(name, email string) auth.User {
+		t.Helper()
+		user, err := repository.AddUser("Public", name)
+		assert.NoError(t, err)
+		if email != "" {
+			assert.NoError(t, repository.SetDigestEmail("Public", user.Key, email))
+		}
+		return *user
+	}
+	noeh := newUser("noeh", "noeh@example.com")
+	idle := newUser("idle", "idle@example.com")
+	quiet := newUser("quiet", "")
+	suspended := newUser("suspended", "suspended@example.com")
+	assert.NoError(t, repository.SuspendUser("Public", suspended.Key))
+
+	due := []string{`{"description":"pay rent","due":"20250110T100000Z","entry":"20250101T100000Z","project":"Home","status":"pending","uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`}
+	reader := mapReader{noeh.Key: due, idle.Key: nil, quiet.Key: due, suspended.Key: due}
+
+	now := time.Date(2025, 1, 10, 7, 0, 0, 0, time.UTC)
+	mailer := &mockMailer{}
+	assert.NoError(t, SendDigests(dir, reader, mailer, now))
+	assert.Len(t, mailer.sent, 1)
+	assert.Equal(t, "noeh@example.com", mailer.sent[0].to)
+	assert.Equal(t, "1 tasks due today, 0 overdue", mailer.sent[0].subject)
+	assert.Equal(t, "Due today:\n\n  2025-01-10 10:00  pay rent (Home)\n\n", mailer.sent[0].body)
+
+	mailer = &mockMailer{err: errors.New("connection refused")}
+	assert.Error(t, SendDigests(dir, reader, mailer, now))
+}
+
+// mapReader reads the transactions of the users by key.
+type mapReader map[string][]string
+
+func (r mapReader) Read(user auth.User) ([]string, error) {
+	return r[user.Key], nil
+}
+
+type mockMailer struct {
+	err  error
+	sent []struct{ to, subject, body string }
+}
+
+func (m *mockMailer) Send(to, subject, body string) error {
+	m.sent = append(m.sent, struct{ to, subject, body string }{to, subject, body})
+	return m.err
+}
@@ -0,0 +1,51 @@
+package task
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// isDryRun returns true if the client asked for a dry-run sync.
+func isDryRun(msg Message) bool {
+	return msg.Header[DryRunHeader] == "1"
+}
+
+// dryRunAllowed returns true if the user can send dry-run syncs.
+func (o Options) dryRunAllowed(user auth.User) bool {
+	if user.Org == nil {
+		return false
+	}
+	return sliceContains(o.DryRunUsers, user.Org.Name+"/"+user.Name)
+}
+
+// dryRunResponse replies the transactions a sync would have stored, along
+// with the number of tasks stored and merged in the "stored" and "merged"
+// headers.
+func dryRunResponse(serverData []string, stored, merged int) Message {
+	resp, err := NewResponse(200).
+		WithHeader(DryRunHeader, "1").
+		WithHeader("stored", strconv.Itoa(stored)).
+		WithHeader("merged", strconv.Itoa(merged)).
+		WithPayload(strings.Join(serverData, "")).
+		Build()
+	if err != nil {
+		return internalErrorResponse(err)
+	}
+	return resp
+}
+
+// DryRunSync computes the sync of the given payload for the user without
+// storing anything, returning the response a dry-run request would get.
+func DryRunSync(user auth.User, payload string, ra ReadAppender, opts Options) Message {
+	msg := Message{
+		Header: map[string]string{
+			"type":       "sync",
+			DryRunHeader: "1",
+		},
+		Payload: payload,
+	}
+
+	return sync(msg, user, ra, opts)
+}
@@ -0,0 +1,60 @@
+package task
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestDryRun(t *testing.T) {
+	user := auth.User{Name: "noeh", Org: &auth.Organization{Name: "Public"}}
+
+	newMessage := func(t *testing.T) (Message, *mockReadAppender) {
+		t.Helper()
+		msg, err := NewMessage(string(loadFile(t, "msg-sent-case01")))
+		assert.Nil(t, err)
+		msg.Header[DryRunHeader] = "1"
+
+		return msg, &mockReadAppender{
+			reader: strings.NewReader(string(loadFile(t, "tx-case01-before.data"))),
+			writer: new(strings.Builder),
+		}
+	}
+
+	t.Run("rejected unless allowed", func(t *testing.T) {
+		msg, ra := newMessage(t)
+
+		resp := processMessage(msg, user, ra, DefaultOptions())
+		assert.Equal(t, "430", resp.Header["code"])
+		assert.Equal(t, "", ra.writer.String())
+	})
+
+	t.Run("computes the merge without storing it", func(t *testing.T) {
+		msg, ra := newMessage(t)
+		opts := DefaultOptions()
+		opts.DryRunUsers = []string{"Public/noeh"}
+
+		resp := processMessage(msg, user, ra, opts)
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.Equal(t, "1", resp.Header[DryRunHeader])
+		assert.Equal(t, "", ra.writer.String())
+
+		// same result as a real sync, without the new sync key
+		delete(msg.Header, DryRunHeader)
+		_, ra = newMessage(t)
+		sync(msg, user, ra, opts)
+		stored := strings.Split(strings.TrimSuffix(ra.writer.String(), "\n"), "\n")
+		assert.Equal(t, strings.Join(stored[:len(stored)-1], "\n")+"\n", resp.Payload)
+	})
+
+	t.Run("from the command line", func(t *testing.T) {
+		msg, ra := newMessage(t)
+
+		resp := DryRunSync(user, msg.Payload, ra, DefaultOptions())
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.NotEqual(t, "", resp.Header["stored"])
+		assert.Equal(t, "", ra.writer.String())
+	})
+}
@@ -0,0 +1,156 @@
+package task
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// EncodingHeader is the header naming the encoding applied to the message
+	// payload.  A missing header means the payload is plain text.
+	EncodingHeader = "encoding"
+
+	// AcceptEncodingHeader is the header used by clients to advertise a
+	// comma-separated list of encodings they understand for the response
+	// payload.
+	AcceptEncodingHeader = "accept-encoding"
+
+	identityEncoding = "identity"
+	gzipEncoding     = "gzip"
+)
+
+// UnsupportedEncodingError is returned when a message payload uses an
+// encoding the server can't handle.
+type UnsupportedEncodingError struct {
+	Encoding string
+}
+
+// Error makes UnsupportedEncodingError an error.
+func (e UnsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported encoding %q", e.Encoding)
+}
+
+// InvalidUTF8Error is returned when a message payload is not valid UTF-8
+// text.  Offset is the position of the first invalid byte.
+type InvalidUTF8Error struct {
+	Offset int
+}
+
+// Error makes InvalidUTF8Error an error.
+func (e InvalidUTF8Error) Error() string {
+	return fmt.Sprintf("payload is not valid UTF-8 (byte offset %d)", e.Offset)
+}
+
+// PayloadTooLargeError is returned when a compressed payload expands beyond
+// the request size limit.
+type PayloadTooLargeError struct {
+	Limit int
+}
+
+// Error makes PayloadTooLargeError an error.
+func (e PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("decoded payload bigger than %d bytes", e.Limit)
+}
+
+// validateUTF8 verifies that the payload is valid UTF-8 text, logging the
+// bytes around the first invalid sequence to help diagnosing broken clients.
+func validateUTF8(payload string) error {
+	if utf8.ValidString(payload) {
+		return nil
+	}
+
+	offset := 0
+	for offset < len(payload) {
+		r, size := utf8.DecodeRuneInString(payload[offset:])
+		if r == utf8.RuneError && size == 1 {
+			break
+		}
+		offset += size
+	}
+
+	from, to := offset-16, offset+16
+	if from < 0 {
+		from = 0
+	}
+	if to > len(payload) {
+		to = len(payload)
+	}
+	log.Warnf("Invalid UTF-8 sequence at byte offset %d: % x", offset, payload[from:to])
+
+	return InvalidUTF8Error{Offset: offset}
+}
+
+// decodePayload replaces the message payload with its plain text version
+// according to the encoding declared by the client, and verifies the
+// resulting text is valid UTF-8.  The decoded payload can't be bigger than
+// limit bytes, so a small compressed request can't expand without bounds.
+func decodePayload(msg *Message, limit int) error {
+	encoding := strings.TrimSpace(strings.ToLower(msg.Header[EncodingHeader]))
+
+	switch encoding {
+	case "", identityEncoding:
+		return validateUTF8(msg.Payload)
+	case gzipEncoding:
+		reader, err := gzip.NewReader(strings.NewReader(msg.Payload))
+		if err != nil {
+			return fmt.Errorf("decoding gzip payload: %v", err)
+		}
+		defer reader.Close()
+
+		var payload strings.Builder
+		if _, err := io.Copy(&payload, io.LimitReader(reader, int64(limit)+1)); err != nil {
+			return fmt.Errorf("decoding gzip payload: %v", err)
+		} else if payload.Len() > limit {
+			return PayloadTooLargeError{Limit: limit}
+		}
+
+		msg.Payload = payload.String()
+		delete(msg.Header, EncodingHeader)
+		return validateUTF8(msg.Payload)
+	default:
+		return UnsupportedEncodingError{Encoding: encoding}
+	}
+}
+
+// encodePayload compresses the response payload if the request advertised
+// an encoding supported by the server.  Unknown encodings are ignored and
+// the payload is sent as plain text.
+func encodePayload(req Message, resp *Message) error {
+	if (len(resp.Payload) == 0 && resp.Stream == nil) || !acceptsEncoding(req, gzipEncoding) {
+		return nil
+	}
+
+	// a streamed payload is compressed as it's generated, only the
+	// compressed one is kept in memory
+	var payload bytes.Buffer
+	writer := gzip.NewWriter(&payload)
+	var err error
+	if resp.Stream != nil {
+		_, err = resp.Stream.WriteTo(writer)
+	} else {
+		_, err = writer.Write([]byte(resp.Payload))
+	}
+	if err != nil {
+		return fmt.Errorf("encoding gzip payload: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("encoding gzip payload: %v", err)
+	}
+
+	resp.Payload, resp.Stream = payload.String(), nil
+	resp.Header[EncodingHeader] = gzipEncoding
+	return nil
+}
+
+func acceptsEncoding(msg Message, encoding string) bool {
+	for _, accepted := range strings.Split(msg.Header[AcceptEncodingHeader], ",") {
+		if strings.TrimSpace(strings.ToLower(accepted)) == encoding {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package task
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodePayload(t *testing.T) {
+	t.Run("plain payload is not modified", func(t *testing.T) {
+		msg := Message{Header: map[string]string{}, Payload: "payload"}
+
+		assert.NoError(t, decodePayload(&msg, RequestLimitInBytes))
+		assert.Equal(t, "payload", msg.Payload)
+	})
+
+	t.Run("identity payload is not modified", func(t *testing.T) {
+		msg := Message{Header: map[string]string{EncodingHeader: "identity"}, Payload: "payload"}
+
+		assert.NoError(t, decodePayload(&msg, RequestLimitInBytes))
+		assert.Equal(t, "payload", msg.Payload)
+	})
+
+	t.Run("gzip payload is decompressed", func(t *testing.T) {
+		msg := Message{Header: map[string]string{EncodingHeader: "GZIP"}, Payload: gzipString(t, "payload")}
+
+		assert.NoError(t, decodePayload(&msg, RequestLimitInBytes))
+		assert.Equal(t, "payload", msg.Payload)
+		assert.NotContains(t, msg.Header, EncodingHeader)
+	})
+
+	t.Run("malformed gzip payload fails", func(t *testing.T) {
+		msg := Message{Header: map[string]string{EncodingHeader: "gzip"}, Payload: "payload"}
+
+		err := decodePayload(&msg, RequestLimitInBytes)
+		assert.Error(t, err)
+		_, unsupported := err.(UnsupportedEncodingError)
+		assert.False(t, unsupported)
+	})
+
+	t.Run("invalid utf-8 payload fails", func(t *testing.T) {
+		msg := Message{Header: map[string]string{}, Payload: "{\"description\":\"ab\xbd\xb2\"}"}
+
+		err := decodePayload(&msg, RequestLimitInBytes)
+		assert.Equal(t, InvalidUTF8Error{Offset: 18}, err)
+		assert.Contains(t, err.Error(), "18")
+	})
+
+	t.Run("invalid utf-8 gzip payload fails", func(t *testing.T) {
+		msg := Message{Header: map[string]string{EncodingHeader: "gzip"}, Payload: gzipString(t, "\xff")}
+
+		assert.Equal(t, InvalidUTF8Error{Offset: 0}, decodePayload(&msg, RequestLimitInBytes))
+	})
+
+	t.Run("gzip payload expanding beyond the limit fails", func(t *testing.T) {
+		msg := Message{Header: map[string]string{EncodingHeader: "gzip"}, Payload: gzipString(t, strings.Repeat("a", 1025))}
+
+		assert.Equal(t, PayloadTooLargeError{Limit: 1024}, decodePayload(&msg, 1024))
+	})
+
+	t.Run("gzip payload with blank lines is kept whole", func(t *testing.T) {
+		raw := "type: sync\nencoding: gzip\n\n" + gzipString(t, "{}\n\n{}")
+		msg, err := NewMessage(raw)
+		assert.NoError(t, err)
+
+		assert.NoError(t, decodePayload(&msg, RequestLimitInBytes))
+		assert.Equal(t, "{}\n\n{}", msg.Payload)
+	})
+
+	t.Run("unknown encoding fails", func(t *testing.T) {
+		msg := Message{Header: map[string]string{EncodingHeader: "br"}, Payload: "payload"}
+
+		err := decodePayload(&msg, RequestLimitInBytes)
+		assert.Equal(t, UnsupportedEncodingError{Encoding: "br"}, err)
+		assert.NotEmpty(t, err.Error())
+	})
+}
+
+func TestEncodePayload(t *testing.T) {
+	t.Run("response is compressed when accepted", func(t *testing.T) {
+		req := Message{Header: map[string]string{AcceptEncodingHeader: "br, gzip"}}
+		resp := NewResponseMessage("200", "Ok")
+		resp.Payload = "payload"
+
+		assert.NoError(t, encodePayload(req, &resp))
+		assert.Equal(t, "gzip", resp.Header[EncodingHeader])
+		assert.Equal(t, "payload", gunzipString(t, resp.Payload))
+	})
+
+	t.Run("response is not compressed when not accepted", func(t *testing.T) {
+		req := Message{Header: map[string]string{AcceptEncodingHeader: "br"}}
+		resp := NewResponseMessage("200", "Ok")
+		resp.Payload = "payload"
+
+		assert.NoError(t, encodePayload(req, &resp))
+		assert.NotContains(t, resp.Header, EncodingHeader)
+		assert.Equal(t, "payload", resp.Payload)
+	})
+
+	t.Run("empty response is not compressed", func(t *testing.T) {
+		req := Message{Header: map[string]string{AcceptEncodingHeader: "gzip"}}
+		resp := NewResponseMessage("500", "error")
+
+		assert.NoError(t, encodePayload(req, &resp))
+		assert.NotContains(t, resp.Header, EncodingHeader)
+	})
+}
+
+func TestProcessEncodedMessage(t *testing.T) {
+	t.Run("unsupported encoding replies 401", func(t *testing.T) {
+		msg := Message{
+			Header: map[string]string{
+				"type":         "sync",
+				"protocol":     "v1",
+				EncodingHeader: "br",
+			},
+			Payload: "payload",
+		}
+		client := &mockClient{
+			reader: strings.NewReader(serialize(t, msg)),
+			writer: new(strings.Builder),
+		}
+		ra := &mockReadAppender{writer: new(strings.Builder)}
+
+		Process(client, &mockAuth{}, ra, DefaultOptions())
+
+		resp := parseMsg(t, client.writer.String())
+		assert.Equal(t, "401", resp.Header["code"])
+		assert.Empty(t, ra.writer.String())
+	})
+
+	t.Run("invalid utf-8 replies 401", func(t *testing.T) {
+		msg := parseMsg(t, loadPayload(t, "msg-sent-init"))
+		msg.Payload = "\xbd\xb2" + msg.Payload
+
+		client := &mockClient{
+			reader: strings.NewReader(serialize(t, msg)),
+			writer: new(strings.Builder),
+		}
+		ra := &mockReadAppender{writer: new(strings.Builder)}
+
+		Process(client, &mockAuth{}, ra, DefaultOptions())
+
+		// the offset of the invalid bytes is only logged
+		resp := parseMsg(t, client.writer.String())
+		assert.Equal(t, "401", resp.Header["code"])
+		assert.Equal(t, "Unsupported encoding", resp.Header["status"])
+		assert.Empty(t, ra.writer.String())
+	})
+
+	t.Run("compressed sync replies compressed", func(t *testing.T) {
+		msg := parseMsg(t, loadPayload(t, "msg-sent-init"))
+		msg.Header[EncodingHeader] = "gzip"
+		msg.Header[AcceptEncodingHeader] = "gzip"
+		msg.Payload = gzipString(t, msg.Payload)
+
+		client := &mockClient{
+			reader: strings.NewReader(serialize(t, msg)),
+			writer: new(strings.Builder),
+		}
+		ra := &mockReadAppender{
+			reader: strings.NewReader(""),
+			writer: new(strings.Builder),
+		}
+
+		Process(client, &mockAuth{}, ra, DefaultOptions())
+
+		resp := parseMsg(t, client.writer.String())
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.Equal(t, "gzip", resp.Header[EncodingHeader])
+		assert.NotEmpty(t, gunzipString(t, resp.Payload))
+		assert.NotEmpty(t, ra.writer.String())
+	})
+}
+
+func serialize(t *testing.T, msg Message) string {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	_, err := msg.WriteTo(&buffer)
+	assert.NoError(t, err)
+	return buffer.String()
+}
+
+func gzipString(t *testing.T, value string) string {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, err := writer.Write([]byte(value))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return buffer.String()
+}
+
+func gunzipString(t *testing.T, value string) string {
+	t.Helper()
+
+	reader, err := gzip.NewReader(strings.NewReader(value))
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestCompressedRequests(t *testing.T) {
+	process := func(t *testing.T, opts Options) Message {
+		t.Helper()
+
+		req := SyncRequest(Credentials{Org: "Public", User: "noeh", Key: "secret"}, "test", nil, "")
+		req.Header[EncodingHeader] = gzipEncoding
+		req.Payload = gzipString(t, strings.Repeat("a", 100000))
+		var raw bytes.Buffer
+		_, err := req.WriteTo(&raw)
+		assert.NoError(t, err)
+
+		client := &mockClient{writer: new(strings.Builder), reader: strings.NewReader(raw.String())}
+		// the storage is never reached
+		Process(client, &mockAuth{}, &panicReadAppender{}, opts)
+		return parseMsg(t, client.writer.String())
+	}
+
+	t.Run("decoded request over the limit", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.RequestLimit = 10000
+
+		assert.Equal(t, "504", process(t, opts).Header["code"])
+	})
+
+	t.Run("decoded request over budget", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Memory = NewMemoryBudget(20000)
+
+		assert.Equal(t, "420", process(t, opts).Header["code"])
+		assert.Zero(t, opts.Memory.Stats().InUse)
+	})
+}
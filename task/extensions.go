@@ -0,0 +1,219 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/process"
+)
+
+// Extension points, the executables are named after the point invoking them,
+// optionally followed by a dot and a suffix to run several of them in name
+// order, e.g. "pre-auth.10-ratelimit".
+const (
+	ExtensionPreAuth  = "pre-auth"
+	ExtensionPostSync = "post-sync"
+)
+
+// DefaultExtensionTimeout is how long an extension can run before it's
+// killed.
+const DefaultExtensionTimeout = 5 * time.Second
+
+// ExtensionRequest is the request sent as JSON to the extensions standard
+// input.  Code, RequestSize and ResponseSize are only sent on
+// ExtensionPostSync.
+type ExtensionRequest struct {
+	Point        string    `json:"point"`
+	Time         time.Time `json:"time"`
+	Org          string    `json:"org"`
+	User         string    `json:"user"`
+	Client       string    `json:"client,omitempty"`
+	Address      string    `json:"address,omitempty"`
+	Type         string    `json:"type"`
+	Code         string    `json:"code,omitempty"`
+	RequestSize  int       `json:"request_size,omitempty"`
+	ResponseSize int       `json:"response_size,omitempty"`
+}
+
+// ExtensionReply is the optional JSON an extension denying a request writes
+// to its standard output, to choose the response sent to the client.
+type ExtensionReply struct {
+	Code   int    `json:"code"`
+	Status string `json:"status"`
+}
+
+// ServerExtensions runs the executables found in Dir at the extension points,
+// so the admins can enforce custom policies without recompiling the server.
+// The zero value has no extensions.
+type ServerExtensions struct {
+	Dir     string
+	Timeout time.Duration
+}
+
+// NewExtensions returns the extensions found in dir, relative to root unless
+// absolute.  An empty dir disables the extensions, and a zero timeout means
+// DefaultExtensionTimeout.
+func NewExtensions(root, dir string, timeout time.Duration) ServerExtensions {
+	if dir == "" {
+		return ServerExtensions{}
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	if timeout <= 0 {
+		timeout = DefaultExtensionTimeout
+	}
+	return ServerExtensions{Dir: dir, Timeout: timeout}
+}
+
+// PreAuth runs the pre-auth extensions before the request is authenticated,
+// stopping at the first one exiting with a non-zero status.  It returns the
+// response rejecting the request, 430 unless the extension replied another
+// one.  An extension failing to run or timing out rejects the request too,
+// with a 500 code, as does an extensions directory that can't be listed.
+func (e ServerExtensions) PreAuth(req ExtensionRequest) (Message, bool) {
+	req.Point = ExtensionPreAuth
+	paths, err := e.executables(req.Point)
+	if err != nil {
+		log.Errorf("Listing the extensions: %v", err)
+		return catalogResponse("500", MsgExtensionFailed), true
+	}
+	for _, path := range paths {
+		output, err := e.run(path, req)
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			log.Warnf("Extension %v denied %q of %q: %v", filepath.Base(path), req.User, req.Org, exitErr)
+			return extensionResponse(output), true
+		} else if err != nil {
+			log.Errorf("%v", err)
+			return catalogResponse("500", MsgExtensionFailed), true
+		}
+	}
+	return Message{}, false
+}
+
+// PostSync runs the post-sync extensions after a sync is replied, their
+// errors are only logged.
+func (e ServerExtensions) PostSync(req ExtensionRequest) {
+	req.Point = ExtensionPostSync
+	paths, err := e.executables(req.Point)
+	if err != nil {
+		log.Warnf("Listing the extensions: %v", err)
+	}
+	for _, path := range paths {
+		if _, err := e.run(path, req); err != nil {
+			log.Warnf("Extension %v: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+// check returns an error if the extensions directory exists but can't be
+// listed, which denies every request.
+func (e ServerExtensions) check() error {
+	_, err := e.executables(ExtensionPreAuth)
+	return err
+}
+
+// executables returns the executables of the extension point, sorted by
+// name.  A missing extensions directory has no executables.
+func (e ServerExtensions) executables(point string) ([]string, error) {
+	if e.Dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(e.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if name != point && !strings.HasPrefix(name, point+".") {
+			continue
+		}
+		path := filepath.Join(e.Dir, name)
+		if info, err := os.Stat(path); err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+			log.Warnf("Ignoring extension %v: not executable", path)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// run executes the extension with the request as JSON in its standard input,
+// returning its standard output.  A non-zero exit status is returned as an
+// *exec.ExitError, and the standard error is logged.  The extension is
+// killed, along with the processes it started, if it doesn't finish on time.
+func (e ServerExtensions) run(path string, req ExtensionRequest) ([]byte, error) {
+	if req.Time.IsZero() {
+		req.Time = time.Now().UTC().Truncate(time.Second)
+	}
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("extension %v: %v", filepath.Base(path), err)
+	}
+
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExtensionTimeout
+	}
+	stdout, stderr, err := process.Run(path, e.Dir, input, timeout)
+	if msg := strings.TrimSpace(string(stderr)); msg != "" {
+		log.Debugf("Extension %v: %s", filepath.Base(path), msg)
+	}
+	if errors.Is(err, process.ErrTimeout) {
+		return nil, fmt.Errorf("extension %v: timed out after %v", filepath.Base(path), timeout)
+	} else if _, ok := err.(*exec.ExitError); ok {
+		return stdout, err
+	} else if err != nil {
+		return nil, fmt.Errorf("extension %v: %v", filepath.Base(path), err)
+	}
+
+	return stdout, nil
+}
+
+// extensionRequest returns the extensions request describing msg, sent by
+// peer.
+func extensionRequest(msg Message, peer auth.Peer) ExtensionRequest {
+	return ExtensionRequest{
+		Org:     msg.Header["org"],
+		User:    msg.Header["user"],
+		Client:  msg.Header["client"],
+		Address: peer.Address,
+		Type:    msg.Header["type"],
+	}
+}
+
+// extensionResponse returns the response an extension denying a request
+// replied, 430 if it didn't reply a valid one.
+func extensionResponse(output []byte) Message {
+	var reply ExtensionReply
+	if len(bytes.TrimSpace(output)) > 0 {
+		if err := json.Unmarshal(output, &reply); err != nil {
+			log.Warnf("Ignoring invalid extension reply %q: %v", output, err)
+		}
+	}
+	if _, ok := ErrorCodes[reply.Code]; !ok || reply.Code < 400 {
+		reply.Code = 430
+	}
+
+	resp := NewResponseMessage(strconv.Itoa(reply.Code), ErrorCodes[reply.Code])
+	if reply.Status != "" {
+		resp.Header["status"] = reply.Status
+	}
+	return resp
+}
@@ -0,0 +1,145 @@
+package task
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestExtensions(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "requests")
+
+	install := func(t *testing.T, name, script string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755))
+		t.Cleanup(func() { os.Remove(path) })
+	}
+
+	extensions := NewExtensions("/ignored", dir, time.Second)
+	assert.Equal(t, dir, extensions.Dir)
+	assert.Equal(t, ServerExtensions{}, NewExtensions(dir, "", 0))
+	assert.Equal(t, DefaultExtensionTimeout, NewExtensions(dir, "extensions", 0).Timeout)
+
+	req := ExtensionRequest{Org: "Public", User: "john", Type: "sync"}
+
+	t.Run("allowed", func(t *testing.T) {
+		install(t, ExtensionPreAuth, "cat > "+output+"\n")
+		install(t, "post-sync.sample", "exit 1\n")
+
+		_, denied := extensions.PreAuth(req)
+		assert.False(t, denied)
+
+		content, err := os.ReadFile(output)
+		assert.Nil(t, err)
+		var got ExtensionRequest
+		assert.Nil(t, json.Unmarshal(content, &got))
+		assert.Equal(t, ExtensionPreAuth, got.Point)
+		assert.Equal(t, "john", got.User)
+		assert.False(t, got.Time.IsZero())
+	})
+
+	t.Run("denied in name order", func(t *testing.T) {
+		install(t, "pre-auth.10-first", "exit 0\n")
+		install(t, "pre-auth.20-deny", `echo '{"code": 431, "status": "Rate limited"}'; exit 1`+"\n")
+		install(t, "pre-auth.30-never", "touch "+output+".never\n")
+
+		resp, denied := extensions.PreAuth(req)
+		assert.True(t, denied)
+		assert.Equal(t, "431", resp.Header["code"])
+		assert.Equal(t, "Rate limited", resp.Header["status"])
+		assert.NoFileExists(t, output+".never")
+	})
+
+	t.Run("denied without reply", func(t *testing.T) {
+		install(t, ExtensionPreAuth, "echo nope; exit 2\n")
+
+		resp, denied := extensions.PreAuth(req)
+		assert.True(t, denied)
+		assert.Equal(t, "430", resp.Header["code"])
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		install(t, ExtensionPreAuth, "exec sleep 5\n")
+
+		resp, denied := NewExtensions(dir, dir, 100*time.Millisecond).PreAuth(req)
+		assert.True(t, denied)
+		assert.Equal(t, "500", resp.Header["code"])
+	})
+
+	t.Run("not executable", func(t *testing.T) {
+		path := filepath.Join(dir, ExtensionPreAuth)
+		assert.Nil(t, os.WriteFile(path, []byte("#!/bin/sh\nexit 1\n"), 0644))
+		defer os.Remove(path)
+
+		_, denied := extensions.PreAuth(req)
+		assert.False(t, denied)
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		missing := NewExtensions(dir, "missing", time.Second)
+
+		_, denied := missing.PreAuth(req)
+		assert.False(t, denied)
+		assert.Nil(t, missing.check())
+	})
+
+	t.Run("unreadable directory", func(t *testing.T) {
+		install(t, "not-a-dir", "exit 0\n")
+		unreadable := NewExtensions(dir, "not-a-dir", time.Second)
+
+		resp, denied := unreadable.PreAuth(req)
+		assert.True(t, denied)
+		assert.Equal(t, "500", resp.Header["code"])
+		assert.NotNil(t, unreadable.check())
+	})
+
+	t.Run("post-sync", func(t *testing.T) {
+		install(t, ExtensionPostSync, "cat > "+output+"\n")
+
+		extensions.PostSync(ExtensionRequest{Org: "Public", User: "john", Type: "sync", Code: "200", RequestSize: 10})
+
+		content, err := os.ReadFile(output)
+		assert.Nil(t, err)
+		var got ExtensionRequest
+		assert.Nil(t, json.Unmarshal(content, &got))
+		assert.Equal(t, ExtensionPostSync, got.Point)
+		assert.Equal(t, "200", got.Code)
+		assert.Equal(t, 10, got.RequestSize)
+	})
+
+	t.Run("request rejected before authenticating", func(t *testing.T) {
+		install(t, ExtensionPreAuth, "exit 1\n")
+
+		client := &mockClient{
+			reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+			writer: new(strings.Builder),
+		}
+		ra := &mockReadAppender{reader: strings.NewReader(""), writer: new(strings.Builder)}
+		opts := DefaultOptions()
+		opts.Extensions = extensions
+
+		Process(client, &peerAuth{allowed: "nobody"}, ra, opts)
+
+		resp, err := NewMessage(client.writer.String()[4:])
+		assert.Nil(t, err)
+		assert.Equal(t, "430", resp.Header["code"])
+		assert.Empty(t, ra.writer.String())
+	})
+}
+
+func TestExtensionRequest(t *testing.T) {
+	msg := Message{Header: map[string]string{"org": "Public", "user": "john", "client": "taskwarrior 2.6.0", "type": "sync"}}
+	req := extensionRequest(msg, auth.Peer{Address: "192.0.2.1:1234"})
+
+	assert.Equal(t, ExtensionRequest{
+		Org: "Public", User: "john", Client: "taskwarrior 2.6.0", Address: "192.0.2.1:1234", Type: "sync",
+	}, req)
+}
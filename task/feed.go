@@ -0,0 +1,210 @@
+package task
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+const (
+	// DefaultFeedSize is the number of events kept in memory by the change
+	// feed when "feed.size" is not configured.
+	DefaultFeedSize = 1000
+
+	feedDefaultTimeout = 30 * time.Second
+	feedMaxTimeout     = 5 * time.Minute
+)
+
+// FeedEvent describes the transactions appended by a user in a sync.
+type FeedEvent struct {
+	Seq   uint64            `json:"seq"`
+	Time  time.Time         `json:"time"`
+	Org   string            `json:"org"`
+	User  string            `json:"user"`
+	Tasks []json.RawMessage `json:"tasks"`
+}
+
+// feedResponse is the long-poll response body.  Next is the sequence to use
+// in the following request.  Truncated is set if some events were discarded
+// before the client could get them.
+type feedResponse struct {
+	Events    []FeedEvent `json:"events"`
+	Next      uint64      `json:"next"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// Feed is a ReadAppender keeping the last appended transactions in memory, so
+// integrations can follow the changes without polling the tx files.
+type Feed struct {
+	ReadAppender
+
+	mu     gosync.Mutex
+	seq    uint64
+	size   int
+	events []FeedEvent
+	notify chan struct{}
+}
+
+// NewFeed wraps ra keeping the last size events.
+func NewFeed(ra ReadAppender, size int) *Feed {
+	if size < 1 {
+		size = DefaultFeedSize
+	}
+	return &Feed{
+		ReadAppender: ra,
+		size:         size,
+		notify:       make(chan struct{}),
+	}
+}
+
+// Append stores the data and publishes the appended tasks.
+func (f *Feed) Append(user auth.User, data []string) error {
+	if err := f.ReadAppender.Append(user, data); err != nil {
+		return err
+	}
+
+	var tasks []json.RawMessage
+	for _, line := range data {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "{") {
+			tasks = append(tasks, json.RawMessage(line))
+		}
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	event := FeedEvent{
+		Time:  time.Now().UTC(),
+		User:  user.Name,
+		Tasks: tasks,
+	}
+	if user.Org != nil {
+		event.Org = user.Org.Name
+	}
+	f.publish(event)
+
+	return nil
+}
+
+func (f *Feed) publish(event FeedEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	event.Seq = f.seq
+	f.events = append(f.events, event)
+	if len(f.events) > f.size {
+		f.events = f.events[len(f.events)-f.size:]
+	}
+
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// Since returns the events of the org user published after seq, or the ones
+// of every user of the org if user is empty, whether older events were
+// already discarded, and a channel closed when a new event is published.
+func (f *Feed) Since(org, user string, seq uint64) ([]FeedEvent, bool, <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var events []FeedEvent
+	for _, e := range f.events {
+		if e.Seq > seq && e.Org == org && (user == "" || e.User == user) {
+			events = append(events, e)
+		}
+	}
+	truncated := len(f.events) > 0 && f.events[0].Seq > seq+1
+
+	return events, truncated, f.notify
+}
+
+// last returns the sequence of the last published event.
+func (f *Feed) last() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.seq
+}
+
+// Handler returns a long-poll HTTP handler serving the feed.  Clients
+// authenticate using basic auth, with "org/user" as user name and the user
+// key as password, and only get their own events, or the ones of the whole
+// organization if they can manage it.  The
+// "since" parameter is the last sequence seen and "timeout" is how long to
+// wait for new events.
+func (f *Feed) Handler(a auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, ok := authenticateBasic(w, r, a)
+		if !ok {
+			return
+		}
+
+		var since uint64
+		var err error
+		if value := r.URL.Query().Get("since"); value != "" {
+			if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+				http.Error(w, "invalid since parameter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		timeout := feedDefaultTimeout
+		if value := r.URL.Query().Get("timeout"); value != "" {
+			if timeout, err = time.ParseDuration(value); err != nil || timeout < 0 {
+				http.Error(w, "invalid timeout parameter", http.StatusBadRequest)
+				return
+			}
+			if timeout > feedMaxTimeout {
+				timeout = feedMaxTimeout
+			}
+		}
+
+		userName := user.Name
+		if user.CanManage(user.Org.Name) {
+			userName = ""
+		}
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		resp := feedResponse{Next: since}
+		for {
+			events, truncated, notify := f.Since(user.Org.Name, userName, since)
+			if len(events) > 0 {
+				resp = feedResponse{Events: events, Next: events[len(events)-1].Seq, Truncated: truncated}
+				break
+			}
+
+			select {
+			case <-notify:
+				continue
+			case <-timer.C:
+			case <-r.Context().Done():
+				return
+			}
+			// nothing for this user, skip the events of others.  A sequence
+			// ahead of the last event means the server was restarted.
+			if last := f.last(); last != since {
+				resp.Next = last
+				resp.Truncated = last < since
+			}
+			break
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("Error sending feed events: %v", err)
+		}
+	})
+}
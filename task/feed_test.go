@@ -0,0 +1,127 @@
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+type feedAuth struct{}
+
+func (feedAuth) Authenticate(org, user, key string) (auth.User, error) {
+	if key != "secret" {
+		return auth.User{}, errors.New("invalid key")
+	}
+	role := auth.RoleUser
+	if user == "admin" {
+		role = auth.RoleOrgAdmin
+	}
+	return auth.User{Name: user, Key: key, Role: role, Org: &auth.Organization{Name: org}}, nil
+}
+
+func TestFeed(t *testing.T) {
+	public := auth.User{Name: "noeh", Org: &auth.Organization{Name: "Public"}}
+	private := auth.User{Name: "john", Org: &auth.Organization{Name: "Private"}}
+
+	feed := NewFeed(newMemReadAppender(), 2)
+	server := httptest.NewServer(feed.Handler(feedAuth{}))
+	defer server.Close()
+
+	get := func(t *testing.T, login, key, query string) (int, feedResponse) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/?"+query, nil)
+		assert.NoError(t, err)
+		req.SetBasicAuth(login, key)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		var body feedResponse
+		if resp.StatusCode == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		}
+		return resp.StatusCode, body
+	}
+
+	t.Run("requires authentication", func(t *testing.T) {
+		code, _ := get(t, "Public/noeh", "invalid", "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+
+		code, _ = get(t, "noeh", "secret", "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("rejects invalid parameters", func(t *testing.T) {
+		code, _ := get(t, "Public/noeh", "secret", "since=abc")
+		assert.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = get(t, "Public/noeh", "secret", "timeout=abc")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("times out without events", func(t *testing.T) {
+		code, body := get(t, "Public/noeh", "secret", "timeout=10ms")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Empty(t, body.Events)
+		assert.Equal(t, uint64(0), body.Next)
+	})
+
+	t.Run("waits for new events", func(t *testing.T) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			assert.NoError(t, feed.Append(private, []string{`{"uuid":"1"}` + "\n", "key\n"}))
+			assert.NoError(t, feed.Append(public, []string{`{"uuid":"2"}` + "\n", "key\n"}))
+		}()
+
+		code, body := get(t, "Public/noeh", "secret", "timeout=1s")
+		assert.Equal(t, http.StatusOK, code)
+		if assert.Len(t, body.Events, 1) {
+			assert.Equal(t, "noeh", body.Events[0].User)
+			assert.Equal(t, uint64(2), body.Events[0].Seq)
+			assert.JSONEq(t, `{"uuid":"2"}`, string(body.Events[0].Tasks[0]))
+		}
+		assert.Equal(t, uint64(2), body.Next)
+	})
+
+	t.Run("skips other orgs events", func(t *testing.T) {
+		code, body := get(t, "Private/john", "secret", "since=1&timeout=10ms")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Empty(t, body.Events)
+		assert.Equal(t, uint64(2), body.Next)
+	})
+
+	t.Run("skips other users events", func(t *testing.T) {
+		code, body := get(t, "Public/bob", "secret", "since=1&timeout=10ms")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Empty(t, body.Events)
+		assert.Equal(t, uint64(2), body.Next)
+	})
+
+	t.Run("org admins get the org events", func(t *testing.T) {
+		code, body := get(t, "Public/admin", "secret", "since=1")
+		assert.Equal(t, http.StatusOK, code)
+		if assert.Len(t, body.Events, 1) {
+			assert.Equal(t, "noeh", body.Events[0].User)
+		}
+	})
+
+	t.Run("reports discarded events", func(t *testing.T) {
+		assert.NoError(t, feed.Append(public, []string{`{"uuid":"3"}` + "\n"}))
+		// sync keys alone are not published
+		assert.NoError(t, feed.Append(public, []string{"key\n"}))
+
+		code, body := get(t, "Public/noeh", "secret", "since=0")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, body.Events, 2)
+		assert.True(t, body.Truncated)
+		assert.Equal(t, uint64(3), body.Next)
+	})
+}
@@ -0,0 +1,125 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// filterDateLayout is the short date format accepted by the filters, besides
+// DateLayout.
+const filterDateLayout = "2006-01-02"
+
+// Filter selects tasks using a taskwarrior like expression, the terms
+// separated by spaces and all of them satisfied by the matching tasks:
+//
+//	status:pending      the attribute has the value, empty if it's missing
+//	project:Work        the project or one of its subprojects
+//	+urgent -someday    the task has, or doesn't have, the tag
+//	due.before:2025-01-01 due.after:20250101T000000Z
+//	description.has:milk project.not:Home
+//
+// The dates are either in DateLayout or YYYY-MM-DD, UTC.
+type Filter struct {
+	terms []filterTerm
+}
+
+// filterTerm is a condition of a Filter, tags are compared with the "tags"
+// attribute and the "has" or "not" modifiers.
+type filterTerm struct {
+	attr     string
+	modifier string
+	value    string
+	date     time.Time
+}
+
+// filterModifiers are the attribute modifiers supported by the filters.
+var filterModifiers = []string{"before", "after", "has", "not", "is"}
+
+// ParseFilter parses a filter expression, an empty one matches every task.
+func ParseFilter(expr string) (Filter, error) {
+	var f Filter
+	for _, word := range strings.Fields(expr) {
+		term, err := parseFilterTerm(word)
+		if err != nil {
+			return Filter{}, err
+		}
+		f.terms = append(f.terms, term)
+	}
+	return f, nil
+}
+
+func parseFilterTerm(word string) (filterTerm, error) {
+	if word[0] == '+' || word[0] == '-' {
+		tag := word[1:]
+		if err := validateTag(tag); err != nil {
+			return filterTerm{}, fmt.Errorf("invalid filter %q: %v", word, err)
+		}
+		if word[0] == '+' {
+			return filterTerm{attr: "tags", modifier: "has", value: tag}, nil
+		}
+		return filterTerm{attr: "tags", modifier: "not", value: tag}, nil
+	}
+
+	colon := strings.IndexByte(word, ':')
+	if colon <= 0 {
+		return filterTerm{}, fmt.Errorf("invalid filter %q, attribute:value, +tag or -tag expected", word)
+	}
+	term := filterTerm{attr: word[:colon], modifier: "is", value: word[colon+1:]}
+	if dot := strings.LastIndexByte(term.attr, '.'); dot > 0 && sliceContains(filterModifiers, term.attr[dot+1:]) {
+		term.attr, term.modifier = term.attr[:dot], term.attr[dot+1:]
+	}
+
+	if term.modifier == "before" || term.modifier == "after" {
+		date, err := time.Parse(DateLayout, term.value)
+		if err != nil {
+			if date, err = time.Parse(filterDateLayout, term.value); err != nil {
+				return filterTerm{}, fmt.Errorf("invalid filter %q, date expected", word)
+			}
+		}
+		term.date = date
+	}
+
+	return term, nil
+}
+
+// Match returns true if the task satisfies every term of the filter.
+func (f Filter) Match(t Task) bool {
+	for _, term := range f.terms {
+		if !term.match(t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (term filterTerm) match(t Task) bool {
+	if term.attr == "tags" && (term.modifier == "has" || term.modifier == "not") {
+		return t.HasTag(term.value) == (term.modifier == "has")
+	}
+
+	value := t.Get(term.attr)
+	switch term.modifier {
+	case "before":
+		date := t.GetDate(term.attr)
+		return !date.IsZero() && date.Before(term.date)
+	case "after":
+		date := t.GetDate(term.attr)
+		return !date.IsZero() && date.After(term.date)
+	case "has":
+		return strings.Contains(strings.ToLower(value), strings.ToLower(term.value))
+	case "not":
+		return !term.equals(value)
+	default:
+		return term.equals(value)
+	}
+}
+
+// equals compares the attribute value, the projects include their
+// subprojects.
+func (term filterTerm) equals(value string) bool {
+	if term.attr == "project" && term.value != "" {
+		return value == term.value || strings.HasPrefix(value, term.value+".")
+	}
+	return value == term.value
+}
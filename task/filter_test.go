@@ -0,0 +1,59 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	tasks := make([]Task, 0, 3)
+	for _, raw := range []string{
+		`{"description":"Pay bills","due":"20241215T000000Z","entry":"20241101T000000Z","project":"Work","status":"pending","tags":["urgent"],"uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`,
+		`{"description":"Fix the roof","entry":"20241101T000000Z","project":"Work.Home","status":"pending","uuid":"2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b"}`,
+		`{"description":"Buy milk","due":"20250115T000000Z","end":"20250110T000000Z","entry":"20241101T000000Z","project":"Workshop","status":"completed","uuid":"0c1a7a3e-1b0b-4a4e-9d4d-2f0a6a5a8c01"}`,
+	} {
+		task, err := NewTask(raw)
+		assert.NoError(t, err)
+		tasks = append(tasks, task)
+	}
+
+	cases := []struct {
+		expr     string
+		expected []string
+	}{
+		{"", []string{"Pay bills", "Fix the roof", "Buy milk"}},
+		{"status:pending", []string{"Pay bills", "Fix the roof"}},
+		{"project:Work", []string{"Pay bills", "Fix the roof"}},
+		{"project.not:Work", []string{"Buy milk"}},
+		{"+urgent", []string{"Pay bills"}},
+		{"-urgent status:pending", []string{"Fix the roof"}},
+		{"status:pending project:Work +urgent due.before:2025-01-01", []string{"Pay bills"}},
+		{"due.after:2025-01-01", []string{"Buy milk"}},
+		{"due.before:20250101T000000Z", []string{"Pay bills"}},
+		{"description.has:MILK", []string{"Buy milk"}},
+		{"due:", []string{"Fix the roof"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			f, err := ParseFilter(c.expr)
+			assert.NoError(t, err)
+
+			var matched []string
+			for _, task := range tasks {
+				if f.Match(task) {
+					matched = append(matched, task.Get("description"))
+				}
+			}
+			assert.Equal(t, c.expected, matched)
+		})
+	}
+
+	for _, expr := range []string{"status", ":pending", "due.before:tomorrow", "+", "+two,tags"} {
+		t.Run("invalid "+expr, func(t *testing.T) {
+			_, err := ParseFilter(expr)
+			assert.Error(t, err)
+		})
+	}
+}
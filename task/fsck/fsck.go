@@ -0,0 +1,264 @@
+// Package fsck verifies the integrity of a gotas repository, optionally
+// repairing the problems that can be fixed without losing data.
+package fsck
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/szaffarano/gotas/config"
+	"github.com/szaffarano/gotas/task"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+const (
+	orgsFolder   = "orgs"
+	usersFolder  = "users"
+	groupsFolder = "groups"
+	configFile   = "config"
+	txFile       = "tx.data"
+	txFileTemp   = "tx.tmp.data"
+	blobPrefix   = "sha256:"
+)
+
+// Issue is a problem found in the repository.
+type Issue struct {
+	Path     string `json:"path"`
+	Line     int    `json:"line,omitempty"`
+	Problem  string `json:"problem"`
+	Repaired bool   `json:"repaired"`
+}
+
+func (i Issue) String() string {
+	location := i.Path
+	if i.Line > 0 {
+		location = fmt.Sprintf("%s:%d", i.Path, i.Line)
+	}
+	status := ""
+	if i.Repaired {
+		status = " (repaired)"
+	}
+	return fmt.Sprintf("%s: %s%s", location, i.Problem, status)
+}
+
+// Report is the result of a repository check.
+type Report struct {
+	Issues []Issue
+}
+
+// Clean returns true if every issue found was repaired.
+func (r Report) Clean() bool {
+	for _, i := range r.Issues {
+		if !i.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+type checker struct {
+	repair bool
+	report Report
+}
+
+// Check walks the repository located in dataDir.  If repair is set, the
+// problems that can be safely fixed are repaired, keeping a backup of every
+// modified transactions file.
+func Check(dataDir string, repair bool) (Report, error) {
+	c := checker{repair: repair}
+
+	if info, err := os.Stat(dataDir); err != nil {
+		return Report{}, fmt.Errorf("read dir info %v: %v", dataDir, err)
+	} else if !info.IsDir() {
+		return Report{}, fmt.Errorf("%v: directory expected", dataDir)
+	}
+
+	c.checkConfig(filepath.Join(dataDir, configFile), "")
+
+	roots, err := repo.LoadRoots(dataDir)
+	if err != nil {
+		c.issue(filepath.Join(dataDir, configFile), 0, err.Error(), false)
+		return c.report, nil
+	}
+
+	for _, root := range roots.All() {
+		orgsPath := filepath.Join(root, orgsFolder)
+		orgs, err := os.ReadDir(orgsPath)
+		if os.IsNotExist(err) && root != dataDir {
+			continue
+		} else if err != nil {
+			c.issue(orgsPath, 0, "missing organizations directory", false)
+			continue
+		}
+
+		for _, org := range orgs {
+			orgPath := filepath.Join(orgsPath, org.Name())
+			if !org.IsDir() {
+				c.issue(orgPath, 0, "unexpected file", false)
+				continue
+			} else if roots.Locate(org.Name()) != root {
+				c.issue(orgPath, 0, "stale copy of an organization moved to another data root", false)
+				continue
+			}
+			c.checkOrg(orgPath)
+		}
+	}
+
+	return c.report, nil
+}
+
+func (c *checker) issue(path string, line int, problem string, repaired bool) {
+	c.report.Issues = append(c.report.Issues, Issue{Path: path, Line: line, Problem: problem, Repaired: repaired})
+}
+
+func (c *checker) checkConfig(path, requiredKey string) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		c.issue(path, 0, fmt.Sprintf("invalid configuration: %v", err), false)
+		return
+	}
+
+	if requiredKey != "" && cfg.Get(requiredKey) == "" {
+		c.issue(path, 0, fmt.Sprintf("missing %q entry", requiredKey), false)
+	}
+}
+
+func (c *checker) checkOrg(orgPath string) {
+	usersPath := filepath.Join(orgPath, usersFolder)
+	users, err := os.ReadDir(usersPath)
+	if os.IsNotExist(err) {
+		repaired := c.repair && os.Mkdir(usersPath, 0775) == nil
+		c.issue(usersPath, 0, "missing users directory", repaired)
+		return
+	} else if err != nil {
+		c.issue(usersPath, 0, fmt.Sprintf("reading users: %v", err), false)
+		return
+	}
+
+	for _, user := range users {
+		userPath := filepath.Join(usersPath, user.Name())
+		if !user.IsDir() {
+			c.issue(userPath, 0, "unexpected file", false)
+			continue
+		}
+
+		if _, err := uuid.Parse(user.Name()); err != nil {
+			c.issue(userPath, 0, "user key is not a valid UUID", false)
+		}
+		c.checkConfig(filepath.Join(userPath, configFile), "user")
+		c.checkData(userPath)
+	}
+
+	groups, err := os.ReadDir(filepath.Join(orgPath, groupsFolder))
+	if err != nil {
+		return
+	}
+	for _, group := range groups {
+		if group.IsDir() {
+			c.checkData(filepath.Join(orgPath, groupsFolder, group.Name()))
+		}
+	}
+}
+
+// checkData verifies the transactions stored in dir.
+func (c *checker) checkData(dir string) {
+	// classified as the garbage collection does, a complete first append
+	// is the only copy of the user data
+	temps, _ := filepath.Glob(filepath.Join(dir, txFileTemp+"*"))
+	for _, tempPath := range temps {
+		artifact, found, err := repo.CollectArtifact(tempPath, !c.repair)
+		if err != nil {
+			c.issue(tempPath, 0, fmt.Sprintf("leftover temporary file: %v", err), false)
+		} else if found {
+			c.issue(tempPath, 0, fmt.Sprintf("leftover temporary file, %s to %s", artifact.Reason, artifact.Action), artifact.Done)
+		}
+	}
+
+	path := filepath.Join(dir, txFile)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		c.issue(path, 0, fmt.Sprintf("reading transactions: %v", err), false)
+		return
+	}
+
+	lines := strings.Split(string(content), "\n")
+	// a complete file ends with a new line, leaving an empty last element
+	truncated := lines[len(lines)-1] != ""
+	lines = lines[:len(lines)-1]
+
+	keys := make(map[string]int)
+	for i, line := range lines {
+		number := i + 1
+		if strings.HasPrefix(line, blobPrefix) {
+			blob, err := repo.ResolveBlob(filepath.Dir(filepath.Dir(dir)), line)
+			if err != nil {
+				c.issue(path, number, fmt.Sprintf("invalid deduplicated task: %v", err), false)
+			} else if _, err := task.NewTask(blob); err != nil {
+				c.issue(path, number, fmt.Sprintf("invalid task: %v", err), false)
+			}
+		} else if strings.HasPrefix(line, "{") || strings.HasPrefix(line, "[") {
+			if _, err := task.NewTask(line); err != nil {
+				c.issue(path, number, fmt.Sprintf("invalid task: %v", err), false)
+			}
+		} else if _, err := uuid.Parse(line); err == nil {
+			if first, ok := keys[line]; ok {
+				c.issue(path, number, fmt.Sprintf("sync key %s already used in line %d", line, first), false)
+			} else {
+				keys[line] = number
+			}
+		} else {
+			c.issue(path, number, "neither a task nor a sync key", false)
+		}
+	}
+
+	if truncated {
+		repaired := false
+		if c.repair {
+			if err := c.truncate(path, content); err != nil {
+				c.issue(path, len(lines)+1, fmt.Sprintf("repairing truncated line: %v", err), false)
+				return
+			}
+			repaired = true
+		}
+		c.issue(path, len(lines)+1, "truncated last line", repaired)
+	}
+}
+
+// truncate removes the incomplete last line, keeping a backup of the
+// original file.
+func (c *checker) truncate(path string, content []byte) error {
+	backup := fmt.Sprintf("%s.%s.bak", path, time.Now().UTC().Format("20060102T150405Z"))
+	if err := copyFile(path, backup); err != nil {
+		return fmt.Errorf("creating backup: %v", err)
+	}
+
+	end := bytes.LastIndexByte(content, '\n') + 1
+	return os.Truncate(path, int64(end))
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
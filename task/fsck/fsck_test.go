@@ -0,0 +1,176 @@
+package fsck
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	userKey  = "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7"
+	syncKey  = "b8a25aa7-fea9-4abf-a487-02eacd85bd58"
+	taskLine = `{"description":"Some task","entry":"20210930T115722Z","status":"pending","uuid":"8346181b-f613-4d54-9481-c66cb541d42f"}`
+)
+
+func TestCheck(t *testing.T) {
+	t.Run("clean repository", func(t *testing.T) {
+		dataDir := newRepo(t, taskLine+"\n"+syncKey+"\n")
+
+		report, err := Check(dataDir, false)
+		assert.Nil(t, err)
+		assert.Empty(t, report.Issues)
+		assert.True(t, report.Clean())
+	})
+
+	t.Run("fails with non existent repository", func(t *testing.T) {
+		_, err := Check(filepath.Join(t.TempDir(), "fake"), false)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("detects invalid lines and duplicated keys", func(t *testing.T) {
+		dataDir := newRepo(t, strings.Join([]string{
+			taskLine,
+			syncKey,
+			"garbage",
+			`{"description":`,
+			syncKey,
+		}, "\n")+"\n")
+
+		report, err := Check(dataDir, true)
+		assert.Nil(t, err)
+		assert.False(t, report.Clean())
+		assert.Equal(t, []int{3, 4, 5}, lines(report))
+		assert.Contains(t, report.Issues[2].Problem, "already used in line 2")
+	})
+
+	t.Run("checks the deduplicated tasks", func(t *testing.T) {
+		sum := sha256.Sum256([]byte(taskLine))
+		hash := hex.EncodeToString(sum[:])
+		missing := strings.Repeat("0", len(hash))
+		dataDir := newRepo(t, strings.Join([]string{
+			"sha256:" + hash,
+			syncKey,
+			"sha256:" + missing,
+		}, "\n")+"\n")
+		write(t, filepath.Join(dataDir, "orgs", "Public", "blobs", hash[:2], hash), taskLine)
+
+		report, err := Check(dataDir, false)
+		assert.Nil(t, err)
+		assert.Equal(t, []int{3}, lines(report))
+		assert.Contains(t, report.Issues[0].Problem, "invalid deduplicated task")
+	})
+
+	t.Run("detects structure problems", func(t *testing.T) {
+		dataDir := newRepo(t, "")
+		write(t, filepath.Join(dataDir, "orgs", "random-file"), "")
+		write(t, filepath.Join(dataDir, "orgs", "Public", "users", "not-a-uuid", "config"), "user=john\n")
+		write(t, filepath.Join(dataDir, "orgs", "Public", "users", userKey, "config"), "invalid\n")
+
+		report, err := Check(dataDir, false)
+		assert.Nil(t, err)
+		assert.Len(t, report.Issues, 3)
+		assert.False(t, report.Clean())
+	})
+
+	t.Run("repairs truncated last line", func(t *testing.T) {
+		dataDir := newRepo(t, taskLine+"\n"+syncKey+"\n"+`{"descr`)
+		txPath := filepath.Join(dataDir, "orgs", "Public", "users", userKey, txFile)
+
+		report, err := Check(dataDir, false)
+		assert.Nil(t, err)
+		if assert.Len(t, report.Issues, 1) {
+			assert.Equal(t, 3, report.Issues[0].Line)
+			assert.False(t, report.Issues[0].Repaired)
+		}
+
+		report, err = Check(dataDir, true)
+		assert.Nil(t, err)
+		assert.True(t, report.Clean())
+		assert.Len(t, report.Issues, 1)
+
+		data, err := os.ReadFile(txPath)
+		assert.Nil(t, err)
+		assert.Equal(t, taskLine+"\n"+syncKey+"\n", string(data))
+
+		backups, err := filepath.Glob(txPath + ".*.bak")
+		assert.Nil(t, err)
+		assert.Len(t, backups, 1)
+
+		report, err = Check(dataDir, false)
+		assert.Nil(t, err)
+		assert.Empty(t, report.Issues)
+	})
+
+	t.Run("repairs leftovers and missing folders", func(t *testing.T) {
+		dataDir := newRepo(t, "")
+		tmpPath := filepath.Join(dataDir, "orgs", "Public", "users", userKey, txFileTemp)
+		write(t, tmpPath, "partial")
+		write(t, filepath.Join(dataDir, "orgs", "Private", "config"), "")
+
+		report, err := Check(dataDir, true)
+		assert.Nil(t, err)
+		assert.Len(t, report.Issues, 2)
+		assert.True(t, report.Clean())
+
+		assert.NoFileExists(t, tmpPath)
+		assert.DirExists(t, filepath.Join(dataDir, "orgs", "Private", "users"))
+	})
+
+	t.Run("recovers a complete first append", func(t *testing.T) {
+		dataDir := newRepo(t, "")
+		userDir := filepath.Join(dataDir, "orgs", "Public", "users", userKey)
+		tmpPath := filepath.Join(userDir, txFileTemp+".1b4e28ba-2fa1-11d2-883f-0016d3cca427")
+		write(t, tmpPath, taskLine+"\n"+syncKey+"\n")
+
+		report, err := Check(dataDir, false)
+		assert.Nil(t, err)
+		if assert.Len(t, report.Issues, 1) {
+			assert.Contains(t, report.Issues[0].Problem, "recover")
+		}
+		assert.FileExists(t, tmpPath)
+
+		report, err = Check(dataDir, true)
+		assert.Nil(t, err)
+		assert.True(t, report.Clean())
+		assert.NoFileExists(t, tmpPath)
+
+		data, err := os.ReadFile(filepath.Join(userDir, txFile))
+		assert.Nil(t, err)
+		assert.Equal(t, taskLine+"\n"+syncKey+"\n", string(data))
+	})
+}
+
+func newRepo(t *testing.T, data string) string {
+	t.Helper()
+
+	dataDir := t.TempDir()
+	userDir := filepath.Join(dataDir, "orgs", "Public", "users", userKey)
+
+	write(t, filepath.Join(dataDir, "config"), "root="+dataDir+"\n")
+	write(t, filepath.Join(userDir, "config"), "user=noeh\n")
+	if data != "" {
+		write(t, filepath.Join(userDir, txFile), data)
+	}
+
+	return dataDir
+}
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0664))
+}
+
+func lines(report Report) []int {
+	var lines []int
+	for _, i := range report.Issues {
+		lines = append(lines, i.Line)
+	}
+	return lines
+}
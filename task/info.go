@@ -0,0 +1,100 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// Headers of the statistics responses, see ServerInfo.
+const (
+	versionHeader = "version"
+	commitHeader  = "commit"
+	builtHeader   = "built"
+	storageHeader = "storage"
+)
+
+// BuildInfo identifies a gotas build.
+type BuildInfo struct {
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Date    string `json:"date,omitempty"`
+}
+
+// Build is the running build, set by the main package and reported to the
+// statistics requests.
+var Build BuildInfo
+
+// ServerInfo is what a server reports about itself to a statistics request.
+type ServerInfo struct {
+	BuildInfo
+	// Storage is the storage backend, see Options.Storage.
+	Storage string `json:"storage,omitempty"`
+	// Protocols are the protocol versions supported.
+	Protocols []string `json:"protocols"`
+}
+
+// StatisticsRequest builds the request of the server information, only
+// answered to the server admins.
+func StatisticsRequest(creds Credentials, client string) Message {
+	return Message{
+		Header: map[string]string{
+			"client":   client,
+			"protocol": ProtocolVersion,
+			"type":     "statistics",
+			"org":      creds.Org,
+			"user":     creds.User,
+			"key":      creds.Key,
+		},
+	}
+}
+
+// ParseServerInfo reads the server information from a statistics response.
+func ParseServerInfo(resp Message) (ServerInfo, error) {
+	if code := resp.Header["code"]; code != "200" {
+		return ServerInfo{}, fmt.Errorf("statistics rejected: %s %s", code, resp.Header["status"])
+	}
+
+	info := ServerInfo{
+		BuildInfo: BuildInfo{
+			Version: resp.Header[versionHeader],
+			Commit:  resp.Header[commitHeader],
+			Date:    resp.Header[builtHeader],
+		},
+		Storage:   resp.Header[storageHeader],
+		Protocols: make([]string, 0),
+	}
+	for _, protocol := range strings.Split(resp.Header[ProtocolsHeader], ",") {
+		if protocol = strings.TrimSpace(protocol); protocol != "" {
+			info.Protocols = append(info.Protocols, protocol)
+		}
+	}
+	return info, nil
+}
+
+// statistics replies the server information to the server admins.
+func statistics(user auth.User, opts Options) Message {
+	if user.Role != auth.RoleServerAdmin {
+		return catalogResponse("430", MsgStatisticsDenied)
+	}
+
+	b := NewResponse(200).WithHeader(ProtocolsHeader, strings.Join(SupportedProtocols(), ","))
+	for name, value := range map[string]string{
+		versionHeader: Build.Version,
+		commitHeader:  Build.Commit,
+		builtHeader:   Build.Date,
+		storageHeader: opts.Storage,
+	} {
+		// the unknown values aren't sent
+		if value != "" {
+			b.WithHeader(name, value)
+		}
+	}
+
+	resp, err := b.Build()
+	if err != nil {
+		return internalErrorResponse(err)
+	}
+	return resp
+}
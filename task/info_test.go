@@ -0,0 +1,41 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestStatistics(t *testing.T) {
+	previous := Build
+	defer func() { Build = previous }()
+	Build = BuildInfo{Version: "1.2.3", Commit: "abc123"}
+
+	req := StatisticsRequest(Credentials{Org: "Public", User: "root", Key: "secret"}, "test 1.0")
+	opts := DefaultOptions()
+	opts.Storage = "filesystem"
+
+	t.Run("server admin", func(t *testing.T) {
+		resp := processMessage(req, auth.User{Role: auth.RoleServerAdmin}, nil, opts)
+		assert.Equal(t, "200", resp.Header["code"])
+		_, sent := resp.Header[builtHeader]
+		assert.False(t, sent)
+
+		info, err := ParseServerInfo(resp)
+		assert.NoError(t, err)
+		assert.Equal(t, ServerInfo{
+			BuildInfo: BuildInfo{Version: "1.2.3", Commit: "abc123"},
+			Storage:   "filesystem",
+			Protocols: SupportedProtocols(),
+		}, info)
+	})
+
+	t.Run("regular user", func(t *testing.T) {
+		resp := processMessage(req, auth.User{Role: auth.RoleUser}, nil, opts)
+		assert.Equal(t, "430", resp.Header["code"])
+
+		_, err := ParseServerInfo(resp)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,245 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	gosync "sync"
+	"time"
+	"unicode/utf8"
+)
+
+// composeBuffers keeps the buffers used by ComposeJSON to avoid allocating a
+// new one for every composed task.
+var composeBuffers = gosync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+const hex = "0123456789abcdef"
+
+// jsonField is a raw JSON object member.
+type jsonField struct {
+	name  string
+	value json.RawMessage
+}
+
+// objectFields splits a raw JSON object into its members keeping the original
+// order.  It returns an error if raw is not a valid JSON object.
+func objectFields(raw []byte) ([]jsonField, error) {
+	if !json.Valid(raw) {
+		var value interface{}
+		return nil, json.Unmarshal(raw, &value)
+	}
+	return splitObject(raw)
+}
+
+// splitObject is objectFields for an already validated raw value.
+func splitObject(raw []byte) ([]jsonField, error) {
+	idx := skipSpaces(raw, 0)
+	if idx >= len(raw) || raw[idx] != '{' {
+		return nil, fmt.Errorf("json object expected: %s", raw)
+	}
+
+	fields := make([]jsonField, 0, 16)
+	idx = skipSpaces(raw, idx+1)
+	for idx < len(raw) && raw[idx] != '}' {
+		end := skipValue(raw, idx)
+		name := rawString(raw[idx:end])
+
+		idx = skipSpaces(raw, end)
+		idx = skipSpaces(raw, idx+1) // skip ':'
+		end = skipValue(raw, idx)
+		fields = append(fields, jsonField{name, raw[idx:end]})
+
+		idx = skipSpaces(raw, end)
+		if raw[idx] == ',' {
+			idx = skipSpaces(raw, idx+1)
+		}
+	}
+
+	return fields, nil
+}
+
+// splitArray splits an already validated raw JSON array into its items.
+func splitArray(raw []byte) ([]json.RawMessage, error) {
+	idx := skipSpaces(raw, 0)
+	if idx >= len(raw) || raw[idx] != '[' {
+		return nil, fmt.Errorf("json array expected: %s", raw)
+	}
+
+	items := make([]json.RawMessage, 0, 4)
+	idx = skipSpaces(raw, idx+1)
+	for idx < len(raw) && raw[idx] != ']' {
+		end := skipValue(raw, idx)
+		items = append(items, raw[idx:end])
+
+		idx = skipSpaces(raw, end)
+		if raw[idx] == ',' {
+			idx = skipSpaces(raw, idx+1)
+		}
+	}
+
+	return items, nil
+}
+
+// skipValue returns the position right after the JSON value starting at idx.
+// The input must have been already validated.
+func skipValue(raw []byte, idx int) int {
+	switch raw[idx] {
+	case '"':
+		for idx++; idx < len(raw); idx++ {
+			if raw[idx] == '\\' {
+				idx++
+			} else if raw[idx] == '"' {
+				return idx + 1
+			}
+		}
+		return idx
+	case '{', '[':
+		depth := 0
+		for idx < len(raw) {
+			switch raw[idx] {
+			case '"':
+				idx = skipValue(raw, idx)
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return idx + 1
+				}
+			}
+			idx++
+		}
+		return idx
+	default:
+		for idx < len(raw) {
+			switch raw[idx] {
+			case ',', '}', ']', ' ', '\t', '\n', '\r':
+				return idx
+			}
+			idx++
+		}
+		return idx
+	}
+}
+
+func skipSpaces(raw []byte, idx int) int {
+	for idx < len(raw) {
+		switch raw[idx] {
+		case ' ', '\t', '\n', '\r':
+			idx++
+		default:
+			return idx
+		}
+	}
+	return idx
+}
+
+// rawString converts a raw JSON value to the same string fmt.Sprintf("%v")
+// would produce for the value decoded by json.Unmarshal into an interface{}.
+// Strings and numbers, by far the most common values, are converted without
+// going through the generic decoder.
+func rawString(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return fmt.Sprintf("%v", nil)
+	}
+
+	switch raw[0] {
+	case '"':
+		if bytes.IndexByte(raw, '\\') == -1 && utf8.Valid(raw) {
+			return string(raw[1 : len(raw)-1])
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err == nil {
+			return value
+		}
+	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		if value, err := strconv.ParseFloat(string(raw), 64); err == nil {
+			return strconv.FormatFloat(value, 'g', -1, 64)
+		}
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return string(raw)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// rawDate parses a raw JSON date with parseDate and returns it as an epoch
+// string.
+func rawDate(raw json.RawMessage, parseDate func(string) (time.Time, error)) (string, error) {
+	ts, err := parseDate(rawDateString(raw))
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(ts.UTC().Unix(), 10), nil
+}
+
+// rawDateString returns the string of a raw JSON date.  Unlike rawString, the
+// numbers are kept as sent, so the epoch dates aren't turned into floats.
+func rawDateString(raw json.RawMessage) string {
+	if len(raw) > 0 && raw[0] != '"' {
+		return string(raw)
+	}
+	return rawString(raw)
+}
+
+// writeJSONString writes s as a JSON string, escaping it the same way
+// encoding/json does with HTML escaping enabled.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			buf.WriteString(s[start:i])
+			switch b {
+			case '\\', '"':
+				buf.WriteByte('\\')
+				buf.WriteByte(b)
+			case '\n':
+				buf.WriteString(`\n`)
+			case '\r':
+				buf.WriteString(`\r`)
+			case '\t':
+				buf.WriteString(`\t`)
+			default:
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[b>>4])
+				buf.WriteByte(hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf.WriteString(s[start:i])
+			buf.WriteString(`\ufffd`)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 are valid JSON but break JavaScript parsers.
+		if r == '\u2028' || r == '\u2029' {
+			buf.WriteString(s[start:i])
+			buf.WriteString(`\u202`)
+			buf.WriteByte(hex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf.WriteString(s[start:])
+	buf.WriteByte('"')
+}
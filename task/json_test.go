@@ -0,0 +1,84 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawString(t *testing.T) {
+	cases := []struct {
+		title    string
+		raw      string
+		expected string
+	}{
+		{"plain string", `"value"`, "value"},
+		{"escaped string", `"a \"quoted\" á value"`, `a "quoted" á value`},
+		{"integer", `1633003050`, "1.63300305e+09"},
+		{"small integer", `1`, "1"},
+		{"decimal", `-3.5`, "-3.5"},
+		{"boolean", `true`, "true"},
+		{"null", `null`, "<nil>"},
+		{"missing", ``, "<nil>"},
+		{"array", `["a", "b"]`, "[a b]"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			assert.Equal(t, c.expected, rawString(json.RawMessage(c.raw)))
+		})
+	}
+}
+
+func TestWriteJSONString(t *testing.T) {
+	cases := []string{
+		"",
+		"simple",
+		`with "quotes" and \ backslashes`,
+		"new\nlines\rand\ttabs",
+		"html <b>&</b>",
+		"unicode 日本語 ñ",
+		"control \x01 char",
+		"separators    ",
+		"invalid \xbd\xb2 utf8",
+	}
+
+	for _, c := range cases {
+		t.Run(c, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			writeJSONString(buf, c)
+
+			// compare decoded values, escaping details vary among Go versions
+			expected, err := json.Marshal(c)
+			assert.Nil(t, err)
+			var expectedValue, actualValue string
+			assert.Nil(t, json.Unmarshal(expected, &expectedValue))
+			assert.Nil(t, json.Unmarshal(buf.Bytes(), &actualValue))
+			assert.Equal(t, expectedValue, actualValue)
+		})
+	}
+}
+
+func TestObjectFields(t *testing.T) {
+	t.Run("valid object keeps order", func(t *testing.T) {
+		fields, err := objectFields([]byte(` { "b" : [1, {"x": "]"}], "a":"}", "cA": null } `))
+		assert.Nil(t, err)
+		assert.Equal(t, []jsonField{
+			{"b", json.RawMessage(`[1, {"x": "]"}]`)},
+			{"a", json.RawMessage(`"}"`)},
+			{"cA", json.RawMessage(`null`)},
+		}, fields)
+	})
+
+	t.Run("invalid object fails", func(t *testing.T) {
+		_, err := objectFields([]byte(`{"a": }`))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("not an object fails", func(t *testing.T) {
+		_, err := objectFields([]byte(`["a"]`))
+		assert.NotNil(t, err)
+	})
+}
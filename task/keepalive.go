@@ -0,0 +1,71 @@
+package task
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// KeepAliveHeader set to "1" in a request asks the server to keep the
+	// connection open for more requests, saving the TLS handshakes.  The
+	// server grants it replying the idle timeout in seconds in the same
+	// header, the connection is closed otherwise.
+	KeepAliveHeader = "keep-alive"
+
+	// KeepAliveMaxRequests is the maximum number of requests sent through a
+	// connection kept alive, so a client can't hold a worker forever.
+	KeepAliveMaxRequests = 100
+)
+
+// connection is the state of a client connection shared by its requests.
+type connection struct {
+	// org and user sent the first request, the only ones allowed to send the
+	// next requests.
+	org, user string
+	requests  int
+}
+
+// readDeadliner is a connection supporting read timeouts, needed to close
+// the idle connections kept alive.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// checkUser verifies that the authenticated request comes from the user of
+// the previous ones.
+func (c *connection) checkUser(msg Message) error {
+	org, user := msg.Header["org"], msg.Header["user"]
+	if c.requests > 1 && (org != c.org || user != c.user) {
+		return fmt.Errorf("interleaved users on one connection, %q of %q expected", c.user, c.org)
+	}
+	c.org, c.user = org, user
+	return nil
+}
+
+// keepAlive returns true if the connection is kept alive after replying the
+// response.  Only the successful requests asking for it are, and only if
+// enabled and the connection supports timeouts.
+func (c *connection) keepAlive(client io.ReadWriter, msg, resp Message, opts Options) bool {
+	if opts.KeepAlive <= 0 || msg.Header[KeepAliveHeader] != "1" ||
+		!strings.HasPrefix(resp.Header["code"], "2") || c.requests >= KeepAliveMaxRequests {
+		return false
+	}
+	_, ok := client.(readDeadliner)
+	return ok
+}
+
+// waitNext sets the idle timeout waiting for the next request, returning
+// false if it can't.
+func (c *connection) waitNext(client io.ReadWriter, idle time.Duration) bool {
+	conn, ok := client.(readDeadliner)
+	if !ok {
+		return false
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(idle)); err != nil {
+		log.Debugf("Error setting the keep-alive timeout: %v", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package task
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepAlive(t *testing.T) {
+	request := func(t *testing.T, user string, keepAlive bool) Message {
+		msg := parseMsg(t, loadPayload(t, "msg-sent-init"))
+		msg.Header["user"] = user
+		if keepAlive {
+			msg.Header[KeepAliveHeader] = "1"
+		}
+		return msg
+	}
+
+	serve := func(opts Options) (net.Conn, chan struct{}) {
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			ra := &mockReadAppender{reader: strings.NewReader(""), writer: new(strings.Builder)}
+			Process(server, &mockAuth{}, ra, opts)
+		}()
+		return client, done
+	}
+
+	send := func(t *testing.T, conn net.Conn, msg Message) Message {
+		t.Helper()
+
+		_, err := msg.WriteTo(conn)
+		assert.NoError(t, err)
+		resp, err := ReadMessage(conn, RequestLimitInBytes)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	closed := func(t *testing.T, conn net.Conn, done chan struct{}) {
+		t.Helper()
+
+		_, err := conn.Read(make([]byte, 1))
+		assert.Equal(t, io.EOF, err)
+		<-done
+	}
+
+	opts := DefaultOptions()
+	opts.KeepAlive = 30 * time.Second
+
+	t.Run("many requests on one connection", func(t *testing.T) {
+		conn, done := serve(opts)
+		defer conn.Close()
+
+		for i := 0; i < 3; i++ {
+			resp := send(t, conn, request(t, "noeh", true))
+			assert.Equal(t, "200", resp.Header["code"])
+			assert.Equal(t, "30", resp.Header[KeepAliveHeader])
+		}
+
+		resp := send(t, conn, request(t, "noeh", false))
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.Empty(t, resp.Header[KeepAliveHeader])
+		closed(t, conn, done)
+	})
+
+	t.Run("interleaved users rejected", func(t *testing.T) {
+		conn, done := serve(opts)
+		defer conn.Close()
+
+		resp := send(t, conn, request(t, "noeh", true))
+		assert.Equal(t, "200", resp.Header["code"])
+
+		resp = send(t, conn, request(t, "other", true))
+		assert.Equal(t, "400", resp.Header["code"])
+		assert.Contains(t, resp.Header["status"], "interleaved users")
+		closed(t, conn, done)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		conn, done := serve(DefaultOptions())
+		defer conn.Close()
+
+		resp := send(t, conn, request(t, "noeh", true))
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.Empty(t, resp.Header[KeepAliveHeader])
+		closed(t, conn, done)
+	})
+
+	t.Run("idle connection closed", func(t *testing.T) {
+		idle := opts
+		idle.KeepAlive = 50 * time.Millisecond
+		conn, done := serve(idle)
+		defer conn.Close()
+
+		resp := send(t, conn, request(t, "noeh", true))
+		assert.Equal(t, "0", resp.Header[KeepAliveHeader])
+		closed(t, conn, done)
+	})
+}
@@ -0,0 +1,95 @@
+package task
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	gosync "sync"
+
+	"github.com/google/uuid"
+)
+
+// Supported "sync.keys" values, besides "seed:<number>".
+const (
+	RandomKeys     = "random"
+	SequentialKeys = "sequential"
+
+	seedKeysPrefix = "seed:"
+)
+
+// KeyGenerator generates the sync keys sent to the clients.  The keys must be
+// unique in the user transactions, the default ones are random UUIDs.
+type KeyGenerator interface {
+	NewKey() string
+}
+
+type randomKeys struct{}
+
+func (randomKeys) NewKey() string {
+	return uuid.New().String()
+}
+
+// sequentialKeys generates the keys 00000000-0000-0000-0000-000000000001,
+// 00000000-0000-0000-0000-000000000002 and so on.
+type sequentialKeys struct {
+	mu   gosync.Mutex
+	next uint64
+}
+
+// NewSequentialKeys returns a generator of consecutive keys, starting at 1.
+// Only meant for tests, the keys repeat after a restart.
+func NewSequentialKeys() KeyGenerator {
+	return &sequentialKeys{next: 1}
+}
+
+func (g *sequentialKeys) NewKey() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := fmt.Sprintf("00000000-0000-0000-0000-%012x", g.next)
+	g.next++
+	return key
+}
+
+// seededKeys generates random UUIDs from a seeded source.
+type seededKeys struct {
+	mu     gosync.Mutex
+	source *rand.Rand
+}
+
+// NewSeededKeys returns a generator of random looking keys, always the same
+// sequence for a given seed.  Only meant for tests and development.
+func NewSeededKeys(seed int64) KeyGenerator {
+	return &seededKeys{source: rand.New(rand.NewSource(seed))}
+}
+
+func (g *seededKeys) NewKey() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key, err := uuid.NewRandomFromReader(g.source)
+	if err != nil {
+		// reading from a math/rand source never fails
+		panic(err)
+	}
+	return key.String()
+}
+
+// ParseKeyGenerator returns the key generator configured in "sync.keys":
+// "random" (the default), "sequential" or "seed:<number>".
+func ParseKeyGenerator(value string) (KeyGenerator, error) {
+	switch {
+	case value == "" || value == RandomKeys:
+		return randomKeys{}, nil
+	case value == SequentialKeys:
+		return NewSequentialKeys(), nil
+	case strings.HasPrefix(value, seedKeysPrefix):
+		seed, err := strconv.ParseInt(strings.TrimPrefix(value, seedKeysPrefix), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid seed in %q", value)
+		}
+		return NewSeededKeys(seed), nil
+	}
+	return nil, fmt.Errorf("either %q, %q or \"%s<number>\" expected, got %q", RandomKeys, SequentialKeys, seedKeysPrefix, value)
+}
@@ -0,0 +1,56 @@
+package task
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyGenerators(t *testing.T) {
+	t.Run("sequential", func(t *testing.T) {
+		keys := NewSequentialKeys()
+		assert.Equal(t, "00000000-0000-0000-0000-000000000001", keys.NewKey())
+		assert.Equal(t, "00000000-0000-0000-0000-000000000002", keys.NewKey())
+	})
+
+	t.Run("seeded", func(t *testing.T) {
+		first, second := NewSeededKeys(42), NewSeededKeys(42)
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, first.NewKey(), second.NewKey())
+		}
+		assert.NotEqual(t, NewSeededKeys(1).NewKey(), NewSeededKeys(2).NewKey())
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		for _, value := range []string{"", RandomKeys, SequentialKeys, "seed:42"} {
+			keys, err := ParseKeyGenerator(value)
+			assert.Nil(t, err, value)
+			assert.Len(t, keys.NewKey(), 36, value)
+		}
+		for _, value := range []string{"uuid", "seed:", "seed:abc"} {
+			_, err := ParseKeyGenerator(value)
+			assert.NotNil(t, err, value)
+		}
+	})
+
+	t.Run("sync", func(t *testing.T) {
+		client := &mockClient{
+			reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+			writer: new(strings.Builder),
+		}
+		ra := &mockReadAppender{
+			reader: strings.NewReader(string(loadFile(t, "tx-init-before.data"))),
+			writer: new(strings.Builder),
+		}
+		opts := DefaultOptions()
+		opts.Keys = NewSequentialKeys()
+
+		Process(client, &mockAuth{}, ra, opts)
+
+		resp, err := NewMessage(client.writer.String()[4:])
+		assert.Nil(t, err)
+		assert.Equal(t, "00000000-0000-0000-0000-000000000001", SyncKey(resp))
+		assert.True(t, strings.HasSuffix(ra.writer.String(), "00000000-0000-0000-0000-000000000001\n"))
+	})
+}
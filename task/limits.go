@@ -0,0 +1,72 @@
+package task
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// DefaultLimitWarn is the percentage of a limit above which the users are
+// warned, used when "limit.warn" is not configured.
+const DefaultLimitWarn = 80
+
+// warningSeparator separates the warnings sent in the "message" header,
+// headers can't span several lines.
+const warningSeparator = " | "
+
+// overSoftLimit returns true if used is above the percent of limit, and limit
+// wasn't reached yet.  A zero limit or percent disables the check.
+func overSoftLimit(used, limit, percent int) bool {
+	if limit <= 0 || percent <= 0 {
+		return false
+	}
+	return used <= limit && used*100 > limit*percent
+}
+
+// addWarning adds a warning to the response "message" header, which the
+// clients show after a sync.
+func addWarning(resp *Message, warning string) {
+	if resp.Header == nil {
+		resp.Header = make(map[string]string)
+	}
+	if current := resp.Header["message"]; current != "" {
+		warning = current + warningSeparator + warning
+	}
+	resp.Header["message"] = warning
+}
+
+// dataSize returns the size in bytes of the stored transactions.
+func dataSize(data []string) int {
+	size := 0
+	for _, line := range data {
+		size += len(line) + 1
+	}
+	return size
+}
+
+// quota returns the quota of the user, the one of its organization settings
+// if set or the server one otherwise.
+func (o Options) quota(user auth.User) int {
+	if user.Org == nil || user.Org.Settings[QuotaSize] == "" {
+		return o.Quota
+	}
+
+	quota, err := strconv.Atoi(user.Org.Settings[QuotaSize])
+	if err != nil || quota < 0 {
+		log.Warnf("Ignoring the invalid %q of organization %q: %q", QuotaSize, user.Org.Name, user.Org.Settings[QuotaSize])
+		return o.Quota
+	}
+	return quota
+}
+
+// quotaResponse rejects a sync exceeding the user quota.
+func quotaResponse(size, quota int) Message {
+	return catalogResponse("430", MsgQuotaExceeded, size, quota)
+}
+
+// limitWarning describes how much of a limit is used.  It avoids ": ", which
+// some clients take as a header separator.
+func limitWarning(what string, used, limit int) string {
+	return fmt.Sprintf("Warning, %s is at %d%% of the limit (%d of %d bytes)", what, used*100/limit, used, limit)
+}
@@ -0,0 +1,127 @@
+package task
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestOverSoftLimit(t *testing.T) {
+	cases := []struct {
+		name    string
+		used    int
+		limit   int
+		percent int
+		over    bool
+	}{
+		{"below", 50, 100, 80, false},
+		{"at the threshold", 80, 100, 80, false},
+		{"above the threshold", 81, 100, 80, true},
+		{"at the limit", 100, 100, 80, true},
+		{"above the limit", 101, 100, 80, false},
+		{"no limit", 81, 0, 80, false},
+		{"warnings disabled", 81, 100, 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.over, overSoftLimit(c.used, c.limit, c.percent))
+		})
+	}
+}
+
+func TestLimitWarnings(t *testing.T) {
+	user := auth.User{Name: "noeh", Org: &auth.Organization{Name: "Public"}}
+	txBefore := string(loadFile(t, "tx-case01-before.data"))
+
+	syncWith := func(t *testing.T, opts Options) (Message, *mockReadAppender) {
+		t.Helper()
+		msg, err := NewMessage(string(loadFile(t, "msg-sent-case01")))
+		assert.Nil(t, err)
+
+		ra := &mockReadAppender{
+			reader: strings.NewReader(txBefore),
+			writer: new(strings.Builder),
+		}
+		return sync(msg, user, ra, opts), ra
+	}
+
+	t.Run("no warning below the soft limit", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Quota = len(txBefore) * 10
+
+		resp, _ := syncWith(t, opts)
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.Equal(t, "", resp.Header["message"])
+	})
+
+	// the size of the data after the sync
+	_, ra := syncWith(t, DefaultOptions())
+	used := dataSize(strings.Split(strings.TrimSuffix(txBefore, "\n"), "\n")) + len(ra.writer.String())
+
+	t.Run("warning above the soft limit", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Quota = used
+
+		resp, ra := syncWith(t, opts)
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.Contains(t, resp.Header["message"], "your data")
+		assert.NotEqual(t, "", ra.writer.String())
+	})
+
+	t.Run("rejected above the quota", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Quota = used - 1
+
+		resp, ra := syncWith(t, opts)
+		assert.Equal(t, "430", resp.Header["code"])
+		assert.Equal(t, "", ra.writer.String())
+	})
+
+	t.Run("request size warning", func(t *testing.T) {
+		payload := loadPayload(t, "msg-sent-case01")
+		client := &mockClient{
+			reader: strings.NewReader(payload),
+			writer: new(strings.Builder),
+		}
+		ra := &mockReadAppender{
+			reader: strings.NewReader(txBefore),
+			writer: new(strings.Builder),
+		}
+
+		opts := DefaultOptions()
+		opts.RequestLimit = len(payload) + 1
+		opts.Message = "Welcome"
+		Process(client, &mockAuth{}, ra, opts)
+
+		resp := parseMsg(t, client.writer.String())
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.True(t, strings.HasPrefix(resp.Header["message"], "Welcome"+warningSeparator))
+		assert.Contains(t, resp.Header["message"], "the request size")
+	})
+}
+
+func TestOrgQuota(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Quota = 100
+
+	cases := []struct {
+		name     string
+		org      *auth.Organization
+		expected int
+	}{
+		{"no organization", nil, 100},
+		{"no organization quota", &auth.Organization{Name: "Public"}, 100},
+		{"organization quota", &auth.Organization{Name: "Public", Settings: map[string]string{QuotaSize: "50"}}, 50},
+		{"organization without quota", &auth.Organization{Name: "Public", Settings: map[string]string{QuotaSize: "0"}}, 0},
+		{"invalid organization quota", &auth.Organization{Name: "Public", Settings: map[string]string{QuotaSize: "lots"}}, 100},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, opts.quota(auth.User{Name: "noeh", Org: c.org}))
+		})
+	}
+}
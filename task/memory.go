@@ -0,0 +1,134 @@
+package task
+
+import (
+	"errors"
+	"strconv"
+	gosync "sync"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// memoryOverhead approximates the memory held by the requests from the
+// size of their data: the raw lines, their copies and the parsed tasks.
+const memoryOverhead = 3
+
+// errMemoryBudget rejects a request that doesn't fit in the memory budget.
+var errMemoryBudget = errors.New("memory budget exceeded")
+
+// MemoryBudget bounds the approximate memory held by the requests in flight,
+// so many users with huge histories syncing at once don't get the server
+// killed.  A request is always accepted when no other one holds memory, so
+// the users whose history alone exceeds the budget can still sync.
+type MemoryBudget struct {
+	limit int64
+
+	mu       gosync.Mutex
+	inUse    int64
+	peak     int64
+	rejected uint64
+}
+
+// MemoryStats are the memory budget usage, in bytes.
+type MemoryStats struct {
+	Budget   int64  `json:"budget"`
+	InUse    int64  `json:"in_use"`
+	Peak     int64  `json:"peak"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// NewMemoryBudget creates a budget of limit bytes.
+func NewMemoryBudget(limit int) *MemoryBudget {
+	return &MemoryBudget{limit: int64(limit)}
+}
+
+// reserve accounts n more bytes, false if they don't fit.
+func (b *MemoryBudget) reserve(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.inUse > 0 && b.inUse+n > b.limit {
+		b.rejected++
+		return false
+	}
+	b.inUse += n
+	if b.inUse > b.peak {
+		b.peak = b.inUse
+	}
+	return true
+}
+
+func (b *MemoryBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.inUse -= n
+}
+
+// Stats returns the current usage.
+func (b *MemoryBudget) Stats() MemoryStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return MemoryStats{Budget: b.limit, InUse: b.inUse, Peak: b.peak, Rejected: b.rejected}
+}
+
+// memoryAccount is the memory reserved by a request, released at once when
+// it's replied.  Accounts of a nil budget reserve nothing.
+type memoryAccount struct {
+	budget   *MemoryBudget
+	reserved int64
+}
+
+func (a *memoryAccount) reserve(bytes int) bool {
+	if a.budget == nil {
+		return true
+	}
+	n := int64(bytes) * memoryOverhead
+	if !a.budget.reserve(n) {
+		return false
+	}
+	a.reserved += n
+	return true
+}
+
+func (a *memoryAccount) release() {
+	if a.budget != nil && a.reserved > 0 {
+		a.budget.release(a.reserved)
+		a.reserved = 0
+	}
+}
+
+// accountedReadAppender reserves the memory of the transactions read,
+// failing with errMemoryBudget if they don't fit.
+type accountedReadAppender struct {
+	ReadAppender
+	account *memoryAccount
+}
+
+func (ra accountedReadAppender) Read(user auth.User) ([]string, error) {
+	data, err := ra.ReadAppender.Read(user)
+	if err != nil {
+		return data, err
+	}
+
+	size := 0
+	for _, line := range data {
+		size += len(line)
+	}
+	if !ra.account.reserve(size) {
+		return nil, errMemoryBudget
+	}
+	return data, nil
+}
+
+// memoryBudgetResponse asks the client to retry when the server has room.
+func memoryBudgetResponse() Message {
+	resp, err := NewResponse(420).
+		withCatalogStatus(MsgMemoryBudget).
+		WithHeader(RetryAfterHeader, strconv.Itoa(BusyRetryAfter)).
+		Build()
+	if err != nil {
+		return internalErrorResponse(err)
+	}
+	return resp
+}
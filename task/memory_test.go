@@ -0,0 +1,73 @@
+package task
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	b := NewMemoryBudget(100)
+
+	assert.True(t, b.reserve(80))
+	assert.False(t, b.reserve(30))
+	assert.True(t, b.reserve(20))
+	b.release(100)
+
+	// a request alone is always accepted
+	assert.True(t, b.reserve(300))
+	b.release(300)
+
+	assert.Equal(t, MemoryStats{Budget: 100, InUse: 0, Peak: 300, Rejected: 1}, b.Stats())
+}
+
+func TestMemoryBudgetRequests(t *testing.T) {
+	history := strings.Repeat(`{"description":"old","entry":"20250101T100000Z","status":"pending","uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`+"\n", 100)
+
+	process := func(opts Options) Message {
+		client := &mockClient{
+			writer: new(strings.Builder),
+			reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+		}
+		ra := &mockReadAppender{
+			reader: strings.NewReader(history),
+			writer: new(strings.Builder),
+		}
+		Process(client, &mockAuth{}, ra, opts)
+		return parseMsg(t, client.writer.String())
+	}
+
+	t.Run("fits", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Memory = NewMemoryBudget(1 << 20)
+
+		resp := process(opts)
+		assert.Equal(t, "200", resp.Header["code"])
+		stats := opts.Memory.Stats()
+		assert.Zero(t, stats.InUse)
+		assert.Greater(t, stats.Peak, int64(len(history)))
+	})
+
+	t.Run("history over budget", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Memory = NewMemoryBudget(len(history))
+		// another request in flight
+		assert.True(t, opts.Memory.reserve(1))
+
+		resp := process(opts)
+		assert.Equal(t, "420", resp.Header["code"])
+		assert.NotEmpty(t, resp.Header[RetryAfterHeader])
+		assert.Equal(t, int64(1), opts.Memory.Stats().InUse)
+	})
+
+	t.Run("request over budget", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Memory = NewMemoryBudget(10)
+		assert.True(t, opts.Memory.reserve(10))
+
+		resp := process(opts)
+		assert.Equal(t, "420", resp.Header["code"])
+		assert.Equal(t, MemoryStats{Budget: 10, InUse: 10, Peak: 10, Rejected: 1}, opts.Memory.Stats())
+	})
+}
@@ -0,0 +1,228 @@
+package task
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// MergeStrategy is the name of the MergeEngine combining the concurrent
+// client and server modifications of a task.
+type MergeStrategy string
+
+// Built-in merge strategies, both apply the modifications in order and the
+// last one applied wins the conflicts.
+const (
+	// MergeByTimestamp orders the modifications by their client-provided
+	// "modified" date, same as taskd.  Equal dates are ordered by receipt.
+	MergeByTimestamp MergeStrategy = "timestamp"
+
+	// MergeByReceipt orders the modifications as the server received them,
+	// so a client with a wrong clock can't permanently win the conflicts.
+	MergeByReceipt MergeStrategy = "receipt"
+)
+
+// Sources of the modifications in the merge audit trail.
+const (
+	MergeClient = "client"
+	MergeServer = "server"
+)
+
+// Modification is a version of a task along with its receipt sequence number.
+// The transactions file is append-only, so a server modification's sequence
+// is its line number, and the ones being synced follow the server data.
+type Modification struct {
+	Task Task
+	Seq  int
+}
+
+// MergeStep is an entry of the merge audit trail, a modification applied to
+// the combined task.
+type MergeStep struct {
+	Source   string
+	Seq      int
+	Modified time.Time
+	Changes  Changes
+}
+
+func (s MergeStep) String() string {
+	return fmt.Sprintf("%s %d (%s): +%v -%v ~%v", s.Source, s.Seq, s.Modified.Format(DateLayout),
+		s.Changes.Added, s.Changes.Removed, s.Changes.Modified)
+}
+
+// MergeEngine combines the client and server modifications of a task made
+// since their common ancestor.  The modifications are in receipt order, and
+// the engine must not change the ancestor nor the modifications.
+type MergeEngine interface {
+	Merge(ancestor Task, client, server []Modification) (Task, []MergeStep)
+}
+
+var (
+	mergeEnginesMu gosync.RWMutex
+	mergeEngines   = map[MergeStrategy]MergeEngine{
+		MergeByTimestamp: legacyEngine{MergeByTimestamp},
+		MergeByReceipt:   legacyEngine{MergeByReceipt},
+	}
+)
+
+// RegisterMergeEngine adds the engine selectable with the given name in the
+// "merge.mode" entries, so experimental strategies can run side by side with
+// the built-in ones.  A name can only be registered once.
+func RegisterMergeEngine(name MergeStrategy, engine MergeEngine) error {
+	mergeEnginesMu.Lock()
+	defer mergeEnginesMu.Unlock()
+
+	if name == "" || strings.ContainsAny(string(name), " \n") {
+		return fmt.Errorf("invalid merge engine name %q", name)
+	} else if _, ok := mergeEngines[name]; ok {
+		return fmt.Errorf("merge engine %q already registered", name)
+	}
+	mergeEngines[name] = engine
+	return nil
+}
+
+// MergeStrategies returns the names of the registered merge engines, sorted.
+func MergeStrategies() []string {
+	mergeEnginesMu.RLock()
+	defer mergeEnginesMu.RUnlock()
+
+	names := make([]string, 0, len(mergeEngines))
+	for name := range mergeEngines {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseMergeStrategy validates a merge strategy name, empty means
+// MergeByTimestamp.
+func ParseMergeStrategy(name string) (MergeStrategy, error) {
+	if name == "" {
+		return MergeByTimestamp, nil
+	}
+
+	mergeEnginesMu.RLock()
+	_, ok := mergeEngines[MergeStrategy(name)]
+	mergeEnginesMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("one of %v expected, got %q", MergeStrategies(), name)
+	}
+	return MergeStrategy(name), nil
+}
+
+// Engine returns the merge engine registered with the strategy name,
+// MergeByTimestamp's if there's none.
+func (s MergeStrategy) Engine() MergeEngine {
+	mergeEnginesMu.RLock()
+	defer mergeEnginesMu.RUnlock()
+
+	if engine, ok := mergeEngines[s]; ok {
+		return engine
+	}
+	return mergeEngines[MergeByTimestamp]
+}
+
+// before returns true if left has to be applied before right.
+func (s MergeStrategy) before(left, right Modification) bool {
+	if s == MergeByReceipt {
+		return left.Seq < right.Seq
+	}
+
+	modLeft, modRight := lastModification(left.Task), lastModification(right.Task)
+	if modLeft.Equal(modRight) {
+		return left.Seq < right.Seq
+	}
+	return modLeft.Before(modRight)
+}
+
+// legacyEngine is the taskd merge: it walks both lists of modifications in
+// the strategy order, patching the ancestor with the changes each one made
+// to its predecessor in the same list.
+type legacyEngine struct {
+	strategy MergeStrategy
+}
+
+func (e legacyEngine) Merge(ancestor Task, client, server []Modification) (Task, []MergeStep) {
+	combined := ancestor.Copy()
+	prevClient, prevServer := ancestor, ancestor
+	var idxClient, idxServer int
+	var steps []MergeStep
+
+	apply := func(source string, prev *Task, mod Modification) {
+		changes := patch(combined, *prev, mod.Task)
+		modified := lastModification(mod.Task)
+		combined.SetDate("modified", modified)
+		*prev = mod.Task
+		steps = append(steps, MergeStep{Source: source, Seq: mod.Seq, Modified: modified, Changes: changes})
+	}
+
+	for idxClient < len(client) && idxServer < len(server) {
+		if e.strategy.before(client[idxClient], server[idxServer]) {
+			apply(MergeClient, &prevClient, client[idxClient])
+			idxClient++
+		} else {
+			apply(MergeServer, &prevServer, server[idxServer])
+			idxServer++
+		}
+	}
+	for ; idxClient < len(client); idxClient++ {
+		apply(MergeClient, &prevClient, client[idxClient])
+	}
+	for ; idxServer < len(server); idxServer++ {
+		apply(MergeServer, &prevServer, server[idxServer])
+	}
+
+	return combined, steps
+}
+
+// mergeRecords returns the decision logs of the merged entries.
+func mergeRecords(entries []syncEntry, strategy MergeStrategy) []repo.MergeRecord {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	var records []repo.MergeRecord
+	for _, e := range entries {
+		if !e.merge {
+			continue
+		}
+		record := repo.MergeRecord{
+			Time:       now,
+			UUID:       e.task.Get("uuid"),
+			Strategy:   string(strategy),
+			Attributes: make(map[string]string),
+			Steps:      make([]repo.MergeDecision, 0, len(e.steps)),
+		}
+		for _, step := range e.steps {
+			decision := repo.MergeDecision{
+				Source:   step.Source,
+				Modified: step.Modified,
+				Changed:  append(append([]string(nil), step.Changes.Added...), step.Changes.Modified...),
+				Removed:  step.Changes.Removed,
+			}
+			sort.Strings(decision.Changed)
+			for _, name := range append(decision.Changed, decision.Removed...) {
+				record.Attributes[name] = step.Source
+			}
+			record.Steps = append(record.Steps, decision)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// mergeStrategy returns the merge strategy of the user organization.
+func (o Options) mergeStrategy(user auth.User) MergeStrategy {
+	if user.Org != nil {
+		if s, ok := o.OrgMerge[user.Org.Name]; ok {
+			return s
+		}
+	}
+	if o.Merge == "" {
+		return MergeByTimestamp
+	}
+	return o.Merge
+}
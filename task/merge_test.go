@@ -0,0 +1,133 @@
+package task
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestMergeStrategy(t *testing.T) {
+	uuid := "c9a1e0c2-5a4f-4e0b-9a57-8f0e3f1d2b11"
+	entry := time.Date(2021, 5, 3, 10, 0, 0, 0, time.UTC)
+
+	taskLine := func(description string, modified time.Time) string {
+		return fmt.Sprintf(`{"uuid":%q,"description":%q,"status":"pending","entry":%q,"modified":%q}`,
+			uuid, description, entry.Format(DateLayout), modified.Format(DateLayout))
+	}
+
+	// a client with its clock one year ahead stored a modification, then a
+	// client with the right clock modifies the task again
+	serverData := []string{
+		taskLine("original", entry),
+		"f1b9c9f0-2d7c-4f43-8a7e-3c4f0a6b2e10",
+		taskLine("skewed", entry.AddDate(1, 0, 0)),
+		"0c6d2b5e-8f1a-4a7b-9e3d-5b2c1a0f9e22",
+	}
+	client, err := NewTask(taskLine("fixed", entry.Add(time.Hour)))
+	assert.Nil(t, err)
+
+	cases := []struct {
+		strategy MergeStrategy
+		expected string
+	}{
+		{MergeByTimestamp, "skewed"},
+		{MergeByReceipt, "fixed"},
+	}
+
+	for _, c := range cases {
+		t.Run(string(c.strategy), func(t *testing.T) {
+			merged, _, err := mergeTask(serverData, []Task{client}, 1, uuid, c.strategy, "")
+			assert.Nil(t, err)
+
+			result, err := NewTask(merged)
+			assert.Nil(t, err)
+			assert.Equal(t, c.expected, result.Get("description"))
+		})
+	}
+
+	t.Run("equal dates are ordered by receipt", func(t *testing.T) {
+		older := Modification{Task: client, Seq: 1}
+		newer := Modification{Task: client, Seq: 2}
+
+		assert.True(t, MergeByTimestamp.before(older, newer))
+		assert.False(t, MergeByTimestamp.before(newer, older))
+	})
+
+	t.Run("audit trail", func(t *testing.T) {
+		ancestor, err := NewTask(serverData[0])
+		assert.Nil(t, err)
+		skewed, err := NewTask(serverData[2])
+		assert.Nil(t, err)
+
+		combined, steps := MergeByReceipt.Engine().Merge(ancestor,
+			[]Modification{{Task: client, Seq: 4}}, []Modification{{Task: skewed, Seq: 2}})
+
+		assert.Equal(t, "fixed", combined.Get("description"))
+		assert.Equal(t, "original", ancestor.Get("description"))
+		assert.Len(t, steps, 2)
+		assert.Equal(t, MergeStep{Source: MergeServer, Seq: 2, Modified: entry.AddDate(1, 0, 0),
+			Changes: Changes{Modified: []string{"description", "modified"}}}, steps[0])
+		assert.Equal(t, MergeClient, steps[1].Source)
+		assert.Equal(t, 4, steps[1].Seq)
+	})
+
+	t.Run("registered engine", func(t *testing.T) {
+		name := MergeStrategy("ancestor")
+		assert.Nil(t, RegisterMergeEngine(name, ancestorEngine{}))
+		defer func() {
+			mergeEnginesMu.Lock()
+			delete(mergeEngines, name)
+			mergeEnginesMu.Unlock()
+		}()
+		assert.NotNil(t, RegisterMergeEngine(name, ancestorEngine{}))
+		assert.NotNil(t, RegisterMergeEngine(MergeByTimestamp, ancestorEngine{}))
+		assert.NotNil(t, RegisterMergeEngine("", ancestorEngine{}))
+		assert.Equal(t, []string{"ancestor", "receipt", "timestamp"}, MergeStrategies())
+
+		s, err := ParseMergeStrategy("ancestor")
+		assert.Nil(t, err)
+		assert.Equal(t, name, s)
+
+		merged, _, err := mergeTask(serverData, []Task{client}, 1, uuid, name, MergeByTimestamp)
+		assert.Nil(t, err)
+		result, err := NewTask(merged)
+		assert.Nil(t, err)
+		assert.Equal(t, "original", result.Get("description"))
+	})
+
+	t.Run("unknown strategy engine", func(t *testing.T) {
+		assert.Equal(t, MergeByTimestamp.Engine(), MergeStrategy("random").Engine())
+	})
+
+	t.Run("per organization strategy", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.OrgMerge = map[string]MergeStrategy{"Skewed": MergeByReceipt}
+
+		assert.Equal(t, MergeByReceipt, opts.mergeStrategy(auth.User{Org: &auth.Organization{Name: "Skewed"}}))
+		assert.Equal(t, MergeByTimestamp, opts.mergeStrategy(auth.User{Org: &auth.Organization{Name: "Public"}}))
+		assert.Equal(t, MergeByTimestamp, opts.mergeStrategy(auth.User{}))
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		s, err := ParseMergeStrategy("")
+		assert.Nil(t, err)
+		assert.Equal(t, MergeByTimestamp, s)
+
+		s, err = ParseMergeStrategy("receipt")
+		assert.Nil(t, err)
+		assert.Equal(t, MergeByReceipt, s)
+
+		_, err = ParseMergeStrategy("random")
+		assert.NotNil(t, err)
+	})
+}
+
+// ancestorEngine ignores every modification.
+type ancestorEngine struct{}
+
+func (ancestorEngine) Merge(ancestor Task, client, server []Modification) (Task, []MergeStep) {
+	return ancestor.Copy(), nil
+}
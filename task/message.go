@@ -1,10 +1,16 @@
 package task
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"sort"
+	"strconv"
 	"strings"
+	gosync "sync"
 )
 
 const (
@@ -18,34 +24,67 @@ type Message struct {
 	Header map[string]string
 	// Payload is an optional payload.
 	Payload string
+	// Stream, if set, writes the payload instead of Payload, so the large
+	// ones are sent as they're generated instead of kept in memory.
+	Stream PayloadStream
+
+	// status is the catalog status of a response, see localize.
+	status *catalogStatus
+}
+
+// PayloadStream is a payload written as it's generated, see Message.Stream.
+type PayloadStream interface {
+	io.WriterTo
+
+	// Size returns the number of bytes WriteTo writes, sent before the
+	// payload.
+	Size() int
 }
 
-// NewMessage parses a message
+// NewMessage parses a message.  The headers end at the first blank line and
+// everything after it is the payload, kept verbatim even if it has blank lines
+// too.  Header lines starting with whitespace continue the previous header
+// value.
 func NewMessage(raw string) (Message, error) {
 	message := Message{
 		Header: map[string]string{},
 	}
 
-	parts := strings.Split(raw, SEP)
-	if len(parts) == 1 {
-		return message, errors.New("Message separator not found")
-	} else if len(parts) >= 2 {
-		message.Payload = parts[1]
+	sep := strings.Index(raw, SEP)
+	if sep == -1 {
+		return message, errNoSeparator
 	}
+	message.Payload = raw[sep+len(SEP):]
+
+	var previous string
+	for _, header := range strings.Split(raw[:sep], "\n") {
+		if previous != "" && header != "" && (header[0] == ' ' || header[0] == '\t') {
+			// folded header
+			if value := strings.TrimSpace(header); value != "" {
+				message.Header[previous] = strings.TrimSpace(message.Header[previous] + " " + value)
+			}
+			continue
+		}
 
-	headers := strings.Split(parts[0], "\n")
-	for _, header := range headers {
-		splitted := strings.Split(header, ": ")
-		if len(splitted) != 2 {
+		// the value may have colons too, e.g. "status: Error: ..."
+		colon := strings.IndexByte(header, ':')
+		if colon <= 0 || strings.TrimSpace(header[:colon]) == "" {
 			return message, fmt.Errorf("error parsing header entry: %q", header)
 		}
+		previous = CanonicalHeader(header[:colon])
 
-		message.Header[splitted[0]] = splitted[1]
+		message.Header[previous] = strings.TrimSpace(header[colon+1:])
 	}
 
 	return message, nil
 }
 
+// CanonicalHeader returns the name used for a header in Message.Header, the
+// names are case-insensitive and are kept in lower case, as they're sent.
+func CanonicalHeader(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
 // NewResponseMessage is a helper method to create a simple response message
 // with an initial header
 func NewResponseMessage(code, status string) Message {
@@ -58,28 +97,285 @@ func NewResponseMessage(code, status string) Message {
 	}
 }
 
+// headerOrder is the order used to serialize the well-known headers, the
+// remaining ones follow sorted alphabetically.
+var headerOrder = []string{"type", "org", "user", "key", "client", "protocol", "code", "status"}
+
+// requiredResponseHeaders are the headers every response must have.
+var requiredResponseHeaders = []string{"type", "code", "status"}
+
+// ResponseBuilder creates response messages, validating that all the required
+// headers are set.  The zero value is not usable, see NewResponse.
+type ResponseBuilder struct {
+	msg Message
+}
+
+// NewResponse starts building a response with the given code.  The status is
+// taken from ErrorCodes unless it is overridden using WithStatus.
+func NewResponse(code int) *ResponseBuilder {
+	return &ResponseBuilder{
+		msg: Message{
+			Header: map[string]string{
+				"type":   "response",
+				"code":   strconv.Itoa(code),
+				"status": ErrorCodes[code],
+			},
+		},
+	}
+}
+
+// WithStatus sets the status description sent along with the response code.
+func (b *ResponseBuilder) WithStatus(status string) *ResponseBuilder {
+	return b.WithHeader("status", status)
+}
+
+// withCatalogStatus sets the status from the catalog, see catalogResponse.
+func (b *ResponseBuilder) withCatalogStatus(id MessageID, args ...interface{}) *ResponseBuilder {
+	status := catalogStatus{id: id, args: args}
+	b.msg.status = &status
+	return b.WithStatus(status.text(DefaultLocale))
+}
+
+// WithHeader sets an arbitrary header, overriding any previous value.  The
+// name is canonicalized.
+func (b *ResponseBuilder) WithHeader(name, value string) *ResponseBuilder {
+	b.msg.Header[CanonicalHeader(name)] = value
+	return b
+}
+
+// WithPayload sets the response payload.
+func (b *ResponseBuilder) WithPayload(payload string) *ResponseBuilder {
+	b.msg.Payload = payload
+	return b
+}
+
+// WithStream sets the response payload, written as it's generated.
+func (b *ResponseBuilder) WithStream(stream PayloadStream) *ResponseBuilder {
+	b.msg.Stream = stream
+	return b
+}
+
+// Build validates the headers and returns the response message.
+func (b *ResponseBuilder) Build() (Message, error) {
+	for _, name := range requiredResponseHeaders {
+		if b.msg.Header[name] == "" {
+			return Message{}, fmt.Errorf("missing required header %q", name)
+		}
+	}
+
+	code, err := strconv.Atoi(b.msg.Header["code"])
+	if _, ok := ErrorCodes[code]; err != nil || !ok {
+		return Message{}, fmt.Errorf("unknown response code %q", b.msg.Header["code"])
+	}
+
+	for name, value := range b.msg.Header {
+		if name == "" || strings.ContainsAny(name, ":\n") {
+			return Message{}, fmt.Errorf("invalid header name %q", name)
+		}
+		if strings.Contains(value, "\n") {
+			return Message{}, fmt.Errorf("invalid value for header %q: %q", name, value)
+		}
+	}
+
+	msg := Message{
+		Header:  make(map[string]string, len(b.msg.Header)),
+		Payload: b.msg.Payload,
+		Stream:  b.msg.Stream,
+		status:  b.msg.status,
+	}
+	for name, value := range b.msg.Header {
+		msg.Header[name] = value
+	}
+
+	return msg, nil
+}
+
+// sortedHeaders returns the message header names in serialization order.
+func (m Message) sortedHeaders() []string {
+	names := make([]string, 0, len(m.Header))
+	for _, name := range headerOrder {
+		if _, ok := m.Header[name]; ok {
+			names = append(names, name)
+		}
+	}
+
+	extra := len(names)
+	for name := range m.Header {
+		if !sliceContains(headerOrder, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[extra:])
+
+	return names
+}
+
+var (
+	// errNoSeparator is returned parsing a message without payload
+	// separator, with the taskd text.
+	errNoSeparator = errors.New("Message separator not found")
+	// errMessageTooBig is returned reading a message bigger than the limit.
+	errMessageTooBig = errors.New("message size limit exceeded")
+)
+
+// messageBuffers keeps the buffers used to read and write messages to avoid
+// allocating new ones for every request.
+var messageBuffers = gosync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
 // String makes Message an Stringer
 func (m Message) String() string {
-	var builder strings.Builder
-	for h := range m.Header {
-		fmt.Fprintf(&builder, "%s: %s\n", h, m.Header[h])
+	var buffer bytes.Buffer
+	buffer.Grow(m.size())
+	m.writeBody(&buffer)
+	if m.Stream != nil {
+		if _, err := m.Stream.WriteTo(&buffer); err != nil {
+			log.Errorf("Error writing the payload: %v", err)
+		}
 	}
-	fmt.Fprintf(&builder, "\n%s", m.Payload)
 
-	return builder.String()
+	return buffer.String()
 }
 
-// Serialize convert a message in an array of bytes ready to send to the
-// client.
-func (m Message) Serialize() []byte {
-	msg := m.String()
-	size := uint32(len(msg) + 4)
+// streamBufferSize is the size of the writes of a streamed payload.
+const streamBufferSize = 64 * 1024
+
+// WriteTo writes the message to w using the wire format expected by the
+// client, i.e. the message size as a 4 bytes big endian number followed by
+// the message itself.  The whole message is sent with a single write, unless
+// the payload is streamed, then it's sent in chunks as it's generated.
+func (m Message) WriteTo(w io.Writer) (int64, error) {
+	buffer := messageBuffers.Get().(*bytes.Buffer)
+	defer messageBuffers.Put(buffer)
+	buffer.Reset()
+
+	size := m.size() + 4
+	if m.Stream == nil {
+		buffer.Grow(size)
+	}
 
-	buffer := make([]byte, size)
+	var prefix [4]byte
+	binary.BigEndian.PutUint32(prefix[:], uint32(size))
+	buffer.Write(prefix[:])
+	m.writeBody(buffer)
 
-	binary.BigEndian.PutUint32(buffer[:4], size)
+	if m.Stream != nil {
+		return m.writeStream(w, buffer.Bytes(), size)
+	}
 
-	copy(buffer[4:], msg)
+	sent, err := w.Write(buffer.Bytes())
+	if err != nil || sent < size {
+		return int64(sent), fmt.Errorf("writing response to the client, sent %v: %v", sent, err)
+	}
+
+	return int64(sent), nil
+}
+
+// writeStream writes the size and headers already serialized, followed by
+// the streamed payload.  The size was sent in advance, so a payload writing
+// a different number of bytes fails.
+func (m Message) writeStream(w io.Writer, head []byte, size int) (int64, error) {
+	counter := &countingWriter{w: w}
+	writer := bufio.NewWriterSize(counter, streamBufferSize)
+
+	_, err := writer.Write(head)
+	if err == nil {
+		_, err = m.Stream.WriteTo(writer)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		return counter.written, fmt.Errorf("writing response to the client, sent %v: %v", counter.written, err)
+	} else if counter.written != int64(size) {
+		return counter.written, fmt.Errorf("writing response to the client, sent %v of %v bytes", counter.written, size)
+	}
+
+	return counter.written, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w       io.Writer
+	written int64
+}
 
-	return buffer
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+// materialize replaces the streamed payload by its content, for the
+// transformations that need the whole payload.
+func (m *Message) materialize() error {
+	if m.Stream == nil {
+		return nil
+	}
+
+	var payload strings.Builder
+	payload.Grow(m.Stream.Size())
+	if _, err := m.Stream.WriteTo(&payload); err != nil {
+		return fmt.Errorf("generating payload: %v", err)
+	}
+	m.Payload, m.Stream = payload.String(), nil
+	return nil
+}
+
+// ReadMessage reads a message sent using the wire format, see WriteTo.  It
+// fails if the message is bigger than limit bytes, including the size.
+func ReadMessage(r io.Reader, limit int) (Message, error) {
+	var prefix [4]byte
+
+	if num, err := io.ReadFull(r, prefix[:]); err != nil {
+		return Message{}, fmt.Errorf("reading size, read %v bytes, got %w", num, err)
+	}
+
+	messageSize := int(binary.BigEndian.Uint32(prefix[:]))
+	if messageSize > limit {
+		return Message{}, errMessageTooBig
+	} else if messageSize < len(prefix) {
+		return Message{}, fmt.Errorf("invalid message size: %v", messageSize)
+	}
+
+	buffer := messageBuffers.Get().(*bytes.Buffer)
+	defer messageBuffers.Put(buffer)
+	buffer.Reset()
+	buffer.Grow(messageSize - len(prefix))
+	body := buffer.Bytes()[:messageSize-len(prefix)]
+
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Message{}, fmt.Errorf("reading client, got %w", err)
+	}
+
+	// the buffer is reused, so the message gets its own copy
+	return NewMessage(string(body))
+}
+
+// size returns the length of the serialized message, without the size
+// prefix.
+func (m Message) size() int {
+	// headers, the blank line separating them and the payload
+	size := 1 + len(m.Payload)
+	if m.Stream != nil {
+		size = 1 + m.Stream.Size()
+	}
+	for h, v := range m.Header {
+		size += len(h) + len(": ") + len(v) + 1
+	}
+	return size
+}
+
+func (m Message) writeBody(w *bytes.Buffer) {
+	for _, h := range m.sortedHeaders() {
+		w.WriteString(h)
+		w.WriteString(": ")
+		w.WriteString(m.Header[h])
+		w.WriteString("\n")
+	}
+	w.WriteString("\n")
+	w.WriteString(m.Payload)
 }
@@ -1,7 +1,10 @@
 package task
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -54,6 +57,51 @@ status: Ok
 			failure: true,
 		},
 
+		{
+			title:    "header names are case-insensitive",
+			given:    "Type: sync\nORG: Public\n\npayload",
+			expected: Message{Header: map[string]string{"type": "sync", "org": "Public"}, Payload: "payload"},
+		},
+		{
+			title:    "header names and values are trimmed",
+			given:    "type:sync\norg :  Public \r\n\npayload",
+			expected: Message{Header: map[string]string{"type": "sync", "org": "Public"}, Payload: "payload"},
+		},
+		{
+			title:    "header values can have colons",
+			given:    "type: response\nstatus: Error: something failed\n\n",
+			expected: Message{Header: map[string]string{"type": "response", "status": "Error: something failed"}},
+		},
+		{
+			title:    "header values can be empty",
+			given:    "type: sync\ninfo:\n\n",
+			expected: Message{Header: map[string]string{"type": "sync", "info": ""}},
+		},
+		{
+			title:   "header without name should fail",
+			given:   "type: sync\n: value\n\n",
+			failure: true,
+		},
+		{
+			title:    "payload with blank lines is kept verbatim",
+			given:    "type: sync\n\nfirst\n\nsecond\n\n\nthird\n",
+			expected: Message{Header: map[string]string{"type": "sync"}, Payload: "first\n\nsecond\n\n\nthird\n"},
+		},
+		{
+			title:    "payload starting with a blank line",
+			given:    "type: sync\n\n\npayload",
+			expected: Message{Header: map[string]string{"type": "sync"}, Payload: "\npayload"},
+		},
+		{
+			title:    "folded headers continue the previous value",
+			given:    "type: response\nstatus: Could not\n  sync the\n\ttasks\ncode: 500\n\n",
+			expected: Message{Header: map[string]string{"type": "response", "status": "Could not sync the tasks", "code": "500"}},
+		},
+		{
+			title:    "folded empty header",
+			given:    "type: sync\ninfo:\n more info\n\n",
+			expected: Message{Header: map[string]string{"type": "sync", "info": "more info"}},
+		},
 		{
 			title:    "message with empty payload should be parsed",
 			given:    "type: response\n\n",
@@ -90,7 +138,7 @@ func TestToStringMessage(t *testing.T) {
 		},
 		{
 			title:    "simple message with payload",
-			given:    Message{map[string]string{"type": "response"}, "payload"},
+			given:    Message{Header: map[string]string{"type": "response"}, Payload: "payload"},
 			expected: "type: response\n\npayload",
 		},
 	}
@@ -99,7 +147,7 @@ func TestToStringMessage(t *testing.T) {
 		assert.Equal(t, c.expected, c.given.String())
 	}
 }
-func TestSerializeMessage(t *testing.T) {
+func TestWriteMessage(t *testing.T) {
 	cases := []struct {
 		title    string
 		given    Message
@@ -112,15 +160,198 @@ func TestSerializeMessage(t *testing.T) {
 		},
 		{
 			title:    "simple message with payload",
-			given:    Message{map[string]string{"type": "response"}, "payload"},
+			given:    Message{Header: map[string]string{"type": "response"}, Payload: "payload"},
 			expected: []byte("type: response\n\npayload"),
 		},
 	}
 
 	for _, c := range cases {
-		message := c.given.Serialize()
+		var buffer bytes.Buffer
+		written, err := c.given.WriteTo(&buffer)
+		assert.NoError(t, err)
+		message := buffer.Bytes()
+		assert.Equal(t, int64(len(message)), written)
 		size := binary.BigEndian.Uint32(message[:4])
 		assert.Equal(t, c.expected, message[4:])
 		assert.Equal(t, uint32(len(message)), size)
 	}
 }
+
+func TestReadMessage(t *testing.T) {
+	msg := Message{Header: map[string]string{"type": "sync", "org": "Public"}, Payload: "first\n\nsecond\n"}
+
+	t.Run("written messages are read back", func(t *testing.T) {
+		var buffer bytes.Buffer
+		_, err := msg.WriteTo(&buffer)
+		assert.NoError(t, err)
+
+		read, err := ReadMessage(&buffer, RequestLimitInBytes)
+		assert.NoError(t, err)
+		assert.Equal(t, msg, read)
+		assert.Zero(t, buffer.Len())
+	})
+
+	cases := []struct {
+		title string
+		given []byte
+		limit int
+		err   string
+	}{
+		{"size limit exceeded", frame(msg.String()), len(msg.String()), "message size limit exceeded"},
+		{"invalid size", []byte{0, 0, 0, 3}, RequestLimitInBytes, "invalid message size: 3"},
+		{"missing size", []byte{0, 0}, RequestLimitInBytes, "reading size, read 2 bytes, got unexpected EOF"},
+		{"truncated message", frame(msg.String())[:10], RequestLimitInBytes, "reading client, got unexpected EOF"},
+		{"missing separator", frame("type: sync\n"), RequestLimitInBytes, "Message separator not found"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			_, err := ReadMessage(bytes.NewReader(c.given), c.limit)
+			assert.EqualError(t, err, c.err)
+		})
+	}
+}
+
+// frame returns the message with its size prefix, as sent through the wire.
+func frame(msg string) []byte {
+	raw := make([]byte, 4, 4+len(msg))
+	binary.BigEndian.PutUint32(raw, uint32(4+len(msg)))
+	return append(raw, msg...)
+}
+
+func TestResponseBuilder(t *testing.T) {
+	cases := []struct {
+		name    string
+		builder *ResponseBuilder
+		header  map[string]string
+		payload string
+		err     string
+	}{
+		{
+			name:    "status from error codes",
+			builder: NewResponse(200).WithPayload("payload"),
+			header:  map[string]string{"type": "response", "code": "200", "status": "Ok"},
+			payload: "payload",
+		},
+		{
+			name:    "custom status",
+			builder: NewResponse(430).WithStatus("Access denied"),
+			header:  map[string]string{"type": "response", "code": "430", "status": "Access denied"},
+		},
+		{
+			name:    "extra header",
+			builder: NewResponse(201).WithHeader("client", "gotas"),
+			header:  map[string]string{"type": "response", "code": "201", "status": "No change", "client": "gotas"},
+		},
+		{
+			name:    "canonical header name",
+			builder: NewResponse(200).WithHeader(" Client ", "gotas"),
+			header:  map[string]string{"type": "response", "code": "200", "status": "Ok", "client": "gotas"},
+		},
+		{
+			name:    "unknown code",
+			builder: NewResponse(999),
+			err:     `missing required header "status"`,
+		},
+		{
+			name:    "unknown code with status",
+			builder: NewResponse(999).WithStatus("Whatever"),
+			err:     `unknown response code "999"`,
+		},
+		{
+			name:    "empty type",
+			builder: NewResponse(200).WithHeader("type", ""),
+			err:     `missing required header "type"`,
+		},
+		{
+			name:    "invalid header name",
+			builder: NewResponse(200).WithHeader("bad: name", "value"),
+			err:     `invalid header name "bad: name"`,
+		},
+		{
+			name:    "invalid header value",
+			builder: NewResponse(200).WithStatus("Ok\ncode: 500"),
+			err:     `invalid value for header "status": "Ok\ncode: 500"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msg, err := c.builder.Build()
+			if c.err != "" {
+				assert.EqualError(t, err, c.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, c.header, msg.Header)
+			assert.Equal(t, c.payload, msg.Payload)
+		})
+	}
+}
+
+func TestDeterministicHeaderOrder(t *testing.T) {
+	msg, err := NewResponse(200).
+		WithHeader("zeta", "z").
+		WithHeader("alpha", "a").
+		WithHeader("client", "gotas").
+		WithPayload("payload").
+		Build()
+	assert.Nil(t, err)
+
+	expected := "type: response\nclient: gotas\ncode: 200\nstatus: Ok\nalpha: a\nzeta: z\n\npayload"
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, expected, msg.String())
+	}
+}
+
+func BenchmarkReceiveMessage(b *testing.B) {
+	var buffer bytes.Buffer
+	if _, err := benchmarkMessage().WriteTo(&buffer); err != nil {
+		b.Fatal(err)
+	}
+	raw := buffer.Bytes()
+	reader := bytes.NewReader(raw)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader.Reset(raw)
+		if _, err := ReadMessage(reader, RequestLimitInBytes); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReplyMessage(b *testing.B) {
+	msg := benchmarkMessage()
+	var w bytes.Buffer
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		if err := replyMessage(&w, msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkMessage() Message {
+	var payload strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&payload, `{"description":"Task %d","entry":"20211009T112536Z","status":"pending","uuid":"e346004f-6ebb-4507-8f21-%012d"}`+"\n", i, i)
+	}
+	payload.WriteString("94978aad-fbaf-4876-92e0-33321f1cbab9\n")
+
+	return Message{
+		Header: map[string]string{
+			"type":     "sync",
+			"org":      "Public",
+			"user":     "noeh",
+			"key":      "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7",
+			"client":   "taskwarrior 2.6.0",
+			"protocol": "v1",
+		},
+		Payload: payload.String(),
+	}
+}
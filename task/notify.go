@@ -0,0 +1,119 @@
+package task
+
+import (
+	"encoding/json"
+	gosync "sync"
+
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/pubsub"
+)
+
+// notifyBacklog is the number of sync events waiting to be published, newer
+// events are dropped when the broker can't keep up.
+const notifyBacklog = 100
+
+// SyncEvent summarizes the tasks stored and merged by a sync.
+type SyncEvent struct {
+	Org    string   `json:"org"`
+	User   string   `json:"user"`
+	Stored int      `json:"stored"`
+	Merged int      `json:"merged"`
+	UUIDs  []string `json:"uuids"`
+}
+
+func newSyncEvent(user auth.User, entries []syncEntry) SyncEvent {
+	event := SyncEvent{User: user.Name, UUIDs: make([]string, 0, len(entries))}
+	if user.Org != nil {
+		event.Org = user.Org.Name
+	}
+
+	for _, e := range entries {
+		if e.merge {
+			event.Merged++
+		} else {
+			event.Stored++
+		}
+		if uuid := e.task.Get("uuid"); !sliceContains(event.UUIDs, uuid) {
+			event.UUIDs = append(event.UUIDs, uuid)
+		}
+	}
+
+	return event
+}
+
+// Notifier publishes the sync events in background, so a slow or
+// unavailable broker doesn't delay the syncs.
+type Notifier struct {
+	publisher pubsub.Publisher
+	topic     func(org string) string
+	events    chan SyncEvent
+	done      chan struct{}
+
+	mu     gosync.Mutex
+	closed bool
+}
+
+// NewNotifier creates a Notifier sending the events of every organization to
+// the topic returned by topic.
+func NewNotifier(publisher pubsub.Publisher, topic func(org string) string) *Notifier {
+	n := &Notifier{
+		publisher: publisher,
+		topic:     topic,
+		events:    make(chan SyncEvent, notifyBacklog),
+		done:      make(chan struct{}),
+	}
+
+	go n.run()
+
+	return n
+}
+
+// Notify queues an event to be published.  Events notified after Close are
+// dropped.
+func (n *Notifier) Notify(event SyncEvent) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		log.Warnf("Dropping sync notification for %q: notifier closed", event.User)
+		return
+	}
+
+	select {
+	case n.events <- event:
+	default:
+		log.Warnf("Dropping sync notification for %q: too many pending", event.User)
+	}
+}
+
+// Close publishes the pending events and closes the connection to the
+// broker.  Closing it more than once has no effect.
+func (n *Notifier) Close() error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	n.closed = true
+	close(n.events)
+	n.mu.Unlock()
+
+	<-n.done
+	return n.publisher.Close()
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+
+	for event := range n.events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			log.Errorf("Error encoding sync notification: %v", err)
+			continue
+		}
+
+		if err := n.publisher.Publish(n.topic(event.Org), payload); err != nil {
+			log.Errorf("Error publishing sync notification: %v", err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package task
+
+import (
+	"errors"
+	"strings"
+	gosync "sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockPublisher struct {
+	mu       gosync.Mutex
+	messages map[string][]string
+	fails    bool
+	closed   bool
+}
+
+func (p *mockPublisher) Publish(topic string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.fails {
+		return errors.New("broker unavailable")
+	}
+	p.messages[topic] = append(p.messages[topic], string(payload))
+	return nil
+}
+
+func (p *mockPublisher) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestNotifySync(t *testing.T) {
+	publisher := &mockPublisher{messages: make(map[string][]string)}
+	notifier := NewNotifier(publisher, func(org string) string { return "gotas/" + org })
+
+	var events []SyncEvent
+	opts := DefaultOptions()
+	opts.OnSync = func(e SyncEvent) {
+		events = append(events, e)
+		notifier.Notify(e)
+	}
+
+	for _, c := range []struct{ sent, before string }{
+		{"msg-sent-init", "empty-tx"},
+		{"msg-sent-case11", "tx-case11-before.data"},
+		{"msg-sent-merged-task", "tx-merged-task-before.data"},
+	} {
+		client := &mockClient{
+			reader: strings.NewReader(loadPayload(t, c.sent)),
+			writer: new(strings.Builder),
+		}
+		ra := &mockReadAppender{
+			reader: strings.NewReader(string(loadFile(t, c.before))),
+			writer: new(strings.Builder),
+		}
+		Process(client, &mockAuth{}, ra, opts)
+	}
+
+	assert.NoError(t, notifier.Close())
+	assert.True(t, publisher.closed)
+
+	// the sync without changes is not notified
+	if assert.Len(t, events, 2) {
+		assert.Greater(t, events[0].Stored, 0)
+		assert.Equal(t, 0, events[0].Merged)
+		assert.Equal(t, events[0].Stored, len(events[0].UUIDs))
+		assert.Greater(t, events[1].Merged, 0)
+	}
+	assert.Len(t, publisher.messages["gotas/"], 2)
+}
+
+func TestNotifierFailures(t *testing.T) {
+	publisher := &mockPublisher{fails: true}
+	notifier := NewNotifier(publisher, func(org string) string { return org })
+
+	assert.NotPanics(t, func() {
+		for i := 0; i < 2*notifyBacklog; i++ {
+			notifier.Notify(SyncEvent{Org: "Public"})
+		}
+	})
+	assert.NoError(t, notifier.Close())
+}
+
+func TestNotifierClose(t *testing.T) {
+	publisher := &mockPublisher{messages: make(map[string][]string)}
+	notifier := NewNotifier(publisher, func(org string) string { return org })
+
+	var wg gosync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < notifyBacklog; j++ {
+				notifier.Notify(SyncEvent{Org: "Public"})
+			}
+		}()
+	}
+
+	assert.NoError(t, notifier.Close())
+	wg.Wait()
+
+	assert.NotPanics(t, func() { notifier.Notify(SyncEvent{Org: "Public"}) })
+	assert.NoError(t, notifier.Close())
+	assert.True(t, publisher.closed)
+}
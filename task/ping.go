@@ -0,0 +1,26 @@
+package task
+
+// PingType is the type of the health probes, answered without authenticating
+// the client nor reading its data, e.g. by the load balancers checking the
+// server through the taskd port.
+const PingType = "ping"
+
+// PingRequest builds a health probe.
+func PingRequest(client string) Message {
+	return Message{
+		Header: map[string]string{
+			"client":   client,
+			"protocol": ProtocolVersion,
+			"type":     PingType,
+		},
+	}
+}
+
+// pingResponse replies a health probe, the server is healthy unless it's in
+// maintenance mode.
+func pingResponse(opts Options) Message {
+	if opts.Maintenance != nil && opts.Maintenance() {
+		return maintenanceResponse()
+	}
+	return NewResponseMessage("200", ErrorCodes[200])
+}
@@ -0,0 +1,107 @@
+package task
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/transport"
+)
+
+func TestPing(t *testing.T) {
+	newClient := func(t *testing.T, req Message, anonymous bool) *peerClient {
+		var buf bytes.Buffer
+		_, err := req.WriteTo(&buf)
+		assert.NoError(t, err)
+		return &peerClient{
+			mockClient: mockClient{reader: strings.NewReader(buf.String()), writer: new(strings.Builder)},
+			peer:       auth.Peer{Address: "192.0.2.1:1234", Anonymous: anonymous},
+		}
+	}
+	process := func(t *testing.T, client *peerClient, opts Options) Message {
+		// the storage and the credentials are never checked
+		Process(client, &mockAuth{fails: true}, &panicReadAppender{}, opts)
+
+		resp, err := NewMessage(client.writer.String()[4:])
+		assert.NoError(t, err)
+		return resp
+	}
+
+	t.Run("healthy server", func(t *testing.T) {
+		for _, anonymous := range []bool{false, true} {
+			client := newClient(t, PingRequest("probe"), anonymous)
+			resp := process(t, client, DefaultOptions())
+			assert.Equal(t, "200", resp.Header["code"])
+			assert.True(t, client.closed)
+		}
+	})
+
+	t.Run("server in maintenance", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Maintenance = func() bool { return true }
+
+		resp := process(t, newClient(t, PingRequest("probe"), false), opts)
+		assert.Equal(t, "420", resp.Header["code"])
+	})
+
+	t.Run("anonymous clients can only ping", func(t *testing.T) {
+		req := StatisticsRequest(Credentials{Org: "Public", User: "root", Key: "secret"}, "probe")
+		resp := process(t, newClient(t, req, true), DefaultOptions())
+		assert.Equal(t, "430", resp.Header["code"])
+	})
+}
+
+func TestTunnelAnonymous(t *testing.T) {
+	base := filepath.Join("transport", "testdata", "certs")
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	srv, err := transport.NewTunnelServer(transport.TLSConfig{
+		CaCert:             filepath.Join(base, "ca.pem"),
+		ServerCert:         filepath.Join(base, "server.pem"),
+		ServerKey:          filepath.Join(base, "server.key"),
+		BindAddress:        address,
+		OptionalClientCert: true,
+	}, 1, func(client io.ReadWriteCloser) {
+		// the storage is never reached by the anonymous clients
+		Process(client, &mockAuth{}, &panicReadAppender{}, DefaultOptions())
+	})
+	assert.NoError(t, err)
+	defer srv.Close()
+
+	ca, err := os.ReadFile(filepath.Join(base, "ca.pem"))
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"}}}
+
+	var body bytes.Buffer
+	_, err = SyncRequest(Credentials{Org: "Public", User: "root", Key: "secret"}, "probe", nil, "").WriteTo(&body)
+	assert.NoError(t, err)
+
+	resp, err := client.Post(fmt.Sprintf("https://%s%s", address, transport.TunnelPath), "application/octet-stream", &body)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	if assert.Greater(t, len(raw), 4) {
+		msg, err := NewMessage(string(raw[4:]))
+		assert.NoError(t, err)
+		assert.Equal(t, "430", msg.Header["code"])
+	}
+}
@@ -0,0 +1,92 @@
+// Package process runs the programs the server is extended with, the hooks
+// and the extensions, making sure that neither they nor the processes they
+// start outlive their timeout.
+package process
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// ErrTimeout is returned when a program doesn't finish on time.
+var ErrTimeout = errors.New("timed out")
+
+// Run runs the program at path in dir with input as its standard input,
+// returning its standard output and error.  The program runs in its own
+// process group, killed if the program, or the background processes still
+// holding its output, don't finish within the timeout.  A non-zero exit
+// status is returned as an *exec.ExitError.
+func Run(path, dir string, input []byte, timeout time.Duration) ([]byte, []byte, error) {
+	// the outputs are pipes of our own, so the end of the program can be
+	// told apart from the end of its output
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer stdoutReader.Close()
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdoutWriter.Close()
+		return nil, nil, err
+	}
+	defer stderrReader.Close()
+
+	cmd := exec.Command(path)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+	newGroup(cmd)
+
+	err = cmd.Start()
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	copied := make(chan struct{}, 2)
+	go copyOutput(&stdout, stdoutReader, copied)
+	go copyOutput(&stderr, stderrReader, copied)
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	timedOut := false
+	select {
+	case err = <-exited:
+	case <-timer.C:
+		timedOut = true
+		killGroup(cmd)
+		err = <-exited
+	}
+	for i := 0; i < 2 && !timedOut; i++ {
+		select {
+		case <-copied:
+		case <-timer.C:
+			timedOut = true
+			killGroup(cmd)
+		}
+	}
+
+	// the processes that left the group may still hold the output
+	stdoutReader.Close()
+	stderrReader.Close()
+	if timedOut {
+		return nil, nil, ErrTimeout
+	}
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+func copyOutput(w io.Writer, r io.Reader, done chan<- struct{}) {
+	io.Copy(w, r)
+	done <- struct{}{}
+}
@@ -0,0 +1,82 @@
+package process
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+
+	install := func(t *testing.T, script string) string {
+		t.Helper()
+		path := filepath.Join(dir, "program")
+		assert.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755))
+		return path
+	}
+
+	// running tells whether the process is alive, the killed ones may be
+	// zombies until reaped
+	running := func(t *testing.T, pidFile string) bool {
+		t.Helper()
+		pid, err := os.ReadFile(pidFile)
+		assert.NoError(t, err)
+		stat, err := os.ReadFile(filepath.Join("/proc", strings.TrimSpace(string(pid)), "stat"))
+		return err == nil && !strings.Contains(string(stat), ") Z ")
+	}
+
+	t.Run("input and outputs", func(t *testing.T) {
+		path := install(t, "cat\necho warning >&2\n")
+
+		stdout, stderr, err := Run(path, dir, []byte("input"), time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, "input", string(stdout))
+		assert.Equal(t, "warning\n", string(stderr))
+	})
+
+	t.Run("exit status", func(t *testing.T) {
+		path := install(t, "echo failed\nexit 3\n")
+
+		stdout, _, err := Run(path, dir, nil, time.Second)
+		assert.IsType(t, &exec.ExitError{}, err)
+		assert.Equal(t, "failed\n", string(stdout))
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		pidFile := filepath.Join(dir, "timed-out.pid")
+		path := install(t, "sleep 10 &\necho $! > "+pidFile+"\nwait\n")
+
+		start := time.Now()
+		_, _, err := Run(path, dir, nil, 100*time.Millisecond)
+		assert.ErrorIs(t, err, ErrTimeout)
+		assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+		assert.Eventually(t, func() bool { return !running(t, pidFile) }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("background process holding the output", func(t *testing.T) {
+		pidFile := filepath.Join(dir, "holding.pid")
+		path := install(t, "sleep 10 &\necho $! > "+pidFile+"\n")
+
+		start := time.Now()
+		_, _, err := Run(path, dir, nil, 100*time.Millisecond)
+		assert.ErrorIs(t, err, ErrTimeout)
+		assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+		assert.Eventually(t, func() bool { return !running(t, pidFile) }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("detached background process", func(t *testing.T) {
+		pidFile := filepath.Join(dir, "detached.pid")
+		path := install(t, "sleep 1 > /dev/null 2>&1 &\necho $! > "+pidFile+"\necho done\n")
+
+		stdout, _, err := Run(path, dir, nil, time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, "done\n", string(stdout))
+		assert.True(t, running(t, pidFile))
+	})
+}
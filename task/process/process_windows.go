@@ -0,0 +1,10 @@
+package process
+
+import "os/exec"
+
+func newGroup(cmd *exec.Cmd) {}
+
+// killGroup kills the program, the processes it started are left running.
+func killGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
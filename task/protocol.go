@@ -0,0 +1,98 @@
+package task
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	gosync "sync"
+)
+
+// ProtocolsHeader lists, comma separated, the protocol versions supported by
+// the server.  It's sent when a request is rejected because of its version,
+// so the client can retry with one of them.
+const ProtocolsHeader = "protocols"
+
+// Codec translates the payloads of a protocol version from and to the v1
+// format the sync works with, so a new format doesn't change the merge.
+type Codec interface {
+	// DecodeRequest returns the request payload in the v1 format.
+	DecodeRequest(payload string) (string, error)
+
+	// EncodeResponse returns the v1 response payload in the codec format.
+	EncodeResponse(payload string) (string, error)
+}
+
+// UnsupportedProtocolError is returned when the client speaks a protocol
+// version without a registered codec.
+type UnsupportedProtocolError struct {
+	Version string
+}
+
+func (e UnsupportedProtocolError) Error() string {
+	return fmt.Sprintf("protocol not supported (%s)", e.Version)
+}
+
+// v1Codec is the taskd protocol, already in the format the sync works with.
+type v1Codec struct{}
+
+func (v1Codec) DecodeRequest(payload string) (string, error) {
+	return payload, nil
+}
+
+func (v1Codec) EncodeResponse(payload string) (string, error) {
+	return payload, nil
+}
+
+var (
+	codecsMu gosync.RWMutex
+	codecs   = map[string]Codec{ProtocolVersion: v1Codec{}}
+)
+
+// RegisterCodec adds the codec handling the given protocol version.  A
+// version can only be registered once.
+func RegisterCodec(version string, codec Codec) error {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	if version == "" || strings.ContainsAny(version, ",\n") {
+		return fmt.Errorf("invalid protocol version %q", version)
+	} else if _, ok := codecs[version]; ok {
+		return fmt.Errorf("protocol %q already registered", version)
+	}
+	codecs[version] = codec
+	return nil
+}
+
+// SupportedProtocols returns the protocol versions with a registered codec,
+// sorted.
+func SupportedProtocols() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	versions := make([]string, 0, len(codecs))
+	for version := range codecs {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// codecFor returns the codec of the protocol version.
+func codecFor(version string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	codec, ok := codecs[version]
+	if !ok {
+		return nil, UnsupportedProtocolError{Version: version}
+	}
+	return codec, nil
+}
+
+// unsupportedProtocolResponse rejects a request because of its protocol
+// version, advertising the supported ones.
+func unsupportedProtocolResponse(err UnsupportedProtocolError) Message {
+	resp := NewResponseMessage("400", err.Error())
+	resp.Header[ProtocolsHeader] = strings.Join(SupportedProtocols(), ",")
+	return resp
+}
@@ -0,0 +1,72 @@
+package task
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// prefixCodec is a test protocol whose payloads start with a marker line.
+type prefixCodec struct{}
+
+const codecMarker = "# v-test\n"
+
+func (prefixCodec) DecodeRequest(payload string) (string, error) {
+	return strings.TrimPrefix(payload, codecMarker), nil
+}
+
+func (prefixCodec) EncodeResponse(payload string) (string, error) {
+	return codecMarker + payload, nil
+}
+
+func TestRegisterCodec(t *testing.T) {
+	assert.Error(t, RegisterCodec(ProtocolVersion, prefixCodec{}))
+	assert.Error(t, RegisterCodec("", prefixCodec{}))
+	assert.Error(t, RegisterCodec("v2,v3", prefixCodec{}))
+
+	assert.NoError(t, RegisterCodec("v-test", prefixCodec{}))
+	defer func() {
+		codecsMu.Lock()
+		delete(codecs, "v-test")
+		codecsMu.Unlock()
+	}()
+	assert.Error(t, RegisterCodec("v-test", prefixCodec{}))
+	assert.Equal(t, []string{"v-test", "v1"}, SupportedProtocols())
+
+	t.Run("sync using the registered codec", func(t *testing.T) {
+		msg := parseMsg(t, loadPayload(t, "msg-sent-init"))
+		msg.Header["protocol"] = "v-test"
+		msg.Payload = codecMarker + msg.Payload
+
+		client := &mockClient{
+			reader: strings.NewReader(string(frame(msg.String()))),
+			writer: new(strings.Builder),
+		}
+		ra := &mockReadAppender{
+			reader: strings.NewReader(""),
+			writer: new(strings.Builder),
+		}
+
+		Process(client, &mockAuth{}, ra, DefaultOptions())
+
+		resp := parseMsg(t, client.writer.String())
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.Equal(t, "v-test", resp.Header["protocol"])
+		assert.True(t, strings.HasPrefix(resp.Payload, codecMarker))
+		assert.NotContains(t, ra.writer.String(), codecMarker)
+	})
+
+	t.Run("advertise the supported protocols", func(t *testing.T) {
+		client := &mockClient{
+			reader: strings.NewReader(loadPayload(t, "msg-sent-invalid-protocol")),
+			writer: new(strings.Builder),
+		}
+
+		Process(client, &mockAuth{}, &mockReadAppender{writer: new(strings.Builder)}, DefaultOptions())
+
+		resp := parseMsg(t, client.writer.String())
+		assert.Equal(t, "400", resp.Header["code"])
+		assert.Equal(t, "v-test,v1", resp.Header[ProtocolsHeader])
+	})
+}
@@ -0,0 +1,121 @@
+package pubsub
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	mqttConnect   = 0x10
+	mqttConnAck   = 0x20
+	mqttPublish   = 0x30
+	mqttLevel311  = 4
+	mqttClean     = 0x02
+	mqttPassword  = 0x40
+	mqttUsername  = 0x80
+	mqttMaxLength = 268435455
+)
+
+// mqtt implements the publishing subset of MQTT 3.1.1.  Messages are sent
+// with QoS 0 and the keep alive is disabled.
+type mqtt struct{}
+
+func (mqtt) handshake(conn net.Conn, u *url.URL) error {
+	var body bytes.Buffer
+	writeMQTTString(&body, "MQTT")
+	body.WriteByte(mqttLevel311)
+
+	flags := byte(mqttClean)
+	var user, pass string
+	if u.User != nil {
+		user = u.User.Username()
+		flags |= mqttUsername
+		if p, ok := u.User.Password(); ok {
+			pass = p
+			flags |= mqttPassword
+		}
+	}
+	body.WriteByte(flags)
+	body.Write([]byte{0, 0}) // keep alive disabled
+
+	writeMQTTString(&body, fmt.Sprintf("gotas-%d", time.Now().UnixNano()))
+	if flags&mqttUsername != 0 {
+		writeMQTTString(&body, user)
+	}
+	if flags&mqttPassword != 0 {
+		writeMQTTString(&body, pass)
+	}
+
+	if err := writeMQTTPacket(conn, mqttConnect, body.Bytes()); err != nil {
+		return err
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(dialTimeout)); err != nil {
+		return err
+	}
+	var ack [4]byte
+	if _, err := io.ReadFull(conn, ack[:]); err != nil {
+		return fmt.Errorf("reading connection ack: %v", err)
+	}
+	if ack[0] != mqttConnAck || ack[1] != 2 {
+		return errors.New("unexpected connection ack")
+	} else if ack[3] != 0 {
+		return fmt.Errorf("connection refused (code %d)", ack[3])
+	}
+
+	return conn.SetReadDeadline(time.Time{})
+}
+
+func (mqtt) validate(topic string) error {
+	if topic == "" || len(topic) > 0xffff || strings.ContainsAny(topic, "+#\x00") {
+		return fmt.Errorf("invalid topic %q", topic)
+	}
+	return nil
+}
+
+func (mqtt) publish(conn net.Conn, topic string, payload []byte) error {
+	var body bytes.Buffer
+	writeMQTTString(&body, topic)
+	body.Write(payload)
+
+	return writeMQTTPacket(conn, mqttPublish, body.Bytes())
+}
+
+func writeMQTTString(buf *bytes.Buffer, s string) {
+	buf.WriteByte(byte(len(s) >> 8))
+	buf.WriteByte(byte(len(s)))
+	buf.WriteString(s)
+}
+
+func writeMQTTPacket(w io.Writer, packetType byte, body []byte) error {
+	if len(body) > mqttMaxLength {
+		return fmt.Errorf("packet too big: %d bytes", len(body))
+	}
+
+	packet := make([]byte, 0, len(body)+5)
+	packet = append(packet, packetType)
+
+	// remaining length, variable length encoded
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+
+	_, err := w.Write(packet)
+	return err
+}
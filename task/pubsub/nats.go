@@ -0,0 +1,98 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// nats implements the publishing subset of the NATS client protocol.  Server
+// pings are answered in background so the connection is kept alive.
+type nats struct {
+	mu gosync.Mutex
+}
+
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+}
+
+func (n *nats) handshake(conn net.Conn, u *url.URL) error {
+	reader := bufio.NewReader(conn)
+
+	if err := conn.SetReadDeadline(time.Now().Add(dialTimeout)); err != nil {
+		return err
+	}
+	info, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading server info: %v", err)
+	} else if !strings.HasPrefix(info, "INFO ") {
+		return fmt.Errorf("unexpected server greeting: %q", strings.TrimSpace(info))
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	connect := natsConnect{Name: "gotas"}
+	if u.User != nil {
+		connect.User = u.User.Username()
+		connect.Pass, _ = u.User.Password()
+	}
+	options, err := json.Marshal(connect)
+	if err != nil {
+		return err
+	}
+	if err := n.write(conn, fmt.Sprintf("CONNECT %s\r\n", options)); err != nil {
+		return err
+	}
+
+	go n.serve(conn, reader)
+
+	return nil
+}
+
+func (n *nats) validate(subject string) error {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return fmt.Errorf("invalid subject %q", subject)
+	}
+	return nil
+}
+
+func (n *nats) publish(conn net.Conn, subject string, payload []byte) error {
+	return n.write(conn, fmt.Sprintf("PUB %s %d\r\n%s\r\n", subject, len(payload), payload))
+}
+
+// serve answers the server pings until the connection is closed.
+func (n *nats) serve(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			if err := n.write(conn, "PONG\r\n"); err != nil {
+				return
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			log.Errorf("NATS server error: %s", line)
+		}
+	}
+}
+
+func (n *nats) write(conn net.Conn, data string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	_, err := conn.Write([]byte(data))
+	return err
+}
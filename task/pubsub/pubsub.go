@@ -0,0 +1,142 @@
+// Package pubsub implements minimal MQTT and NATS publishers, enough to emit
+// notifications without pulling full featured client libraries.
+package pubsub
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	gosync "sync"
+	"time"
+
+	"github.com/szaffarano/gotas/logger"
+)
+
+const dialTimeout = 5 * time.Second
+
+// publishTimeout bounds the writes of a message, so a broker that stops
+// reading can't block the publisher forever.
+var publishTimeout = 5 * time.Second
+
+var log *logger.Logger
+
+func init() {
+	log = logger.Log()
+}
+
+// Publisher sends messages to a topic (MQTT) or subject (NATS).
+type Publisher interface {
+	Publish(topic string, payload []byte) error
+	Close() error
+}
+
+// protocol is the wire protocol of a broker.
+type protocol interface {
+	// validate verifies the topic is valid for the protocol.
+	validate(topic string) error
+
+	// handshake initializes a new connection.
+	handshake(conn net.Conn, u *url.URL) error
+
+	// publish writes a message to an already initialized connection.
+	publish(conn net.Conn, topic string, payload []byte) error
+}
+
+// New creates a Publisher for the broker at the given URL.  The supported
+// schemes are "mqtt" and "nats", credentials can be set in the URL user info.
+// The connection is established on the first publication and reestablished
+// if it fails.
+func New(rawURL string) (Publisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing broker url: %v", err)
+	}
+
+	var p protocol
+	switch u.Scheme {
+	case "mqtt":
+		p = &mqtt{}
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), "1883")
+		}
+	case "nats":
+		p = &nats{}
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), "4222")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported broker scheme %q", u.Scheme)
+	}
+
+	return &publisher{url: u, protocol: p}, nil
+}
+
+type publisher struct {
+	mu       gosync.Mutex
+	url      *url.URL
+	protocol protocol
+	conn     net.Conn
+}
+
+// Publish sends the message, connecting to the broker if needed.  A broken
+// connection is reestablished once before giving up.
+func (p *publisher) Publish(topic string, payload []byte) (err error) {
+	if err := p.protocol.validate(topic); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		reused := p.conn != nil
+		if !reused {
+			if err = p.connect(); err != nil {
+				return err
+			}
+		}
+
+		if err = p.conn.SetWriteDeadline(time.Now().Add(publishTimeout)); err == nil {
+			err = p.protocol.publish(p.conn, topic, payload)
+		}
+		if err == nil {
+			if err = p.conn.SetWriteDeadline(time.Time{}); err == nil {
+				return nil
+			}
+		}
+
+		p.conn.Close()
+		p.conn = nil
+		if !reused {
+			break
+		}
+	}
+
+	return fmt.Errorf("publishing to %q: %v", topic, err)
+}
+
+func (p *publisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.url.Host, dialTimeout)
+	if err != nil {
+		return fmt.Errorf("connecting to %v: %v", p.url.Host, err)
+	}
+	if err := p.protocol.handshake(conn, p.url); err != nil {
+		conn.Close()
+		return fmt.Errorf("connecting to %v: %v", p.url.Host, err)
+	}
+	p.conn = conn
+	return nil
+}
+
+// Close closes the connection to the broker.
+func (p *publisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
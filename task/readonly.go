@@ -0,0 +1,72 @@
+package task
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// ReadOnlyError rejects an append while the server, or the organization Org
+// if set, is read-only.
+type ReadOnlyError struct {
+	Org string
+}
+
+// Error makes ReadOnlyError an error.
+func (e ReadOnlyError) Error() string {
+	if e.Org != "" {
+		return fmt.Sprintf("organization %q is read-only", e.Org)
+	}
+	return "server is read-only"
+}
+
+// readOnly returns the response rejecting a sync uploading changes, if the
+// server or the user organization are read-only.
+func (o Options) readOnly(user auth.User) (Message, bool) {
+	if err := checkReadOnly(user, o.ReadOnly); err != nil {
+		return readOnlyResponse(*err), true
+	}
+	return Message{}, false
+}
+
+func checkReadOnly(user auth.User, server bool) *ReadOnlyError {
+	if server {
+		return &ReadOnlyError{}
+	} else if user.Org != nil && user.Org.ReadOnly {
+		return &ReadOnlyError{Org: user.Org.Name}
+	}
+	return nil
+}
+
+// readOnlyResponse rejects a sync uploading changes.  The server is read-only
+// for a while, e.g. during a maintenance, so it asks the client to retry.
+func readOnlyResponse(err ReadOnlyError) Message {
+	if err.Org != "" {
+		return catalogResponse("430", MsgOrgReadOnly, err.Org)
+	}
+	resp, buildErr := NewResponse(420).
+		withCatalogStatus(MsgReadOnly).
+		WithHeader(RetryAfterHeader, strconv.Itoa(MaintenanceRetryAfter)).
+		Build()
+	if buildErr != nil {
+		return internalErrorResponse(buildErr)
+	}
+	return resp
+}
+
+// readOnlyGate rejects the appends with a ReadOnlyError while the server, as
+// reported by server, or the user organization are read-only, so the REST API
+// honours the read-only modes like the syncs do.
+type readOnlyGate struct {
+	ReadAppender
+	server func() bool
+}
+
+// Append makes readOnlyGate a ReadAppender.
+func (g readOnlyGate) Append(user auth.User, data []string) error {
+	if err := checkReadOnly(user, g.server()); err != nil {
+		return *err
+	}
+	return g.ReadAppender.Append(user, data)
+}
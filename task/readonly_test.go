@@ -0,0 +1,102 @@
+package task
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestReadOnly(t *testing.T) {
+	newMessage := func(t *testing.T, upload bool) (Message, *mockReadAppender) {
+		t.Helper()
+		msg, err := NewMessage(string(loadFile(t, "msg-sent-case01")))
+		assert.Nil(t, err)
+
+		data := string(loadFile(t, "tx-case01-before.data"))
+		if !upload {
+			// only the sync key, downloading the changes
+			lines := strings.Split(strings.TrimSpace(data), "\n")
+			msg.Payload = lines[len(lines)-1] + "\n"
+		}
+
+		return msg, &mockReadAppender{
+			reader: strings.NewReader(data),
+			writer: new(strings.Builder),
+		}
+	}
+
+	cases := []struct {
+		title    string
+		server   bool
+		org      bool
+		upload   bool
+		expected string
+	}{
+		{"writable", false, false, true, "200"},
+		{"read-only server", true, false, true, "420"},
+		{"read-only organization", false, true, true, "430"},
+		{"read-only server without changes", true, false, false, "201"},
+		{"read-only organization without changes", false, true, false, "201"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			user := auth.User{Name: "noeh", Org: &auth.Organization{Name: "Public", ReadOnly: c.org}}
+			opts := DefaultOptions()
+			opts.ReadOnly = c.server
+			msg, ra := newMessage(t, c.upload)
+
+			resp := processMessage(msg, user, ra, opts)
+			assert.Equal(t, c.expected, resp.Header["code"], resp.Header["status"])
+			if !strings.HasPrefix(c.expected, "2") {
+				assert.Equal(t, "", ra.writer.String())
+			}
+		})
+	}
+}
+
+// readOnlyAuth authenticates every user, the ones of the "Archive"
+// organization being read-only.
+type readOnlyAuth struct{}
+
+func (readOnlyAuth) Authenticate(org, user, key string) (auth.User, error) {
+	return auth.User{Name: user, Key: key, Org: &auth.Organization{Name: org, ReadOnly: org == "Archive"}}, nil
+}
+
+func TestReadOnlyAPI(t *testing.T) {
+	serverReadOnly := false
+	ra := readOnlyGate{ReadAppender: newMemReadAppender(), server: func() bool { return serverReadOnly }}
+	server := httptest.NewServer(APIHandler(readOnlyAuth{}, ra, NewSequentialKeys()))
+	defer server.Close()
+
+	post := func(t *testing.T, org string) *http.Response {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/v1/orgs/"+org+"/users/secret/tasks", strings.NewReader(`{"description":"Pay bills"}`))
+		assert.NoError(t, err)
+		req.SetBasicAuth(org+"/noeh", "secret")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("writable", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, post(t, "Public").StatusCode)
+	})
+
+	t.Run("read-only organization", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, post(t, "Archive").StatusCode)
+	})
+
+	t.Run("read-only server", func(t *testing.T) {
+		serverReadOnly = true
+		resp := post(t, "Public")
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+	})
+}
@@ -0,0 +1,126 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultRequestTimeout is how long a client can take to send a request,
+	// counting from its first byte.
+	DefaultRequestTimeout = 5 * time.Minute
+
+	// DefaultMinRate is the minimum transfer rate, in bytes per second, of
+	// the requests.
+	DefaultMinRate = 100
+
+	// DefaultIdleTimeout is how long a new connection can wait before sending
+	// the first byte of its request.
+	DefaultIdleTimeout = 30 * time.Second
+
+	// minRateGrace is the time a client has before the minimum rate is
+	// enforced, so the small requests of a slow network aren't rejected.
+	minRateGrace = 10 * time.Second
+)
+
+// errSlowClient is returned reading a request that takes too long, e.g. a
+// slow-loris client trickling the bytes to hold a server worker.
+var errSlowClient = errors.New("slow client")
+
+// receiver reads a request enforcing the maximum duration and the minimum
+// transfer rate, both counting from its first byte, and with idle, how long
+// the first byte can take.  Connections supporting timeouts are interrupted
+// on time, the rest are only checked between reads.
+type receiver struct {
+	r       io.Reader
+	timeout time.Duration
+	minRate int
+	grace   time.Duration
+	idle    time.Duration
+
+	start time.Time
+	read  int
+}
+
+// newReceiver returns a reader receiving the next request of the client with
+// the options timeouts.
+func newReceiver(client io.Reader, opts Options) *receiver {
+	return &receiver{r: client, timeout: opts.RequestTimeout, minRate: opts.MinRate, grace: minRateGrace}
+}
+
+func (r *receiver) Read(p []byte) (int, error) {
+	conn, deadlines := r.r.(readDeadliner)
+	if deadlines && (!r.start.IsZero() || r.idle > 0) {
+		if err := conn.SetReadDeadline(r.deadline()); err != nil {
+			log.Debugf("Error setting the request timeout: %v", err)
+		}
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 && r.start.IsZero() {
+		r.start = time.Now()
+	}
+	r.read += n
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() && !r.start.IsZero() {
+		return n, r.slowError()
+	} else if errors.As(err, &netErr) && netErr.Timeout() && r.idle > 0 {
+		return n, fmt.Errorf("%w: no request in %v", errSlowClient, r.idle)
+	} else if err == nil && r.late() {
+		return n, r.slowError()
+	}
+	return n, err
+}
+
+// deadline returns when the next byte has to be received, zero if there's no
+// limit.
+func (r *receiver) deadline() time.Time {
+	if r.start.IsZero() {
+		return time.Now().Add(r.idle)
+	}
+
+	var deadline time.Time
+	if r.timeout > 0 {
+		deadline = r.start.Add(r.timeout)
+	}
+	if r.minRate > 0 {
+		next := r.start.Add(r.grace + time.Duration(r.read+1)*time.Second/time.Duration(r.minRate))
+		if deadline.IsZero() || next.Before(deadline) {
+			deadline = next
+		}
+	}
+	return deadline
+}
+
+// late returns true if the bytes read so far arrived too late.
+func (r *receiver) late() bool {
+	if r.start.IsZero() {
+		return false
+	}
+	elapsed := time.Since(r.start)
+	if r.timeout > 0 && elapsed > r.timeout {
+		return true
+	}
+	return r.minRate > 0 && elapsed > r.grace+time.Duration(r.read)*time.Second/time.Duration(r.minRate)
+}
+
+func (r *receiver) slowError() error {
+	elapsed := time.Since(r.start).Round(time.Millisecond)
+	if r.timeout > 0 && elapsed >= r.timeout {
+		return fmt.Errorf("%w: request not received in %v", errSlowClient, r.timeout)
+	}
+	return fmt.Errorf("%w: %d bytes in %v, below %d bytes/s", errSlowClient, r.read, elapsed, r.minRate)
+}
+
+// done clears the request timeout, the connection may be kept alive.
+func (r *receiver) done() {
+	if conn, ok := r.r.(readDeadliner); ok && !r.start.IsZero() {
+		if err := conn.SetReadDeadline(time.Time{}); err != nil {
+			log.Debugf("Error clearing the request timeout: %v", err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package task
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// slowReader returns a byte every delay.
+type slowReader struct {
+	data  string
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	p[0], r.data = r.data[0], r.data[1:]
+	return 1, nil
+}
+
+func TestReceiver(t *testing.T) {
+	payload := loadPayload(t, "msg-sent-init")
+	prefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(prefix, 1000)
+
+	t.Run("fast client", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+		go client.Write([]byte(payload))
+
+		r := &receiver{r: server, timeout: time.Second, minRate: 1000, grace: time.Second}
+		_, err := ReadMessage(r, RequestLimitInBytes)
+		assert.Nil(t, err)
+		r.done()
+	})
+
+	t.Run("request timeout", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+		go client.Write(prefix)
+
+		start := time.Now()
+		r := &receiver{r: server, timeout: 100 * time.Millisecond}
+		_, err := ReadMessage(r, RequestLimitInBytes)
+		assert.True(t, errors.Is(err, errSlowClient), "got %v", err)
+		assert.Contains(t, err.Error(), "request not received in 100ms")
+		assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	})
+
+	t.Run("minimum rate", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+		go client.Write(append(prefix, '{'))
+
+		r := &receiver{r: server, minRate: 100, grace: 100 * time.Millisecond}
+		_, err := ReadMessage(r, RequestLimitInBytes)
+		assert.True(t, errors.Is(err, errSlowClient), "got %v", err)
+		assert.Contains(t, err.Error(), "below 100 bytes/s")
+	})
+
+	t.Run("waits the first byte", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			client.Write([]byte(payload))
+		}()
+
+		r := &receiver{r: server, timeout: 100 * time.Millisecond}
+		_, err := ReadMessage(r, RequestLimitInBytes)
+		assert.Nil(t, err)
+	})
+
+	t.Run("idle connection", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+
+		r := &receiver{r: server, timeout: time.Second, idle: 100 * time.Millisecond}
+		_, err := ReadMessage(r, RequestLimitInBytes)
+		assert.True(t, errors.Is(err, errSlowClient), "got %v", err)
+		assert.Contains(t, err.Error(), "no request in 100ms")
+	})
+
+	t.Run("idle timeout cleared by the first byte", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+		go func() {
+			client.Write(prefix[:1])
+			time.Sleep(200 * time.Millisecond)
+			client.Write([]byte(payload)[1:])
+		}()
+
+		r := &receiver{r: server, idle: 100 * time.Millisecond}
+		_, err := ReadMessage(r, RequestLimitInBytes)
+		assert.Nil(t, err)
+	})
+
+	t.Run("connection without timeouts", func(t *testing.T) {
+		r := &receiver{r: &slowReader{data: string(prefix) + "{}", delay: 20 * time.Millisecond}, timeout: 50 * time.Millisecond}
+		_, err := ReadMessage(r, RequestLimitInBytes)
+		assert.True(t, errors.Is(err, errSlowClient), "got %v", err)
+	})
+
+	t.Run("slow client disconnected without reply", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+
+		opts := DefaultOptions()
+		opts.RequestTimeout = 100 * time.Millisecond
+		done := make(chan struct{})
+		go func() {
+			Process(server, &mockAuth{}, &mockReadAppender{reader: strings.NewReader(""), writer: new(strings.Builder)}, opts)
+			close(done)
+		}()
+
+		_, err := client.Write(prefix)
+		assert.Nil(t, err)
+		_, err = client.Read(make([]byte, 1))
+		assert.Equal(t, io.EOF, err)
+		<-done
+	})
+
+	t.Run("silent client disconnected without reply", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+
+		opts := DefaultOptions()
+		opts.IdleTimeout = 100 * time.Millisecond
+		go Process(server, &mockAuth{}, &mockReadAppender{reader: strings.NewReader(""), writer: new(strings.Builder)}, opts)
+
+		_, err := client.Read(make([]byte, 1))
+		assert.Equal(t, io.EOF, err)
+	})
+}
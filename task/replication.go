@@ -0,0 +1,323 @@
+package task
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/transport"
+)
+
+const (
+	// replicateType is the message type used both by the replicas to
+	// subscribe and by the primary to send the appended transactions.
+	replicateType = "replicate"
+
+	// replicationLimit is the maximum size of a replication message.
+	replicationLimit = 64 * RequestLimitInBytes
+
+	// replicationBacklog is the number of events buffered per replica, a
+	// replica falling further behind is disconnected.
+	replicationBacklog = 1024
+
+	// replicationRetry is the time a replica waits before reconnecting to the
+	// primary.
+	replicationRetry = 5 * time.Second
+)
+
+// RedirectError is returned by a ReadAppender unable to store data.  Address
+// is the server the client has to use instead.
+type RedirectError struct {
+	Address string
+}
+
+// Error makes RedirectError an error.
+func (e RedirectError) Error() string {
+	return fmt.Sprintf("read-only server, use %s instead", e.Address)
+}
+
+type readOnly struct {
+	ReadAppender
+	primary string
+}
+
+// NewReadOnly returns a ReadAppender serving the data read from ra and
+// rejecting every append with a RedirectError pointing to the primary.
+func NewReadOnly(ra ReadAppender, primary string) ReadAppender {
+	return readOnly{ra, primary}
+}
+
+// Append makes readOnly a ReadAppender.
+func (r readOnly) Append(_ auth.User, _ []string) error {
+	return RedirectError{Address: r.primary}
+}
+
+// Primary is a ReadAppender streaming every appended transaction to the
+// connected replicas.
+type Primary struct {
+	ReadAppender
+
+	// Replicas are the certificate common names allowed to replicate.
+	Replicas []string
+
+	mu          gosync.Mutex
+	subscribers map[chan Message]bool
+	closed      bool
+}
+
+// NewPrimary wraps ra to replicate its appended transactions.
+func NewPrimary(ra ReadAppender, replicas []string) *Primary {
+	return &Primary{
+		ReadAppender: ra,
+		Replicas:     replicas,
+		subscribers:  make(map[chan Message]bool),
+	}
+}
+
+// Append stores the data and publishes it to the replicas along with the
+// last sync key the user had before, so they can detect gaps.  Unlike the
+// number of transactions, the key doesn't go backwards when the transactions
+// are rewritten shorter, e.g. by the retention or a reset.
+func (p *Primary) Append(user auth.User, data []string) error {
+	current, err := p.ReadAppender.Read(user)
+	if err != nil {
+		return err
+	}
+
+	if err := p.ReadAppender.Append(user, data); err != nil {
+		return err
+	}
+
+	p.publish(replicationEvent(user, lastSyncKey(current), data))
+
+	return nil
+}
+
+// ReplicationTLSConfig returns the configuration of the replication listener
+// bound to address.  The replicas are identified by their certificate common
+// name, so they must present a certificate signed by the CA whatever the
+// trust setting is, any other one could claim the name of a replica.
+func ReplicationTLSConfig(cfg transport.TLSConfig, address string) transport.TLSConfig {
+	cfg.BindAddress = address
+	cfg.AllowAnyClient = false
+	cfg.OptionalClientCert = false
+	return cfg
+}
+
+// Serve streams the appended transactions to a replica until either the
+// connection fails or the primary is closed.
+func (p *Primary) Serve(replica io.ReadWriteCloser) {
+	defer replica.Close()
+
+	msg, err := ReadMessage(replica, RequestLimitInBytes)
+	if err != nil {
+		log.Errorf("Error receiving replication request: %v", err)
+		return
+	}
+	if msg.Header["type"] != replicateType {
+		log.Errorf("Unexpected message type from replica: %q", msg.Header["type"])
+		return
+	}
+
+	name := peerName(replica)
+	if !sliceContains(p.Replicas, name) {
+		log.Warnf("Rejecting replication from unknown replica %q", name)
+		return
+	}
+
+	events := p.subscribe()
+	if events == nil {
+		return
+	}
+	defer p.unsubscribe(events)
+
+	log.Infof("Replica %q connected", name)
+	for event := range events {
+		if err := replyMessage(replica, event); err != nil {
+			log.Errorf("Error replicating to %q: %v", name, err)
+			return
+		}
+	}
+	log.Infof("Replica %q disconnected", name)
+}
+
+// Close disconnects all the replicas.
+func (p *Primary) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for events := range p.subscribers {
+		delete(p.subscribers, events)
+		close(events)
+	}
+}
+
+func (p *Primary) subscribe() chan Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+
+	events := make(chan Message, replicationBacklog)
+	p.subscribers[events] = true
+	return events
+}
+
+func (p *Primary) unsubscribe(events chan Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.subscribers[events] {
+		delete(p.subscribers, events)
+		close(events)
+	}
+}
+
+func (p *Primary) publish(event Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for events := range p.subscribers {
+		select {
+		case events <- event:
+		default:
+			log.Errorf("Replica too far behind, disconnecting it")
+			delete(p.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// Follow connects to the primary using dial and applies the replicated
+// transactions to ra until quit is closed, reconnecting if the connection
+// fails.
+func Follow(dial func() (io.ReadWriteCloser, error), ra ReadAppender, quit <-chan struct{}) {
+	for {
+		if err := follow(dial, ra, quit); err != nil {
+			log.Errorf("Replication stopped: %v", err)
+		}
+
+		select {
+		case <-quit:
+			return
+		case <-time.After(replicationRetry):
+		}
+	}
+}
+
+func follow(dial func() (io.ReadWriteCloser, error), ra ReadAppender, quit <-chan struct{}) error {
+	primary, err := dial()
+	if err != nil {
+		return fmt.Errorf("connecting to the primary: %v", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-quit:
+		case <-done:
+		}
+		primary.Close()
+	}()
+
+	subscription := Message{Header: map[string]string{"type": replicateType}}
+	if err := replyMessage(primary, subscription); err != nil {
+		return err
+	}
+
+	for {
+		event, err := ReadMessage(primary, replicationLimit)
+		if err != nil {
+			select {
+			case <-quit:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		if err := applyEvent(ra, event); err != nil {
+			log.Errorf("Error applying replicated data: %v", err)
+		}
+	}
+}
+
+func replicationEvent(user auth.User, after string, data []string) Message {
+	event := Message{
+		Header: map[string]string{
+			"type":  replicateType,
+			"key":   user.Key,
+			"after": after,
+		},
+		Payload: strings.Join(data, ""),
+	}
+	if user.Org != nil {
+		event.Header["org"] = user.Org.Name
+	}
+	if user.Group != "" {
+		event.Header["group"] = user.Group
+	}
+	return event
+}
+
+// applyEvent appends the replicated data if it follows the last sync key of
+// the replica, unless it was already applied.  A gap in the transactions
+// can't be recovered from the stream, the replica has to be reseeded from a
+// copy of the primary data.
+func applyEvent(ra ReadAppender, event Message) error {
+	user := auth.User{
+		Key:   event.Header["key"],
+		Org:   &auth.Organization{Name: event.Header["org"]},
+		Group: event.Header["group"],
+	}
+
+	var data []string
+	for _, line := range strings.SplitAfter(event.Payload, "\n") {
+		if line != "" {
+			data = append(data, line)
+		}
+	}
+
+	current, err := ra.Read(user)
+	if err != nil {
+		return err
+	}
+
+	after, last := event.Header["after"], lastSyncKey(current)
+	switch key := lastSyncKey(data); {
+	case last == after:
+		return ra.Append(user, data)
+	case key != "" && sliceContains(current, key):
+		log.Infof("Skipping already replicated data for %q", user.Key)
+		return nil
+	default:
+		return fmt.Errorf("replica out of sync for %q: last sync key %q, expected %q", user.Key, last, after)
+	}
+}
+
+// lastSyncKey returns the last sync key of the transactions, empty if there
+// is none.
+func lastSyncKey(data []string) string {
+	for i := len(data) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(data[i]); line != "" && !strings.HasPrefix(line, "{") {
+			return line
+		}
+	}
+	return ""
+}
+
+// peerName returns the common name of the peer certificate, empty if it
+// wasn't verified.
+func peerName(conn io.ReadWriteCloser) string {
+	if peer := peerOf(conn); peer.Verified {
+		return peer.CommonName()
+	}
+	return ""
+}
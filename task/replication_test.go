@@ -0,0 +1,244 @@
+package task
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/transport"
+)
+
+type memReadAppender struct {
+	mu   gosync.Mutex
+	data map[string][]string
+}
+
+func newMemReadAppender() *memReadAppender {
+	return &memReadAppender{data: make(map[string][]string)}
+}
+
+func (m *memReadAppender) Read(user auth.User) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var data []string
+	for _, line := range m.data[user.Org.Name+"/"+user.Key] {
+		data = append(data, strings.TrimSuffix(line, "\n"))
+	}
+	return data, nil
+}
+
+func (m *memReadAppender) Append(user auth.User, data []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := user.Org.Name + "/" + user.Key
+	m.data[key] = append(m.data[key], data...)
+	return nil
+}
+
+func TestReplication(t *testing.T) {
+	user := auth.User{Key: "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7", Org: &auth.Organization{Name: "Public"}}
+
+	primaryData := newMemReadAppender()
+	replicaData := newMemReadAppender()
+	primary := NewPrimary(primaryData, []string{""})
+
+	quit := make(chan struct{})
+	connected := make(chan struct{})
+	dial := func() (io.ReadWriteCloser, error) {
+		client, server := net.Pipe()
+		go primary.Serve(server)
+		close(connected)
+		return client, nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		Follow(dial, replicaData, quit)
+		close(done)
+	}()
+	<-connected
+
+	// wait until the replica subscribes
+	assert.Eventually(t, func() bool {
+		primary.mu.Lock()
+		defer primary.mu.Unlock()
+		return len(primary.subscribers) == 1
+	}, time.Second, time.Millisecond)
+
+	assert.NoError(t, primary.Append(user, []string{"{\"uuid\":\"1\"}\n", "key-1\n"}))
+	assert.NoError(t, primary.Append(user, []string{"{\"uuid\":\"2\"}\n", "key-2\n"}))
+
+	assert.Eventually(t, func() bool {
+		data, _ := replicaData.Read(user)
+		return len(data) == 4
+	}, time.Second, time.Millisecond)
+
+	expected, _ := primaryData.Read(user)
+	actual, _ := replicaData.Read(user)
+	assert.Equal(t, expected, actual)
+
+	close(quit)
+	<-done
+	primary.Close()
+}
+
+func TestApplyEvent(t *testing.T) {
+	user := auth.User{Key: "key", Org: &auth.Organization{Name: "Public"}}
+	ra := newMemReadAppender()
+
+	assert.NoError(t, applyEvent(ra, replicationEvent(user, "", []string{"{\"uuid\":\"1\"}\n", "key-1\n"})))
+	// already applied
+	assert.NoError(t, applyEvent(ra, replicationEvent(user, "", []string{"{\"uuid\":\"1\"}\n", "key-1\n"})))
+	// gap
+	assert.Error(t, applyEvent(ra, replicationEvent(user, "key-2", []string{"{\"uuid\":\"3\"}\n", "key-3\n"})))
+	assert.NoError(t, applyEvent(ra, replicationEvent(user, "key-1", []string{"{\"uuid\":\"2\"}\n", "key-2\n"})))
+
+	data, _ := ra.Read(user)
+	assert.Equal(t, []string{`{"uuid":"1"}`, "key-1", `{"uuid":"2"}`, "key-2"}, data)
+
+	t.Run("primary rewritten shorter", func(t *testing.T) {
+		// e.g. the retention kept the latest task and key only, the
+		// transactions are fewer but the next ones are still applied
+		primary := NewPrimary(newMemReadAppender(), nil)
+		assert.NoError(t, primary.ReadAppender.Append(user, []string{"{\"uuid\":\"2\"}\n", "key-2\n"}))
+		events := primary.subscribe()
+		defer primary.unsubscribe(events)
+
+		assert.NoError(t, primary.Append(user, []string{"{\"uuid\":\"4\"}\n", "key-4\n"}))
+		assert.NoError(t, applyEvent(ra, <-events))
+		data, _ := ra.Read(user)
+		assert.Equal(t, "key-4", data[len(data)-1])
+
+		// a reset starting from a new key is detected
+		reset := NewPrimary(newMemReadAppender(), nil)
+		assert.NoError(t, reset.ReadAppender.Append(user, []string{"{\"uuid\":\"4\"}\n", "key-reset\n"}))
+		events = reset.subscribe()
+		defer reset.unsubscribe(events)
+
+		assert.NoError(t, reset.Append(user, []string{"{\"uuid\":\"5\"}\n", "key-5\n"}))
+		assert.Error(t, applyEvent(ra, <-events))
+	})
+}
+
+func TestReplicationRequiresVerifiedReplicas(t *testing.T) {
+	base := filepath.Join("transport", "testdata", "certs")
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	// the certificates are trusted blindly, but not for the replication
+	primary := NewPrimary(newMemReadAppender(), []string{"localhost"})
+	srv, err := transport.NewServer(ReplicationTLSConfig(transport.TLSConfig{
+		CaCert:         filepath.Join(base, "ca.pem"),
+		ServerCert:     filepath.Join(base, "server.pem"),
+		ServerKey:      filepath.Join(base, "server.key"),
+		AllowAnyClient: true,
+	}, address), 2, primary.Serve)
+	assert.NoError(t, err)
+	defer srv.Close()
+	defer primary.Close()
+
+	ca, err := os.ReadFile(filepath.Join(base, "ca.pem"))
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	subscribe := func(cert tls.Certificate) error {
+		// sent even if not signed by the CA the primary asks for
+		dialer := &net.Dialer{Timeout: time.Second}
+		conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
+			RootCAs:    pool,
+			ServerName: "localhost",
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return &cert, nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := replyMessage(conn, Message{Header: map[string]string{"type": replicateType}}); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err = conn.Read(make([]byte, 1))
+		return err
+	}
+	subscribers := func() int {
+		primary.mu.Lock()
+		defer primary.mu.Unlock()
+		return len(primary.subscribers)
+	}
+
+	t.Run("self-signed certificate", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		template := x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "localhost"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		raw, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+		assert.NoError(t, err)
+
+		err = subscribe(tls.Certificate{Certificate: [][]byte{raw}, PrivateKey: key})
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, os.ErrDeadlineExceeded))
+		assert.Zero(t, subscribers())
+	})
+
+	t.Run("certificate signed by the CA", func(t *testing.T) {
+		cert, err := tls.LoadX509KeyPair(filepath.Join(base, "client.pem"), filepath.Join(base, "client.key"))
+		assert.NoError(t, err)
+
+		// subscribed, so the primary keeps the connection open
+		assert.True(t, errors.Is(subscribe(cert), os.ErrDeadlineExceeded))
+	})
+}
+
+func TestReadOnlyRedirects(t *testing.T) {
+	client := &mockClient{
+		reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+		writer: new(strings.Builder),
+	}
+	ra := NewReadOnly(&mockReadAppender{
+		reader: strings.NewReader(""),
+		writer: new(strings.Builder),
+	}, "primary.example.com:53589")
+
+	Process(client, &mockAuth{}, ra, DefaultOptions())
+
+	resp := parseMsg(t, client.writer.String())
+	assert.Equal(t, "301", resp.Header["code"])
+	assert.Equal(t, "primary.example.com:53589", resp.Header["info"])
+}
+
+func TestPeerName(t *testing.T) {
+	certificates := []*x509.Certificate{{Subject: pkix.Name{CommonName: "replica"}}}
+
+	verified := &peerClient{peer: auth.Peer{Certificates: certificates, Verified: true}}
+	assert.Equal(t, "replica", peerName(verified))
+
+	// trusted blindly by the transport
+	unverified := &peerClient{peer: auth.Peer{Certificates: certificates}}
+	assert.Empty(t, peerName(unverified))
+}
@@ -0,0 +1,236 @@
+package repo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// archiveFile holds the transactions moved out of the user, or group,
+// transactions by the retention.  The first line is the horizon, the newest
+// sync key preceded by archived tasks in the transactions, followed by the
+// archived tasks grouped by the sync key they preceded, each group followed by
+// its key.
+const archiveFile = "archive.data"
+
+// archive moves the given archived transactions, grouped by the sync key they
+// preceded in the kept ones, to the archive of the transactions at path.
+func archive(path string, kept, archived []string) error {
+	if len(archived) == 0 {
+		return nil
+	}
+
+	archivePath := filepath.Join(filepath.Dir(path), archiveFile)
+	horizon, groups, err := readArchive(archivePath)
+	if err != nil {
+		return err
+	}
+
+	positions := keyPositions(kept)
+	for _, line := range archived {
+		line = strings.TrimSuffix(line, "\n")
+		groups = append(groups, line)
+		if isSyncKey(line) {
+			if pos, ok := positions[line]; ok && (horizon == "" || pos > positions[horizon]) {
+				horizon = line
+			}
+		}
+	}
+
+	content := horizon + "\n" + strings.Join(groups, "\n") + "\n"
+	if err := os.WriteFile(archivePath+tempSuffix, []byte(content), 0600); err != nil {
+		return fmt.Errorf("saving archive: %w", err)
+	}
+	if err := os.Rename(archivePath+tempSuffix, archivePath); err != nil {
+		return fmt.Errorf("saving archive: %w", err)
+	}
+	return nil
+}
+
+// Unarchive returns the user transactions with the archived tasks put back in
+// place if a client syncing from the sync key needs them, that is, if the key
+// precedes the archive horizon or is empty, for the full resyncs.  The data is
+// returned unchanged otherwise, the archive isn't read.
+func (ra *DefaultReadAppender) Unarchive(user auth.User, data []string, key string) ([]string, error) {
+	userDir, err := ra.userDir(user)
+	if err != nil {
+		return nil, err
+	}
+	archivePath := filepath.Join(userDir, archiveFile)
+	horizon, err := readHorizon(archivePath)
+	if err != nil || horizon == "" {
+		return data, err
+	}
+
+	if key != "" {
+		positions := keyPositions(data)
+		keyPos, known := positions[key]
+		horizonPos, inData := positions[horizon]
+		if !known || (inData && keyPos >= horizonPos) {
+			return data, nil
+		}
+	}
+
+	_, groups, err := readArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Reading the archive of %q, syncing from %q", user.Name, key)
+	return unarchive(data, groups), nil
+}
+
+// unarchive puts every group of archived tasks back before its sync key.  The
+// groups whose key isn't in the data anymore go first.
+func unarchive(data, groups []string) []string {
+	byKey := make(map[string][]string)
+	var group []string
+	for _, line := range groups {
+		if isSyncKey(line) {
+			byKey[line] = append(byKey[line], group...)
+			group = nil
+		} else {
+			group = append(group, line)
+		}
+	}
+
+	positions := keyPositions(data)
+	var orphans []string
+	for key, lines := range byKey {
+		if _, ok := positions[key]; !ok {
+			orphans = append(orphans, lines...)
+		}
+	}
+
+	merged := make([]string, 0, len(data)+len(groups))
+	merged = append(merged, orphans...)
+	for _, line := range data {
+		if isSyncKey(line) {
+			merged = append(merged, byKey[line]...)
+		}
+		merged = append(merged, line)
+	}
+	return merged
+}
+
+// readHorizon returns the horizon of an archive, empty if there's none.
+func readHorizon(path string) (string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("loading archive: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	horizon, err := reader.ReadString('\n')
+	if err != nil && horizon == "" {
+		return "", nil
+	}
+	return strings.TrimSpace(horizon), nil
+}
+
+// readArchive returns the horizon and the archived lines of an archive, none
+// if it doesn't exist.
+func readArchive(path string) (string, []string, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil, nil
+	} else if err != nil {
+		return "", nil, fmt.Errorf("loading archive: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	return lines[0], lines[1:], nil
+}
+
+// keyPositions returns the position of every sync key in the transactions.
+func keyPositions(lines []string) map[string]int {
+	positions := make(map[string]int)
+	for i, line := range lines {
+		if line = strings.TrimSuffix(line, "\n"); isSyncKey(line) {
+			positions[line] = i
+		}
+	}
+	return positions
+}
+
+// isSyncKey returns true if the transactions line is a sync key.
+func isSyncKey(line string) bool {
+	return line != "" && !strings.HasPrefix(line, "{") && !isBlobRef(line)
+}
+
+// ArchiveUsage is the size, in bytes, of the transactions of a user, or a
+// group, and of its archive.
+type ArchiveUsage struct {
+	Org     string `json:"org"`
+	User    string `json:"user,omitempty"`
+	Group   string `json:"group,omitempty"`
+	Tx      int64  `json:"tx"`
+	Archive int64  `json:"archive"`
+}
+
+// ArchiveStatus returns the transactions and archive sizes of the users and
+// groups of the repository located in dataDir, only the ones of the given
+// organization unless it's empty.
+func ArchiveStatus(dataDir, orgName string) ([]ArchiveUsage, error) {
+	roots, err := LoadRoots(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("archive status: %w", err)
+	}
+
+	usages := make([]ArchiveUsage, 0)
+	for _, dir := range roots.orgsDirs() {
+		orgs, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("archive status: %w", err)
+		}
+		for _, org := range orgs {
+			if !org.IsDir() || (orgName != "" && org.Name() != orgName) {
+				continue
+			}
+			for _, folder := range []string{usersFolder, groupsFolder} {
+				owners, err := os.ReadDir(filepath.Join(dir, org.Name(), folder))
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				} else if err != nil {
+					return nil, fmt.Errorf("archive status: %w", err)
+				}
+				for _, owner := range owners {
+					if !owner.IsDir() {
+						continue
+					}
+					usage := ArchiveUsage{Org: org.Name()}
+					if folder == usersFolder {
+						usage.User = owner.Name()
+					} else {
+						usage.Group = owner.Name()
+					}
+					ownerDir := filepath.Join(dir, org.Name(), folder, owner.Name())
+					usage.Tx, usage.Archive = fileSize(filepath.Join(ownerDir, txFile)), fileSize(filepath.Join(ownerDir, archiveFile))
+					if usage.Tx > 0 || usage.Archive > 0 {
+						usages = append(usages, usage)
+					}
+				}
+			}
+		}
+	}
+	return usages, nil
+}
+
+// fileSize returns the size of a file, zero if it doesn't exist.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,103 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestArchive(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	const key = "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7"
+	lines := []string{
+		`{"uuid":"old-completed","status":"pending","modified":"20200101T000000Z"}`,
+		`{"uuid":"pending","status":"pending","modified":"20200101T000000Z"}`,
+		"0f4c4ba0-3c5a-4cd1-a8e4-36b8ba3b7d1e",
+		`{"uuid":"old-completed","status":"completed","end":"20200102T000000Z","modified":"20200102T000000Z"}`,
+		`{"uuid":"old-deleted","status":"deleted","end":"20200102T000000Z"}`,
+		"5b1b7ea0-7e8c-4d39-9b04-0b4bd5e2a6a1",
+		`{"uuid":"pending","status":"pending","modified":"20200103T000000Z"}`,
+		"b1a4b0a8-69e3-4f0c-9c4a-3f1f1e3d6c2d",
+		// after the last sync key, can't be archived yet
+		`{"uuid":"old-deleted","status":"deleted","end":"20200104T000000Z"}`,
+	}
+
+	userDir := filepath.Join(tempRepo, orgsFolder, "Public", usersFolder, key)
+	path := filepath.Join(userDir, txFile)
+	assert.Nil(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+
+	retention := Retention{Completed: 24 * time.Hour, Deleted: 24 * time.Hour, Archive: true}
+
+	t.Run("check only", func(t *testing.T) {
+		expired, err := EnforceRetention(tempRepo, retention, true)
+		assert.Nil(t, err)
+		assert.Equal(t, []Expired{{Path: path, Completed: 1, Deleted: 1, Lines: 3, Archived: true}}, expired)
+		assert.Equal(t, path+": would archive 1 completed and 1 deleted task(s), 3 line(s)", expired[0].String())
+		assert.NoFileExists(t, filepath.Join(userDir, archiveFile))
+	})
+
+	t.Run("moves the expired tasks", func(t *testing.T) {
+		expired, err := EnforceRetention(tempRepo, retention, false)
+		assert.Nil(t, err)
+		assert.Equal(t, []Expired{{Path: path, Completed: 1, Deleted: 1, Lines: 3, Archived: true, Done: true}}, expired)
+
+		stored, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, strings.Join([]string{
+			lines[1], lines[2], lines[5], lines[6], lines[7], lines[8],
+		}, "\n")+"\n", string(stored))
+
+		archived, err := os.ReadFile(filepath.Join(userDir, archiveFile))
+		assert.Nil(t, err)
+		assert.Equal(t, strings.Join([]string{
+			lines[5], lines[0], lines[2], lines[3], lines[4], lines[5],
+		}, "\n")+"\n", string(archived))
+	})
+
+	t.Run("reads the archive only if needed", func(t *testing.T) {
+		user := auth.User{Name: "noeh", Key: key, Org: &auth.Organization{Name: "Public"}}
+		ra := NewDefaultReadAppender(tempRepo)
+		data, err := ra.Read(user)
+		assert.Nil(t, err)
+
+		for _, syncKey := range []string{lines[5], lines[7], "unknown"} {
+			unarchived, err := ra.Unarchive(user, data, syncKey)
+			assert.Nil(t, err)
+			assert.Equal(t, data, unarchived, syncKey)
+		}
+
+		expected := []string{lines[1], lines[0], lines[2], lines[3], lines[4], lines[5], lines[6], lines[7], lines[8]}
+		for _, syncKey := range []string{lines[2], ""} {
+			unarchived, err := ra.Unarchive(user, data, syncKey)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, unarchived, syncKey)
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		usages, err := ArchiveStatus(tempRepo, "Public")
+		assert.Nil(t, err)
+
+		var found bool
+		for _, u := range usages {
+			if u.User == key {
+				found = true
+				assert.Equal(t, fileSize(path), u.Tx)
+				assert.Equal(t, fileSize(filepath.Join(userDir, archiveFile)), u.Archive)
+			}
+		}
+		assert.True(t, found)
+
+		usages, err = ArchiveStatus(tempRepo, "invalid")
+		assert.Nil(t, err)
+		assert.Empty(t, usages)
+	})
+}
@@ -17,15 +17,35 @@ func NewDefaultAuthenticator(rootDir string) (*DefaultAuthenticator, error) {
 	return &DefaultAuthenticator{repo}, nil
 }
 
-// Authenticate verifies that the given organiozation-user-key is valid.
+// Authenticate verifies that the given organiozation-user-key is valid.  The
+// organization is looked up in the repository cache, so the users added or
+// changed by other processes are seen as soon as they are saved.
 func (a *DefaultAuthenticator) Authenticate(orgName, userName, key string) (auth.User, error) {
-	org, err := a.repo.GetOrg(orgName)
+	org, err := a.repo.cachedOrg(orgName)
 	if err != nil {
 		return auth.User{}, auth.AuthenticationError{Code: "400", Msg: "Invalid org"}
 	}
 
+	return authenticate(org, userName, key)
+}
+
+// Reload drops the organizations cached by the authenticator, see
+// Repository.Reload.
+func (a *DefaultAuthenticator) Reload() {
+	a.repo.Reload()
+}
+
+// authenticate looks for the user in the organization, rejecting the deleted
+// and suspended ones.
+func authenticate(org *auth.Organization, userName, key string) (auth.User, error) {
 	for _, u := range org.Users {
 		if u.Key == key && u.Name == userName {
+			if !org.Deleted.IsZero() || !u.Deleted.IsZero() {
+				return auth.User{}, auth.AuthenticationError{Code: "432", Msg: "Account terminated"}
+			}
+			if !u.Suspended.IsZero() {
+				return auth.User{}, auth.AuthenticationError{Code: "431", Msg: "Account suspended"}
+			}
 			return u, nil
 		}
 	}
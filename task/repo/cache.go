@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	gosync "sync"
+
+	"github.com/google/uuid"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// changesFile holds a stamp rewritten on every change of the organizations
+// and users, so the processes sharing the repository (e.g. a running server
+// and the CLI) notice the changes made by the others.
+const changesFile = "changes"
+
+// orgCache keeps the organizations already loaded, and the owners of the
+// calendar tokens, until the changes stamp of the repository moves or it's
+// reloaded.
+type orgCache struct {
+	mu     gosync.Mutex
+	stamp  string
+	orgs   map[string]*auth.Organization
+	tokens map[string]calendarOwner
+}
+
+func newOrgCache() *orgCache {
+	return &orgCache{orgs: make(map[string]*auth.Organization)}
+}
+
+// cachedOrg returns the given organization, loading it only if it isn't
+// cached or the repository changed since it was.  The organization returned
+// is shared, so it must not be modified.
+func (r *Repository) cachedOrg(orgName string) (*auth.Organization, error) {
+	r.cache.mu.Lock()
+	defer r.cache.mu.Unlock()
+
+	r.cache.refresh(r.baseDir)
+	if org, ok := r.cache.orgs[orgName]; ok {
+		return org, nil
+	}
+
+	org, err := r.loadOrg(orgName)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.orgs[orgName] = org
+
+	return org, nil
+}
+
+// Reload drops the organizations and users loaded so far, so the next lookups
+// read them again.  The changes made through a Repository are noticed
+// without it, it's only needed after editing the files by hand.
+func (r *Repository) Reload() {
+	r.cache.mu.Lock()
+	defer r.cache.mu.Unlock()
+
+	r.cache.stamp = ""
+	r.cache.orgs = make(map[string]*auth.Organization)
+	r.cache.tokens = nil
+	forgetRoots(r.baseDir)
+}
+
+// refresh drops everything cached if the changes stamp of the repository
+// located in dataDir moved.  It must be called holding mu.
+func (c *orgCache) refresh(dataDir string) {
+	if stamp := readStamp(dataDir); stamp != c.stamp {
+		c.stamp = stamp
+		c.orgs = make(map[string]*auth.Organization)
+		c.tokens = nil
+	}
+}
+
+// changed moves the changes stamp of the repository, invalidating the
+// organizations cached by every process.
+func (r *Repository) changed() {
+	path := filepath.Join(r.baseDir, changesFile)
+	if err := os.WriteFile(path, []byte(uuid.New().String()+"\n"), 0644); err != nil {
+		log.Warnf("Recording the repository change: %v", err)
+		r.Reload()
+	}
+}
+
+// readStamp returns the changes stamp of the repository, empty if it never
+// changed.
+func readStamp(dataDir string) string {
+	stamp, err := os.ReadFile(filepath.Join(dataDir, changesFile))
+	if err != nil {
+		return ""
+	}
+	return string(stamp)
+}
@@ -0,0 +1,71 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrgCache(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	authenticator, err := NewDefaultAuthenticator(tempRepo)
+	require.Nil(t, err)
+	admin, err := OpenRepository(tempRepo)
+	require.Nil(t, err)
+
+	t.Run("sees the users added by other processes", func(t *testing.T) {
+		_, err := authenticator.Authenticate("Public", "noeh", "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7")
+		require.Nil(t, err)
+
+		user, err := admin.AddUser("Public", "alice")
+		require.Nil(t, err)
+
+		authenticated, err := authenticator.Authenticate("Public", "alice", user.Key)
+		assert.Nil(t, err)
+		assert.Equal(t, "alice", authenticated.Name)
+	})
+
+	t.Run("sees the users deleted by other processes", func(t *testing.T) {
+		require.Nil(t, admin.DelUser("Public", "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7"))
+
+		_, err := authenticator.Authenticate("Public", "noeh", "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("sees the organizations added by other processes", func(t *testing.T) {
+		before := len(authenticator.repo.Orgs())
+		_, err := admin.NewOrg("Other")
+		require.Nil(t, err)
+
+		assert.Equal(t, before+1, len(authenticator.repo.Orgs()))
+	})
+
+	t.Run("sees the manual changes once reloaded", func(t *testing.T) {
+		userDir := filepath.Join(tempRepo, orgsFolder, "Other", usersFolder, "manual-key")
+		require.Nil(t, os.Mkdir(userDir, 0755))
+		require.Nil(t, os.WriteFile(filepath.Join(userDir, "config"), []byte("user=manual\n"), 0644))
+
+		_, err := authenticator.Authenticate("Other", "manual", "manual-key")
+		assert.NotNil(t, err)
+
+		authenticator.Reload()
+		_, err = authenticator.Authenticate("Other", "manual", "manual-key")
+		assert.Nil(t, err)
+	})
+
+	t.Run("returns copies of the cached organizations", func(t *testing.T) {
+		org, err := admin.GetOrg("Public")
+		require.Nil(t, err)
+		org.Users[0].Name = "changed"
+
+		org, err = admin.GetOrg("Public")
+		require.Nil(t, err)
+		assert.NotEqual(t, "changed", org.Users[0].Name)
+	})
+}
@@ -0,0 +1,136 @@
+package repo
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/szaffarano/gotas/config"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// calendarTokenKey is the user configuration entry holding the token used to
+// access the user calendar.
+const calendarTokenKey = "calendar.token"
+
+// NewCalendarToken generates a new token to access the user calendar,
+// invalidating the previous one.
+func (r *Repository) NewCalendarToken(orgName, userKey string) (string, error) {
+	var random [16]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return "", fmt.Errorf("generating calendar token: %w", err)
+	}
+	token := hex.EncodeToString(random[:])
+
+	if err := r.setUserConfig(orgName, userKey, calendarTokenKey, token); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RevokeCalendarToken disables the access to the user calendar.
+func (r *Repository) RevokeCalendarToken(orgName, userKey string) error {
+	return r.setUserConfig(orgName, userKey, calendarTokenKey, "")
+}
+
+// UserByCalendarToken returns the user owning the given calendar token,
+// unless it's deleted or suspended.  The tokens are indexed the first time
+// they are looked up, and indexed again once the repository changes, so the
+// tokens created while the server is running are taken into account.
+func (r *Repository) UserByCalendarToken(token string) (auth.User, error) {
+	invalid := fmt.Errorf("invalid calendar token")
+	if token == "" {
+		return auth.User{}, invalid
+	}
+
+	owner, ok, err := r.calendarOwner(token)
+	if err != nil {
+		return auth.User{}, err
+	} else if !ok {
+		return auth.User{}, invalid
+	}
+
+	org, err := r.GetOrg(owner.org)
+	if errors.Is(err, ErrOrgNotFound) {
+		return auth.User{}, invalid
+	} else if err != nil {
+		return auth.User{}, err
+	} else if !org.Deleted.IsZero() {
+		return auth.User{}, invalid
+	}
+	for _, user := range org.Users {
+		if user.Key == owner.key && user.Deleted.IsZero() && user.Suspended.IsZero() {
+			return user, nil
+		}
+	}
+
+	return auth.User{}, invalid
+}
+
+// calendarOwner identifies the user owning a calendar token.
+type calendarOwner struct {
+	org string
+	key string
+}
+
+// calendarOwner looks up the owner of the calendar token in the index,
+// building it if the repository changed since it was.
+func (r *Repository) calendarOwner(token string) (calendarOwner, bool, error) {
+	r.cache.mu.Lock()
+	defer r.cache.mu.Unlock()
+
+	r.cache.refresh(r.baseDir)
+	if r.cache.tokens == nil {
+		tokens, err := r.loadCalendarTokens()
+		if err != nil {
+			return calendarOwner{}, false, err
+		}
+		r.cache.tokens = tokens
+	}
+
+	owner, ok := r.cache.tokens[hashCalendarToken(token)]
+	return owner, ok, nil
+}
+
+// loadCalendarTokens reads the calendar tokens of every user, indexed by
+// their hashes.
+func (r *Repository) loadCalendarTokens() (map[string]calendarOwner, error) {
+	roots, err := LoadRoots(r.baseDir)
+	if err != nil {
+		return nil, err
+	}
+	orgNames, err := roots.orgNames()
+	if err != nil {
+		return nil, fmt.Errorf("indexing calendar tokens: %w", err)
+	}
+
+	tokens := make(map[string]calendarOwner)
+	for _, orgName := range orgNames {
+		configs, err := filepath.Glob(filepath.Join(roots.OrgDir(orgName), usersFolder, "*", "config"))
+		if err != nil {
+			return nil, fmt.Errorf("indexing calendar tokens: %w", err)
+		}
+		for _, path := range configs {
+			cfg, err := config.Load(path)
+			if err != nil {
+				continue
+			}
+			if token := cfg.Get(calendarTokenKey); token != "" {
+				tokens[hashCalendarToken(token)] = calendarOwner{org: orgName, key: filepath.Base(filepath.Dir(path))}
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+// hashCalendarToken returns the key a token is indexed by, hashed so looking
+// it up doesn't tell how much of a guessed token matches.
+func hashCalendarToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
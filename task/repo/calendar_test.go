@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/config"
+)
+
+func TestCalendarTokens(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	repo, err := OpenRepository(tempRepo)
+	assert.Nil(t, err)
+
+	const noeh = "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7"
+
+	_, err = repo.NewCalendarToken("Public", "invalid")
+	assert.Error(t, err)
+
+	token, err := repo.NewCalendarToken("Public", noeh)
+	assert.NoError(t, err)
+	assert.Len(t, token, 32)
+
+	user, err := repo.UserByCalendarToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "noeh", user.Name)
+	assert.Equal(t, noeh, user.Key)
+	assert.Equal(t, "Public", user.Org.Name)
+
+	_, err = repo.UserByCalendarToken("")
+	assert.Error(t, err)
+
+	newToken, err := repo.NewCalendarToken("Public", noeh)
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, newToken)
+	_, err = repo.UserByCalendarToken(token)
+	assert.Error(t, err)
+
+	assert.NoError(t, repo.SuspendUser("Public", noeh))
+	_, err = repo.UserByCalendarToken(newToken)
+	assert.Error(t, err)
+	assert.NoError(t, repo.ResumeUser("Public", noeh))
+	_, err = repo.UserByCalendarToken(newToken)
+	assert.NoError(t, err)
+
+	assert.NoError(t, repo.RevokeCalendarToken("Public", noeh))
+	_, err = repo.UserByCalendarToken(newToken)
+	assert.Error(t, err)
+
+	// the tokens edited by hand are indexed once reloaded
+	configPath := filepath.Join(tempRepo, orgsFolder, "Public", usersFolder, noeh, "config")
+	cfg, err := config.Load(configPath)
+	assert.NoError(t, err)
+	cfg.Set(calendarTokenKey, "edited")
+	assert.NoError(t, config.Save(cfg))
+	_, err = repo.UserByCalendarToken("edited")
+	assert.Error(t, err)
+	repo.Reload()
+	user, err = repo.UserByCalendarToken("edited")
+	assert.NoError(t, err)
+	assert.Equal(t, noeh, user.Key)
+}
@@ -0,0 +1,168 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	gosync "sync"
+	"time"
+)
+
+// pendingAppend is an append written to its temporary file, waiting to be
+// made durable and to replace the transactions file.
+type pendingAppend struct {
+	file  *os.File
+	temp  string
+	final string
+	err   error
+}
+
+// commitAppends flushes the temporary files to disk, replaces the
+// transactions files with them and flushes their directories, so the appends
+// survive a crash once it returns.  A crash before that leaves either the
+// previous transactions file or the temporary one, recovered by the garbage
+// collection.  The errors are set on every append.  The files and the
+// directories are flushed together by syncAll, once per filesystem where
+// it's supported.
+func commitAppends(batch []*pendingAppend) {
+	files := make([]*os.File, 0, len(batch))
+	for _, p := range batch {
+		files = append(files, p.file)
+	}
+	for i, err := range syncAll(files) {
+		batch[i].err = err
+	}
+	for _, p := range batch {
+		if err := p.file.Close(); err != nil && p.err == nil {
+			p.err = err
+		}
+	}
+
+	dirs := make(map[string][]*pendingAppend)
+	for _, p := range batch {
+		if p.err != nil {
+			continue
+		}
+		if err := os.Rename(p.temp, p.final); err != nil {
+			p.err = err
+			continue
+		}
+		dir := filepath.Dir(p.final)
+		dirs[dir] = append(dirs[dir], p)
+	}
+
+	var opened []*os.File
+	var waiting [][]*pendingAppend
+	for dir, appends := range dirs {
+		d, err := os.Open(dir)
+		if err != nil {
+			for _, p := range appends {
+				p.err = err
+			}
+			continue
+		}
+		opened = append(opened, d)
+		waiting = append(waiting, appends)
+	}
+	for i, err := range syncAll(opened) {
+		opened[i].Close()
+		if err != nil {
+			for _, p := range waiting[i] {
+				p.err = err
+			}
+		}
+	}
+}
+
+// fileSync flushes a file to disk, replaced by the tests to count the
+// flushes.
+var fileSync = (*os.File).Sync
+
+// syncDir flushes the entries of a directory to disk.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// groupCommit commits the appends of concurrent syncs together: the first
+// append of a batch waits the window for others to join, and all of them are
+// flushed at once.  An append waits at most the window plus the flush.
+type groupCommit struct {
+	window time.Duration
+
+	mu      gosync.Mutex
+	pending *commitBatch
+}
+
+// commitBatch are the appends committed together, done is closed once they
+// are.
+type commitBatch struct {
+	appends []*pendingAppend
+	done    chan struct{}
+}
+
+func (g *groupCommit) commit(p *pendingAppend) error {
+	g.mu.Lock()
+	batch := g.pending
+	if batch == nil {
+		batch = &commitBatch{done: make(chan struct{})}
+		g.pending = batch
+		time.AfterFunc(g.window, func() { g.flush(batch) })
+	}
+	batch.appends = append(batch.appends, p)
+	g.mu.Unlock()
+
+	<-batch.done
+	return p.err
+}
+
+func (g *groupCommit) flush(batch *commitBatch) {
+	// the appends arriving from now on start a new batch
+	g.mu.Lock()
+	g.pending = nil
+	g.mu.Unlock()
+
+	commitAppends(batch.appends)
+	close(batch.done)
+}
+
+// keyedMutex is a mutex per key, e.g. the path of a file.  The zero value is
+// ready to use, and the locks no longer held are dropped.
+type keyedMutex struct {
+	mu    gosync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	gosync.Mutex
+	refs int
+}
+
+// lock locks the key until the returned function is called.
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		k.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
@@ -0,0 +1,71 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitAppendsSyncs(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+
+	var files, filesystems int
+	defer func(file, filesystem func(*os.File) error) {
+		fileSync, filesystemSync = file, filesystem
+	}(fileSync, filesystemSync)
+	fileSync = func(*os.File) error { files++; return nil }
+	filesystemSync = func(*os.File) error { filesystems++; return nil }
+
+	pending := func(t *testing.T, count int) []*pendingAppend {
+		t.Helper()
+
+		var batch []*pendingAppend
+		for i := 0; i < count; i++ {
+			dir := filepath.Join(tempRepo, fmt.Sprintf("user%d", i))
+			assert.NoError(t, os.MkdirAll(dir, 0700))
+			file, err := os.Create(filepath.Join(dir, txFileTemp))
+			assert.NoError(t, err)
+			batch = append(batch, &pendingAppend{file: file, temp: file.Name(), final: filepath.Join(dir, txFile)})
+		}
+		return batch
+	}
+
+	t.Run("single append", func(t *testing.T) {
+		files, filesystems = 0, 0
+		batch := pending(t, 1)
+		commitAppends(batch)
+		assert.NoError(t, batch[0].err)
+		assert.FileExists(t, batch[0].final)
+
+		// the file and its directory
+		assert.Equal(t, 2, files)
+		assert.Zero(t, filesystems)
+	})
+
+	t.Run("batched appends", func(t *testing.T) {
+		files, filesystems = 0, 0
+		batch := pending(t, 5)
+		commitAppends(batch)
+		for _, p := range batch {
+			assert.NoError(t, p.err)
+			assert.FileExists(t, p.final)
+		}
+
+		// the files and then the directories, all in the same filesystem
+		assert.Zero(t, files)
+		assert.Equal(t, 2, filesystems)
+	})
+}
+
+func TestFilesystemSync(t *testing.T) {
+	file, err := os.CreateTemp("", "syncfs")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	assert.NoError(t, filesystemSync(file))
+}
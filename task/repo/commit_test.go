@@ -0,0 +1,132 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestGroupCommit(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+
+	repo, err := NewRepository(tempRepo, nil)
+	assert.NoError(t, err)
+	_, err = repo.NewOrg("Public")
+	assert.NoError(t, err)
+
+	var users []auth.User
+	for i := 0; i < 5; i++ {
+		user, err := repo.AddUser("Public", fmt.Sprintf("user%d", i))
+		assert.NoError(t, err)
+		users = append(users, *user)
+	}
+
+	t.Run("concurrent appends", func(t *testing.T) {
+		ra := NewGroupCommitReadAppender(tempRepo, 20*time.Millisecond)
+
+		var wg gosync.WaitGroup
+		for _, user := range users {
+			wg.Add(1)
+			go func(user auth.User) {
+				defer wg.Done()
+				assert.NoError(t, ra.Append(user, []string{user.Name + "\n"}))
+			}(user)
+		}
+		wg.Wait()
+
+		// every batch is flushed and the next one starts empty
+		assert.Nil(t, ra.group.pending)
+		for _, user := range users {
+			data, err := ra.Read(user)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{user.Name}, data)
+		}
+	})
+
+	t.Run("not replaced until committed", func(t *testing.T) {
+		ra := NewGroupCommitReadAppender(tempRepo, 500*time.Millisecond)
+		user := users[0]
+		dir, err := ra.userDir(user)
+		assert.NoError(t, err)
+
+		done := make(chan error)
+		go func() {
+			done <- ra.Append(user, []string{"pending\n"})
+		}()
+
+		// a crash now leaves the previous transactions and the complete
+		// temporary file, recovered by the garbage collection
+		assert.Eventually(t, func() bool {
+			ra.group.mu.Lock()
+			defer ra.group.mu.Unlock()
+			return ra.group.pending != nil
+		}, time.Second, time.Millisecond)
+		data, err := ra.Read(user)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{user.Name}, data)
+		temps, err := filepath.Glob(filepath.Join(dir, txFileTemp+"*"))
+		assert.NoError(t, err)
+		if assert.Len(t, temps, 1) {
+			temp, err := os.ReadFile(temps[0])
+			assert.NoError(t, err)
+			assert.Equal(t, user.Name+"\npending\n", string(temp))
+		}
+
+		assert.NoError(t, <-done)
+
+		data, err = ra.Read(user)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{user.Name, "pending"}, data)
+		temps, err = filepath.Glob(filepath.Join(dir, txFileTemp+"*"))
+		assert.NoError(t, err)
+		assert.Empty(t, temps)
+	})
+
+	t.Run("concurrent appends to the same user", func(t *testing.T) {
+		ra := NewGroupCommitReadAppender(tempRepo, 20*time.Millisecond)
+		user := users[3]
+
+		var wg gosync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				assert.NoError(t, ra.Append(user, []string{fmt.Sprintf("line%d\n", i)}))
+			}(i)
+		}
+		wg.Wait()
+
+		data, err := ra.Read(user)
+		assert.NoError(t, err)
+		assert.Len(t, data, 11)
+		for i := 0; i < 10; i++ {
+			assert.Contains(t, data, fmt.Sprintf("line%d", i))
+		}
+		assert.Empty(t, ra.appends.locks)
+	})
+
+	t.Run("failed commit", func(t *testing.T) {
+		ra := NewGroupCommitReadAppender(tempRepo, 10*time.Millisecond)
+		user := users[1]
+
+		// the rename fails replacing a directory
+		dir, err := ra.userDir(user)
+		assert.NoError(t, err)
+		final := filepath.Join(dir, txFile)
+		assert.NoError(t, os.Remove(final))
+		assert.NoError(t, os.MkdirAll(filepath.Join(final, "blocked"), 0700))
+		defer os.RemoveAll(final)
+
+		file, err := os.Create(filepath.Join(dir, txFileTemp))
+		assert.NoError(t, err)
+		assert.Error(t, ra.group.commit(&pendingAppend{file: file, temp: file.Name(), final: final}))
+		assert.NoError(t, ra.Append(users[2], []string{"after\n"}))
+	})
+}
@@ -8,7 +8,10 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/szaffarano/gotas/task/auth"
 )
 
@@ -16,44 +19,124 @@ import (
 // simple fylesystem structure
 type DefaultReadAppender struct {
 	baseDir string
+	// dedup stores the tasks appended in the organization blob store,
+	// referenced by the transactions.  The references are always resolved
+	// on read.
+	dedup bool
+	// group batches the commits of the concurrent appends, each append is
+	// committed on its own if nil.
+	group *groupCommit
+	// appends locks the transactions files being appended.
+	appends keyedMutex
 }
 
-// NewDefaultReadAppender creates a new ReadAppender
+// NewDefaultReadAppender creates a new ReadAppender, deduplicating the tasks
+// if enabled in the repository configuration.
 func NewDefaultReadAppender(baseDir string) *DefaultReadAppender {
-	return &DefaultReadAppender{baseDir}
+	return &DefaultReadAppender{baseDir: baseDir, dedup: dedupEnabled(baseDir)}
+}
+
+// NewGroupCommitReadAppender creates a ReadAppender like
+// NewDefaultReadAppender does, but committing the appends of the concurrent
+// syncs together, every window, to flush the disk once for all of them.  On
+// other systems than Linux every file is still flushed on its own.
+func NewGroupCommitReadAppender(baseDir string, window time.Duration) *DefaultReadAppender {
+	ra := NewDefaultReadAppender(baseDir)
+	ra.group = &groupCommit{window: window}
+	return ra
+}
+
+// Storage names the storage backend, "filesystem" or "filesystem+dedup" if
+// the tasks are deduplicated.
+func (ra *DefaultReadAppender) Storage() string {
+	if ra.dedup {
+		return "filesystem+dedup"
+	}
+	return "filesystem"
 }
 
 type source string
 
+// userDir returns the directory holding the user transactions, which is
+// shared by all the members when the user belongs to a group.
+func (ra *DefaultReadAppender) userDir(user auth.User) (string, error) {
+	dir, err := orgDir(ra.baseDir, user.Org.Name)
+	if err != nil {
+		return "", err
+	}
+	if user.Group != "" {
+		return filepath.Join(dir, groupsFolder, user.Group), nil
+	}
+	return filepath.Join(dir, usersFolder, user.Key), nil
+}
+
 // Read returns all the transaction information belonging to the given user.
 func (ra *DefaultReadAppender) Read(user auth.User) ([]string, error) {
 	var file *os.File
-	var err error
-	txFile := filepath.Join(ra.baseDir, orgsFolder, user.Org.Name, usersFolder, user.Key, txFile)
+	userDir, err := ra.userDir(user)
+	if err != nil {
+		return nil, err
+	}
+	txFile := filepath.Join(userDir, txFile)
 	data := make([]string, 0, 50)
 
 	if file, err = os.OpenFile(txFile, os.O_RDWR|os.O_CREATE, 0600); err != nil {
-		return nil, fmt.Errorf("open tx file: %v", err)
+		return nil, fmt.Errorf("open tx file: %w", err)
 	}
 	defer file.Close()
 
+	var blobs blobStore
+	resolved := make(map[string]string)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		data = append(data, scanner.Text())
+		line := scanner.Text()
+		if isBlobRef(line) {
+			if blobs == "" {
+				dir, err := orgDir(ra.baseDir, user.Org.Name)
+				if err != nil {
+					return nil, err
+				}
+				blobs = orgBlobs(dir)
+			}
+			task, ok := resolved[line]
+			if !ok {
+				if task, err = blobs.get(line); err != nil {
+					return nil, err
+				}
+				resolved[line] = task
+			}
+			line = task
+		}
+		data = append(data, line)
 	}
 
 	return data, nil
 }
 
-// Append add data at the end of the transaction user database.
+// Append add data at the end of the transaction user database.  The appends
+// to the same transactions are serialized from the copy to the rename, so
+// none of them is lost, each one writing its own temporary file.
 func (ra *DefaultReadAppender) Append(user auth.User, data []string) error {
-	txFilePath := filepath.Join(ra.baseDir, orgsFolder, user.Org.Name, usersFolder, user.Key, txFile)
-	txFileTempPath := filepath.Join(ra.baseDir, orgsFolder, user.Org.Name, usersFolder, user.Key, txFileTemp)
+	orgPath, err := orgDir(ra.baseDir, user.Org.Name)
+	if err != nil {
+		return err
+	}
+	userDir, err := ra.userDir(user)
+	if err != nil {
+		return err
+	}
+	txFilePath := filepath.Join(userDir, txFile)
+	txFileTempPath := filepath.Join(userDir, txFileTemp+"."+uuid.New().String())
 	var file *os.File
 
+	unlock := ra.appends.lock(txFilePath)
+	defer unlock()
+	// a no-op once committed, the file is renamed
+	defer os.Remove(txFileTempPath)
+
 	if _, err := os.Stat(txFilePath); errors.Is(err, fs.ErrNotExist) {
 		if file, err = os.OpenFile(txFileTempPath, os.O_RDWR|os.O_CREATE, 0600); err != nil {
-			return fmt.Errorf("open tx file: %v", err)
+			return fmt.Errorf("open tx file: %w", err)
 		}
 	} else {
 		if err := (source(txFilePath)).copy(txFileTempPath); err != nil {
@@ -61,27 +144,31 @@ func (ra *DefaultReadAppender) Append(user auth.User, data []string) error {
 		}
 
 		if file, err = os.OpenFile(txFileTempPath, os.O_RDWR|os.O_APPEND, 0600); err != nil {
-			return fmt.Errorf("open tx file: %v", err)
+			return fmt.Errorf("open tx file: %w", err)
 		}
 	}
 	defer file.Close()
 
+	blobs := orgBlobs(orgPath)
 	for _, line := range data {
+		if task := strings.TrimSuffix(line, "\n"); ra.dedup && strings.HasPrefix(task, "{") {
+			ref, err := blobs.put(task)
+			if err != nil {
+				return err
+			}
+			line = ref + line[len(task):]
+		}
 		if _, err := file.Write([]byte(line)); err != nil {
 			return err
 		}
 	}
 
-	// close the file before rename it
-	if err := file.Close(); err != nil {
-		return err
-	}
-
-	if err := os.Rename(txFileTempPath, txFilePath); err != nil {
-		return err
+	pending := &pendingAppend{file: file, temp: txFileTempPath, final: txFilePath}
+	if ra.group != nil {
+		return ra.group.commit(pending)
 	}
-
-	return nil
+	commitAppends([]*pendingAppend{pending})
+	return pending.err
 }
 
 func (s source) copy(dst string) error {
@@ -26,13 +26,14 @@ func TestGetData(t *testing.T) {
 }
 
 func TestAppendData(t *testing.T) {
-	auth := validAuthenticator(t)
-	ra := validReadAppender(t)
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
 
-	defer func() {
-		tx := filepath.Join("testdata", "repo_one", orgsFolder, "Public", usersFolder, "f793325d-c0d4-4f11-91d3-1388a02e727c", txFile)
-		assert.NoError(t, os.Remove(tx))
-	}()
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	auth, err := NewDefaultAuthenticator(tempRepo)
+	assert.NoError(t, err)
+	ra := NewDefaultReadAppender(tempRepo)
 
 	user, err := auth.Authenticate("Public", "john", "f793325d-c0d4-4f11-91d3-1388a02e727c")
 	assert.Nil(t, err)
@@ -0,0 +1,220 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/szaffarano/gotas/config"
+)
+
+const (
+	// dedupKey is the repository configuration entry enabling the tasks
+	// deduplication.  The transactions stored afterwards reference the tasks
+	// by their hash, every distinct task is stored once per organization.
+	dedupKey = "storage.dedup"
+
+	// blobsFolder is the organization folder holding the deduplicated tasks.
+	blobsFolder = "blobs"
+
+	// blobPrefix prefixes the transactions lines referencing a deduplicated
+	// task, followed by the task hash.
+	blobPrefix = "sha256:"
+)
+
+// blobStore keeps the deduplicated tasks of an organization, every one in a
+// file named after its hash.
+type blobStore string
+
+// orgBlobs returns the blob store of the organization directory orgDir.
+func orgBlobs(orgDir string) blobStore {
+	return blobStore(filepath.Join(orgDir, blobsFolder))
+}
+
+// txBlobs returns the blob store referenced by the transactions file at
+// path, stored in the users or groups folder of an organization.
+func txBlobs(path string) blobStore {
+	return orgBlobs(filepath.Dir(filepath.Dir(filepath.Dir(path))))
+}
+
+// isBlobRef returns true if the transactions line references a deduplicated
+// task.
+func isBlobRef(line string) bool {
+	return strings.HasPrefix(line, blobPrefix)
+}
+
+func (b blobStore) path(hash string) string {
+	return filepath.Join(string(b), hash[:2], hash)
+}
+
+// put stores the task, unless already stored, returning the line that
+// references it.  The blob is flushed to disk before, so a transactions file
+// committed afterwards never references a missing one.
+func (b blobStore) put(task string) (string, error) {
+	sum := sha256.Sum256([]byte(task))
+	hash := hex.EncodeToString(sum[:])
+	path := b.path(hash)
+
+	if _, err := os.Stat(path); err == nil {
+		return blobPrefix + hash, nil
+	}
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("creating blobs dir: %w", err)
+		}
+		// the new directories must survive a crash too
+		for _, parent := range []string{string(b), filepath.Dir(string(b))} {
+			if err := syncDir(parent); err != nil {
+				return "", fmt.Errorf("creating blobs dir: %w", err)
+			}
+		}
+	}
+
+	// concurrent appends storing the same task write the same content, each
+	// one to its own temporary file, and the last rename wins
+	temp := path + "." + uuid.New().String() + tempSuffix
+	if err := writeSynced(temp, []byte(task)); err != nil {
+		os.Remove(temp)
+		return "", fmt.Errorf("writing blob: %w", err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return "", fmt.Errorf("writing blob: %w", err)
+	}
+	if err := syncDir(dir); err != nil {
+		return "", fmt.Errorf("writing blob: %w", err)
+	}
+
+	return blobPrefix + hash, nil
+}
+
+// writeSynced writes a new file and flushes it to disk.
+func writeSynced(path string, content []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// get returns the task referenced by the line, verifying its hash.
+func (b blobStore) get(ref string) (string, error) {
+	hash := strings.TrimPrefix(ref, blobPrefix)
+	if len(hash) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid blob reference %q", ref)
+	}
+
+	content, err := os.ReadFile(b.path(hash))
+	if err != nil {
+		return "", fmt.Errorf("reading blob: %w", err)
+	}
+	if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != hash {
+		return "", fmt.Errorf("blob %s: content doesn't match its hash", hash)
+	}
+
+	return string(content), nil
+}
+
+// resolver returns a function replacing the references by the tasks, keeping
+// the lines that can't be resolved.  The tasks are cached, so the ones
+// referenced many times are read once.
+func (b blobStore) resolver() func(string) string {
+	cache := make(map[string]string)
+	return func(line string) string {
+		if !isBlobRef(line) {
+			return line
+		}
+		if task, ok := cache[line]; ok {
+			return task
+		}
+		task, err := b.get(line)
+		if err != nil {
+			return line
+		}
+		cache[line] = task
+		return task
+	}
+}
+
+// ResolveBlob returns the task referenced by a line of a transactions file
+// stored in the organization directory orgDir.
+func ResolveBlob(orgDir, line string) (string, error) {
+	return orgBlobs(orgDir).get(line)
+}
+
+// dedupEnabled returns true if the repository located in dataDir
+// deduplicates the tasks.
+func dedupEnabled(dataDir string) bool {
+	configPath := filepath.Join(dataDir, "config")
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return false
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Errorf("Error loading repository config, tasks not deduplicated: %v", err)
+		return false
+	}
+
+	dedup, _, err := cfg.LookupBool(dedupKey)
+	if err != nil {
+		log.Errorf("Ignoring %q: %v", dedupKey, err)
+	}
+	return dedup
+}
+
+// unreferencedBlobs finds the blobs of the organization directory orgDir not
+// referenced by any transactions file, left by the purged users or the
+// retention.
+func unreferencedBlobs(orgDir string) ([]Artifact, error) {
+	store := orgBlobs(orgDir)
+	if _, err := os.Stat(string(store)); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	referenced := make(map[string]bool)
+	for _, folder := range []string{usersFolder, groupsFolder} {
+		for _, name := range []string{txFile, txFileTemp + "*"} {
+			paths, err := filepath.Glob(filepath.Join(orgDir, folder, "*", name))
+			if err != nil {
+				return nil, err
+			}
+			for _, path := range paths {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					return nil, err
+				}
+				for _, line := range strings.Split(string(content), "\n") {
+					if isBlobRef(line) {
+						referenced[strings.TrimPrefix(line, blobPrefix)] = true
+					}
+				}
+			}
+		}
+	}
+
+	var artifacts []Artifact
+	blobs, err := filepath.Glob(filepath.Join(string(store), "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range blobs {
+		if name := filepath.Base(path); !strings.HasSuffix(name, tempSuffix) && !referenced[name] {
+			artifacts = append(artifacts, Artifact{Path: path, Action: ArtifactRemoved, Reason: "unreferenced blob"})
+		}
+	}
+
+	return artifacts, nil
+}
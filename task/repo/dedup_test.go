@@ -0,0 +1,143 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/config"
+)
+
+func TestDedup(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	cfg, err := config.Load(filepath.Join(tempRepo, "config"))
+	assert.NoError(t, err)
+	cfg.Set(dedupKey, "true")
+	assert.NoError(t, config.Save(cfg))
+
+	repo, err := OpenRepository(tempRepo)
+	assert.NoError(t, err)
+	first, err := repo.AddUser("Public", "first")
+	assert.NoError(t, err)
+	second, err := repo.AddUser("Public", "second")
+	assert.NoError(t, err)
+
+	task := `{"description":"dedup","entry":"20210101T000000Z","status":"pending","uuid":"1d8c1bb0-5d3b-4d6e-9b1a-1a2b3c4d5e6f"}`
+	keys := []string{"9d4fb7a2-3b1c-4a8e-8f2d-0e1f2a3b4c5d", "2b6e8d1c-7f3a-4e5b-9c0d-1a2b3c4d5e6f"}
+
+	ra := NewDefaultReadAppender(tempRepo)
+	assert.True(t, ra.dedup)
+	assert.NoError(t, ra.Append(*first, []string{task + "\n", keys[0] + "\n"}))
+	assert.NoError(t, ra.Append(*first, []string{task + "\n", keys[1] + "\n"}))
+	assert.NoError(t, ra.Append(*second, []string{task + "\n", keys[0] + "\n"}))
+
+	orgPath := filepath.Join(tempRepo, orgsFolder, "Public")
+
+	t.Run("stored once", func(t *testing.T) {
+		blobs, err := filepath.Glob(filepath.Join(orgPath, blobsFolder, "*", "*"))
+		assert.NoError(t, err)
+		assert.Len(t, blobs, 1)
+
+		content, err := os.ReadFile(filepath.Join(orgPath, usersFolder, first.Key, txFile))
+		assert.NoError(t, err)
+		assert.NotContains(t, string(content), "dedup")
+		assert.True(t, strings.HasPrefix(string(content), blobPrefix))
+	})
+
+	t.Run("reassembled on read", func(t *testing.T) {
+		data, err := ra.Read(*first)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{task, keys[0], task, keys[1]}, data)
+	})
+
+	t.Run("concurrent puts of the same task", func(t *testing.T) {
+		blobs := orgBlobs(t.TempDir())
+		concurrent := `{"description":"concurrent","uuid":"0c7d3e5a-9b1f-4a2c-8d6e-5f4a3b2c1d0e"}`
+
+		var wg gosync.WaitGroup
+		errs := make(chan error, 20)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ref, err := blobs.put(concurrent)
+				if err == nil {
+					_, err = blobs.get(ref)
+				}
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			assert.NoError(t, err)
+		}
+
+		// no temporary file left behind
+		files, err := filepath.Glob(filepath.Join(string(blobs), "*", "*"))
+		assert.NoError(t, err)
+		assert.Len(t, files, 1)
+	})
+
+	t.Run("not deduplicated when disabled", func(t *testing.T) {
+		plain := &DefaultReadAppender{baseDir: tempRepo}
+		assert.NoError(t, plain.Append(*second, []string{task + "\n", keys[1] + "\n"}))
+
+		data, err := plain.Read(*second)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{task, keys[0], task, keys[1]}, data)
+	})
+
+	t.Run("corrupted blob", func(t *testing.T) {
+		blobs, err := filepath.Glob(filepath.Join(orgPath, blobsFolder, "*", "*"))
+		assert.NoError(t, err)
+		original, err := os.ReadFile(blobs[0])
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(blobs[0], []byte("{}"), 0600))
+		defer func() {
+			assert.NoError(t, os.WriteFile(blobs[0], original, 0600))
+		}()
+
+		_, err = ra.Read(*first)
+		assert.Error(t, err)
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		done := strings.Replace(task, `"status":"pending"`, `"end":"20210102T000000Z","status":"completed"`, 1)
+		assert.NoError(t, ra.Append(*first, []string{done + "\n", "5c7a9e1b-2d4f-4a6b-8c0e-1f2a3b4c5d6e\n"}))
+
+		expired, err := EnforceRetention(tempRepo, Retention{Completed: 24 * time.Hour}, false)
+		assert.NoError(t, err)
+		lines := 0
+		for _, e := range expired {
+			if e.Path == filepath.Join(orgPath, usersFolder, first.Key, txFile) {
+				lines = e.Lines
+			}
+		}
+		assert.Equal(t, 3, lines)
+
+		data, err := ra.Read(*first)
+		assert.NoError(t, err)
+		assert.NotContains(t, strings.Join(data, "\n"), "dedup")
+	})
+
+	t.Run("unreferenced blobs collected", func(t *testing.T) {
+		artifacts, err := CollectGarbage(tempRepo, false)
+		assert.NoError(t, err)
+		assert.Len(t, artifacts, 1)
+		assert.Equal(t, "unreferenced blob", artifacts[0].Reason)
+
+		// still referenced by the second user
+		data, err := ra.Read(*second)
+		assert.NoError(t, err)
+		assert.Equal(t, task, data[0])
+	})
+}
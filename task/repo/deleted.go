@@ -0,0 +1,182 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/szaffarano/gotas/config"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// deletedKey is the organization and user configuration entry holding the
+// time they were deleted.  Deleted entities are kept on disk until purged.
+const deletedKey = "deleted"
+
+// RestoreOrg undoes the deletion of an Organization.
+func (r *Repository) RestoreOrg(orgName string) error {
+	org, err := r.GetOrg(orgName)
+	if err != nil {
+		return err
+	} else if org.Deleted.IsZero() {
+		return newError(ErrOrgNotDeleted, "organization %q is not deleted", orgName)
+	}
+
+	if err := r.setOrgConfig(orgName, deletedKey, ""); err != nil {
+		return fmt.Errorf("restoring org: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreUser undoes the deletion of a user.
+func (r *Repository) RestoreUser(orgName, userKey string) error {
+	user, err := r.getUser(orgName, userKey)
+	if err != nil {
+		return err
+	} else if user.Deleted.IsZero() {
+		return newError(ErrUserNotDeleted, "user %q is not deleted", userKey)
+	}
+
+	return r.setUserConfig(orgName, userKey, deletedKey, "")
+}
+
+// Purge permanently removes the organizations and users deleted before the
+// given time, returning a description of what was removed and of the
+// deleted entities skipped because their deletion time is invalid.
+func (r *Repository) Purge(before time.Time) (purged []string, skipped []string, err error) {
+	defer func() {
+		if len(purged) > 0 {
+			r.changed()
+		}
+	}()
+
+	for _, org := range r.Orgs() {
+
+		dir, err := orgDir(r.baseDir, org.Name)
+		if err != nil {
+			return purged, skipped, err
+		}
+
+		if !org.Deleted.IsZero() {
+			deleted, err := deletionTime(filepath.Join(dir, "config"))
+			if err != nil {
+				skipped = append(skipped, fmt.Sprintf("organization %q: %v", org.Name, err))
+			} else if deleted.Before(before) {
+				if err := os.RemoveAll(dir); err != nil {
+					return purged, skipped, fmt.Errorf("purging org: %w", err)
+				}
+				purged = append(purged, fmt.Sprintf("organization %q", org.Name))
+				continue
+			}
+		}
+
+		for _, u := range org.Users {
+			if u.Deleted.IsZero() {
+				continue
+			}
+			userPath := filepath.Join(dir, usersFolder, u.Key)
+			deleted, err := deletionTime(filepath.Join(userPath, "config"))
+			if err != nil {
+				skipped = append(skipped, fmt.Sprintf("user %q (%s) from organization %q: %v", u.Name, u.Key, org.Name, err))
+				continue
+			} else if !deleted.Before(before) {
+				continue
+			}
+			if err := os.RemoveAll(userPath); err != nil {
+				return purged, skipped, fmt.Errorf("purging user: %w", err)
+			}
+			purged = append(purged, fmt.Sprintf("user %q (%s) from organization %q", u.Name, u.Key, org.Name))
+		}
+	}
+	return purged, skipped, nil
+}
+
+// deletionTime reads the deletion time from a configuration file, failing
+// if it can't be parsed.
+func deletionTime(configPath string) (time.Time, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("loading config: %w", err)
+	}
+	return parseDeleted(cfg.Get(deletedKey))
+}
+
+func (r *Repository) getUser(orgName, userKey string) (auth.User, error) {
+	org, err := r.GetOrg(orgName)
+	if err != nil {
+		return auth.User{}, err
+	}
+
+	for _, u := range org.Users {
+		if u.Key == userKey {
+			return u, nil
+		}
+	}
+
+	return auth.User{}, newError(ErrUserNotFound, "user %q does not exists", userKey)
+}
+
+// setOrgConfig sets an organization configuration entry, creating the
+// configuration file if needed.
+func (r *Repository) setOrgConfig(orgName, key, value string) error {
+	configPath, err := r.orgConfigPath(orgName)
+	if err != nil {
+		return err
+	}
+
+	var cfg config.Config
+	if _, statErr := os.Stat(configPath); os.IsNotExist(statErr) {
+		cfg, err = config.New(configPath)
+	} else {
+		cfg, err = config.Load(configPath)
+	}
+	if err != nil {
+		return fmt.Errorf("loading org config: %w", err)
+	}
+
+	cfg.Set(key, value)
+	if err := config.Save(cfg); err != nil {
+		return fmt.Errorf("saving org config: %w", err)
+	}
+	r.changed()
+
+	return nil
+}
+
+func (r *Repository) orgConfigPath(orgName string) (string, error) {
+	dir, err := orgDir(r.baseDir, orgName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config"), nil
+}
+
+// parseDeleted parses a deletion or suspension time, the zero time if
+// there is none.
+func parseDeleted(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	deleted, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid deletion time %q: %w", value, err)
+	}
+
+	return deleted, nil
+}
+
+// loadDeleted parses a deletion or suspension time as parseDeleted does.
+// The entities with values that can't be parsed are kept deleted or
+// suspended, but as of now, so Purge never removes them.
+func loadDeleted(value string) time.Time {
+	deleted, err := parseDeleted(value)
+	if err != nil {
+		log.Warnf("Ignoring the %v, taking the current time", err)
+		return time.Now().UTC()
+	}
+
+	return deleted
+}
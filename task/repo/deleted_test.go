@@ -0,0 +1,111 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestRestore(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	repo, err := OpenRepository(tempRepo)
+	assert.Nil(t, err)
+	a := &DefaultAuthenticator{repo}
+	userKey := "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7"
+
+	assertCode := func(t *testing.T, code string) {
+		t.Helper()
+		_, err := a.Authenticate("Public", "noeh", userKey)
+		if code == "" {
+			assert.Nil(t, err)
+			return
+		}
+		authErr, ok := err.(auth.AuthenticationError)
+		if assert.True(t, ok) {
+			assert.Equal(t, code, authErr.Code)
+		}
+	}
+
+	t.Run("restore fails if not deleted", func(t *testing.T) {
+		assert.NotNil(t, repo.RestoreOrg("Public"))
+		assert.NotNil(t, repo.RestoreUser("Public", userKey))
+		assert.NotNil(t, repo.RestoreUser("Public", "invalid"))
+	})
+
+	t.Run("deleted user can't authenticate until restored", func(t *testing.T) {
+		assert.Nil(t, repo.DelUser("Public", userKey))
+		assertCode(t, "432")
+
+		assert.Nil(t, repo.RestoreUser("Public", userKey))
+		assertCode(t, "")
+	})
+
+	t.Run("deleted org users can't authenticate until restored", func(t *testing.T) {
+		assert.Nil(t, repo.DelOrg("Public"))
+		assertCode(t, "432")
+
+		assert.Nil(t, repo.RestoreOrg("Public"))
+		assertCode(t, "")
+		for _, org := range repo.Orgs() {
+			assert.True(t, org.Deleted.IsZero())
+		}
+	})
+}
+
+func TestPurge(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	repo, err := OpenRepository(tempRepo)
+	assert.Nil(t, err)
+	userKey := "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7"
+	userPath := filepath.Join(tempRepo, "orgs", "Public", "users", userKey)
+	orgPath := filepath.Join(tempRepo, "orgs", "Private")
+
+	invalidKey := "a321e1fc-654f-44ba-a460-a79493c65c0a"
+	invalidPath := filepath.Join(tempRepo, "orgs", "Public", "users", invalidKey)
+
+	assert.Nil(t, repo.DelUser("Public", userKey))
+	assert.Nil(t, repo.DelOrg("Private"))
+	assert.Nil(t, repo.setUserConfig("Public", invalidKey, deletedKey, "yesterday"))
+
+	t.Run("keeps recently deleted entities", func(t *testing.T) {
+		purged, skipped, err := repo.Purge(time.Now().Add(-time.Hour))
+		assert.Nil(t, err)
+		assert.Empty(t, purged)
+		assert.Len(t, skipped, 1)
+		assert.DirExists(t, userPath)
+		assert.DirExists(t, orgPath)
+	})
+
+	t.Run("invalid deletion times keep the users deleted", func(t *testing.T) {
+		user, err := repo.getUser("Public", invalidKey)
+		assert.Nil(t, err)
+		assert.False(t, user.Deleted.IsZero())
+	})
+
+	t.Run("removes deleted entities", func(t *testing.T) {
+		before := len(repo.Orgs())
+
+		purged, skipped, err := repo.Purge(time.Now().Add(time.Hour))
+		assert.Nil(t, err)
+		assert.Len(t, purged, 2)
+		assert.Len(t, skipped, 1)
+		assert.NoDirExists(t, userPath)
+		assert.NoDirExists(t, orgPath)
+		assert.DirExists(t, invalidPath)
+		assert.Len(t, repo.Orgs(), before-1)
+
+		org, err := repo.GetOrg("Public")
+		assert.Nil(t, err)
+		assert.Len(t, org.Users, 2)
+	})
+}
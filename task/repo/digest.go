@@ -0,0 +1,42 @@
+package repo
+
+import (
+	"fmt"
+	"net/mail"
+	"path/filepath"
+
+	"github.com/szaffarano/gotas/config"
+)
+
+// digestKey is the user configuration entry with the address the digest of
+// the due tasks is sent to.  The users without it don't get the digest.
+const digestKey = "digest.email"
+
+// SetDigestEmail opts the user in the digest of the due tasks, sent to
+// email, or out of it if email is empty.
+func (r *Repository) SetDigestEmail(orgName, userKey, email string) error {
+	if email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			return fmt.Errorf("invalid email %q: %w", email, err)
+		}
+	}
+	return r.setUserConfig(orgName, userKey, digestKey, email)
+}
+
+// DigestEmail returns the address the digest of the due tasks of the user is
+// sent to, empty if the user didn't opt in.
+func (r *Repository) DigestEmail(orgName, userKey string) (string, error) {
+	if _, err := r.getUser(orgName, userKey); err != nil {
+		return "", err
+	}
+
+	dir, err := orgDir(r.baseDir, orgName)
+	if err != nil {
+		return "", err
+	}
+	cfg, err := config.Load(filepath.Join(dir, usersFolder, userKey, "config"))
+	if err != nil {
+		return "", fmt.Errorf("loading user config: %w", err)
+	}
+	return cfg.Get(digestKey), nil
+}
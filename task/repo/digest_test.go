@@ -0,0 +1,39 @@
+package repo
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDigestEmail(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+
+	repo, err := NewRepository(tempRepo, nil)
+	assert.NoError(t, err)
+	_, err = repo.NewOrg("Public")
+	assert.NoError(t, err)
+	user, err := repo.AddUser("Public", "noeh")
+	assert.NoError(t, err)
+
+	email, err := repo.DigestEmail("Public", user.Key)
+	assert.NoError(t, err)
+	assert.Empty(t, email)
+
+	assert.NoError(t, repo.SetDigestEmail("Public", user.Key, "noeh@example.com"))
+	email, err = repo.DigestEmail("Public", user.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, "noeh@example.com", email)
+
+	assert.NoError(t, repo.SetDigestEmail("Public", user.Key, ""))
+	email, err = repo.DigestEmail("Public", user.Key)
+	assert.NoError(t, err)
+	assert.Empty(t, email)
+
+	assert.Error(t, repo.SetDigestEmail("Public", user.Key, "not an email"))
+	assert.Error(t, repo.SetDigestEmail("Public", "invalid", "noeh@example.com"))
+	_, err = repo.DigestEmail("Public", "invalid")
+	assert.Error(t, err)
+}
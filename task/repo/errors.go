@@ -0,0 +1,46 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Errors returned by the repository, to be checked with errors.Is.  The
+// messages of the errors wrapping them name the organization, user or group.
+var (
+	ErrOrgExists        = errors.New("organization already exists")
+	ErrOrgNotFound      = errors.New("organization not found")
+	ErrOrgDeleted       = errors.New("organization deleted")
+	ErrOrgNotDeleted    = errors.New("organization not deleted")
+	ErrUserExists       = errors.New("user already exists")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrUserDeleted      = errors.New("user deleted")
+	ErrUserNotDeleted   = errors.New("user not deleted")
+	ErrUserSuspended    = errors.New("user suspended")
+	ErrUserNotSuspended = errors.New("user not suspended")
+	ErrGroupExists      = errors.New("group already exists")
+	ErrGroupNotFound    = errors.New("group not found")
+	ErrPermission       = fs.ErrPermission
+)
+
+// repoError is an error of a given kind, one of the errors above, with its
+// own message.
+type repoError struct {
+	kind error
+	msg  string
+}
+
+func (e *repoError) Error() string {
+	return e.msg
+}
+
+func (e *repoError) Unwrap() error {
+	return e.kind
+}
+
+// newError returns an error of the given kind, formatting its message with
+// fmt.Sprintf.
+func newError(kind error, format string, args ...interface{}) error {
+	return &repoError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,70 @@
+package repo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrors(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	repo, err := OpenRepository(tempRepo)
+	assert.Nil(t, err)
+
+	const noeh = "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7"
+
+	t.Run("organizations", func(t *testing.T) {
+		_, err := repo.NewOrg("Public")
+		assert.True(t, errors.Is(err, ErrOrgExists))
+		assert.EqualError(t, err, `organization "Public" already exists`)
+
+		_, err = repo.GetOrg("invalid")
+		assert.True(t, errors.Is(err, ErrOrgNotFound))
+		assert.True(t, errors.Is(repo.DelOrg("invalid"), ErrOrgNotFound))
+		assert.True(t, errors.Is(repo.RestoreOrg("Public"), ErrOrgNotDeleted))
+	})
+
+	t.Run("users", func(t *testing.T) {
+		_, err := repo.AddUser("Public", "noeh")
+		assert.True(t, errors.Is(err, ErrUserExists))
+		_, err = repo.AddUser("invalid", "noeh")
+		assert.True(t, errors.Is(err, ErrOrgNotFound))
+
+		assert.True(t, errors.Is(repo.DelUser("Public", "invalid"), ErrUserNotFound))
+		assert.True(t, errors.Is(repo.ResumeUser("Public", noeh), ErrUserNotSuspended))
+		assert.NoError(t, repo.SuspendUser("Public", noeh))
+		assert.True(t, errors.Is(repo.SuspendUser("Public", noeh), ErrUserSuspended))
+	})
+
+	t.Run("groups", func(t *testing.T) {
+		assert.NoError(t, repo.NewGroup("Public", "team"))
+		assert.True(t, errors.Is(repo.NewGroup("Public", "team"), ErrGroupExists))
+		assert.True(t, errors.Is(repo.DelGroup("Public", "invalid"), ErrGroupNotFound))
+	})
+
+	t.Run("deleted organizations", func(t *testing.T) {
+		assert.NoError(t, repo.DelOrg("Public"))
+		assert.True(t, errors.Is(repo.DelOrg("Public"), ErrOrgDeleted))
+		_, err := repo.AddUser("Public", "jane")
+		assert.True(t, errors.Is(err, ErrOrgDeleted))
+	})
+
+	t.Run("memory repository", func(t *testing.T) {
+		memory := NewMemoryRepository()
+		_, err := memory.NewOrg("Public")
+		assert.NoError(t, err)
+
+		_, err = memory.NewOrg("Public")
+		assert.True(t, errors.Is(err, ErrOrgExists))
+		_, err = memory.GetOrg("invalid")
+		assert.True(t, errors.Is(err, ErrOrgNotFound))
+		assert.True(t, errors.Is(memory.DelUser("Public", "invalid"), ErrUserNotFound))
+	})
+}
@@ -0,0 +1,202 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// tempSuffix is the suffix of the temporary files written while saving
+	// a configuration file.
+	tempSuffix = ".tmp"
+
+	// lockSuffix is the suffix of the lock files.
+	lockSuffix = ".lock"
+
+	// staleLockAge is the age after which a lock file is considered left
+	// behind by a crashed process.
+	staleLockAge = time.Hour
+)
+
+// ArtifactAction is what is done with a leftover temporary file.
+type ArtifactAction string
+
+// Actions applied to the leftover temporary files.
+const (
+	// ArtifactRemoved is a file deleted because it's useless.
+	ArtifactRemoved ArtifactAction = "remove"
+	// ArtifactRecovered is a complete transactions file that wasn't renamed
+	// because of a crash.
+	ArtifactRecovered ArtifactAction = "recover"
+)
+
+// Artifact is a leftover temporary file found in the repository.  Done is
+// false if it was only reported.
+type Artifact struct {
+	Path   string         `json:"path"`
+	Action ArtifactAction `json:"action"`
+	Reason string         `json:"reason"`
+	Done   bool           `json:"done"`
+}
+
+func (a Artifact) String() string {
+	status := "would " + string(a.Action)
+	if a.Done {
+		status = string(a.Action) + "d"
+	}
+	return fmt.Sprintf("%s: %s (%s)", a.Path, a.Reason, status)
+}
+
+// CollectGarbage removes the temporary files and stale locks left in the
+// repository located in dataDir by a crash, which later appends may clobber
+// or trip over.  A temporary transactions file is recovered if it's the only
+// copy of the user data and it's complete.  With checkOnly, the artifacts
+// found are only reported.  It's meant to be called before the server starts.
+func CollectGarbage(dataDir string, checkOnly bool) ([]Artifact, error) {
+	var artifacts []Artifact
+
+	roots, err := LoadRoots(dataDir)
+	if err != nil {
+		return artifacts, fmt.Errorf("collecting garbage: %w", err)
+	}
+
+	walk := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() {
+			return nil
+		}
+
+		artifact, found, err := inspectArtifact(path, d)
+		if err != nil || !found {
+			return err
+		}
+
+		if !checkOnly {
+			if err := artifact.apply(); err != nil {
+				return err
+			}
+			artifact.Done = true
+			log.Warnf("Garbage collected %v", artifact)
+		}
+		artifacts = append(artifacts, artifact)
+
+		return nil
+	}
+	// the data directory holds the repository files besides the orgs
+	dirs := append([]string{dataDir}, roots.orgsDirs()[1:]...)
+	for _, dir := range dirs {
+		if err := filepath.WalkDir(dir, walk); err != nil {
+			return artifacts, fmt.Errorf("collecting garbage: %w", err)
+		}
+	}
+
+	// after the leftover appends are recovered, so their blobs are kept
+	for _, dir := range roots.orgsDirs() {
+		orgs, err := os.ReadDir(dir)
+		if err != nil {
+			return artifacts, fmt.Errorf("collecting garbage: %w", err)
+		}
+		for _, org := range orgs {
+			if !org.IsDir() {
+				continue
+			}
+			blobs, err := unreferencedBlobs(filepath.Join(dir, org.Name()))
+			if err != nil {
+				return artifacts, fmt.Errorf("collecting garbage: %w", err)
+			}
+			for _, artifact := range blobs {
+				if !checkOnly {
+					if err := artifact.apply(); err != nil {
+						return artifacts, fmt.Errorf("collecting garbage: %w", err)
+					}
+					artifact.Done = true
+					log.Infof("Garbage collected %v", artifact)
+				}
+				artifacts = append(artifacts, artifact)
+			}
+		}
+	}
+
+	return artifacts, nil
+}
+
+// CollectArtifact handles a single file the way CollectGarbage does: if it's
+// a leftover, it's removed or recovered, unless checkOnly.  It returns false
+// if the file isn't a leftover.
+func CollectArtifact(path string, checkOnly bool) (Artifact, bool, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Artifact{}, false, err
+	}
+	artifact, found, err := inspectArtifact(path, fs.FileInfoToDirEntry(info))
+	if err != nil || !found || checkOnly {
+		return artifact, found, err
+	}
+
+	if err := artifact.apply(); err != nil {
+		return artifact, true, err
+	}
+	artifact.Done = true
+	return artifact, true, nil
+}
+
+func inspectArtifact(path string, d fs.DirEntry) (Artifact, bool, error) {
+	name := d.Name()
+
+	switch {
+	case strings.HasPrefix(name, txFileTemp):
+		if _, err := os.Stat(filepath.Join(filepath.Dir(path), txFile)); err == nil {
+			return Artifact{Path: path, Action: ArtifactRemoved, Reason: "unfinished append"}, true, nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return Artifact{}, false, err
+		}
+		if completeTx(content) {
+			return Artifact{Path: path, Action: ArtifactRecovered, Reason: "complete first append"}, true, nil
+		}
+		return Artifact{Path: path, Action: ArtifactRemoved, Reason: "incomplete first append"}, true, nil
+
+	case strings.HasSuffix(name, tempSuffix):
+		return Artifact{Path: path, Action: ArtifactRemoved, Reason: "unfinished save"}, true, nil
+
+	case strings.HasSuffix(name, lockSuffix):
+		info, err := d.Info()
+		if err != nil {
+			return Artifact{}, false, err
+		}
+		if time.Since(info.ModTime()) > staleLockAge {
+			return Artifact{Path: path, Action: ArtifactRemoved, Reason: "stale lock"}, true, nil
+		}
+	}
+
+	return Artifact{}, false, nil
+}
+
+// completeTx returns true if the transactions end with a sync key, which is
+// the last line written by every append.
+func completeTx(content []byte) bool {
+	if !bytes.HasSuffix(content, []byte("\n")) {
+		return false
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(content, []byte("\n")), []byte("\n"))
+	_, err := uuid.ParseBytes(lines[len(lines)-1])
+	return err == nil
+}
+
+func (a Artifact) apply() error {
+	if a.Action == ArtifactRecovered {
+		return os.Rename(a.Path, filepath.Join(filepath.Dir(a.Path), txFile))
+	}
+	return os.Remove(a.Path)
+}
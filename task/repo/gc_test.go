@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	users := filepath.Join(tempRepo, orgsFolder, "Public", usersFolder)
+	withData := filepath.Join(users, "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7")
+	complete := filepath.Join(users, "a321e1fc-654f-44ba-a460-a79493c65c0a")
+	incomplete := filepath.Join(users, "f793325d-c0d4-4f11-91d3-1388a02e727c")
+
+	write := func(t *testing.T, path, content string) {
+		t.Helper()
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	}
+
+	task := `{"description":"test","uuid":"8f3b2c1a-7d4e-4f5a-9b6c-0d1e2f3a4b5c"}` + "\n"
+	write(t, filepath.Join(withData, txFileTemp), task)
+	write(t, filepath.Join(complete, txFileTemp), task+"6f0c5b8e-2a1d-4c3b-9e7f-8a6b5c4d3e2f\n")
+	write(t, filepath.Join(incomplete, txFileTemp), task)
+	write(t, filepath.Join(incomplete, "config"+tempSuffix), "user=someone\n")
+
+	staleLock := filepath.Join(tempRepo, "server"+lockSuffix)
+	freshLock := filepath.Join(tempRepo, "other"+lockSuffix)
+	write(t, staleLock, "")
+	write(t, freshLock, "")
+	old := time.Now().Add(-2 * staleLockAge)
+	assert.Nil(t, os.Chtimes(staleLock, old, old))
+
+	expected := map[string]ArtifactAction{
+		filepath.Join(withData, txFileTemp):            ArtifactRemoved,
+		filepath.Join(complete, txFileTemp):            ArtifactRecovered,
+		filepath.Join(incomplete, txFileTemp):          ArtifactRemoved,
+		filepath.Join(incomplete, "config"+tempSuffix): ArtifactRemoved,
+		staleLock: ArtifactRemoved,
+	}
+
+	assertArtifacts := func(t *testing.T, artifacts []Artifact, done bool) {
+		t.Helper()
+		found := make(map[string]ArtifactAction)
+		for _, a := range artifacts {
+			found[a.Path] = a.Action
+			assert.Equal(t, done, a.Done)
+		}
+		assert.Equal(t, expected, found)
+	}
+
+	t.Run("check only", func(t *testing.T) {
+		artifacts, err := CollectGarbage(tempRepo, true)
+		assert.Nil(t, err)
+		assertArtifacts(t, artifacts, false)
+
+		for path := range expected {
+			assert.FileExists(t, path)
+		}
+	})
+
+	t.Run("collect", func(t *testing.T) {
+		artifacts, err := CollectGarbage(tempRepo, false)
+		assert.Nil(t, err)
+		assertArtifacts(t, artifacts, true)
+
+		for path := range expected {
+			assert.NoFileExists(t, path)
+		}
+		assert.FileExists(t, freshLock)
+		assert.FileExists(t, filepath.Join(withData, txFile))
+
+		recovered, err := os.ReadFile(filepath.Join(complete, txFile))
+		assert.Nil(t, err)
+		assert.Equal(t, task+"6f0c5b8e-2a1d-4c3b-9e7f-8a6b5c4d3e2f\n", string(recovered))
+		assert.NoFileExists(t, filepath.Join(incomplete, txFile))
+	})
+
+	t.Run("nothing left", func(t *testing.T) {
+		artifacts, err := CollectGarbage(tempRepo, false)
+		assert.Nil(t, err)
+		assert.Empty(t, artifacts)
+	})
+}
@@ -0,0 +1,133 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// groupKey is the user configuration entry naming the group the user belongs
+// to.
+const groupKey = "group"
+
+// NewGroup creates a shared group in the given Organization.  All the users
+// joining the group sync the same task list.
+func (r *Repository) NewGroup(orgName, groupName string) error {
+	if groupName == "" || groupName != filepath.Base(groupName) || groupName == "." || groupName == ".." {
+		return fmt.Errorf("invalid group name %q", groupName)
+	}
+
+	if _, err := r.GetOrg(orgName); err != nil {
+		return err
+	}
+
+	groupPath, err := r.groupPath(orgName, groupName)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(groupPath); err == nil {
+		return newError(ErrGroupExists, "group %q already exists", groupName)
+	}
+
+	if err := os.MkdirAll(groupPath, 0775); err != nil {
+		return fmt.Errorf("creating group: %w", err)
+	}
+
+	return nil
+}
+
+// DelGroup deletes a group and its shared data.  The members go back to their
+// own task list.
+func (r *Repository) DelGroup(orgName, groupName string) error {
+	org, err := r.GetOrg(orgName)
+	if err != nil {
+		return err
+	}
+
+	groupPath, err := r.groupPath(orgName, groupName)
+	if err != nil {
+		return err
+	} else if !isGroup(groupName, groupPath) {
+		return newError(ErrGroupNotFound, "group %q does not exists", groupName)
+	}
+
+	for _, u := range org.Users {
+		if u.Group == groupName {
+			if err := r.setUserGroup(orgName, u.Key, ""); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.RemoveAll(groupPath); err != nil {
+		return fmt.Errorf("deleting group: %w", err)
+	}
+
+	return nil
+}
+
+// GetGroups returns the names of the groups defined in the given
+// Organization.
+func (r *Repository) GetGroups(orgName string) ([]string, error) {
+	if _, err := r.GetOrg(orgName); err != nil {
+		return nil, err
+	}
+
+	dir, err := orgDir(r.baseDir, orgName)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, groupsFolder))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("listing groups: %w", err)
+	}
+
+	var groups []string
+	for _, e := range entries {
+		if e.IsDir() {
+			groups = append(groups, e.Name())
+		}
+	}
+	sort.Strings(groups)
+
+	return groups, nil
+}
+
+// JoinGroup makes the user share the group task list.  The user's own data
+// is kept but it's not synced while the user belongs to the group.
+func (r *Repository) JoinGroup(orgName, groupName, userKey string) error {
+	groupPath, err := r.groupPath(orgName, groupName)
+	if err != nil {
+		return err
+	} else if !isGroup(groupName, groupPath) {
+		return newError(ErrGroupNotFound, "group %q does not exists", groupName)
+	}
+
+	return r.setUserGroup(orgName, userKey, groupName)
+}
+
+// LeaveGroup makes the user go back to its own task list.
+func (r *Repository) LeaveGroup(orgName, userKey string) error {
+	return r.setUserGroup(orgName, userKey, "")
+}
+
+func (r *Repository) setUserGroup(orgName, userKey, groupName string) error {
+	return r.setUserConfig(orgName, userKey, groupKey, groupName)
+}
+
+// isGroup returns true if the group is stored in groupPath.
+func isGroup(groupName, groupPath string) bool {
+	info, err := os.Stat(groupPath)
+	return groupName != "" && err == nil && info.IsDir()
+}
+
+func (r *Repository) groupPath(orgName, groupName string) (string, error) {
+	dir, err := orgDir(r.baseDir, orgName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, groupsFolder, groupName), nil
+}
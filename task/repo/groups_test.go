@@ -0,0 +1,89 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroups(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	repo, err := OpenRepository(tempRepo)
+	assert.Nil(t, err)
+
+	const noeh = "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7"
+	const john = "f793325d-c0d4-4f11-91d3-1388a02e727c"
+
+	t.Run("new group", func(t *testing.T) {
+		assert.NoError(t, repo.NewGroup("Public", "team"))
+		assert.Error(t, repo.NewGroup("Public", "team"))
+		assert.Error(t, repo.NewGroup("invalid", "team"))
+		assert.Error(t, repo.NewGroup("Public", "../team"))
+		assert.Error(t, repo.NewGroup("Public", ""))
+
+		groups, err := repo.GetGroups("Public")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"team"}, groups)
+
+		groups, err = repo.GetGroups("Private")
+		assert.NoError(t, err)
+		assert.Empty(t, groups)
+	})
+
+	t.Run("members share the transactions", func(t *testing.T) {
+		assert.NoError(t, repo.JoinGroup("Public", "team", noeh))
+		assert.NoError(t, repo.JoinGroup("Public", "team", john))
+		assert.Error(t, repo.JoinGroup("Public", "invalid", john))
+		assert.Error(t, repo.JoinGroup("Public", "team", "invalid"))
+
+		auth, err := NewDefaultAuthenticator(tempRepo)
+		assert.NoError(t, err)
+		ra := NewDefaultReadAppender(tempRepo)
+
+		userOne, err := auth.Authenticate("Public", "noeh", noeh)
+		assert.NoError(t, err)
+		assert.Equal(t, "team", userOne.Group)
+		userTwo, err := auth.Authenticate("Public", "john", john)
+		assert.NoError(t, err)
+
+		assert.NoError(t, ra.Append(userOne, []string{"shared\n"}))
+		data, err := ra.Read(userTwo)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"shared"}, data)
+	})
+
+	t.Run("leave group", func(t *testing.T) {
+		assert.NoError(t, repo.LeaveGroup("Public", john))
+
+		auth, err := NewDefaultAuthenticator(tempRepo)
+		assert.NoError(t, err)
+		user, err := auth.Authenticate("Public", "john", john)
+		assert.NoError(t, err)
+		assert.Empty(t, user.Group)
+
+		data, err := NewDefaultReadAppender(tempRepo).Read(user)
+		assert.NoError(t, err)
+		assert.Empty(t, data)
+	})
+
+	t.Run("del group", func(t *testing.T) {
+		assert.NoError(t, repo.DelGroup("Public", "team"))
+		assert.Error(t, repo.DelGroup("Public", "team"))
+
+		auth, err := NewDefaultAuthenticator(tempRepo)
+		assert.NoError(t, err)
+		user, err := auth.Authenticate("Public", "noeh", noeh)
+		assert.NoError(t, err)
+		assert.Empty(t, user.Group)
+
+		groups, err := repo.GetGroups("Public")
+		assert.NoError(t, err)
+		assert.Empty(t, groups)
+	})
+}
@@ -0,0 +1,134 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/szaffarano/gotas/config"
+	"github.com/szaffarano/gotas/task/process"
+)
+
+// Hook names, the scripts are named after the events triggering them.
+const (
+	HookOrgAdded     = "on-org-added"
+	HookUserAdded    = "on-user-added"
+	HookUserRemoved  = "on-user-removed"
+	HookSyncComplete = "on-sync-complete"
+)
+
+const (
+	// DefaultHooksFolder is the hooks directory, relative to the data
+	// directory, used if none is configured.
+	DefaultHooksFolder = "hooks"
+
+	// DefaultHookTimeout is how long a hook can run before it's killed.
+	DefaultHookTimeout = 10 * time.Second
+
+	// same as the server "hooks.dir" and "hooks.timeout" entries
+	hooksDirKey     = "hooks.dir"
+	hooksTimeoutKey = "hooks.timeout"
+)
+
+// HookEvent is the event sent as JSON to the hooks standard input.
+type HookEvent struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Org   string    `json:"org"`
+	User  string    `json:"user,omitempty"`
+	Key   string    `json:"key,omitempty"`
+	Sync  *LastSync `json:"sync,omitempty"`
+	// Settings are the organization settings, sent on HookOrgAdded.
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// Hooks runs the executables found in Dir named after the events, like git
+// hooks do, so the admins can integrate provisioning and notifications.
+type Hooks struct {
+	Dir     string
+	Timeout time.Duration
+}
+
+// NewHooks returns the hooks of the repository located in dataDir.  A
+// relative dir is resolved against dataDir, and empty values mean the
+// defaults.
+func NewHooks(dataDir, dir string, timeout time.Duration) Hooks {
+	if dir == "" {
+		dir = DefaultHooksFolder
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(dataDir, dir)
+	}
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	return Hooks{Dir: dir, Timeout: timeout}
+}
+
+// loadHooks returns the hooks configured in the repository located in
+// dataDir, falling back to the defaults if the configuration is not valid.
+func loadHooks(dataDir string) Hooks {
+	cfg, err := config.Load(filepath.Join(dataDir, "config"))
+	if err != nil {
+		return NewHooks(dataDir, "", 0)
+	}
+
+	var timeout time.Duration
+	if value := cfg.Get(hooksTimeoutKey); value != "" {
+		if timeout, err = time.ParseDuration(value); err != nil {
+			log.Warnf("Ignoring invalid %s %q: %v", hooksTimeoutKey, value, err)
+		}
+	}
+	return NewHooks(dataDir, cfg.Get(hooksDirKey), timeout)
+}
+
+// Run executes the hook named after the event, if any, with the event as JSON
+// in its standard input.  The hook is killed, along with the processes it
+// started, if it doesn't finish on time, and a non-zero exit status is an
+// error including its output.
+func (h Hooks) Run(event HookEvent) error {
+	if h.Dir == "" {
+		return nil
+	}
+
+	path := filepath.Join(h.Dir, event.Event)
+	if info, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("hook %s: %w", event.Event, err)
+	} else if info.IsDir() || info.Mode()&0111 == 0 {
+		log.Warnf("Ignoring hook %v: not executable", path)
+		return nil
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC().Truncate(time.Second)
+	}
+	input, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("hook %s: %w", event.Event, err)
+	}
+
+	stdout, stderr, err := process.Run(path, h.Dir, input, h.Timeout)
+	output := strings.TrimSpace(string(stdout) + string(stderr))
+	if errors.Is(err, process.ErrTimeout) {
+		return fmt.Errorf("hook %s: timed out after %v", event.Event, h.Timeout)
+	} else if err != nil {
+		return fmt.Errorf("hook %s: %v: %s", event.Event, err, output)
+	}
+	log.Debugf("Hook %s: %s", event.Event, output)
+
+	return nil
+}
+
+// run runs a hook after a repository change, which is already done, so the
+// errors are only logged.
+func (h Hooks) run(event HookEvent) {
+	if err := h.Run(event); err != nil {
+		log.Warnf("%v", err)
+	}
+}
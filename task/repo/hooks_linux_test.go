@@ -0,0 +1,106 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHooks(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	hooksDir := filepath.Join(tempRepo, DefaultHooksFolder)
+	assert.Nil(t, os.Mkdir(hooksDir, 0755))
+	output := filepath.Join(tempRepo, "events")
+
+	// every hook appends its input to the output file
+	for _, name := range []string{HookOrgAdded, HookUserAdded, HookUserRemoved} {
+		script := "#!/bin/sh\ncat >> " + output + "\necho >> " + output + "\n"
+		assert.Nil(t, os.WriteFile(filepath.Join(hooksDir, name), []byte(script), 0755))
+	}
+
+	events := func(t *testing.T) []HookEvent {
+		t.Helper()
+		content, err := os.ReadFile(output)
+		assert.Nil(t, err)
+		os.Remove(output)
+
+		var events []HookEvent
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		for decoder.More() {
+			var event HookEvent
+			assert.Nil(t, decoder.Decode(&event))
+			events = append(events, event)
+		}
+		return events
+	}
+
+	repo, err := OpenRepository(tempRepo)
+	assert.Nil(t, err)
+	assert.Equal(t, hooksDir, repo.hooks.Dir)
+
+	t.Run("org and user lifecycle", func(t *testing.T) {
+		_, err := repo.NewOrg("Hooked")
+		assert.Nil(t, err)
+		user, err := repo.AddUser("Hooked", "john")
+		assert.Nil(t, err)
+		assert.Nil(t, repo.DelUser("Hooked", user.Key))
+
+		got := events(t)
+		assert.Len(t, got, 3)
+		assert.Equal(t, HookOrgAdded, got[0].Event)
+		assert.Equal(t, "Hooked", got[0].Org)
+		assert.Equal(t, HookUserAdded, got[1].Event)
+		assert.Equal(t, "john", got[1].User)
+		assert.Equal(t, user.Key, got[1].Key)
+		assert.Equal(t, HookUserRemoved, got[2].Event)
+		assert.Equal(t, user.Key, got[2].Key)
+		assert.False(t, got[2].Time.IsZero())
+	})
+
+	t.Run("missing hook", func(t *testing.T) {
+		hooks := NewHooks(tempRepo, "", 0)
+		assert.Nil(t, hooks.Run(HookEvent{Event: HookSyncComplete, Org: "Public"}))
+	})
+
+	t.Run("failing hook", func(t *testing.T) {
+		script := "#!/bin/sh\necho provisioning failed\nexit 1\n"
+		assert.Nil(t, os.WriteFile(filepath.Join(hooksDir, HookSyncComplete), []byte(script), 0755))
+		defer os.Remove(filepath.Join(hooksDir, HookSyncComplete))
+
+		err := NewHooks(tempRepo, "", 0).Run(HookEvent{Event: HookSyncComplete, Org: "Public"})
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "provisioning failed")
+
+		// the repository changes don't fail because of the hooks
+		script = "#!/bin/sh\nexit 1\n"
+		assert.Nil(t, os.WriteFile(filepath.Join(hooksDir, HookOrgAdded), []byte(script), 0755))
+		_, err = repo.NewOrg("Failing")
+		assert.Nil(t, err)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		script := "#!/bin/sh\nexec sleep 10\n"
+		assert.Nil(t, os.WriteFile(filepath.Join(hooksDir, HookSyncComplete), []byte(script), 0755))
+		defer os.Remove(filepath.Join(hooksDir, HookSyncComplete))
+
+		start := time.Now()
+		err := NewHooks(tempRepo, "", 100*time.Millisecond).Run(HookEvent{Event: HookSyncComplete, Org: "Public"})
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "timed out")
+		assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+	})
+
+	t.Run("configured dir", func(t *testing.T) {
+		assert.Equal(t, "/etc/gotas/hooks", NewHooks(tempRepo, "/etc/gotas/hooks", 0).Dir)
+		assert.Equal(t, filepath.Join(tempRepo, "scripts"), NewHooks(tempRepo, "scripts", 0).Dir)
+		assert.Equal(t, DefaultHookTimeout, NewHooks(tempRepo, "", 0).Timeout)
+	})
+}
@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/szaffarano/gotas/config"
+)
+
+// lastSyncFile is the user sidecar file holding the last sync metadata.  It's
+// kept apart from the user config, which is only modified by the admins.
+const lastSyncFile = "last.sync"
+
+const (
+	lastSyncTime    = "time"
+	lastSyncClient  = "client"
+	lastSyncAddress = "address"
+	lastSyncSize    = "size"
+)
+
+// LastSync describes the last successful sync of a user.
+type LastSync struct {
+	Time time.Time `json:"time"`
+	// Client is the client identification sent in the "client" header, e.g.
+	// "taskwarrior 2.6.2".
+	Client string `json:"client"`
+	// Address is the client remote address.
+	Address string `json:"address"`
+	// Size is the request payload size in bytes.
+	Size int `json:"size"`
+}
+
+// RecordSync stores the last sync metadata of the user with the given key,
+// in the repository located in dataDir.
+func RecordSync(dataDir, orgName, userKey string, sync LastSync) error {
+	path, err := lastSyncPath(dataDir, orgName, userKey)
+	if err != nil {
+		return err
+	}
+
+	var cfg config.Config
+	if _, statErr := os.Stat(path); os.IsNotExist(statErr) {
+		cfg, err = config.New(path)
+	} else {
+		cfg, err = config.Load(path)
+	}
+	if err != nil {
+		return fmt.Errorf("loading last sync: %w", err)
+	}
+
+	cfg.Set(lastSyncTime, sync.Time.UTC().Format(time.RFC3339))
+	cfg.Set(lastSyncClient, sync.Client)
+	cfg.Set(lastSyncAddress, sync.Address)
+	cfg.SetInt(lastSyncSize, sync.Size)
+	if err := config.Save(cfg); err != nil {
+		return fmt.Errorf("saving last sync: %w", err)
+	}
+
+	return nil
+}
+
+// LastSync returns the last sync metadata of a user.  It returns false if the
+// user never synced.
+func (r *Repository) LastSync(orgName, userKey string) (LastSync, bool, error) {
+	if _, err := r.getUser(orgName, userKey); err != nil {
+		return LastSync{}, false, err
+	}
+
+	path, err := lastSyncPath(r.baseDir, orgName, userKey)
+	if err != nil {
+		return LastSync{}, false, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return LastSync{}, false, nil
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		return LastSync{}, false, fmt.Errorf("loading last sync: %w", err)
+	}
+
+	t, err := time.Parse(time.RFC3339, cfg.Get(lastSyncTime))
+	if err != nil {
+		return LastSync{}, false, fmt.Errorf("invalid last sync time: %w", err)
+	}
+	size, err := strconv.Atoi(cfg.Get(lastSyncSize))
+	if err != nil {
+		return LastSync{}, false, fmt.Errorf("invalid last sync size: %w", err)
+	}
+
+	return LastSync{
+		Time:    t,
+		Client:  cfg.Get(lastSyncClient),
+		Address: cfg.Get(lastSyncAddress),
+		Size:    size,
+	}, true, nil
+}
+
+func lastSyncPath(dataDir, orgName, userKey string) (string, error) {
+	dir, err := orgDir(dataDir, orgName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, usersFolder, userKey, lastSyncFile), nil
+}
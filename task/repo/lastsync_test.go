@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastSync(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	repo, err := OpenRepository(tempRepo)
+	assert.Nil(t, err)
+	userKey := "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7"
+
+	t.Run("never synced", func(t *testing.T) {
+		_, ok, err := repo.LastSync("Public", userKey)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("record and read", func(t *testing.T) {
+		sync := LastSync{
+			Time:    time.Date(2021, 5, 3, 10, 30, 0, 0, time.UTC),
+			Client:  "taskwarrior 2.6.2",
+			Address: "127.0.0.1:43210",
+			Size:    1234,
+		}
+		assert.Nil(t, RecordSync(tempRepo, "Public", userKey, sync))
+
+		recorded, ok, err := repo.LastSync("Public", userKey)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, sync, recorded)
+
+		sync.Time = sync.Time.Add(time.Hour)
+		sync.Client = "taskwarrior 2.6.3"
+		assert.Nil(t, RecordSync(tempRepo, "Public", userKey, sync))
+
+		recorded, _, err = repo.LastSync("Public", userKey)
+		assert.Nil(t, err)
+		assert.Equal(t, sync, recorded)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		_, _, err := repo.LastSync("Public", "invalid")
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,345 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// MemoryRepository keeps the organizations, users and transactions in
+// memory, for the tests and the ephemeral server mode.  It's an
+// auth.Authenticator and a ReadAppender too, and manages the users like the
+// Repository does.  Nothing survives the process.
+type MemoryRepository struct {
+	mu          gosync.Mutex
+	orgs        []*auth.Organization
+	data        map[string][]string
+	maintenance bool
+}
+
+// NewMemoryRepository creates an empty repository.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{data: make(map[string][]string)}
+}
+
+// Orgs returns the repository organizations.
+func (m *MemoryRepository) Orgs() []auth.Organization {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	orgs := make([]auth.Organization, 0, len(m.orgs))
+	for _, org := range m.orgs {
+		orgs = append(orgs, *copyOrg(org))
+	}
+	return orgs
+}
+
+// NewOrg creates a new Organization.
+func (m *MemoryRepository) NewOrg(orgName string) (*auth.Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if orgName == "" || strings.ContainsAny(orgName, `/\`) {
+		return nil, fmt.Errorf("creating new org: invalid name %q", orgName)
+	} else if _, err := m.org(orgName); err == nil {
+		return nil, newError(ErrOrgExists, "organization %q already exists", orgName)
+	}
+
+	org := &auth.Organization{Name: orgName}
+	m.orgs = append(m.orgs, org)
+
+	return copyOrg(org), nil
+}
+
+// DelOrg marks a given Organization as deleted.
+func (m *MemoryRepository) DelOrg(orgName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.org(orgName)
+	if err != nil {
+		return err
+	} else if !org.Deleted.IsZero() {
+		return newError(ErrOrgDeleted, "organization %q already deleted", orgName)
+	}
+
+	org.Deleted = time.Now().UTC().Truncate(time.Second)
+	return nil
+}
+
+// GetOrg returns an Organization with its users.
+func (m *MemoryRepository) GetOrg(orgName string) (*auth.Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.org(orgName)
+	if err != nil {
+		return nil, err
+	}
+	return copyOrg(org), nil
+}
+
+// AddUser adds a new user to the given Organization.
+func (m *MemoryRepository) AddUser(orgName, userName string) (*auth.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.org(orgName)
+	if err != nil {
+		return nil, err
+	} else if !org.Deleted.IsZero() {
+		return nil, newError(ErrOrgDeleted, "organization %q is deleted", orgName)
+	}
+	for _, u := range org.Users {
+		if u.Name == userName {
+			return nil, newError(ErrUserExists, "user %q already exists", userName)
+		}
+	}
+
+	user := auth.User{Name: userName, Key: uuid.New().String(), Role: auth.RoleUser}
+	org.Users = append(org.Users, user)
+
+	user.Org = copyOrg(org)
+	return &user, nil
+}
+
+// DelUser marks a given user from an Organization as deleted.
+func (m *MemoryRepository) DelUser(orgName, userKey string) error {
+	return m.updateUser(orgName, userKey, func(user *auth.User) error {
+		if !user.Deleted.IsZero() {
+			return newError(ErrUserDeleted, "user %q already deleted", userKey)
+		}
+		user.Deleted = time.Now().UTC().Truncate(time.Second)
+		return nil
+	})
+}
+
+// SetRole changes the role of a user.
+func (m *MemoryRepository) SetRole(orgName, userKey string, role auth.Role) error {
+	if _, err := auth.ParseRole(string(role)); err != nil {
+		return err
+	}
+	return m.updateUser(orgName, userKey, func(user *auth.User) error {
+		user.Role = role
+		return nil
+	})
+}
+
+// SuspendUser suspends a user, which can't sync until resumed.
+func (m *MemoryRepository) SuspendUser(orgName, userKey string) error {
+	return m.updateUser(orgName, userKey, func(user *auth.User) error {
+		if !user.Suspended.IsZero() {
+			return newError(ErrUserSuspended, "user %q already suspended", userKey)
+		}
+		user.Suspended = time.Now().UTC().Truncate(time.Second)
+		return nil
+	})
+}
+
+// ResumeUser undoes the suspension of a user.
+func (m *MemoryRepository) ResumeUser(orgName, userKey string) error {
+	return m.updateUser(orgName, userKey, func(user *auth.User) error {
+		if user.Suspended.IsZero() {
+			return newError(ErrUserNotSuspended, "user %q is not suspended", userKey)
+		}
+		user.Suspended = time.Time{}
+		return nil
+	})
+}
+
+// AddUserAs adds a user like AddUser does, if the actor can manage the
+// organization.
+func (m *MemoryRepository) AddUserAs(actor auth.User, orgName, userName string) (*auth.User, error) {
+	if !actor.CanManage(orgName) {
+		return nil, forbidden(actor, "add users to organization %q", orgName)
+	}
+	return m.AddUser(orgName, userName)
+}
+
+// DelUserAs deletes a user like DelUser does, if the actor can manage it.
+func (m *MemoryRepository) DelUserAs(actor auth.User, orgName, userKey string) error {
+	if err := checkManages(actor, orgName, userKey, "delete", m.getUser); err != nil {
+		return err
+	}
+	return m.DelUser(orgName, userKey)
+}
+
+// SuspendUserAs suspends or resumes a user, if the actor can manage it.
+func (m *MemoryRepository) SuspendUserAs(actor auth.User, orgName, userKey string, suspend bool) error {
+	if suspend {
+		if err := checkManages(actor, orgName, userKey, "suspend", m.getUser); err != nil {
+			return err
+		}
+		return m.SuspendUser(orgName, userKey)
+	}
+
+	if err := checkManages(actor, orgName, userKey, "resume", m.getUser); err != nil {
+		return err
+	}
+	return m.ResumeUser(orgName, userKey)
+}
+
+// SetRoleAs changes the role of a user, if the actor can manage it.  Only
+// the server admins can grant or revoke the server admin role.
+func (m *MemoryRepository) SetRoleAs(actor auth.User, orgName, userKey string, role auth.Role) error {
+	if err := checkManages(actor, orgName, userKey, "change the role of", m.getUser); err != nil {
+		return err
+	} else if role == auth.RoleServerAdmin && actor.Role != auth.RoleServerAdmin {
+		return forbidden(actor, "grant the %v role", role)
+	}
+	return m.SetRole(orgName, userKey, role)
+}
+
+// UsersAs returns the users of an organization, if the actor can manage it.
+func (m *MemoryRepository) UsersAs(actor auth.User, orgName string) ([]auth.User, error) {
+	if !actor.CanManage(orgName) {
+		return nil, forbidden(actor, "list the users of organization %q", orgName)
+	}
+
+	org, err := m.GetOrg(orgName)
+	if err != nil {
+		return nil, err
+	}
+	return org.Users, nil
+}
+
+// SetMaintenance turns the maintenance mode on or off.
+func (m *MemoryRepository) SetMaintenance(on bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.maintenance = on
+	return nil
+}
+
+// InMaintenance returns true if the repository is in maintenance mode.
+func (m *MemoryRepository) InMaintenance() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.maintenance
+}
+
+// Authenticate verifies that the given organization-user-key is valid.
+func (m *MemoryRepository) Authenticate(orgName, userName, key string) (auth.User, error) {
+	org, err := m.GetOrg(orgName)
+	if err != nil {
+		return auth.User{}, auth.AuthenticationError{Code: "400", Msg: "Invalid org"}
+	}
+
+	return authenticate(org, userName, key)
+}
+
+// Read returns all the transaction information belonging to the given user.
+func (m *MemoryRepository) Read(user auth.User) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.dataKey(user)
+	if err != nil {
+		return nil, err
+	}
+	return append(make([]string, 0, len(m.data[key])), m.data[key]...), nil
+}
+
+// Append add data at the end of the transaction user database.
+func (m *MemoryRepository) Append(user auth.User, data []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.dataKey(user)
+	if err != nil {
+		return err
+	}
+	// the lines are stored like Read returns them, without the new lines
+	for _, chunk := range data {
+		for _, line := range strings.SplitAfter(chunk, "\n") {
+			if line != "" {
+				m.data[key] = append(m.data[key], strings.TrimSuffix(line, "\n"))
+			}
+		}
+	}
+	return nil
+}
+
+// dataKey returns the key of the user transactions, shared by all the
+// members when the user belongs to a group.
+func (m *MemoryRepository) dataKey(user auth.User) (string, error) {
+	if user.Org == nil {
+		return "", fmt.Errorf("user %q without organization", user.Name)
+	}
+	org, err := m.org(user.Org.Name)
+	if err != nil {
+		return "", err
+	}
+
+	for _, u := range org.Users {
+		if u.Key != user.Key {
+			continue
+		}
+		if user.Group != "" {
+			return org.Name + "/" + groupsFolder + "/" + user.Group, nil
+		}
+		return org.Name + "/" + usersFolder + "/" + user.Key, nil
+	}
+	return "", newError(ErrUserNotFound, "user %q does not exists", user.Key)
+}
+
+func (m *MemoryRepository) getUser(orgName, userKey string) (auth.User, error) {
+	org, err := m.GetOrg(orgName)
+	if err != nil {
+		return auth.User{}, err
+	}
+
+	for _, u := range org.Users {
+		if u.Key == userKey {
+			return u, nil
+		}
+	}
+	return auth.User{}, newError(ErrUserNotFound, "user %q does not exists", userKey)
+}
+
+func (m *MemoryRepository) updateUser(orgName, userKey string, update func(*auth.User) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.org(orgName)
+	if err != nil {
+		return err
+	}
+	for i := range org.Users {
+		if org.Users[i].Key == userKey {
+			return update(&org.Users[i])
+		}
+	}
+	return newError(ErrUserNotFound, "user %q does not exists", userKey)
+}
+
+func (m *MemoryRepository) org(orgName string) (*auth.Organization, error) {
+	for _, org := range m.orgs {
+		if org.Name == orgName {
+			return org, nil
+		}
+	}
+	return nil, newError(ErrOrgNotFound, "organization %q does not exists", orgName)
+}
+
+// copyOrg returns a copy of the organization, its users referencing it.
+func copyOrg(org *auth.Organization) *auth.Organization {
+	copied := *org
+	copied.Users = append([]auth.User(nil), org.Users...)
+	if org.Settings != nil {
+		copied.Settings = make(map[string]string, len(org.Settings))
+		for k, v := range org.Settings {
+			copied.Settings[k] = v
+		}
+	}
+	for i := range copied.Users {
+		copied.Users[i].Org = &copied
+	}
+	return &copied
+}
@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	org, err := repo.NewOrg("Public")
+	assert.NoError(t, err)
+	assert.Equal(t, "Public", org.Name)
+
+	user, err := repo.AddUser("Public", "noeh")
+	assert.NoError(t, err)
+	assert.Equal(t, auth.RoleUser, user.Role)
+	assert.Equal(t, "Public", user.Org.Name)
+
+	t.Run("organizations", func(t *testing.T) {
+		_, err := repo.NewOrg("Public")
+		assert.Error(t, err)
+		_, err = repo.NewOrg("Pu/blic")
+		assert.Error(t, err)
+		_, err = repo.GetOrg("Missing")
+		assert.Error(t, err)
+
+		assert.Len(t, repo.Orgs(), 1)
+		assert.Len(t, repo.Orgs()[0].Users, 1)
+	})
+
+	t.Run("users", func(t *testing.T) {
+		_, err := repo.AddUser("Public", "noeh")
+		assert.Error(t, err)
+		_, err = repo.AddUser("Missing", "noeh")
+		assert.Error(t, err)
+	})
+
+	t.Run("authentication", func(t *testing.T) {
+		authenticated, err := repo.Authenticate("Public", "noeh", user.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, user.Key, authenticated.Key)
+
+		_, err = repo.Authenticate("Public", "noeh", "invalid")
+		assert.Equal(t, auth.AuthenticationError{Code: "401", Msg: "Invalid username or key"}, err)
+		_, err = repo.Authenticate("Missing", "noeh", user.Key)
+		assert.Equal(t, auth.AuthenticationError{Code: "400", Msg: "Invalid org"}, err)
+
+		assert.NoError(t, repo.SuspendUser("Public", user.Key))
+		_, err = repo.Authenticate("Public", "noeh", user.Key)
+		assert.Equal(t, auth.AuthenticationError{Code: "431", Msg: "Account suspended"}, err)
+		assert.Error(t, repo.SuspendUser("Public", user.Key))
+		assert.NoError(t, repo.ResumeUser("Public", user.Key))
+		assert.Error(t, repo.ResumeUser("Public", user.Key))
+	})
+
+	t.Run("data", func(t *testing.T) {
+		data, err := repo.Read(*user)
+		assert.NoError(t, err)
+		assert.Empty(t, data)
+
+		assert.NoError(t, repo.Append(*user, []string{"{}\n", "key\n"}))
+		data, err = repo.Read(*user)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"{}", "key"}, data)
+
+		stranger := auth.User{Name: "noeh", Key: "invalid", Org: &auth.Organization{Name: "Public"}}
+		_, err = repo.Read(stranger)
+		assert.Error(t, err)
+		assert.Error(t, repo.Append(stranger, []string{"{}\n"}))
+	})
+
+	t.Run("copies", func(t *testing.T) {
+		org, err := repo.GetOrg("Public")
+		assert.NoError(t, err)
+		org.Users[0].Name = "changed"
+
+		org, err = repo.GetOrg("Public")
+		assert.NoError(t, err)
+		assert.Equal(t, "noeh", org.Users[0].Name)
+		assert.Same(t, org, org.Users[0].Org)
+	})
+
+	t.Run("roles", func(t *testing.T) {
+		admin, err := repo.AddUser("Public", "admin")
+		assert.NoError(t, err)
+		assert.NoError(t, repo.SetRole("Public", admin.Key, auth.RoleOrgAdmin))
+		actor, err := repo.Authenticate("Public", "admin", admin.Key)
+		assert.NoError(t, err)
+
+		added, err := repo.AddUserAs(actor, "Public", "managed")
+		assert.NoError(t, err)
+		assert.NoError(t, repo.SuspendUserAs(actor, "Public", added.Key, true))
+		assert.Error(t, repo.SetRoleAs(actor, "Public", added.Key, auth.RoleServerAdmin))
+		_, err = repo.AddUserAs(actor, "Private", "other")
+		assert.IsType(t, auth.PermissionError{}, err)
+
+		users, err := repo.UsersAs(actor, "Public")
+		assert.NoError(t, err)
+		assert.Len(t, users, 3)
+	})
+
+	t.Run("deletion", func(t *testing.T) {
+		assert.NoError(t, repo.DelUser("Public", user.Key))
+		assert.Error(t, repo.DelUser("Public", user.Key))
+		_, err := repo.Authenticate("Public", "noeh", user.Key)
+		assert.Equal(t, auth.AuthenticationError{Code: "432", Msg: "Account terminated"}, err)
+
+		assert.NoError(t, repo.DelOrg("Public"))
+		assert.Error(t, repo.DelOrg("Public"))
+		_, err = repo.AddUser("Public", "late")
+		assert.Error(t, err)
+	})
+
+	t.Run("maintenance", func(t *testing.T) {
+		assert.False(t, repo.InMaintenance())
+		assert.NoError(t, repo.SetMaintenance(true))
+		assert.True(t, repo.InMaintenance())
+	})
+}
@@ -0,0 +1,149 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	gosync "sync"
+	"time"
+)
+
+// mergesFile is the user sidecar file holding the last merges, one JSON
+// record per line, oldest first.
+const mergesFile = "merges.log"
+
+// mergesMu serializes the merges files rewrites, a user can sync from several
+// clients at once.
+var mergesMu gosync.Mutex
+
+// MergeRecord is the compact decision log of a task merge, to find out why a
+// change was lost.
+type MergeRecord struct {
+	Time     time.Time `json:"time"`
+	UUID     string    `json:"uuid"`
+	Strategy string    `json:"strategy"`
+	// Attributes are the side, "client" or "server", of the modification
+	// that last set or removed every attribute changed by the merge.
+	Attributes map[string]string `json:"attributes"`
+	Steps      []MergeDecision   `json:"steps"`
+}
+
+// MergeDecision is a modification applied by a merge, in order.
+type MergeDecision struct {
+	Source   string    `json:"source"`
+	Modified time.Time `json:"modified"`
+	// Changed are the attributes the modification added or modified.
+	Changed []string `json:"changed,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// RecordMerges appends the merge records of the user with the given key, in
+// the repository located in dataDir, keeping only the last size ones.
+func RecordMerges(dataDir, orgName, userKey string, records []MergeRecord, size int) error {
+	if len(records) == 0 || size <= 0 {
+		return nil
+	}
+
+	mergesMu.Lock()
+	defer mergesMu.Unlock()
+
+	path, err := mergesPath(dataDir, orgName, userKey)
+	if err != nil {
+		return err
+	}
+	lines, err := readMergeLines(path)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("encoding merge record: %w", err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) > size {
+		lines = lines[len(lines)-size:]
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := os.WriteFile(path+tempSuffix, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("saving merges: %w", err)
+	}
+	if err := os.Rename(path+tempSuffix, path); err != nil {
+		return fmt.Errorf("saving merges: %w", err)
+	}
+	return nil
+}
+
+// Merges returns the recorded merges of a user, oldest first, only the ones
+// of the task with the given uuid unless it's empty.
+func (r *Repository) Merges(orgName, userKey, uuid string) ([]MergeRecord, error) {
+	if _, err := r.getUser(orgName, userKey); err != nil {
+		return nil, err
+	}
+
+	path, err := mergesPath(r.baseDir, orgName, userKey)
+	if err != nil {
+		return nil, err
+	}
+	mergesMu.Lock()
+	lines, err := readMergeLines(path)
+	mergesMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]MergeRecord, 0)
+	for _, line := range lines {
+		var record MergeRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			log.Warnf("Ignoring invalid merge record %q: %v", line, err)
+			continue
+		}
+		if uuid == "" || record.UUID == uuid {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// readMergeLines returns the lines of a merges file, none if it doesn't
+// exist.
+func readMergeLines(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("loading merges: %w", err)
+	}
+	defer file.Close()
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, append([]byte(nil), line...))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("loading merges: %w", err)
+	}
+	return lines, nil
+}
+
+func mergesPath(dataDir, orgName, userKey string) (string, error) {
+	dir, err := orgDir(dataDir, orgName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, usersFolder, userKey, mergesFile), nil
+}
@@ -0,0 +1,73 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerges(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	repo, err := OpenRepository(tempRepo)
+	assert.Nil(t, err)
+	userKey := "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7"
+
+	record := func(uuid string, minute int) MergeRecord {
+		modified := time.Date(2021, 5, 3, 10, minute, 0, 0, time.UTC)
+		return MergeRecord{
+			Time:       modified.Add(time.Hour),
+			UUID:       uuid,
+			Strategy:   "timestamp",
+			Attributes: map[string]string{"description": "client"},
+			Steps:      []MergeDecision{{Source: "client", Modified: modified, Changed: []string{"description"}}},
+		}
+	}
+
+	t.Run("never merged", func(t *testing.T) {
+		records, err := repo.Merges("Public", userKey, "")
+		assert.Nil(t, err)
+		assert.Empty(t, records)
+	})
+
+	t.Run("record and read", func(t *testing.T) {
+		first, second := record("task-1", 1), record("task-2", 2)
+		assert.Nil(t, RecordMerges(tempRepo, "Public", userKey, []MergeRecord{first, second}, 3))
+
+		records, err := repo.Merges("Public", userKey, "")
+		assert.Nil(t, err)
+		assert.Equal(t, []MergeRecord{first, second}, records)
+
+		records, err = repo.Merges("Public", userKey, "task-2")
+		assert.Nil(t, err)
+		assert.Equal(t, []MergeRecord{second}, records)
+	})
+
+	t.Run("only the last ones are kept", func(t *testing.T) {
+		assert.Nil(t, RecordMerges(tempRepo, "Public", userKey, []MergeRecord{record("task-1", 3), record("task-1", 4)}, 3))
+
+		records, err := repo.Merges("Public", userKey, "")
+		assert.Nil(t, err)
+		assert.Len(t, records, 3)
+		assert.Equal(t, "task-2", records[0].UUID)
+		assert.Equal(t, 4, records[2].Steps[0].Modified.Minute())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		assert.Nil(t, RecordMerges(tempRepo, "Public", userKey, []MergeRecord{record("task-3", 5)}, 0))
+
+		records, err := repo.Merges("Public", userKey, "task-3")
+		assert.Nil(t, err)
+		assert.Empty(t, records)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		_, err := repo.Merges("Public", "invalid", "")
+		assert.NotNil(t, err)
+	})
+}
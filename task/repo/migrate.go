@@ -0,0 +1,199 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SchemaVersion is the version of the repository layout this build
+	// reads and writes.
+	SchemaVersion = 1
+
+	// schemaFile holds the schema version of the repository, the repositories
+	// created before it was introduced are version 0.
+	schemaFile = "schema"
+
+	// backupsFolder holds the copies of the repository taken before the
+	// destructive migrations.
+	backupsFolder = "backups"
+)
+
+// Migration upgrades the repository layout to a schema version.
+type Migration struct {
+	// Version is the schema version the repository is upgraded to.
+	Version int `json:"version"`
+	// Description tells what the migration does.
+	Description string `json:"description"`
+	// Destructive migrations rewrite or remove data, the repository is backed
+	// up before running them.
+	Destructive bool `json:"destructive"`
+	// Apply migrates the repository located in the data directory.  It has
+	// to be idempotent, an interrupted migration runs again.
+	Apply func(dataDir string) error `json:"-"`
+}
+
+// migrations are the known migrations, one per schema version up to
+// SchemaVersion.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "stamp the repository schema version",
+		Apply:       func(string) error { return nil },
+	},
+}
+
+// SchemaError means the repository has a schema version this build can't
+// handle.
+type SchemaError struct {
+	Version int
+}
+
+func (e SchemaError) Error() string {
+	return fmt.Sprintf("repository schema version %d is newer than the supported %d, upgrade gotas", e.Version, SchemaVersion)
+}
+
+// ReadSchemaVersion returns the schema version of the repository located in
+// dataDir, 0 if it isn't stamped.
+func ReadSchemaVersion(dataDir string) (int, error) {
+	content, err := os.ReadFile(filepath.Join(dataDir, schemaFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid schema version %q", strings.TrimSpace(string(content)))
+	}
+	return version, nil
+}
+
+// writeSchemaVersion stamps the repository with the schema version.
+func writeSchemaVersion(dataDir string, version int) error {
+	path := filepath.Join(dataDir, schemaFile)
+	if err := os.WriteFile(path+tempSuffix, []byte(strconv.Itoa(version)+"\n"), 0644); err != nil {
+		return fmt.Errorf("writing schema version: %w", err)
+	}
+	if err := os.Rename(path+tempSuffix, path); err != nil {
+		return fmt.Errorf("writing schema version: %w", err)
+	}
+	return nil
+}
+
+// PendingMigrations returns the migrations the repository located in dataDir
+// needs, in order.  A repository newer than SchemaVersion is a SchemaError.
+func PendingMigrations(dataDir string) ([]Migration, error) {
+	version, err := ReadSchemaVersion(dataDir)
+	if err != nil {
+		return nil, err
+	} else if version > SchemaVersion {
+		return nil, SchemaError{version}
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+	return pending, nil
+}
+
+// Migrate runs the pending migrations of the repository located in dataDir,
+// stamping the schema version after each one, and returns the ones applied.
+// The repository is backed up, once, before the first destructive migration.
+func Migrate(dataDir string) ([]Migration, error) {
+	pending, err := PendingMigrations(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	backedUp := false
+	for _, m := range pending {
+		if m.Destructive && !backedUp {
+			backup, err := backupRepository(dataDir)
+			if err != nil {
+				return applied, err
+			}
+			log.Infof("Repository backed up to %v", backup)
+			backedUp = true
+		}
+
+		if err := m.Apply(dataDir); err != nil {
+			return applied, fmt.Errorf("migrating to schema version %d (%s): %w", m.Version, m.Description, err)
+		}
+		if err := writeSchemaVersion(dataDir, m.Version); err != nil {
+			return applied, err
+		}
+		log.Infof("Migrated to schema version %d: %s", m.Version, m.Description)
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// UpgradeSchema is the check done when the server starts: the repository
+// located in dataDir is migrated if the pending migrations aren't
+// destructive, otherwise "gotas migrate" has to be run, and a repository
+// newer than SchemaVersion is a SchemaError.
+func UpgradeSchema(dataDir string) ([]Migration, error) {
+	pending, err := PendingMigrations(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range pending {
+		if m.Destructive {
+			return nil, fmt.Errorf("repository schema version %d needs a destructive migration, run \"gotas migrate\"", m.Version)
+		}
+	}
+	return Migrate(dataDir)
+}
+
+// backupRepository copies the configuration and the organizations of every
+// data root to a new backup folder, whose path is returned.
+func backupRepository(dataDir string) (string, error) {
+	roots, err := LoadRoots(dataDir)
+	if err != nil {
+		return "", fmt.Errorf("backing up the repository: %w", err)
+	}
+	version, err := ReadSchemaVersion(dataDir)
+	if err != nil {
+		return "", err
+	}
+
+	backup := filepath.Join(dataDir, backupsFolder, fmt.Sprintf("schema-%d-%s", version, time.Now().UTC().Format("20060102T150405Z")))
+	if err := os.MkdirAll(backup, 0700); err != nil {
+		return "", fmt.Errorf("backing up the repository: %w", err)
+	}
+
+	for _, name := range []string{"config", schemaFile} {
+		if _, err := os.Stat(filepath.Join(dataDir, name)); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyTree(filepath.Join(dataDir, name), filepath.Join(backup, name)); err != nil {
+			return "", fmt.Errorf("backing up the repository: %w", err)
+		}
+	}
+	for i, root := range roots.All() {
+		orgs := filepath.Join(root, orgsFolder)
+		if _, err := os.Stat(orgs); os.IsNotExist(err) {
+			continue
+		}
+		target := filepath.Join(backup, orgsFolder)
+		if i > 0 {
+			target = filepath.Join(backup, fmt.Sprintf("root-%d", i), orgsFolder)
+		}
+		if err := copyTree(orgs, target); err != nil {
+			return "", fmt.Errorf("backing up the repository: %w", err)
+		}
+	}
+	return backup, nil
+}
@@ -0,0 +1,144 @@
+package repo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrations(t *testing.T) {
+	last := 0
+	for _, m := range migrations {
+		assert.Equal(t, last+1, m.Version, "migrations out of order")
+		last = m.Version
+	}
+	assert.Equal(t, SchemaVersion, last)
+}
+
+func TestMigrate(t *testing.T) {
+	newRepo := func(t *testing.T) string {
+		t.Helper()
+		dir := tempDir(t)
+		repo, err := NewRepository(dir, nil)
+		assert.NoError(t, err)
+		_, err = repo.NewOrg("Public")
+		assert.NoError(t, err)
+		return dir
+	}
+
+	withMigrations := func(t *testing.T, replacement []Migration) {
+		previous := migrations
+		migrations = replacement
+		t.Cleanup(func() { migrations = previous })
+	}
+
+	t.Run("new repository is current", func(t *testing.T) {
+		dir := newRepo(t)
+		defer os.RemoveAll(dir)
+
+		version, err := ReadSchemaVersion(dir)
+		assert.NoError(t, err)
+		assert.Equal(t, SchemaVersion, version)
+
+		pending, err := PendingMigrations(dir)
+		assert.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+
+	t.Run("unstamped repository upgraded", func(t *testing.T) {
+		dir := newRepo(t)
+		defer os.RemoveAll(dir)
+		assert.NoError(t, os.Remove(filepath.Join(dir, schemaFile)))
+
+		applied, err := UpgradeSchema(dir)
+		assert.NoError(t, err)
+		assert.Len(t, applied, SchemaVersion)
+
+		version, err := ReadSchemaVersion(dir)
+		assert.NoError(t, err)
+		assert.Equal(t, SchemaVersion, version)
+	})
+
+	t.Run("newer repository refused", func(t *testing.T) {
+		dir := newRepo(t)
+		defer os.RemoveAll(dir)
+		assert.NoError(t, writeSchemaVersion(dir, SchemaVersion+1))
+
+		_, err := UpgradeSchema(dir)
+		assert.Equal(t, SchemaError{SchemaVersion + 1}, err)
+		_, err = Migrate(dir)
+		assert.Equal(t, SchemaError{SchemaVersion + 1}, err)
+	})
+
+	t.Run("invalid stamp", func(t *testing.T) {
+		dir := newRepo(t)
+		defer os.RemoveAll(dir)
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, schemaFile), []byte("one\n"), 0644))
+
+		_, err := ReadSchemaVersion(dir)
+		assert.Error(t, err)
+	})
+
+	t.Run("destructive migration backed up", func(t *testing.T) {
+		dir := newRepo(t)
+		defer os.RemoveAll(dir)
+		assert.NoError(t, os.Remove(filepath.Join(dir, schemaFile)))
+
+		marker := filepath.Join(dir, orgsFolder, "Public", "migrated")
+		runs := 0
+		withMigrations(t, []Migration{{
+			Version:     1,
+			Description: "rewrite",
+			Destructive: true,
+			Apply: func(dataDir string) error {
+				runs++
+				return os.WriteFile(marker, []byte("yes\n"), 0600)
+			},
+		}})
+
+		_, err := UpgradeSchema(dir)
+		assert.Error(t, err)
+		assert.Zero(t, runs)
+
+		applied, err := Migrate(dir)
+		assert.NoError(t, err)
+		assert.Len(t, applied, 1)
+		assert.Equal(t, 1, runs)
+		assert.FileExists(t, marker)
+
+		backups, err := os.ReadDir(filepath.Join(dir, backupsFolder))
+		assert.NoError(t, err)
+		assert.Len(t, backups, 1)
+		backup := filepath.Join(dir, backupsFolder, backups[0].Name())
+		assert.DirExists(t, filepath.Join(backup, orgsFolder, "Public"))
+		assert.FileExists(t, filepath.Join(backup, "config"))
+		assert.NoFileExists(t, filepath.Join(backup, orgsFolder, "Public", "migrated"))
+
+		// nothing left to do
+		applied, err = Migrate(dir)
+		assert.NoError(t, err)
+		assert.Empty(t, applied)
+		assert.Equal(t, 1, runs)
+	})
+
+	t.Run("failed migration not stamped", func(t *testing.T) {
+		dir := newRepo(t)
+		defer os.RemoveAll(dir)
+		assert.NoError(t, os.Remove(filepath.Join(dir, schemaFile)))
+
+		withMigrations(t, []Migration{{
+			Version:     1,
+			Description: "broken",
+			Apply:       func(string) error { return errors.New("disk full") },
+		}})
+
+		_, err := Migrate(dir)
+		assert.Error(t, err)
+		version, err := ReadSchemaVersion(dir)
+		assert.NoError(t, err)
+		assert.Zero(t, version)
+	})
+}
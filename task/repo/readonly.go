@@ -0,0 +1,44 @@
+package repo
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// readOnlyKey is the organization configuration entry making it read-only,
+// its users can sync but not upload changes.
+const readOnlyKey = "readonly"
+
+// SetOrgReadOnly sets or clears the read-only flag of an Organization.
+func (r *Repository) SetOrgReadOnly(orgName string, readOnly bool) error {
+	org, err := r.GetOrg(orgName)
+	if err != nil {
+		return err
+	} else if !org.Deleted.IsZero() {
+		return newError(ErrOrgDeleted, "organization %q is deleted", orgName)
+	}
+
+	value := ""
+	if readOnly {
+		value = strconv.FormatBool(readOnly)
+	}
+	if err := r.setOrgConfig(orgName, readOnlyKey, value); err != nil {
+		return fmt.Errorf("setting org read-only: %w", err)
+	}
+
+	return nil
+}
+
+// parseReadOnly parses the read-only flag, values that can't be parsed don't
+// set it.
+func parseReadOnly(value string) bool {
+	if value == "" {
+		return false
+	}
+
+	readOnly, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Warnf("Invalid read-only flag %q: %v", value, err)
+	}
+	return readOnly
+}
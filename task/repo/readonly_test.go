@@ -0,0 +1,41 @@
+package repo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnly(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	repo, err := OpenRepository(tempRepo)
+	assert.Nil(t, err)
+
+	t.Run("toggles the flag", func(t *testing.T) {
+		assert.NoError(t, repo.SetOrgReadOnly("Public", true))
+		org, err := repo.GetOrg("Public")
+		assert.NoError(t, err)
+		assert.True(t, org.ReadOnly)
+		assert.True(t, org.Users[0].Org.ReadOnly)
+		assert.NotContains(t, org.Settings, readOnlyKey)
+
+		assert.NoError(t, repo.SetOrgReadOnly("Public", false))
+		org, err = repo.GetOrg("Public")
+		assert.NoError(t, err)
+		assert.False(t, org.ReadOnly)
+	})
+
+	t.Run("fails with invalid organizations", func(t *testing.T) {
+		assert.True(t, errors.Is(repo.SetOrgReadOnly("invalid", true), ErrOrgNotFound))
+
+		assert.NoError(t, repo.DelOrg("Public"))
+		assert.True(t, errors.Is(repo.SetOrgReadOnly("Public", true), ErrOrgDeleted))
+	})
+}
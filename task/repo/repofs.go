@@ -1,10 +1,12 @@
 package repo
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/szaffarano/gotas/config"
@@ -13,10 +15,15 @@ import (
 )
 
 const (
-	orgsFolder  = "orgs"
-	usersFolder = "users"
-	txFile      = "tx.data"
-	txFileTemp  = "tx.tmp.data"
+	orgsFolder   = "orgs"
+	usersFolder  = "users"
+	groupsFolder = "groups"
+	txFile       = "tx.data"
+	txFileTemp   = "tx.tmp.data"
+
+	// maintenanceFile is the flag file that puts the server in maintenance
+	// mode while it exists.
+	maintenanceFile = "maintenance"
 )
 
 var log *logger.Logger
@@ -29,26 +36,27 @@ func init() {
 // ABM, initialization, etc.
 type Repository struct {
 	baseDir string
-	orgs    []auth.Organization
+	cache   *orgCache
+	hooks   Hooks
 }
 
 // NewRepository create a brand new repository in the given dataDir
 func NewRepository(dataDir string, defaultConfig map[string]string) (*Repository, error) {
 	if fileInfo, err := os.Stat(dataDir); err != nil {
-		return nil, fmt.Errorf("read dir info %v: %v", dataDir, err)
+		return nil, fmt.Errorf("read dir info %v: %w", dataDir, err)
 	} else if !fileInfo.IsDir() {
 		return nil, fmt.Errorf("%v: directory expected", dataDir)
 	} else if dataDir, err = filepath.Abs(dataDir); err != nil {
-		return nil, fmt.Errorf("calculate dir absolute path %v: %v", dataDir, err)
+		return nil, fmt.Errorf("calculate dir absolute path %v: %w", dataDir, err)
 	} else if files, err := os.ReadDir(dataDir); err != nil {
-		return nil, fmt.Errorf("list dir %v: %v", dataDir, err)
+		return nil, fmt.Errorf("list dir %v: %w", dataDir, err)
 	} else if len(files) > 0 {
 		return nil, fmt.Errorf("%s: not empty", dataDir)
 	}
 
 	orgPath := filepath.Join(dataDir, orgsFolder)
 	if err := os.Mkdir(orgPath, 0755); err != nil {
-		return nil, fmt.Errorf("create initial structure %v: %v", orgPath, err)
+		return nil, fmt.Errorf("create initial structure %v: %w", orgPath, err)
 	}
 
 	configFilePath := filepath.Join(dataDir, "config")
@@ -65,98 +73,131 @@ func NewRepository(dataDir string, defaultConfig map[string]string) (*Repository
 		return nil, err
 	}
 
-	return &Repository{baseDir: dataDir}, nil
+	if err := writeSchemaVersion(dataDir, SchemaVersion); err != nil {
+		return nil, err
+	}
+
+	return &Repository{baseDir: dataDir, cache: newOrgCache(), hooks: loadHooks(dataDir)}, nil
 }
 
-// OpenRepository loads a repository from file system.
+// OpenRepository opens a repository from file system.  The organizations and
+// their users are loaded lazily, on the first lookup, and cached until they
+// change.
 func OpenRepository(dataDir string) (*Repository, error) {
+	roots, err := LoadRoots(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("opening repository: %v (%w)", dataDir, err)
+	}
+	if _, err := roots.orgNames(); err != nil {
+		return nil, fmt.Errorf("opening repository: %v (%w)", dataDir, err)
+	}
 
-	orgsRoot := filepath.Join(dataDir, orgsFolder)
-	var orgsToAdd []string
-	err := filepath.WalkDir(orgsRoot, func(_ string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if d.IsDir() {
-			if d.Name() == orgsFolder {
-				return nil
-			}
-			orgsToAdd = append(orgsToAdd, d.Name())
-			return fs.SkipDir
-		}
+	return &Repository{baseDir: dataDir, cache: newOrgCache(), hooks: loadHooks(dataDir)}, nil
+}
 
+// Orgs returns the repository organizations.
+func (r *Repository) Orgs() []auth.Organization {
+	roots, err := LoadRoots(r.baseDir)
+	if err != nil {
+		log.Warnf("Listing organizations: %v", err)
 		return nil
-	})
-
+	}
+	names, err := roots.orgNames()
 	if err != nil {
-		return nil, fmt.Errorf("opening repository: %v (%v)", dataDir, err)
+		log.Warnf("Listing organizations: %v", err)
+		return nil
 	}
 
-	repo := Repository{baseDir: dataDir}
-	for _, orgName := range orgsToAdd {
-		org, err := repo.GetOrg(orgName)
+	var orgs []auth.Organization
+	for _, orgName := range names {
+		org, err := r.GetOrg(orgName)
 		if err != nil {
 			log.Warnf("Ignoring organization %q:  %v", orgName, err)
 			continue
 		}
-		repo.orgs = append(repo.orgs, *org)
+		orgs = append(orgs, *org)
 	}
-
-	return &repo, nil
+	return orgs
 }
 
-// NewOrg initializes a new Organization creating the underlying file system structure.
+// NewOrg initializes a new Organization creating the underlying file system
+// structure, applying the template of the repository configuration, if any.
 func (r *Repository) NewOrg(orgName string) (*auth.Organization, error) {
-	for _, org := range r.orgs {
-		if org.Name == orgName {
-			return nil, fmt.Errorf("organization %q already exists", orgName)
-		}
-	}
+	return r.NewOrgFromTemplate(orgName, nil)
+}
 
-	newOrgPath := filepath.Join(r.baseDir, orgsFolder, orgName)
+func (r *Repository) newOrg(orgName string, settings map[string]string) (*auth.Organization, error) {
+	roots, err := LoadRoots(r.baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(roots.OrgDir(orgName)); err == nil {
+		return nil, newError(ErrOrgExists, "organization %q already exists", orgName)
+	}
+	root := roots.Locate(orgName)
+	if err := os.MkdirAll(filepath.Join(root, orgsFolder), 0755); err != nil {
+		return nil, fmt.Errorf("creating orgs dir: %w", err)
+	}
+	newOrgPath := filepath.Join(root, orgsFolder, orgName)
 	if err := os.Mkdir(newOrgPath, 0775); err != nil {
-		return nil, fmt.Errorf("creating new org: %v", err)
+		return nil, fmt.Errorf("creating new org: %w", err)
 	}
 	if err := os.Mkdir(filepath.Join(newOrgPath, usersFolder), 0775); err != nil {
-		return nil, fmt.Errorf("creating users dir under org: %v", err)
+		return nil, fmt.Errorf("creating users dir under org: %w", err)
+	}
+	if len(settings) > 0 {
+		if err := r.saveOrgSettings(orgName, settings); err != nil {
+			return nil, err
+		}
 	}
 
-	newOrg := auth.Organization{Name: orgName}
-	r.orgs = append(r.orgs, newOrg)
+	newOrg := auth.Organization{Name: orgName, Settings: settings}
+	r.changed()
+
+	r.hooks.run(HookEvent{Event: HookOrgAdded, Org: orgName, Settings: settings})
 
 	return &newOrg, nil
 }
 
-// DelOrg deletes a given Organization.
+// DelOrg marks a given Organization as deleted.  Its data is kept on disk
+// until purged, and its users can't authenticate anymore.
 func (r *Repository) DelOrg(orgName string) error {
-	foundIdx := -1
-	for i := 0; i < len(r.orgs) && foundIdx == -1; i++ {
-		if r.orgs[i].Name == orgName {
-			foundIdx = i
-		}
-	}
-	if foundIdx == -1 {
-		return fmt.Errorf("organization %q does not exists", orgName)
+	org, err := r.GetOrg(orgName)
+	if err != nil {
+		return err
+	} else if !org.Deleted.IsZero() {
+		return newError(ErrOrgDeleted, "organization %q already deleted", orgName)
 	}
 
-	orgPath := filepath.Join(r.baseDir, orgsFolder, orgName)
-	if err := os.RemoveAll(orgPath); err != nil {
-		return fmt.Errorf("deleting org: %v", err)
+	now := time.Now().UTC().Truncate(time.Second)
+	if err := r.setOrgConfig(orgName, deletedKey, now.Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("deleting org: %w", err)
 	}
 
-	r.orgs[foundIdx] = r.orgs[len(r.orgs)-1]
-	r.orgs = r.orgs[:len(r.orgs)-1]
-
 	return nil
 }
 
-// GetOrg initializes an Organization reading the information from the underlying file system.
+// GetOrg returns an Organization with its users, reading them from the
+// underlying file system unless they are cached.
 func (r *Repository) GetOrg(orgName string) (*auth.Organization, error) {
+	org, err := r.cachedOrg(orgName)
+	if err != nil {
+		return nil, err
+	}
+	return copyOrg(org), nil
+}
+
+// loadOrg reads an Organization and its users from the underlying file
+// system.
+func (r *Repository) loadOrg(orgName string) (*auth.Organization, error) {
 	var users []auth.User
-	root := filepath.Join(r.baseDir, orgsFolder, orgName, usersFolder)
+	dir, err := orgDir(r.baseDir, orgName)
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Join(dir, usersFolder)
 
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -167,9 +208,18 @@ func (r *Repository) GetOrg(orgName string) (*auth.Organization, error) {
 			}
 			userConfigPath := filepath.Join(path, "config")
 			if userConfig, err := config.Load(userConfigPath); err == nil {
+				role, err := auth.ParseRole(userConfig.Get(roleKey))
+				if err != nil {
+					log.Warnf("Ignoring the role of user %q: %v", d.Name(), err)
+					role = auth.RoleUser
+				}
 				users = append(users, auth.User{
-					Key:  d.Name(),
-					Name: userConfig.Get("user"),
+					Key:       d.Name(),
+					Name:      userConfig.Get("user"),
+					Group:     userConfig.Get(groupKey),
+					Role:      role,
+					Deleted:   loadDeleted(userConfig.Get(deletedKey)),
+					Suspended: loadDeleted(userConfig.Get(suspendedKey)),
 				})
 			} else {
 				log.Warnf("Ignoring user %q: %v", d.Name(), err)
@@ -179,11 +229,25 @@ func (r *Repository) GetOrg(orgName string) (*auth.Organization, error) {
 		}
 		return nil
 	})
-	if err != nil {
-		return nil, fmt.Errorf("getting users: %v", err)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, newError(ErrOrgNotFound, "organization %q does not exists", orgName)
+	} else if err != nil {
+		return nil, fmt.Errorf("getting users: %w", err)
 	}
 
 	org := auth.Organization{Name: orgName, Users: users}
+	if orgConfig, err := config.Load(filepath.Join(dir, "config")); err == nil {
+		org.Deleted = loadDeleted(orgConfig.Get(deletedKey))
+		org.ReadOnly = parseReadOnly(orgConfig.Get(readOnlyKey))
+		for _, key := range orgConfig.Keys() {
+			if value := orgConfig.Get(key); key != deletedKey && key != readOnlyKey && value != "" {
+				if org.Settings == nil {
+					org.Settings = make(map[string]string)
+				}
+				org.Settings[key] = value
+			}
+		}
+	}
 	for idx := range users {
 		users[idx].Org = &org
 	}
@@ -195,64 +259,139 @@ func (r *Repository) AddUser(orgName string, userName string) (*auth.User, error
 	org, err := r.GetOrg(orgName)
 	if err != nil {
 		return nil, err
+	} else if !org.Deleted.IsZero() {
+		return nil, newError(ErrOrgDeleted, "organization %q is deleted", orgName)
 	}
 
 	for _, u := range org.Users {
 		if u.Name == userName {
-			return nil, fmt.Errorf("user %q already exists", userName)
+			return nil, newError(ErrUserExists, "user %q already exists", userName)
 		}
 	}
 
 	key := uuid.New().String()
-	userPath := filepath.Join(r.baseDir, orgsFolder, org.Name, usersFolder, key)
+	dir, err := orgDir(r.baseDir, org.Name)
+	if err != nil {
+		return nil, err
+	}
+	userPath := filepath.Join(dir, usersFolder, key)
 	if err := os.Mkdir(userPath, 0755); err != nil {
-		return nil, fmt.Errorf("creating user home: %v", err)
+		return nil, fmt.Errorf("creating user home: %w", err)
 	}
 
 	cfg, err := config.New(filepath.Join(userPath, "config"))
 	if err != nil {
-		return nil, fmt.Errorf("creating user config: %v", err)
+		return nil, fmt.Errorf("creating user config: %w", err)
+	}
+	for key, value := range userDefaults(org.Settings) {
+		cfg.Set(key, value)
 	}
 	cfg.Set("user", userName)
 	if err := config.Save(cfg); err != nil {
-		return nil, fmt.Errorf("saving user config: %v", err)
+		return nil, fmt.Errorf("saving user config: %w", err)
 	}
+	r.changed()
+
+	r.hooks.run(HookEvent{Event: HookUserAdded, Org: org.Name, User: userName, Key: key})
 
 	return &auth.User{
 		Name: userName,
 		Key:  key,
 		Org:  org,
+		Role: auth.RoleUser,
 	}, nil
 }
 
-// DelUser deletes a given user from an Organization.  User
+// DelUser marks a given user from an Organization as deleted.  Its data is
+// kept on disk until purged, and it can't authenticate anymore.
 func (r *Repository) DelUser(orgName string, userKey string) error {
-	org, err := r.GetOrg(orgName)
+	user, err := r.getUser(orgName, userKey)
 	if err != nil {
 		return err
+	} else if !user.Deleted.IsZero() {
+		return newError(ErrUserDeleted, "user %q already deleted", userKey)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	if err := r.setUserConfig(orgName, userKey, deletedKey, now.Format(time.RFC3339)); err != nil {
+		return err
 	}
 
-	foundIdx := -1
-	for i := 0; i < len(org.Users) && foundIdx == -1; i++ {
-		if org.Users[i].Key == userKey {
-			foundIdx = i
+	r.hooks.run(HookEvent{Event: HookUserRemoved, Time: now, Org: orgName, User: user.Name, Key: userKey})
+
+	return nil
+}
+
+// SetMaintenance turns the maintenance mode on or off.  While it's on, the
+// server keeps listening but rejects sync requests, so the data can be safely
+// backed up.
+func (r *Repository) SetMaintenance(on bool) error {
+	flagPath := filepath.Join(r.baseDir, maintenanceFile)
+
+	if !on {
+		if err := os.Remove(flagPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("turning maintenance mode off: %w", err)
 		}
+		return nil
+	}
+
+	file, err := os.Create(flagPath)
+	if err != nil {
+		return fmt.Errorf("turning maintenance mode on: %w", err)
 	}
-	if foundIdx == -1 {
-		return fmt.Errorf("user %q does not exists", userKey)
+	return file.Close()
+}
+
+// InMaintenance returns true if the repository is in maintenance mode.
+func (r *Repository) InMaintenance() bool {
+	return InMaintenance(r.baseDir)
+}
+
+// InMaintenance returns true if the repository located in dataDir is in
+// maintenance mode.
+func InMaintenance(dataDir string) bool {
+	_, err := os.Stat(filepath.Join(dataDir, maintenanceFile))
+	return err == nil
+}
+
+// setUserConfig sets a user configuration entry.
+func (r *Repository) setUserConfig(orgName, userKey, key, value string) error {
+	org, err := r.GetOrg(orgName)
+	if err != nil {
+		return err
 	}
 
-	userPath := filepath.Join(r.baseDir, orgsFolder, org.Name, usersFolder, org.Users[foundIdx].Key)
-	if err := os.RemoveAll(userPath); err != nil {
-		return fmt.Errorf("removing user home: %v", err)
+	found := false
+	for _, u := range org.Users {
+		found = found || u.Key == userKey
+	}
+	if !found {
+		return newError(ErrUserNotFound, "user %q does not exists", userKey)
 	}
 
-	org.Users[foundIdx] = org.Users[len(r.orgs)-1]
-	org.Users = org.Users[:len(r.orgs)-1]
+	dir, err := orgDir(r.baseDir, orgName)
+	if err != nil {
+		return err
+	}
+	configPath := filepath.Join(dir, usersFolder, userKey, "config")
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading user config: %w", err)
+	}
+	cfg.Set(key, value)
+	if err := config.Save(cfg); err != nil {
+		return fmt.Errorf("saving user config: %w", err)
+	}
+	r.changed()
 
 	return nil
 }
 
+// SetHooks replaces the hooks run on the repository changes.
+func (r *Repository) SetHooks(hooks Hooks) {
+	r.hooks = hooks
+}
+
 func (r *Repository) String() string {
 	return r.baseDir
 }
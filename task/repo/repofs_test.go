@@ -68,7 +68,7 @@ func TestOpenRepository(t *testing.T) {
 		repo, err := OpenRepository(filepath.Join("testdata", "repo_one"))
 
 		assert.Nil(t, err)
-		assert.Equal(t, 2, len(repo.orgs))
+		assert.Equal(t, 2, len(repo.Orgs()))
 	})
 
 	t.Run("open repository fails with non existent data directory", func(t *testing.T) {
@@ -119,21 +119,21 @@ func TestGetOrganization(t *testing.T) {
 }
 
 func TestNewOrganization(t *testing.T) {
-	repo, err := OpenRepository(filepath.Join("testdata", "repo_one"))
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	repo, err := OpenRepository(tempRepo)
 	assert.Nil(t, err)
 
 	t.Run("new organization works with valid data dir", func(t *testing.T) {
-		before := len(repo.orgs)
+		before := len(repo.Orgs())
 		org, err := repo.NewOrg("delete-me")
 		assert.Nil(t, err)
-		defer func() {
-			if err := os.RemoveAll(filepath.Join("testdata", "repo_one", "orgs", "delete-me")); err != nil {
-				t.Fatal(err)
-			}
-		}()
 
 		assert.Equal(t, "delete-me", org.Name)
-		assert.Equal(t, before+1, len(repo.orgs))
+		assert.Equal(t, before+1, len(repo.Orgs()))
 	})
 
 	t.Run("new organization fails if already exists", func(t *testing.T) {
@@ -158,31 +158,46 @@ func TestDelOrganization(t *testing.T) {
 	repo, err := OpenRepository(tempRepo)
 	assert.Nil(t, err)
 
-	t.Run("removes existing organization", func(t *testing.T) {
-		before := len(repo.orgs)
+	t.Run("marks existing organization as deleted", func(t *testing.T) {
+		before := len(repo.Orgs())
 		err := repo.DelOrg("Public")
 		assert.Nil(t, err)
 
-		assert.Equal(t, before-1, len(repo.orgs))
+		assert.Equal(t, before, len(repo.Orgs()))
+		assert.DirExists(t, filepath.Join(tempRepo, "orgs", "Public"))
+
+		org, err := repo.GetOrg("Public")
+		assert.Nil(t, err)
+		assert.False(t, org.Deleted.IsZero())
+	})
+
+	t.Run("removes organization fails if already deleted", func(t *testing.T) {
+		err := repo.DelOrg("Public")
+		assert.NotNil(t, err)
 	})
 
 	t.Run("removes organization fails if does not exists", func(t *testing.T) {
-		err := repo.DelOrg("Public")
+		err := repo.DelOrg("invalid")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("add user fails in deleted organization", func(t *testing.T) {
+		_, err := repo.AddUser("Public", "john")
 		assert.NotNil(t, err)
 	})
 
 }
 
 func TestNewUser(t *testing.T) {
-	repo, err := OpenRepository(filepath.Join("testdata", "repo_one"))
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	repo, err := OpenRepository(tempRepo)
 	assert.Nil(t, err)
 	org, err := repo.NewOrg("delete-me")
 	assert.Nil(t, err)
-	defer func() {
-		if err := os.RemoveAll(filepath.Join("testdata", "repo_one", "orgs", "delete-me")); err != nil {
-			t.Fatal(err)
-		}
-	}()
 
 	t.Run("add user works with valid organization", func(t *testing.T) {
 		user, err := repo.AddUser("delete-me", "user_one")
@@ -221,6 +236,12 @@ func TestDelUser(t *testing.T) {
 		err := repo.DelUser("Public", "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7")
 
 		assert.NoError(t, err)
+		assert.FileExists(t, filepath.Join(tempRepo, "orgs", "Public", "users", "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7", "tx.data"))
+	})
+
+	t.Run("del deleted user fails", func(t *testing.T) {
+		err := repo.DelUser("Public", "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7")
+		assert.Error(t, err)
 	})
 
 	t.Run("del non org user fails", func(t *testing.T) {
@@ -235,6 +256,28 @@ func TestDelUser(t *testing.T) {
 
 }
 
+func TestMaintenance(t *testing.T) {
+	baseDir := tempDir(t)
+	defer os.RemoveAll(baseDir)
+
+	repo, err := NewRepository(baseDir, defaultConfig)
+	assert.Nil(t, err)
+	assert.False(t, repo.InMaintenance())
+
+	assert.Nil(t, repo.SetMaintenance(true))
+	assert.True(t, repo.InMaintenance())
+	assert.True(t, InMaintenance(baseDir))
+
+	assert.Nil(t, repo.SetMaintenance(true))
+	assert.True(t, repo.InMaintenance())
+
+	assert.Nil(t, repo.SetMaintenance(false))
+	assert.False(t, repo.InMaintenance())
+
+	assert.Nil(t, repo.SetMaintenance(false))
+	assert.False(t, InMaintenance(baseDir))
+}
+
 func tempDir(t *testing.T) string {
 	t.Helper()
 
@@ -0,0 +1,115 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ResetUser truncates the transactions of a user, shared with the members of
+// its group if any, after copying its directory to the repository backups,
+// whose path is returned, empty if the user never synced.  With keepLatest
+// the latest version of every task is kept as a fresh baseline followed by a
+// new sync key, otherwise the transactions are emptied.  Either way the
+// archive is dropped and the clients have to sync from scratch.  The
+// repository must be in maintenance mode, so no sync appends meanwhile, and
+// the backup has the deduplicated tasks resolved, so it doesn't depend on
+// blobs the garbage collection removes once unreferenced.
+func (r *Repository) ResetUser(orgName, userKey string, keepLatest bool) (string, error) {
+	if !r.InMaintenance() {
+		return "", fmt.Errorf("resetting user %q: the repository must be in maintenance mode", userKey)
+	}
+
+	user, err := r.getUser(orgName, userKey)
+	if err != nil {
+		return "", err
+	} else if !user.Deleted.IsZero() {
+		return "", newError(ErrUserDeleted, "user %q is deleted", userKey)
+	}
+
+	orgPath, err := orgDir(r.baseDir, orgName)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(orgPath, usersFolder, user.Key)
+	if user.Group != "" {
+		dir = filepath.Join(orgPath, groupsFolder, user.Group)
+	}
+	path := filepath.Join(dir, txFile)
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("resetting user: %w", err)
+	}
+
+	backup := filepath.Join(r.baseDir, backupsFolder, fmt.Sprintf("reset-%s-%s-%s", orgName, filepath.Base(dir), time.Now().UTC().Format("20060102T150405Z")))
+	if err := copyTree(dir, backup); err != nil {
+		return "", fmt.Errorf("backing up the user: %w", err)
+	}
+	lines := strings.Split(string(content), "\n")
+	resolve := txBlobs(path).resolver()
+	resolved := make([]string, len(lines))
+	for i, line := range lines {
+		resolved[i] = resolve(line)
+	}
+	if err := os.WriteFile(filepath.Join(backup, txFile), []byte(strings.Join(resolved, "\n")), 0600); err != nil {
+		return "", fmt.Errorf("backing up the user: %w", err)
+	}
+
+	var baseline string
+	if keepLatest {
+		latest := latestTasks(lines, resolve)
+		if len(latest) > 0 {
+			baseline = strings.Join(latest, "\n") + "\n" + uuid.New().String() + "\n"
+		}
+	}
+
+	if err := os.WriteFile(path+tempSuffix, []byte(baseline), 0600); err != nil {
+		return backup, fmt.Errorf("resetting user: %w", err)
+	}
+	if err := os.Rename(path+tempSuffix, path); err != nil {
+		return backup, fmt.Errorf("resetting user: %w", err)
+	}
+	if err := os.Remove(filepath.Join(dir, archiveFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return backup, fmt.Errorf("resetting user: %w", err)
+	}
+	return backup, nil
+}
+
+// latestTasks returns the last version of every task in the transactions, in
+// the order the tasks first appear.  The deduplicated tasks are read with
+// resolve, but the lines returned still reference them.
+func latestTasks(lines []string, resolve func(string) string) []string {
+	var order []string
+	latest := make(map[string]string)
+	for _, line := range lines {
+		if line == "" || isSyncKey(line) {
+			continue
+		}
+
+		var task struct {
+			UUID string `json:"uuid"`
+		}
+		if err := json.Unmarshal([]byte(resolve(line)), &task); err != nil || task.UUID == "" {
+			continue
+		}
+		if _, ok := latest[task.UUID]; !ok {
+			order = append(order, task.UUID)
+		}
+		latest[task.UUID] = line
+	}
+
+	tasks := make([]string, 0, len(order))
+	for _, id := range order {
+		tasks = append(tasks, latest[id])
+	}
+	return tasks
+}
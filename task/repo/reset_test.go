@@ -0,0 +1,109 @@
+package repo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResetUser(t *testing.T) {
+	const userKey = "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7"
+	tx := strings.Join([]string{
+		`{"uuid":"1","description":"one","status":"pending"}`,
+		`{"uuid":"2","description":"two","status":"pending"}`,
+		"f2d3a6a1-9b2c-4e38-8c7b-5d0a1c3e4f51",
+		`{"uuid":"1","description":"one","status":"completed"}`,
+		"0b1c2d3e-4f50-4a61-8b72-93a4b5c6d7e8",
+	}, "\n") + "\n"
+
+	setup := func(t *testing.T) (*Repository, string, string) {
+		tempRepo := tempDir(t)
+		t.Cleanup(func() { os.RemoveAll(tempRepo) })
+		copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+		repo, err := OpenRepository(tempRepo)
+		assert.Nil(t, err)
+		assert.NoError(t, repo.SetMaintenance(true))
+
+		userDir := filepath.Join(tempRepo, orgsFolder, "Public", usersFolder, userKey)
+		assert.NoError(t, os.WriteFile(filepath.Join(userDir, txFile), []byte(tx), 0600))
+		assert.NoError(t, os.WriteFile(filepath.Join(userDir, archiveFile), []byte("key\n"), 0600))
+		return repo, tempRepo, userDir
+	}
+
+	t.Run("empties the transactions", func(t *testing.T) {
+		repo, tempRepo, userDir := setup(t)
+
+		backup, err := repo.ResetUser("Public", userKey, false)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(backup, filepath.Join(tempRepo, backupsFolder)))
+
+		content, err := os.ReadFile(filepath.Join(userDir, txFile))
+		assert.NoError(t, err)
+		assert.Empty(t, content)
+		assert.NoFileExists(t, filepath.Join(userDir, archiveFile))
+
+		saved, err := os.ReadFile(filepath.Join(backup, txFile))
+		assert.NoError(t, err)
+		assert.Equal(t, tx, string(saved))
+		assert.FileExists(t, filepath.Join(backup, archiveFile))
+	})
+
+	t.Run("keeps the latest tasks", func(t *testing.T) {
+		repo, _, userDir := setup(t)
+
+		_, err := repo.ResetUser("Public", userKey, true)
+		assert.NoError(t, err)
+
+		content, err := os.ReadFile(filepath.Join(userDir, txFile))
+		assert.NoError(t, err)
+		lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+		assert.Len(t, lines, 3)
+		assert.Equal(t, `{"uuid":"1","description":"one","status":"completed"}`, lines[0])
+		assert.Equal(t, `{"uuid":"2","description":"two","status":"pending"}`, lines[1])
+		_, err = uuid.Parse(lines[2])
+		assert.NoError(t, err)
+		assert.NotContains(t, tx, lines[2])
+	})
+
+	t.Run("resolves the deduplicated tasks in the backup", func(t *testing.T) {
+		repo, _, userDir := setup(t)
+		task := `{"uuid":"3","description":"three","status":"pending"}`
+		ref, err := orgBlobs(filepath.Dir(filepath.Dir(userDir))).put(task)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(userDir, txFile), []byte(ref+"\n"+tx), 0600))
+
+		backup, err := repo.ResetUser("Public", userKey, false)
+		assert.NoError(t, err)
+
+		saved, err := os.ReadFile(filepath.Join(backup, txFile))
+		assert.NoError(t, err)
+		assert.Equal(t, task+"\n"+tx, string(saved))
+	})
+
+	t.Run("requires the maintenance mode", func(t *testing.T) {
+		repo, _, userDir := setup(t)
+		assert.NoError(t, repo.SetMaintenance(false))
+
+		_, err := repo.ResetUser("Public", userKey, false)
+		assert.Error(t, err)
+		content, err := os.ReadFile(filepath.Join(userDir, txFile))
+		assert.NoError(t, err)
+		assert.Equal(t, tx, string(content))
+	})
+
+	t.Run("fails with invalid users", func(t *testing.T) {
+		repo, _, _ := setup(t)
+
+		_, err := repo.ResetUser("Public", "invalid", false)
+		assert.True(t, errors.Is(err, ErrUserNotFound))
+
+		_, err = repo.ResetUser("invalid", userKey, false)
+		assert.True(t, errors.Is(err, ErrOrgNotFound))
+	})
+}
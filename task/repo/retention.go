@@ -0,0 +1,200 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// taskDateLayout is the taskwarrior date format, as stored in the
+// transactions.
+const taskDateLayout = "20060102T150405Z"
+
+// Retention is how long the completed and deleted tasks are kept in the
+// transactions, zero means forever.  With Archive, the expired tasks are moved
+// to the user archive instead of dropped.
+type Retention struct {
+	Completed time.Duration
+	Deleted   time.Duration
+	Archive   bool
+}
+
+// Expired summarizes the tasks dropped, or to be dropped if Done is false,
+// from a transactions file.
+type Expired struct {
+	Path      string `json:"path"`
+	Completed int    `json:"completed"`
+	Deleted   int    `json:"deleted"`
+	Lines     int    `json:"lines"`
+	Archived  bool   `json:"archived,omitempty"`
+	Done      bool   `json:"done"`
+}
+
+func (e Expired) String() string {
+	status := "would drop"
+	switch {
+	case e.Done && e.Archived:
+		status = "archived"
+	case e.Done:
+		status = "dropped"
+	case e.Archived:
+		status = "would archive"
+	}
+	return fmt.Sprintf("%s: %s %d completed and %d deleted task(s), %d line(s)", e.Path, status, e.Completed, e.Deleted, e.Lines)
+}
+
+// Enabled returns true if any task has to be dropped eventually.
+func (r Retention) Enabled() bool {
+	return r.Completed > 0 || r.Deleted > 0
+}
+
+// EnforceRetention drops from the transactions stored in the repository
+// located in dataDir every version of the tasks completed or deleted longer
+// than the retention ago.  The sync keys are kept, so the clients can still
+// sync from any of them.  With checkOnly, the tasks are only reported.  Like
+// CollectGarbage, it's meant to be called before the server starts.
+func EnforceRetention(dataDir string, retention Retention, checkOnly bool) ([]Expired, error) {
+	var expired []Expired
+	if !retention.Enabled() {
+		return expired, nil
+	}
+
+	roots, err := LoadRoots(dataDir)
+	if err != nil {
+		return expired, fmt.Errorf("enforcing retention: %w", err)
+	}
+
+	now := time.Now()
+	walk := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() || d.Name() != txFile {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		kept, archived, result := retention.filter(strings.SplitAfter(string(content), "\n"), now, txBlobs(path).resolver())
+		if result.Lines == 0 {
+			return nil
+		}
+		result.Path, result.Archived = path, retention.Archive
+
+		if !checkOnly {
+			if retention.Archive {
+				if err := archive(path, kept, archived); err != nil {
+					return err
+				}
+			}
+			tempPath := filepath.Join(filepath.Dir(path), txFileTemp)
+			if err := os.WriteFile(tempPath, []byte(strings.Join(kept, "")), 0600); err != nil {
+				return err
+			}
+			if err := os.Rename(tempPath, path); err != nil {
+				return err
+			}
+			result.Done = true
+			log.Infof("Retention %v", result)
+		}
+		expired = append(expired, result)
+
+		return nil
+	}
+	for _, dir := range roots.orgsDirs() {
+		if err := filepath.WalkDir(dir, walk); err != nil {
+			return expired, fmt.Errorf("enforcing retention: %w", err)
+		}
+	}
+
+	return expired, nil
+}
+
+// filter returns the lines not belonging to an expired task, the last
+// version of a task decides whether it expired, and the ones that do grouped
+// by the sync key following them, see archiveFile.  The deduplicated tasks are
+// read with resolve, the lines kept still reference them while the expired
+// ones are resolved.  When archiving, the tasks after the last sync key are
+// kept, they have no group.
+func (r Retention) filter(lines []string, now time.Time, resolve func(string) string) ([]string, []string, Expired) {
+	type version struct {
+		UUID     string `json:"uuid"`
+		Status   string `json:"status"`
+		End      string `json:"end"`
+		Modified string `json:"modified"`
+	}
+
+	versions := make([]version, len(lines))
+	last := make(map[string]version)
+	for i, line := range lines {
+		if line = resolve(strings.TrimSuffix(line, "\n")); !strings.HasPrefix(line, "{") {
+			continue
+		}
+		// invalid tasks are left alone, fsck reports them
+		if err := json.Unmarshal([]byte(line), &versions[i]); err == nil && versions[i].UUID != "" {
+			last[versions[i].UUID] = versions[i]
+		}
+	}
+
+	var result Expired
+	expired := make(map[string]bool)
+	for uuid, v := range last {
+		var keep time.Duration
+		switch v.Status {
+		case "completed":
+			keep = r.Completed
+		case "deleted":
+			keep = r.Deleted
+		}
+
+		date := v.End
+		if date == "" {
+			date = v.Modified
+		}
+		finished, err := time.Parse(taskDateLayout, date)
+		if keep <= 0 || err != nil || now.Sub(finished) < keep {
+			continue
+		}
+
+		expired[uuid] = true
+		if v.Status == "completed" {
+			result.Completed++
+		} else {
+			result.Deleted++
+		}
+	}
+
+	archivable := len(lines)
+	if r.Archive {
+		archivable = 0
+		for i := len(lines) - 1; i >= 0; i-- {
+			if isSyncKey(strings.TrimSuffix(lines[i], "\n")) {
+				archivable = i
+				break
+			}
+		}
+	}
+
+	kept := make([]string, 0, len(lines))
+	var archived, group []string
+	for i, line := range lines {
+		if i < archivable && expired[versions[i].UUID] {
+			result.Lines++
+			group = append(group, resolve(strings.TrimSuffix(line, "\n")))
+			continue
+		}
+		if key := strings.TrimSuffix(line, "\n"); len(group) > 0 && isSyncKey(key) {
+			archived = append(append(archived, group...), key)
+			group = nil
+		}
+		kept = append(kept, line)
+	}
+
+	return kept, archived, result
+}
@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnforceRetention(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	recent := time.Now().UTC().Add(-time.Hour).Format(taskDateLayout)
+	lines := []string{
+		`{"uuid":"old-completed","status":"pending","modified":"20200101T000000Z"}`,
+		`{"uuid":"pending","status":"pending","modified":"20200101T000000Z"}`,
+		"0f4c4ba0-3c5a-4cd1-a8e4-36b8ba3b7d1e",
+		`{"uuid":"old-completed","status":"completed","end":"20200102T000000Z","modified":"20200102T000000Z"}`,
+		`{"uuid":"old-deleted","status":"deleted","end":"20200102T000000Z"}`,
+		`{"uuid":"recent-completed","status":"completed","end":"` + recent + `"}`,
+		"5b1b7ea0-7e8c-4d39-9b04-0b4bd5e2a6a1",
+		`{"uuid":"reopened","status":"completed","end":"20200102T000000Z"}`,
+		`{"uuid":"reopened","status":"pending","modified":"` + recent + `"}`,
+		"b1a4b0a8-69e3-4f0c-9c4a-3f1f1e3d6c2d",
+	}
+	content := strings.Join(lines, "\n") + "\n"
+
+	path := filepath.Join(tempRepo, orgsFolder, "Public", usersFolder, "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7", txFile)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+
+	t.Run("disabled", func(t *testing.T) {
+		expired, err := EnforceRetention(tempRepo, Retention{}, false)
+		assert.Nil(t, err)
+		assert.Empty(t, expired)
+	})
+
+	t.Run("check only", func(t *testing.T) {
+		expired, err := EnforceRetention(tempRepo, Retention{Completed: 24 * time.Hour}, true)
+		assert.Nil(t, err)
+		assert.Equal(t, []Expired{{Path: path, Completed: 1, Lines: 2}}, expired)
+
+		stored, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, content, string(stored))
+	})
+
+	t.Run("enforce", func(t *testing.T) {
+		expired, err := EnforceRetention(tempRepo, Retention{Completed: 24 * time.Hour, Deleted: 24 * time.Hour}, false)
+		assert.Nil(t, err)
+		assert.Equal(t, []Expired{{Path: path, Completed: 1, Deleted: 1, Lines: 3, Done: true}}, expired)
+
+		stored, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, strings.Join([]string{
+			lines[1], lines[2], lines[5], lines[6], lines[7], lines[8], lines[9],
+		}, "\n")+"\n", string(stored))
+		assert.NoFileExists(t, filepath.Join(filepath.Dir(path), txFileTemp))
+
+		expired, err = EnforceRetention(tempRepo, Retention{Completed: 24 * time.Hour, Deleted: 24 * time.Hour}, false)
+		assert.Nil(t, err)
+		assert.Empty(t, expired)
+	})
+}
@@ -0,0 +1,138 @@
+package repo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+const (
+	// roleKey is the user configuration entry holding its role.
+	roleKey = "role"
+
+	// suspendedKey is the user configuration entry holding the time it was
+	// suspended.  Suspended users keep their data but can't sync.
+	suspendedKey = "suspended"
+)
+
+// SetRole changes the role of a user.
+func (r *Repository) SetRole(orgName, userKey string, role auth.Role) error {
+	if _, err := auth.ParseRole(string(role)); err != nil {
+		return err
+	}
+	if _, err := r.getUser(orgName, userKey); err != nil {
+		return err
+	}
+
+	value := string(role)
+	if role == auth.RoleUser {
+		value = ""
+	}
+	return r.setUserConfig(orgName, userKey, roleKey, value)
+}
+
+// SuspendUser suspends a user, which can't sync until resumed.
+func (r *Repository) SuspendUser(orgName, userKey string) error {
+	user, err := r.getUser(orgName, userKey)
+	if err != nil {
+		return err
+	} else if !user.Suspended.IsZero() {
+		return newError(ErrUserSuspended, "user %q already suspended", userKey)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	return r.setUserConfig(orgName, userKey, suspendedKey, now.Format(time.RFC3339))
+}
+
+// ResumeUser undoes the suspension of a user.
+func (r *Repository) ResumeUser(orgName, userKey string) error {
+	user, err := r.getUser(orgName, userKey)
+	if err != nil {
+		return err
+	} else if user.Suspended.IsZero() {
+		return newError(ErrUserNotSuspended, "user %q is not suspended", userKey)
+	}
+
+	return r.setUserConfig(orgName, userKey, suspendedKey, "")
+}
+
+// AddUserAs adds a user like AddUser does, if the actor can manage the
+// organization.
+func (r *Repository) AddUserAs(actor auth.User, orgName, userName string) (*auth.User, error) {
+	if !actor.CanManage(orgName) {
+		return nil, forbidden(actor, "add users to organization %q", orgName)
+	}
+	return r.AddUser(orgName, userName)
+}
+
+// DelUserAs deletes a user like DelUser does, if the actor can manage it.
+func (r *Repository) DelUserAs(actor auth.User, orgName, userKey string) error {
+	if err := r.checkManages(actor, orgName, userKey, "delete"); err != nil {
+		return err
+	}
+	return r.DelUser(orgName, userKey)
+}
+
+// SuspendUserAs suspends or resumes a user, if the actor can manage it.
+func (r *Repository) SuspendUserAs(actor auth.User, orgName, userKey string, suspend bool) error {
+	if suspend {
+		if err := r.checkManages(actor, orgName, userKey, "suspend"); err != nil {
+			return err
+		}
+		return r.SuspendUser(orgName, userKey)
+	}
+
+	if err := r.checkManages(actor, orgName, userKey, "resume"); err != nil {
+		return err
+	}
+	return r.ResumeUser(orgName, userKey)
+}
+
+// SetRoleAs changes the role of a user, if the actor can manage it.  Only
+// the server admins can grant or revoke the server admin role.
+func (r *Repository) SetRoleAs(actor auth.User, orgName, userKey string, role auth.Role) error {
+	if err := r.checkManages(actor, orgName, userKey, "change the role of"); err != nil {
+		return err
+	} else if role == auth.RoleServerAdmin && actor.Role != auth.RoleServerAdmin {
+		return forbidden(actor, "grant the %v role", role)
+	}
+	return r.SetRole(orgName, userKey, role)
+}
+
+// UsersAs returns the users of an organization, if the actor can manage it.
+func (r *Repository) UsersAs(actor auth.User, orgName string) ([]auth.User, error) {
+	if !actor.CanManage(orgName) {
+		return nil, forbidden(actor, "list the users of organization %q", orgName)
+	}
+
+	org, err := r.GetOrg(orgName)
+	if err != nil {
+		return nil, err
+	}
+	return org.Users, nil
+}
+
+// checkManages verifies that the actor can manage the organization and the
+// user, org admins can't manage server admins.
+func (r *Repository) checkManages(actor auth.User, orgName, userKey, action string) error {
+	return checkManages(actor, orgName, userKey, action, r.getUser)
+}
+
+func checkManages(actor auth.User, orgName, userKey, action string, getUser func(orgName, userKey string) (auth.User, error)) error {
+	if !actor.CanManage(orgName) {
+		return forbidden(actor, "%s the users of organization %q", action, orgName)
+	}
+
+	user, err := getUser(orgName, userKey)
+	if err != nil {
+		return err
+	} else if user.Role == auth.RoleServerAdmin && actor.Role != auth.RoleServerAdmin {
+		return forbidden(actor, "%s the server admin %q", action, userKey)
+	}
+	return nil
+}
+
+func forbidden(actor auth.User, format string, args ...interface{}) error {
+	return auth.PermissionError{User: actor.Name, Msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,88 @@
+package repo
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestRoles(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+
+	repo, err := NewRepository(tempRepo, nil)
+	assert.NoError(t, err)
+	for _, org := range []string{"Public", "Private"} {
+		_, err := repo.NewOrg(org)
+		assert.NoError(t, err)
+	}
+
+	newUser := func(org, name string, role auth.Role) auth.User {
+		t.Helper()
+		user, err := repo.AddUser(org, name)
+		assert.NoError(t, err)
+		assert.NoError(t, repo.SetRole(org, user.Key, role))
+		found, err := repo.getUser(org, user.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, role, found.Role)
+		return found
+	}
+
+	root := newUser("Private", "root", auth.RoleServerAdmin)
+	alice := newUser("Public", "alice", auth.RoleOrgAdmin)
+	bob := newUser("Public", "bob", auth.RoleUser)
+	carol := newUser("Private", "carol", auth.RoleUser)
+
+	t.Run("invalid role", func(t *testing.T) {
+		assert.Error(t, repo.SetRole("Public", bob.Key, "superuser"))
+		assert.Error(t, repo.SetRole("Public", "invalid", auth.RoleOrgAdmin))
+	})
+
+	t.Run("org admin", func(t *testing.T) {
+		users, err := repo.UsersAs(alice, "Public")
+		assert.NoError(t, err)
+		assert.Len(t, users, 2)
+
+		_, err = repo.UsersAs(alice, "Private")
+		assert.IsType(t, auth.PermissionError{}, err)
+		_, err = repo.AddUserAs(alice, "Private", "mallory")
+		assert.IsType(t, auth.PermissionError{}, err)
+		assert.IsType(t, auth.PermissionError{}, repo.DelUserAs(alice, "Private", carol.Key))
+		assert.IsType(t, auth.PermissionError{}, repo.SetRoleAs(alice, "Public", bob.Key, auth.RoleServerAdmin))
+
+		dave, err := repo.AddUserAs(alice, "Public", "dave")
+		assert.NoError(t, err)
+		assert.NoError(t, repo.SetRoleAs(alice, "Public", dave.Key, auth.RoleOrgAdmin))
+		assert.NoError(t, repo.DelUserAs(alice, "Public", dave.Key))
+	})
+
+	t.Run("server admins can't be managed by org admins", func(t *testing.T) {
+		admin := newUser("Public", "admin", auth.RoleServerAdmin)
+		assert.IsType(t, auth.PermissionError{}, repo.SuspendUserAs(alice, "Public", admin.Key, true))
+		assert.IsType(t, auth.PermissionError{}, repo.DelUserAs(alice, "Public", admin.Key))
+		assert.NoError(t, repo.DelUserAs(root, "Public", admin.Key))
+	})
+
+	t.Run("regular users manage nothing", func(t *testing.T) {
+		_, err := repo.UsersAs(bob, "Public")
+		assert.IsType(t, auth.PermissionError{}, err)
+		assert.IsType(t, auth.PermissionError{}, repo.SuspendUserAs(bob, "Public", bob.Key, true))
+	})
+
+	t.Run("suspension", func(t *testing.T) {
+		authenticator, err := NewDefaultAuthenticator(tempRepo)
+		assert.NoError(t, err)
+
+		assert.NoError(t, repo.SuspendUserAs(alice, "Public", bob.Key, true))
+		assert.Error(t, repo.SuspendUser("Public", bob.Key))
+		_, err = authenticator.Authenticate("Public", "bob", bob.Key)
+		assert.Equal(t, auth.AuthenticationError{Code: "431", Msg: "Account suspended"}, err)
+
+		assert.NoError(t, repo.SuspendUserAs(root, "Public", bob.Key, false))
+		assert.Error(t, repo.ResumeUser("Public", bob.Key))
+		_, err = authenticator.Authenticate("Public", "bob", bob.Key)
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,378 @@
+package repo
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+
+	"github.com/szaffarano/gotas/config"
+)
+
+const (
+	// rootsKey is the repository configuration entry listing, comma
+	// separated, the data roots the organizations are sharded across besides
+	// the data directory, like "/disk2/gotas, /disk3/gotas".
+	rootsKey = "data.roots"
+
+	// rootPrefix prefixes the repository configuration entries mapping an
+	// organization to one of the roots, like "data.root.acme = /disk2/gotas".
+	rootPrefix = "data.root."
+
+	// rebalancePrefix prefixes the temporary copy of an organization being
+	// moved to another root.
+	rebalancePrefix = ".rebalance-"
+)
+
+// Roots are the data roots the organizations of a repository are stored in,
+// each one with its own "orgs" directory.  An organization lives in the root
+// it's explicitly mapped to, otherwise in the one it's found in, and the new
+// ones are placed by hashing their names.  With no extra root configured,
+// every organization lives in the data directory.
+type Roots struct {
+	base    string
+	extra   []string
+	mapping map[string]string
+}
+
+// LoadRoots reads the data roots configured in the repository located in
+// dataDir.  Relative roots are relative to dataDir.
+func LoadRoots(dataDir string) (Roots, error) {
+	roots := Roots{base: dataDir, mapping: make(map[string]string)}
+
+	configPath := filepath.Join(dataDir, "config")
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return roots, nil
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return Roots{}, fmt.Errorf("loading repository config: %w", err)
+	}
+
+	for _, root := range strings.Split(cfg.Get(rootsKey), ",") {
+		if root = strings.TrimSpace(root); root == "" {
+			continue
+		}
+		root = roots.abs(root)
+		if roots.known(root) {
+			return Roots{}, fmt.Errorf("%v: duplicated data root %q", rootsKey, root)
+		}
+		roots.extra = append(roots.extra, root)
+	}
+
+	for _, key := range cfg.Keys() {
+		orgName := strings.TrimPrefix(key, rootPrefix)
+		root := strings.TrimSpace(cfg.Get(key))
+		if orgName == key || orgName == "" || root == "" {
+			continue
+		}
+		root = roots.abs(root)
+		if !roots.known(root) {
+			return Roots{}, fmt.Errorf("%v: %q is not a data root", key, root)
+		}
+		roots.mapping[orgName] = root
+	}
+
+	return roots, nil
+}
+
+// All returns every root, the data directory first.
+func (r Roots) All() []string {
+	return append([]string{r.base}, r.extra...)
+}
+
+// Sharded returns true if there is more than one root.
+func (r Roots) Sharded() bool {
+	return len(r.extra) > 0
+}
+
+// Locate returns the root an organization is stored in, or the one it will
+// be created in if it doesn't exist yet.
+func (r Roots) Locate(orgName string) string {
+	if root, ok := r.mapping[orgName]; ok {
+		return root
+	}
+	for _, root := range r.All() {
+		if _, err := os.Stat(filepath.Join(root, orgsFolder, orgName)); err == nil {
+			return root
+		}
+	}
+	return r.place(orgName)
+}
+
+// OrgDir returns the directory of an organization.
+func (r Roots) OrgDir(orgName string) string {
+	return filepath.Join(r.Locate(orgName), orgsFolder, orgName)
+}
+
+// place chooses the root of a new organization hashing its name, so the
+// organizations are evenly spread and the placement is stable.
+func (r Roots) place(orgName string) string {
+	all := r.All()
+	hash := fnv.New32a()
+	hash.Write([]byte(orgName))
+	return all[hash.Sum32()%uint32(len(all))]
+}
+
+func (r Roots) abs(root string) string {
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(r.base, root)
+	}
+	return filepath.Clean(root)
+}
+
+func (r Roots) known(root string) bool {
+	for _, known := range r.All() {
+		if known == root {
+			return true
+		}
+	}
+	return false
+}
+
+// rootsCache keeps the data roots of the repositories already loaded, and
+// the directories of their organizations found so far, until the changes
+// stamp of the repository moves.
+var rootsCache = struct {
+	gosync.Mutex
+	repos map[string]*cachedRoots
+}{repos: make(map[string]*cachedRoots)}
+
+type cachedRoots struct {
+	stamp string
+	roots Roots
+	dirs  map[string]string
+}
+
+// orgDir returns the directory of an organization of the repository located
+// in dataDir.  The roots are loaded once and reloaded when the repository
+// changes, so an organization moved to another root while the server runs is
+// found in its new location.
+func orgDir(dataDir, orgName string) (string, error) {
+	rootsCache.Lock()
+	defer rootsCache.Unlock()
+
+	stamp := readStamp(dataDir)
+	cached, ok := rootsCache.repos[dataDir]
+	if !ok || cached.stamp != stamp {
+		roots, err := LoadRoots(dataDir)
+		if err != nil {
+			return "", fmt.Errorf("loading the data roots: %w", err)
+		}
+		cached = &cachedRoots{stamp: stamp, roots: roots, dirs: make(map[string]string)}
+		rootsCache.repos[dataDir] = cached
+	}
+	if dir, ok := cached.dirs[orgName]; ok {
+		return dir, nil
+	}
+
+	// only the existing organizations are kept, the lookups of invalid ones
+	// must not grow the cache
+	dir := cached.roots.OrgDir(orgName)
+	if _, err := os.Stat(dir); err == nil {
+		cached.dirs[orgName] = dir
+	}
+	return dir, nil
+}
+
+// forgetRoots drops the data roots cached for the repository located in
+// dataDir.
+func forgetRoots(dataDir string) {
+	rootsCache.Lock()
+	defer rootsCache.Unlock()
+
+	delete(rootsCache.repos, dataDir)
+}
+
+// orgsDirs returns the "orgs" directory of every root, the data directory
+// first, skipping the extra roots with no organization yet.
+func (r Roots) orgsDirs() []string {
+	dirs := []string{filepath.Join(r.base, orgsFolder)}
+	for _, root := range r.extra {
+		if _, err := os.Stat(filepath.Join(root, orgsFolder)); err == nil {
+			dirs = append(dirs, filepath.Join(root, orgsFolder))
+		}
+	}
+	return dirs
+}
+
+// orgNames lists the organizations stored in any of the roots, skipping the
+// ones being moved.
+func (r Roots) orgNames() ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, root := range r.All() {
+		entries, err := os.ReadDir(filepath.Join(root, orgsFolder))
+		if os.IsNotExist(err) && root != r.base {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || seen[entry.Name()] {
+				continue
+			}
+			if r.Locate(entry.Name()) != root {
+				log.Warnf("Ignoring stale copy of organization %q in %v", entry.Name(), root)
+				continue
+			}
+			seen[entry.Name()] = true
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// MoveOrg moves an organization to another data root, which must be listed
+// in "data.roots" or be the data directory.  The repository must be in
+// maintenance mode, so no sync changes the data while it's copied.  The
+// organization is copied and verified before mapping it to the new root,
+// and only then the old copy is removed, so it's never lost if interrupted.
+func (r *Repository) MoveOrg(orgName, root string) error {
+	if !r.InMaintenance() {
+		return fmt.Errorf("moving organization %q: the repository must be in maintenance mode", orgName)
+	}
+
+	roots, err := LoadRoots(r.baseDir)
+	if err != nil {
+		return err
+	}
+	root = roots.abs(root)
+	if !roots.known(root) {
+		return fmt.Errorf("%q is not a data root, add it to %q first", root, rootsKey)
+	}
+
+	source := roots.OrgDir(orgName)
+	if _, err := os.Stat(source); err != nil {
+		return newError(ErrOrgNotFound, "organization %q does not exists", orgName)
+	} else if roots.Locate(orgName) == root {
+		return fmt.Errorf("organization %q already in %v", orgName, root)
+	}
+
+	target := filepath.Join(root, orgsFolder, orgName)
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("%v already exists", target)
+	}
+	if err := os.MkdirAll(filepath.Join(root, orgsFolder), 0755); err != nil {
+		return fmt.Errorf("creating orgs dir: %w", err)
+	}
+
+	// copy next to the target, in the same file system, so it's renamed
+	// into place only when complete
+	temp := filepath.Join(root, rebalancePrefix+orgName)
+	if err := os.RemoveAll(temp); err != nil {
+		return fmt.Errorf("removing previous copy: %w", err)
+	}
+	if err := copyTree(source, temp); err != nil {
+		os.RemoveAll(temp)
+		return fmt.Errorf("copying organization: %w", err)
+	}
+	if err := sameTree(source, temp); err != nil {
+		os.RemoveAll(temp)
+		return fmt.Errorf("verifying copy: %w", err)
+	}
+	if err := os.Rename(temp, target); err != nil {
+		os.RemoveAll(temp)
+		return fmt.Errorf("moving copy into place: %w", err)
+	}
+
+	if err := r.setRoot(orgName, root); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(source); err != nil {
+		return fmt.Errorf("removing old copy %v: %w", source, err)
+	}
+	log.Infof("Organization %q moved from %v to %v", orgName, filepath.Dir(filepath.Dir(source)), root)
+
+	return nil
+}
+
+// setRoot maps an organization to a root in the repository configuration.
+func (r *Repository) setRoot(orgName, root string) error {
+	configPath := filepath.Join(r.baseDir, "config")
+
+	var cfg config.Config
+	var err error
+	if _, statErr := os.Stat(configPath); os.IsNotExist(statErr) {
+		cfg, err = config.New(configPath)
+	} else {
+		cfg, err = config.Load(configPath)
+	}
+	if err != nil {
+		return fmt.Errorf("loading repository config: %w", err)
+	}
+	cfg.Set(rootPrefix+orgName, root)
+	if err := config.Save(cfg); err != nil {
+		return fmt.Errorf("saving repository config: %w", err)
+	}
+	r.changed()
+
+	return nil
+}
+
+// copyTree copies the directory source to target, syncing every file.
+func copyTree(source, target string) error {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(target, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(dest, info.Mode().Perm())
+		} else if !info.Mode().IsRegular() {
+			return fmt.Errorf("%v: unexpected file type", path)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		file, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(content); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	})
+}
+
+// sameTree verifies that the target directory has the same files, with the
+// same content, than the source one.
+func sameTree(source, target string) error {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+
+		want, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		got, err := os.ReadFile(filepath.Join(target, rel))
+		if err != nil {
+			return err
+		} else if string(got) != string(want) {
+			return fmt.Errorf("%v: content differs", rel)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,176 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/config"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+func TestRoots(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+	extraRoot := tempDir(t)
+	defer os.RemoveAll(extraRoot)
+
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	cfg, err := config.Load(filepath.Join(tempRepo, "config"))
+	assert.NoError(t, err)
+	cfg.Set(rootsKey, extraRoot)
+	assert.NoError(t, config.Save(cfg))
+
+	roots, err := LoadRoots(tempRepo)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{tempRepo, extraRoot}, roots.All())
+
+	t.Run("existing organizations stay in place", func(t *testing.T) {
+		assert.Equal(t, tempRepo, roots.Locate("Public"))
+	})
+
+	t.Run("new organizations are spread across the roots", func(t *testing.T) {
+		placed := make(map[string]int)
+		for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+			placed[roots.Locate(name)]++
+			assert.Equal(t, roots.Locate(name), roots.Locate(name))
+		}
+		assert.Len(t, placed, 2)
+	})
+
+	t.Run("organizations created in the extra root", func(t *testing.T) {
+		repo, err := OpenRepository(tempRepo)
+		assert.NoError(t, err)
+
+		name := orgIn(t, roots, extraRoot)
+		_, err = repo.NewOrg(name)
+		assert.NoError(t, err)
+		user, err := repo.AddUser(name, "noeh")
+		assert.NoError(t, err)
+
+		assert.DirExists(t, filepath.Join(extraRoot, orgsFolder, name, usersFolder, user.Key))
+
+		reopened, err := OpenRepository(tempRepo)
+		assert.NoError(t, err)
+		org, err := reopened.GetOrg(name)
+		assert.NoError(t, err)
+		assert.Len(t, org.Users, 1)
+		assert.Len(t, reopened.Orgs(), len(repo.Orgs()))
+	})
+
+	t.Run("invalid mapping", func(t *testing.T) {
+		repo, err := OpenRepository(tempRepo)
+		assert.NoError(t, err)
+		dir, err := orgDir(tempRepo, "Public")
+		assert.NoError(t, err)
+
+		cfg.Set(rootPrefix+"Public", "/not/a/root")
+		assert.NoError(t, config.Save(cfg))
+		defer func() {
+			cfg.Set(rootPrefix+"Public", "")
+			assert.NoError(t, config.Save(cfg))
+		}()
+
+		_, err = LoadRoots(tempRepo)
+		assert.Error(t, err)
+
+		// the roots loaded so far are kept until reloaded
+		cached, err := orgDir(tempRepo, "Public")
+		assert.NoError(t, err)
+		assert.Equal(t, dir, cached)
+
+		repo.Reload()
+		_, err = orgDir(tempRepo, "Public")
+		assert.Error(t, err)
+		user := auth.User{Key: "53938cd8-b72e-4c2a-9fb5-3cd183cf1fa7", Org: &auth.Organization{Name: "Public"}}
+		_, err = NewDefaultReadAppender(tempRepo).Read(user)
+		assert.Error(t, err)
+		assert.Error(t, NewDefaultReadAppender(tempRepo).Append(user, []string{"lost\n"}))
+		repo.Reload()
+	})
+}
+
+func TestMoveOrg(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+	extraRoot := tempDir(t)
+	defer os.RemoveAll(extraRoot)
+
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	cfg, err := config.Load(filepath.Join(tempRepo, "config"))
+	assert.NoError(t, err)
+	cfg.Set(rootsKey, extraRoot)
+	assert.NoError(t, config.Save(cfg))
+
+	repo, err := OpenRepository(tempRepo)
+	assert.NoError(t, err)
+
+	user, err := repo.AddUser("Public", "moved")
+	assert.NoError(t, err)
+	ra := NewDefaultReadAppender(tempRepo)
+	assert.NoError(t, ra.Append(*user, []string{"tx"}))
+
+	t.Run("requires maintenance mode", func(t *testing.T) {
+		assert.Error(t, repo.MoveOrg("Public", extraRoot))
+	})
+
+	assert.NoError(t, repo.SetMaintenance(true))
+
+	t.Run("unknown root", func(t *testing.T) {
+		assert.Error(t, repo.MoveOrg("Public", tempDir(t)))
+	})
+
+	t.Run("unknown organization", func(t *testing.T) {
+		assert.Error(t, repo.MoveOrg("Missing", extraRoot))
+	})
+
+	t.Run("moves the organization", func(t *testing.T) {
+		assert.NoError(t, repo.MoveOrg("Public", extraRoot))
+
+		assert.NoDirExists(t, filepath.Join(tempRepo, orgsFolder, "Public"))
+		assert.DirExists(t, filepath.Join(extraRoot, orgsFolder, "Public"))
+		assert.NoDirExists(t, filepath.Join(extraRoot, rebalancePrefix+"Public"))
+
+		data, err := ra.Read(*user)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"tx"}, data)
+
+		org, err := repo.GetOrg("Public")
+		assert.NoError(t, err)
+		assert.Contains(t, userNames(org.Users), "moved")
+	})
+
+	t.Run("already in the root", func(t *testing.T) {
+		assert.Error(t, repo.MoveOrg("Public", extraRoot))
+	})
+
+	t.Run("moves it back", func(t *testing.T) {
+		assert.NoError(t, repo.MoveOrg("Public", tempRepo))
+		assert.DirExists(t, filepath.Join(tempRepo, orgsFolder, "Public"))
+		assert.NoDirExists(t, filepath.Join(extraRoot, orgsFolder, "Public"))
+	})
+}
+
+// orgIn returns an organization name placed in the given root.
+func orgIn(t *testing.T, roots Roots, root string) string {
+	t.Helper()
+
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		if roots.Locate(name) == root {
+			return name
+		}
+	}
+	t.Fatalf("no organization placed in %v", root)
+	return ""
+}
+
+func userNames(users []auth.User) []string {
+	var names []string
+	for _, u := range users {
+		names = append(names, u.Name)
+	}
+	return names
+}
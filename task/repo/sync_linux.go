@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// syncAll flushes the files to disk, returning the error of each one.  A
+// single file is flushed on its own, more are flushed with a syncfs(2) per
+// filesystem, falling back to flushing them one by one if unsupported.
+func syncAll(files []*os.File) []error {
+	errs := make([]error, len(files))
+	if len(files) == 1 {
+		errs[0] = fileSync(files[0])
+		return errs
+	}
+
+	filesystems := make(map[uint64][]int)
+	for i, f := range files {
+		info, err := f.Stat()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		dev := uint64(info.Sys().(*syscall.Stat_t).Dev)
+		filesystems[dev] = append(filesystems[dev], i)
+	}
+
+	for _, indexes := range filesystems {
+		err := filesystemSync(files[indexes[0]])
+		for _, i := range indexes {
+			if errors.Is(err, syscall.ENOSYS) {
+				errs[i] = fileSync(files[i])
+			} else {
+				errs[i] = err
+			}
+		}
+	}
+	return errs
+}
+
+// filesystemSync flushes the filesystem holding the file to disk, replaced
+// by the tests to count the flushes.
+var filesystemSync = func(f *os.File) error {
+	if _, _, errno := syscall.Syscall(sysSyncfs, f.Fd(), 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package repo
+
+import "os"
+
+// syncAll flushes the files to disk one by one, returning the error of each
+// one.
+func syncAll(files []*os.File) []error {
+	errs := make([]error, len(files))
+	for i, f := range files {
+		errs[i] = fileSync(f)
+	}
+	return errs
+}
@@ -0,0 +1,8 @@
+//go:build linux && !amd64 && !386
+// +build linux,!amd64,!386
+
+package repo
+
+import "syscall"
+
+const sysSyncfs = syscall.SYS_SYNCFS
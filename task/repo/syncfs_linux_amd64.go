@@ -0,0 +1,5 @@
+package repo
+
+// sysSyncfs is the syncfs(2) system call number, missing in the syscall
+// package for x86.
+const sysSyncfs = 306
@@ -0,0 +1,147 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/szaffarano/gotas/config"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+const (
+	// orgTemplatePrefix prefixes the repository configuration entries
+	// applied to every new organization, like "org.template.quota.size".
+	orgTemplatePrefix = "org.template."
+
+	// userSettingsPrefix prefixes the organization settings copied to the
+	// configuration of every new user, without the prefix.
+	userSettingsPrefix = "user."
+)
+
+// OrgTemplate are the settings applied to a new organization, like the quota
+// or the UDA declarations.  The ones prefixed with "user." are the default
+// settings of its users, copied without the prefix to the configuration of
+// every user created afterwards.
+type OrgTemplate map[string]string
+
+// LoadOrgTemplate reads an organization template file, which has the
+// configuration file format.
+func LoadOrgTemplate(path string) (OrgTemplate, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading org template: %w", err)
+	}
+
+	template := make(OrgTemplate)
+	for _, key := range cfg.Keys() {
+		template[key] = cfg.Get(key)
+	}
+	if err := template.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	return template, nil
+}
+
+// Validate verifies that the template doesn't set the entries managed by the
+// repository.
+func (t OrgTemplate) Validate() error {
+	reserved := []string{
+		deletedKey,
+		userSettingsPrefix + "user",
+		userSettingsPrefix + groupKey,
+		userSettingsPrefix + deletedKey,
+		userSettingsPrefix + roleKey,
+		userSettingsPrefix + suspendedKey,
+	}
+
+	for key := range t {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("empty template setting")
+		}
+		for _, r := range reserved {
+			if key == r {
+				return fmt.Errorf("%q is managed by gotas, it can't be set by a template", key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// NewOrgFromTemplate creates a new Organization like NewOrg does, applying
+// the template over the one in the repository configuration.
+func (r *Repository) NewOrgFromTemplate(orgName string, template OrgTemplate) (*auth.Organization, error) {
+	if err := template.Validate(); err != nil {
+		return nil, err
+	}
+
+	settings, err := r.configOrgTemplate()
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range template {
+		settings[key] = value
+	}
+
+	return r.newOrg(orgName, settings)
+}
+
+// configOrgTemplate returns the template defined in the repository
+// configuration by the entries prefixed with "org.template.".
+func (r *Repository) configOrgTemplate() (OrgTemplate, error) {
+	template := make(OrgTemplate)
+
+	configPath := filepath.Join(r.baseDir, "config")
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return template, nil
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading repository config: %w", err)
+	}
+
+	for _, key := range cfg.Keys() {
+		if name := strings.TrimPrefix(key, orgTemplatePrefix); name != key && name != "" {
+			template[name] = cfg.Get(key)
+		}
+	}
+	if err := template.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %w", configPath, err)
+	}
+
+	return template, nil
+}
+
+// saveOrgSettings stores the settings in the organization configuration.
+func (r *Repository) saveOrgSettings(orgName string, settings map[string]string) error {
+	configPath, err := r.orgConfigPath(orgName)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.New(configPath)
+	if err != nil {
+		return fmt.Errorf("creating org config: %w", err)
+	}
+	for key, value := range settings {
+		cfg.Set(key, value)
+	}
+	if err := config.Save(cfg); err != nil {
+		return fmt.Errorf("saving org config: %w", err)
+	}
+
+	return nil
+}
+
+// userDefaults returns the organization settings applied to its new users.
+func userDefaults(settings map[string]string) map[string]string {
+	defaults := make(map[string]string)
+	for key, value := range settings {
+		if name := strings.TrimPrefix(key, userSettingsPrefix); name != key && name != "" {
+			defaults[name] = value
+		}
+	}
+	return defaults
+}
@@ -0,0 +1,86 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/config"
+)
+
+func TestOrgTemplates(t *testing.T) {
+	tempRepo := tempDir(t)
+	defer os.RemoveAll(tempRepo)
+
+	copy(t, filepath.Join("testdata", "repo_one"), tempRepo)
+
+	cfg, err := config.Load(filepath.Join(tempRepo, "config"))
+	assert.NoError(t, err)
+	cfg.Set("org.template.quota.size", "1024")
+	cfg.Set("org.template.uda.estimate.type", "numeric")
+	cfg.Set("org.template.user.merge.mode", "receipt")
+	assert.NoError(t, config.Save(cfg))
+
+	templatePath := filepath.Join(tempRepo, "template")
+	assert.NoError(t, os.WriteFile(templatePath, []byte("quota.size=2048\nuser.locale=es\n"), 0644))
+
+	repo, err := OpenRepository(tempRepo)
+	assert.NoError(t, err)
+
+	t.Run("configuration template", func(t *testing.T) {
+		org, err := repo.NewOrg("Configured")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"quota.size":        "1024",
+			"uda.estimate.type": "numeric",
+			"user.merge.mode":   "receipt",
+		}, org.Settings)
+	})
+
+	t.Run("template file over the configuration", func(t *testing.T) {
+		template, err := LoadOrgTemplate(templatePath)
+		assert.NoError(t, err)
+
+		_, err = repo.NewOrgFromTemplate("Templated", template)
+		assert.NoError(t, err)
+
+		org, err := repo.GetOrg("Templated")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"quota.size":        "2048",
+			"uda.estimate.type": "numeric",
+			"user.merge.mode":   "receipt",
+			"user.locale":       "es",
+		}, org.Settings)
+	})
+
+	t.Run("user defaults", func(t *testing.T) {
+		user, err := repo.AddUser("Templated", "noeh")
+		assert.NoError(t, err)
+
+		userCfg, err := config.Load(filepath.Join(tempRepo, orgsFolder, "Templated", usersFolder, user.Key, "config"))
+		assert.NoError(t, err)
+		assert.Equal(t, "noeh", userCfg.Get("user"))
+		assert.Equal(t, "receipt", userCfg.Get("merge.mode"))
+		assert.Equal(t, "es", userCfg.Get("locale"))
+		assert.Equal(t, "", userCfg.Get("quota.size"))
+	})
+
+	t.Run("reserved settings", func(t *testing.T) {
+		for _, key := range []string{"deleted", "user.user", "user.group", "user.deleted"} {
+			_, err := repo.NewOrgFromTemplate("Reserved", OrgTemplate{key: "value"})
+			assert.Error(t, err, key)
+		}
+		assert.NoDirExists(t, filepath.Join(tempRepo, orgsFolder, "Reserved"))
+	})
+
+	t.Run("invalid template file", func(t *testing.T) {
+		_, err := LoadOrgTemplate(filepath.Join(tempRepo, "missing"))
+		assert.Error(t, err)
+
+		assert.NoError(t, os.WriteFile(templatePath, []byte("user.user=root\n"), 0644))
+		_, err = LoadOrgTemplate(templatePath)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,87 @@
+package task
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportDayLayout identifies the days of the reports.
+const reportDayLayout = "2006-01-02"
+
+// BurndownDay is the activity of a user in a day, "YYYY-MM-DD" in UTC.
+type BurndownDay struct {
+	Day string `json:"day"`
+	// Created and Completed are the tasks created and completed that day, by
+	// their entry and end dates.
+	Created   int `json:"created"`
+	Completed int `json:"completed"`
+	// Pending is the number of tasks still open at the end of the day.
+	Pending int `json:"pending"`
+}
+
+// Burndown computes the tasks created, completed and still pending every day
+// between since and until, both included, from the user transactions.  The
+// recurring task templates aren't counted, their instances are.
+func Burndown(data []string, since, until time.Time) ([]BurndownDay, error) {
+	tasks, err := LatestTasks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	first := since.UTC().Truncate(24 * time.Hour)
+	last := until.UTC().Truncate(24 * time.Hour)
+	if last.Before(first) {
+		return nil, fmt.Errorf("invalid period, %s is after %s", first.Format(reportDayLayout), last.Format(reportDayLayout))
+	}
+
+	days := make([]BurndownDay, 0, int(last.Sub(first)/(24*time.Hour))+1)
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		end := day.AddDate(0, 0, 1)
+		current := BurndownDay{Day: day.Format(reportDayLayout)}
+
+		for _, t := range tasks {
+			status := t.Get("status")
+			entry, finished := t.GetDate("entry"), t.GetDate("end")
+			if status == "recurring" || entry.IsZero() || !entry.Before(end) {
+				continue
+			}
+
+			if !entry.Before(day) {
+				current.Created++
+			}
+			closed := (status == "completed" || status == "deleted") && !finished.IsZero()
+			if closed && status == "completed" && !finished.Before(day) && finished.Before(end) {
+				current.Completed++
+			}
+			if !closed || !finished.Before(end) {
+				current.Pending++
+			}
+		}
+
+		days = append(days, current)
+	}
+
+	return days, nil
+}
+
+// ParseReportPeriod parses the length of a report period, either a number of
+// days ("30d"), weeks ("4w") or a Go duration ("36h").
+func ParseReportPeriod(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if number := strings.TrimSuffix(value, suffix); number != value {
+			n, err := strconv.Atoi(number)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid period %q", value)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	period, err := time.ParseDuration(value)
+	if err != nil || period <= 0 {
+		return 0, fmt.Errorf("invalid period %q, e.g. 30d, 4w or 36h expected", value)
+	}
+	return period, nil
+}
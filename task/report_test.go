@@ -0,0 +1,58 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBurndown(t *testing.T) {
+	data := []string{
+		`{"description":"old","entry":"20250101T100000Z","status":"pending","uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`,
+		`{"description":"done","entry":"20250110T100000Z","status":"pending","uuid":"2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b"}`,
+		"94978aad-fbaf-4876-92e0-33321f1cbab9",
+		`{"description":"done","end":"20250111T120000Z","entry":"20250110T100000Z","status":"completed","uuid":"2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b"}`,
+		`{"description":"gone","end":"20250112T080000Z","entry":"20250111T100000Z","status":"deleted","uuid":"0c1a7a3e-1b0b-4a4e-9d4d-2f0a6a5a8c01"}`,
+		`{"description":"template","entry":"20250110T100000Z","recur":"daily","status":"recurring","uuid":"5c7a9e1b-2d4f-4a6b-8c0e-1f2a3b4c5d6e"}`,
+		"6c1b7f2e-0f5e-4b8e-a1d9-3f6a2b1c0d9e",
+	}
+
+	since := time.Date(2025, 1, 10, 23, 0, 0, 0, time.UTC)
+	until := time.Date(2025, 1, 12, 1, 0, 0, 0, time.UTC)
+	days, err := Burndown(data, since, until)
+	assert.NoError(t, err)
+	assert.Equal(t, []BurndownDay{
+		{Day: "2025-01-10", Created: 1, Completed: 0, Pending: 2},
+		{Day: "2025-01-11", Created: 1, Completed: 1, Pending: 2},
+		{Day: "2025-01-12", Created: 0, Completed: 0, Pending: 1},
+	}, days)
+
+	_, err = Burndown(data, until, since)
+	assert.Error(t, err)
+}
+
+func TestParseReportPeriod(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"30d", 30 * 24 * time.Hour},
+		{"4w", 28 * 24 * time.Hour},
+		{"36h", 36 * time.Hour},
+	}
+	for _, c := range cases {
+		t.Run(c.value, func(t *testing.T) {
+			period, err := ParseReportPeriod(c.value)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, period)
+		})
+	}
+
+	for _, value := range []string{"", "d", "-1d", "0w", "month", "-2h"} {
+		t.Run("invalid "+value, func(t *testing.T) {
+			_, err := ParseReportPeriod(value)
+			assert.Error(t, err)
+		})
+	}
+}
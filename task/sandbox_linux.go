@@ -0,0 +1,187 @@
+package task
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock system calls and constants, see landlock(7).  The system call
+// numbers are the same in every architecture.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockRulePathBeneath = 1
+
+	// ABI v1 filesystem access rights
+	landlockAccessFSExecute  = 1 << 0
+	landlockAccessFSReadFile = 1 << 2
+	landlockAccessFSReadDir  = 1 << 3
+	landlockAccessFSAll      = 1<<13 - 1
+
+	prSetNoNewPrivs = 38
+)
+
+type landlockRulesetAttr struct {
+	handledAccessFS uint64
+}
+
+// landlockPathBeneathAttr is packed in C, the kernel only reads the first 12
+// bytes so the trailing padding is harmless.
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+// dropPrivileges switches the process to the given user and group, which
+// can be names or numeric ids.  The group defaults to the user primary one.
+func dropPrivileges(userName, groupName string) error {
+	if userName == "" && groupName == "" {
+		return nil
+	}
+
+	uid, gid := -1, -1
+	if userName != "" {
+		u, err := lookupUser(userName)
+		if err != nil {
+			return SettingsError{RunUser, err}
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+	if groupName != "" {
+		g, err := lookupGroup(groupName)
+		if err != nil {
+			return SettingsError{RunGroup, err}
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	if os.Geteuid() != 0 {
+		if (uid == -1 || uid == os.Geteuid()) && (gid == -1 || gid == os.Getegid()) {
+			return nil
+		}
+		return fmt.Errorf("dropping privileges: the server has to be started as root")
+	}
+
+	// the groups first, the user can't change them afterwards
+	if err := syscall.Setgroups([]int{}); err != nil {
+		return fmt.Errorf("dropping supplementary groups: %v", err)
+	}
+	if gid != -1 {
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("switching to group %d: %v", gid, err)
+		}
+	}
+	if uid != -1 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("switching to user %d: %v", uid, err)
+		}
+	}
+
+	log.Infof("Running as uid %d, gid %d", os.Getuid(), os.Getgid())
+
+	return nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupGroupId(name)
+	}
+	return user.LookupGroup(name)
+}
+
+// systemFiles are read by the name resolution, which the outgoing
+// connections (notifications, digests, replication) keep using in the
+// sandbox.
+var systemFiles = []string{"/etc/hosts", "/etc/resolv.conf", "/etc/nsswitch.conf"}
+
+// sandbox restricts the filesystem access of the whole process using
+// landlock: the writable directories can be fully accessed, and the files in
+// the readable ones can only be read, and the ones in the executable ones read
+// and executed.  The system files needed to resolve names can be read too, and
+// the system CAs are loaded beforehand.  Everything else is denied, so it must
+// be called once every file outside those directories was loaded.
+func sandbox(writable, readable, executable []string) error {
+	// the pool is loaded once and kept for the TLS clients
+	if _, err := x509.SystemCertPool(); err != nil {
+		log.Warnf("Loading the system CAs before sandboxing: %v", err)
+	}
+
+	attr := landlockRulesetAttr{handledAccessFS: landlockAccessFSAll}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("creating landlock ruleset, is landlock enabled? %v", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	for path, access := range sandboxRules(writable, readable, executable, systemFiles) {
+		if err := addLandlockRule(int(fd), path, access); err != nil {
+			return err
+		}
+	}
+
+	// the restriction has to be applied to every thread of the process
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("setting no new privileges: %v", errno)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("enforcing landlock ruleset: %v", errno)
+	}
+
+	log.Infof("Filesystem access restricted to %v (read-write), %v (read-only), %v (executable) and the system files", writable, readable, executable)
+
+	return nil
+}
+
+// sandboxRules returns the landlock access of every path.  The files only
+// get the file access rights, the missing ones are skipped.
+func sandboxRules(writable, readable, executable, files []string) map[string]uint64 {
+	rules := make(map[string]uint64)
+	for _, dir := range readable {
+		rules[dir] |= landlockAccessFSReadFile | landlockAccessFSReadDir
+	}
+	for _, dir := range executable {
+		rules[dir] |= landlockAccessFSExecute | landlockAccessFSReadFile | landlockAccessFSReadDir
+	}
+	for _, dir := range writable {
+		rules[dir] |= landlockAccessFSAll
+	}
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			rules[file] |= landlockAccessFSReadFile
+		}
+	}
+	return rules
+}
+
+// addLandlockRule allows the access to a directory hierarchy or a file,
+// following the symbolic links, e.g. /etc/resolv.conf.
+func addLandlockRule(rulesetFd int, path string, access uint64) error {
+	pathFd, err := syscall.Open(filepath.Clean(path), syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("opening %v: %v", path, err)
+	}
+	defer syscall.Close(pathFd)
+
+	rule := landlockPathBeneathAttr{allowedAccess: access, parentFd: int32(pathFd)}
+	_, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(rulesetFd), landlockRulePathBeneath, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("adding landlock rule for %v: %v", path, errno)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package task
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandboxRules(t *testing.T) {
+	dir := t.TempDir()
+	hosts := filepath.Join(dir, "hosts")
+	assert.NoError(t, os.WriteFile(hosts, []byte("127.0.0.1 localhost\n"), 0644))
+
+	rules := sandboxRules([]string{"/data"}, []string{"/certs"}, []string{"/extensions"}, []string{hosts, filepath.Join(dir, "missing")})
+
+	assert.Equal(t, map[string]uint64{
+		"/data":       landlockAccessFSAll,
+		"/certs":      landlockAccessFSReadFile | landlockAccessFSReadDir,
+		"/extensions": landlockAccessFSExecute | landlockAccessFSReadFile | landlockAccessFSReadDir,
+		// the files can't get the directory access rights
+		hosts: landlockAccessFSReadFile,
+	}, rules)
+}
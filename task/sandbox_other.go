@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package task
+
+import "fmt"
+
+// dropPrivileges is only supported on Linux.
+func dropPrivileges(userName, groupName string) error {
+	if userName == "" && groupName == "" {
+		return nil
+	}
+	err := fmt.Errorf("dropping privileges is only supported on Linux")
+	if userName == "" {
+		return SettingsError{RunGroup, err}
+	}
+	return SettingsError{RunUser, err}
+}
+
+// sandbox is only supported on Linux.
+func sandbox(writable, readable, executable []string) error {
+	return fmt.Errorf("sandboxing is only supported on Linux")
+}
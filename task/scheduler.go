@@ -0,0 +1,493 @@
+package task
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// Jobs run by the server scheduler, configured with "schedule.<job>".
+const (
+	// JobGC removes the temporary files left by a crash, see
+	// repo.CollectGarbage.
+	JobGC = "gc"
+	// JobRetention drops the expired tasks, see repo.EnforceRetention.
+	JobRetention = "retention"
+	// JobDigest emails the tasks due today or overdue to the users that opted
+	// in, see SendDigests.
+	JobDigest = "digest"
+)
+
+// Jobs are the jobs that can be scheduled.
+var Jobs = []string{JobGC, JobRetention, JobDigest}
+
+func knownJob(name string) bool {
+	for _, job := range Jobs {
+		if job == name {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// jobsFolder is the data directory folder holding the jobs locks and
+	// last runs, shared by the servers using the same data directory.
+	jobsFolder = "jobs"
+
+	// jobLockTTL is the age after which the lock of a job is considered left
+	// by a crashed server.  The locks of running jobs are refreshed before.
+	jobLockTTL = 10 * time.Minute
+
+	// scheduleHorizon is how far a cron schedule is searched for its next
+	// run, the ones never matching, like "0 0 30 2 *", never run.
+	scheduleHorizon = 5 * 366 * 24 * time.Hour
+)
+
+// Schedule decides when a job runs.
+type Schedule interface {
+	// Next returns the first run after t, or the zero time if there is
+	// none.
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule parses a cron-like schedule, either the standard five fields
+// "minute hour day-of-month month day-of-week", supporting "*", lists,
+// ranges and steps, or one of "@hourly", "@daily", "@weekly", "@monthly" and
+// "@every <duration>".  The "@every" schedules are aligned to the Unix epoch,
+// so every server sharing the data directory runs them at the same time.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || interval < time.Second {
+			return nil, fmt.Errorf("invalid schedule %q: interval of at least a second expected", spec)
+		}
+		return every(interval), nil
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: five fields expected", spec)
+	}
+
+	var cron cronSchedule
+	for i, field := range []struct {
+		set      *uint64
+		min, max int
+	}{
+		{&cron.minute, 0, 59},
+		{&cron.hour, 0, 23},
+		{&cron.dom, 1, 31},
+		{&cron.month, 1, 12},
+		{&cron.dow, 0, 7},
+	} {
+		set, err := parseCronField(fields[i], field.min, field.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", spec, err)
+		}
+		*field.set = set
+	}
+	// Sunday is both 0 and 7
+	if cron.dow&(1<<7) != 0 {
+		cron.dow |= 1
+	}
+	cron.anyDom, cron.anyDow = fields[2] == "*", fields[4] == "*"
+
+	return cron, nil
+}
+
+// every runs a job every interval.
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	interval := time.Duration(e)
+	return t.Truncate(interval).Add(interval)
+}
+
+// cronSchedule is a parsed cron schedule, every field is the set of allowed
+// values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	anyDom, anyDow                bool
+}
+
+func (c cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(scheduleHorizon)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// matchDay follows cron: if both the day of the month and the day of the
+// week are restricted, matching either is enough.
+func (c cronSchedule) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// parseCronField parses a comma separated list of "*", values and ranges,
+// optionally with a step, like "*/15" or "1-5,10".
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid range %q", part)
+				}
+			} else if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+
+		for v := from; v <= to; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// JobStats are the statistics of a scheduled job.
+type JobStats struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	Runs     uint64 `json:"runs"`
+	Failures uint64 `json:"failures"`
+	// Skipped counts the runs done by another server sharing the data
+	// directory.
+	Skipped      uint64        `json:"skipped"`
+	LastRun      time.Time     `json:"last_run,omitempty"`
+	LastDuration time.Duration `json:"last_duration"`
+	LastError    string        `json:"last_error,omitempty"`
+	NextRun      time.Time     `json:"next_run,omitempty"`
+}
+
+type scheduledJob struct {
+	name     string
+	spec     string
+	schedule Schedule
+	run      func() error
+	stats    JobStats
+}
+
+// Scheduler runs the background jobs, like the garbage collection, following
+// their schedules.  A random delay up to the jitter is added to every run to
+// spread the load.  The servers sharing the data directory take turns: every
+// scheduled run is done by the first one locking the job, the others skip it.
+type Scheduler struct {
+	dir    string
+	jitter time.Duration
+
+	mu     gosync.Mutex
+	jobs   []*scheduledJob
+	random *rand.Rand
+
+	quit chan struct{}
+	wg   gosync.WaitGroup
+}
+
+// NewScheduler creates a scheduler locking the jobs in the data directory
+// root.
+func NewScheduler(root string, jitter time.Duration) *Scheduler {
+	return &Scheduler{
+		dir:    filepath.Join(root, jobsFolder),
+		jitter: jitter,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+		quit:   make(chan struct{}),
+	}
+}
+
+// Register adds a job, run following the schedule spec once the scheduler is
+// started.
+func (s *Scheduler) Register(name, spec string, run func() error) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("scheduling %s: %v", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs = append(s.jobs, &scheduledJob{
+		name:     name,
+		spec:     spec,
+		schedule: schedule,
+		run:      run,
+		stats:    JobStats{Name: name, Schedule: spec},
+	})
+
+	return nil
+}
+
+// Start runs the registered jobs in background until closed.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(job)
+	}
+}
+
+// Close stops the scheduler, waiting for the running jobs.
+func (s *Scheduler) Close() {
+	close(s.quit)
+	s.wg.Wait()
+}
+
+// Stats returns the statistics of every job.
+func (s *Scheduler) Stats() []JobStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make([]JobStats, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		stats = append(stats, job.stats)
+	}
+	return stats
+}
+
+func (s *Scheduler) loop(job *scheduledJob) {
+	defer s.wg.Done()
+
+	for {
+		slot := job.schedule.Next(time.Now())
+		if slot.IsZero() {
+			log.Warnf("Job %s never runs, schedule %q", job.name, job.spec)
+			return
+		}
+
+		s.mu.Lock()
+		job.stats.NextRun = slot
+		delay := time.Until(slot)
+		if s.jitter > 0 {
+			delay += time.Duration(s.random.Int63n(int64(s.jitter)))
+		}
+		s.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-s.quit:
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.runSlot(job, slot)
+		}
+	}
+}
+
+// runSlot runs the job scheduled at slot, unless another server already did.
+func (s *Scheduler) runSlot(job *scheduledJob, slot time.Time) {
+	unlock, ok, err := s.lock(job.name)
+	if err != nil {
+		log.Errorf("Error locking job %s: %v", job.name, err)
+		return
+	} else if !ok {
+		s.skip(job)
+		return
+	}
+	defer unlock()
+
+	if last, err := s.lastRun(job.name); err != nil {
+		log.Errorf("Error reading the last run of job %s: %v", job.name, err)
+	} else if !last.Before(slot) {
+		s.skip(job)
+		return
+	}
+
+	start := time.Now()
+	err = job.run()
+	duration := time.Since(start)
+
+	if err := s.setLastRun(job.name, slot); err != nil {
+		log.Errorf("Error saving the last run of job %s: %v", job.name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.stats.Runs++
+	job.stats.LastRun = start
+	job.stats.LastDuration = duration
+	job.stats.LastError = ""
+	if err != nil {
+		job.stats.Failures++
+		job.stats.LastError = err.Error()
+		log.Errorf("Job %s failed after %v: %v", job.name, duration, err)
+		return
+	}
+	log.Infof("Job %s done in %v", job.name, duration)
+}
+
+func (s *Scheduler) skip(job *scheduledJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.stats.Skipped++
+	log.Debugf("Job %s skipped, run by another server", job.name)
+}
+
+// lock takes the lock of a job, shared by the servers using the same data
+// directory.  The lock is refreshed while held, so it's only taken over if
+// left by a crashed server.
+func (s *Scheduler) lock(name string) (func(), bool, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, false, err
+	}
+	path := filepath.Join(s.dir, name+".lock")
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		info, statErr := os.Stat(path)
+		if statErr != nil || time.Since(info.ModTime()) < jobLockTTL {
+			return nil, false, nil
+		}
+		log.Warnf("Taking over the stale lock of job %s", name)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, false, err
+		}
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			return nil, false, nil
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	host, _ := os.Hostname()
+	fmt.Fprintf(file, "%s %d\n", host, os.Getpid())
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return nil, false, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobLockTTL / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if err := os.Chtimes(path, now, now); err != nil {
+					log.Errorf("Error refreshing the lock of job %s: %v", name, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		if err := os.Remove(path); err != nil {
+			log.Errorf("Error unlocking job %s: %v", name, err)
+		}
+	}, true, nil
+}
+
+func (s *Scheduler) lastRun(name string) (time.Time, error) {
+	content, err := os.ReadFile(filepath.Join(s.dir, name+".last"))
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(content)))
+}
+
+func (s *Scheduler) setLastRun(name string, slot time.Time) error {
+	path := filepath.Join(s.dir, name+".last")
+	temp := path + ".new"
+	if err := os.WriteFile(temp, []byte(slot.UTC().Format(time.RFC3339)+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// appendGate pauses the appends while a job rewrites the transactions.
+type appendGate struct {
+	ReadAppender
+	mu gosync.RWMutex
+}
+
+func (g *appendGate) Append(user auth.User, data []string) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.ReadAppender.Append(user, data)
+}
+
+// exclusive runs f while no transaction is appended.
+func (g *appendGate) exclusive(f func() error) func() error {
+	return func() error {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		return f()
+	}
+}
@@ -0,0 +1,139 @@
+package task
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2021, time.March, 10, 10, 17, 30, 0, time.UTC) // Wednesday
+
+	cases := []struct {
+		name string
+		spec string
+		next time.Time
+	}{
+		{"every minute", "* * * * *", time.Date(2021, time.March, 10, 10, 18, 0, 0, time.UTC)},
+		{"steps", "*/15 * * * *", time.Date(2021, time.March, 10, 10, 30, 0, 0, time.UTC)},
+		{"list", "5,20 * * * *", time.Date(2021, time.March, 10, 10, 20, 0, 0, time.UTC)},
+		{"next hour", "5 * * * *", time.Date(2021, time.March, 10, 11, 5, 0, 0, time.UTC)},
+		{"hourly", "@hourly", time.Date(2021, time.March, 10, 11, 0, 0, 0, time.UTC)},
+		{"daily", "@daily", time.Date(2021, time.March, 11, 0, 0, 0, 0, time.UTC)},
+		{"weekly", "@weekly", time.Date(2021, time.March, 14, 0, 0, 0, 0, time.UTC)},
+		{"sunday as 7", "0 3 * * 7", time.Date(2021, time.March, 14, 3, 0, 0, 0, time.UTC)},
+		{"monthly", "@monthly", time.Date(2021, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"range", "30 2 * * 1-5", time.Date(2021, time.March, 11, 2, 30, 0, 0, time.UTC)},
+		{"day of month or week", "0 0 1 * 5", time.Date(2021, time.March, 12, 0, 0, 0, 0, time.UTC)},
+		{"next year", "0 0 1 1 *", time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"never", "0 0 30 2 *", time.Time{}},
+		{"every", "@every 1h", time.Date(2021, time.March, 10, 11, 0, 0, 0, time.UTC)},
+		{"every minutes", "@every 10m", time.Date(2021, time.March, 10, 10, 20, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(c.spec)
+			assert.NoError(t, err)
+			assert.Equal(t, c.next, schedule.Next(base))
+		})
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@every", "@every 1ms", "@yearly"} {
+		t.Run("invalid "+spec, func(t *testing.T) {
+			_, err := ParseSchedule(spec)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSchedulerRunSlot(t *testing.T) {
+	root := t.TempDir()
+	slot := time.Date(2021, time.March, 10, 11, 0, 0, 0, time.UTC)
+
+	runs := 0
+	fail := false
+	job := &scheduledJob{name: JobGC, run: func() error {
+		runs++
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	}}
+
+	first := NewScheduler(root, 0)
+	second := NewScheduler(root, 0)
+
+	t.Run("runs once per slot", func(t *testing.T) {
+		first.runSlot(job, slot)
+		second.runSlot(job, slot)
+
+		assert.Equal(t, 1, runs)
+		assert.Equal(t, uint64(2), job.stats.Runs+job.stats.Skipped)
+		assert.Equal(t, uint64(1), job.stats.Skipped)
+		assert.NoFileExists(t, filepath.Join(root, jobsFolder, JobGC+".lock"))
+	})
+
+	t.Run("skipped while locked", func(t *testing.T) {
+		unlock, ok, err := first.lock(JobGC)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		second.runSlot(job, slot.Add(time.Hour))
+		assert.Equal(t, 1, runs)
+		unlock()
+
+		second.runSlot(job, slot.Add(time.Hour))
+		assert.Equal(t, 2, runs)
+	})
+
+	t.Run("stale lock taken over", func(t *testing.T) {
+		path := filepath.Join(root, jobsFolder, JobGC+".lock")
+		assert.NoError(t, os.WriteFile(path, []byte("crashed 1\n"), 0600))
+		old := time.Now().Add(-2 * jobLockTTL)
+		assert.NoError(t, os.Chtimes(path, old, old))
+
+		first.runSlot(job, slot.Add(2*time.Hour))
+		assert.Equal(t, 3, runs)
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		fail = true
+		first.runSlot(job, slot.Add(3*time.Hour))
+
+		assert.Equal(t, uint64(1), job.stats.Failures)
+		assert.Equal(t, "failed", job.stats.LastError)
+	})
+}
+
+func TestSchedulerStart(t *testing.T) {
+	scheduler := NewScheduler(t.TempDir(), 0)
+
+	done := make(chan struct{}, 1)
+	assert.NoError(t, scheduler.Register(JobGC, "@every 1s", func() error {
+		select {
+		case done <- struct{}{}:
+		default:
+		}
+		return nil
+	}))
+	assert.Error(t, scheduler.Register(JobRetention, "invalid", nil))
+
+	scheduler.Start()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("job not run")
+	}
+	scheduler.Close()
+
+	stats := scheduler.Stats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, JobGC, stats[0].Name)
+	assert.Equal(t, "@every 1s", stats[0].Schedule)
+	assert.False(t, stats[0].NextRun.IsZero())
+}
@@ -2,21 +2,55 @@ package task
 
 import (
 	"bufio"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"runtime"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	gosync "sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/repo"
+	"github.com/szaffarano/gotas/task/transport"
 )
 
 const (
-	// RequestLimitInBytes is the maximum size allowed for an incoming message
-	// TODO read this value from the configuration
+	// RequestLimitInBytes is the default maximum size allowed for an incoming
+	// message, used when the "request.limit" setting is not configured.
 	RequestLimitInBytes = 1048576
+
+	// ProtocolVersion is the taskd protocol version advertised in responses
+	// not answering a request, the others use the version of the request.
+	ProtocolVersion = "v1"
+
+	// DefaultIdentity is the server identity advertised in responses when
+	// "server.identity" is not configured.
+	DefaultIdentity = "gotas"
+
+	// RetryAfterHeader tells the client how many seconds to wait before
+	// retrying a request rejected because the server is in maintenance mode.
+	RetryAfterHeader = "retry-after"
+
+	// MaintenanceRetryAfter is the number of seconds suggested to the clients
+	// while the server is in maintenance mode.
+	MaintenanceRetryAfter = 300
+
+	// BusyRetryAfter is the number of seconds suggested to the clients
+	// rejected because the connections queue is full.
+	BusyRetryAfter = 5
+
+	// ResetSyncKey is the zero UUID, which the clients send as the sync key
+	// to get all the data, like a first-time sync does.
+	ResetSyncKey = "00000000-0000-0000-0000-000000000000"
+
+	// DryRunHeader turns a sync request into a dry run when set to "1": the
+	// merge is computed but nothing is stored.
+	DryRunHeader = "dryrun"
 )
 
 // Reader reads user transactions
@@ -35,96 +69,544 @@ type ReadAppender interface {
 	Appender
 }
 
-// Process processes a taskd client request
-func Process(client io.ReadWriteCloser, auth auth.Authenticator, ra ReadAppender) {
+// Unarchiver puts back in the transactions read the archived tasks a client
+// syncing from the sync key needs, empty for the full resyncs.  See
+// repo.DefaultReadAppender.Unarchive.
+type Unarchiver interface {
+	Unarchive(user auth.User, data []string, key string) ([]string, error)
+}
+
+// Options exposes the settings used to process client requests.
+type Options struct {
+	// SyncWorkers is the maximum number of tasks merged concurrently during a
+	// sync.
+	SyncWorkers int
+
+	// RequestLimit is the maximum size in bytes allowed for an incoming
+	// message.
+	RequestLimit int
+
+	// Identity is the server name and version sent in the "server" header.
+	Identity string
+
+	// Storage names the storage backend reported to the statistics
+	// requests, see ServerInfo.
+	Storage string
+
+	// Message is an optional message of the day sent in the "message" header,
+	// clients show it after a sync.
+	Message string
+
+	// MaintenanceMessage is an optional notice set by the administrator, sent
+	// to the clients in the "info" header.
+	MaintenanceMessage string
+
+	// RequestTimeout is how long a client can take to send a request, and
+	// MinRate the minimum bytes per second it has to send, so the slow
+	// clients can't hold the workers.  Zero disables them.
+	RequestTimeout time.Duration
+	MinRate        int
+
+	// IdleTimeout is how long a new connection can wait before sending its
+	// first request, zero means forever.
+	IdleTimeout time.Duration
+
+	// KeepAlive is how long a connection kept alive waits for the next
+	// request, zero disables keeping them alive.
+	KeepAlive time.Duration
+
+	// CondensedInit answers the full resyncs (first-time syncs and
+	// ResetSyncKey) with the latest state of every task instead of replaying
+	// the whole history, like taskd does.
+	CondensedInit bool
+
+	// Verbose adds the taskd diagnostic "info" header to the sync responses,
+	// with the tasks stored and merged.  The lines of the request that
+	// couldn't be parsed are always explained in "error".
+	Verbose bool
+
+	// Maintenance reports whether the server is in maintenance mode, if so,
+	// sync requests are rejected with a 420 code.  It's checked on every
+	// request so the mode can be toggled without restarting the server.
+	Maintenance func() bool
+
+	// Archives, if set, puts back the archived tasks the syncs from old sync
+	// keys need.
+	Archives Unarchiver
+
+	// ReadOnly rejects the syncs uploading changes with a 420 code, the ones
+	// only downloading them still succeed.  The organizations can be made
+	// read-only on their own, see auth.Organization.
+	ReadOnly bool
+
+	// OnSync is called after a sync stores or merges tasks.
+	OnSync func(SyncEvent)
+
+	// DriftPolicy are the thresholds used to detect suspicious syncs.
+	DriftPolicy DriftPolicy
+
+	// Merge is the strategy used to merge concurrent modifications.
+	Merge MergeStrategy
+
+	// OrgMerge overrides Merge for some organizations.
+	OrgMerge map[string]MergeStrategy
+
+	// LenientDates accepts the client dates not following DateLayout, see
+	// ParseLenientDate.
+	LenientDates bool
+
+	// MergeShadow is a strategy also run on every merge, logging when its
+	// result differs, to evaluate it before rolling it out.
+	MergeShadow MergeStrategy
+
+	// Anomalies counts the suspicious syncs.  If nil, they're only logged.
+	Anomalies *AnomalyDetector
+
+	// Quota is the maximum size in bytes of the transactions stored for a
+	// user, syncs exceeding it are rejected with a 430 code.  Zero means no
+	// quota.
+	Quota int
+
+	// LimitWarn is the percentage of the quota and the request limit above
+	// which the users are warned in the "message" header.  Zero disables the
+	// warnings.
+	LimitWarn int
+
+	// DryRunUsers are the users allowed to send dry-run syncs, as
+	// "org/user".  Dry runs are rejected for everybody else.
+	DryRunUsers []string
+
+	// Keys generates the sync keys, random UUIDs if nil.
+	Keys KeyGenerator
+
+	// Stats collects the requests statistics, if set.
+	Stats *Stats
+
+	// Memory bounds the memory held by the requests in flight, rejected with
+	// a 420 code when over budget, if set.
+	Memory *MemoryBudget
+
+	// RecordSync is called after every successful sync, with the metadata
+	// admins use to find stale or abusive accounts.
+	RecordSync func(auth.User, repo.LastSync)
+
+	// RecordMerges is called after a sync merging tasks is stored, with the
+	// merges audit trail.
+	RecordMerges func(auth.User, []repo.MergeRecord)
+
+	// Extensions are the executables run before authenticating the requests
+	// and after the syncs, see ServerExtensions.
+	Extensions ServerExtensions
+}
+
+// newKey returns a new sync key.
+func (opts Options) newKey() string {
+	if opts.Keys == nil {
+		return randomKeys{}.NewKey()
+	}
+	return opts.Keys.NewKey()
+}
+
+// DefaultOptions returns the options used when nothing is configured.
+func DefaultOptions() Options {
+	return Options{
+		SyncWorkers:    runtime.NumCPU(),
+		RequestLimit:   RequestLimitInBytes,
+		Identity:       DefaultIdentity,
+		Merge:          MergeByTimestamp,
+		LimitWarn:      DefaultLimitWarn,
+		CondensedInit:  true,
+		RequestTimeout: DefaultRequestTimeout,
+		MinRate:        DefaultMinRate,
+		IdleTimeout:    DefaultIdleTimeout,
+		DriftPolicy: DriftPolicy{
+			MaxHistory: DefaultDriftHistorySize,
+			MaxFuture:  DefaultDriftFuture,
+		},
+	}
+}
+
+// Process processes the requests of a taskd client connection, only one
+// unless the client asks to keep the connection alive, see KeepAliveHeader.
+// A panic while processing a request is recovered, so it only affects the
+// current connection.
+func Process(client io.ReadWriteCloser, auth auth.Authenticator, ra ReadAppender, opts Options) {
 	defer client.Close()
 
+	var conn connection
+	for processRequest(client, auth, ra, opts, &conn) {
+		if !conn.waitNext(client, opts.KeepAlive) {
+			return
+		}
+	}
+}
+
+// processRequest processes the next request of the connection, returning
+// true if the connection is kept alive for another one.
+func processRequest(client io.ReadWriteCloser, auth auth.Authenticator, ra ReadAppender, opts Options, conn *connection) (keepAlive bool) {
+	// every request is replied once, keeping the code for the statistics
+	start, replied := time.Now(), ""
+	defer func() {
+		if replied != "" {
+			opts.Stats.record(replied, time.Since(start))
+		}
+	}()
+	// the statuses are translated once the request is read
+	var locale string
+	reply := func(resp Message) error {
+		replied = resp.Header["code"]
+		localize(&resp, locale)
+		return respond(client, resp, opts)
+	}
+
+	requestID := uuid.New().String()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Recovered from panic processing request %s: %v\n%s", requestID, r, debug.Stack())
+			keepAlive = false
+			resp := catalogResponse("500", MsgInternalErrorRequest, requestID)
+			if err := reply(resp); err != nil {
+				log.Errorf("Error replying error message to the client: %v", err)
+			}
+		}
+	}()
+
 	var msg, resp Message
 	var err error
 
-	if msg, err = receiveMessage(client); err != nil {
-		log.Errorf("Error parsing message: %v", err)
-		// TODO receive error code in the error
-		if err = replyMessage(client, NewResponseMessage("500", err.Error())); err != nil {
+	peer := peerOf(client)
+	receiver := newReceiver(client, opts)
+	if conn.requests == 0 {
+		// the connections kept alive have their own idle timeout
+		receiver.idle = opts.IdleTimeout
+	}
+	msg, err = ReadMessage(receiver, opts.RequestLimit)
+	receiver.done()
+	if errors.Is(err, errSlowClient) {
+		log.Warnf("Closing the connection with %v: %v", peer, err)
+		return false
+	} else if err != nil && conn.requests > 0 {
+		// the client closed the connection kept alive, or went idle
+		log.Debugf("Closing the connection kept alive with %v: %v", peer, err)
+		return false
+	} else if err != nil {
+		log.Errorf("Error parsing message from %v: %v", peer, err)
+		resp = catalogResponse("500", MsgSyntaxError)
+		if errors.Is(err, errMessageTooBig) {
+			resp = catalogResponse("500", MsgMessageTooBig)
+		} else if errors.Is(err, errNoSeparator) {
+			resp = catalogResponse("500", MsgNoSeparator)
+		}
+		if err = reply(resp); err != nil {
 			log.Errorf("Error replying error message to the client: %v", err)
 		}
 		return
 	}
+	requestSize := msg.size() + 4
+	locale = msg.Header[LocaleHeader]
+	if conn.requests > 0 {
+		// not counting the time the connection was idle
+		start = time.Now()
+	}
+	conn.requests++
 
-	loggedUser, err := isValid(msg, auth)
+	if msg.Header["type"] == PingType {
+		if err = reply(pingResponse(opts)); err != nil {
+			log.Errorf("Error replying the ping to the client: %v", err)
+		}
+		return false
+	}
+	if peer.Anonymous {
+		log.Warnf("Rejecting %q of %q from %v: no client certificate", msg.Header["user"], msg.Header["org"], peer)
+		if err = reply(catalogResponse("430", MsgCertificateRequired)); err != nil {
+			log.Errorf("Error replying error message to the client: %v", err)
+		}
+		return false
+	}
+
+	account := memoryAccount{budget: opts.Memory}
+	defer func() {
+		if account.reserved > 0 {
+			log.Debugf("Request from %q of %q held ~%d bytes", msg.Header["user"], msg.Header["org"], account.reserved)
+		}
+		account.release()
+	}()
+	if !account.reserve(requestSize) {
+		log.Warnf("Rejecting request from %v: %v", peer, errMemoryBudget)
+		if err = reply(memoryBudgetResponse()); err != nil {
+			log.Errorf("Error replying error message to the client: %v", err)
+		}
+		return
+	}
+	if opts.Memory != nil {
+		ra = accountedReadAppender{ReadAppender: ra, account: &account}
+	}
+
+	if resp, denied := opts.Extensions.PreAuth(extensionRequest(msg, peer)); denied {
+		if err = reply(resp); err != nil {
+			log.Errorf("Error replying error message to the client: %v", err)
+		}
+		return
+	}
+
+	loggedUser, err := isValid(msg, auth, peer)
 	if err != nil {
-		if err = replyMessage(client, NewResponseMessage("400", err.Error())); err != nil {
+		log.Warnf("Rejecting %q of %q from %v: %v", msg.Header["user"], msg.Header["org"], peer, err)
+		resp = NewResponseMessage(authErrorCode(err), err.Error())
+		if protocolErr, ok := err.(UnsupportedProtocolError); ok {
+			resp = unsupportedProtocolResponse(protocolErr)
+		}
+		if err = reply(resp); err != nil {
+			log.Errorf("Error replying error message to the client: %v", err)
+		}
+		return
+	}
+	if err := conn.checkUser(msg); err != nil {
+		log.Warnf("Rejecting %q of %q from %v: %v", msg.Header["user"], msg.Header["org"], peer, err)
+		if err = reply(NewResponseMessage("400", err.Error())); err != nil {
 			log.Errorf("Error replying error message to the client: %v", err)
 		}
 		return
 	}
 
-	resp = processMessage(msg, loggedUser, ra)
+	encodedSize := len(msg.Payload)
+	if err := decodePayload(&msg, opts.RequestLimit); err != nil {
+		log.Warnf("Rejecting %q of %q from %v: %v", msg.Header["user"], msg.Header["org"], peer, err)
+		resp := catalogResponse("400", MsgMalformedData)
+		switch err.(type) {
+		case UnsupportedEncodingError, InvalidUTF8Error:
+			resp = catalogResponse("401", MsgUnsupportedEncoding)
+		case PayloadTooLargeError:
+			resp = catalogResponse("504", MsgRequestTooBig)
+		}
+		if err = reply(resp); err != nil {
+			log.Errorf("Error replying error message to the client: %v", err)
+		}
+		return
+	}
+	// the compressed payloads only reserved their compressed size
+	if decodedSize := len(msg.Payload); decodedSize > encodedSize && !account.reserve(decodedSize-encodedSize) {
+		log.Warnf("Rejecting request from %v: %v", peer, errMemoryBudget)
+		if err = reply(memoryBudgetResponse()); err != nil {
+			log.Errorf("Error replying error message to the client: %v", err)
+		}
+		return
+	}
 
-	if err := replyMessage(client, resp); err != nil {
-		log.Errorf("Error sending response message: %v", err)
+	// isValid already verified the client protocol
+	codec, _ := codecFor(msg.Header["protocol"])
+	if msg.Payload, err = codec.DecodeRequest(msg.Payload); err != nil {
+		log.Warnf("Rejecting %q of %q from %v: %v", msg.Header["user"], msg.Header["org"], peer, err)
+		if err = reply(catalogResponse("400", MsgMalformedData)); err != nil {
+			log.Errorf("Error replying error message to the client: %v", err)
+		}
 		return
 	}
+
+	resp = processMessage(msg, loggedUser, ra, opts)
+	if _, identity := codec.(v1Codec); !identity {
+		// the codecs translate the whole payload
+		if err = resp.materialize(); err != nil {
+			resp = internalErrorResponse(fmt.Errorf("encoding response: %v", err))
+		}
+	}
+	if resp.Payload != "" {
+		if resp.Payload, err = codec.EncodeResponse(resp.Payload); err != nil {
+			resp = internalErrorResponse(fmt.Errorf("encoding response: %v", err))
+		}
+	}
+	resp.Header["protocol"] = msg.Header["protocol"]
+	if strings.HasPrefix(resp.Header["code"], "2") && overSoftLimit(requestSize, opts.RequestLimit, opts.LimitWarn) {
+		addWarning(&resp, limitWarning("the request size", requestSize, opts.RequestLimit))
+	}
+
+	if err := encodePayload(msg, &resp); err != nil {
+		resp = internalErrorResponse(fmt.Errorf("encoding response: %v", err))
+	}
+
+	if keepAlive = conn.keepAlive(client, msg, resp, opts); keepAlive {
+		resp.Header[KeepAliveHeader] = strconv.Itoa(int(opts.KeepAlive / time.Second))
+	}
+
+	responseSize := resp.size() + 4
+	if err := reply(resp); err != nil {
+		log.Errorf("Error sending response message: %v", err)
+		return false
+	}
+
+	if msg.Header["type"] == "sync" && !isDryRun(msg) && strings.HasPrefix(resp.Header["code"], "2") {
+		opts.Stats.recordSync(msg.Header["org"], requestSize, responseSize)
+	}
+	if opts.RecordSync != nil && msg.Header["type"] == "sync" && !isDryRun(msg) && strings.HasPrefix(resp.Header["code"], "2") {
+		opts.RecordSync(loggedUser, repo.LastSync{
+			Time:    time.Now(),
+			Client:  msg.Header["client"],
+			Address: peer.Address,
+			Size:    len(msg.Payload),
+		})
+	}
+	if msg.Header["type"] == "sync" {
+		req := extensionRequest(msg, peer)
+		req.Code, req.RequestSize, req.ResponseSize = resp.Header["code"], requestSize, responseSize
+		go opts.Extensions.PostSync(req)
+	}
+
+	return keepAlive
 }
 
-func receiveMessage(client io.Reader) (msg Message, err error) {
-	buffer := make([]byte, 4)
+// peerOf returns the client identity seen by the transport, only the
+// address if the connection doesn't come from a transport.Server.
+func peerOf(client io.ReadWriteCloser) auth.Peer {
+	if c, ok := client.(transport.Client); ok {
+		return c.Peer()
+	}
+	return auth.Peer{Address: remoteAddress(client)}
+}
 
-	if num, err := client.Read(buffer); err != nil || num != 4 {
-		return msg, fmt.Errorf("reading size, read %v bytes, got %v", num, err)
+// remoteAddress returns the client address if the connection exposes it.
+func remoteAddress(client io.ReadWriteCloser) string {
+	if conn, ok := client.(interface{ RemoteAddr() net.Addr }); ok && conn.RemoteAddr() != nil {
+		return conn.RemoteAddr().String()
 	}
+	return ""
+}
+
+// authErrorCode returns the response code for an authentication failure.
+func authErrorCode(err error) string {
+	if authErr, ok := err.(auth.AuthenticationError); ok && authErr.Code != "" {
+		return authErr.Code
+	}
+	return "400"
+}
 
-	messageSize := int(binary.BigEndian.Uint32(buffer[:4]))
-	if messageSize > RequestLimitInBytes {
-		return Message{}, errors.New("message size limit exceeded")
+func maintenanceResponse() Message {
+	resp, err := NewResponse(420).
+		WithHeader(RetryAfterHeader, strconv.Itoa(MaintenanceRetryAfter)).
+		Build()
+	if err != nil {
+		return internalErrorResponse(err)
 	}
+	return resp
+}
 
-	buffer = make([]byte, messageSize-4)
+// Busy replies the client that the server is too busy to handle its request.
+// The request is read anyway, closing a connection with unread data could
+// drop the response.
+func Busy(client io.ReadWriteCloser, opts Options) {
+	defer client.Close()
+	start := time.Now()
 
-	if _, err := client.Read(buffer); err != nil {
-		return msg, fmt.Errorf("reading client, got %v", err)
+	receiver := newReceiver(client, opts)
+	msg, err := ReadMessage(receiver, opts.RequestLimit)
+	receiver.done()
+	if errors.Is(err, errSlowClient) {
+		log.Warnf("Closing the connection with %v: %v", peerOf(client), err)
+		return
+	} else if err != nil {
+		log.Debugf("Error reading the request of a rejected client: %v", err)
 	}
 
-	return NewMessage(string(buffer))
+	resp, err := NewResponse(420).
+		withCatalogStatus(MsgServerBusy).
+		WithHeader(RetryAfterHeader, strconv.Itoa(BusyRetryAfter)).
+		Build()
+	if err != nil {
+		resp = internalErrorResponse(err)
+	}
+	localize(&resp, msg.Header[LocaleHeader])
+	if err := respond(client, resp, opts); err != nil {
+		log.Errorf("Error replying the busy message to the client: %v", err)
+	}
+	opts.Stats.record(resp.Header["code"], time.Since(start))
 }
 
-func processMessage(msg Message, user auth.User, ra ReadAppender) (resp Message) {
-	switch t := msg.Header["type"]; t {
-	case "sync":
-		return sync(msg, user, ra)
-	default:
-		return NewResponseMessage("500", fmt.Sprintf("unknown message type: %q", t))
+// redirectResponse tells the client to use another server, whose address is
+// sent in the "info" header.
+func redirectResponse(address string) Message {
+	resp, err := NewResponse(301).WithHeader("info", address).Build()
+	if err != nil {
+		return internalErrorResponse(err)
 	}
+	return resp
 }
 
-func replyMessage(client io.Writer, resp Message) error {
-	responseMessage := resp.Serialize()
+// respond adds the configured server headers to the response and sends it to
+// the client.
+func respond(client io.Writer, resp Message, opts Options) error {
+	addServerHeaders(&resp, opts)
+	return replyMessage(client, resp)
+}
 
-	if size, err := client.Write([]byte(responseMessage[:4])); err != nil || size < 4 {
-		return fmt.Errorf("writing size to the client, sent %v: %v", size, err)
+func addServerHeaders(resp *Message, opts Options) {
+	if resp.Header == nil {
+		resp.Header = make(map[string]string)
 	}
+	if opts.Identity != "" {
+		resp.Header["server"] = opts.Identity
+	}
+	if _, ok := resp.Header["protocol"]; !ok {
+		resp.Header["protocol"] = ProtocolVersion
+	}
+	if opts.Message != "" {
+		// the warnings follow the message of the day
+		warnings := resp.Header["message"]
+		resp.Header["message"] = opts.Message
+		if warnings != "" {
+			addWarning(resp, warnings)
+		}
+	}
+	if info, ok := resp.Header["info"]; !ok && opts.MaintenanceMessage != "" {
+		resp.Header["info"] = opts.MaintenanceMessage
+	} else if ok && opts.MaintenanceMessage != "" && strings.HasPrefix(resp.Header["code"], "2") {
+		// the notice precedes the sync diagnostics
+		resp.Header["info"] = opts.MaintenanceMessage + warningSeparator + info
+	}
+}
 
-	if size, err := client.Write([]byte(responseMessage[4:])); err != nil || size < len(responseMessage)-4 {
-		return fmt.Errorf("writing response to the client, sent %v: %v", size, err)
+func processMessage(msg Message, user auth.User, ra ReadAppender, opts Options) (resp Message) {
+	switch t := msg.Header["type"]; t {
+	case "sync":
+		if opts.Maintenance != nil && opts.Maintenance() {
+			log.Infof("Rejecting sync from %q: server in maintenance mode", user.Name)
+			return maintenanceResponse()
+		}
+		if isDryRun(msg) && !opts.dryRunAllowed(user) {
+			log.Warnf("Rejecting dry-run sync from %q: not allowed", user.Name)
+			return catalogResponse("430", MsgDryRunDenied)
+		}
+		return sync(msg, user, ra, opts)
+	case "statistics":
+		return statistics(user, opts)
+	default:
+		return catalogResponse("500", MsgUnknownType, t)
 	}
+}
 
-	return nil
+func replyMessage(client io.Writer, resp Message) error {
+	_, err := resp.WriteTo(client)
+	return err
 }
 
-func isValid(msg Message, a auth.Authenticator) (auth.User, error) {
+func isValid(msg Message, a auth.Authenticator, peer auth.Peer) (auth.User, error) {
 	userName := msg.Header["user"]
 	key := msg.Header["key"]
 	orgName := msg.Header["org"]
 
 	// verify user credentials
-	loggedUser, err := a.Authenticate(orgName, userName, key)
+	var loggedUser auth.User
+	var err error
+	if pa, ok := a.(auth.PeerAuthenticator); ok {
+		loggedUser, err = pa.AuthenticatePeer(orgName, userName, key, peer)
+	} else {
+		loggedUser, err = a.Authenticate(orgName, userName, key)
+	}
 	if err != nil {
 		return loggedUser, err
 	}
 
 	// verify protocol version
-	if msg.Header["protocol"] != "v1" {
-		return auth.User{}, fmt.Errorf("protocol not supported (%s)", msg.Header["protocol"])
+	if _, err := codecFor(msg.Header["protocol"]); err != nil {
+		return auth.User{}, err
 	}
 
 	// TODO verify redirect
@@ -132,31 +614,61 @@ func isValid(msg Message, a auth.Authenticator) (auth.User, error) {
 	return loggedUser, nil
 }
 
-func sync(msg Message, user auth.User, ra ReadAppender) Message {
+func sync(msg Message, user auth.User, ra ReadAppender, opts Options) Message {
 	var err error
-	tx, clientData := getClientData(msg.Payload)
+	tx, clientData, skipped := getClientData(msg.Payload, opts.LenientDates)
+	if len(clientData) > 0 && !isDryRun(msg) {
+		if resp, readOnly := opts.readOnly(user); readOnly {
+			log.Infof("Rejecting sync from %q: read-only", user.Name)
+			return resp
+		}
+	}
+	// held until the new transactions are appended, the group members and
+	// the REST API change the same ones
+	defer lockStream(user)()
 	serverData, err := ra.Read(user)
-	if err != nil {
-		log.Errorf("Error reading user dada: %v", err)
-		return NewResponseMessage("500", "Error reading user data")
+	if err == errMemoryBudget {
+		log.Warnf("Rejecting sync from %q: %v", user.Name, err)
+		return memoryBudgetResponse()
+	} else if err != nil {
+		log.Errorf("Error reading user data: %v", err)
+		return catalogResponse("500", MsgReadFailed)
+	}
+	if opts.Archives != nil {
+		key := tx
+		if key == ResetSyncKey {
+			key = ""
+		}
+		if serverData, err = opts.Archives.Unarchive(user, serverData, key); err != nil {
+			log.Errorf("Error reading user archive: %v", err)
+			return catalogResponse("500", MsgReadFailed)
+		}
 	}
 	log.Infof("Loaded %v records", len(serverData))
 
 	branchPoint := findBranchPoint(serverData, tx)
 	if branchPoint == -1 {
-		return NewResponseMessage("500", "Could not find the last sync transaction. Did you skip the 'task sync init' requirement?")
+		opts.Anomalies.report(user, AnomalyBranchPoint, fmt.Sprintf("sync key %q", tx))
+		return catalogResponse("500", MsgUnknownSyncKey)
+	}
+
+	if err := checkDrift(user, msg.Payload, tx, clientData, opts); err != nil {
+		log.Warnf("Rejecting the sync of %q: %v", user.Name, err)
+		var clientErr clientError
+		if errors.As(err, &clientErr) {
+			return catalogResponse("400", clientErr.id, clientErr.args...)
+		}
+		return internalErrorResponse(err)
 	}
 
 	serverSubset, err := extractSubset(serverData, branchPoint)
 	if err != nil {
-		return NewResponseMessage("500", err.Error())
+		return internalErrorResponse(err)
 	}
 
-	var newServerData, newClientData []string
-
 	// Maintain a list of already-merged task UUIDs.
 	alreadySeen := make(map[string]bool)
-	var storeCount, mergeCount int
+	var entries []syncEntry
 
 	// For each incoming task...
 	for _, clientTask := range clientData {
@@ -172,58 +684,81 @@ func sync(msg Message, user auth.User, ra ReadAppender) Message {
 			}
 
 			alreadySeen[uuid] = true
+			entries = append(entries, syncEntry{task: clientTask, merge: true})
+		} else {
+			entries = append(entries, syncEntry{task: clientTask})
+		}
+	}
 
-			// Find common ancestor, prior to branch point
-			commonAncestor, err := findCommonAncestor(serverData, branchPoint, uuid)
-			if err != nil {
-				return NewResponseMessage("500", err.Error())
-			}
-
-			// List the client-side modifications.
-			clientMods := getClientMods(clientData, uuid)
-
-			// List the server-side modifications.
-			serverMods, err := getServerMods(serverData, uuid, commonAncestor)
-			if err != nil {
-				return NewResponseMessage("500", err.Error())
-			}
-
-			// Merge sort between clientMods and serverMods, patching ancestor.
-			combined, err := NewTask(serverData[commonAncestor])
-			if err != nil {
-				return NewResponseMessage("500", err.Error())
-			}
-
-			mergeSort(clientMods, serverMods, combined)
+	// Every UUID is merged independently, so entries are processed
+	// concurrently and collected afterwards keeping the client order.
+	processEntries(entries, opts.SyncWorkers, func(e *syncEntry) {
+		if e.merge {
+			e.result, e.steps, e.err = mergeTask(serverData, clientData, branchPoint, e.task.Get("uuid"), opts.mergeStrategy(user), opts.MergeShadow)
+		} else {
+			// Task not in subset, therefore can be stored unmodified.  Does not get
+			// returned to client.
+			e.result, e.err = e.task.ComposeJSON()
+		}
+	})
 
-			combinedJSON := combined.ComposeJSON()
+	var newServerData, newClientData []string
+	var storeCount, mergeCount int
+	for _, e := range entries {
+		if e.err != nil {
+			return internalErrorResponse(e.err)
+		}
 
+		newServerData = append(newServerData, (e.result + "\n"))
+		if e.merge {
 			// Append combined task to client and server data, if not already there.
-			newServerData = append(newServerData, (combinedJSON + "\n"))
-			newClientData = append(newClientData, combinedJSON)
+			newClientData = append(newClientData, e.result)
 			mergeCount++
 		} else {
-			// Task not in subset, therefore can be stored unmodified.  Does not get
-			// returned to client.
-			newServerData = append(newServerData, (clientTask.ComposeJSON() + "\n"))
 			storeCount++
 		}
 	}
 
 	log.Infof("Stored %v tasks, merged %v tasks", storeCount, mergeCount)
 
+	if isDryRun(msg) {
+		resp := dryRunResponse(newServerData, storeCount, mergeCount)
+		addDiagnostics(&resp, storeCount, mergeCount, skipped, opts)
+		return resp
+	}
+
 	// New server data means a new sync key must be generated.  No new server data
 	// means the most recent sync key is reused.
 	newSyncKey := ""
+	quota := opts.quota(user)
+	usedQuota := dataSize(serverData)
 	if len(newServerData) > 0 {
-		newSyncKey = uuid.New().String()
+		newSyncKey = opts.newKey()
 		newServerData = append(newServerData, (newSyncKey + "\n"))
 		log.Infof("New sync key %q", newSyncKey)
 
+		usedQuota += len(strings.Join(newServerData, ""))
+		if quota > 0 && usedQuota > quota {
+			log.Warnf("Rejecting sync from %q: quota exceeded (%d of %d bytes)", user.Name, usedQuota, quota)
+			return quotaResponse(usedQuota, quota)
+		}
+
 		// Append new_server_data to file.
 		// append_server_data(org, password, newServerData)
 		if err := ra.Append(user, newServerData); err != nil {
-			return NewResponseMessage("500", err.Error())
+			if redirect, ok := err.(RedirectError); ok {
+				return redirectResponse(redirect.Address)
+			} else if readOnly, ok := err.(ReadOnlyError); ok {
+				return readOnlyResponse(readOnly)
+			}
+			return internalErrorResponse(err)
+		}
+
+		if opts.OnSync != nil {
+			opts.OnSync(newSyncEvent(user, entries))
+		}
+		if opts.RecordMerges != nil && mergeCount > 0 {
+			opts.RecordMerges(user, mergeRecords(entries, opts.mergeStrategy(user)))
 		}
 	} else {
 		for i := len(serverData) - 1; i >= 0; i-- {
@@ -235,49 +770,178 @@ func sync(msg Message, user auth.User, ra ReadAppender) Message {
 		log.Infof("Sync key %q still valid", newSyncKey)
 	}
 
-	out := Message{
-		Payload: getResponsePayload(serverSubset, newClientData, newSyncKey),
-		Header:  make(map[string]string),
+	if (tx == "" || tx == ResetSyncKey) && opts.CondensedInit {
+		serverSubset = condense(serverSubset)
+	}
+	payload, err := newTaskStream(serverSubset, newClientData, newSyncKey)
+	if err != nil {
+		return internalErrorResponse(err)
 	}
 
 	// If there are changes, respond with 200, otherwise 201.
+	code := 201
 	if len(serverSubset) > 0 || len(newClientData) > 0 || len(newServerData) > 0 {
-		log.Infof("returning 200")
-		out.Header["code"] = "200"
-		out.Header["status"] = ErrorCodes[200]
+		code = 200
+	}
+	log.Infof("returning %d", code)
+
+	builder := NewResponse(code)
+	if payload.Size() > streamThreshold {
+		log.Infof("Streaming %d bytes", payload.Size())
+		builder.WithStream(payload)
 	} else {
-		log.Infof("returning 201")
-		out.Header["code"] = "201"
-		out.Header["status"] = ErrorCodes[201]
-		log.Infof("No change")
+		builder.WithPayload(payload.String())
+	}
+	out, err := builder.Build()
+	if err != nil {
+		return internalErrorResponse(err)
 	}
 
+	if overSoftLimit(usedQuota, quota, opts.LimitWarn) {
+		addWarning(&out, limitWarning("your data", usedQuota, quota))
+	}
+	addDiagnostics(&out, storeCount, mergeCount, skipped, opts)
+
 	return out
 }
 
-func getResponsePayload(serverSubset []Task, newClientData []string, newSyncKey string) string {
-	// If there is outgoing data, generate payload + key.
-	payload := ""
-	if len(serverSubset) > 0 || len(newClientData) > 0 {
-		payload = generatePayload(serverSubset, newClientData, newSyncKey)
-	} else {
-		// No outgoing data, just sent the latest key.
-		payload = newSyncKey + "\n"
+// maxSkippedDetails is the number of invalid lines explained in the error
+// header, the rest are only counted.
+const maxSkippedDetails = 3
+
+// addDiagnostics adds the taskd diagnostic headers to a sync response.  The
+// info header, with the tasks stored and merged, is only added if verbose.
+// The error header explaining why the first invalid lines were skipped is
+// always added, so a dropped task is never silently lost.
+func addDiagnostics(resp *Message, stored, merged int, skipped []string, opts Options) {
+	if !strings.HasPrefix(resp.Header["code"], "2") {
+		return
 	}
+	if opts.Verbose {
+		resp.Header["info"] = fmt.Sprintf("Stored %d tasks, merged %d tasks", stored, merged)
+	}
+	if len(skipped) > 0 {
+		details := skipped
+		if len(details) > maxSkippedDetails {
+			details = append(details[:maxSkippedDetails:maxSkippedDetails], "...")
+		}
+		resp.Header["error"] = fmt.Sprintf("Skipped %d invalid lines: %s", len(skipped), strings.Join(details, "; "))
+	}
+}
 
-	return payload
+// syncEntry is an incoming client task to be either stored or merged, along
+// with the resulting JSON representation.
+type syncEntry struct {
+	task   Task
+	merge  bool
+	result string
+	steps  []MergeStep
+	err    error
 }
 
-func getClientData(payload string) (tx string, tasks []Task) {
+// processEntries applies fn to every entry using a bounded number of workers.
+// Each entry is processed exactly once and by a single goroutine.
+func processEntries(entries []syncEntry, workers int, fn func(*syncEntry)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan *syncEntry)
+	var wg gosync.WaitGroup
+	for i := 0; i < workers && i < len(entries); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				processEntry(e, fn)
+			}
+		}()
+	}
+
+	for idx := range entries {
+		jobs <- &entries[idx]
+	}
+	close(jobs)
+
+	wg.Wait()
+}
+
+// processEntry applies fn to the entry, turning a panic into an entry error
+// because it happens outside the goroutine handling the client request.
+func processEntry(e *syncEntry, fn func(*syncEntry)) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Recovered from panic processing task %q: %v\n%s", e.task.Get("uuid"), r, debug.Stack())
+			e.err = fmt.Errorf("internal server error processing task %q", e.task.Get("uuid"))
+		}
+	}()
+
+	fn(e)
+}
+
+// mergeTask merges the client and server modifications of the task with the
+// given uuid using the strategy engine, and returns the combined task as JSON
+// along with the merge audit trail.
+// A shadow strategy, if any, merges them too and its result is only compared
+// to the stored one.
+func mergeTask(serverData []string, clientData []Task, branchPoint int, uuid string, strategy, shadow MergeStrategy) (string, []MergeStep, error) {
+	// Find common ancestor, prior to branch point
+	commonAncestor, err := findCommonAncestor(serverData, branchPoint, uuid)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// List the client-side modifications.
+	clientMods := getClientMods(clientData, uuid, len(serverData))
+
+	// List the server-side modifications.
+	serverMods, err := getServerMods(serverData, uuid, commonAncestor)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ancestor, err := NewTask(serverData[commonAncestor])
+	if err != nil {
+		return "", nil, err
+	}
+
+	combined, steps := strategy.Engine().Merge(ancestor, clientMods, serverMods)
+	for _, step := range steps {
+		log.Infof("Merge %s of %s: %v", strategy, uuid, step)
+	}
+	log.Infof("Merge result %v", combined.data)
+
+	if shadow != "" && shadow != strategy {
+		shadowed, _ := shadow.Engine().Merge(ancestor, clientMods, serverMods)
+		if changes := Diff(combined, shadowed); !changes.Empty() {
+			log.Warnf("Merge %s of %s differs from %s: +%v -%v ~%v", shadow, uuid, strategy,
+				changes.Added, changes.Removed, changes.Modified)
+		}
+	}
+
+	result, err := combined.ComposeJSON()
+	return result, steps, err
+}
+
+// getClientData parses the sync payload, returning the sync key, the tasks
+// and why every invalid line was skipped.  The lenient mode accepts the dates
+// of NewLenientTask.
+func getClientData(payload string, lenient bool) (tx string, tasks []Task, skipped []string) {
+	parse := NewTask
+	if lenient {
+		parse = NewLenientTask
+	}
+
 	scanner := bufio.NewScanner(strings.NewReader(payload))
-	for scanner.Scan() {
+	for number := 1; scanner.Scan(); number++ {
 		line := scanner.Text()
 
 		if len(line) > 0 {
 			if strings.HasPrefix(line, "{") {
-				t, err := NewTask(line)
+				t, err := parse(line)
 				if err != nil {
 					log.Warnf("Error parsing task: %v", err)
+					skipped = append(skipped, fmt.Sprintf("line %d: %v", number, err))
 					continue
 				}
 				tasks = append(tasks, t)
@@ -285,18 +949,22 @@ func getClientData(payload string) (tx string, tasks []Task) {
 			} else {
 				if parsed, err := uuid.Parse(line); err != nil {
 					log.Warnf("Error parsing UUID %s: %v", line, err)
+					skipped = append(skipped, fmt.Sprintf("line %d: invalid sync key", number))
 				} else {
 					tx = parsed.String()
 				}
 			}
 		}
 	}
-	return tx, tasks
+	return tx, tasks, skipped
 }
 
+// findBranchPoint returns the index of the sync key in the user data, -1 if
+// it's not found.  A missing key is either a first-time sync or a request to
+// get all the data, and so is the zero UUID (ResetSyncKey), sent by the
+// clients that reset their backlog: both return 0, sending everything.
 func findBranchPoint(data []string, key string) int {
-	// A missing key is either a first-time sync, or a request to get all data.
-	if key == "" {
+	if key == "" || key == ResetSyncKey {
 		return 0
 	}
 
@@ -331,6 +999,27 @@ func extractSubset(data []string, branchPoint int) ([]Task, error) {
 	return tasks, nil
 }
 
+// condense keeps the latest state of every task, in the order the tasks were
+// first seen, so a full resync sends one line per task instead of all its
+// modifications.
+func condense(tasks []Task) []Task {
+	index := make(map[string]int)
+	var condensed []Task
+	for _, t := range tasks {
+		uuid := t.Get("uuid")
+		if idx, ok := index[uuid]; ok {
+			condensed[idx] = t
+			continue
+		}
+		index[uuid] = len(condensed)
+		condensed = append(condensed, t)
+	}
+	if dropped := len(tasks) - len(condensed); dropped > 0 {
+		log.Infof("Condensed %v tasks, %v older states dropped", len(condensed), dropped)
+	}
+	return condensed
+}
+
 func taskContains(taskList []Task, name, value string) bool {
 	for _, t := range taskList {
 		if t.Get(name) == value {
@@ -370,16 +1059,17 @@ func findCommonAncestor(data []string, branchPoint int, uuid string) (int, error
 		}
 	}
 
-	return 0, fmt.Errorf("could not find common ancestor for %q. Did you skip the 'task sync init' requirement?", uuid)
+	return 0, clientError{catalogStatus{id: MsgNoCommonAncestor, args: []interface{}{uuid}}}
 }
 
 // Extract tasks from the client list, with the given UUID, maintaining the
-// sequence.
-func getClientMods(data []Task, uuid string) []Task {
-	var mods []Task
-	for _, t := range data {
+// sequence.  They are received after the server data, so their receipt
+// sequence numbers start at base.
+func getClientMods(data []Task, uuid string, base int) []Modification {
+	var mods []Modification
+	for i, t := range data {
 		if t.Get("uuid") == uuid {
-			mods = append(mods, t)
+			mods = append(mods, Modification{Task: t, Seq: base + i})
 		}
 	}
 	return mods
@@ -387,8 +1077,8 @@ func getClientMods(data []Task, uuid string) []Task {
 
 // Extract tasks from the server list, with the given UUID, maintaining the
 // sequence.
-func getServerMods(data []string, uuid string, ancestor int) ([]Task, error) {
-	var mods []Task
+func getServerMods(data []string, uuid string, ancestor int) ([]Modification, error) {
+	var mods []Modification
 	for i := ancestor + 1; i < len(data); i++ {
 		if strings.HasPrefix(data[i], "{") {
 			t, err := NewTask(data[i])
@@ -396,54 +1086,13 @@ func getServerMods(data []string, uuid string, ancestor int) ([]Task, error) {
 				return nil, err
 			}
 			if t.Get("uuid") == uuid {
-				mods = append(mods, t)
+				mods = append(mods, Modification{Task: t, Seq: i})
 			}
 		}
 	}
 	return mods, nil
 }
 
-// Simultaneously walks two lists, select either the left or the right depending
-// on last modification time.
-func mergeSort(left []Task, right []Task, combined Task) {
-	prevLeft, prevRight := combined.Copy(), combined.Copy()
-	var idxLeft, idxRight int
-
-	for idxLeft < len(left) && idxRight < len(right) {
-		modLeft := lastModification(left[idxLeft])
-		modRigth := lastModification(right[idxRight])
-		if modLeft.Before(modRigth) {
-			log.Infof("applying left %d < %d", modLeft.Unix(), modRigth.Unix())
-			patch(combined, prevLeft, left[idxLeft])
-			combined.SetDate("modified", modLeft)
-			prevLeft = left[idxLeft]
-			idxLeft++
-		} else {
-			log.Infof("applying right %d >= %d", modLeft.Unix(), modRigth.Unix())
-			patch(combined, prevRight, right[idxRight])
-			combined.SetDate("modified", modRigth)
-			prevRight = right[idxRight]
-			idxRight++
-		}
-	}
-
-	for idxLeft < len(left) {
-		patch(combined, prevLeft, left[idxLeft])
-		combined.SetDate("modified", lastModification(left[idxLeft]))
-		prevLeft = left[idxLeft]
-		idxLeft++
-	}
-
-	for idxRight < len(right) {
-		patch(combined, prevRight, right[idxRight])
-		combined.SetDate("modified", lastModification(right[idxRight]))
-		prevRight = right[idxRight]
-		idxRight++
-	}
-
-	log.Infof("Merge result %s", combined.ComposeJSON())
-}
-
 // //////////////////////////////////////////////////////////////////////////////
 // Get the last modication time for a task.  Ideally this is the attribute
 // "modification".  If that is missing (pre taskwarrior 2.2.0), use the later of
@@ -460,81 +1109,29 @@ func lastModification(t Task) time.Time {
 	return t.GetDate("entry")
 }
 
-func generatePayload(subset []Task, additions []string, key string) string {
-	payload := new(strings.Builder)
-
-	for _, s := range subset {
-		payload.Write([]byte(s.ComposeJSON()))
-		payload.Write([]byte("\n"))
-	}
-
-	for _, a := range additions {
-		payload.Write([]byte(a))
-		payload.Write([]byte("\n"))
-	}
-
-	payload.Write([]byte(key))
-	payload.Write([]byte("\n"))
-
-	return payload.String()
-}
-
 // //////////////////////////////////////////////////////////////////////////////
 // Determine the delta between 'from' and 'to', and apply only those changes to
 // 'base'.  All three tasks have the same uuid.
-func patch(base, from, to Task) {
-	// Determine the different attribute names between from and to.
-	fromAtts := from.GetAttrNames()
-	toAtts := to.GetAttrNames()
-
-	fromOnly, toOnly := listDiff(fromAtts, toAtts)
-	commonAtts := listIntersect(fromAtts, toAtts)
+func patch(base, from, to Task) Changes {
+	changes := Diff(from, to)
 
 	// The from-only attributes must be deleted from base.
-	for _, att := range fromOnly {
+	for _, att := range changes.Removed {
 		log.Infof("patch remove %v", att)
 		base.Remove(att)
 	}
 
 	// The to-only attributes must be added to base.
-	for _, att := range toOnly {
+	for _, att := range changes.Added {
 		log.Infof("patch add %v=%v", att, to.Get(att))
 		base.Set(att, to.Get(att))
 	}
 
 	// The intersecting attributes, if the values differ, are applied.
-	for _, att := range commonAtts {
-		if from.Get(att) != to.Get(att) {
-			log.Infof("patch modify %v=%v", att, to.Get(att))
-			base.Set(att, to.Get(att))
-		}
-	}
-}
-
-// List operations.
-func listDiff(left, right []string) (leftOnly, rightOnly []string) {
-
-	for _, l := range left {
-		if !sliceContains(right, l) {
-			leftOnly = append(leftOnly, l)
-		}
-	}
-
-	for _, r := range right {
-		if !sliceContains(left, r) {
-			rightOnly = append(rightOnly, r)
-		}
-	}
-
-	return leftOnly, rightOnly
-}
-
-func listIntersect(left, right []string) (intersection []string) {
-	for _, l := range left {
-		if sliceContains(right, l) {
-			intersection = append(intersection, l)
-		}
+	for _, att := range changes.Modified {
+		log.Infof("patch modify %v=%v", att, to.Get(att))
+		base.Set(att, to.Get(att))
 	}
 
-	return intersection
+	return changes
 }
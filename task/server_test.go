@@ -3,15 +3,20 @@ package task
 import (
 	"bufio"
 	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/repo"
 )
 
 type mockClient struct {
@@ -24,8 +29,11 @@ type mockClient struct {
 
 type mockAuth struct {
 	fails bool
+	err   error
 }
 
+type panicReadAppender struct{}
+
 type mockReadAppender struct {
 	reader *strings.Reader
 	writer *strings.Builder
@@ -51,7 +59,9 @@ func (c *mockClient) Close() error {
 }
 
 func (a *mockAuth) Authenticate(orgName, userName, key string) (auth.User, error) {
-	if a.fails {
+	if a.err != nil {
+		return auth.User{}, a.err
+	} else if a.fails {
 		return auth.User{}, errors.New("Invalid credentials")
 	}
 	return auth.User{}, nil
@@ -73,6 +83,14 @@ func (ra *mockReadAppender) Append(user auth.User, data []string) error {
 	return nil
 }
 
+func (ra *panicReadAppender) Read(user auth.User) ([]string, error) {
+	panic("crafted panic")
+}
+
+func (ra *panicReadAppender) Append(user auth.User, data []string) error {
+	panic("crafted panic")
+}
+
 func TestProcessMessage(t *testing.T) {
 
 	cases := []struct {
@@ -118,7 +136,7 @@ func TestProcessMessage(t *testing.T) {
 
 			expected := loadFile(t, c.txAfter)
 
-			Process(client, auth, ra)
+			Process(client, auth, ra, DefaultOptions())
 
 			assert.True(t, client.closed)
 			assert.NotNil(t, client.writer.String())
@@ -138,22 +156,26 @@ func TestProcessMessage(t *testing.T) {
 			writer: new(strings.Builder),
 		}
 
-		Process(client, auth, ra)
+		Process(client, auth, ra, DefaultOptions())
 
 		comparePayloads(t, string(loadPayload(t, "msg-replied-error-reading")), client.writer.String())
 	})
 
 	t.Run("fail if client broken pipe", func(t *testing.T) {
+		// the size is sent but the client hangs up before sending the message
+		sizeBuffer := make([]byte, 4)
+		binary.BigEndian.PutUint32(sizeBuffer, uint32(100))
+
 		client := &mockClient{
 			writer: new(strings.Builder),
-			reader: strings.NewReader(loadPayload(t, "tx-init-before.data")),
+			reader: strings.NewReader(string(sizeBuffer)),
 		}
 		auth := &mockAuth{}
 		ra := &mockReadAppender{
 			writer: new(strings.Builder),
 		}
 
-		Process(client, auth, ra)
+		Process(client, auth, ra, DefaultOptions())
 
 		comparePayloads(t, string(loadPayload(t, "msg-replied-client-broken-pipe")), client.writer.String())
 	})
@@ -168,11 +190,88 @@ func TestProcessMessage(t *testing.T) {
 			writer: new(strings.Builder),
 		}
 
-		Process(client, auth, ra)
+		Process(client, auth, ra, DefaultOptions())
 
 		comparePayloads(t, string(loadPayload(t, "msg-replied-invalid-credentials")), client.writer.String())
 	})
 
+	t.Run("reply the authentication error code", func(t *testing.T) {
+		client := &mockClient{
+			writer: new(strings.Builder),
+			reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+		}
+		a := &mockAuth{err: auth.AuthenticationError{Code: "432", Msg: "Account terminated"}}
+		ra := &mockReadAppender{
+			writer: new(strings.Builder),
+		}
+
+		Process(client, a, ra, DefaultOptions())
+
+		resp := parseMsg(t, client.writer.String())
+		assert.Equal(t, "432", resp.Header["code"])
+		assert.Equal(t, "Account terminated", resp.Header["status"])
+	})
+
+	t.Run("record the successful syncs", func(t *testing.T) {
+		client := &mockClient{
+			writer: new(strings.Builder),
+			reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+		}
+		ra := &mockReadAppender{
+			reader: strings.NewReader(string(loadFile(t, "tx-init-before.data"))),
+			writer: new(strings.Builder),
+		}
+
+		var recorded []repo.LastSync
+		opts := DefaultOptions()
+		opts.RecordSync = func(_ auth.User, sync repo.LastSync) {
+			recorded = append(recorded, sync)
+		}
+
+		Process(client, &mockAuth{}, ra, opts)
+
+		msg := parseMsg(t, loadPayload(t, "msg-sent-init"))
+		if assert.Len(t, recorded, 1) {
+			assert.Equal(t, msg.Header["client"], recorded[0].Client)
+			assert.Equal(t, len(msg.Payload), recorded[0].Size)
+			assert.False(t, recorded[0].Time.IsZero())
+		}
+
+		recorded = nil
+		client = &mockClient{
+			writer: new(strings.Builder),
+			reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+		}
+		Process(client, &mockAuth{err: auth.AuthenticationError{Code: "432", Msg: "Account terminated"}}, ra, opts)
+		assert.Len(t, recorded, 0)
+	})
+
+	t.Run("record the merges", func(t *testing.T) {
+		client := &mockClient{
+			writer: new(strings.Builder),
+			reader: strings.NewReader(loadPayload(t, "msg-sent-case01")),
+		}
+		ra := &mockReadAppender{
+			reader: strings.NewReader(string(loadFile(t, "tx-case01-before.data"))),
+			writer: new(strings.Builder),
+		}
+
+		var recorded []repo.MergeRecord
+		opts := DefaultOptions()
+		opts.RecordMerges = func(_ auth.User, records []repo.MergeRecord) {
+			recorded = append(recorded, records...)
+		}
+
+		Process(client, &mockAuth{}, ra, opts)
+
+		if assert.Len(t, recorded, 1) {
+			assert.Equal(t, string(MergeByTimestamp), recorded[0].Strategy)
+			assert.NotEmpty(t, recorded[0].Steps)
+			// the server modification is the latest, so it wins the tags
+			assert.Equal(t, MergeServer, recorded[0].Attributes["tags"])
+		}
+	})
+
 	t.Run("fail if writer fails", func(t *testing.T) {
 		client := &mockClient{
 			writer:     new(strings.Builder),
@@ -184,7 +283,7 @@ func TestProcessMessage(t *testing.T) {
 			writer: new(strings.Builder),
 		}
 
-		Process(client, auth, ra)
+		Process(client, auth, ra, DefaultOptions())
 
 		assert.Equal(t, 0, len(client.writer.String()))
 	})
@@ -203,24 +302,467 @@ func TestProcessMessage(t *testing.T) {
 			writer: new(strings.Builder),
 		}
 
-		Process(client, auth, ra)
+		Process(client, auth, ra, DefaultOptions())
+
+		comparePayloads(t, string(loadPayload(t, "msg-replied-size-exceeded")), client.writer.String())
+	})
+
+	t.Run("recover if processing panics", func(t *testing.T) {
+		client := &mockClient{
+			reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+			writer: new(strings.Builder),
+		}
+
+		assert.NotPanics(t, func() {
+			Process(client, &mockAuth{}, &panicReadAppender{}, DefaultOptions())
+		})
+
+		assert.True(t, client.closed)
+		resp := parseMsg(t, client.writer.String())
+		assert.Equal(t, "500", resp.Header["code"])
+		assert.Contains(t, resp.Header["status"], "internal server error")
+	})
+
+	t.Run("advertise configured headers", func(t *testing.T) {
+		client := &mockClient{
+			reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+			writer: new(strings.Builder),
+		}
+		ra := &mockReadAppender{
+			reader: strings.NewReader(""),
+			writer: new(strings.Builder),
+		}
+
+		opts := DefaultOptions()
+		opts.Identity = "gotas 1.2.3"
+		opts.Message = "Welcome!"
+		opts.MaintenanceMessage = "Down for maintenance on Sunday"
+		Process(client, &mockAuth{}, ra, opts)
+
+		resp := parseMsg(t, client.writer.String())
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.Equal(t, "gotas 1.2.3", resp.Header["server"])
+		assert.Equal(t, ProtocolVersion, resp.Header["protocol"])
+		assert.Equal(t, "Welcome!", resp.Header["message"])
+		assert.Equal(t, "Down for maintenance on Sunday", resp.Header["info"])
+	})
+
+	t.Run("verbose diagnostics", func(t *testing.T) {
+		msg := parseMsg(t, loadPayload(t, "msg-sent-init"))
+		msg.Payload = "{not a task}\n" + msg.Payload
+
+		client := &mockClient{
+			reader: strings.NewReader(string(frame(msg.String()))),
+			writer: new(strings.Builder),
+		}
+		ra := &mockReadAppender{
+			reader: strings.NewReader(""),
+			writer: new(strings.Builder),
+		}
+
+		opts := DefaultOptions()
+		opts.Verbose = true
+		opts.MaintenanceMessage = "Down for maintenance on Sunday"
+		Process(client, &mockAuth{}, ra, opts)
+
+		resp := parseMsg(t, client.writer.String())
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.Regexp(t, `^Down for maintenance on Sunday \| Stored \d+ tasks, merged 0 tasks$`, resp.Header["info"])
+		assert.Regexp(t, `^Skipped 1 invalid lines: line 1: parsing json: `, resp.Header["error"])
+	})
+
+	t.Run("skipped lines reported without verbose", func(t *testing.T) {
+		msg := parseMsg(t, loadPayload(t, "msg-sent-init"))
+		msg.Payload = "{not a task}\n" + msg.Payload
+
+		client := &mockClient{
+			reader: strings.NewReader(string(frame(msg.String()))),
+			writer: new(strings.Builder),
+		}
+		ra := &mockReadAppender{
+			reader: strings.NewReader(""),
+			writer: new(strings.Builder),
+		}
+
+		Process(client, &mockAuth{}, ra, DefaultOptions())
+
+		resp := parseMsg(t, client.writer.String())
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.Empty(t, resp.Header["info"])
+		assert.Regexp(t, `^Skipped 1 invalid lines: line 1: parsing json: `, resp.Header["error"])
+	})
+
+	t.Run("lenient dates", func(t *testing.T) {
+		task := `{"uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8","description":"Task 1","status":"pending","entry":"2021-10-09T11:25:36Z"}`
+		for _, lenient := range []bool{false, true} {
+			msg := parseMsg(t, loadPayload(t, "msg-sent-init"))
+			msg.Payload = task + "\n"
+
+			client := &mockClient{
+				reader: strings.NewReader(string(frame(msg.String()))),
+				writer: new(strings.Builder),
+			}
+			ra := &mockReadAppender{
+				reader: strings.NewReader(""),
+				writer: new(strings.Builder),
+			}
+
+			opts := DefaultOptions()
+			opts.Verbose = true
+			opts.LenientDates = lenient
+			Process(client, &mockAuth{}, ra, opts)
+
+			resp := parseMsg(t, client.writer.String())
+			if lenient {
+				assert.Equal(t, "Stored 1 tasks, merged 0 tasks", resp.Header["info"])
+				assert.Contains(t, ra.writer.String(), `"entry":"20211009T112536Z"`)
+			} else {
+				assert.Equal(t, "Stored 0 tasks, merged 0 tasks", resp.Header["info"])
+				assert.Regexp(t, `^Skipped 1 invalid lines: line 1: parsing date in entry field`, resp.Header["error"])
+			}
+		}
+	})
+
+	t.Run("reject sync in maintenance mode", func(t *testing.T) {
+		client := &mockClient{
+			reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+			writer: new(strings.Builder),
+		}
+		ra := &mockReadAppender{
+			reader: strings.NewReader(""),
+			writer: new(strings.Builder),
+		}
+
+		opts := DefaultOptions()
+		opts.Maintenance = func() bool { return true }
+		Process(client, &mockAuth{}, ra, opts)
+
+		resp := parseMsg(t, client.writer.String())
+		assert.Equal(t, "420", resp.Header["code"])
+		assert.Equal(t, ErrorCodes[420], resp.Header["status"])
+		assert.Equal(t, "300", resp.Header[RetryAfterHeader])
+		assert.Empty(t, ra.writer.String())
+	})
+
+	t.Run("fail if size exceeds the configured limit", func(t *testing.T) {
+		client := &mockClient{
+			reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+			writer: new(strings.Builder),
+		}
+
+		auth := &mockAuth{}
+		ra := &mockReadAppender{
+			writer: new(strings.Builder),
+		}
+
+		opts := DefaultOptions()
+		opts.RequestLimit = 10
+		Process(client, auth, ra, opts)
 
 		comparePayloads(t, string(loadPayload(t, "msg-replied-size-exceeded")), client.writer.String())
 	})
 }
 
-func loadPayload(t *testing.T, path string) string {
-	t.Helper()
+func TestSyncWorkers(t *testing.T) {
+	const tasks = 50
+	const template = `{"description":"Task %d","entry":"20211009T112536Z","modified":"%s",` +
+		`"status":"pending","uuid":"e346004f-6ebb-4507-8f21-%012d"}`
 
-	data := loadFile(t, path)
-	size := uint32(len(data) + 4)
+	var before, client strings.Builder
+	for i := 0; i < tasks; i++ {
+		fmt.Fprintf(&before, template+"\n", i, "20211009T112552Z", i)
+	}
+	fmt.Fprintln(&before, "94978aad-fbaf-4876-92e0-33321f1cbab9")
+	for i := 0; i < tasks; i++ {
+		fmt.Fprintf(&before, template+"\n", i, "20211009T112600Z", i)
+	}
+	fmt.Fprintln(&before, "ee197af5-abba-4dd8-b8ea-f40df3000d5a")
 
-	buffer := make([]byte, size)
+	for i := tasks - 1; i >= 0; i-- {
+		fmt.Fprintf(&client, template+"\n", i, "20211009T112700Z", i)
+		// new tasks are stored and interleaved with the merged ones
+		fmt.Fprintf(&client, template+"\n", i, "20211009T112700Z", tasks+i)
+	}
+	fmt.Fprintln(&client, "94978aad-fbaf-4876-92e0-33321f1cbab9")
 
-	binary.BigEndian.PutUint32(buffer[:4], size)
-	copy(buffer[4:], data)
+	run := func(workers int) (Message, string) {
+		ra := &mockReadAppender{
+			reader: strings.NewReader(before.String()),
+			writer: new(strings.Builder),
+		}
+		msg := Message{
+			Header:  map[string]string{"type": "sync"},
+			Payload: client.String(),
+		}
+		return sync(msg, auth.User{}, ra, Options{SyncWorkers: workers}), ra.writer.String()
+	}
+
+	expectedMsg, expectedTx := run(1)
+	assert.Equal(t, "200", expectedMsg.Header["code"])
+	assert.Len(t, withoutKeys(expectedTx), 2*tasks)
+
+	for _, workers := range []int{0, 2, 8, 3 * tasks} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			actualMsg, actualTx := run(workers)
+
+			assert.Equal(t, expectedMsg.Header, actualMsg.Header)
+			assert.Equal(t, withoutKeys(expectedMsg.Payload), withoutKeys(actualMsg.Payload))
+			assert.Equal(t, withoutKeys(expectedTx), withoutKeys(actualTx))
+		})
+	}
+}
+
+func TestProcessEntriesRecoversPanics(t *testing.T) {
+	entries := []syncEntry{
+		{task: Task{data: map[string]string{"uuid": "1"}}},
+		{task: Task{data: map[string]string{"uuid": "2"}}},
+	}
+
+	processEntries(entries, 2, func(e *syncEntry) {
+		if e.task.Get("uuid") == "2" {
+			panic("crafted panic")
+		}
+		e.result = "ok"
+	})
+
+	assert.NoError(t, entries[0].err)
+	assert.Equal(t, "ok", entries[0].result)
+	assert.Error(t, entries[1].err)
+}
+
+func TestFindBranchPoint(t *testing.T) {
+	data := []string{
+		`{"uuid":"1d8c1bb0-5d3b-4d6e-9b1a-1a2b3c4d5e6f"}`,
+		"9d4fb7a2-3b1c-4a8e-8f2d-0e1f2a3b4c5d",
+		`{"uuid":"2b6e8d1c-7f3a-4e5b-9c0d-1a2b3c4d5e6f"}`,
+		"5c7a9e1b-2d4f-4a6b-8c0e-1f2a3b4c5d6e",
+	}
+
+	cases := []struct {
+		title    string
+		key      string
+		expected int
+	}{
+		{"first-time sync", "", 0},
+		{"zero key resets the backlog", ResetSyncKey, 0},
+		{"known key", "9d4fb7a2-3b1c-4a8e-8f2d-0e1f2a3b4c5d", 1},
+		{"last key", "5c7a9e1b-2d4f-4a6b-8c0e-1f2a3b4c5d6e", 3},
+		{"unknown key", "6e8d1c2b-7f3a-4e5b-9c0d-1a2b3c4d5e6f", -1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			assert.Equal(t, c.expected, findBranchPoint(data, c.key))
+		})
+	}
+
+	t.Run("zero key sends everything", func(t *testing.T) {
+		ra := &mockReadAppender{
+			reader: strings.NewReader(strings.Join(data, "\n")),
+			writer: new(strings.Builder),
+		}
+
+		resp := sync(Message{Payload: ResetSyncKey + "\n"}, auth.User{}, ra, DefaultOptions())
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.Equal(t, data[0]+"\n"+data[2]+"\n"+data[3]+"\n", resp.Payload)
+	})
+}
+
+func TestCondensedInit(t *testing.T) {
+	data := []string{
+		"0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d",
+		`{"description":"first","uuid":"1d8c1bb0-5d3b-4d6e-9b1a-1a2b3c4d5e6f"}`,
+		"9d4fb7a2-3b1c-4a8e-8f2d-0e1f2a3b4c5d",
+		`{"description":"other","uuid":"2b6e8d1c-7f3a-4e5b-9c0d-1a2b3c4d5e6f"}`,
+		`{"description":"second","uuid":"1d8c1bb0-5d3b-4d6e-9b1a-1a2b3c4d5e6f"}`,
+		"5c7a9e1b-2d4f-4a6b-8c0e-1f2a3b4c5d6e",
+	}
+	lastKey := data[len(data)-1]
+
+	cases := []struct {
+		title     string
+		key       string
+		condensed bool
+		expected  []string
+	}{
+		{"first-time sync sends the latest states", "", true, []string{data[4], data[3], lastKey}},
+		{"reset sends the latest states", ResetSyncKey, true, []string{data[4], data[3], lastKey}},
+		{"strict first-time sync sends the history", "", false, []string{data[1], data[3], data[4], lastKey}},
+		{"incremental sync sends the history", data[0], true, []string{data[1], data[3], data[4], lastKey}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			ra := &mockReadAppender{
+				reader: strings.NewReader(strings.Join(data, "\n")),
+				writer: new(strings.Builder),
+			}
+			opts := DefaultOptions()
+			opts.CondensedInit = c.condensed
+
+			resp := sync(Message{Payload: c.key + "\n"}, auth.User{}, ra, opts)
+			assert.Equal(t, "200", resp.Header["code"])
+			assert.Equal(t, strings.Join(c.expected, "\n")+"\n", resp.Payload)
+		})
+	}
+}
+
+// mockArchives puts back the archived task before its sync key, unless the
+// key is more recent.
+type mockArchives struct {
+	task, key string
+	keys      []string
+}
+
+func (a *mockArchives) Unarchive(user auth.User, data []string, key string) ([]string, error) {
+	a.keys = append(a.keys, key)
+	if key != "" && key != data[0] {
+		return data, nil
+	}
+
+	var unarchived []string
+	for _, line := range data {
+		if line == a.key {
+			unarchived = append(unarchived, a.task)
+		}
+		unarchived = append(unarchived, line)
+	}
+	return unarchived, nil
+}
+
+func TestSyncArchives(t *testing.T) {
+	data := []string{
+		"0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d",
+		`{"description":"first","uuid":"1d8c1bb0-5d3b-4d6e-9b1a-1a2b3c4d5e6f"}`,
+		"9d4fb7a2-3b1c-4a8e-8f2d-0e1f2a3b4c5d",
+	}
+	archived := `{"description":"archived","status":"completed","uuid":"2b6e8d1c-7f3a-4e5b-9c0d-1a2b3c4d5e6f"}`
+
+	cases := []struct {
+		title    string
+		key      string
+		expected []string
+	}{
+		{"first-time sync", "", []string{data[1], archived, data[2]}},
+		{"reset", ResetSyncKey, []string{data[1], archived, data[2]}},
+		{"old sync key", data[0], []string{data[1], archived, data[2]}},
+		{"recent sync key", data[2], []string{data[2]}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			ra := &mockReadAppender{
+				reader: strings.NewReader(strings.Join(data, "\n")),
+				writer: new(strings.Builder),
+			}
+			archives := &mockArchives{task: archived, key: data[2]}
+			opts := DefaultOptions()
+			opts.CondensedInit = false
+			opts.Archives = archives
+
+			resp := sync(Message{Payload: c.key + "\n"}, auth.User{}, ra, opts)
+			assert.Equal(t, strings.Join(c.expected, "\n")+"\n", resp.Payload)
+			expectedKey := c.key
+			if c.key == ResetSyncKey {
+				expectedKey = ""
+			}
+			assert.Equal(t, []string{expectedKey}, archives.keys)
+		})
+	}
+}
+
+func TestBusy(t *testing.T) {
+	client := &mockClient{
+		reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+		writer: new(strings.Builder),
+	}
+	opts := DefaultOptions()
+	opts.Stats = NewStats()
+
+	Busy(client, opts)
+
+	resp, err := NewMessage(client.writer.String()[4:])
+	assert.Nil(t, err)
+	assert.Equal(t, "420", resp.Header["code"])
+	assert.Equal(t, strconv.Itoa(BusyRetryAfter), resp.Header[RetryAfterHeader])
+	assert.Equal(t, 0, client.reader.Len(), "request not read")
+	assert.True(t, client.closed)
+	assert.Equal(t, map[string]uint64{"420": 1}, opts.Stats.Snapshot().Codes)
+}
+
+// peerClient is a mockClient coming from a transport.Server.
+type peerClient struct {
+	mockClient
+	peer auth.Peer
+}
+
+func (c *peerClient) Peer() auth.Peer {
+	return c.peer
+}
+
+// peerAuth only accepts the clients presenting the allowed certificate.
+type peerAuth struct {
+	mockAuth
+	allowed string
+}
+
+func (a *peerAuth) AuthenticatePeer(org, user, key string, peer auth.Peer) (auth.User, error) {
+	if peer.CommonName() != a.allowed {
+		return auth.User{}, auth.AuthenticationError{Code: "430", Msg: "certificate not allowed"}
+	}
+	return a.Authenticate(org, user, key)
+}
+
+func TestProcessPeer(t *testing.T) {
+	newClient := func(t *testing.T, cn string) *peerClient {
+		return &peerClient{
+			mockClient: mockClient{
+				reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+				writer: new(strings.Builder),
+			},
+			peer: auth.Peer{
+				Address:      "192.0.2.1:1234",
+				Certificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: cn}}},
+			},
+		}
+	}
+
+	for cn, code := range map[string]string{"laptop": "200", "phone": "430"} {
+		t.Run(cn, func(t *testing.T) {
+			client := newClient(t, cn)
+			ra := &mockReadAppender{
+				reader: strings.NewReader(string(loadFile(t, "tx-init-before.data"))),
+				writer: new(strings.Builder),
+			}
+
+			Process(client, &peerAuth{allowed: "laptop"}, ra, DefaultOptions())
+
+			resp, err := NewMessage(client.writer.String()[4:])
+			assert.Nil(t, err)
+			assert.Equal(t, code, resp.Header["code"])
+		})
+	}
+
+	t.Run("peer of a plain connection", func(t *testing.T) {
+		assert.Equal(t, auth.Peer{}, peerOf(&mockClient{}))
+		assert.Equal(t, "laptop", peerOf(newClient(t, "laptop")).CommonName())
+	})
+}
+
+func withoutKeys(data string) []string {
+	var tasks []string
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, "{") {
+			tasks = append(tasks, line)
+		}
+	}
+	return tasks
+}
+
+func loadPayload(t *testing.T, path string) string {
+	t.Helper()
 
-	return string(buffer)
+	return string(frame(string(loadFile(t, path))))
 }
 
 func loadFile(t *testing.T, path string) []byte {
@@ -0,0 +1,665 @@
+package task
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"reflect"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/szaffarano/gotas/config"
+	"github.com/szaffarano/gotas/task/repo"
+	"github.com/szaffarano/gotas/task/transport"
+)
+
+// DefaultSettingsInterval is how often the configuration is reloaded looking
+// for changes.
+const DefaultSettingsInterval = 5 * time.Second
+
+// MaxCommitWindow bounds the latency the group commit adds to the appends,
+// see "storage.commit.window".
+const MaxCommitWindow = time.Second
+
+// TrustPolicy is the client certificates verification policy.
+type TrustPolicy string
+
+// Supported trust policies, same as taskd.
+const (
+	// TrustStrict only accepts client certificates signed by the CA.
+	TrustStrict TrustPolicy = "strict"
+	// TrustAllowAll accepts any client certificate.
+	TrustAllowAll TrustPolicy = "allow all"
+)
+
+// SettingsError is a configuration validation error.
+type SettingsError struct {
+	Key string
+	Err error
+}
+
+func (e SettingsError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+// Settings is the typed and validated server configuration.
+type Settings struct {
+	Root        string
+	BindAddress string
+	QueueSize   int
+	Trust       TrustPolicy
+	Verbose     bool
+
+	CaCert     string
+	ServerCert string
+	ServerKey  string
+	ClientCert string
+	ClientKey  string
+	// HostCerts are the certificates selected by the host name requested by
+	// the clients, "server.cert.<host>" and "server.key.<host>".
+	HostCerts map[string]transport.KeyPair
+
+	// TLSMinVersion, TLSMaxVersion and TLSCiphers tune the TLS servers, zero
+	// values mean the transport defaults.
+	TLSMinVersion uint16
+	TLSMaxVersion uint16
+	TLSCiphers    []uint16
+	// TLSSessionTickets enables the session resumption, rotating the keys
+	// every TLSTicketRotation if set.
+	TLSSessionTickets bool
+	TLSTicketRotation time.Duration
+	// PingClientCert requires the client certificates to the health probes,
+	// otherwise the connections without certificate can only ping.
+	PingClientCert bool
+
+	RequestLimit       int
+	QuotaSize          int
+	LimitWarn          int
+	SyncWorkers        int
+	Identity           string
+	Message            string
+	MaintenanceMessage string
+	// SyncVerbose adds the taskd diagnostic info header to the sync responses.
+	SyncVerbose bool
+	// SyncCondensedInit sends the latest state of the tasks to the full
+	// resyncs, instead of their whole history.
+	SyncCondensedInit bool
+	// SyncLenientDates accepts the client dates not following DateLayout.
+	SyncLenientDates bool
+	// ReadOnly rejects the syncs uploading changes.
+	ReadOnly bool
+	// RequestTimeout is how long a client can take to send a request, and
+	// MinRate the minimum bytes per second it has to send.
+	RequestTimeout time.Duration
+	MinRate        int
+	// KeepAlive is how long the connections kept alive wait for the next
+	// request, zero disables them.
+	KeepAlive time.Duration
+
+	ReplicationListen   string
+	ReplicationReplicas []string
+	ReplicationPrimary  string
+	ReplicationRedirect string
+
+	FeedListen string
+	FeedSize   int
+
+	PublishURL   string
+	PublishTopic string
+	// PublishTopics are the per organization topics, "publish.topic.<org>".
+	PublishTopics map[string]string
+
+	CalendarListen string
+
+	// TunnelListen is the HTTPS address where the taskd protocol is
+	// tunneled, for clients only allowed to use HTTPS.
+	TunnelListen string
+
+	// APIListen is the HTTPS address of the REST API, see APIHandler.
+	APIListen string
+
+	// AdminSocket is the admin API unix socket path.
+	AdminSocket string
+	// AdminDebug serves the runtime profiles on the admin API.
+	AdminDebug bool
+
+	// RunUser and RunGroup are the user and group the server switches to
+	// once it's listening.
+	RunUser  string
+	RunGroup string
+	// Sandbox restricts the server filesystem access to the data directory,
+	// the certificates and the system files needed to resolve names.
+	Sandbox bool
+
+	Drift DriftPolicy
+
+	// SyncKeys is the sync keys generator, see ParseKeyGenerator.
+	SyncKeys string
+
+	// Hooks are the scripts run on the repository events.
+	Hooks repo.Hooks
+
+	// Extensions are the executables run at the extension points.
+	Extensions ServerExtensions
+
+	// Retention is how long the completed and deleted tasks are kept,
+	// enforced by the garbage collection.
+	Retention repo.Retention
+
+	// DryRunUsers are the users allowed to send dry-run syncs, "org/user".
+	DryRunUsers []string
+
+	Merge MergeStrategy
+	// OrgMerge are the per organization merge strategies, "merge.mode.<org>".
+	OrgMerge map[string]MergeStrategy
+	// MergeShadow is the strategy compared to the one merging, if any.
+	MergeShadow MergeStrategy
+	// MergeAudit is the number of merge records kept per user, 0 disables
+	// them.
+	MergeAudit int
+
+	// Schedules are the schedules of the background jobs, "schedule.<job>",
+	// see ParseSchedule.  The jobs not scheduled don't run.
+	Schedules map[string]string
+	// JobJitter is the maximum random delay added to every job run.
+	JobJitter time.Duration
+
+	// MemoryBudget bounds the memory, in bytes, held by the requests in
+	// flight, zero means no limit.
+	MemoryBudget int
+
+	// CommitWindow is how long the appends wait for the ones of concurrent
+	// syncs to be committed together, zero commits every append on its own.
+	CommitWindow time.Duration
+
+	// SMTP sends the digests of the due tasks, see JobDigest.
+	SMTP SMTPMailer
+}
+
+// NewSettings builds the settings from the raw configuration, the errors name
+// the invalid key.
+func NewSettings(cfg config.Config) (Settings, error) {
+	s := Settings{
+		Root:                cfg.Get(Root),
+		BindAddress:         cfg.Get(BindAddress),
+		CaCert:              cfg.Get(CaCert),
+		ServerCert:          cfg.Get(ServerCert),
+		ServerKey:           cfg.Get(ServerKey),
+		ClientCert:          cfg.Get(ClientCert),
+		ClientKey:           cfg.Get(ClientKey),
+		Identity:            cfg.Get(ServerIdentity),
+		Message:             cfg.Get(ServerMessage),
+		MaintenanceMessage:  cfg.Get(MaintenanceMessage),
+		ReplicationListen:   cfg.Get(ReplicationListen),
+		ReplicationReplicas: splitList(cfg.Get(ReplicationReplicas)),
+		ReplicationPrimary:  cfg.Get(ReplicationPrimary),
+		ReplicationRedirect: cfg.Get(ReplicationRedirect),
+		FeedListen:          cfg.Get(FeedListen),
+		PublishURL:          cfg.Get(PublishURL),
+		PublishTopic:        cfg.Get(PublishTopic),
+		PublishTopics:       make(map[string]string),
+		Schedules:           make(map[string]string),
+		OrgMerge:            make(map[string]MergeStrategy),
+		HostCerts:           make(map[string]transport.KeyPair),
+		DryRunUsers:         splitList(cfg.Get(DryRunUsers)),
+		CalendarListen:      cfg.Get(CalendarListen),
+		TunnelListen:        cfg.Get(TunnelListen),
+		APIListen:           cfg.Get(APIListen),
+		SyncKeys:            cfg.Get(SyncKeys),
+		SMTP: SMTPMailer{
+			Address:  cfg.Get(SMTPServer),
+			From:     cfg.Get(SMTPFrom),
+			User:     cfg.Get(SMTPUser),
+			Password: cfg.Get(SMTPPassword),
+		},
+		AdminSocket: adminSocketPath(cfg.Get(Root), cfg.Get(AdminSocket)),
+		RunUser:     cfg.Get(RunUser),
+		RunGroup:    cfg.Get(RunGroup),
+	}
+
+	for _, key := range []string{Root, BindAddress, CaCert, ServerCert, ServerKey} {
+		if cfg.Get(key) == "" {
+			return Settings{}, SettingsError{key, fmt.Errorf("required")}
+		}
+	}
+
+	for key, address := range map[string]string{
+		BindAddress:        s.BindAddress,
+		ReplicationListen:  s.ReplicationListen,
+		ReplicationPrimary: s.ReplicationPrimary,
+		FeedListen:         s.FeedListen,
+		CalendarListen:     s.CalendarListen,
+		TunnelListen:       s.TunnelListen,
+		APIListen:          s.APIListen,
+	} {
+		if address == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return Settings{}, SettingsError{key, fmt.Errorf("invalid address %q", address)}
+		}
+	}
+
+	switch trust := TrustPolicy(cfg.Get(Trust)); trust {
+	case "", TrustStrict:
+		s.Trust = TrustStrict
+	case TrustAllowAll:
+		s.Trust = trust
+	default:
+		return Settings{}, SettingsError{Trust, fmt.Errorf("either %q or %q expected, got %q", TrustStrict, TrustAllowAll, trust)}
+	}
+
+	var err error
+	if s.Verbose, _, err = cfg.LookupBool(Verbose); err != nil {
+		return Settings{}, SettingsError{Verbose, err}
+	}
+	if s.Sandbox, _, err = cfg.LookupBool(Sandbox); err != nil {
+		return Settings{}, SettingsError{Sandbox, err}
+	}
+	if s.AdminDebug, _, err = cfg.LookupBool(AdminDebug); err != nil {
+		return Settings{}, SettingsError{AdminDebug, err}
+	}
+	// read by the repository, only validated
+	if _, _, err = cfg.LookupBool(StorageDedup); err != nil {
+		return Settings{}, SettingsError{StorageDedup, err}
+	}
+	if value := cfg.Get(StorageCommitWindow); value != "" {
+		if s.CommitWindow, err = time.ParseDuration(value); err != nil || s.CommitWindow < 0 || s.CommitWindow > MaxCommitWindow {
+			return Settings{}, SettingsError{StorageCommitWindow, fmt.Errorf("duration up to %v expected, got %q", MaxCommitWindow, value)}
+		}
+	}
+
+	for key, version := range map[string]*uint16{TLSMinVersion: &s.TLSMinVersion, TLSMaxVersion: &s.TLSMaxVersion} {
+		if value := cfg.Get(key); value != "" {
+			if *version, err = transport.ParseTLSVersion(value); err != nil {
+				return Settings{}, SettingsError{key, err}
+			}
+		}
+	}
+	if s.TLSMaxVersion != 0 && s.TLSMinVersion > s.TLSMaxVersion {
+		return Settings{}, SettingsError{TLSMaxVersion, fmt.Errorf("lower than %q", TLSMinVersion)}
+	}
+	if ciphers := splitList(cfg.Get(TLSCiphers)); len(ciphers) > 0 {
+		if s.TLSMinVersion == tls.VersionTLS13 {
+			return Settings{}, SettingsError{TLSCiphers, fmt.Errorf("the TLS 1.3 cipher suites can't be configured")}
+		}
+		if s.TLSCiphers, err = transport.ParseCipherSuites(ciphers); err != nil {
+			return Settings{}, SettingsError{TLSCiphers, err}
+		}
+	}
+
+	if value := cfg.Get(KeepAliveTimeout); value != "" {
+		if s.KeepAlive, err = time.ParseDuration(value); err != nil || s.KeepAlive < time.Second {
+			return Settings{}, SettingsError{KeepAliveTimeout, fmt.Errorf("duration of at least 1s expected, got %q", value)}
+		}
+	}
+
+	s.RequestTimeout = DefaultRequestTimeout
+	if value := cfg.Get(RequestTimeout); value != "" {
+		if s.RequestTimeout, err = time.ParseDuration(value); err != nil || s.RequestTimeout < 0 {
+			return Settings{}, SettingsError{RequestTimeout, fmt.Errorf("non-negative duration expected, got %q", value)}
+		}
+	}
+	s.MinRate = DefaultMinRate
+	if value, ok, err := cfg.LookupInt(MinRate); err != nil {
+		return Settings{}, SettingsError{MinRate, err}
+	} else if ok && value < 0 {
+		return Settings{}, SettingsError{MinRate, fmt.Errorf("non-negative number expected, got %d", value)}
+	} else if ok {
+		s.MinRate = value
+	}
+
+	var hooksTimeout time.Duration
+	if value := cfg.Get(HooksTimeout); value != "" {
+		if hooksTimeout, err = time.ParseDuration(value); err != nil || hooksTimeout <= 0 {
+			return Settings{}, SettingsError{HooksTimeout, fmt.Errorf("positive duration expected, got %q", value)}
+		}
+	}
+	s.Hooks = repo.NewHooks(s.Root, cfg.Get(HooksDir), hooksTimeout)
+
+	var extensionsTimeout time.Duration
+	if value := cfg.Get(ExtensionsTimeout); value != "" {
+		if extensionsTimeout, err = time.ParseDuration(value); err != nil || extensionsTimeout <= 0 {
+			return Settings{}, SettingsError{ExtensionsTimeout, fmt.Errorf("positive duration expected, got %q", value)}
+		}
+	}
+	s.Extensions = NewExtensions(s.Root, cfg.Get(Extensions), extensionsTimeout)
+
+	for _, option := range []struct {
+		key   string
+		value *time.Duration
+	}{
+		{RetentionCompleted, &s.Retention.Completed},
+		{RetentionDeleted, &s.Retention.Deleted},
+	} {
+		days, _, err := cfg.LookupInt(option.key)
+		if err != nil {
+			return Settings{}, SettingsError{option.key, err}
+		} else if days < 0 {
+			return Settings{}, SettingsError{option.key, fmt.Errorf("number of days expected, got %d", days)}
+		}
+		*option.value = time.Duration(days) * 24 * time.Hour
+	}
+
+	if s.Retention.Archive, _, err = cfg.LookupBool(RetentionArchive); err != nil {
+		return Settings{}, SettingsError{RetentionArchive, err}
+	}
+
+	tickets, ok, err := cfg.LookupBool(TLSSessionTickets)
+	if err != nil {
+		return Settings{}, SettingsError{TLSSessionTickets, err}
+	}
+	s.TLSSessionTickets = tickets || !ok
+	pingCert, ok, err := cfg.LookupBool(PingClientCert)
+	if err != nil {
+		return Settings{}, SettingsError{PingClientCert, err}
+	}
+	s.PingClientCert = pingCert || !ok
+	if value := cfg.Get(TLSTicketRotation); value != "" {
+		if s.TLSTicketRotation, err = time.ParseDuration(value); err != nil || s.TLSTicketRotation <= 0 {
+			return Settings{}, SettingsError{TLSTicketRotation, fmt.Errorf("positive duration expected, got %q", value)}
+		}
+	}
+
+	defaults := DefaultOptions()
+	for _, option := range []struct {
+		key   string
+		value *int
+		def   int
+	}{
+		{QueueSize, &s.QueueSize, transport.DefaultQueueSize},
+		{RequestLimit, &s.RequestLimit, defaults.RequestLimit},
+		{SyncWorkers, &s.SyncWorkers, defaults.SyncWorkers},
+		{FeedSize, &s.FeedSize, DefaultFeedSize},
+		{QuotaSize, &s.QuotaSize, 0},
+		{MemoryBudgetSize, &s.MemoryBudget, 0},
+		{MergeAudit, &s.MergeAudit, 0},
+	} {
+		if *option.value, err = intOption(cfg, option.key, option.def); err != nil {
+			return Settings{}, SettingsError{option.key, err}
+		}
+	}
+
+	s.Drift.MaxHistory, err = intOption(cfg, DriftHistorySize, DefaultDriftHistorySize)
+	if err != nil {
+		return Settings{}, SettingsError{DriftHistorySize, err}
+	}
+	s.Drift.MaxFuture = DefaultDriftFuture
+	if value := cfg.Get(DriftFuture); value != "" {
+		if s.Drift.MaxFuture, err = time.ParseDuration(value); err != nil || s.Drift.MaxFuture < 0 {
+			return Settings{}, SettingsError{DriftFuture, fmt.Errorf("non-negative duration expected, got %q", value)}
+		}
+	}
+	if s.Drift.RejectFuture, _, err = cfg.LookupBool(DriftRejectFuture); err != nil {
+		return Settings{}, SettingsError{DriftRejectFuture, err}
+	}
+	if s.SyncVerbose, _, err = cfg.LookupBool(SyncVerbose); err != nil {
+		return Settings{}, SettingsError{SyncVerbose, err}
+	}
+	condensed, ok, err := cfg.LookupBool(SyncCondensedInit)
+	if err != nil {
+		return Settings{}, SettingsError{SyncCondensedInit, err}
+	}
+	s.SyncCondensedInit = condensed || !ok
+	if s.SyncLenientDates, _, err = cfg.LookupBool(SyncLenientDates); err != nil {
+		return Settings{}, SettingsError{SyncLenientDates, err}
+	}
+	if s.ReadOnly, _, err = cfg.LookupBool(ReadOnly); err != nil {
+		return Settings{}, SettingsError{ReadOnly, err}
+	}
+
+	if s.ReplicationListen != "" && len(s.ReplicationReplicas) == 0 {
+		return Settings{}, SettingsError{ReplicationReplicas, fmt.Errorf("required to enable the replication")}
+	}
+
+	s.LimitWarn = DefaultLimitWarn
+	if value, ok, err := cfg.LookupInt(LimitWarn); err != nil {
+		return Settings{}, SettingsError{LimitWarn, err}
+	} else if ok && (value < 0 || value > 100) {
+		return Settings{}, SettingsError{LimitWarn, fmt.Errorf("percentage expected, got %d", value)}
+	} else if ok {
+		s.LimitWarn = value
+	}
+
+	if _, err := ParseKeyGenerator(s.SyncKeys); err != nil {
+		return Settings{}, SettingsError{SyncKeys, err}
+	}
+
+	if s.Merge, err = ParseMergeStrategy(cfg.Get(MergeMode)); err != nil {
+		return Settings{}, SettingsError{MergeMode, err}
+	}
+	if value := cfg.Get(MergeShadow); value != "" {
+		if s.MergeShadow, err = ParseMergeStrategy(value); err != nil {
+			return Settings{}, SettingsError{MergeShadow, err}
+		}
+	}
+
+	if value := cfg.Get(JobJitter); value != "" {
+		if s.JobJitter, err = time.ParseDuration(value); err != nil || s.JobJitter < 0 {
+			return Settings{}, SettingsError{JobJitter, fmt.Errorf("non-negative duration expected, got %q", value)}
+		}
+	}
+
+	topicPrefix, mergePrefix, schedulePrefix := PublishTopic+".", MergeMode+".", JobSchedule+"."
+	certPrefix, keyPrefix := ServerCert+".", ServerKey+"."
+	for _, key := range cfg.Keys() {
+		if host := strings.TrimPrefix(key, certPrefix); host != key && host != "" {
+			pair := s.HostCerts[host]
+			pair.Cert = cfg.Get(key)
+			s.HostCerts[host] = pair
+		}
+		if host := strings.TrimPrefix(key, keyPrefix); host != key && host != "" {
+			pair := s.HostCerts[host]
+			pair.Key = cfg.Get(key)
+			s.HostCerts[host] = pair
+		}
+		if org := strings.TrimPrefix(key, topicPrefix); org != key && org != "" {
+			s.PublishTopics[org] = cfg.Get(key)
+		}
+		if org := strings.TrimPrefix(key, mergePrefix); org != key && org != "" {
+			if s.OrgMerge[org], err = ParseMergeStrategy(cfg.Get(key)); err != nil {
+				return Settings{}, SettingsError{key, err}
+			}
+		}
+		if job := strings.TrimPrefix(key, schedulePrefix); job != key && job != "" && key != JobJitter {
+			if !knownJob(job) {
+				return Settings{}, SettingsError{key, fmt.Errorf("unknown job, expected one of %v", Jobs)}
+			}
+			if spec := cfg.Get(key); spec != "" {
+				if _, err := ParseSchedule(spec); err != nil {
+					return Settings{}, SettingsError{key, err}
+				}
+				s.Schedules[job] = spec
+			}
+		}
+	}
+
+	if s.SMTP.Address != "" {
+		if _, _, err := net.SplitHostPort(s.SMTP.Address); err != nil {
+			return Settings{}, SettingsError{SMTPServer, fmt.Errorf("host:port expected, got %q", s.SMTP.Address)}
+		}
+		if _, err := mail.ParseAddress(s.SMTP.From); err != nil {
+			return Settings{}, SettingsError{SMTPFrom, fmt.Errorf("sender address required by %q: %v", SMTPServer, err)}
+		}
+	}
+	if s.Schedules[JobDigest] != "" && s.SMTP.Address == "" {
+		return Settings{}, SettingsError{SMTPServer, fmt.Errorf("required by %q", JobSchedule+"."+JobDigest)}
+	}
+
+	for host, pair := range s.HostCerts {
+		if pair.Cert == "" {
+			return Settings{}, SettingsError{certPrefix + host, fmt.Errorf("required by %q", keyPrefix+host)}
+		}
+		if pair.Key == "" {
+			return Settings{}, SettingsError{keyPrefix + host, fmt.Errorf("required by %q", certPrefix+host)}
+		}
+	}
+
+	return s, nil
+}
+
+// Topic returns the topic where the org sync events are published,
+// "publish.topic.<org>" if configured, otherwise "publish.topic", where
+// "{org}" is replaced by the organization name.
+func (s Settings) Topic(org string) string {
+	if topic := s.PublishTopics[org]; topic != "" {
+		return topic
+	}
+
+	topic := s.PublishTopic
+	if topic == "" {
+		topic = "gotas/{org}"
+	}
+	return strings.ReplaceAll(topic, "{org}", org)
+}
+
+// apply returns the options updated with the settings that can be changed
+// while the server is running.
+func (s Settings) apply(opts Options) Options {
+	opts.RequestLimit = s.RequestLimit
+	opts.Quota = s.QuotaSize
+	opts.LimitWarn = s.LimitWarn
+	opts.SyncWorkers = s.SyncWorkers
+	opts.Message = s.Message
+	opts.MaintenanceMessage = s.MaintenanceMessage
+	opts.Verbose = s.SyncVerbose
+	opts.CondensedInit = s.SyncCondensedInit
+	opts.LenientDates = s.SyncLenientDates
+	opts.ReadOnly = s.ReadOnly
+	opts.KeepAlive = s.KeepAlive
+	opts.RequestTimeout = s.RequestTimeout
+	opts.MinRate = s.MinRate
+	opts.DriftPolicy = s.Drift
+	opts.Merge = s.Merge
+	opts.DryRunUsers = s.DryRunUsers
+	opts.OrgMerge = s.OrgMerge
+	opts.MergeShadow = s.MergeShadow
+	opts.Extensions = s.Extensions
+	if s.Identity != "" {
+		opts.Identity = s.Identity
+	}
+	return opts
+}
+
+// restartRequired returns the name of the settings changed that are only
+// applied when the server starts.
+func restartRequired(old, new Settings) []string {
+	// clear the settings applied on the fly
+	for _, s := range []*Settings{&old, &new} {
+		s.RequestLimit, s.SyncWorkers = 0, 0
+		s.QuotaSize, s.LimitWarn = 0, 0
+		s.Identity, s.Message, s.MaintenanceMessage = "", "", ""
+		s.Verbose, s.SyncVerbose = false, false
+		s.SyncCondensedInit, s.SyncLenientDates = false, false
+		s.ReadOnly = false
+		s.KeepAlive = 0
+		s.RequestTimeout, s.MinRate = 0, 0
+		s.PublishTopic = ""
+		s.PublishTopics = nil
+		s.Drift = DriftPolicy{}
+		s.Merge, s.OrgMerge, s.MergeShadow = "", nil, ""
+		s.DryRunUsers = nil
+		s.Extensions = ServerExtensions{}
+	}
+
+	var changed []string
+	oldValue, newValue := reflect.ValueOf(old), reflect.ValueOf(new)
+	for i := 0; i < oldValue.NumField(); i++ {
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			changed = append(changed, oldValue.Type().Field(i).Name)
+		}
+	}
+	return changed
+}
+
+// SettingsWatcher reloads the configuration looking for changes, and notifies
+// them to the subscribed subsystems.
+type SettingsWatcher struct {
+	load func() (config.Config, error)
+
+	mu          gosync.Mutex
+	current     Settings
+	subscribers []func(old, new Settings)
+}
+
+// NewSettingsWatcher loads the initial settings using load, which is called
+// again on every check.
+func NewSettingsWatcher(load func() (config.Config, error)) (*SettingsWatcher, error) {
+	w := &SettingsWatcher{load: load}
+
+	settings, err := w.read()
+	if err != nil {
+		return nil, err
+	}
+	w.current = settings
+
+	return w, nil
+}
+
+func (w *SettingsWatcher) read() (Settings, error) {
+	cfg, err := w.load()
+	if err != nil {
+		return Settings{}, err
+	}
+	return NewSettings(cfg)
+}
+
+// Current returns the last valid settings.
+func (w *SettingsWatcher) Current() Settings {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.current
+}
+
+// Subscribe registers a function called every time the settings change.
+func (w *SettingsWatcher) Subscribe(f func(old, new Settings)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.subscribers = append(w.subscribers, f)
+}
+
+// Check reloads the configuration and notifies the subscribers if it
+// changed.  An invalid configuration is ignored, keeping the current one.
+func (w *SettingsWatcher) Check() error {
+	settings, err := w.read()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	old := w.current
+	if reflect.DeepEqual(old, settings) {
+		w.mu.Unlock()
+		return nil
+	}
+	w.current = settings
+	subscribers := append([]func(old, new Settings){}, w.subscribers...)
+	w.mu.Unlock()
+
+	for _, f := range subscribers {
+		f(old, settings)
+	}
+
+	return nil
+}
+
+// Watch checks the configuration every interval until quit is closed.
+func (w *SettingsWatcher) Watch(interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			if err := w.Check(); err != nil {
+				log.Errorf("Ignoring invalid configuration: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package task
+
+import (
+	"crypto/tls"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/config"
+	"github.com/szaffarano/gotas/task/transport"
+)
+
+func TestNewSettings(t *testing.T) {
+	newConfig := func(t *testing.T, values map[string]string) config.Config {
+		t.Helper()
+		cfg, err := config.New(filepath.Join(t.TempDir(), ConfigFile))
+		assert.Nil(t, err)
+
+		for k, v := range map[string]string{
+			Root:        "/data",
+			BindAddress: "localhost:53589",
+			CaCert:      "ca.cert.pem",
+			ServerCert:  "server.cert.pem",
+			ServerKey:   "server.key.pem",
+		} {
+			cfg.Set(k, v)
+		}
+		for k, v := range values {
+			cfg.Set(k, v)
+		}
+		return cfg
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		s, err := NewSettings(newConfig(t, nil))
+		assert.Nil(t, err)
+
+		assert.Equal(t, TrustStrict, s.Trust)
+		assert.Equal(t, DefaultOptions().RequestLimit, s.RequestLimit)
+		assert.Equal(t, DefaultFeedSize, s.FeedSize)
+		assert.False(t, s.Verbose)
+		assert.True(t, s.TLSSessionTickets)
+		assert.True(t, s.PingClientCert)
+		assert.True(t, s.SyncCondensedInit)
+		assert.Equal(t, DefaultRequestTimeout, s.RequestTimeout)
+		assert.Equal(t, DefaultMinRate, s.MinRate)
+	})
+
+	t.Run("typed values", func(t *testing.T) {
+		s, err := NewSettings(newConfig(t, map[string]string{
+			Trust:                             "allow all",
+			RequestLimit:                      "1024",
+			Verbose:                           "true",
+			ReplicationListen:                 "localhost:53590",
+			ReplicationReplicas:               "replica1, replica2",
+			DriftFuture:                       "1h",
+			DriftRejectFuture:                 "true",
+			MergeMode + ".Public":             "receipt",
+			MergeShadow:                       "receipt",
+			TLSMaxVersion:                     "1.2",
+			TLSCiphers:                        "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+			ServerCert + ".tasks.example.com": "tasks.cert.pem",
+			ServerKey + ".tasks.example.com":  "tasks.key.pem",
+		}))
+		assert.Nil(t, err)
+
+		assert.Equal(t, TrustAllowAll, s.Trust)
+		assert.Equal(t, 1024, s.RequestLimit)
+		assert.True(t, s.Verbose)
+		assert.Equal(t, []string{"replica1", "replica2"}, s.ReplicationReplicas)
+		assert.Equal(t, MergeByTimestamp, s.Merge)
+		assert.Equal(t, map[string]MergeStrategy{"Public": MergeByReceipt}, s.OrgMerge)
+		assert.Equal(t, MergeByReceipt, s.MergeShadow)
+		assert.Equal(t, map[string]transport.KeyPair{"tasks.example.com": {Cert: "tasks.cert.pem", Key: "tasks.key.pem"}}, s.HostCerts)
+		assert.Equal(t, uint16(tls.VersionTLS12), s.TLSMaxVersion)
+		assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, s.TLSCiphers)
+		assert.Equal(t, DriftPolicy{MaxHistory: DefaultDriftHistorySize, MaxFuture: time.Hour, RejectFuture: true}, s.Drift)
+	})
+
+	cases := []struct {
+		name   string
+		values map[string]string
+		key    string
+	}{
+		{"missing root", map[string]string{Root: ""}, Root},
+		{"missing certificate", map[string]string{ServerCert: ""}, ServerCert},
+		{"invalid address", map[string]string{FeedListen: "localhost"}, FeedListen},
+		{"invalid tunnel address", map[string]string{TunnelListen: "localhost"}, TunnelListen},
+		{"invalid api address", map[string]string{APIListen: "localhost"}, APIListen},
+		{"invalid trust", map[string]string{Trust: "nobody"}, Trust},
+		{"invalid number", map[string]string{RequestLimit: "a lot"}, RequestLimit},
+		{"negative number", map[string]string{SyncWorkers: "-1"}, SyncWorkers},
+		{"invalid bool", map[string]string{Verbose: "maybe"}, Verbose},
+		{"invalid drift duration", map[string]string{DriftFuture: "tomorrow"}, DriftFuture},
+		{"negative drift duration", map[string]string{DriftFuture: "-1h"}, DriftFuture},
+		{"invalid merge mode", map[string]string{MergeMode: "random"}, MergeMode},
+		{"invalid org merge mode", map[string]string{MergeMode + ".Public": "random"}, MergeMode + ".Public"},
+		{"invalid merge shadow", map[string]string{MergeShadow: "random"}, MergeShadow},
+		{"invalid limit warning", map[string]string{LimitWarn: "120"}, LimitWarn},
+		{"invalid quota", map[string]string{QuotaSize: "0"}, QuotaSize},
+		{"invalid sandbox", map[string]string{Sandbox: "maybe"}, Sandbox},
+		{"invalid dedup", map[string]string{StorageDedup: "maybe"}, StorageDedup},
+		{"invalid admin debug", map[string]string{AdminDebug: "maybe"}, AdminDebug},
+		{"invalid memory budget", map[string]string{MemoryBudgetSize: "0"}, MemoryBudgetSize},
+		{"invalid commit window", map[string]string{StorageCommitWindow: "-1ms"}, StorageCommitWindow},
+		{"commit window too long", map[string]string{StorageCommitWindow: "1m"}, StorageCommitWindow},
+		{"invalid sync verbose", map[string]string{SyncVerbose: "maybe"}, SyncVerbose},
+		{"invalid condensed init", map[string]string{SyncCondensedInit: "maybe"}, SyncCondensedInit},
+		{"invalid lenient dates", map[string]string{SyncLenientDates: "maybe"}, SyncLenientDates},
+		{"invalid read-only", map[string]string{ReadOnly: "maybe"}, ReadOnly},
+		{"invalid keep-alive timeout", map[string]string{KeepAliveTimeout: "10ms"}, KeepAliveTimeout},
+		{"invalid request timeout", map[string]string{RequestTimeout: "-1s"}, RequestTimeout},
+		{"invalid minimum rate", map[string]string{MinRate: "-1"}, MinRate},
+		{"invalid tls version", map[string]string{TLSMinVersion: "1.1"}, TLSMinVersion},
+		{"inverted tls versions", map[string]string{TLSMinVersion: "1.3", TLSMaxVersion: "1.2"}, TLSMaxVersion},
+		{"unknown cipher", map[string]string{TLSCiphers: "TLS_NULL"}, TLSCiphers},
+		{"insecure cipher", map[string]string{TLSCiphers: "TLS_RSA_WITH_RC4_128_SHA"}, TLSCiphers},
+		{"ciphers in tls 1.3 only mode", map[string]string{TLSMinVersion: "1.3", TLSCiphers: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, TLSCiphers},
+		{"invalid session tickets", map[string]string{TLSSessionTickets: "maybe"}, TLSSessionTickets},
+		{"invalid ping client certificates", map[string]string{PingClientCert: "maybe"}, PingClientCert},
+		{"invalid ticket rotation", map[string]string{TLSTicketRotation: "0s"}, TLSTicketRotation},
+		{"host certificate without key", map[string]string{ServerCert + ".example.com": "cert.pem"}, ServerKey + ".example.com"},
+		{"invalid sync keys", map[string]string{SyncKeys: "uuid"}, SyncKeys},
+		{"invalid hooks timeout", map[string]string{HooksTimeout: "-1s"}, HooksTimeout},
+		{"invalid extensions timeout", map[string]string{ExtensionsTimeout: "soon"}, ExtensionsTimeout},
+		{"invalid completed retention", map[string]string{RetentionCompleted: "30d"}, RetentionCompleted},
+		{"invalid deleted retention", map[string]string{RetentionDeleted: "-1"}, RetentionDeleted},
+		{"invalid retention archive", map[string]string{RetentionArchive: "maybe"}, RetentionArchive},
+		{"missing replicas", map[string]string{ReplicationListen: "localhost:53590"}, ReplicationReplicas},
+		{"unknown job", map[string]string{JobSchedule + ".backup": "@daily"}, JobSchedule + ".backup"},
+		{"invalid job schedule", map[string]string{JobSchedule + "." + JobGC: "daily"}, JobSchedule + "." + JobGC},
+		{"invalid job jitter", map[string]string{JobJitter: "-1m"}, JobJitter},
+		{"invalid smtp server", map[string]string{SMTPServer: "smtp.example.com", SMTPFrom: "gotas@example.com"}, SMTPServer},
+		{"missing smtp sender", map[string]string{SMTPServer: "smtp.example.com:587"}, SMTPFrom},
+		{"digest without smtp", map[string]string{JobSchedule + "." + JobDigest: "@daily"}, SMTPServer},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewSettings(newConfig(t, c.values))
+
+			var settingsErr SettingsError
+			assert.True(t, errors.As(err, &settingsErr))
+			assert.Equal(t, c.key, settingsErr.Key)
+		})
+	}
+
+	t.Run("topics", func(t *testing.T) {
+		s, err := NewSettings(newConfig(t, map[string]string{
+			PublishTopic:            "tasks/{org}",
+			PublishTopic + ".Admin": "admin",
+		}))
+		assert.Nil(t, err)
+
+		assert.Equal(t, "tasks/Public", s.Topic("Public"))
+		assert.Equal(t, "admin", s.Topic("Admin"))
+		assert.Equal(t, "gotas/Public", Settings{}.Topic("Public"))
+	})
+}
+
+func TestSettingsWatcher(t *testing.T) {
+	cfg, err := config.New(filepath.Join(t.TempDir(), ConfigFile))
+	assert.Nil(t, err)
+	cfg.Set(Root, "/data")
+	cfg.Set(BindAddress, "localhost:53589")
+	cfg.Set(CaCert, "ca.cert.pem")
+	cfg.Set(ServerCert, "server.cert.pem")
+	cfg.Set(ServerKey, "server.key.pem")
+
+	load := func() (config.Config, error) {
+		return cfg, nil
+	}
+
+	watcher, err := NewSettingsWatcher(load)
+	assert.Nil(t, err)
+
+	var notified []Settings
+	watcher.Subscribe(func(old, new Settings) {
+		notified = append(notified, old, new)
+	})
+
+	t.Run("unchanged settings are not notified", func(t *testing.T) {
+		assert.Nil(t, watcher.Check())
+		assert.Len(t, notified, 0)
+	})
+
+	t.Run("changes are notified", func(t *testing.T) {
+		cfg.Set(RequestLimit, "1024")
+		assert.Nil(t, watcher.Check())
+
+		assert.Len(t, notified, 2)
+		assert.Equal(t, DefaultOptions().RequestLimit, notified[0].RequestLimit)
+		assert.Equal(t, 1024, notified[1].RequestLimit)
+		assert.Equal(t, 1024, watcher.Current().RequestLimit)
+		assert.Empty(t, restartRequired(notified[0], notified[1]))
+	})
+
+	t.Run("invalid changes are ignored", func(t *testing.T) {
+		notified = nil
+		cfg.Set(RequestLimit, "a lot")
+
+		assert.NotNil(t, watcher.Check())
+		assert.Len(t, notified, 0)
+		assert.Equal(t, 1024, watcher.Current().RequestLimit)
+	})
+
+	t.Run("restart required", func(t *testing.T) {
+		notified = nil
+		cfg.Set(RequestLimit, "1024")
+		cfg.Set(BindAddress, "0.0.0.0:53589")
+
+		assert.Nil(t, watcher.Check())
+		assert.Len(t, notified, 2)
+		assert.Equal(t, []string{"BindAddress"}, restartRequired(notified[0], notified[1]))
+	})
+}
+
+func TestDropPrivileges(t *testing.T) {
+	t.Run("nothing configured", func(t *testing.T) {
+		assert.Nil(t, dropPrivileges("", ""))
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		err := dropPrivileges("gotas-unknown-user", "")
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), RunUser)
+	})
+}
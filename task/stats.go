@@ -0,0 +1,334 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	gosync "sync"
+	"time"
+
+	"github.com/szaffarano/gotas/task/transport"
+)
+
+// statsSamples is the number of latencies kept to calculate the percentiles.
+const statsSamples = 1024
+
+// Stats collects the requests statistics since the server started.  The
+// durations are measured with the monotonic clock, so they're not affected by
+// the wall clock adjustments.
+type Stats struct {
+	mu       gosync.Mutex
+	start    time.Time
+	requests uint64
+	codes    map[string]uint64
+	total    time.Duration
+	samples  []time.Duration
+	next     int
+
+	queue  func() transport.QueueStats
+	jobs   func() []JobStats
+	memory func() MemoryStats
+
+	handshakes     uint64
+	handshakeTotal time.Duration
+	resumed        uint64
+	resumedTotal   time.Duration
+
+	// totals survive the restarts, saved in totalsPath if set
+	totals     StatsTotals
+	totalsPath string
+}
+
+// StatsFile is the file, in the data directory, keeping the statistics
+// totals across restarts.
+const StatsFile = "stats.json"
+
+// StatsSaveInterval is how often the statistics totals are saved.
+const StatsSaveInterval = time.Minute
+
+// StatsTotals are the syncs counted since Since, kept across restarts until
+// they're reset.  The bytes are the requests received and the responses
+// sent.
+type StatsTotals struct {
+	Since    time.Time              `json:"since"`
+	Syncs    uint64                 `json:"syncs"`
+	BytesIn  uint64                 `json:"bytes_in"`
+	BytesOut uint64                 `json:"bytes_out"`
+	Orgs     map[string]OrgActivity `json:"orgs,omitempty"`
+}
+
+// OrgActivity are the syncs of the users of an organization.
+type OrgActivity struct {
+	Syncs    uint64    `json:"syncs"`
+	BytesIn  uint64    `json:"bytes_in"`
+	BytesOut uint64    `json:"bytes_out"`
+	LastSync time.Time `json:"last_sync"`
+}
+
+func newStatsTotals() StatsTotals {
+	return StatsTotals{Since: time.Now().UTC(), Orgs: make(map[string]OrgActivity)}
+}
+
+// copy returns a deep copy of the totals.
+func (t StatsTotals) copy() StatsTotals {
+	orgs := make(map[string]OrgActivity, len(t.Orgs))
+	for name, activity := range t.Orgs {
+		orgs[name] = activity
+	}
+	t.Orgs = orgs
+	return t
+}
+
+// StatsSnapshot are the statistics at a given moment.  The percentiles are
+// calculated over the last requests.
+type StatsSnapshot struct {
+	Start      time.Time         `json:"start"`
+	Uptime     time.Duration     `json:"uptime"`
+	Requests   uint64            `json:"requests"`
+	Codes      map[string]uint64 `json:"codes"`
+	AvgLatency time.Duration     `json:"avg_latency"`
+	P95Latency time.Duration     `json:"p95_latency"`
+	P99Latency time.Duration     `json:"p99_latency"`
+
+	// Handshakes are the full tls handshakes and Resumed the ones resuming a
+	// previous session, with their average durations.
+	Handshakes          uint64        `json:"handshakes"`
+	AvgHandshake        time.Duration `json:"avg_handshake"`
+	Resumed             uint64        `json:"resumed"`
+	AvgResumedHandshake time.Duration `json:"avg_resumed_handshake"`
+
+	Queue transport.QueueStats `json:"queue"`
+
+	// Memory is the memory budget usage, if there is a budget.
+	Memory *MemoryStats `json:"memory,omitempty"`
+
+	Jobs []JobStats `json:"jobs,omitempty"`
+
+	// Totals are the syncs counted across restarts.
+	Totals StatsTotals `json:"totals"`
+}
+
+// NewStats creates the statistics, starting the uptime count.
+func NewStats() *Stats {
+	return &Stats{
+		start:   time.Now(),
+		codes:   make(map[string]uint64),
+		samples: make([]time.Duration, 0, statsSamples),
+		totals:  newStatsTotals(),
+	}
+}
+
+// persist keeps the totals in the given file, restoring the ones saved by
+// the previous run, if any.
+func (s *Stats) persist(path string) error {
+	totals := newStatsTotals()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("loading statistics: %v", err)
+	} else if err == nil {
+		if err := json.Unmarshal(data, &totals); err != nil {
+			return fmt.Errorf("loading statistics %v: %v", path, err)
+		}
+		if totals.Orgs == nil {
+			totals.Orgs = make(map[string]OrgActivity)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.totals, s.totalsPath = totals, path
+	return nil
+}
+
+// save writes the totals to the file set with persist, if any.
+func (s *Stats) save() error {
+	s.mu.Lock()
+	path := s.totalsPath
+	data, err := json.Marshal(s.totals)
+	s.mu.Unlock()
+
+	if path == "" {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("saving statistics: %v", err)
+	}
+
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0600); err != nil {
+		return fmt.Errorf("saving statistics: %v", err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		return fmt.Errorf("saving statistics: %v", err)
+	}
+	return nil
+}
+
+// resetTotals starts counting the totals again, saving them right away.
+func (s *Stats) resetTotals() error {
+	s.mu.Lock()
+	s.totals = newStatsTotals()
+	s.mu.Unlock()
+
+	return s.save()
+}
+
+// saveStats saves the totals every interval until quit is closed.
+func saveStats(s *Stats, interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.save(); err != nil {
+				log.Errorf("%v", err)
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// recordSync counts a successful sync of the given organization, with the
+// request and response sizes.  Nil stats are ignored.
+func (s *Stats) recordSync(org string, in, out int) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.totals.Syncs++
+	s.totals.BytesIn += uint64(in)
+	s.totals.BytesOut += uint64(out)
+
+	activity := s.totals.Orgs[org]
+	activity.Syncs++
+	activity.BytesIn += uint64(in)
+	activity.BytesOut += uint64(out)
+	activity.LastSync = time.Now().UTC()
+	s.totals.Orgs[org] = activity
+}
+
+// record counts a request replied with the given code.  Nil stats are
+// ignored.
+func (s *Stats) record(code string, latency time.Duration) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	s.codes[code]++
+	s.total += latency
+
+	if len(s.samples) < statsSamples {
+		s.samples = append(s.samples, latency)
+	} else {
+		s.samples[s.next] = latency
+	}
+	s.next = (s.next + 1) % statsSamples
+}
+
+// watchQueue includes the state of the connections queue in the snapshots.
+func (s *Stats) watchQueue(queue func() transport.QueueStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queue = queue
+}
+
+// watchJobs includes the scheduled jobs statistics in the snapshots.
+func (s *Stats) watchJobs(jobs func() []JobStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs = jobs
+}
+
+// watchMemory includes the memory budget usage in the snapshots.
+func (s *Stats) watchMemory(memory func() MemoryStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.memory = memory
+}
+
+// recordHandshake counts a tls handshake.  Nil stats are ignored.
+func (s *Stats) recordHandshake(duration time.Duration, resumed bool) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if resumed {
+		s.resumed++
+		s.resumedTotal += duration
+	} else {
+		s.handshakes++
+		s.handshakeTotal += duration
+	}
+}
+
+// Snapshot returns the current statistics.
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := StatsSnapshot{
+		Start:    s.start,
+		Uptime:   time.Since(s.start),
+		Requests: s.requests,
+		Codes:    make(map[string]uint64, len(s.codes)),
+		Totals:   s.totals.copy(),
+	}
+	for k, v := range s.codes {
+		snapshot.Codes[k] = v
+	}
+
+	if s.requests > 0 {
+		snapshot.AvgLatency = s.total / time.Duration(s.requests)
+	}
+
+	snapshot.Handshakes, snapshot.Resumed = s.handshakes, s.resumed
+	if s.handshakes > 0 {
+		snapshot.AvgHandshake = s.handshakeTotal / time.Duration(s.handshakes)
+	}
+	if s.resumed > 0 {
+		snapshot.AvgResumedHandshake = s.resumedTotal / time.Duration(s.resumed)
+	}
+
+	if s.queue != nil {
+		snapshot.Queue = s.queue()
+	}
+	if s.jobs != nil {
+		snapshot.Jobs = s.jobs()
+	}
+	if s.memory != nil {
+		memory := s.memory()
+		snapshot.Memory = &memory
+	}
+
+	sorted := append([]time.Duration(nil), s.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	snapshot.P95Latency = percentile(sorted, 95)
+	snapshot.P99Latency = percentile(sorted, 99)
+
+	return snapshot
+}
+
+// percentile returns the nearest-rank percentile of the sorted samples.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[rank-1]
+}
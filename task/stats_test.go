@@ -0,0 +1,221 @@
+package task
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	t.Run("percentiles", func(t *testing.T) {
+		stats := NewStats()
+		for i := 1; i <= 100; i++ {
+			stats.record("200", time.Duration(i)*time.Millisecond)
+		}
+
+		snapshot := stats.Snapshot()
+		assert.Equal(t, uint64(100), snapshot.Requests)
+		assert.Equal(t, map[string]uint64{"200": 100}, snapshot.Codes)
+		assert.Equal(t, 50500*time.Microsecond, snapshot.AvgLatency)
+		assert.Equal(t, 95*time.Millisecond, snapshot.P95Latency)
+		assert.Equal(t, 99*time.Millisecond, snapshot.P99Latency)
+		assert.True(t, snapshot.Uptime > 0)
+	})
+
+	t.Run("percentiles over the last requests", func(t *testing.T) {
+		stats := NewStats()
+		for i := 0; i < statsSamples; i++ {
+			stats.record("200", time.Second)
+		}
+		for i := 0; i < statsSamples; i++ {
+			stats.record("200", time.Millisecond)
+		}
+
+		snapshot := stats.Snapshot()
+		assert.Equal(t, uint64(2*statsSamples), snapshot.Requests)
+		assert.Equal(t, time.Millisecond, snapshot.P99Latency)
+	})
+
+	t.Run("handshakes", func(t *testing.T) {
+		stats := NewStats()
+		stats.recordHandshake(4*time.Millisecond, false)
+		stats.recordHandshake(2*time.Millisecond, false)
+		stats.recordHandshake(time.Millisecond, true)
+
+		snapshot := stats.Snapshot()
+		assert.Equal(t, uint64(2), snapshot.Handshakes)
+		assert.Equal(t, 3*time.Millisecond, snapshot.AvgHandshake)
+		assert.Equal(t, uint64(1), snapshot.Resumed)
+		assert.Equal(t, time.Millisecond, snapshot.AvgResumedHandshake)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		snapshot := NewStats().Snapshot()
+		assert.Equal(t, uint64(0), snapshot.Requests)
+		assert.Equal(t, time.Duration(0), snapshot.P95Latency)
+	})
+
+	t.Run("requests are recorded", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Stats = NewStats()
+
+		for _, fails := range []bool{false, true} {
+			client := &mockClient{
+				reader: strings.NewReader(loadPayload(t, "msg-sent-init")),
+				writer: new(strings.Builder),
+			}
+			ra := &mockReadAppender{
+				reader: strings.NewReader(string(loadFile(t, "tx-init-before.data"))),
+				writer: new(strings.Builder),
+			}
+			Process(client, &mockAuth{fails: fails}, ra, opts)
+		}
+
+		snapshot := opts.Stats.Snapshot()
+		assert.Equal(t, uint64(2), snapshot.Requests)
+		assert.Equal(t, map[string]uint64{"200": 1, "400": 1}, snapshot.Codes)
+
+		totals := snapshot.Totals
+		assert.Equal(t, uint64(1), totals.Syncs)
+		assert.Equal(t, uint64(len(loadPayload(t, "msg-sent-init"))), totals.BytesIn)
+		assert.NotZero(t, totals.BytesOut)
+		if assert.Contains(t, totals.Orgs, "Public") {
+			assert.Equal(t, uint64(1), totals.Orgs["Public"].Syncs)
+			assert.Equal(t, totals.BytesOut, totals.Orgs["Public"].BytesOut)
+		}
+	})
+
+	t.Run("totals survive the restarts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), StatsFile)
+
+		stats := NewStats()
+		assert.Nil(t, stats.persist(path))
+		stats.recordSync("Public", 100, 200)
+		stats.recordSync("Private", 10, 20)
+		assert.Nil(t, stats.save())
+		since := stats.Snapshot().Totals.Since
+
+		restarted := NewStats()
+		assert.Nil(t, restarted.persist(path))
+		totals := restarted.Snapshot().Totals
+		assert.True(t, since.Equal(totals.Since))
+		assert.Equal(t, uint64(2), totals.Syncs)
+		assert.Equal(t, uint64(110), totals.BytesIn)
+		assert.Equal(t, uint64(220), totals.BytesOut)
+		assert.Equal(t, uint64(200), totals.Orgs["Public"].BytesOut)
+
+		assert.Nil(t, restarted.resetTotals())
+		reset := NewStats()
+		assert.Nil(t, reset.persist(path))
+		assert.Equal(t, uint64(0), reset.Snapshot().Totals.Syncs)
+		assert.Empty(t, reset.Snapshot().Totals.Orgs)
+	})
+
+	t.Run("invalid totals file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), StatsFile)
+		assert.Nil(t, os.WriteFile(path, []byte("{invalid"), 0600))
+
+		assert.Error(t, NewStats().persist(path))
+	})
+}
+
+func TestAdminSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultAdminSocket)
+
+	stats := NewStats()
+	stats.record("200", time.Millisecond)
+
+	listener, err := ListenAdmin(path)
+	assert.Nil(t, err)
+	server := &http.Server{Handler: AdminHandler(stats, false)}
+	go server.Serve(listener)
+	defer server.Close()
+
+	snapshot, err := QueryStats(path)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), snapshot.Requests)
+	assert.Equal(t, time.Millisecond, snapshot.AvgLatency)
+
+	t.Run("stale socket is replaced", func(t *testing.T) {
+		server.Close()
+
+		listener, err := ListenAdmin(path)
+		assert.Nil(t, err)
+		server := &http.Server{Handler: AdminHandler(NewStats(), false)}
+		go server.Serve(listener)
+		defer server.Close()
+
+		snapshot, err := QueryStats(path)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(0), snapshot.Requests)
+	})
+
+	t.Run("profiles", func(t *testing.T) {
+		serve := func(debug bool) *http.Server {
+			listener, err := ListenAdmin(path)
+			assert.Nil(t, err)
+			server := &http.Server{Handler: AdminHandler(NewStats(), debug)}
+			go server.Serve(listener)
+			return server
+		}
+
+		var profile bytes.Buffer
+		server := serve(false)
+		err := QueryProfile(path, "heap", 0, &profile)
+		assert.Contains(t, fmt.Sprint(err), "profiling disabled")
+		server.Close()
+
+		server = serve(true)
+		defer server.Close()
+		defer runtime.SetBlockProfileRate(0)
+		defer runtime.SetMutexProfileFraction(0)
+
+		assert.Equal(t, mutexProfileFraction, runtime.SetMutexProfileFraction(-1))
+		assert.Nil(t, QueryProfile(path, "block", 0, &profile))
+
+		assert.Nil(t, QueryProfile(path, "heap", 0, &profile))
+		assert.NotZero(t, profile.Len())
+		assert.Error(t, QueryProfile(path, "cpu", 0, &profile))
+		assert.Error(t, QueryProfile(path, "threads", 0, &profile))
+
+		resp, err := adminClient(path).Get("http://gotas/debug/vars")
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		root := t.TempDir()
+		stats := NewStats()
+		assert.Nil(t, stats.persist(filepath.Join(root, StatsFile)))
+		stats.recordSync("Public", 100, 200)
+
+		listener, err := ListenAdmin(path)
+		assert.Nil(t, err)
+		server := &http.Server{Handler: AdminHandler(stats, false)}
+		go server.Serve(listener)
+
+		assert.Nil(t, ResetStats(root, path))
+		assert.Equal(t, uint64(0), stats.Snapshot().Totals.Syncs)
+		assert.FileExists(t, filepath.Join(root, StatsFile))
+
+		// without the server the saved totals are removed
+		server.Close()
+		assert.Nil(t, ResetStats(root, path))
+		assert.NoFileExists(t, filepath.Join(root, StatsFile))
+	})
+
+	t.Run("socket path", func(t *testing.T) {
+		assert.Equal(t, filepath.Join("/data", DefaultAdminSocket), adminSocketPath("/data", ""))
+		assert.Equal(t, "/data/other.sock", adminSocketPath("/data", "other.sock"))
+		assert.Equal(t, "/run/gotas.sock", adminSocketPath("/data", "/run/gotas.sock"))
+	})
+}
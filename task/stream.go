@@ -0,0 +1,81 @@
+package task
+
+import (
+	"io"
+	"strings"
+)
+
+// streamThreshold is the size in bytes above which the sync responses are
+// streamed to the client instead of generated in memory.
+var streamThreshold = 1024 * 1024
+
+// taskStream is a sync response payload: the server tasks, the merged ones
+// and the sync key.  The tasks are composed as the payload is written, so a
+// large payload, e.g. the first sync of a big account, isn't kept in memory.
+type taskStream struct {
+	tasks     []Task
+	additions []string
+	key       string
+	size      int
+}
+
+// newTaskStream returns the payload of the given tasks, composing them once
+// to compute its size.
+func newTaskStream(tasks []Task, additions []string, key string) (*taskStream, error) {
+	stream := &taskStream{tasks: tasks, additions: additions, key: key}
+
+	size, err := stream.WriteTo(io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	stream.size = int(size)
+
+	return stream, nil
+}
+
+// Size returns the payload size in bytes.
+func (s *taskStream) Size() int {
+	return s.size
+}
+
+// WriteTo writes the payload, one line per task followed by the sync key.
+func (s *taskStream) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	writeLine := func(line string) error {
+		n, err := io.WriteString(w, line)
+		written += int64(n)
+		if err != nil {
+			return err
+		}
+		n, err = io.WriteString(w, "\n")
+		written += int64(n)
+		return err
+	}
+
+	for _, t := range s.tasks {
+		composed, err := t.ComposeJSON()
+		if err != nil {
+			return written, err
+		}
+		if err := writeLine(composed); err != nil {
+			return written, err
+		}
+	}
+	for _, addition := range s.additions {
+		if err := writeLine(addition); err != nil {
+			return written, err
+		}
+	}
+
+	return written, writeLine(s.key)
+}
+
+// String returns the whole payload.
+func (s *taskStream) String() string {
+	var payload strings.Builder
+	payload.Grow(s.size)
+	if _, err := s.WriteTo(&payload); err != nil {
+		log.Errorf("Error writing the payload: %v", err)
+	}
+	return payload.String()
+}
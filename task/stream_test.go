@@ -0,0 +1,82 @@
+package task
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskStream(t *testing.T) {
+	task, err := NewTask(`{"description":"Task 1","uuid":"1d8c1bb0-5d3b-4d6e-9b1a-1a2b3c4d5e6f"}`)
+	assert.Nil(t, err)
+	additions := []string{`{"description":"Task 2","uuid":"2b6e8d1c-7f3a-4e5b-9c0d-1a2b3c4d5e6f"}`}
+	key := "9d4fb7a2-3b1c-4a8e-8f2d-0e1f2a3b4c5d"
+
+	t.Run("writes the tasks followed by the key", func(t *testing.T) {
+		stream, err := newTaskStream([]Task{task}, additions, key)
+		assert.Nil(t, err)
+
+		expected := `{"description":"Task 1","uuid":"1d8c1bb0-5d3b-4d6e-9b1a-1a2b3c4d5e6f"}` + "\n" + additions[0] + "\n" + key + "\n"
+		assert.Equal(t, expected, stream.String())
+		assert.Equal(t, len(expected), stream.Size())
+	})
+
+	t.Run("only the key without tasks", func(t *testing.T) {
+		stream, err := newTaskStream(nil, nil, key)
+		assert.Nil(t, err)
+		assert.Equal(t, key+"\n", stream.String())
+	})
+
+	t.Run("streams the large sync responses", func(t *testing.T) {
+		defer func(threshold int) { streamThreshold = threshold }(streamThreshold)
+		streamThreshold = 0
+
+		data := "{\"description\":\"Task 1\",\"uuid\":\"1d8c1bb0-5d3b-4d6e-9b1a-1a2b3c4d5e6f\"}\n" + key + "\n"
+		sync := func(accept string) Message {
+			msg := parseMsg(t, loadPayload(t, "msg-sent-init"))
+			msg.Payload = ResetSyncKey + "\n"
+			if accept != "" {
+				msg.Header[AcceptEncodingHeader] = accept
+			}
+			client := &mockClient{
+				reader: strings.NewReader(string(frame(msg.String()))),
+				writer: new(strings.Builder),
+			}
+			ra := &mockReadAppender{reader: strings.NewReader(data), writer: new(strings.Builder)}
+
+			Process(client, &mockAuth{}, ra, DefaultOptions())
+			return parseMsg(t, client.writer.String())
+		}
+
+		resp := sync("")
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.Equal(t, data, resp.Payload)
+
+		resp = sync("gzip")
+		assert.Equal(t, "200", resp.Header["code"])
+		assert.Equal(t, data, gunzipString(t, resp.Payload))
+	})
+
+	t.Run("fails if the stream size is wrong", func(t *testing.T) {
+		msg := Message{Header: map[string]string{"type": "response"}, Stream: lyingStream{"payload"}}
+
+		_, err := msg.WriteTo(new(strings.Builder))
+		assert.Error(t, err)
+	})
+}
+
+// lyingStream reports a size bigger than the payload written.
+type lyingStream struct {
+	payload string
+}
+
+func (s lyingStream) Size() int {
+	return len(s.payload) + 1
+}
+
+func (s lyingStream) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, s.payload)
+	return int64(n), err
+}
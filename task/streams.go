@@ -0,0 +1,63 @@
+package task
+
+import (
+	gosync "sync"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// streams locks the transactions being synced or changed through the REST
+// API, so two of them don't merge from the same stale read.  The members of
+// a group share their transactions, and so their lock.
+var streams keyedMutex
+
+// lockStream locks the transactions of the user until the returned function
+// is called.
+func lockStream(user auth.User) func() {
+	org := ""
+	if user.Org != nil {
+		org = user.Org.Name
+	}
+	if user.Group != "" {
+		return streams.lock(org + "/groups/" + user.Group)
+	}
+	return streams.lock(org + "/users/" + user.Key)
+}
+
+// keyedMutex is a mutex per key.  The zero value is ready to use, and the
+// locks no longer held are dropped.
+type keyedMutex struct {
+	mu    gosync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	gosync.Mutex
+	refs int
+}
+
+// lock locks the key until the returned function is called.
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		k.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
@@ -0,0 +1,70 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// overlapReadAppender stores the transactions of every user in memory,
+// counting the syncs reading them before the previous one appended.
+type overlapReadAppender struct {
+	mu       gosync.Mutex
+	data     []string
+	active   int
+	overlaps int
+}
+
+func (o *overlapReadAppender) Read(_ auth.User) ([]string, error) {
+	o.mu.Lock()
+	if o.active++; o.active > 1 {
+		o.overlaps++
+	}
+	data := append([]string{}, o.data...)
+	o.mu.Unlock()
+
+	// let the other syncs catch up
+	time.Sleep(10 * time.Millisecond)
+	return data, nil
+}
+
+func (o *overlapReadAppender) Append(_ auth.User, data []string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.active--
+	for _, line := range data {
+		o.data = append(o.data, strings.TrimSuffix(line, "\n"))
+	}
+	return nil
+}
+
+func TestSyncGroupMembers(t *testing.T) {
+	org := &auth.Organization{Name: "Public"}
+	ra := &overlapReadAppender{}
+
+	var wg gosync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			user := auth.User{Name: fmt.Sprintf("member%d", i), Key: uuid.New().String(), Group: "team", Org: org}
+			task := fmt.Sprintf(`{"description":"task %d","entry":"20250101T100000Z","status":"pending","uuid":"%s"}`, i, uuid.New().String())
+			resp := sync(Message{Payload: task + "\n"}, user, ra, DefaultOptions())
+			assert.Equal(t, "200", resp.Header["code"])
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Zero(t, ra.overlaps)
+	// every member stored its task and a sync key
+	assert.Len(t, ra.data, 10)
+	assert.Empty(t, streams.locks)
+}
@@ -0,0 +1,60 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Tags returns the task tags in the order they were added.
+func (t *Task) Tags() []string {
+	return splitList(t.data["tags"])
+}
+
+// HasTag returns true if the task has the given tag.
+func (t *Task) HasTag(tag string) bool {
+	return sliceContains(t.Tags(), tag)
+}
+
+// AddTag adds a tag to the task, unless it already has it.  Tags can't be
+// empty nor contain commas or spaces.
+func (t *Task) AddTag(tag string) error {
+	if err := validateTag(tag); err != nil {
+		return err
+	}
+
+	tags := t.Tags()
+	if !sliceContains(tags, tag) {
+		t.data["tags"] = strings.Join(append(tags, tag), ",")
+	}
+	return nil
+}
+
+// RemoveTag removes a tag from the task and returns true, or false if the task
+// didn't have it.
+func (t *Task) RemoveTag(tag string) bool {
+	tags := t.Tags()
+	for i, current := range tags {
+		if current == tag {
+			tags = append(tags[:i], tags[i+1:]...)
+			if len(tags) == 0 {
+				delete(t.data, "tags")
+			} else {
+				t.data["tags"] = strings.Join(tags, ",")
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func validateTag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("empty tag")
+	} else if strings.ContainsRune(tag, ',') {
+		return fmt.Errorf("invalid tag %q: commas are not allowed", tag)
+	} else if strings.IndexFunc(tag, unicode.IsSpace) != -1 {
+		return fmt.Errorf("invalid tag %q: spaces are not allowed", tag)
+	}
+	return nil
+}
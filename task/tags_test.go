@@ -0,0 +1,74 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTags(t *testing.T) {
+	t.Run("add and remove tags", func(t *testing.T) {
+		task := Task{data: map[string]string{"description": "Some task"}}
+		assert.Empty(t, task.Tags())
+
+		for _, tag := range []string{"home", "café", "日本", "home", "urgent"} {
+			assert.NoError(t, task.AddTag(tag))
+		}
+		assert.Equal(t, []string{"home", "café", "日本", "urgent"}, task.Tags())
+		assert.True(t, task.HasTag("日本"))
+		assert.False(t, task.HasTag("cafe"))
+		assert.False(t, task.HasTag("hom"))
+
+		assert.True(t, task.RemoveTag("café"))
+		assert.False(t, task.RemoveTag("café"))
+		assert.Equal(t, []string{"home", "日本", "urgent"}, task.Tags())
+
+		for _, tag := range []string{"home", "日本", "urgent"} {
+			assert.True(t, task.RemoveTag(tag))
+		}
+		assert.Empty(t, task.Tags())
+		assert.False(t, task.Has("tags"))
+	})
+
+	t.Run("invalid tags fail", func(t *testing.T) {
+		task := Task{data: map[string]string{"description": "Some task"}}
+		for _, tag := range []string{"", "a,b", "two words", "tab\there", "no-break space"} {
+			assert.Error(t, task.AddTag(tag), tag)
+		}
+		assert.False(t, task.Has("tags"))
+	})
+
+	t.Run("parsed tags", func(t *testing.T) {
+		cases := []struct {
+			title    string
+			tags     string
+			expected []string
+			success  bool
+		}{
+			{"array", `["home","日本","home"]`, []string{"home", "日本"}, true},
+			{"string", `"home,日本"`, []string{"home", "日本"}, true},
+			{"empty tags are skipped", `["","home"]`, []string{"home"}, true},
+			{"empty array", `[]`, nil, true},
+			{"tag with spaces", `["two words"]`, nil, false},
+			{"tag with commas", `["a,b"]`, nil, false},
+		}
+
+		for _, c := range cases {
+			t.Run(c.title, func(t *testing.T) {
+				task, err := NewTask(`{"description":"Some task","tags":` + c.tags + `,"uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`)
+				if !c.success {
+					assert.Error(t, err)
+					return
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, c.expected, task.Tags())
+
+				raw, err := task.ComposeJSON()
+				assert.NoError(t, err)
+				parsed, err := NewTask(raw)
+				assert.NoError(t, err)
+				assert.Equal(t, c.expected, parsed.Tags())
+			})
+		}
+	})
+}
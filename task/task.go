@@ -3,8 +3,10 @@
 package task
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -42,8 +44,123 @@ const (
 	ServerCert   = "server.cert"
 	ServerCrl    = "server.crl"
 	CaCert       = "ca.cert"
+	SyncWorkers  = "sync.workers"
+
+	ServerIdentity     = "server.identity"
+	ServerMessage      = "server.message"
+	MaintenanceMessage = "maintenance.message"
+	ReadOnly           = "server.readonly"
+
+	ReplicationListen   = "replication.listen"
+	ReplicationReplicas = "replication.replicas"
+	ReplicationPrimary  = "replication.primary"
+	ReplicationRedirect = "replication.redirect"
+
+	FeedListen = "feed.listen"
+	FeedSize   = "feed.size"
+
+	PublishURL   = "publish.url"
+	PublishTopic = "publish.topic"
+
+	CalendarListen = "calendar.listen"
+
+	TunnelListen = "tunnel.listen"
+
+	APIListen = "api.listen"
+
+	SyncKeys          = "sync.keys"
+	SyncVerbose       = "sync.verbose"
+	SyncCondensedInit = "sync.init.condensed"
+	SyncLenientDates  = "sync.dates.lenient"
+
+	KeepAliveTimeout = "keepalive.timeout"
+
+	RequestTimeout = "timeout.request"
+	MinRate        = "limit.minrate"
+
+	HooksDir     = "hooks.dir"
+	HooksTimeout = "hooks.timeout"
+
+	ExtensionsTimeout = "extensions.timeout"
+
+	RetentionCompleted = "retention.completed.days"
+	RetentionDeleted   = "retention.deleted.days"
+	RetentionArchive   = "retention.archive"
+
+	DriftHistorySize  = "drift.history.size"
+	DriftFuture       = "drift.future"
+	DriftRejectFuture = "drift.reject.future"
+
+	MergeMode   = "merge.mode"
+	MergeShadow = "merge.shadow"
+	MergeAudit  = "merge.audit"
+
+	DryRunUsers = "dryrun.users"
+
+	QuotaSize = "quota.size"
+	LimitWarn = "limit.warn"
+
+	MemoryBudgetSize = "memory.budget"
+
+	OrgTemplate = "org.template"
+
+	DataRoots = "data.roots"
+	DataRoot  = "data.root"
+
+	JobSchedule = "schedule"
+	JobJitter   = "schedule.jitter"
+
+	StorageDedup        = "storage.dedup"
+	StorageCommitWindow = "storage.commit.window"
+
+	SMTPServer   = "smtp.server"
+	SMTPFrom     = "smtp.from"
+	SMTPUser     = "smtp.user"
+	SMTPPassword = "smtp.password"
+
+	AdminSocket = "admin.socket"
+	AdminDebug  = "admin.debug"
+
+	RunUser  = "run.user"
+	RunGroup = "run.group"
+	Sandbox  = "sandbox"
+
+	TLSMinVersion = "tls.min_version"
+	TLSMaxVersion = "tls.max_version"
+	TLSCiphers    = "tls.ciphers"
+
+	TLSSessionTickets = "tls.session.tickets"
+	TLSTicketRotation = "tls.ticket.rotation"
+
+	PingClientCert = "ping.client.cert"
 )
 
+// ConfigKeys are the configuration entries accepted when the configuration is
+// loaded in strict mode, including the taskd ones gotas ignores.
+var ConfigKeys = []string{
+	Confirmation, Extensions, IPLog, Log, PidFile, QueueSize, RequestLimit,
+	Root, BindAddress, Trust, Verbose, ClientCert, ClientKey, ServerKey,
+	ServerCert, ServerCrl, CaCert, SyncWorkers,
+	ServerCert + ".*", ServerKey + ".*",
+	ServerIdentity, ServerMessage, MaintenanceMessage, ReadOnly,
+	ReplicationListen, ReplicationReplicas, ReplicationPrimary, ReplicationRedirect,
+	FeedListen, FeedSize,
+	PublishURL, PublishTopic, PublishTopic + ".*",
+	CalendarListen, TunnelListen, APIListen, SyncKeys, SyncVerbose, SyncCondensedInit, SyncLenientDates, KeepAliveTimeout,
+	RequestTimeout, MinRate,
+	HooksDir, HooksTimeout, ExtensionsTimeout,
+	RetentionCompleted, RetentionDeleted, RetentionArchive,
+	DriftHistorySize, DriftFuture, DriftRejectFuture,
+	MergeMode, MergeMode + ".*", MergeShadow, MergeAudit,
+	DryRunUsers, QuotaSize, LimitWarn, MemoryBudgetSize, OrgTemplate + ".*", AdminSocket, AdminDebug,
+	DataRoots, DataRoot + ".*", JobSchedule + ".*", StorageDedup, StorageCommitWindow,
+	SMTPServer, SMTPFrom, SMTPUser, SMTPPassword,
+	RunUser, RunGroup, Sandbox,
+	TLSMinVersion, TLSMaxVersion, TLSCiphers,
+	TLSSessionTickets, TLSTicketRotation, PingClientCert,
+	"ciphers", "client.allow", "client.deny", "daemon", "debug", "debug.tls", "family",
+}
+
 var (
 	attributeTypes = map[string]string{
 		"depends":      "string",
@@ -122,13 +239,24 @@ type Task struct {
 // command implementation) until the last one, v2.6.0 (development branch) and
 // it seems to work fine, always receiving JSON payloads.
 func NewTask(raw string) (Task, error) {
+	return newTask(raw, ParseDate)
+}
+
+// NewLenientTask parses a raw string like NewTask, also accepting the dates
+// ParseLenientDate does.  The dates are normalized, so the task is composed
+// as if they followed DateLayout.
+func NewLenientTask(raw string) (Task, error) {
+	return newTask(raw, ParseLenientDate)
+}
+
+func newTask(raw string, parseDate func(string) (time.Time, error)) (Task, error) {
 	rune, _ := utf8.DecodeRuneInString(raw)
 	switch rune {
 	// first try, format v4
 	case '[':
 		return parseV4(raw)
 	case '{':
-		return parseJSON(raw)
+		return parseJSON(raw, parseDate)
 	case utf8.RuneError:
 		return Task{}, fmt.Errorf("invalid string")
 	default:
@@ -157,11 +285,7 @@ func parseV4(raw string) (Task, error) {
 			name := new(strings.Builder)
 			value := new(strings.Builder)
 			if attLine.GetUntil(':', name) && attLine.Skip(':') && attLine.GetQuoted('"', value) {
-				if !strings.HasPrefix(name.String(), "annotation_") {
-					task.annotationCount++
-				}
-
-				task.data[name.String()] = parser.Decode(value.String())
+				task.Set(name.String(), parser.DecodeValue(value.String()))
 			} else if attLine.Eos() {
 				// throw std::string ("Unrecognized characters at end of line.");
 				log.Debug("unrecognized characters at end of line, trying legacy parsing")
@@ -202,21 +326,27 @@ func parseLegacy(line string) (Task, error) {
 	return Task{}, fmt.Errorf("not implemented")
 }
 
-func parseJSON(line string) (Task, error) {
-	lineAsJSON := make(map[string]interface{})
-
-	if err := json.Unmarshal([]byte(line), &lineAsJSON); err != nil {
+func parseJSON(line string, parseDate func(string) (time.Time, error)) (Task, error) {
+	lineAsJSON, err := objectFields([]byte(line))
+	if err != nil {
 		return Task{}, fmt.Errorf("parsing json: %v", err.Error())
 	}
 
-	uuid := fmt.Sprintf("%v", lineAsJSON["uuid"])
+	// The uuid is needed beforehand to validate the dependencies.
+	var uuid json.RawMessage
+	for _, field := range lineAsJSON {
+		if field.name == "uuid" {
+			uuid = field.value
+		}
+	}
+
 	t := Task{
-		data: map[string]string{
-			"uuid": uuid,
-		},
+		data: make(map[string]string, len(lineAsJSON)+1),
 	}
+	t.data["uuid"] = rawString(uuid)
 
-	for attrName, attrValue := range lineAsJSON {
+	for _, field := range lineAsJSON {
+		attrName, attrValue := field.name, field.value
 		// If the attribute is a recognized column.
 		if attrType := attributeTypes[attrName]; attrType != "" {
 			if attrName == "id" {
@@ -227,25 +357,35 @@ func parseJSON(line string) (Task, error) {
 				continue
 			} else if attrName == "modification" {
 				// TW-1274 Standardization.
-				ts, err := time.Parse(DateLayout, fmt.Sprintf("%v", attrValue))
+				epoch, err := rawDate(attrValue, parseDate)
 				if err != nil {
-					return Task{}, fmt.Errorf("parsing date in %v field, %v: %v", attrName, attrValue, err.Error())
+					return Task{}, fmt.Errorf("parsing date in %v field, %s: %v", attrName, attrValue, err.Error())
 				}
-				t.data["modified"] = fmt.Sprintf("%d", ts.UTC().Unix())
+				t.data["modified"] = epoch
 			} else if attrType == "date" {
 				// Dates are converted from ISO to epoch.
-				ts, err := time.Parse(DateLayout, fmt.Sprintf("%v", attrValue))
+				epoch, err := rawDate(attrValue, parseDate)
 				if err != nil {
-					return Task{}, fmt.Errorf("parsing date in %v field, %v: %v", attrName, attrValue, err.Error())
+					return Task{}, fmt.Errorf("parsing date in %v field, %s: %v", attrName, attrValue, err.Error())
 				}
-				t.data[attrName] = fmt.Sprintf("%d", ts.UTC().Unix())
+				t.data[attrName] = epoch
+			} else if attrType == "duration" {
+				// Durations are kept as sent, the ones not understood
+				// too, the client that wrote them may know better.
+				duration := rawString(attrValue)
+				if _, err := parser.ParseDuration(duration); err != nil {
+					log.Warnf("Keeping unknown duration in %v field of task %v: %v", attrName, t.data["uuid"], err)
+				}
+				t.data[attrName] = duration
 			} else if attrName == "tags" {
 				tags, err := parseTags(attrValue)
 				if err != nil {
 					return Task{}, err
 				}
 				for _, tag := range tags {
-					t.addTag(tag)
+					if err := t.AddTag(tag); err != nil {
+						return Task{}, err
+					}
 				}
 			} else if attrName == "depends" {
 				dependencies, err := parseDepends(attrValue)
@@ -254,111 +394,133 @@ func parseJSON(line string) (Task, error) {
 				}
 
 				for _, dep := range dependencies {
-					if err := t.addDependency(dep); err != nil {
+					if err := t.AddDependency(dep); err != nil {
 						return Task{}, err
 					}
 				}
 			} else {
 				// Other types are simply added.
-				// json.Unmarshal already decoded the `\uxxxx` escaped unicode
-				t.data[attrName] = fmt.Sprintf("%v", attrValue)
+				// rawString already decodes the `\uxxxx` escaped unicode
+				t.data[attrName] = rawString(attrValue)
 			}
 		} else {
 			// UDA orphans and annotations do not have columns.
 
 			if attrName == "annotations" {
-				entries, err := parseAnnoations(attrValue)
+				annotations, err := parseAnnotations(attrValue, parseDate)
 				if err != nil {
 					return Task{}, err
 				}
 
-				for _, e := range entries {
-					t.data[e[0]] = e[1]
+				for _, a := range annotations {
+					t.setAnnotation(a.Entry.Unix(), a.Description)
 				}
 			} else { // UDA Orphan - must be preserved.
-				t.data[attrName] = fmt.Sprintf("%v", attrValue)
+				t.data[attrName] = rawString(attrValue)
 			}
 		}
 	}
 	return t, nil
 }
 
-func parseTags(attrValue interface{}) ([]string, error) {
+func parseTags(attrValue json.RawMessage) ([]string, error) {
 	var tags []string
-	switch value := attrValue.(type) {
-	case []interface{}:
+	switch attrValue[0] {
+	case '[':
 		// Tags are an array of JSON strings.
-		for _, tag := range value {
-			tags = append(tags, fmt.Sprintf("%v", tag))
+		values, err := splitArray(attrValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid type for field tags: %s", attrValue)
 		}
-	case string:
+		tags = make([]string, 0, len(values))
+		for _, tag := range values {
+			if tag := rawString(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	case '"':
 		// This is a temporary measure to accommodate a malformed JSON message
 		// from Mirakel sync.
 		// 2016-02-21 Mirakel dropped sync support in late 2015. This can be
 		//            removed in a later release.
-		tags = append(tags, value)
+		tags = splitList(rawString(attrValue))
 	default:
-		return nil, fmt.Errorf("invalid type for field tags: %v", attrValue)
+		return nil, fmt.Errorf("invalid type for field tags: %s", attrValue)
 	}
 	return tags, nil
 }
 
-func parseDepends(attrValue interface{}) ([]string, error) {
+func parseDepends(attrValue json.RawMessage) ([]string, error) {
 	var deps []string
-	switch value := attrValue.(type) {
-	case []interface{}:
+	switch attrValue[0] {
+	case '[':
 		// Dependencies can be exported as an array of strings.
 		// 2016-02-21: This will be the only option in future releases.
 		//             See other 2016-02-21 comments for details.
-		for _, dependency := range value {
-			deps = append(deps, fmt.Sprintf("%v", dependency))
+		values, err := splitArray(attrValue)
+		if err != nil {
+			return nil, fmt.Errorf("depends type not match: %s", attrValue)
 		}
-	case string:
+		deps = make([]string, 0, len(values))
+		for _, dependency := range values {
+			deps = append(deps, rawString(dependency))
+		}
+	case '"':
 		// Dependencies can be exported as a single comma-separated string.
 		// 2016-02-21: Deprecated - see other 2016-02-21 comments for details.
-		for _, dependency := range strings.Split(value, ",") {
-			deps = append(deps, fmt.Sprintf("%v", dependency))
-		}
+		deps = strings.Split(rawString(attrValue), ",")
 	default:
-		return nil, fmt.Errorf("depends type not match: %v", value)
+		return nil, fmt.Errorf("depends type not match: %s", attrValue)
 	}
 	return deps, nil
 }
 
-func parseAnnoations(attrValue interface{}) ([][]string, error) {
+func parseAnnotations(attrValue json.RawMessage, parseDate func(string) (time.Time, error)) ([]Annotation, error) {
 	// Annotations are an array of JSON objects with 'entry' and
 	// 'description' values and must be converted.
-	var entries [][]string
-	if annotations, ok := attrValue.([]interface{}); ok {
-		for _, item := range annotations {
-			if annotation, ok := item.(map[string]interface{}); ok {
-				entry := make([]string, 2)
-
-				when, ok := annotation["entry"]
-				if !ok {
-					return nil, fmt.Errorf("annotation is missing an entry date: %v", annotation)
-				}
-				what, ok := annotation["description"]
-				if !ok {
-					return nil, fmt.Errorf("annotation is missing a description: %v", annotation)
-				}
+	if attrValue[0] != '[' {
+		return nil, fmt.Errorf("annotations type does not match: %s", attrValue)
+	}
 
-				ts, err := time.Parse(DateLayout, fmt.Sprintf("%v", when))
-				if err != nil {
-					return nil, fmt.Errorf("invalid date format %q: %v", when, err.Error())
-				}
-				name := fmt.Sprintf("annotation_%v", ts.UTC().Unix())
+	annotations, err := splitArray(attrValue)
+	if err != nil {
+		return nil, fmt.Errorf("annotations type does not match: %v", err)
+	}
 
-				entry[0] = name
-				entry[1] = fmt.Sprintf("%v", what)
-				entries = append(entries, entry)
-			} else {
-				return nil, fmt.Errorf("annotations type inside list does not match: %T", attrValue)
+	entries := make([]Annotation, 0, len(annotations))
+	for _, item := range annotations {
+		if item[0] != '{' {
+			return nil, fmt.Errorf("annotations type inside list does not match: %s", item)
+		}
+		fields, err := splitObject(item)
+		if err != nil {
+			return nil, fmt.Errorf("annotations type inside list does not match: %v", err)
+		}
+
+		var when, what json.RawMessage
+		for _, field := range fields {
+			switch field.name {
+			case "entry":
+				when = field.value
+			case "description":
+				what = field.value
 			}
 		}
-		return entries, nil
+		if when == nil {
+			return nil, fmt.Errorf("annotation is missing an entry date: %s", item)
+		}
+		if what == nil {
+			return nil, fmt.Errorf("annotation is missing a description: %s", item)
+		}
+
+		entry, err := parseDate(rawDateString(when))
+		if err != nil {
+			return nil, fmt.Errorf("invalid date format %s: %v", when, err.Error())
+		}
+
+		entries = append(entries, Annotation{Entry: entry.UTC(), Description: rawString(what)})
 	}
-	return nil, fmt.Errorf("annotations type does not match: %T", attrValue)
+	return entries, nil
 }
 
 func determineVersion(line string) int {
@@ -374,7 +536,7 @@ func determineVersion(line string) int {
 	// character.
 	var validUUID bool
 	var status byte
-	if len(line) > 36 {
+	if len(line) > 37 {
 		_, err := uuid.Parse(line[0:36])
 		status = line[37]
 		validUUID = err == nil
@@ -433,6 +595,9 @@ func (t *Task) Get(name string) string {
 
 // Set sets or overrides the given attribute to the task.
 func (t *Task) Set(name, value string) {
+	if _, ok := t.data[name]; !ok && strings.HasPrefix(name, annotationPrefix) {
+		t.annotationCount++
+	}
 	t.data[name] = value
 }
 
@@ -486,92 +651,113 @@ func (t *Task) GetAttrNames() []string {
 // Remove removes an attribute or does not do anything in case it doesn't
 // exist.
 func (t *Task) Remove(name string) {
+	if _, ok := t.data[name]; ok && strings.HasPrefix(name, annotationPrefix) {
+		t.annotationCount--
+	}
 	delete(t.data, name)
 }
 
-// ComposeJSON converts a given task to its JSON representation.  Decorate
-// parameter allows including the "id" task attribute.
-func (t *Task) ComposeJSON() string {
-	filtered := make(map[string]interface{})
+// ComposeF4 converts a given task to the format 4 line used by the legacy
+// taskserver data files, `[name:"value" ...]`.  Attributes are written in
+// lexicographical order and the empty ones are skipped, as Taskwarrior does.
+func (t *Task) ComposeF4() string {
+	names := make([]string, 0, len(t.data))
+	for name, value := range t.data {
+		if value != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var ff4 strings.Builder
+	ff4.WriteByte('[')
+	for i, name := range names {
+		if i > 0 {
+			ff4.WriteByte(' ')
+		}
+		ff4.WriteString(name)
+		ff4.WriteString(`:"`)
+		ff4.WriteString(parser.EncodeValue(t.data[name]))
+		ff4.WriteByte('"')
+	}
+	ff4.WriteByte(']')
+
+	return ff4.String()
+}
+
+// ComposeJSON converts a given task to its JSON representation.  Attributes
+// are written in lexicographical order and annotations are sorted by their
+// entry date, so the same task always produces the same output.
+func (t *Task) ComposeJSON() (string, error) {
+	names := make([]string, 0, len(t.data)+1)
 
 	for attrName, attrValue := range t.data {
-		attrType := attributeTypes[attrName]
+		if strings.HasPrefix(attrName, annotationPrefix) {
+			continue
+		} else if attrType := attributeTypes[attrName]; attrType == "date" || attrType == "numeric" ||
+			attrName == "tags" || attrName == "depends" || len(attrValue) > 0 {
+			names = append(names, attrName)
+		}
+	}
 
-		if strings.HasPrefix(attrName, "annotation_") {
-			epoch, err := strconv.Atoi(attrName[len("annotation_"):])
-			if err != nil {
-				log.Warnf("Malformed annotation %q: %v", attrName, err)
-				continue
-			}
+	annotations := t.Annotations()
+	if len(annotations) > 0 {
+		names = append(names, "annotations")
+	}
+	sort.Strings(names)
 
-			newAnnotation := map[string]string{
-				"entry":       time.Unix(int64(epoch), 0).UTC().Format(DateLayout),
-				"description": attrValue,
-			}
+	buf := composeBuffers.Get().(*bytes.Buffer)
+	defer composeBuffers.Put(buf)
+	buf.Reset()
 
-			annotations, ok := filtered["annotations"]
-			if !ok {
-				filtered["annotations"] = []map[string]string{newAnnotation}
-			} else {
-				filtered["annotations"] = append(annotations.([]map[string]string), newAnnotation)
+	buf.WriteByte('{')
+	for idx, attrName := range names {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSONString(buf, attrName)
+		buf.WriteByte(':')
+
+		attrValue := t.data[attrName]
+		attrType := attributeTypes[attrName]
+
+		if attrName == "annotations" {
+			buf.WriteByte('[')
+			for i, annotation := range annotations {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteString(`{"description":`)
+				writeJSONString(buf, annotation.Description)
+				buf.WriteString(`,"entry":`)
+				writeJSONString(buf, annotation.Entry.Format(DateLayout))
+				buf.WriteByte('}')
 			}
+			buf.WriteByte(']')
 		} else if attrType == "date" {
-			filtered[attrName] = t.GetDate(attrName).Format(DateLayout)
+			writeJSONString(buf, t.GetDate(attrName).Format(DateLayout))
 		} else if attrType == "numeric" {
-			filtered[attrName] = t.GetInt(attrName)
+			buf.WriteString(strconv.Itoa(t.GetInt(attrName)))
 		} else if attrName == "tags" {
-			filtered[attrName] = strings.Split(attrValue, ",")
-		} else if attrName == "depends" {
-			// taskwarrior has two possible type for it, string or array.
+			buf.WriteByte('[')
+			for i, tag := range t.Tags() {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				writeJSONString(buf, tag)
+			}
+			buf.WriteByte(']')
+		} else {
+			// taskwarrior has two possible type for "depends", string or array.
 			// see https://github.com/GothenburgBitFactory/taskserver/blob/1aaa22452c2c656c5cdb8e017368e0848e54555d/src/Task.cpp#L935-L948
 			// Set string and not list to be compliant with taskd 1.2.0 and tw 2.5.x
 			// TODO be aware of the config property "json.depends.array"
-			filtered[attrName] = strings.Split(attrValue, ",")
-			filtered[attrName] = fmt.Sprintf("%v", attrValue)
-		} else if len(attrValue) > 0 {
-			filtered[attrName] = attrValue
+			writeJSONString(buf, attrValue)
 		}
 	}
+	buf.WriteByte('}')
 
-	value, err := json.Marshal(filtered)
-	if err != nil {
-		// TODO return an error or just log it?
-		log.Errorf("Error marshaling task: %v", err)
-		return ""
-	}
-	return string(value)
-}
-
-func (t *Task) addTag(tag string) {
-	var tags []string
-	if len(t.data["tags"]) > 0 {
-		tags = strings.Split(t.data["tags"], ",")
-	}
-	for _, t := range tags {
-		if t == tag {
-			// tag already exists, don't add it
-			return
-		}
-	}
-	tags = append(tags, tag)
-	t.data["tags"] = strings.Join(tags, ",")
-}
-
-func (t *Task) addDependency(dependency string) error {
-	if dependency == t.data["uuid"] {
-		return fmt.Errorf("a task cannot be dependent on itself")
-	}
-
-	depends := t.data["depends"]
-	if depends != "" {
-		// Check for extant dependency.
-		if !strings.Contains(depends, dependency) {
-			t.data["depends"] = fmt.Sprintf("%s,%s", depends, dependency)
-		}
-	} else {
-		t.data["depends"] = dependency
-	}
-	return nil
+	return buf.String(), nil
 }
 
 // Copy returns a copy of the task
@@ -587,3 +773,31 @@ func (t *Task) Copy() Task {
 
 	return ret
 }
+
+// LatestTasks returns the latest state of every task found in the given
+// transactions, in the order they were first seen.  Sync keys are skipped.
+func LatestTasks(data []string) ([]Task, error) {
+	var tasks []Task
+	positions := make(map[string]int)
+
+	for _, line := range data {
+		if !strings.HasPrefix(line, "{") && !strings.HasPrefix(line, "[") {
+			continue
+		}
+
+		task, err := NewTask(line)
+		if err != nil {
+			return nil, err
+		}
+
+		uuid := task.Get("uuid")
+		if idx, ok := positions[uuid]; ok {
+			tasks[idx] = task
+		} else {
+			positions[uuid] = len(tasks)
+			tasks = append(tasks, task)
+		}
+	}
+
+	return tasks, nil
+}
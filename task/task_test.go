@@ -28,6 +28,15 @@ func TestNewTask(t *testing.T) {
 				"entry":       "123",
 			},
 		},
+		{
+			"escaped values are decoded",
+			`[description:"say \"hi\" &open;now&close; \u20ac" uuid:"456"]`,
+			true,
+			map[string]string{
+				"description": `say "hi" [now] €`,
+				"uuid":        "456",
+			},
+		},
 		{
 			"additional characters at the end of the task fails",
 			`[description:"Some task" entry:"123" status:"pending" uuid:"456a" abc def]`,
@@ -92,6 +101,40 @@ func TestNewTask(t *testing.T) {
 				"annotation_1633003244": "A small annotation 2",
 			},
 		},
+		{
+			"recurring task with a valid duration works",
+			`{"description":"Pay rent","entry":"20211009T112536Z","recur":"P1M","status":"recurring","uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`,
+			true,
+			map[string]string{
+				"description": "Pay rent",
+				"entry":       "1633778736",
+				"recur":       "P1M",
+				"status":      "recurring",
+				"uuid":        "e346004f-6ebb-4507-8f21-0ba2b8f263d8",
+			},
+		},
+		{
+			"task unknown recur duration kept as sent",
+			`{"description":"Pay rent","recur":"every month","status":"recurring","uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`,
+			true,
+			map[string]string{
+				"description": "Pay rent",
+				"recur":       "every month",
+				"status":      "recurring",
+				"uuid":        "e346004f-6ebb-4507-8f21-0ba2b8f263d8",
+			},
+		},
+		{
+			"task fractional recur duration",
+			`{"description":"Water plants","recur":"1.5d","status":"recurring","uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`,
+			true,
+			map[string]string{
+				"description": "Water plants",
+				"recur":       "1.5d",
+				"status":      "recurring",
+				"uuid":        "e346004f-6ebb-4507-8f21-0ba2b8f263d8",
+			},
+		},
 		{"task depends itself", readFile(t, "task-invalid-depends-itself.json"), false, nil},
 		{"task depends itself when depends is slice", readFile(t, "task-invalid-depends-itself-2.json"), false, nil},
 		{"task invalid entry date", readFile(t, "task-invalid-entry-date.json"), false, nil},
@@ -109,6 +152,7 @@ func TestNewTask(t *testing.T) {
 		{"format FF3 fails", `a2b5f6fc-7285-75cc-90b9-abf624a8457e - [] [entry:1632687645 priority: project:] [1632722433:"A small annotation"] Some task`, false, nil},
 		{"format FF2 fails", `37beef88-c3f8-a1e9-1f49-0a4856f7af7d - [] [entry:1632721666 priority: project:] annotate A small annotation`, false, nil},
 		{"format FF1 fails", `X [someTag] [att:value] description`, false, nil},
+		{"uuid followed by a single char fails", `37beef88-c3f8-a1e9-1f49-0a4856f7af7d `, false, nil},
 	}
 
 	for _, c := range cases {
@@ -130,13 +174,43 @@ func TestNewTask(t *testing.T) {
 		task, err := NewTask(readFile(t, "task-2.json"))
 		assert.Nil(t, err)
 
-		json := task.ComposeJSON()
+		json, err := task.ComposeJSON()
+		assert.Nil(t, err)
 		task2, err := NewTask(json)
 		assert.Nil(t, err)
 
 		assert.Equal(t, task, task2)
 	})
 
+	t.Run("task compose json is deterministic", func(t *testing.T) {
+		task, err := NewTask(readFile(t, "task-2.json"))
+		assert.Nil(t, err)
+
+		expected, err := task.ComposeJSON()
+		assert.Nil(t, err)
+
+		for i := 0; i < 20; i++ {
+			copied := task.Copy()
+			actual, err := copied.ComposeJSON()
+			assert.Nil(t, err)
+			assert.Equal(t, expected, actual)
+		}
+	})
+
+	t.Run("task compose json sorts annotations by entry", func(t *testing.T) {
+		task := Task{data: map[string]string{
+			"uuid":                  "b04d7885-31ff-4992-b4fe-5cde1b41ca54",
+			"annotation_1633003244": "second",
+			"annotation_999":        "first",
+		}}
+
+		json, err := task.ComposeJSON()
+		assert.Nil(t, err)
+		assert.Equal(t,
+			`{"annotations":[{"description":"first","entry":"19700101T001639Z"},{"description":"second","entry":"20210930T120044Z"}],"uuid":"b04d7885-31ff-4992-b4fe-5cde1b41ca54"}`,
+			json)
+	})
+
 	t.Run("gets and sets", func(t *testing.T) {
 		task, err := NewTask(readFile(t, "task-2.json"))
 		assert.Nil(t, err)
@@ -191,6 +265,24 @@ func TestNewTask(t *testing.T) {
 
 }
 
+func TestComposeF4(t *testing.T) {
+	task, err := NewTask(readFile(t, "task-2.json"))
+	assert.Nil(t, err)
+	task.Set("description", `say "hi" [now] \ €`)
+	task.Set("project", "")
+
+	ff4 := task.ComposeF4()
+	assert.Equal(t, `[annotation_1633003241:"A small annotation" annotation_1633003244:"A small annotation 2" `+
+		`customField:"value for custom field" depends:"abc,xyz" description:"say \"hi\" &open;now&close; \\ €" `+
+		`entry:"1633003050" imask:"1" modified:"1633179167" status:"pending" tags:"tag1,tag2" uuid:"b04d7885-31ff-4992-b4fe-5cde1b41ca54"]`, ff4)
+	assert.Equal(t, 4, determineVersion(ff4))
+
+	parsed, err := NewTask(ff4)
+	assert.Nil(t, err)
+	task.Remove("project")
+	assert.Equal(t, task.data, parsed.data)
+}
+
 func TestDetermineVersion(t *testing.T) {
 	cases := []struct {
 		raw     string
@@ -230,3 +322,75 @@ func readFile(t *testing.T, path string) string {
 	}
 	return string(content)
 }
+
+func BenchmarkNewTask(b *testing.B) {
+	payload := benchmarkPayload(b, 10000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, line := range payload {
+			if _, err := NewTask(line); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkComposeJSON(b *testing.B) {
+	var tasks []Task
+	for _, line := range benchmarkPayload(b, 10000) {
+		task, err := NewTask(line)
+		if err != nil {
+			b.Fatal(err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for idx := range tasks {
+			if _, err := tasks[idx].ComposeJSON(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func benchmarkPayload(b *testing.B, size int) []string {
+	b.Helper()
+
+	const template = `{"description":"Task number %d with \"quotes\"","due":"20211009T220000Z",` +
+		`"entry":"20211009T112536Z","modified":"20211009T112552Z","status":"pending",` +
+		`"uuid":"e346004f-6ebb-4507-8f21-%012d","tags":["tagOne","tagTwo"],"imask":%d,` +
+		`"depends":["b8a25aa7-fea9-4abf-a487-02eacd85bd58"],"customField":"value",` +
+		`"annotations":[{"entry":"20210930T120041Z","description":"A small annotation"}]}`
+
+	payload := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		payload = append(payload, fmt.Sprintf(template, i, i, i))
+	}
+	return payload
+}
+
+func TestLatestTasks(t *testing.T) {
+	data := []string{
+		`{"description":"first","entry":"20211009T112536Z","status":"pending","uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`,
+		`{"description":"second","entry":"20211009T112536Z","status":"pending","uuid":"2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b"}`,
+		`94978aad-fbaf-4876-92e0-33321f1cbab9`,
+		`{"description":"first modified","entry":"20211009T112536Z","status":"completed","uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}`,
+		`ee197af5-abba-4dd8-b8ea-f40df3000d5a`,
+	}
+
+	tasks, err := LatestTasks(data)
+	assert.Nil(t, err)
+	if assert.Len(t, tasks, 2) {
+		assert.Equal(t, "first modified", tasks[0].Get("description"))
+		assert.Equal(t, "completed", tasks[0].Get("status"))
+		assert.Equal(t, "second", tasks[1].Get("description"))
+	}
+
+	_, err = LatestTasks([]string{`{"uuid": invalid}`})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// clientSessions caches the tls sessions, so reconnecting to the same server
+// resumes them instead of running a full handshake.
+var clientSessions = tls.NewLRUClientSessionCache(0)
+
+// ClientConfig exposes the configuration needed to connect to a tls server.
+// An "https://host:port" address tunnels the connection over HTTPS.
+type ClientConfig struct {
+	CaCert  string
+	Cert    string
+	Key     string
+	Address string
+}
+
+// Dial connects to a tls server authenticating with the client certificate.
+// With an https address the messages are tunneled over HTTPS, the connection
+// is only established once the first message is read.
+func Dial(cfg ClientConfig) (io.ReadWriteCloser, error) {
+	var ca []byte
+	var cert tls.Certificate
+	var err error
+
+	if ca, err = os.ReadFile(cfg.CaCert); err != nil {
+		return nil, fmt.Errorf("reading root CA file: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	if ok := roots.AppendCertsFromPEM(ca); !ok {
+		return nil, fmt.Errorf("invalid root CA file: %v", cfg.CaCert)
+	}
+
+	if cert, err = tls.LoadX509KeyPair(cfg.Cert, cfg.Key); err != nil {
+		return nil, fmt.Errorf("reading certificate file: %v", err)
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		Certificates:       []tls.Certificate{cert},
+		RootCAs:            roots,
+		ClientSessionCache: clientSessions,
+	}
+
+	if strings.HasPrefix(cfg.Address, "https://") {
+		u, err := url.Parse(cfg.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %v: %v", cfg.Address, err)
+		}
+		if u.Path == "" {
+			u.Path = TunnelPath
+		}
+		return dialTunnel(u.String(), tlsCfg), nil
+	}
+
+	conn, err := tls.Dial("tcp", cfg.Address, tlsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %v: %v", cfg.Address, err)
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,206 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// diagnoseTimeout bounds the connection and the wait for the server to reject
+// the client certificate, which in TLS 1.3 happens after the handshake.
+const diagnoseTimeout = 2 * time.Second
+
+// Certificate summarizes a certificate of a chain.
+type Certificate struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Names     []string  `json:"names"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+// Diagnosis is the result of a diagnostic connection to a tls server.
+type Diagnosis struct {
+	Version     string        `json:"version"`
+	CipherSuite string        `json:"cipherSuite"`
+	Chain       []Certificate `json:"chain"`
+	Problems    []string      `json:"problems"`
+}
+
+// Diagnose connects to a tls server like Dial does, but instead of failing on
+// the first error it collects the problems found in the server and client
+// certificates, explained in plain words.  It only fails if the connection
+// can't be established at all.
+func Diagnose(cfg ClientConfig) (Diagnosis, error) {
+	diagnosis := Diagnosis{Chain: make([]Certificate, 0), Problems: make([]string, 0)}
+
+	var roots *x509.CertPool
+	if ca, err := os.ReadFile(cfg.CaCert); err != nil {
+		diagnosis.problem("the CA certificate can't be read: %v", err)
+	} else if roots = x509.NewCertPool(); !roots.AppendCertsFromPEM(ca) {
+		diagnosis.problem("the CA file %v has no valid PEM certificate", cfg.CaCert)
+		roots = nil
+	}
+
+	var certs []tls.Certificate
+	if cfg.Cert == "" || cfg.Key == "" {
+		diagnosis.problem("no client certificate configured, the server requires one")
+	} else if cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key); err != nil {
+		diagnosis.problem("the client certificate can't be loaded: %v", err)
+	} else {
+		certs = append(certs, cert)
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+			diagnosis.checkValidity("client", leaf)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(cfg.Address)
+	if err != nil {
+		return diagnosis, fmt.Errorf("invalid address %v: %v", cfg.Address, err)
+	}
+
+	// the chain is verified below to explain the failures
+	dialer := &net.Dialer{Timeout: diagnoseTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", cfg.Address, &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		Certificates:       certs,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		if rejected(err) {
+			diagnosis.problem("the server rejected the client certificate: %v", err)
+			return diagnosis, nil
+		}
+		return diagnosis, fmt.Errorf("connecting to %v: %v", cfg.Address, err)
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	diagnosis.Version = tlsVersion(state.Version)
+	diagnosis.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+
+	for _, cert := range state.PeerCertificates {
+		diagnosis.Chain = append(diagnosis.Chain, summarize(cert))
+	}
+
+	if len(state.PeerCertificates) > 0 && roots != nil {
+		leaf := state.PeerCertificates[0]
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			DNSName:       host,
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		if err != nil {
+			diagnosis.Problems = append(diagnosis.Problems, explain("server", err, host, leaf))
+		}
+	}
+
+	// in TLS 1.3 the server checks the client certificate after the
+	// handshake, a rejection arrives as an alert on the first read
+	if err := conn.SetReadDeadline(time.Now().Add(diagnoseTimeout)); err == nil {
+		if _, err := conn.Read(make([]byte, 1)); err != nil && rejected(err) {
+			diagnosis.problem("the server rejected the client certificate: %v", err)
+		}
+	}
+
+	return diagnosis, nil
+}
+
+func (d *Diagnosis) problem(format string, args ...interface{}) {
+	d.Problems = append(d.Problems, fmt.Sprintf(format, args...))
+}
+
+func (d *Diagnosis) checkValidity(owner string, cert *x509.Certificate) {
+	if problem := validity(owner, cert); problem != "" {
+		d.Problems = append(d.Problems, problem)
+	}
+}
+
+// validity describes why the certificate is not valid now, if it isn't.
+func validity(owner string, cert *x509.Certificate) string {
+	now := time.Now()
+	if now.After(cert.NotAfter) {
+		return fmt.Sprintf("the %s certificate expired on %v", owner, cert.NotAfter.Format(time.RFC3339))
+	} else if now.Before(cert.NotBefore) {
+		return fmt.Sprintf("the %s certificate is not valid until %v", owner, cert.NotBefore.Format(time.RFC3339))
+	}
+	return ""
+}
+
+// explain describes a certificate verification error, the owner being
+// "server" or "client".
+func explain(owner string, err error, host string, cert *x509.Certificate) string {
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var unknown x509.UnknownAuthorityError
+
+	switch {
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired && validity(owner, cert) != "":
+		return validity(owner, cert)
+	case errors.As(err, &invalid) && invalid.Reason == x509.IncompatibleUsage:
+		return fmt.Sprintf("the %s certificate is not allowed for %s authentication", owner, owner)
+	case errors.As(err, &hostname):
+		names := certNames(cert)
+		if len(names) == 0 {
+			return fmt.Sprintf("the %s certificate has no subject alternative names and the CN %q is ignored, regenerate it including %q", owner, cert.Subject.CommonName, host)
+		}
+		return fmt.Sprintf("the %s certificate is valid for %v, not for %q", owner, strings.Join(names, ", "), host)
+	case errors.As(err, &unknown):
+		return fmt.Sprintf("the %s certificate, issued by %q, is not signed by the configured CA", owner, cert.Issuer.String())
+	}
+	return fmt.Sprintf("the %s certificate is not valid: %v", owner, err)
+}
+
+// summarize returns the certificate details shown to the user.
+func summarize(cert *x509.Certificate) Certificate {
+	return Certificate{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		Names:     certNames(cert),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+	}
+}
+
+// rejected returns whether the error is an alert sent by the server because of
+// the client certificate.
+func rejected(err error) bool {
+	msg := err.Error()
+	for _, alert := range []string{"bad certificate", "certificate required", "unknown certificate authority", "expired certificate", "certificate revoked"} {
+		if strings.Contains(msg, alert) {
+			return true
+		}
+	}
+	return false
+}
+
+func certNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+func tlsVersion(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
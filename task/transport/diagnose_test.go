@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiagnose(t *testing.T) {
+	base := filepath.Join("testdata", "certs")
+	srvConfig := TLSConfig{
+		CaCert:      filepath.Join(base, "ca.pem"),
+		ServerCert:  filepath.Join(base, "server.pem"),
+		ServerKey:   filepath.Join(base, "server.key"),
+		BindAddress: fmt.Sprintf("localhost:%d", nextFreePort(t, 1025)),
+	}
+
+	srv, err := NewServer(srvConfig, 1, func(client io.ReadWriteCloser) {
+		client.Close()
+	})
+	assert.Nil(t, err)
+	defer srv.Close()
+
+	t.Run("valid certificates", func(t *testing.T) {
+		diagnosis, err := Diagnose(ClientConfig{
+			CaCert:  filepath.Join(base, "ca.pem"),
+			Cert:    filepath.Join(base, "client.pem"),
+			Key:     filepath.Join(base, "client.key"),
+			Address: srvConfig.BindAddress,
+		})
+		assert.Nil(t, err)
+		assert.Empty(t, diagnosis.Problems)
+		assert.NotEmpty(t, diagnosis.Version)
+		assert.NotEmpty(t, diagnosis.CipherSuite)
+		assert.Equal(t, []string{"localhost", "127.0.0.1"}, diagnosis.Chain[0].Names)
+	})
+
+	cases := []struct {
+		title   string
+		cert    string
+		key     string
+		problem string
+	}{
+		{"expired client certificate", "client-expired.pem", "client-expired.key", "the client certificate expired"},
+		{"missing client certificate", "", "", "no client certificate configured"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			cfg := ClientConfig{
+				CaCert:  filepath.Join(base, "ca.pem"),
+				Address: srvConfig.BindAddress,
+			}
+			if c.cert != "" {
+				cfg.Cert = filepath.Join(base, c.cert)
+				cfg.Key = filepath.Join(base, c.key)
+			}
+
+			diagnosis, err := Diagnose(cfg)
+			assert.Nil(t, err)
+			assert.Contains(t, strings.Join(diagnosis.Problems, "\n"), c.problem)
+			assert.Contains(t, strings.Join(diagnosis.Problems, "\n"), "the server rejected the client certificate")
+		})
+	}
+
+	t.Run("unreachable server", func(t *testing.T) {
+		_, err := Diagnose(ClientConfig{
+			CaCert:  filepath.Join(base, "ca.pem"),
+			Address: fmt.Sprintf("localhost:%d", nextFreePort(t, 1025)),
+		})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestExplain(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "taskd"},
+		DNSNames: []string{"taskd.example.com"},
+	}
+
+	msg := explain("server", x509.HostnameError{Certificate: cert, Host: "localhost"}, "localhost", cert)
+	assert.Equal(t, `the server certificate is valid for taskd.example.com, not for "localhost"`, msg)
+
+	cert.DNSNames = nil
+	msg = explain("server", x509.HostnameError{Certificate: cert, Host: "localhost"}, "localhost", cert)
+	assert.Contains(t, msg, `the CN "taskd" is ignored`)
+
+	msg = explain("server", x509.UnknownAuthorityError{Cert: cert}, "localhost", cert)
+	assert.Contains(t, msg, "not signed by the configured CA")
+}
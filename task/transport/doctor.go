@@ -0,0 +1,152 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	"github.com/szaffarano/gotas/pki"
+)
+
+// Finding is a certificate problem along with the steps to fix it.
+type Finding struct {
+	Problem     string `json:"problem"`
+	Remediation string `json:"remediation"`
+}
+
+// CertificatesReport is the result of checking the configured certificates.
+type CertificatesReport struct {
+	CA       []Certificate `json:"ca"`
+	Server   *Certificate  `json:"server,omitempty"`
+	Client   *Certificate  `json:"client,omitempty"`
+	Findings []Finding     `json:"findings"`
+}
+
+// CheckCertificates verifies, without connecting anywhere, the CA and the
+// server certificate and key of the configuration, and the client ones if
+// given: that they can be loaded, the keys match the certificates, they are
+// valid now and signed by the CA, and that the server certificate is valid
+// for the host of the bind address.  Every problem found comes with the
+// steps to fix it.
+func CheckCertificates(cfg TLSConfig, clientCert, clientKey string) CertificatesReport {
+	report := CertificatesReport{CA: make([]Certificate, 0), Findings: make([]Finding, 0)}
+
+	pkiDir := "/path/to/pki"
+	if cfg.CaCert != "" {
+		pkiDir = filepath.Dir(cfg.CaCert)
+	}
+	newCA := fmt.Sprintf("create a new CA with `gotas pki -p %s init` and issue the server and client certificates again", pkiDir)
+
+	var roots *x509.CertPool
+	if cfg.CaCert == "" {
+		report.add("set ca.cert to the CA certificate, or "+newCA, "the CA certificate is not configured")
+	} else if cas, err := pki.ReadCertificates(cfg.CaCert); err != nil {
+		report.add("set ca.cert to the CA certificate, or "+newCA, "the CA certificate can't be read: %v", err)
+	} else {
+		roots = x509.NewCertPool()
+		for _, ca := range cas {
+			report.CA = append(report.CA, summarize(ca))
+			roots.AddCert(ca)
+		}
+		if problem := validity("CA", cas[0]); problem != "" {
+			report.add(newCA, "%s", problem)
+		}
+		if !cas[0].IsCA {
+			report.add("set ca.cert to the certificate that signed the server and client ones",
+				"the CA certificate %q is not a certificate authority", cas[0].Subject.String())
+		}
+	}
+
+	host, _, err := net.SplitHostPort(cfg.BindAddress)
+	if err != nil {
+		report.add("set server to the host and port to listen on, like localhost:53589",
+			"invalid bind address %q: %v", cfg.BindAddress, err)
+	} else if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		// any name can reach the server, the clients must use one in the
+		// certificate
+		host = ""
+	}
+
+	serverHost := host
+	if serverHost == "" {
+		serverHost = "<hostname>"
+	}
+	newServer := fmt.Sprintf("create a new server certificate with `gotas pki -p %s add server -c %s`", pkiDir, serverHost)
+	if cfg.ServerCert == "" || cfg.ServerKey == "" {
+		report.add("set server.cert and server.key, or "+newServer, "the server certificate or key is not configured")
+	} else {
+		report.Server = report.checkPair("server", cfg.ServerCert, cfg.ServerKey, roots, host, newServer)
+	}
+
+	if clientCert != "" || clientKey != "" {
+		newClient := fmt.Sprintf("create a new client certificate with `gotas pki -p %s add client -c <user>`", pkiDir)
+		if clientCert == "" || clientKey == "" {
+			report.add("give both the client certificate and key", "the client certificate or key is missing")
+		} else {
+			report.Client = report.checkPair("client", clientCert, clientKey, roots, "", newClient)
+		}
+	}
+
+	return report
+}
+
+// checkPair checks a server or client certificate and its key, returning the
+// certificate details if it can be read.  The host is only verified if given.
+func (r *CertificatesReport) checkPair(owner, certPath, keyPath string, roots *x509.CertPool, host, remediation string) *Certificate {
+	certs, err := pki.ReadCertificates(certPath)
+	if err != nil {
+		r.add(fmt.Sprintf("set %s.cert to the %s certificate, or %s", owner, owner, remediation),
+			"the %s certificate can't be read: %v", owner, err)
+		return nil
+	}
+	leaf := certs[0]
+	summary := summarize(leaf)
+
+	if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+		r.add(fmt.Sprintf("set %s.key to the key created along with %s, or %s", owner, certPath, remediation),
+			"the %s key doesn't match the certificate or can't be loaded: %v", owner, err)
+	}
+
+	if problem := validity(owner, leaf); problem != "" {
+		r.add(remediation, "%s", problem)
+	}
+
+	usage := x509.ExtKeyUsageServerAuth
+	if owner == "client" {
+		usage = x509.ExtKeyUsageClientAuth
+	}
+	if roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{usage},
+		})
+		var invalid x509.CertificateInvalidError
+		// the expiration is already reported
+		if err != nil && !(errors.As(err, &invalid) && invalid.Reason == x509.Expired) {
+			r.add(remediation, "%s", explain(owner, err, "", leaf))
+		}
+	}
+
+	if host != "" {
+		if err := leaf.VerifyHostname(host); err != nil {
+			r.add(remediation, "%s", explain(owner, err, host, leaf))
+		}
+	}
+
+	return &summary
+}
+
+func (r *CertificatesReport) add(remediation, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{
+		Problem:     fmt.Sprintf(format, args...),
+		Remediation: remediation,
+	})
+}
@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/pki"
+)
+
+func TestCheckCertificates(t *testing.T) {
+	base := filepath.Join("testdata", "certs")
+	certs := func(name string) string {
+		return filepath.Join(base, name)
+	}
+
+	otherCA := filepath.Join(t.TempDir(), "ca.pem")
+	cert, _, err := pki.CreateCA("Other", "other CA")
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(otherCA, cert, 0600))
+
+	valid := TLSConfig{
+		CaCert:      certs("ca.pem"),
+		ServerCert:  certs("server.pem"),
+		ServerKey:   certs("server.key"),
+		BindAddress: "localhost:53589",
+	}
+
+	t.Run("valid certificates", func(t *testing.T) {
+		report := CheckCertificates(valid, certs("client.pem"), certs("client.key"))
+		assert.Empty(t, report.Findings)
+		assert.Len(t, report.CA, 1)
+		assert.Equal(t, []string{"localhost", "127.0.0.1"}, report.Server.Names)
+		assert.NotNil(t, report.Client)
+	})
+
+	t.Run("listening on all interfaces", func(t *testing.T) {
+		cfg := valid
+		cfg.BindAddress = "0.0.0.0:53589"
+		report := CheckCertificates(cfg, "", "")
+		assert.Empty(t, report.Findings)
+		assert.Nil(t, report.Client)
+	})
+
+	cases := []struct {
+		title       string
+		cfg         func(cfg *TLSConfig)
+		clientCert  string
+		clientKey   string
+		problem     string
+		remediation string
+	}{
+		{
+			title:       "host not in the server certificate",
+			cfg:         func(cfg *TLSConfig) { cfg.BindAddress = "taskd.example.com:53589" },
+			problem:     `the server certificate is valid for localhost, 127.0.0.1, not for "taskd.example.com"`,
+			remediation: "add server -c taskd.example.com",
+		},
+		{
+			title:       "server key not matching",
+			cfg:         func(cfg *TLSConfig) { cfg.ServerKey = certs("client.key") },
+			problem:     "the server key doesn't match the certificate",
+			remediation: "set server.key to the key created along with",
+		},
+		{
+			title:       "client certificate used as server one",
+			cfg:         func(cfg *TLSConfig) { cfg.ServerCert, cfg.ServerKey = certs("client.pem"), certs("client.key") },
+			problem:     "the server certificate is not allowed for server authentication",
+			remediation: "add server -c localhost",
+		},
+		{
+			title:       "signed by another CA",
+			cfg:         func(cfg *TLSConfig) { cfg.CaCert = otherCA },
+			problem:     "the server certificate, issued by",
+			remediation: "gotas pki -p " + filepath.Dir(otherCA) + " add server",
+		},
+		{
+			title:       "invalid CA",
+			cfg:         func(cfg *TLSConfig) { cfg.CaCert = certs("ca-invalid.pem") },
+			problem:     "the CA certificate can't be read",
+			remediation: "init",
+		},
+		{
+			title:       "nothing configured",
+			cfg:         func(cfg *TLSConfig) { *cfg = TLSConfig{BindAddress: "localhost:53589"} },
+			problem:     "the server certificate or key is not configured",
+			remediation: "set server.cert and server.key",
+		},
+		{
+			title:       "invalid bind address",
+			cfg:         func(cfg *TLSConfig) { cfg.BindAddress = "localhost" },
+			problem:     `invalid bind address "localhost"`,
+			remediation: "set server to the host and port",
+		},
+		{
+			title:       "expired client certificate",
+			cfg:         func(cfg *TLSConfig) {},
+			clientCert:  certs("client-expired.pem"),
+			clientKey:   certs("client-expired.key"),
+			problem:     "the client certificate expired",
+			remediation: "add client -c <user>",
+		},
+		{
+			title:       "client key missing",
+			cfg:         func(cfg *TLSConfig) {},
+			clientCert:  certs("client.pem"),
+			problem:     "the client certificate or key is missing",
+			remediation: "give both the client certificate and key",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			cfg := valid
+			c.cfg(&cfg)
+
+			report := CheckCertificates(cfg, c.clientCert, c.clientKey)
+
+			var problems, remediations []string
+			for _, finding := range report.Findings {
+				problems = append(problems, finding.Problem)
+				remediations = append(remediations, finding.Remediation)
+			}
+			assert.Contains(t, strings.Join(problems, "\n"), c.problem)
+			assert.Contains(t, strings.Join(remediations, "\n"), c.remediation)
+		})
+	}
+}
@@ -1,20 +1,59 @@
 package transport
 
-import "io"
+import (
+	"io"
+	"time"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// DefaultQueueSize is the number of connections handled concurrently, and the
+// number of them waiting, when no valid "queue.size" is configured.
+const DefaultQueueSize = 10
+
+// DefaultHandshakeTimeout is how long a client can take to complete the TLS
+// handshake, and the HTTPS ones to send the request headers, when no
+// HandshakeTimeout is configured.
+const DefaultHandshakeTimeout = 10 * time.Second
 
 // Server implements the transport to communicate taskd clients with the server
 type Server interface {
 	// NextClient returns a client connection
 	// NextClient() (io.ReadWriteCloser, error)
 
+	// Queue returns the state of the connections queue.
+	Queue() QueueStats
+
 	// Close stops taskd server
 	Close() error
 }
 
-// Handler contains the logic to process an incoming connection
+// QueueStats is the state of the server connections queue: the connections
+// waiting and being handled, and the ones rejected because the queue was
+// full since the server started.
+type QueueStats struct {
+	Waiting  int    `json:"waiting"`
+	Active   int    `json:"active"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// Client is a connection accepted by the server, exposing the identity
+// verified during the handshake.
+type Client interface {
+	io.ReadWriteCloser
+
+	// Peer returns the client identity.
+	Peer() auth.Peer
+}
+
+// Handler contains the logic to process an incoming connection, a Client
+// when it comes from a Server.
 type Handler func(io.ReadWriteCloser)
 
-// NewServer creates a new taskd server working according to the configuration
+// NewServer creates a new taskd server working according to the configuration.
+// At most maxConcurrency connections are handled at the same time, and as many
+// wait in a queue.  When the queue is full the connections are handed to
+// cfg.Busy, or just closed if not set.
 func NewServer(cfg TLSConfig, maxConcurrency int, handler Handler) (Server, error) {
 	return newTLSServer(cfg, maxConcurrency, handler)
 }
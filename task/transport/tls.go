@@ -1,14 +1,21 @@
 package transport
 
 import (
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/szaffarano/gotas/logger"
+	"github.com/szaffarano/gotas/task/auth"
 )
 
 // TLSConfig exposes the configuration needed by the tls transport
@@ -17,47 +24,220 @@ type TLSConfig struct {
 	ServerCert  string
 	ServerKey   string
 	BindAddress string
+
+	// AllowAnyClient accepts client certificates not signed by the CA.
+	AllowAnyClient bool
+	// OptionalClientCert accepts the connections without client certificate,
+	// their peers are anonymous.  The certificates presented are verified
+	// anyway.
+	OptionalClientCert bool
+
+	// MinVersion and MaxVersion bound the TLS versions negotiated, zero
+	// means TLS 1.2 and the newest supported version respectively.
+	MinVersion uint16
+	MaxVersion uint16
+	// CipherSuites are the TLS 1.2 cipher suites accepted, DefaultCipherSuites
+	// if empty.  The TLS 1.3 ones are not configurable.
+	CipherSuites []uint16
+
+	// DisableSessionTickets forces a full handshake on every connection.
+	DisableSessionTickets bool
+	// TicketRotation is how often the session ticket keys are rotated, zero
+	// means the crypto/tls automatic rotation.  The previous key is kept so
+	// the tickets are valid for two rotation periods.
+	TicketRotation time.Duration
+
+	// OnHandshake, if set, is called after every successful handshake.
+	OnHandshake func(duration time.Duration, resumed bool)
+	// HandshakeTimeout is how long a client can take to complete the
+	// handshake, DefaultHandshakeTimeout if zero, so the connections never
+	// completing it don't hold the workers.
+	HandshakeTimeout time.Duration
+	// ReadTimeout is how long the HTTPS servers, like the tunnel, wait for a
+	// whole request, zero means no limit.
+	ReadTimeout time.Duration
+
+	// HostCerts are the certificates presented instead of the default one
+	// when the client requests the host name (SNI).  A "*.example.com" host
+	// matches any direct subdomain.
+	HostCerts map[string]KeyPair
+
+	// Busy, if set, handles the connections rejected because the queue is
+	// full, to let the clients know they should retry.
+	Busy Handler
 }
 
-var log *logger.Logger
+// handshakeTimeout returns the configured handshake timeout, or the default.
+func (cfg TLSConfig) handshakeTimeout() time.Duration {
+	if cfg.HandshakeTimeout > 0 {
+		return cfg.HandshakeTimeout
+	}
+	return DefaultHandshakeTimeout
+}
 
-func init() {
-	log = logger.Log()
+// HTTPServer returns an HTTP server enforcing the handshake and read timeouts,
+// so the clients trickling their requests can't hold the connections.
+func (cfg TLSConfig) HTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.handshakeTimeout(),
+		ReadTimeout:       cfg.ReadTimeout,
+	}
 }
 
-// NewTlsServer creates a new tls-based server
-func newTLSServer(cfg TLSConfig, maxConcurrency int, handlerFunc Handler) (Server, error) {
-	var ca []byte
-	var cert tls.Certificate
-	var err error
+// KeyPair is the location of a certificate and its private key.
+type KeyPair struct {
+	Cert string
+	Key  string
+}
 
-	if ca, err = os.ReadFile(cfg.CaCert); err != nil {
-		return nil, fmt.Errorf("reading root CA file: %v", err)
+// DefaultCipherSuites are the cipher suites recommended by
+// https://ssl-config.mozilla.org/ for "intermediate" systems.
+var DefaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// ParseTLSVersion parses a TLS version, either "1.2" or "1.3".  Older versions
+// are rejected.
+func ParseTLSVersion(value string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "TLS") {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
 	}
+	return 0, fmt.Errorf("either 1.2 or 1.3 expected, got %q", value)
+}
 
-	roots := x509.NewCertPool()
-	if ok := roots.AppendCertsFromPEM(ca); !ok {
-		return nil, fmt.Errorf("reading creating root CA pool: %v", err)
+// ParseCipherSuites parses the cipher suites names, as in
+// tls.CipherSuiteName.  Unknown and insecure suites are rejected, and so are
+// the TLS 1.3 ones because they can't be configured.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]*tls.CipherSuite)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite
+	}
+
+	var suites []uint16
+	for _, name := range names {
+		suite, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("the TLS 1.3 cipher suite %q can't be configured", name)
+		}
+		suites = append(suites, suite.ID)
 	}
+	return suites, nil
+}
 
-	if cert, err = tls.LoadX509KeyPair(cfg.ServerCert, cfg.ServerKey); err != nil {
+// ServerConfig loads the server certificates and returns the tls server
+// configuration, honoring the configured versions and cipher suites.
+func (cfg TLSConfig) ServerConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.ServerCert, cfg.ServerKey)
+	if err != nil {
 		return nil, fmt.Errorf("reading certificate file: %v", err)
 	}
 
-	// base config from https://ssl-config.mozilla.org/ for "intermediate" systems
+	hosts := make(map[string]*tls.Certificate, len(cfg.HostCerts))
+	for host, pair := range cfg.HostCerts {
+		hostCert, err := tls.LoadX509KeyPair(pair.Cert, pair.Key)
+		if err != nil {
+			return nil, fmt.Errorf("reading certificate file of %q: %v", host, err)
+		}
+		hosts[strings.ToLower(host)] = &hostCert
+	}
+
 	tlsCfg := &tls.Config{
 		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   cfg.MaxVersion,
 		Certificates: []tls.Certificate{cert},
-		ClientCAs:    roots,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
-			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
-		},
-		ClientAuth: tls.RequireAndVerifyClientCert,
+		CipherSuites: DefaultCipherSuites,
+	}
+	if cfg.MinVersion != 0 {
+		tlsCfg.MinVersion = cfg.MinVersion
+	}
+	if len(cfg.CipherSuites) > 0 {
+		tlsCfg.CipherSuites = cfg.CipherSuites
+	}
+	tlsCfg.SessionTicketsDisabled = cfg.DisableSessionTickets
+	if len(hosts) > 0 {
+		tlsCfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return hostCertificate(hosts, hello.ServerName), nil
+		}
+	}
+	return tlsCfg, nil
+}
+
+// hostCertificate returns the certificate of the host, trying the exact name
+// first and then the wildcard one.  A nil certificate means the default one.
+func hostCertificate(hosts map[string]*tls.Certificate, name string) *tls.Certificate {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if cert, ok := hosts[name]; ok {
+		return cert
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, ok := hosts["*"+name[i:]]; ok {
+			return cert
+		}
+	}
+	return nil
+}
+
+// clientAuthConfig returns the server configuration requiring the client
+// certificates, signed by the CA unless any client is allowed, unless they're
+// optional.
+func (cfg TLSConfig) clientAuthConfig() (*tls.Config, error) {
+	ca, err := os.ReadFile(cfg.CaCert)
+	if err != nil {
+		return nil, fmt.Errorf("reading root CA file: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	if ok := roots.AppendCertsFromPEM(ca); !ok {
+		return nil, fmt.Errorf("reading creating root CA pool: %v", err)
+	}
+
+	tlsCfg, err := cfg.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg.ClientCAs = roots
+	switch {
+	case cfg.AllowAnyClient && cfg.OptionalClientCert:
+		tlsCfg.ClientAuth = tls.RequestClientCert
+	case cfg.AllowAnyClient:
+		tlsCfg.ClientAuth = tls.RequireAnyClientCert
+	case cfg.OptionalClientCert:
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsCfg, nil
+}
+
+var log *logger.Logger
+
+func init() {
+	log = logger.Log()
+}
+
+// NewTlsServer creates a new tls-based server
+func newTLSServer(cfg TLSConfig, maxConcurrency int, handlerFunc Handler) (Server, error) {
+	if maxConcurrency < 1 {
+		log.Warnf("Invalid queue size %d, using the default (%d)", maxConcurrency, DefaultQueueSize)
+		maxConcurrency = DefaultQueueSize
+	}
+
+	tlsCfg, err := cfg.clientAuthConfig()
+	if err != nil {
+		return nil, err
 	}
 
 	listener, err := tls.Listen("tcp", cfg.BindAddress, tlsCfg)
@@ -69,23 +249,64 @@ func newTLSServer(cfg TLSConfig, maxConcurrency int, handlerFunc Handler) (Serve
 
 	server.listener = listener
 	server.quit = make(chan interface{}, 1)
+	server.done = make(chan struct{})
 	server.wg.Add(1)
 	server.handler = handlerFunc
+	server.busy = cfg.Busy
+	server.onHandshake = cfg.OnHandshake
+	server.handshakeTimeout = cfg.handshakeTimeout()
+	server.queue = make(chan net.Conn, maxConcurrency)
+	// rejecting is cheap, but still bounded
+	server.rejecting = make(chan struct{}, maxConcurrency)
+
+	if cfg.TicketRotation > 0 && !cfg.DisableSessionTickets {
+		keys, err := rotateTicketKeys(tlsCfg, nil)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		go server.rotateTickets(tlsCfg, keys, cfg.TicketRotation)
+	}
 
-	go server.serve(maxConcurrency)
+	for i := 0; i < maxConcurrency; i++ {
+		server.wg.Add(1)
+		go server.work()
+	}
+	go server.serve()
 
 	return &server, nil
 }
 
 type tlsServer struct {
-	listener net.Listener
-	quit     chan interface{}
-	wg       sync.WaitGroup
-	handler  Handler
+	// accessed atomically, first to keep them aligned in 32 bits platforms
+	active   int64
+	rejected uint64
+
+	listener    net.Listener
+	quit        chan interface{}
+	done        chan struct{}
+	wg          sync.WaitGroup
+	handler     Handler
+	busy        Handler
+	onHandshake func(time.Duration, bool)
+
+	handshakeTimeout time.Duration
+
+	queue     chan net.Conn
+	rejecting chan struct{}
+}
+
+func (s *tlsServer) Queue() QueueStats {
+	return QueueStats{
+		Waiting:  len(s.queue),
+		Active:   int(atomic.LoadInt64(&s.active)),
+		Rejected: atomic.LoadUint64(&s.rejected),
+	}
 }
 
 func (s *tlsServer) Close() error {
 	defer close(s.quit)
+	close(s.done)
 
 	s.quit <- true
 
@@ -97,10 +318,12 @@ func (s *tlsServer) Close() error {
 	return err
 }
 
-func (s *tlsServer) serve(maxConcurrency int) {
+// serve accepts the connections, queueing them for the workers.  The accept
+// loop never blocks, when the queue is full the connection is rejected right
+// away instead of waiting in the listener backlog.
+func (s *tlsServer) serve() {
 	defer s.wg.Done()
-
-	concurrency := make(chan interface{}, maxConcurrency)
+	defer close(s.queue)
 
 	for {
 		conn, err := s.listener.Accept()
@@ -110,17 +333,153 @@ func (s *tlsServer) serve(maxConcurrency int) {
 				return
 			default:
 				log.Errorf("error receiving connection: %v", err)
+				continue
 			}
 		}
-		s.wg.Add(1)
-		concurrency <- 1
-		go func() {
-			defer func() {
-				<-concurrency
-				s.wg.Done()
-			}()
-
-			s.handler(conn)
+
+		select {
+		case s.queue <- conn:
+		default:
+			s.reject(conn)
+		}
+	}
+}
+
+// work handles the queued connections until the queue is closed.
+func (s *tlsServer) work() {
+	defer s.wg.Done()
+
+	for conn := range s.queue {
+		select {
+		case <-s.done:
+			// closing, drop the connections still waiting
+			conn.Close()
+			continue
+		default:
+		}
+
+		atomic.AddInt64(&s.active, 1)
+		s.handle(conn, s.handler)
+		atomic.AddInt64(&s.active, -1)
+	}
+}
+
+// reject hands the connection to the busy handler in background, or closes it
+// if there is none or too many connections are being rejected already.
+func (s *tlsServer) reject(conn net.Conn) {
+	atomic.AddUint64(&s.rejected, 1)
+	log.Warnf("Queue full, rejecting connection from %v", conn.RemoteAddr())
+
+	if s.busy == nil {
+		conn.Close()
+		return
+	}
+
+	select {
+	case s.rejecting <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			<-s.rejecting
+			s.wg.Done()
 		}()
+		s.handle(conn, s.busy)
+	}()
+}
+
+// handle completes the handshake and runs the handler.
+func (s *tlsServer) handle(conn net.Conn, handler Handler) {
+	defer func() {
+		// a failing connection must not bring the whole server down
+		if r := recover(); r != nil {
+			log.Errorf("Recovered from panic handling connection from %v: %v\n%s", conn.RemoteAddr(), r, debug.Stack())
+			conn.Close()
+		}
+	}()
+
+	if !s.handshake(conn.(*tls.Conn)) {
+		conn.Close()
+		return
+	}
+
+	handler(tlsClient{conn.(*tls.Conn)})
+}
+
+// tlsClient is a Client on top of a tls connection.
+type tlsClient struct {
+	*tls.Conn
+}
+
+// Peer returns the identity presented during the handshake.
+func (c tlsClient) Peer() auth.Peer {
+	state := c.ConnectionState()
+	return auth.Peer{
+		Address:      c.RemoteAddr().String(),
+		ServerName:   state.ServerName,
+		Certificates: state.PeerCertificates,
+		Verified:     len(state.VerifiedChains) > 0,
+		Anonymous:    len(state.PeerCertificates) == 0,
+	}
+}
+
+// handshake completes the tls handshake before handing the connection over,
+// measuring how long it takes.
+func (s *tlsServer) handshake(tlsConn *tls.Conn) bool {
+	start := time.Now()
+	if err := tlsConn.SetDeadline(start.Add(s.handshakeTimeout)); err != nil {
+		log.Debugf("Error setting the handshake timeout of %v: %v", tlsConn.RemoteAddr(), err)
+		return false
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		log.Debugf("Handshake with %v failed: %v", tlsConn.RemoteAddr(), err)
+		return false
+	}
+	if err := tlsConn.SetDeadline(time.Time{}); err != nil {
+		log.Debugf("Error clearing the handshake timeout of %v: %v", tlsConn.RemoteAddr(), err)
+		return false
+	}
+	if s.onHandshake != nil {
+		s.onHandshake(time.Since(start), tlsConn.ConnectionState().DidResume)
+	}
+	return true
+}
+
+// rotateTickets replaces the session ticket keys every interval until the
+// server is closed.
+func (s *tlsServer) rotateTickets(cfg *tls.Config, keys [][32]byte, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			var err error
+			if keys, err = rotateTicketKeys(cfg, keys); err != nil {
+				log.Errorf("Error rotating the session ticket keys: %v", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// rotateTicketKeys generates a new session ticket key, keeping the previous
+// one to decrypt the tickets already issued.
+func rotateTicketKeys(cfg *tls.Config, keys [][32]byte) ([][32]byte, error) {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return keys, fmt.Errorf("generating session ticket key: %v", err)
+	}
+
+	keys = append([][32]byte{key}, keys...)
+	if len(keys) > 2 {
+		keys = keys[:2]
 	}
+	cfg.SetSessionTicketKeys(keys)
+	return keys, nil
 }
@@ -3,6 +3,7 @@ package transport
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -149,6 +150,91 @@ func TestMaxConcurrency(t *testing.T) {
 
 }
 
+func TestDefaultQueueSize(t *testing.T) {
+	base := filepath.Join("testdata", "certs")
+	srvConfig := TLSConfig{
+		CaCert:      filepath.Join(base, "ca.pem"),
+		ServerCert:  filepath.Join(base, "server.pem"),
+		ServerKey:   filepath.Join(base, "server.key"),
+		BindAddress: fmt.Sprintf("localhost:%d", nextFreePort(t, 1025)),
+	}
+	clientCfg := newTLSConfig(t, "client.conf")
+
+	ack := make(chan interface{})
+	handler := func(client io.ReadWriteCloser) {
+		defer client.Close()
+
+		buf := make([]byte, 10)
+		_, err := client.Read(buf)
+		assert.Nil(t, err)
+		ack <- 1
+	}
+
+	// an unbuffered concurrency channel would block the server forever
+	srv, err := NewServer(srvConfig, 0, handler)
+	assert.Nil(t, err)
+	defer srv.Close()
+
+	client, err := tls.Dial("tcp", srvConfig.BindAddress, clientCfg)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	defer client.Close()
+
+	_, err = client.Write([]byte("ping"))
+	assert.Nil(t, err)
+
+	select {
+	case <-ack:
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "connection not handled")
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	base := filepath.Join("testdata", "certs")
+	srvConfig := TLSConfig{
+		CaCert:      filepath.Join(base, "ca.pem"),
+		ServerCert:  filepath.Join(base, "server.pem"),
+		ServerKey:   filepath.Join(base, "server.key"),
+		BindAddress: fmt.Sprintf("localhost:%d", nextFreePort(t, 1025)),
+	}
+	clientCfg := newTLSConfig(t, "client.conf")
+
+	ack := make(chan string)
+	handler := func(client io.ReadWriteCloser) {
+		buf := make([]byte, 10)
+		size, err := client.Read(buf)
+		assert.Nil(t, err)
+		if string(buf[:size]) == "panic" {
+			panic("crafted panic")
+		}
+		ack <- string(buf[:size])
+		client.Close()
+	}
+
+	srv, err := NewServer(srvConfig, 1, handler)
+	assert.Nil(t, err)
+	defer srv.Close()
+
+	for _, msg := range []string{"panic", "ping"} {
+		client, err := tls.Dial("tcp", srvConfig.BindAddress, clientCfg)
+		if err != nil {
+			assert.FailNow(t, err.Error())
+		}
+		_, err = client.Write([]byte(msg))
+		assert.Nil(t, err)
+		defer client.Close()
+	}
+
+	select {
+	case received := <-ack:
+		assert.Equal(t, "ping", received)
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "server stopped handling connections after a panic")
+	}
+}
+
 func newTaskdClientServer(t *testing.T, clCfgFile string) (net.Conn, io.ReadWriteCloser, func()) {
 	t.Helper()
 
@@ -243,3 +329,325 @@ func newTLSConfig(t *testing.T, conf string) *tls.Config {
 		RootCAs:      caCertPool,
 	}
 }
+
+func TestTLSVersions(t *testing.T) {
+	base := filepath.Join("testdata", "certs")
+	srvConfig := TLSConfig{
+		CaCert:      filepath.Join(base, "ca.pem"),
+		ServerCert:  filepath.Join(base, "server.pem"),
+		ServerKey:   filepath.Join(base, "server.key"),
+		BindAddress: fmt.Sprintf("localhost:%d", nextFreePort(t, 1025)),
+		MinVersion:  tls.VersionTLS13,
+	}
+
+	srv, err := NewServer(srvConfig, 1, func(client io.ReadWriteCloser) {
+		client.Close()
+	})
+	assert.Nil(t, err)
+	defer srv.Close()
+
+	t.Run("tls 1.3 accepted", func(t *testing.T) {
+		client, err := tls.Dial("tcp", srvConfig.BindAddress, newTLSConfig(t, "client.conf"))
+		if assert.Nil(t, err) {
+			assert.Equal(t, uint16(tls.VersionTLS13), client.ConnectionState().Version)
+			client.Close()
+		}
+	})
+
+	t.Run("tls 1.2 rejected", func(t *testing.T) {
+		clientCfg := newTLSConfig(t, "client.conf")
+		clientCfg.MaxVersion = tls.VersionTLS12
+
+		_, err := tls.Dial("tcp", srvConfig.BindAddress, clientCfg)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestParseTLSSettings(t *testing.T) {
+	for value, expected := range map[string]uint16{"1.2": tls.VersionTLS12, "TLS1.3": tls.VersionTLS13} {
+		version, err := ParseTLSVersion(value)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, version)
+	}
+	_, err := ParseTLSVersion("1.0")
+	assert.NotNil(t, err)
+
+	suites, err := ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}, suites)
+
+	for _, name := range []string{"TLS_UNKNOWN", "TLS_RSA_WITH_RC4_128_SHA", "TLS_AES_128_GCM_SHA256"} {
+		_, err := ParseCipherSuites([]string{name})
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestSessionResumption(t *testing.T) {
+	base := filepath.Join("testdata", "certs")
+
+	cases := []struct {
+		title    string
+		disabled bool
+		rotation time.Duration
+		resumed  bool
+	}{
+		{"resumed", false, 0, true},
+		{"resumed with rotated keys", false, time.Hour, true},
+		{"tickets disabled", true, 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			handshakes := make(chan bool, 2)
+			srvConfig := TLSConfig{
+				CaCert:                filepath.Join(base, "ca.pem"),
+				ServerCert:            filepath.Join(base, "server.pem"),
+				ServerKey:             filepath.Join(base, "server.key"),
+				BindAddress:           fmt.Sprintf("localhost:%d", nextFreePort(t, 1025)),
+				DisableSessionTickets: c.disabled,
+				TicketRotation:        c.rotation,
+				OnHandshake: func(_ time.Duration, resumed bool) {
+					handshakes <- resumed
+				},
+			}
+
+			srv, err := NewServer(srvConfig, 1, func(client io.ReadWriteCloser) {
+				defer client.Close()
+				_, _ = client.Write([]byte("ack"))
+			})
+			assert.Nil(t, err)
+			defer srv.Close()
+
+			clientCfg := newTLSConfig(t, "client.conf")
+			clientCfg.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+			for i := 0; i < 2; i++ {
+				client, err := tls.Dial("tcp", srvConfig.BindAddress, clientCfg)
+				if !assert.Nil(t, err) {
+					return
+				}
+				// the tls 1.3 tickets arrive after the handshake
+				_, _ = io.ReadAll(client)
+				client.Close()
+			}
+
+			assert.False(t, <-handshakes)
+			assert.Equal(t, c.resumed, <-handshakes)
+		})
+	}
+}
+
+func TestClientPeer(t *testing.T) {
+	base := filepath.Join("testdata", "certs")
+	srvConfig := TLSConfig{
+		CaCert:      filepath.Join(base, "ca.pem"),
+		ServerCert:  filepath.Join(base, "server.pem"),
+		ServerKey:   filepath.Join(base, "server.key"),
+		BindAddress: fmt.Sprintf("localhost:%d", nextFreePort(t, 1025)),
+	}
+
+	peers := make(chan Client, 1)
+	srv, err := NewServer(srvConfig, 1, func(client io.ReadWriteCloser) {
+		defer client.Close()
+		peers <- client.(Client)
+	})
+	assert.Nil(t, err)
+	defer srv.Close()
+
+	clientCfg := newTLSConfig(t, "client.conf")
+	clientCfg.ServerName = "localhost"
+	client, err := tls.Dial("tcp", srvConfig.BindAddress, clientCfg)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer client.Close()
+
+	select {
+	case c := <-peers:
+		peer := c.Peer()
+		assert.Equal(t, "localhost", peer.CommonName())
+		assert.True(t, peer.Verified)
+		assert.Equal(t, "localhost", peer.ServerName)
+		assert.Equal(t, client.LocalAddr().String(), peer.Address)
+	case <-time.After(time.Second):
+		assert.Fail(t, "connection not handled")
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	base := filepath.Join("testdata", "certs")
+	busy := make(chan struct{}, 1)
+	srvConfig := TLSConfig{
+		CaCert:      filepath.Join(base, "ca.pem"),
+		ServerCert:  filepath.Join(base, "server.pem"),
+		ServerKey:   filepath.Join(base, "server.key"),
+		BindAddress: fmt.Sprintf("localhost:%d", nextFreePort(t, 1025)),
+		Busy: func(client io.ReadWriteCloser) {
+			defer client.Close()
+			busy <- struct{}{}
+		},
+	}
+
+	release := make(chan struct{})
+	handling := make(chan struct{}, 2)
+	srv, err := NewServer(srvConfig, 1, func(client io.ReadWriteCloser) {
+		defer client.Close()
+		handling <- struct{}{}
+		<-release
+	})
+	assert.Nil(t, err)
+	defer srv.Close()
+
+	clientCfg := newTLSConfig(t, "client.conf")
+	dial := func() {
+		client, err := tls.Dial("tcp", srvConfig.BindAddress, clientCfg)
+		if assert.Nil(t, err) {
+			defer client.Close()
+			_ = client.Handshake()
+		}
+	}
+
+	// one connection handled, one waiting and the last one rejected
+	dial()
+	<-handling
+	waiting, err := net.Dial("tcp", srvConfig.BindAddress)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer waiting.Close()
+	dial()
+
+	select {
+	case <-busy:
+	case <-time.After(time.Second):
+		assert.Fail(t, "connection not rejected")
+	}
+	assert.Equal(t, QueueStats{Waiting: 1, Active: 1, Rejected: 1}, srv.Queue())
+
+	// the waiting connection is taken once the first one finishes
+	close(release)
+	assert.Eventually(t, func() bool {
+		return srv.Queue().Waiting == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHostCertificates(t *testing.T) {
+	base := filepath.Join("testdata", "certs")
+	srvConfig := TLSConfig{
+		CaCert:      filepath.Join(base, "ca.pem"),
+		ServerCert:  filepath.Join(base, "server.pem"),
+		ServerKey:   filepath.Join(base, "server.key"),
+		BindAddress: fmt.Sprintf("localhost:%d", nextFreePort(t, 1025)),
+		HostCerts: map[string]KeyPair{
+			"*.fancydomain.io": {filepath.Join(base, "client-bad-host.pem"), filepath.Join(base, "client-bad-host.key")},
+		},
+	}
+
+	srv, err := NewServer(srvConfig, 2, func(client io.ReadWriteCloser) {
+		client.Close()
+	})
+	assert.Nil(t, err)
+	defer srv.Close()
+
+	for host, cn := range map[string]string{"tasks.fancydomain.io": "fancydomain.io", "localhost": "localhost"} {
+		t.Run(host, func(t *testing.T) {
+			clientCfg := newTLSConfig(t, "client.conf")
+			clientCfg.ServerName = host
+			clientCfg.InsecureSkipVerify = true
+
+			client, err := tls.Dial("tcp", srvConfig.BindAddress, clientCfg)
+			if assert.Nil(t, err) {
+				defer client.Close()
+				assert.Equal(t, cn, client.ConnectionState().PeerCertificates[0].Subject.CommonName)
+			}
+		})
+	}
+
+	t.Run("invalid host certificate", func(t *testing.T) {
+		cfg := srvConfig
+		cfg.HostCerts = map[string]KeyPair{"example.com": {"non-existent", "non-existent"}}
+
+		_, err := cfg.ServerConfig()
+		assert.NotNil(t, err)
+	})
+}
+
+func TestOptionalClientCert(t *testing.T) {
+	base := filepath.Join("testdata", "certs")
+	srvConfig := TLSConfig{
+		CaCert:             filepath.Join(base, "ca.pem"),
+		ServerCert:         filepath.Join(base, "server.pem"),
+		ServerKey:          filepath.Join(base, "server.key"),
+		BindAddress:        fmt.Sprintf("localhost:%d", nextFreePort(t, 1025)),
+		OptionalClientCert: true,
+	}
+
+	peers := make(chan Client, 1)
+	srv, err := NewServer(srvConfig, 1, func(client io.ReadWriteCloser) {
+		defer client.Close()
+		peers <- client.(Client)
+	})
+	assert.Nil(t, err)
+	defer srv.Close()
+
+	for _, withCert := range []bool{true, false} {
+		clientCfg := newTLSConfig(t, "client.conf")
+		clientCfg.ServerName = "localhost"
+		if !withCert {
+			clientCfg.Certificates = nil
+		}
+		client, err := tls.Dial("tcp", srvConfig.BindAddress, clientCfg)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		select {
+		case c := <-peers:
+			assert.Equal(t, !withCert, c.Peer().Anonymous)
+		case <-time.After(time.Second):
+			assert.Fail(t, "connection not handled")
+		}
+		client.Close()
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	base := filepath.Join("testdata", "certs")
+	srvConfig := TLSConfig{
+		CaCert:           filepath.Join(base, "ca.pem"),
+		ServerCert:       filepath.Join(base, "server.pem"),
+		ServerKey:        filepath.Join(base, "server.key"),
+		BindAddress:      fmt.Sprintf("localhost:%d", nextFreePort(t, 1025)),
+		HandshakeTimeout: 100 * time.Millisecond,
+	}
+
+	newServers := map[string]func() (Server, error){
+		"taskd": func() (Server, error) {
+			return NewServer(srvConfig, 1, func(client io.ReadWriteCloser) { client.Close() })
+		},
+		"tunnel": func() (Server, error) {
+			return NewTunnelServer(srvConfig, 1, func(client io.ReadWriteCloser) { client.Close() })
+		},
+	}
+
+	for name, newServer := range newServers {
+		t.Run(name, func(t *testing.T) {
+			srv, err := newServer()
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer srv.Close()
+
+			// the client never starts the handshake
+			conn, err := net.Dial("tcp", srvConfig.BindAddress)
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer conn.Close()
+
+			assert.Nil(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+			_, err = conn.Read(make([]byte, 1))
+			var netErr net.Error
+			assert.False(t, errors.As(err, &netErr) && netErr.Timeout(), "connection not closed: %v", err)
+		})
+	}
+}
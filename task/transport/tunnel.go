@@ -0,0 +1,190 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/szaffarano/gotas/task/auth"
+)
+
+// TunnelPath is the path where the taskd protocol is tunneled over HTTPS.
+// The request body carries the framed client messages and the response body
+// the framed server ones, so clients behind firewalls only allowing HTTPS
+// can still sync.
+const TunnelPath = "/taskd"
+
+// NewTunnelServer serves the taskd protocol tunneled over HTTPS, requiring the
+// same client certificates as the tls server.  At most maxConcurrency
+// requests are handled at the same time, further ones are handed to cfg.Busy,
+// or rejected with 503 if not set.
+func NewTunnelServer(cfg TLSConfig, maxConcurrency int, handler Handler) (Server, error) {
+	if maxConcurrency < 1 {
+		maxConcurrency = DefaultQueueSize
+	}
+
+	tlsCfg, err := cfg.clientAuthConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", cfg.BindAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &tunnelServer{
+		handler: handler,
+		busy:    cfg.Busy,
+		slots:   make(chan struct{}, maxConcurrency),
+	}
+	mux := http.NewServeMux()
+	mux.Handle(TunnelPath, s)
+	s.server = cfg.HTTPServer(mux)
+
+	go func() {
+		if err := s.server.Serve(tls.NewListener(listener, tlsCfg)); err != http.ErrServerClosed {
+			log.Errorf("Tunnel server stopped: %v", err)
+		}
+	}()
+
+	return s, nil
+}
+
+type tunnelServer struct {
+	// accessed atomically, first to keep them aligned in 32 bits platforms
+	active   int64
+	rejected uint64
+
+	server  *http.Server
+	handler Handler
+	busy    Handler
+	slots   chan struct{}
+}
+
+func (s *tunnelServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	client := &tunnelClient{request: r, writer: w}
+
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		atomic.AddUint64(&s.rejected, 1)
+		log.Warnf("Too many tunneled requests, rejecting %v", r.RemoteAddr)
+		if s.busy == nil {
+			http.Error(w, "server busy", http.StatusServiceUnavailable)
+			return
+		}
+		s.busy(client)
+		return
+	}
+
+	atomic.AddInt64(&s.active, 1)
+	defer func() {
+		atomic.AddInt64(&s.active, -1)
+		<-s.slots
+	}()
+
+	s.handler(client)
+}
+
+func (s *tunnelServer) Queue() QueueStats {
+	return QueueStats{
+		Active:   int(atomic.LoadInt64(&s.active)),
+		Rejected: atomic.LoadUint64(&s.rejected),
+	}
+}
+
+func (s *tunnelServer) Close() error {
+	return s.server.Close()
+}
+
+// tunnelClient is a Client reading the request body and writing the response
+// one, flushing every write so the messages are streamed.
+type tunnelClient struct {
+	request *http.Request
+	writer  http.ResponseWriter
+}
+
+func (c *tunnelClient) Read(buf []byte) (int, error) {
+	return c.request.Body.Read(buf)
+}
+
+func (c *tunnelClient) Write(buf []byte) (int, error) {
+	n, err := c.writer.Write(buf)
+	if flusher, ok := c.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+// Close is a no-op, the response finishes when the handler returns.
+func (c *tunnelClient) Close() error {
+	return nil
+}
+
+// Peer returns the identity presented during the handshake.
+func (c *tunnelClient) Peer() auth.Peer {
+	peer := auth.Peer{Address: c.request.RemoteAddr, Anonymous: true}
+	if state := c.request.TLS; state != nil {
+		peer.ServerName = state.ServerName
+		peer.Certificates = state.PeerCertificates
+		peer.Verified = len(state.VerifiedChains) > 0
+		peer.Anonymous = len(state.PeerCertificates) == 0
+	}
+	return peer
+}
+
+// tunnelConn is the client side of the tunnel: the messages written are
+// buffered and sent as the request body on the first read, then the response
+// body is read.
+type tunnelConn struct {
+	client *http.Client
+	url    string
+	buf    bytes.Buffer
+	resp   *http.Response
+}
+
+func dialTunnel(url string, tlsCfg *tls.Config) *tunnelConn {
+	return &tunnelConn{
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg}},
+		url:    url,
+	}
+}
+
+func (c *tunnelConn) Write(buf []byte) (int, error) {
+	if c.resp != nil {
+		return 0, fmt.Errorf("tunnel: request already sent")
+	}
+	return c.buf.Write(buf)
+}
+
+func (c *tunnelConn) Read(buf []byte) (int, error) {
+	if c.resp == nil {
+		resp, err := c.client.Post(c.url, "application/octet-stream", &c.buf)
+		if err != nil {
+			return 0, fmt.Errorf("tunnel: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return 0, fmt.Errorf("tunnel: %s", resp.Status)
+		}
+		c.resp = resp
+	}
+	return c.resp.Body.Read(buf)
+}
+
+func (c *tunnelConn) Close() error {
+	if c.resp != nil {
+		return c.resp.Body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTunnel(t *testing.T) {
+	base := filepath.Join("testdata", "certs")
+	busy := make(chan struct{}, 1)
+	srvConfig := TLSConfig{
+		CaCert:      filepath.Join(base, "ca.pem"),
+		ServerCert:  filepath.Join(base, "server.pem"),
+		ServerKey:   filepath.Join(base, "server.key"),
+		BindAddress: fmt.Sprintf("localhost:%d", nextFreePort(t, 1025)),
+		Busy: func(client io.ReadWriteCloser) {
+			busy <- struct{}{}
+		},
+	}
+
+	release := make(chan struct{})
+	srv, err := NewTunnelServer(srvConfig, 1, func(client io.ReadWriteCloser) {
+		defer client.Close()
+
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(client, buf); err != nil {
+			return
+		}
+		if string(buf) == "block" {
+			<-release
+		}
+		_, _ = client.Write([]byte(client.(Client).Peer().CommonName() + ":" + string(buf)))
+	})
+	assert.Nil(t, err)
+	defer srv.Close()
+
+	dial := func(msg string) (string, error) {
+		conn, err := Dial(ClientConfig{
+			CaCert:  filepath.Join(base, "ca.pem"),
+			Cert:    filepath.Join(base, "client.pem"),
+			Key:     filepath.Join(base, "client.key"),
+			Address: "https://" + srvConfig.BindAddress,
+		})
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return "", err
+		}
+		resp, err := io.ReadAll(conn)
+		return string(resp), err
+	}
+
+	t.Run("message tunneled", func(t *testing.T) {
+		resp, err := dial("hello")
+		assert.Nil(t, err)
+		assert.Equal(t, "localhost:hello", resp)
+	})
+
+	t.Run("busy", func(t *testing.T) {
+		go func() {
+			_, _ = dial("block")
+		}()
+		assert.Eventually(t, func() bool {
+			return srv.Queue().Active == 1
+		}, time.Second, 10*time.Millisecond)
+
+		_, _ = dial("hello")
+		select {
+		case <-busy:
+		case <-time.After(time.Second):
+			assert.Fail(t, "request not rejected")
+		}
+		assert.Equal(t, uint64(1), srv.Queue().Rejected)
+
+		close(release)
+	})
+}
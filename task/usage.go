@@ -0,0 +1,87 @@
+package task
+
+import (
+	"sort"
+	"strings"
+)
+
+// growthLayout groups the tasks growth by month.
+const growthLayout = "2006-01"
+
+// Usage summarizes the transactions stored for a user.
+type Usage struct {
+	// Size is the transactions size in bytes.
+	Size int `json:"size"`
+	// Transactions is the number of syncs that stored data, i.e. the sync
+	// keys.
+	Transactions int `json:"transactions"`
+	// Tasks is the number of tasks by their latest status.
+	Tasks map[string]int `json:"tasks"`
+	// Growth is the number of tasks created every month, by their entry
+	// date, sorted by month.
+	Growth []Growth `json:"growth"`
+}
+
+// Growth is the number of tasks created in a month, "YYYY-MM".
+type Growth struct {
+	Month string `json:"month"`
+	Added int    `json:"added"`
+}
+
+// NewUsage summarizes the given transactions.
+func NewUsage(data []string) (Usage, error) {
+	usage := Usage{Size: dataSize(data), Tasks: make(map[string]int), Growth: make([]Growth, 0)}
+
+	for _, line := range data {
+		if line != "" && !strings.HasPrefix(line, "{") && !strings.HasPrefix(line, "[") {
+			usage.Transactions++
+		}
+	}
+
+	tasks, err := LatestTasks(data)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	added := make(map[string]int)
+	for _, t := range tasks {
+		usage.Tasks[t.Get("status")]++
+		if entry := t.GetDate("entry"); !entry.IsZero() {
+			added[entry.Format(growthLayout)]++
+		}
+	}
+	usage.addGrowth(added)
+
+	return usage, nil
+}
+
+// Add accumulates other usage.
+func (u *Usage) Add(other Usage) {
+	u.Size += other.Size
+	u.Transactions += other.Transactions
+	if u.Tasks == nil {
+		u.Tasks = make(map[string]int)
+	}
+	for status, count := range other.Tasks {
+		u.Tasks[status] += count
+	}
+
+	added := make(map[string]int)
+	for _, g := range other.Growth {
+		added[g.Month] += g.Added
+	}
+	u.addGrowth(added)
+}
+
+func (u *Usage) addGrowth(added map[string]int) {
+	for i, g := range u.Growth {
+		if count, ok := added[g.Month]; ok {
+			u.Growth[i].Added += count
+			delete(added, g.Month)
+		}
+	}
+	for month, count := range added {
+		u.Growth = append(u.Growth, Growth{Month: month, Added: count})
+	}
+	sort.Slice(u.Growth, func(i, j int) bool { return u.Growth[i].Month < u.Growth[j].Month })
+}
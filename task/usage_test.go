@@ -0,0 +1,46 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsage(t *testing.T) {
+	data := []string{
+		`{"uuid":"a","status":"pending","entry":"20210115T100000Z","description":"one"}`,
+		`{"uuid":"b","status":"pending","entry":"20210301T100000Z","description":"two"}`,
+		"0f4c4ba0-3c5a-4cd1-a8e4-36b8ba3b7d1e",
+		`{"uuid":"a","status":"completed","entry":"20210115T100000Z","description":"one"}`,
+		"5b1b7ea0-7e8c-4d39-9b04-0b4bd5e2a6a1",
+	}
+
+	usage, err := NewUsage(data)
+	assert.Nil(t, err)
+	assert.Equal(t, dataSize(data), usage.Size)
+	assert.Equal(t, 2, usage.Transactions)
+	assert.Equal(t, map[string]int{"pending": 1, "completed": 1}, usage.Tasks)
+	assert.Equal(t, []Growth{{"2021-01", 1}, {"2021-03", 1}}, usage.Growth)
+
+	t.Run("add", func(t *testing.T) {
+		other, err := NewUsage([]string{
+			`{"uuid":"c","status":"deleted","entry":"20210120T100000Z","description":"three"}`,
+			`{"uuid":"d","status":"pending","entry":"20201231T100000Z","description":"four"}`,
+			"b1a4b0a8-69e3-4f0c-9c4a-3f1f1e3d6c2d",
+		})
+		assert.Nil(t, err)
+
+		var total Usage
+		total.Add(usage)
+		total.Add(other)
+		assert.Equal(t, usage.Size+other.Size, total.Size)
+		assert.Equal(t, 3, total.Transactions)
+		assert.Equal(t, map[string]int{"pending": 2, "completed": 1, "deleted": 1}, total.Tasks)
+		assert.Equal(t, []Growth{{"2020-12", 1}, {"2021-01", 2}, {"2021-03", 1}}, total.Growth)
+	})
+
+	t.Run("invalid task", func(t *testing.T) {
+		_, err := NewUsage([]string{`{"uuid": invalid}`})
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,196 @@
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+// UsersAPIPrefix is the path prefix of the users management API.
+const UsersAPIPrefix = APIPrefix + "admin/"
+
+// UserAdmin manages the users on behalf of an admin, checking its
+// permissions.  The operations not allowed fail with an auth.PermissionError.
+type UserAdmin interface {
+	UsersAs(actor auth.User, orgName string) ([]auth.User, error)
+	AddUserAs(actor auth.User, orgName, userName string) (*auth.User, error)
+	DelUserAs(actor auth.User, orgName, userKey string) error
+	SuspendUserAs(actor auth.User, orgName, userKey string, suspend bool) error
+	SetRoleAs(actor auth.User, orgName, userKey string, role auth.Role) error
+}
+
+// apiUser is a user as sent by the users management API.
+type apiUser struct {
+	Org       string     `json:"org"`
+	Name      string     `json:"name"`
+	Key       string     `json:"key,omitempty"`
+	Role      auth.Role  `json:"role"`
+	Suspended *time.Time `json:"suspended,omitempty"`
+	Deleted   *time.Time `json:"deleted,omitempty"`
+}
+
+// userChanges are the fields a PATCH request can change, the missing ones
+// are kept.
+type userChanges struct {
+	Suspended *bool      `json:"suspended"`
+	Role      *auth.Role `json:"role"`
+}
+
+// UsersHandler returns an HTTP handler to manage the users of the
+// organizations the authenticated user is an admin of:
+//
+//	GET /v1/admin/orgs/<org>/users
+//	POST /v1/admin/orgs/<org>/users
+//	PATCH /v1/admin/orgs/<org>/users/<name>
+//	DELETE /v1/admin/orgs/<org>/users/<name>
+//
+// POST creates the user named in the request body, {"name": "..."}, replying
+// its key, which GET never sends, and PATCH suspends or resumes the user and
+// changes its role, {"suspended": true, "role": "org-admin"}.  The users are
+// addressed by name, the keys are their passwords.  The requests
+// authenticate as the REST API ones, the org admins can only manage the
+// users of their organization and the server admins the ones of every
+// organization.
+func UsersHandler(a auth.Authenticator, users UserAdmin) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(req.URL.Path, UsersAPIPrefix), "/")
+		if !strings.HasPrefix(req.URL.Path, UsersAPIPrefix) || len(parts) < 3 || len(parts) > 4 ||
+			parts[0] != "orgs" || parts[2] != "users" {
+			http.NotFound(w, req)
+			return
+		}
+		orgName := parts[1]
+
+		actor, ok := authenticateBasic(w, req, a)
+		if !ok {
+			return
+		}
+
+		switch {
+		case len(parts) == 3 && req.Method == http.MethodGet:
+			list, err := users.UsersAs(actor, orgName)
+			if err != nil {
+				usersAPIError(w, err)
+				return
+			}
+			out := make([]apiUser, 0, len(list))
+			for _, u := range list {
+				// the keys are the users passwords, only sent when created
+				user := newAPIUser(orgName, u)
+				user.Key = ""
+				out = append(out, user)
+			}
+			replyJSON(w, http.StatusOK, out)
+		case len(parts) == 3 && req.Method == http.MethodPost:
+			var body struct {
+				Name string `json:"name"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, apiBodyLimit)).Decode(&body); err != nil || body.Name == "" {
+				http.Error(w, "user name expected", http.StatusBadRequest)
+				return
+			}
+			user, err := users.AddUserAs(actor, orgName, body.Name)
+			if err != nil {
+				usersAPIError(w, err)
+				return
+			}
+			log.Infof("User %q added to %q by %q through the REST API", user.Name, orgName, actor.Name)
+			replyJSON(w, http.StatusCreated, newAPIUser(orgName, *user))
+		case len(parts) == 4 && req.Method == http.MethodPatch:
+			var changes userChanges
+			if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, apiBodyLimit)).Decode(&changes); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			user, err := getUser(users, actor, orgName, parts[3])
+			if err != nil {
+				usersAPIError(w, err)
+				return
+			}
+			if changes.Role != nil {
+				if err := users.SetRoleAs(actor, orgName, user.Key, *changes.Role); err != nil {
+					usersAPIError(w, err)
+					return
+				}
+			}
+			if changes.Suspended != nil {
+				if err := users.SuspendUserAs(actor, orgName, user.Key, *changes.Suspended); err != nil {
+					usersAPIError(w, err)
+					return
+				}
+			}
+			log.Infof("User %q of %q changed by %q through the REST API", user.Name, orgName, actor.Name)
+			w.WriteHeader(http.StatusNoContent)
+		case len(parts) == 4 && req.Method == http.MethodDelete:
+			user, err := getUser(users, actor, orgName, parts[3])
+			if err != nil {
+				usersAPIError(w, err)
+				return
+			}
+			if err := users.DelUserAs(actor, orgName, user.Key); err != nil {
+				usersAPIError(w, err)
+				return
+			}
+			log.Infof("User %q of %q deleted by %q through the REST API", user.Name, orgName, actor.Name)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// getUser looks up a user of the organization by name, if the actor can
+// manage it.
+func getUser(users UserAdmin, actor auth.User, orgName, userName string) (auth.User, error) {
+	list, err := users.UsersAs(actor, orgName)
+	if err != nil {
+		return auth.User{}, err
+	}
+	for _, u := range list {
+		if u.Name == userName {
+			return u, nil
+		}
+	}
+	return auth.User{}, fmt.Errorf("user %q of %q: %w", userName, orgName, repo.ErrUserNotFound)
+}
+
+func newAPIUser(orgName string, u auth.User) apiUser {
+	user := apiUser{Org: orgName, Name: u.Name, Key: u.Key, Role: u.Role}
+	if !u.Suspended.IsZero() {
+		suspended := u.Suspended
+		user.Suspended = &suspended
+	}
+	if !u.Deleted.IsZero() {
+		deleted := u.Deleted
+		user.Deleted = &deleted
+	}
+	return user
+}
+
+// usersAPIError replies with the error, forbidden if it's a permissions one
+// and not found if the user or organization don't exist.
+func usersAPIError(w http.ResponseWriter, err error) {
+	var permission auth.PermissionError
+	if errors.As(err, &permission) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if errors.Is(err, repo.ErrUserNotFound) || errors.Is(err, repo.ErrOrgNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func replyJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Errorf("Error sending the response: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package task
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/szaffarano/gotas/task/auth"
+	"github.com/szaffarano/gotas/task/repo"
+)
+
+func TestUsersHandler(t *testing.T) {
+	repository := repo.NewMemoryRepository()
+
+	for _, org := range []string{"Public", "Private"} {
+		_, err := repository.NewOrg(org)
+		assert.NoError(t, err)
+	}
+
+	keys := make(map[string]string)
+	for _, u := range []struct {
+		org, name string
+		role      auth.Role
+	}{
+		{"Private", "root", auth.RoleServerAdmin},
+		{"Public", "alice", auth.RoleOrgAdmin},
+		{"Public", "bob", auth.RoleUser},
+		{"Private", "carol", auth.RoleUser},
+	} {
+		user, err := repository.AddUser(u.org, u.name)
+		assert.NoError(t, err)
+		assert.NoError(t, repository.SetRole(u.org, user.Key, u.role))
+		keys[u.name] = user.Key
+	}
+
+	server := httptest.NewServer(UsersHandler(repository, repository))
+	defer server.Close()
+
+	do := func(t *testing.T, method, as, path, body string) (int, string) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, server.URL+UsersAPIPrefix+path, strings.NewReader(body))
+		assert.NoError(t, err)
+		if as != "" {
+			org := "Public"
+			if as == "root" || as == "carol" {
+				org = "Private"
+			}
+			req.SetBasicAuth(org+"/"+as, keys[as])
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		out, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp.StatusCode, string(out)
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		as     string
+		path   string
+		body   string
+		code   int
+	}{
+		{"not authenticated", http.MethodGet, "", "orgs/Public/users", "", http.StatusUnauthorized},
+		{"unknown path", http.MethodGet, "alice", "orgs/Public/groups", "", http.StatusNotFound},
+		{"org admin lists its org", http.MethodGet, "alice", "orgs/Public/users", "", http.StatusOK},
+		{"org admin lists another org", http.MethodGet, "alice", "orgs/Private/users", "", http.StatusForbidden},
+		{"user lists its org", http.MethodGet, "bob", "orgs/Public/users", "", http.StatusForbidden},
+		{"org admin adds a user", http.MethodPost, "alice", "orgs/Public/users", `{"name":"dave"}`, http.StatusCreated},
+		{"user name missing", http.MethodPost, "alice", "orgs/Public/users", `{}`, http.StatusBadRequest},
+		{"org admin adds a user to another org", http.MethodPost, "alice", "orgs/Private/users", `{"name":"dave"}`, http.StatusForbidden},
+		{"org admin grants server admin", http.MethodPatch, "alice", "orgs/Public/users/bob", `{"role":"server-admin"}`, http.StatusForbidden},
+		{"invalid role", http.MethodPatch, "alice", "orgs/Public/users/bob", `{"role":"boss"}`, http.StatusBadRequest},
+		{"org admin suspends a user", http.MethodPatch, "alice", "orgs/Public/users/bob", `{"suspended":true}`, http.StatusNoContent},
+		{"suspended user", http.MethodGet, "bob", "orgs/Public/users", "", http.StatusUnauthorized},
+		{"org admin deletes a user of another org", http.MethodDelete, "alice", "orgs/Private/users/carol", "", http.StatusForbidden},
+		{"unknown user", http.MethodDelete, "alice", "orgs/Public/users/zoe", "", http.StatusNotFound},
+		{"user addressed by key", http.MethodPatch, "alice", "orgs/Public/users/" + keys["bob"], `{"suspended":false}`, http.StatusNotFound},
+		{"server admin deletes a user", http.MethodDelete, "root", "orgs/Private/users/carol", "", http.StatusNoContent},
+		{"server admin grants server admin", http.MethodPatch, "root", "orgs/Public/users/alice", `{"role":"server-admin"}`, http.StatusNoContent},
+		{"method not allowed", http.MethodPut, "root", "orgs/Public/users", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, body := do(t, c.method, c.as, c.path, c.body)
+			assert.Equal(t, c.code, code, body)
+		})
+	}
+
+	t.Run("users listed", func(t *testing.T) {
+		code, body := do(t, http.MethodGet, "root", "orgs/Public/users", "")
+		assert.Equal(t, http.StatusOK, code)
+
+		var users []apiUser
+		assert.NoError(t, json.Unmarshal([]byte(body), &users))
+		roles := make(map[string]auth.Role)
+		for _, u := range users {
+			roles[u.Name] = u.Role
+			assert.Empty(t, u.Key)
+			if u.Name == "bob" {
+				assert.NotNil(t, u.Suspended)
+			}
+		}
+		assert.Equal(t, map[string]auth.Role{"alice": auth.RoleServerAdmin, "bob": auth.RoleUser, "dave": auth.RoleUser}, roles)
+	})
+}
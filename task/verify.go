@@ -0,0 +1,197 @@
+package task
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/szaffarano/gotas/parser"
+)
+
+// The formats a payload record can be detected as.
+const (
+	FormatJSON    = "json"
+	FormatV4      = "v4"
+	FormatLegacy  = "legacy"
+	FormatSyncKey = "sync key"
+	FormatUnknown = "unknown"
+)
+
+// PayloadRecord is the verification result of a payload line.
+type PayloadRecord struct {
+	// Line is the line number in the payload, starting at 1.
+	Line int `json:"line"`
+	// Format is the format the record was detected as.
+	Format string `json:"format"`
+	// Version is the Taskwarrior file format version, if known.
+	Version int `json:"version,omitempty"`
+	// UUID is the task uuid or the sync key.
+	UUID string `json:"uuid,omitempty"`
+	// Column is the position of the error in the line, starting at 1, or
+	// zero if unknown.
+	Column int `json:"column,omitempty"`
+	// Error describes why the record is rejected, if it is.
+	Error string `json:"error,omitempty"`
+}
+
+// PayloadReport is the verification result of a raw client message.
+type PayloadReport struct {
+	// Size is the message size declared in the framing.
+	Size int `json:"size"`
+	// Trailing is the number of captured bytes after the message.
+	Trailing int               `json:"trailing"`
+	Header   map[string]string `json:"header"`
+	Records  []PayloadRecord   `json:"records"`
+}
+
+// Invalid returns the number of rejected records.
+func (r PayloadReport) Invalid() int {
+	invalid := 0
+	for _, record := range r.Records {
+		if record.Error != "" {
+			invalid++
+		}
+	}
+	return invalid
+}
+
+// VerifyPayload parses a raw client message, as captured from the wire with
+// its 4-byte size prefix, and validates every line of its payload the same
+// way the server does, reporting the position of the errors found.  It only
+// returns an error if the message itself can't be parsed.
+func VerifyPayload(raw []byte) (PayloadReport, error) {
+	var report PayloadReport
+
+	if len(raw) < 4 {
+		return report, fmt.Errorf("size prefix expected, got %d bytes", len(raw))
+	}
+	report.Size = int(binary.BigEndian.Uint32(raw[:4]))
+	if report.Size < 4 {
+		return report, fmt.Errorf("invalid message size: %v", report.Size)
+	} else if report.Size > len(raw) {
+		return report, fmt.Errorf("truncated message, %d bytes declared but %d captured", report.Size, len(raw))
+	}
+	report.Trailing = len(raw) - report.Size
+
+	msg, err := NewMessage(string(raw[4:report.Size]))
+	report.Header = msg.Header
+	if err != nil {
+		return report, fmt.Errorf("parsing message: %v", err)
+	}
+	if err := decodePayload(&msg, DefaultOptions().RequestLimit); err != nil {
+		return report, fmt.Errorf("decoding payload: %v", err)
+	}
+
+	report.Records = make([]PayloadRecord, 0)
+	for i, line := range strings.Split(msg.Payload, "\n") {
+		if line != "" {
+			report.Records = append(report.Records, verifyRecord(i+1, line))
+		}
+	}
+
+	return report, nil
+}
+
+func verifyRecord(number int, line string) PayloadRecord {
+	record := PayloadRecord{Line: number, Format: FormatUnknown}
+
+	switch {
+	case strings.HasPrefix(line, "{"):
+		record.Format = FormatJSON
+		if !json.Valid([]byte(line)) {
+			var value interface{}
+			err := json.Unmarshal([]byte(line), &value)
+			var syntax *json.SyntaxError
+			if errors.As(err, &syntax) {
+				record.Column = int(syntax.Offset)
+			}
+			record.Error = err.Error()
+			return record
+		}
+		t, err := NewTask(line)
+		if err != nil {
+			record.Error = err.Error()
+			return record
+		}
+		record.UUID = t.Get("uuid")
+		if _, err := uuid.Parse(record.UUID); err != nil {
+			record.Error = fmt.Sprintf("invalid uuid %q", record.UUID)
+		}
+	case isUUID(line):
+		record.Format = FormatSyncKey
+		record.UUID = line
+	case strings.HasPrefix(line, "["):
+		record.Format, record.Version = FormatV4, 4
+		record.UUID, record.Column, record.Error = verifyV4(line)
+		if record.Error == "" {
+			record.Error = "format 4 records are ignored, only JSON records are synced"
+		} else if version := determineVersion(line); version != 0 && version != 4 {
+			// parseV4 falls back to the legacy formats too
+			return verifyLegacy(record, line, version)
+		}
+	default:
+		if version := determineVersion(line); version != 0 {
+			return verifyLegacy(record, line, version)
+		}
+		record.Error = "unrecognized record"
+	}
+
+	return record
+}
+
+func verifyLegacy(record PayloadRecord, line string, version int) PayloadRecord {
+	record.Format, record.Version = FormatLegacy, version
+	record.UUID, record.Column = "", 0
+	if _, err := parseLegacy(line); err != nil {
+		record.Error = err.Error()
+	}
+	return record
+}
+
+// verifyV4 walks a format 4 record with the same steps used by parseV4,
+// returning the task uuid or the column where the parsing failed.
+func verifyV4(line string) (id string, column int, problem string) {
+	pig := parser.NewPig(line)
+	attributes := new(strings.Builder)
+
+	if !pig.Skip('[') {
+		return "", pig.Cursor() + 1, "'[' expected"
+	}
+	if !pig.GetUntil(']', attributes) || !pig.Skip(']') {
+		return "", pig.Cursor() + 1, "']' expected"
+	}
+	if !pig.Eos() {
+		return "", pig.Cursor() + 1, "unrecognized characters at end of line"
+	}
+
+	// the attributes start after the '['
+	attLine := parser.NewPig(attributes.String())
+	for !attLine.Eos() {
+		name := new(strings.Builder)
+		value := new(strings.Builder)
+		start := attLine.Cursor()
+		if !attLine.GetUntil(':', name) || !attLine.Skip(':') {
+			return id, start + 2, "attribute name expected"
+		}
+		if !attLine.GetQuoted('"', value) {
+			return id, attLine.Cursor() + 2, fmt.Sprintf("quoted value expected for %q", name.String())
+		}
+		if name.String() == "uuid" {
+			id = parser.DecodeValue(value.String())
+		}
+		attLine.Skip(' ')
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return id, 0, fmt.Sprintf("invalid uuid %q", id)
+	}
+	return id, 0, ""
+}
+
+func isUUID(value string) bool {
+	_, err := uuid.Parse(value)
+	return err == nil
+}
@@ -0,0 +1,54 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyPayload(t *testing.T) {
+	raw := frame("type: sync\norg: Public\n\n" +
+		`{"description":"ok","entry":"20211009T112536Z","status":"pending","uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}` + "\n" +
+		`{"description":"broken",,"uuid":"e346004f-6ebb-4507-8f21-0ba2b8f263d8"}` + "\n" +
+		`[description:"old" uuid:"2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b"]` + "\n" +
+		`[description:"old" uuid:2a53a60d]` + "\n" +
+		"\n" +
+		"what is this\n" +
+		"45da7110-1bcc-4318-d33e-12267a774e0f\n")
+
+	report, err := VerifyPayload(append(raw, "garbage"...))
+	assert.NoError(t, err)
+	assert.Equal(t, len(raw), report.Size)
+	assert.Equal(t, 7, report.Trailing)
+	assert.Equal(t, map[string]string{"type": "sync", "org": "Public"}, report.Header)
+	assert.Equal(t, 4, report.Invalid())
+
+	assert.Equal(t, []PayloadRecord{
+		{Line: 1, Format: FormatJSON, UUID: "e346004f-6ebb-4507-8f21-0ba2b8f263d8"},
+		{Line: 2, Format: FormatJSON, Column: 25, Error: "invalid character ',' looking for beginning of object key string"},
+		{Line: 3, Format: FormatV4, Version: 4, UUID: "2a53a60d-3c7c-4d9b-8b6a-bd6e3b3c3f4b", Error: "format 4 records are ignored, only JSON records are synced"},
+		{Line: 4, Format: FormatV4, Version: 4, Column: 25, Error: `quoted value expected for "uuid"`},
+		{Line: 6, Format: FormatUnknown, Error: "unrecognized record"},
+		{Line: 7, Format: FormatSyncKey, UUID: "45da7110-1bcc-4318-d33e-12267a774e0f"},
+	}, report.Records)
+}
+
+func TestVerifyPayloadFraming(t *testing.T) {
+	cases := []struct {
+		title string
+		given []byte
+	}{
+		{"missing prefix", []byte{0, 0}},
+		{"size too small", []byte{0, 0, 0, 2, 'a'}},
+		{"truncated", frame("type: sync\n\n")[:10]},
+		{"missing separator", frame("type: sync\n")},
+		{"invalid utf-8", frame("type: sync\n\n\xff")},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			_, err := VerifyPayload(c.given)
+			assert.Error(t, err)
+		})
+	}
+}